@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/shashimalcse/cronuseo/internal/apikey"
+	"github.com/shashimalcse/cronuseo/internal/check"
+	"github.com/shashimalcse/cronuseo/internal/config"
+	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
+	"github.com/shashimalcse/cronuseo/internal/extauthz"
+	"github.com/shashimalcse/cronuseo/internal/group"
+	"github.com/shashimalcse/cronuseo/internal/health"
+	"github.com/shashimalcse/cronuseo/internal/jit"
+	"github.com/shashimalcse/cronuseo/internal/limits"
+	"github.com/shashimalcse/cronuseo/internal/logger"
+	"github.com/shashimalcse/cronuseo/internal/role"
+	"github.com/shashimalcse/cronuseo/internal/sod"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/token"
+	"github.com/shashimalcse/cronuseo/internal/user"
+	"github.com/shashimalcse/cronuseo/internal/userschema"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"github.com/shashimalcse/cronuseo/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var Version = "1.0.0"
+
+// healthInterval is how often the gRPC health status is updated.
+const healthInterval = 5 * time.Second
+
+// Config flags, see config.NewSource.
+var source = config.NewSource(flag.CommandLine, "./config/local.yml")
+
+func main() {
+
+	flag.Parse()
+
+	// Load configurations.
+	cfg, err := source.Load()
+	if err != nil {
+		log.Fatalf("Error while loading config: %v\n", err)
+	}
+
+	// Set up logger.
+	logger, err := logger.Init(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize logger: %v\n", err)
+	}
+
+	// Mongo client.
+	mongodb, err := db.Init(cfg, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize MongoDB client", zap.Error(err))
+	}
+	// Plaintext API keys and SCIM tokens of older versions are hashed.
+	if migrated, err := apikey.Migrate(context.Background(), mongodb); err != nil {
+		logger.Fatal("Failed to migrate plaintext API keys", zap.Error(err))
+	} else if migrated > 0 {
+		logger.Info("Migrated plaintext API keys.", zap.Int64("organizations", migrated))
+	}
+
+	shutdownTracing, err := telemetry.InitTracing(cfg.Tracing, "cronuseo-check-server", logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize tracing", zap.Error(err))
+	}
+
+	checks := health.New(logger)
+	checks.Add("mongodb", mongodb.Ping)
+	server, err := BuildServer(cfg, logger, mongodb, checks)
+	if err != nil {
+		logger.Fatal("Error while building check server", zap.Error(err))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The gRPC health service reports the dependency checks for the server
+	// and each of its services.
+	healthServer := grpchealth.NewServer()
+	var services []string
+	for service := range server.GetServiceInfo() {
+		services = append(services, service)
+	}
+	healthpb.RegisterHealthServer(server, healthServer)
+	go checks.Watch(ctx, healthServer, healthInterval, services...)
+
+	endpoint := cfg.CheckServer.Endpoint
+	listener, err := net.Listen("tcp", endpoint)
+	if err != nil {
+		logger.Fatal("Error while listening", zap.String("check_server_endpoint", endpoint), zap.Error(err))
+	}
+	var httpServer *http.Server
+	if cfg.CheckServer.MetricsEndpoint != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", telemetry.Handler())
+		mux.Handle("/healthz", checks.Liveness())
+		mux.Handle("/readyz", checks.Readiness())
+		httpServer = &http.Server{Addr: cfg.CheckServer.MetricsEndpoint, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("Error while serving metrics", zap.Error(err))
+			}
+		}()
+		logger.Info("Serving check server metrics and health probes", zap.String("metrics_endpoint", cfg.CheckServer.MetricsEndpoint))
+	}
+	go func() {
+		logger.Info("Starting check server", zap.String("check_server_endpoint", endpoint))
+		if err := server.Serve(listener); err != nil {
+			logger.Fatal("Error while starting check server", zap.Error(err))
+		}
+	}()
+	<-ctx.Done()
+	stop()
+
+	// Fail readiness first, then drain the in-flight calls. Calls still running
+	// after the timeout are cancelled.
+	timeout := cfg.CheckServer.ShutdownTimeout
+	logger.Info("Shutting down check server", zap.Duration("shutdown_timeout", timeout))
+	checks.Shutdown()
+	healthServer.Shutdown()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		logger.Warn("Timed out while draining calls, cancelling the remaining calls")
+		server.Stop()
+	}
+	if httpServer != nil {
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Error while shutting down metrics server", zap.Error(err))
+		}
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("Error while flushing traces", zap.Error(err))
+	}
+	if err := mongodb.Disconnect(shutdownCtx); err != nil {
+		logger.Error("Error while disconnecting MongoDB client", zap.Error(err))
+	}
+	logger.Info("Check server stopped")
+}
+
+// BuildServer builds the gRPC check server with the cronuseo Check service
+// and, when enabled, TLS and the Envoy external authorization service.
+func BuildServer(cfg *config.Config, logger *zap.Logger, mongodb *db.MongoDB, checks *health.Health) (*grpc.Server, error) {
+
+	limitsService := limits.NewService(limits.NewRepository(mongodb), cfg.Limits, logger)
+	checkRepo := check.NewRepository(mongodb)
+	// Subjects of ext_authz requests are provisioned from their verified claims.
+	var provisioner check.Provisioner
+	if cfg.ExtAuthz.Enabled && cfg.ExtAuthz.Subject.Provision {
+		provisioner = newProvisioner(mongodb, limitsService, logger)
+	}
+	// Checks are rate limited per validated API key by the check service.
+	checkService := check.NewService(checkRepo, logger, limitsService, limitsService, provisioner)
+
+	options := []grpc.ServerOption{grpc.ChainUnaryInterceptor(
+		telemetry.UnaryServerInterceptor(),
+		util.UnaryServerInterceptor(),
+	)}
+	var identities *check.ClientIdentities
+	if tlsCfg := cfg.CheckServer.TLS; tlsCfg.Enabled {
+		creds, err := check.ServerCredentials(tlsCfg)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.Creds(creds))
+		if identities, err = check.NewClientIdentities(tlsCfg.Clients); err != nil {
+			return nil, err
+		}
+		logger.Info("Check server TLS enabled", zap.String("client_auth", tlsCfg.ClientAuth), zap.Int("clients", len(tlsCfg.Clients)))
+	} else {
+		logger.Warn("Check server TLS is disabled, API keys are sent in clear text.")
+	}
+
+	server := grpc.NewServer(options...)
+	proto.RegisterCheckServer(server, check.NewGrpcService(checkService, identities, logger))
+
+	if cfg.ExtAuthz.Enabled {
+		routes, err := extauthz.CompileRoutes(cfg.ExtAuthz.Routes)
+		if err != nil {
+			return nil, err
+		}
+		var verifier *token.Verifier
+		if cfg.ExtAuthz.Subject.Header == "" {
+			// Service account tokens are trusted with the shared signing key.
+			signer, err := token.NewSigner(cfg.ServiceAccounts, logger)
+			if err != nil {
+				return nil, err
+			}
+			if verifier, err = token.NewVerifier(cfg.Auth, signer, logger); err != nil {
+				return nil, err
+			}
+			checks.Add("jwks", func(context.Context) error { return verifier.Ready() })
+		}
+		authService := extauthz.NewService(cfg.ExtAuthz, routes, checkService, verifier, logger)
+		authv3.RegisterAuthorizationServer(server, authService)
+		logger.Info("Envoy ext_authz service enabled", zap.Int("routes", len(routes)))
+	}
+	return server, nil
+}
+
+// newProvisioner creates the just-in-time provisioning service. Claims are
+// verified by the ext_authz service, so subject tokens are not accepted.
+func newProvisioner(mongodb *db.MongoDB, limitsService limits.Service, logger *zap.Logger) jit.Service {
+
+	sodService := sod.NewService(sod.NewRepository(mongodb), logger)
+	roleService := role.NewService(role.NewRepository(mongodb), logger, limitsService, sodService)
+	groupService := group.NewService(group.NewRepository(mongodb), logger, limitsService, sodService)
+	userService := user.NewService(user.NewRepository(mongodb), logger, roleService, groupService, limitsService, sodService,
+		userschema.NewService(userschema.NewRepository(mongodb), logger))
+	return jit.NewService(jit.NewRepository(mongodb), userService, nil, logger)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/apikey"
+)
+
+var (
+	apiKeyColumns        = []string{"id", "name", "prefix", "scopes", "expires_at", "last_used_at"}
+	createdAPIKeyColumns = []string{"id", "name", "prefix", "scopes", "expires_at", "key"}
+)
+
+func runAPIKeys(args []string, out io.Writer) error {
+
+	const subcommands = "list, create, rotate, delete"
+	sub, args, err := subcommand(args, subcommands)
+	if err != nil {
+		return err
+	}
+	base := orgManaged("/api-keys")
+	if sub == "list" || sub == "delete" {
+		_, err := crud(sub, args, out, "api-keys", base, apiKeyColumns)
+		return err
+	}
+
+	cmd := newCommand("api-keys "+sub, out)
+	var expiresIn time.Duration
+	switch sub {
+	case "create":
+		var scopes stringList
+		req := apikey.APIKeyCreationRequest{}
+		cmd.fs.StringVar(&req.Name, "name", "", "API key name")
+		cmd.fs.Var(&scopes, "scope", "check, sync or admin, repeatable")
+		cmd.fs.DurationVar(&expiresIn, "expires-in", 0, "lifetime of the key, e.g. 2160h")
+		if err := cmd.parse(args); err != nil {
+			return err
+		}
+		req.Scopes = scopes
+		if expiresIn > 0 {
+			expiresAt := time.Now().Add(expiresIn)
+			req.ExpiresAt = &expiresAt
+		}
+		return cmd.send(http.MethodPost, base, req, createdAPIKeyColumns)
+	case "rotate":
+		req := apikey.APIKeyRotationRequest{}
+		cmd.fs.StringVar(&req.GracePeriod, "grace-period", "", "how long the old key stays valid, e.g. 1h")
+		cmd.fs.DurationVar(&expiresIn, "expires-in", 0, "lifetime of the new key, defaults to the lifetime of the old key")
+		if err := cmd.parse(args, "id"); err != nil {
+			return err
+		}
+		if expiresIn > 0 {
+			expiresAt := time.Now().Add(expiresIn)
+			req.ExpiresAt = &expiresAt
+		}
+		return cmd.send(http.MethodPost, orgManaged("/api-keys/"+cmd.args[0]+"/rotate"), req, createdAPIKeyColumns)
+	default:
+		return fmt.Errorf("unknown subcommand %q, one of: %s", sub, subcommands)
+	}
+}
+
+// orgManaged resolves a path of the organization management API.
+func orgManaged(path string) func(c *client) (string, error) {
+
+	return func(c *client) (string, error) {
+		if c.ctx.Organization == "" {
+			return "", fmt.Errorf("organization is not set, use --org or set it in the context")
+		}
+		return "/api/v1/organizations/" + c.ctx.Organization + path, nil
+	}
+}
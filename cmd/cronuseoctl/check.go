@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/shashimalcse/cronuseo/internal/check"
+	"github.com/shashimalcse/cronuseo/internal/group"
+	"github.com/shashimalcse/cronuseo/internal/role"
+	"github.com/shashimalcse/cronuseo/internal/user"
+)
+
+func runCheck(args []string, out io.Writer) error {
+
+	cmd := newCommand("check", out)
+	if err := cmd.parse(args, "user", "resource", "action"); err != nil {
+		return err
+	}
+	c, err := cmd.opts.client()
+	if err != nil {
+		return err
+	}
+	result, err := checkPermission(c, cmd.args[0], cmd.args[1], cmd.args[2])
+	if err != nil {
+		return err
+	}
+	return cmd.print(result, "allowed")
+}
+
+func checkPermission(c *client, identifier string, resource string, action string) (check.CheckResponse, error) {
+
+	if c.ctx.OrganizationIdentifier == "" {
+		return check.CheckResponse{}, fmt.Errorf("organization identifier is not set, use --org-identifier or set it in the context")
+	}
+	if c.ctx.APIKey == "" {
+		return check.CheckResponse{}, fmt.Errorf("API key is not set, use --api-key or set it in the context")
+	}
+	var result check.CheckResponse
+	req := check.CheckRequest{Identifier: identifier, Resource: resource, Action: action}
+	err := c.do(http.MethodPost, "/api/v1/o/"+c.ctx.OrganizationIdentifier+"/check", req, &result)
+	return result, err
+}
+
+// explanation lists the roles and policies that decide a permission.
+type explanation struct {
+	User     string           `json:"user"`
+	Resource string           `json:"resource"`
+	Action   string           `json:"action"`
+	Roles    []explainedRole  `json:"roles"`
+	Policies []explainedGrant `json:"policies"`
+	// Granted is true when one of the roles grants the permission.
+	Granted bool `json:"granted"`
+	// Allowed is the decision of the check API, which also evaluates
+	// policies. It is only set when an API key is available.
+	Allowed *bool `json:"allowed,omitempty"`
+}
+
+type explainedRole struct {
+	Role   string `json:"role"`
+	Via    string `json:"via"`
+	Grants bool   `json:"grants"`
+}
+
+type explainedGrant struct {
+	Policy string `json:"policy"`
+	Via    string `json:"via"`
+}
+
+func runExplain(args []string, out io.Writer) error {
+
+	cmd := newCommand("explain", out)
+	if err := cmd.parse(args, "user", "resource", "action"); err != nil {
+		return err
+	}
+	c, err := cmd.opts.client()
+	if err != nil {
+		return err
+	}
+	result, err := explain(c, cmd.args[0], cmd.args[1], cmd.args[2])
+	if err != nil {
+		return err
+	}
+	if cmd.opts.output != "table" && cmd.opts.output != "" {
+		return cmd.print(result)
+	}
+
+	fmt.Fprintf(out, "User %s, permission %s:%s\n", result.User, result.Resource, result.Action)
+	if len(result.Roles) == 0 {
+		fmt.Fprintln(out, "  no roles")
+	}
+	for _, r := range result.Roles {
+		verdict := "does not grant"
+		if r.Grants {
+			verdict = "grants"
+		}
+		fmt.Fprintf(out, "  role %s (%s) %s the permission\n", r.Role, r.Via, verdict)
+	}
+	for _, p := range result.Policies {
+		fmt.Fprintf(out, "  policy %s (%s) must be satisfied\n", p.Policy, p.Via)
+	}
+	switch {
+	case result.Allowed != nil:
+		fmt.Fprintf(out, "Decision: allowed=%t\n", *result.Allowed)
+	case result.Granted:
+		fmt.Fprintln(out, "Decision: granted by roles, set an API key to evaluate policies")
+	default:
+		fmt.Fprintln(out, "Decision: denied, no role grants the permission")
+	}
+	return nil
+}
+
+func explain(c *client, identifier string, resource string, action string) (explanation, error) {
+
+	result := explanation{User: identifier, Resource: resource, Action: action,
+		Roles: []explainedRole{}, Policies: []explainedGrant{}}
+
+	usersPath, err := c.orgPath("/users")
+	if err != nil {
+		return result, err
+	}
+	var users []user.User
+	if err := c.do(http.MethodGet, usersPath, nil, &users); err != nil {
+		return result, err
+	}
+	userId := ""
+	for _, u := range users {
+		if u.Identifier == identifier {
+			userId = u.ID.Hex()
+		}
+	}
+	if userId == "" {
+		return result, fmt.Errorf("user %q not found", identifier)
+	}
+	var u user.UserResponse
+	if err := c.do(http.MethodGet, usersPath+"/"+userId, nil, &u); err != nil {
+		return result, err
+	}
+
+	rolesPath, _ := c.orgPath("/roles")
+	addRole := func(id string, name string, via string) error {
+		var r role.RoleResponse
+		if err := c.do(http.MethodGet, rolesPath+"/"+id, nil, &r); err != nil {
+			return err
+		}
+		grants := false
+		for _, permission := range r.Permissions {
+			if permission.Resource == resource && permission.Action == action {
+				grants = true
+			}
+		}
+		result.Granted = result.Granted || grants
+		result.Roles = append(result.Roles, explainedRole{Role: name, Via: via, Grants: grants})
+		return nil
+	}
+	for _, r := range u.Roles {
+		if err := addRole(r.ID.Hex(), r.Identifier, "direct"); err != nil {
+			return result, err
+		}
+	}
+	for _, p := range u.Policies {
+		result.Policies = append(result.Policies, explainedGrant{Policy: p.Identifier, Via: "direct"})
+	}
+
+	groupsPath, _ := c.orgPath("/groups")
+	for _, assigned := range u.Groups {
+		var g group.GroupResponse
+		if err := c.do(http.MethodGet, groupsPath+"/"+assigned.ID.Hex(), nil, &g); err != nil {
+			return result, err
+		}
+		for _, r := range g.Roles {
+			if err := addRole(r.ID.Hex(), r.Identifier, "group "+g.Identifier); err != nil {
+				return result, err
+			}
+		}
+		for _, p := range g.Policies {
+			result.Policies = append(result.Policies, explainedGrant{Policy: p.Identifier, Via: "group " + g.Identifier})
+		}
+	}
+
+	if c.ctx.APIKey != "" && c.ctx.OrganizationIdentifier != "" {
+		decision, err := checkPermission(c, identifier, resource, action)
+		if err != nil {
+			return result, err
+		}
+		result.Allowed = &decision.Allowed
+	}
+	return result, nil
+}
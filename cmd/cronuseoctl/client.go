@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// options are the connection and output flags shared by every command.
+// Flags take precedence over environment variables, which take precedence
+// over the selected context.
+type options struct {
+	configPath             string
+	contextName            string
+	server                 string
+	token                  string
+	apiKey                 string
+	organization           string
+	organizationIdentifier string
+	output                 string
+}
+
+func (o *options) register(fs *flag.FlagSet) {
+
+	fs.StringVar(&o.configPath, "config", defaultConfigPath(), "path to the cronuseoctl config file")
+	fs.StringVar(&o.contextName, "context", "", "context to use instead of the current context")
+	fs.StringVar(&o.server, "server", "", "cronuseo server URL")
+	fs.StringVar(&o.token, "token", "", "bearer token for the management API")
+	fs.StringVar(&o.apiKey, "api-key", "", "organization API key for check and sync")
+	fs.StringVar(&o.organization, "org", "", "organization ID")
+	fs.StringVar(&o.organizationIdentifier, "org-identifier", "", "organization identifier, used by check")
+	fs.StringVar(&o.output, "o", "table", "output format: table, json or yaml")
+}
+
+// resolve merges flags, environment variables and the selected context.
+func (o *options) resolve() (*Context, error) {
+
+	cfg, err := loadConfig(o.configPath)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := cfg.context(o.contextName)
+	if err != nil {
+		return nil, err
+	}
+	ctx := *selected
+	pick := func(value *string, flagValue string, env string) {
+		if flagValue != "" {
+			*value = flagValue
+		} else if envValue := os.Getenv(env); envValue != "" {
+			*value = envValue
+		}
+	}
+	pick(&ctx.Server, o.server, "CRONUSEO_SERVER")
+	pick(&ctx.Token, o.token, "CRONUSEO_TOKEN")
+	pick(&ctx.APIKey, o.apiKey, "CRONUSEO_API_KEY")
+	pick(&ctx.Organization, o.organization, "CRONUSEO_ORG")
+	pick(&ctx.OrganizationIdentifier, o.organizationIdentifier, "CRONUSEO_ORG_IDENTIFIER")
+	if ctx.Server == "" {
+		ctx.Server = "http://localhost:8080"
+	}
+	return &ctx, nil
+}
+
+func (o *options) client() (*client, error) {
+
+	ctx, err := o.resolve()
+	if err != nil {
+		return nil, err
+	}
+	return &client{ctx: ctx, http: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+type client struct {
+	ctx  *Context
+	http *http.Client
+}
+
+// orgPath returns an organization scoped management API path.
+func (c *client) orgPath(path string) (string, error) {
+
+	if c.ctx.Organization == "" {
+		return "", fmt.Errorf("organization is not set, use --org or set it in the context")
+	}
+	return "/api/v1/o/" + c.ctx.Organization + path, nil
+}
+
+// do sends a JSON request and decodes the JSON response into out.
+func (c *client) do(method string, path string, body interface{}, out interface{}) error {
+
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	data, err := c.send(method, path, "application/json", "application/json", payload)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// send sends a raw request and returns the raw response body.
+func (c *client) send(method string, path string, contentType string, accept string, payload []byte) ([]byte, error) {
+
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.ctx.Server, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", accept)
+	if c.ctx.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.ctx.Token)
+	}
+	if c.ctx.APIKey != "" {
+		req.Header.Set("API_KEY", c.ctx.APIKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+			Details []struct {
+				Field   string `json:"field"`
+				Message string `json:"message"`
+			} `json:"details"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			message := apiErr.Message
+			for _, detail := range apiErr.Details {
+				message += "\n  " + detail.Field + ": " + detail.Message
+			}
+			return nil, fmt.Errorf("%s %s: %s (%d)", method, path, message, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return data, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Context holds the connection settings of one cronuseo deployment.
+type Context struct {
+	Name                   string `yaml:"name"`
+	Server                 string `yaml:"server"`
+	Token                  string `yaml:"token,omitempty"`
+	APIKey                 string `yaml:"api_key,omitempty"`
+	Organization           string `yaml:"organization,omitempty"`
+	OrganizationIdentifier string `yaml:"organization_identifier,omitempty"`
+}
+
+// Config is the cronuseoctl configuration file.
+type Config struct {
+	CurrentContext string    `yaml:"current_context"`
+	Contexts       []Context `yaml:"contexts"`
+}
+
+// defaultConfigPath returns $CRONUSEO_CONFIG or ~/.cronuseo/config.yaml.
+func defaultConfigPath() string {
+
+	if path := os.Getenv("CRONUSEO_CONFIG"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".cronuseo.yaml"
+	}
+	return filepath.Join(home, ".cronuseo", "config.yaml")
+}
+
+// loadConfig reads the config file. A missing file is an empty config.
+func loadConfig(path string) (*Config, error) {
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return &cfg, nil
+}
+
+func (c *Config) save(path string) error {
+
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// The file holds credentials, so keep it private.
+	return os.WriteFile(path, data, 0o600)
+}
+
+// context returns the named context, or the current one when name is empty.
+func (c *Config) context(name string) (*Context, error) {
+
+	if name == "" {
+		name = c.CurrentContext
+	}
+	if name == "" {
+		return &Context{}, nil
+	}
+	for i := range c.Contexts {
+		if c.Contexts[i].Name == name {
+			return &c.Contexts[i], nil
+		}
+	}
+	return nil, fmt.Errorf("context %q not found", name)
+}
+
+// setContext adds or replaces a context.
+func (c *Config) setContext(ctx Context) {
+
+	for i := range c.Contexts {
+		if c.Contexts[i].Name == ctx.Name {
+			c.Contexts[i] = ctx
+			return
+		}
+	}
+	c.Contexts = append(c.Contexts, ctx)
+}
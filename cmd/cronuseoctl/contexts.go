@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+func runConfig(args []string, out io.Writer) error {
+
+	const subcommands = "view, get-contexts, use-context, set-context, delete-context"
+	sub, args, err := subcommand(args, subcommands)
+	if err != nil {
+		return err
+	}
+	cmd := newCommand("config "+sub, out)
+	var names []string
+	switch sub {
+	case "view", "get-contexts":
+	case "use-context", "set-context", "delete-context":
+		names = []string{"name"}
+	default:
+		return fmt.Errorf("unknown subcommand %q, one of: %s", sub, subcommands)
+	}
+	if err := cmd.parse(args, names...); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(cmd.opts.configPath)
+	if err != nil {
+		return err
+	}
+
+	switch sub {
+	case "view":
+		data, err := yaml.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	case "get-contexts":
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "CURRENT\tNAME\tSERVER\tORGANIZATION")
+		for _, ctx := range cfg.Contexts {
+			current := ""
+			if ctx.Name == cfg.CurrentContext {
+				current = "*"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", current, ctx.Name, ctx.Server, ctx.Organization)
+		}
+		return tw.Flush()
+	case "use-context":
+		if _, err := cfg.context(cmd.args[0]); err != nil {
+			return err
+		}
+		cfg.CurrentContext = cmd.args[0]
+	case "set-context":
+		// Only the given flags change an existing context.
+		ctx, err := cfg.context(cmd.args[0])
+		if err != nil {
+			ctx = &Context{Name: cmd.args[0]}
+		}
+		updated := *ctx
+		cmd.fs.Visit(func(f *flag.Flag) {
+			value := f.Value.String()
+			switch f.Name {
+			case "server":
+				updated.Server = value
+			case "token":
+				updated.Token = value
+			case "api-key":
+				updated.APIKey = value
+			case "org":
+				updated.Organization = value
+			case "org-identifier":
+				updated.OrganizationIdentifier = value
+			}
+		})
+		cfg.setContext(updated)
+		if cfg.CurrentContext == "" {
+			cfg.CurrentContext = updated.Name
+		}
+	case "delete-context":
+		if _, err := cfg.context(cmd.args[0]); err != nil {
+			return err
+		}
+		contexts := []Context{}
+		for _, ctx := range cfg.Contexts {
+			if ctx.Name != cmd.args[0] {
+				contexts = append(contexts, ctx)
+			}
+		}
+		cfg.Contexts = contexts
+		if cfg.CurrentContext == cmd.args[0] {
+			cfg.CurrentContext = ""
+		}
+	}
+	if err := cfg.save(cmd.opts.configPath); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Updated %s.\n", cmd.opts.configPath)
+	return nil
+}
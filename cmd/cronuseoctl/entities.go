@@ -0,0 +1,522 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/shashimalcse/cronuseo/internal/group"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/policy"
+	"github.com/shashimalcse/cronuseo/internal/resource"
+	"github.com/shashimalcse/cronuseo/internal/role"
+	"github.com/shashimalcse/cronuseo/internal/user"
+)
+
+var (
+	organizationColumns = []string{"id", "identifier", "display_name"}
+	userColumns         = []string{"id", "identifier", "username", "roles", "groups"}
+	roleColumns         = []string{"id", "identifier", "display_name", "permissions"}
+	groupColumns        = []string{"id", "identifier", "display_name", "roles", "users"}
+	resourceColumns     = []string{"id", "identifier", "display_name", "type", "actions"}
+	policyColumns       = []string{"id", "identifier", "display_name", "active_version"}
+)
+
+// crud runs the list, get and delete subcommands shared by every entity and
+// reports whether it handled the subcommand.
+func crud(sub string, args []string, out io.Writer, name string, path func(c *client) (string, error), columns []string) (bool, error) {
+
+	cmd := newCommand(name+" "+sub, out)
+	var names []string
+	switch sub {
+	case "list":
+	case "get", "delete":
+		names = []string{"id"}
+	default:
+		return false, nil
+	}
+	if err := cmd.parse(args, names...); err != nil {
+		return true, err
+	}
+	c, err := cmd.opts.client()
+	if err != nil {
+		return true, err
+	}
+	base, err := path(c)
+	if err != nil {
+		return true, err
+	}
+	switch sub {
+	case "list":
+		var items []map[string]interface{}
+		if err := c.do(http.MethodGet, base, nil, &items); err != nil {
+			return true, err
+		}
+		return true, cmd.print(items, columns...)
+	case "get":
+		var item map[string]interface{}
+		if err := c.do(http.MethodGet, base+"/"+cmd.args[0], nil, &item); err != nil {
+			return true, err
+		}
+		return true, cmd.print(item, columns...)
+	default:
+		if err := c.do(http.MethodDelete, base+"/"+cmd.args[0], nil, nil); err != nil {
+			return true, err
+		}
+		fmt.Fprintf(out, "Deleted %s.\n", cmd.args[0])
+		return true, nil
+	}
+}
+
+// send runs a parsed command that sends body to path and prints the result.
+func (cmd *command) send(method string, path func(c *client) (string, error), body interface{}, columns []string) error {
+
+	c, err := cmd.opts.client()
+	if err != nil {
+		return err
+	}
+	target, err := path(c)
+	if err != nil {
+		return err
+	}
+	var result map[string]interface{}
+	if err := c.do(method, target, body, &result); err != nil {
+		return err
+	}
+	return cmd.print(result, columns...)
+}
+
+func orgScoped(path string) func(c *client) (string, error) {
+
+	return func(c *client) (string, error) {
+		return c.orgPath(path)
+	}
+}
+
+func fixed(path string) func(c *client) (string, error) {
+
+	return func(c *client) (string, error) {
+		return path, nil
+	}
+}
+
+func runOrganizations(args []string, out io.Writer) error {
+
+	const subcommands = "list, get, create, delete, regenerate-key, regenerate-scim-token"
+	sub, args, err := subcommand(args, subcommands)
+	if err != nil {
+		return err
+	}
+	base := "/api/v1/organizations"
+	if handled, err := crud(sub, args, out, "organizations", fixed(base), organizationColumns); handled {
+		return err
+	}
+
+	cmd := newCommand("organizations "+sub, out)
+	switch sub {
+	case "create":
+		var identifier, displayName, admin string
+		cmd.fs.StringVar(&identifier, "identifier", "", "organization identifier")
+		cmd.fs.StringVar(&displayName, "display-name", "", "organization display name")
+		cmd.fs.StringVar(&admin, "admin", "", "identifier of the organization admin user")
+		if err := cmd.parse(args); err != nil {
+			return err
+		}
+		req := map[string]string{"identifier": identifier, "display_name": displayName, "admin_identifier": admin}
+		return cmd.send(http.MethodPost, fixed(base), req, organizationColumns)
+	case "regenerate-key":
+		if err := cmd.parse(args, "id"); err != nil {
+			return err
+		}
+		return cmd.send(http.MethodPost, fixed(base+"/"+cmd.args[0]+"/regenerate-key"), nil, []string{"id", "identifier", "api_key"})
+	case "regenerate-scim-token":
+		if err := cmd.parse(args, "id"); err != nil {
+			return err
+		}
+		return cmd.send(http.MethodPost, fixed(base+"/"+cmd.args[0]+"/regenerate-scim-token"), nil, []string{"id", "identifier", "scim_token"})
+	default:
+		return fmt.Errorf("unknown subcommand %q, one of: %s", sub, subcommands)
+	}
+}
+
+func runUsers(args []string, out io.Writer) error {
+
+	const subcommands = "list, get, create, update, delete, assign, unassign"
+	sub, args, err := subcommand(args, subcommands)
+	if err != nil {
+		return err
+	}
+	if handled, err := crud(sub, args, out, "users", orgScoped("/users"), userColumns); handled {
+		return err
+	}
+
+	cmd := newCommand("users "+sub, out)
+	var props, roles, groups, policies stringList
+	switch sub {
+	case "create":
+		req := user.CreateUserRequest{}
+		cmd.fs.StringVar(&req.Identifier, "identifier", "", "user identifier")
+		cmd.fs.StringVar(&req.Username, "username", "", "user name")
+		cmd.fs.Var(&props, "property", "user property as key=value, repeatable")
+		if err := cmd.parse(args); err != nil {
+			return err
+		}
+		if req.UserProperties, err = properties(props); err != nil {
+			return err
+		}
+		return cmd.send(http.MethodPost, orgScoped("/users"), req, userColumns)
+	case "update":
+		var username string
+		cmd.fs.StringVar(&username, "username", "", "new user name")
+		cmd.fs.Var(&props, "property", "user property as key=value, repeatable")
+		if err := cmd.parse(args, "id"); err != nil {
+			return err
+		}
+		req := user.UpdateUserRequest{}
+		if username != "" {
+			req.Username = &username
+		}
+		if req.UserProperties, err = properties(props); err != nil {
+			return err
+		}
+		return cmd.send(http.MethodPut, orgScoped("/users/"+cmd.args[0]), req, userColumns)
+	case "assign", "unassign":
+		cmd.fs.Var(&roles, "role", "role ID, repeatable")
+		cmd.fs.Var(&groups, "group", "group ID, repeatable")
+		cmd.fs.Var(&policies, "policy", "policy ID, repeatable")
+		if err := cmd.parse(args, "id"); err != nil {
+			return err
+		}
+		roleIds, err := objectIds(roles)
+		if err != nil {
+			return err
+		}
+		groupIds, err := objectIds(groups)
+		if err != nil {
+			return err
+		}
+		policyIds, err := objectIds(policies)
+		if err != nil {
+			return err
+		}
+		req := user.PatchUserRequest{AddedRoles: roleIds, AddedGroups: groupIds, AddedPolicies: policyIds}
+		if sub == "unassign" {
+			req = user.PatchUserRequest{RemovedRoles: roleIds, RemovedGroups: groupIds, RemovedPolicies: policyIds}
+		}
+		return cmd.send(http.MethodPatch, orgScoped("/users/"+cmd.args[0]), req, userColumns)
+	default:
+		return fmt.Errorf("unknown subcommand %q, one of: %s", sub, subcommands)
+	}
+}
+
+func runRoles(args []string, out io.Writer) error {
+
+	const subcommands = "list, get, create, update, delete, patch"
+	sub, args, err := subcommand(args, subcommands)
+	if err != nil {
+		return err
+	}
+	if handled, err := crud(sub, args, out, "roles", orgScoped("/roles"), roleColumns); handled {
+		return err
+	}
+
+	cmd := newCommand("roles "+sub, out)
+	switch sub {
+	case "create":
+		var permissions stringList
+		req := role.CreateRoleRequest{}
+		cmd.fs.StringVar(&req.Identifier, "identifier", "", "role identifier")
+		cmd.fs.StringVar(&req.DisplayName, "display-name", "", "role display name")
+		cmd.fs.Var(&permissions, "permission", "permission as resource:action, repeatable")
+		if err := cmd.parse(args); err != nil {
+			return err
+		}
+		if req.Permissions, err = parsePermissions(permissions); err != nil {
+			return err
+		}
+		return cmd.send(http.MethodPost, orgScoped("/roles"), req, roleColumns)
+	case "update":
+		var displayName string
+		cmd.fs.StringVar(&displayName, "display-name", "", "role display name")
+		if err := cmd.parse(args, "id"); err != nil {
+			return err
+		}
+		return cmd.send(http.MethodPut, orgScoped("/roles/"+cmd.args[0]), role.UpdateRoleRequest{DisplayName: &displayName}, roleColumns)
+	case "patch":
+		var addedPermissions, removedPermissions, addedUsers, removedUsers, addedGroups, removedGroups stringList
+		cmd.fs.Var(&addedPermissions, "add-permission", "permission to add as resource:action, repeatable")
+		cmd.fs.Var(&removedPermissions, "remove-permission", "permission to remove as resource:action, repeatable")
+		cmd.fs.Var(&addedUsers, "add-user", "user ID to add, repeatable")
+		cmd.fs.Var(&removedUsers, "remove-user", "user ID to remove, repeatable")
+		cmd.fs.Var(&addedGroups, "add-group", "group ID to add, repeatable")
+		cmd.fs.Var(&removedGroups, "remove-group", "group ID to remove, repeatable")
+		if err := cmd.parse(args, "id"); err != nil {
+			return err
+		}
+		req := role.PatchRoleRequest{}
+		if req.AddedPermissions, err = parsePermissions(addedPermissions); err != nil {
+			return err
+		}
+		if req.RemovedPermissions, err = parsePermissions(removedPermissions); err != nil {
+			return err
+		}
+		if req.AddedUsers, err = objectIds(addedUsers); err != nil {
+			return err
+		}
+		if req.RemovedUsers, err = objectIds(removedUsers); err != nil {
+			return err
+		}
+		if req.AddedGroups, err = objectIds(addedGroups); err != nil {
+			return err
+		}
+		if req.RemovedGroups, err = objectIds(removedGroups); err != nil {
+			return err
+		}
+		return cmd.send(http.MethodPatch, orgScoped("/roles/"+cmd.args[0]), req, roleColumns)
+	default:
+		return fmt.Errorf("unknown subcommand %q, one of: %s", sub, subcommands)
+	}
+}
+
+func runGroups(args []string, out io.Writer) error {
+
+	const subcommands = "list, get, create, update, delete, patch"
+	sub, args, err := subcommand(args, subcommands)
+	if err != nil {
+		return err
+	}
+	if handled, err := crud(sub, args, out, "groups", orgScoped("/groups"), groupColumns); handled {
+		return err
+	}
+
+	cmd := newCommand("groups "+sub, out)
+	switch sub {
+	case "create":
+		var roles, users, policies stringList
+		req := group.CreateGroupRequest{}
+		cmd.fs.StringVar(&req.Identifier, "identifier", "", "group identifier")
+		cmd.fs.StringVar(&req.DisplayName, "display-name", "", "group display name")
+		cmd.fs.Var(&roles, "role", "role ID, repeatable")
+		cmd.fs.Var(&users, "user", "user ID, repeatable")
+		cmd.fs.Var(&policies, "policy", "policy ID, repeatable")
+		if err := cmd.parse(args); err != nil {
+			return err
+		}
+		if req.Roles, err = objectIds(roles); err != nil {
+			return err
+		}
+		if req.Users, err = objectIds(users); err != nil {
+			return err
+		}
+		if req.Policies, err = objectIds(policies); err != nil {
+			return err
+		}
+		return cmd.send(http.MethodPost, orgScoped("/groups"), req, groupColumns)
+	case "update":
+		var displayName string
+		cmd.fs.StringVar(&displayName, "display-name", "", "group display name")
+		if err := cmd.parse(args, "id"); err != nil {
+			return err
+		}
+		return cmd.send(http.MethodPut, orgScoped("/groups/"+cmd.args[0]), group.UpdateGroupRequest{DisplayName: &displayName}, groupColumns)
+	case "patch":
+		var addedRoles, removedRoles, addedUsers, removedUsers, addedPolicies, removedPolicies stringList
+		cmd.fs.Var(&addedRoles, "add-role", "role ID to add, repeatable")
+		cmd.fs.Var(&removedRoles, "remove-role", "role ID to remove, repeatable")
+		cmd.fs.Var(&addedUsers, "add-user", "user ID to add, repeatable")
+		cmd.fs.Var(&removedUsers, "remove-user", "user ID to remove, repeatable")
+		cmd.fs.Var(&addedPolicies, "add-policy", "policy ID to add, repeatable")
+		cmd.fs.Var(&removedPolicies, "remove-policy", "policy ID to remove, repeatable")
+		if err := cmd.parse(args, "id"); err != nil {
+			return err
+		}
+		req := group.PatchGroupRequest{}
+		if req.AddedRoles, err = objectIds(addedRoles); err != nil {
+			return err
+		}
+		if req.RemovedRoles, err = objectIds(removedRoles); err != nil {
+			return err
+		}
+		if req.AddedUsers, err = objectIds(addedUsers); err != nil {
+			return err
+		}
+		if req.RemovedUsers, err = objectIds(removedUsers); err != nil {
+			return err
+		}
+		if req.AddedPolicies, err = objectIds(addedPolicies); err != nil {
+			return err
+		}
+		if req.RemovedPolicies, err = objectIds(removedPolicies); err != nil {
+			return err
+		}
+		return cmd.send(http.MethodPatch, orgScoped("/groups/"+cmd.args[0]), req, groupColumns)
+	default:
+		return fmt.Errorf("unknown subcommand %q, one of: %s", sub, subcommands)
+	}
+}
+
+func runResources(args []string, out io.Writer) error {
+
+	const subcommands = "list, get, create, update, delete, patch, actions"
+	sub, args, err := subcommand(args, subcommands)
+	if err != nil {
+		return err
+	}
+	if handled, err := crud(sub, args, out, "resources", orgScoped("/resources"), resourceColumns); handled {
+		return err
+	}
+
+	cmd := newCommand("resources "+sub, out)
+	switch sub {
+	case "create":
+		var actions stringList
+		req := resource.CreateResourceRequest{}
+		cmd.fs.StringVar(&req.Identifier, "identifier", "", "resource identifier")
+		cmd.fs.StringVar(&req.DisplayName, "display-name", "", "resource display name")
+		cmd.fs.Var(&actions, "action", "action identifier, repeatable")
+		if err := cmd.parse(args); err != nil {
+			return err
+		}
+		req.Actions = toActions(actions)
+		return cmd.send(http.MethodPost, orgScoped("/resources"), req, resourceColumns)
+	case "update":
+		var displayName string
+		cmd.fs.StringVar(&displayName, "display-name", "", "resource display name")
+		if err := cmd.parse(args, "id"); err != nil {
+			return err
+		}
+		return cmd.send(http.MethodPut, orgScoped("/resources/"+cmd.args[0]), resource.UpdateResourceRequest{DisplayName: &displayName}, resourceColumns)
+	case "patch":
+		var added, removed stringList
+		cmd.fs.Var(&added, "add-action", "action identifier to add, repeatable")
+		cmd.fs.Var(&removed, "remove-action", "action identifier to remove, repeatable")
+		if err := cmd.parse(args, "id"); err != nil {
+			return err
+		}
+		req := resource.PatchResourceRequest{AddedActions: toActions(added), RemovedActions: removed}
+		return cmd.send(http.MethodPatch, orgScoped("/resources/"+cmd.args[0]), req, resourceColumns)
+	case "actions":
+		if err := cmd.parse(args); err != nil {
+			return err
+		}
+		c, err := cmd.opts.client()
+		if err != nil {
+			return err
+		}
+		path, err := c.orgPath("/resources/actions")
+		if err != nil {
+			return err
+		}
+		var actions []resource.Action
+		if err := c.do(http.MethodGet, path, nil, &actions); err != nil {
+			return err
+		}
+		return cmd.print(actions, "resource", "action")
+	default:
+		return fmt.Errorf("unknown subcommand %q, one of: %s", sub, subcommands)
+	}
+}
+
+func runPolicies(args []string, out io.Writer) error {
+
+	const subcommands = "list, get, create, update, delete, add-version, remove-version, activate"
+	sub, args, err := subcommand(args, subcommands)
+	if err != nil {
+		return err
+	}
+	if handled, err := crud(sub, args, out, "policies", orgScoped("/policies"), policyColumns); handled {
+		return err
+	}
+
+	cmd := newCommand("policies "+sub, out)
+	var file string
+	switch sub {
+	case "create":
+		req := policy.CreatePolicyRequest{}
+		cmd.fs.StringVar(&req.Identifier, "identifier", "", "policy identifier")
+		cmd.fs.StringVar(&req.DisplayName, "display-name", "", "policy display name")
+		cmd.fs.StringVar(&req.Version, "version", "", "initial policy version")
+		cmd.fs.StringVar(&file, "file", "", "file with the policy content, - for stdin")
+		if err := cmd.parse(args); err != nil {
+			return err
+		}
+		if req.Policy, err = readInput(file); err != nil {
+			return err
+		}
+		return cmd.send(http.MethodPost, orgScoped("/policies"), req, policyColumns)
+	case "update":
+		var displayName string
+		cmd.fs.StringVar(&displayName, "display-name", "", "policy display name")
+		if err := cmd.parse(args, "id"); err != nil {
+			return err
+		}
+		return cmd.send(http.MethodPut, orgScoped("/policies/"+cmd.args[0]), policy.UpdatePolicyRequest{DisplayName: &displayName}, policyColumns)
+	case "add-version":
+		var version string
+		cmd.fs.StringVar(&version, "version", "", "policy version")
+		cmd.fs.StringVar(&file, "file", "", "file with the policy content, - for stdin")
+		if err := cmd.parse(args, "id"); err != nil {
+			return err
+		}
+		content, err := readInput(file)
+		if err != nil {
+			return err
+		}
+		req := policy.PatchPolicyRequest{AddedPolicies: []mongo_entity.PolicyContent{{Version: version, Policy: content}}}
+		return cmd.send(http.MethodPatch, orgScoped("/policies/"+cmd.args[0]), req, policyColumns)
+	case "remove-version":
+		if err := cmd.parse(args, "id", "version"); err != nil {
+			return err
+		}
+		req := policy.PatchPolicyRequest{RemovedPolicies: []string{cmd.args[1]}}
+		return cmd.send(http.MethodPatch, orgScoped("/policies/"+cmd.args[0]), req, policyColumns)
+	case "activate":
+		if err := cmd.parse(args, "id", "version"); err != nil {
+			return err
+		}
+		req := policy.UpdatePolicyRequest{ActiveVersion: &cmd.args[1]}
+		return cmd.send(http.MethodPut, orgScoped("/policies/"+cmd.args[0]), req, policyColumns)
+	default:
+		return fmt.Errorf("unknown subcommand %q, one of: %s", sub, subcommands)
+	}
+}
+
+func parsePermissions(values []string) ([]mongo_entity.Permission, error) {
+
+	permissions := []mongo_entity.Permission{}
+	for _, value := range values {
+		res, action, ok := strings.Cut(value, ":")
+		if !ok || res == "" || action == "" {
+			return nil, fmt.Errorf("invalid permission %q, expected resource:action", value)
+		}
+		permissions = append(permissions, mongo_entity.Permission{Resource: res, Action: action})
+	}
+	return permissions, nil
+}
+
+func toActions(identifiers []string) []mongo_entity.Action {
+
+	actions := []mongo_entity.Action{}
+	for _, identifier := range identifiers {
+		actions = append(actions, mongo_entity.Action{Identifier: identifier, DisplayName: identifier})
+	}
+	return actions
+}
+
+// readInput reads a file, or stdin when the name is "-".
+func readInput(name string) (string, error) {
+
+	if name == "" {
+		return "", fmt.Errorf("--file is required")
+	}
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	return string(data), err
+}
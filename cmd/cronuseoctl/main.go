@@ -0,0 +1,187 @@
+// Command cronuseoctl administers a cronuseo server from the command line.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var Version = "1.0.0"
+
+const usage = `cronuseoctl administers a cronuseo server.
+
+Usage:
+  cronuseoctl <command> [subcommand] [flags] [args]
+
+Commands:
+  organizations  list, get, create, delete, regenerate-key, regenerate-scim-token
+  api-keys       list, create, rotate, delete
+  users          list, get, create, update, delete, assign, unassign
+  roles          list, get, create, update, delete, patch
+  groups         list, get, create, update, delete, patch
+  resources      list, get, create, update, delete, patch, actions
+  policies       list, get, create, update, delete, add-version, remove-version, activate
+  check          check whether a user is allowed to perform an action
+  explain        show the roles and policies behind a permission decision
+  export         export the organization configuration
+  apply          apply an organization configuration file
+  config         view, get-contexts, use-context, set-context, delete-context
+  version        print the cronuseoctl version
+
+Management commands authenticate with --token, check uses --api-key. Both can
+be stored per context in the config file (default ~/.cronuseo/config.yaml).
+Run 'cronuseoctl <command> -h' for the flags of a command.
+`
+
+func main() {
+
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, out io.Writer) error {
+
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprint(out, usage)
+		return nil
+	}
+	name, args := args[0], args[1:]
+	switch name {
+	case "organizations", "organization", "orgs":
+		return runOrganizations(args, out)
+	case "api-keys", "api-key":
+		return runAPIKeys(args, out)
+	case "users", "user":
+		return runUsers(args, out)
+	case "roles", "role":
+		return runRoles(args, out)
+	case "groups", "group":
+		return runGroups(args, out)
+	case "resources", "resource":
+		return runResources(args, out)
+	case "policies", "policy":
+		return runPolicies(args, out)
+	case "check":
+		return runCheck(args, out)
+	case "explain":
+		return runExplain(args, out)
+	case "export":
+		return runExport(args, out)
+	case "apply":
+		return runApply(args, out)
+	case "config":
+		return runConfig(args, out)
+	case "version":
+		fmt.Fprintln(out, Version)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q, run 'cronuseoctl help' for usage", name)
+	}
+}
+
+// command is a parsed subcommand invocation.
+type command struct {
+	fs   *flag.FlagSet
+	opts options
+	out  io.Writer
+	args []string
+}
+
+func newCommand(name string, out io.Writer) *command {
+
+	c := &command{fs: flag.NewFlagSet("cronuseoctl "+name, flag.ContinueOnError), out: out}
+	c.fs.SetOutput(out)
+	c.opts.register(c.fs)
+	return c
+}
+
+// parse parses flags that may appear before, between or after the positional
+// arguments and checks the number of positional arguments.
+func (c *command) parse(args []string, names ...string) error {
+
+	positional := []string{}
+	for {
+		if err := c.fs.Parse(args); err != nil {
+			return err
+		}
+		args = c.fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if len(positional) != len(names) {
+		if len(names) == 0 {
+			return fmt.Errorf("%s takes no arguments", c.fs.Name())
+		}
+		return fmt.Errorf("usage: %s <%s> [flags]", c.fs.Name(), strings.Join(names, "> <"))
+	}
+	c.args = positional
+	return nil
+}
+
+func (c *command) print(v interface{}, columns ...string) error {
+
+	return printResult(c.out, c.opts.output, v, columns...)
+}
+
+// subcommand splits the subcommand name from its arguments.
+func subcommand(args []string, subcommands string) (string, []string, error) {
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", nil, fmt.Errorf("missing subcommand, one of: %s", subcommands)
+	}
+	return args[0], args[1:], nil
+}
+
+// stringList is a repeatable flag that also accepts comma separated values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func objectIds(ids []string) ([]primitive.ObjectID, error) {
+
+	result := []primitive.ObjectID{}
+	for _, id := range ids {
+		objectId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q", id)
+		}
+		result = append(result, objectId)
+	}
+	return result, nil
+}
+
+// properties parses key=value flags into user properties.
+func properties(values []string) (map[string]interface{}, error) {
+
+	result := map[string]interface{}{}
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid property %q, expected key=value", value)
+		}
+		result[key] = val
+	}
+	return result, nil
+}
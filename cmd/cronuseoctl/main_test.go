@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigContexts(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	var out bytes.Buffer
+	assert.Nil(t, run([]string{"config", "set-context", "dev", "--config", path, "--server", "http://dev:8080", "--org", "abc"}, &out))
+	assert.Nil(t, run([]string{"config", "set-context", "dev", "--config", path, "--token", "secret"}, &out))
+
+	cfg, err := loadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "dev", cfg.CurrentContext)
+	assert.Equal(t, []Context{{Name: "dev", Server: "http://dev:8080", Token: "secret", Organization: "abc"}}, cfg.Contexts)
+
+	assert.NotNil(t, run([]string{"config", "use-context", "prod", "--config", path}, &out))
+}
+
+func TestUsersList(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/o/abc/users", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"id":"1","identifier":"jane","username":"Jane","roles":[{"id":"2","identifier":"admin"}]}]`))
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	args := []string{"users", "list", "--config", filepath.Join(t.TempDir(), "none.yaml"), "--server", server.URL, "--token", "secret", "--org", "abc"}
+	assert.Nil(t, run(args, &out))
+	assert.Equal(t, "ID  IDENTIFIER  USERNAME  ROLES  GROUPS\n1   jane        Jane      admin  \n", out.String())
+
+	out.Reset()
+	assert.Nil(t, run(append(args, "-o", "yaml"), &out))
+	assert.Contains(t, out.String(), "identifier: jane")
+}
+
+func TestErrors(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"Role not found."}`))
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	config := filepath.Join(t.TempDir(), "none.yaml")
+	err := run([]string{"roles", "get", "123", "--config", config, "--server", server.URL, "--org", "abc"}, &out)
+	assert.EqualError(t, err, "GET /api/v1/o/abc/roles/123: Role not found. (404)")
+
+	assert.NotNil(t, run([]string{"roles", "get", "--config", config}, &out))
+	assert.NotNil(t, run([]string{"roles", "get", "123", "--config", config}, &out))
+	assert.NotNil(t, run([]string{"unknown"}, &out))
+}
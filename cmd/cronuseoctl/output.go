@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+// printResult writes v in the requested format. Tables show the given JSON
+// fields as columns, one row per element when v is a list.
+func printResult(w io.Writer, format string, v interface{}, columns ...string) error {
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		generic, err := toGeneric(v)
+		if err != nil {
+			return err
+		}
+		out, err := yaml.Marshal(generic)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	case "table", "":
+		generic, err := toGeneric(v)
+		if err != nil {
+			return err
+		}
+		return printTable(w, generic, columns)
+	default:
+		return fmt.Errorf("unknown output format %q, use table, json or yaml", format)
+	}
+}
+
+// toGeneric round trips v through JSON so structs print with their JSON names.
+func toGeneric(v interface{}) (interface{}, error) {
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return sortedKeys(generic), nil
+}
+
+// sortedKeys converts maps to yaml.MapSlice so YAML output is stable.
+func sortedKeys(v interface{}) interface{} {
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		result := yaml.MapSlice{}
+		for _, key := range keys {
+			result = append(result, yaml.MapItem{Key: key, Value: sortedKeys(value[key])})
+		}
+		return result
+	case []interface{}:
+		for i := range value {
+			value[i] = sortedKeys(value[i])
+		}
+		return value
+	default:
+		return v
+	}
+}
+
+func printTable(w io.Writer, v interface{}, columns []string) error {
+
+	rows, ok := v.([]interface{})
+	if !ok {
+		rows = []interface{}{v}
+	}
+	if len(columns) == 0 {
+		columns = []string{"id", "identifier"}
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, row := range rows {
+		values := []string{}
+		for _, column := range columns {
+			values = append(values, cell(field(row, column)))
+		}
+		fmt.Fprintln(tw, strings.Join(values, "\t"))
+	}
+	return tw.Flush()
+}
+
+func field(row interface{}, name string) interface{} {
+
+	if object, ok := row.(yaml.MapSlice); ok {
+		for _, item := range object {
+			if item.Key == name {
+				return item.Value
+			}
+		}
+	}
+	return nil
+}
+
+// cell renders a table cell. Lists of entities are shown by identifier.
+func cell(v interface{}) string {
+
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []interface{}:
+		items := []string{}
+		for _, item := range value {
+			if identifier := field(item, "identifier"); identifier != nil {
+				items = append(items, cell(identifier))
+			} else if action := field(item, "action"); action != nil {
+				items = append(items, cell(field(item, "resource"))+":"+cell(action))
+			} else {
+				items = append(items, cell(item))
+			}
+		}
+		return strings.Join(items, ",")
+	case yaml.MapSlice:
+		items := []string{}
+		for _, item := range value {
+			items = append(items, fmt.Sprintf("%v=%s", item.Key, cell(item.Value)))
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
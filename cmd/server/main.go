@@ -1,25 +1,46 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	_ "github.com/lib/pq"
 	_ "github.com/shashimalcse/cronuseo/docs"
+	"github.com/shashimalcse/cronuseo/internal/accessrequest"
+	"github.com/shashimalcse/cronuseo/internal/apikey"
+	"github.com/shashimalcse/cronuseo/internal/assignment"
+	"github.com/shashimalcse/cronuseo/internal/audit"
 	"github.com/shashimalcse/cronuseo/internal/check"
 	"github.com/shashimalcse/cronuseo/internal/config"
 	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
+	"github.com/shashimalcse/cronuseo/internal/directory"
 	"github.com/shashimalcse/cronuseo/internal/group"
+	"github.com/shashimalcse/cronuseo/internal/health"
+	"github.com/shashimalcse/cronuseo/internal/jit"
+	"github.com/shashimalcse/cronuseo/internal/limits"
 	"github.com/shashimalcse/cronuseo/internal/logger"
 	mw "github.com/shashimalcse/cronuseo/internal/middleware"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
 	"github.com/shashimalcse/cronuseo/internal/organization"
+	"github.com/shashimalcse/cronuseo/internal/orgconfig"
 	"github.com/shashimalcse/cronuseo/internal/policy"
 	"github.com/shashimalcse/cronuseo/internal/resource"
 	"github.com/shashimalcse/cronuseo/internal/role"
+	"github.com/shashimalcse/cronuseo/internal/scim"
+	"github.com/shashimalcse/cronuseo/internal/serviceaccount"
+	"github.com/shashimalcse/cronuseo/internal/sod"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/token"
 	"github.com/shashimalcse/cronuseo/internal/user"
+	"github.com/shashimalcse/cronuseo/internal/userschema"
+	"github.com/shashimalcse/cronuseo/internal/util"
 	echoSwagger "github.com/swaggo/echo-swagger"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
@@ -27,8 +48,8 @@ import (
 
 var Version = "1.0.0"
 
-// Default config flag.
-var flagConfig = flag.String("config", "./config/local.yml", "path to the config file")
+// Config flags, see config.NewSource.
+var source = config.NewSource(flag.CommandLine, "./config/local.yml")
 
 // @title          Cronuseo API
 // @version        1.0
@@ -49,7 +70,7 @@ func main() {
 	flag.Parse()
 
 	// Load configurations.
-	cfg, err := config.Load(*flagConfig)
+	cfg, err := source.Load()
 	if err != nil {
 		log.Fatalf("Error while loading config: %v\n", err)
 	}
@@ -66,92 +87,273 @@ func main() {
 	if err != nil {
 		logger.Fatal("Failed to initialize MongoDB client", zap.Error(err))
 	}
+	// Plaintext API keys and SCIM tokens of older versions are hashed.
+	if migrated, err := apikey.Migrate(context.Background(), mongodb); err != nil {
+		logger.Fatal("Failed to migrate plaintext API keys", zap.Error(err))
+	} else if migrated > 0 {
+		logger.Info("Migrated plaintext API keys.", zap.Int64("organizations", migrated))
+	}
 
-	logger.Info("Starting server", zap.String("server_endpoint", cfg.Server.Endpoint))
+	shutdownTracing, err := telemetry.InitTracing(cfg.Tracing, "cronuseo", logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize tracing", zap.Error(err))
+	}
 
-	if err := BuildServer(cfg, logger, mongodb).Start(cfg.Server.Endpoint); err != nil {
-		logger.Fatal("Error while starting server", zap.Error(err))
+	checks := health.New(logger)
+	checks.Add("mongodb", mongodb.Ping)
+	permissions, err := mw.NewPermissions(cfg.APIEndpoints)
+	if err != nil {
+		logger.Fatal("Failed to compile endpoint permissions", zap.Error(err))
+	}
+	e, scheduler := BuildServer(cfg, logger, mongodb, checks, permissions)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Endpoint permissions are reloaded on SIGHUP and config file changes,
+	// other settings need a restart.
+	go source.Watch(ctx, logger, func(cfg *config.Config) error {
+		if err := permissions.Reload(cfg.APIEndpoints); err != nil {
+			return err
+		}
+		logger.Info("Reloaded endpoint permissions", zap.Int("endpoints", len(cfg.APIEndpoints)))
+		return nil
+	})
+	// Expired time-bound assignments are removed until shutdown.
+	if cfg.Assignments.SweepInterval > 0 {
+		sweeper := assignment.NewSweeper(mongodb, audit.NewRecorder(logger), logger)
+		go sweeper.Run(ctx, cfg.Assignments.SweepInterval)
+	}
+	// Deleted organizations are purged after the retention period.
+	if cfg.Organizations.PurgeInterval > 0 {
+		purger := organization.NewPurger(organization.NewRepository(mongodb), cfg.Organizations.Retention, logger)
+		go purger.Run(ctx, cfg.Organizations.PurgeInterval)
+	}
+	// Scheduled directory syncs run until shutdown.
+	if cfg.DirectorySync.CheckInterval > 0 {
+		go scheduler.Run(ctx, cfg.DirectorySync.CheckInterval)
+	}
+	go func() {
+		logger.Info("Starting server", zap.String("server_endpoint", cfg.Server.Endpoint))
+		if err := e.Start(cfg.Server.Endpoint); err != nil && err != http.ErrServerClosed {
+			logger.Fatal("Error while starting server", zap.Error(err))
+		}
+	}()
+	<-ctx.Done()
+	stop()
+
+	// Fail readiness first, then drain the in-flight requests.
+	timeout := cfg.Server.ShutdownTimeout
+	logger.Info("Shutting down server", zap.Duration("shutdown_timeout", timeout))
+	checks.Shutdown()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Error while draining requests", zap.Error(err))
 	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("Error while flushing traces", zap.Error(err))
+	}
+	if err := mongodb.Disconnect(shutdownCtx); err != nil {
+		logger.Error("Error while disconnecting MongoDB client", zap.Error(err))
+	}
+	logger.Info("Server stopped")
 }
 
-// BuildServer builds and configures the echo server.
+// BuildServer builds and configures the echo server, and the scheduler of the
+// directory syncs.
 func BuildServer(
 	cfg *config.Config, // Config
 	logger *zap.Logger, // Logger
 	mongodb *db.MongoDB, // MongoDB
-) *echo.Echo {
+	checks *health.Health, // Dependency checks
+	permissions *mw.Permissions, // Endpoint permissions
+) (*echo.Echo, *directory.Scheduler) {
 
 	e := echo.New()
+	e.HTTPErrorHandler = util.ErrorHandler(logger)
 
-	// Middleware setup.
-	setupMiddleware(e, cfg)
+	// Liveness and readiness probes.
+	e.GET("/healthz", echo.WrapHandler(checks.Liveness()))
+	e.GET("/readyz", echo.WrapHandler(checks.Readiness()))
 
 	// API route groups.
 	apiV1 := e.Group("/api/v1")
 
-	requiredPermissions := getRequiredPermissions(cfg.APIEndpoints)
+	limitsService := limits.NewService(limits.NewRepository(mongodb), cfg.Limits, logger)
+	// Apply middleware specific to API routes if needed.
+	signer, err := token.NewSigner(cfg.ServiceAccounts, logger)
+	if err != nil {
+		logger.Fatal("Failed to load service account signing key", zap.Error(err))
+	}
+	verifier, err := token.NewVerifier(cfg.Auth, signer, logger)
+	if err != nil {
+		logger.Fatal("Failed to configure token issuers", zap.Error(err))
+	}
+	checks.Add("jwks", func(context.Context) error { return verifier.Ready() })
+	apiServices := newServices(mongodb, signer, verifier, limitsService, cfg, logger)
+
+	// Middleware setup.
+	setupMiddleware(e, cfg, apiServices.organization)
+
+	// Check subjects are provisioned just in time from their claims.
 	checkRepo := check.NewRepository(mongodb)
-	checkService := check.NewService(checkRepo, logger)
+	checkService := check.NewService(checkRepo, logger, limitsService, limitsService, apiServices.jit)
 	check.RegisterHandlers(apiV1, checkService)
-	// Apply middleware specific to API routes if needed.
-	apiV1.Use(mw.Auth(cfg, logger, requiredPermissions, checkService))
+	publicRoutes := e.Routes()
+	apiV1.Use(mw.Auth(cfg, logger, verifier, permissions, checkService))
+	// Organization scoped routes are rate limited per authenticated subject,
+	// checks per validated API key by the check service.
+	apiV1.Use(limits.RateLimit(limitsService, mw.Principal))
 
 	// Register service handlers.
-	registerServiceHandlers(apiV1, mongodb, cfg, logger)
+	registerRoutes(e, apiV1, apiServices)
 
-	return e
+	// Every admin route must have a permission mapping.
+	if err := permissions.Bind("/api/v1", e.Routes(), publicRoutes); err != nil {
+		logger.Fatal("Failed to bind endpoint permissions", zap.Error(err))
+	}
+
+	return e, directory.NewScheduler(directory.NewRepository(mongodb), apiServices.directory, logger)
 }
 
-func setupMiddleware(e *echo.Echo, cfg *config.Config) {
-	// CORS middleware configuration.
-	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowCredentials: true,
+func setupMiddleware(e *echo.Echo, cfg *config.Config, orgService organization.Service) {
+	// CORS middleware configuration, organizations allow their own origins.
+	e.Use(organization.CORS(middleware.CORSConfig{
+		AllowCredentials: cfg.CORS.AllowCredentials,
 		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", "API_KEY"},
-		AllowOrigins:     []string{"http://localhost:3000"},
-	}))
+		AllowOrigins:     cfg.CORS.AllowOrigins,
+	}, orgService))
+
+	// Request ids of the X-Request-Id header, or generated ones, are sent
+	// back in the header and in error responses.
+	e.Use(middleware.RequestID())
 
 	// Logger middleware.
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
-		Format: "${time_rfc3339}; method=${method}; uri=${uri}; status=${status};\n",
+		Skipper: func(c echo.Context) bool {
+			return c.Path() == "/healthz" || c.Path() == "/readyz"
+		},
+		Format: "${time_rfc3339}; id=${id}; method=${method}; uri=${uri}; status=${status};\n",
 	}))
 
+	// Tracing and request metrics.
+	e.Use(telemetry.Middleware())
+
 	// Swagger endpoint.
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
+
+	// Prometheus metrics endpoint.
+	e.GET("/metrics", echo.WrapHandler(telemetry.Handler()))
 }
 
-func registerServiceHandlers(e *echo.Group, mongodb *db.MongoDB, cfg *config.Config, logger *zap.Logger) {
+// newServices creates the services of the admin API, and the root organization
+// when it does not exist.
+func newServices(mongodb *db.MongoDB, signer *token.Signer, verifier *token.Verifier, limitsService limits.Service, cfg *config.Config, logger *zap.Logger) services {
 	// Initialize repositories.
 	orgRepo := organization.NewRepository(mongodb)
+	apiKeyRepo := apikey.NewRepository(mongodb)
 	userRepo := user.NewRepository(mongodb)
 	resourceRepo := resource.NewRepository(mongodb)
 	roleRepo := role.NewRepository(mongodb)
 	groupRepo := group.NewRepository(mongodb)
 	policyRepo := policy.NewRepository(mongodb)
+	scimRepo := scim.NewRepository(mongodb)
+	orgConfigRepo := orgconfig.NewRepository(mongodb)
+	serviceAccountRepo := serviceaccount.NewRepository(mongodb)
+	accessRequestRepo := accessrequest.NewRepository(mongodb)
+	sodRepo := sod.NewRepository(mongodb)
+	userSchemaRepo := userschema.NewRepository(mongodb)
+	jitRepo := jit.NewRepository(mongodb)
+	directoryRepo := directory.NewRepository(mongodb)
 
 	// Initialize services with repositories.
-	orgService := organization.NewService(orgRepo, logger)
+	orgService := organization.NewService(orgRepo, cfg.APIKeys.RotationGracePeriod, organization.NewTenantDefaults(cfg), limitsService, logger)
+	apiKeyService := apikey.NewService(apiKeyRepo, cfg.APIKeys.RotationGracePeriod, logger)
 	resourceService := resource.NewService(resourceRepo, logger)
-	roleService := role.NewService(roleRepo, logger)
-	userService := user.NewService(userRepo, logger, roleService)
-	groupService := group.NewService(groupRepo, logger)
-	policyService := policy.NewService(policyRepo, logger)
-
-	initializeRootOrganization(orgService, userService, groupService, roleService, resourceService, cfg, logger)
+	sodService := sod.NewService(sodRepo, logger)
+	userSchemaService := userschema.NewService(userSchemaRepo, logger)
+	roleService := role.NewService(roleRepo, logger, limitsService, sodService)
+	groupService := group.NewService(groupRepo, logger, limitsService, sodService)
+	userService := user.NewService(userRepo, logger, roleService, groupService, limitsService, sodService, userSchemaService)
+	policyService := policy.NewService(policyRepo, logger, limitsService, userSchemaService)
+	scimService := scim.NewService(scimRepo, userService, groupService, logger)
+	orgConfigService := orgconfig.NewService(orgConfigRepo, resourceService, roleService, groupService, policyService, logger)
+	serviceAccountService := serviceaccount.NewService(serviceAccountRepo, signer, cfg.APIKeys.RotationGracePeriod, logger)
+	accessRequestService := accessrequest.NewService(accessRequestRepo, userService, accessrequest.NewNotifier(cfg.AccessRequests.Webhook, logger),
+		audit.NewRecorder(logger), cfg.AccessRequests.MaxDuration, logger)
+	jitService := jit.NewService(jitRepo, userService, verifier, logger)
+	directoryService := directory.NewService(directoryRepo, userService, groupService, directory.NewLDAP(cfg.DirectorySync.Timeout), logger)
+
+	initializeRootOrganization(context.Background(), orgService, userService, groupService, roleService, resourceService, cfg, logger)
+
+	return services{
+		organization:   orgService,
+		apiKey:         apiKeyService,
+		user:           userService,
+		resource:       resourceService,
+		role:           roleService,
+		group:          groupService,
+		policy:         policyService,
+		orgConfig:      orgConfigService,
+		scim:           scimService,
+		serviceAccount: serviceAccountService,
+		limits:         limitsService,
+		accessRequest:  accessRequestService,
+		sod:            sodService,
+		userSchema:     userSchemaService,
+		jit:            jitService,
+		directory:      directoryService,
+	}
+}
 
-	// Register handlers.
-	organization.RegisterHandlers(e, orgService)
-	user.RegisterHandlers(e, userService)
-	resource.RegisterHandlers(e, resourceService)
-	role.RegisterHandlers(e, roleService)
-	group.RegisterHandlers(e, groupService)
-	policy.RegisterHandlers(e, policyService)
+// services are the services exposed through the admin and SCIM APIs.
+type services struct {
+	organization   organization.Service
+	apiKey         apikey.Service
+	user           user.Service
+	resource       resource.Service
+	role           role.Service
+	group          group.Service
+	policy         policy.Service
+	orgConfig      orgconfig.Service
+	scim           scim.Service
+	serviceAccount serviceaccount.Service
+	limits         limits.Service
+	accessRequest  accessrequest.Service
+	sod            sod.Service
+	userSchema     userschema.Service
+	jit            jit.Service
+	directory      directory.Service
+}
 
+func registerRoutes(e *echo.Echo, apiV1 *echo.Group, s services) {
+
+	organization.RegisterHandlers(apiV1, s.organization)
+	apikey.RegisterHandlers(apiV1, s.apiKey)
+	user.RegisterHandlers(apiV1, s.user)
+	resource.RegisterHandlers(apiV1, s.resource)
+	role.RegisterHandlers(apiV1, s.role)
+	group.RegisterHandlers(apiV1, s.group)
+	policy.RegisterHandlers(apiV1, s.policy)
+	orgconfig.RegisterHandlers(apiV1, s.orgConfig)
+	serviceaccount.RegisterHandlers(apiV1, s.serviceAccount)
+	limits.RegisterHandlers(apiV1, s.limits)
+	accessrequest.RegisterHandlers(apiV1, s.accessRequest)
+	sod.RegisterHandlers(apiV1, s.sod)
+	userschema.RegisterHandlers(apiV1, s.userSchema)
+	jit.RegisterHandlers(apiV1, s.jit)
+	directory.RegisterHandlers(apiV1, s.directory)
+
+	// SCIM provisioning is authenticated with organization tokens instead of admin JWTs.
+	scim.RegisterHandlers(e.Group("/scim/v2"), s.scim)
+	// Service accounts exchange their client credentials for tokens.
+	serviceaccount.RegisterTokenHandlers(e.Group("/oauth2"), s.serviceAccount)
 }
 
-func initializeRootOrganization(orgService organization.Service, userService user.Service, groupService group.Service,
+func initializeRootOrganization(ctx context.Context, orgService organization.Service, userService user.Service, groupService group.Service,
 	roleService role.Service, resourceService resource.Service, cfg *config.Config, logger *zap.Logger) {
 
-	exists, err := orgService.CheckOrgExistByIdentifier(nil, cfg.RootOrganization.Name)
+	exists, err := orgService.CheckOrgExistByIdentifier(ctx, cfg.RootOrganization.Name)
 	if err != nil {
 		logger.Fatal("Failed to check root organization exists", zap.Error(err))
 	}
@@ -166,16 +368,16 @@ func initializeRootOrganization(orgService organization.Service, userService use
 			Groups:      []mongo_entity.Group{},
 			Policies:    []mongo_entity.Policy{},
 		}
-		orgService.Create(nil, rootOrg)
+		orgService.Create(ctx, rootOrg)
 
-		initializeSystemResources(orgService, resourceService, cfg, logger)
-		initializeAdmin(orgService, userService, roleService, cfg, logger)
+		initializeSystemResources(ctx, orgService, resourceService, cfg, logger)
+		initializeAdmin(ctx, orgService, userService, roleService, cfg, logger)
 	}
 }
 
-func initializeAdmin(orgService organization.Service, userService user.Service, roleService role.Service, cfg *config.Config, logger *zap.Logger) {
+func initializeAdmin(ctx context.Context, orgService organization.Service, userService user.Service, roleService role.Service, cfg *config.Config, logger *zap.Logger) {
 
-	rootOrgId, err := orgService.GetIdByIdentifier(nil, cfg.RootOrganization.Name)
+	rootOrgId, err := orgService.GetIdByIdentifier(ctx, cfg.RootOrganization.Name)
 	if err != nil {
 		logger.Fatal("Failed to get root org id", zap.Error(err))
 	}
@@ -185,8 +387,8 @@ func initializeAdmin(orgService organization.Service, userService user.Service,
 		Roles:      []primitive.ObjectID{},
 		Groups:     []primitive.ObjectID{},
 	}
-	userService.Create(nil, rootOrgId, adminUser)
-	adminId, err := userService.GetIdByIdentifier(nil, rootOrgId, cfg.RootOrganization.AdminIdentifier)
+	userService.Create(ctx, rootOrgId, adminUser)
+	adminId, err := userService.GetIdByIdentifier(ctx, rootOrgId, cfg.RootOrganization.AdminIdentifier)
 	if err != nil {
 		logger.Fatal("Failed to get admin id", zap.Error(err))
 	}
@@ -220,12 +422,12 @@ func initializeAdmin(orgService organization.Service, userService user.Service,
 		Groups:      []primitive.ObjectID{},
 		Permissions: permissions,
 	}
-	roleService.Create(nil, rootOrgId, adminRole)
+	roleService.Create(ctx, rootOrgId, adminRole)
 }
 
-func initializeSystemResources(orgService organization.Service, resourceService resource.Service, cfg *config.Config, logger *zap.Logger) {
+func initializeSystemResources(ctx context.Context, orgService organization.Service, resourceService resource.Service, cfg *config.Config, logger *zap.Logger) {
 
-	rootOrgId, err := orgService.GetIdByIdentifier(nil, cfg.RootOrganization.Name)
+	rootOrgId, err := orgService.GetIdByIdentifier(ctx, cfg.RootOrganization.Name)
 	if err != nil {
 		logger.Fatal("Failed to get root org id", zap.Error(err))
 	}
@@ -241,7 +443,7 @@ func initializeSystemResources(orgService organization.Service, resourceService
 		Actions:     orgActions,
 		Type:        mongo_entity.SystemResource,
 	}
-	resourceService.Create(nil, rootOrgId, orgResource)
+	resourceService.Create(ctx, rootOrgId, orgResource)
 
 	// User resource
 	var userActions []mongo_entity.Action
@@ -254,7 +456,7 @@ func initializeSystemResources(orgService organization.Service, resourceService
 		Actions:     userActions,
 		Type:        mongo_entity.SystemResource,
 	}
-	resourceService.Create(nil, rootOrgId, userResource)
+	resourceService.Create(ctx, rootOrgId, userResource)
 
 	// Group resource
 	var groupActions []mongo_entity.Action
@@ -267,7 +469,7 @@ func initializeSystemResources(orgService organization.Service, resourceService
 		Actions:     groupActions,
 		Type:        mongo_entity.SystemResource,
 	}
-	resourceService.Create(nil, rootOrgId, groupResource)
+	resourceService.Create(ctx, rootOrgId, groupResource)
 
 	// Role resource
 	var roleActions []mongo_entity.Action
@@ -280,7 +482,7 @@ func initializeSystemResources(orgService organization.Service, resourceService
 		Actions:     roleActions,
 		Type:        mongo_entity.SystemResource,
 	}
-	resourceService.Create(nil, rootOrgId, roleResource)
+	resourceService.Create(ctx, rootOrgId, roleResource)
 
 	// Resource resource
 	var resourceActions []mongo_entity.Action
@@ -293,7 +495,7 @@ func initializeSystemResources(orgService organization.Service, resourceService
 		Actions:     resourceActions,
 		Type:        mongo_entity.SystemResource,
 	}
-	resourceService.Create(nil, rootOrgId, resourceResource)
+	resourceService.Create(ctx, rootOrgId, resourceResource)
 
 	// Policy resource
 	var policyActions []mongo_entity.Action
@@ -306,23 +508,5 @@ func initializeSystemResources(orgService organization.Service, resourceService
 		Actions:     policyActions,
 		Type:        mongo_entity.SystemResource,
 	}
-	resourceService.Create(nil, rootOrgId, policyResource)
-}
-
-func getRequiredPermissions(endpoints []config.APIEndpoint) map[mw.MethodPath][]string {
-
-	requiredPermissions := make(map[mw.MethodPath][]string)
-
-	for _, endpoint := range endpoints {
-		for _, method := range endpoint.Methods {
-			key := mw.MethodPath{
-				Method:   method.Method,
-				Path:     endpoint.Path,
-				Resource: endpoint.Resource,
-			}
-			requiredPermissions[key] = method.RequiredPermissions
-		}
-	}
-
-	return requiredPermissions
+	resourceService.Create(ctx, rootOrgId, policyResource)
 }
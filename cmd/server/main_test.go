@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/check"
+	"github.com/shashimalcse/cronuseo/internal/config"
+	mw "github.com/shashimalcse/cronuseo/internal/middleware"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+// Every admin route must be covered by the endpoint permissions of each
+// shipped configuration, otherwise the server refuses to start.
+func TestEndpointPermissions(t *testing.T) {
+
+	for _, file := range []string{"local.yml", "local-debug.yml", "run-test.yml"} {
+		t.Run(file, func(t *testing.T) {
+			data, err := os.ReadFile("../../config/" + file)
+			assert.Nil(t, err)
+			cfg := config.Config{}
+			assert.Nil(t, yaml.Unmarshal(data, &cfg))
+
+			permissions, err := mw.NewPermissions(cfg.APIEndpoints)
+			assert.Nil(t, err)
+
+			e := echo.New()
+			apiV1 := e.Group("/api/v1")
+			check.RegisterHandlers(apiV1, nil)
+			publicRoutes := e.Routes()
+			apiV1.Use(func(next echo.HandlerFunc) echo.HandlerFunc { return next })
+			registerRoutes(e, apiV1, services{})
+
+			assert.Nil(t, permissions.Bind("/api/v1", e.Routes(), publicRoutes))
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package accessrequest
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	mw "github.com/shashimalcse/cronuseo/internal/middleware"
+	"github.com/shashimalcse/cronuseo/internal/token"
+	"github.com/shashimalcse/cronuseo/internal/util"
+)
+
+func RegisterHandlers(r *echo.Group, service Service) {
+	res := resource{service}
+	router := r.Group("/o/:org_id/access-requests")
+	router.GET("", res.query)
+	router.POST("", res.create)
+	router.GET("/:id", res.get)
+	router.POST("/:id/approve", res.approve)
+	router.POST("/:id/deny", res.deny)
+	router.POST("/:id/cancel", res.cancel)
+	r.GET("/o/:org_id/access-request-policy", res.getPolicy)
+	r.PUT("/o/:org_id/access-request-policy", res.updatePolicy)
+}
+
+type resource struct {
+	service Service
+}
+
+// subject returns the subject of the verified token of the request.
+func subject(c echo.Context) string {
+
+	if identity, ok := c.Get(mw.IdentityKey).(token.Identity); ok {
+		return identity.Subject
+	}
+	return ""
+}
+
+// @Description Get the access requests of the caller, or all of them for approvers.
+// @Tags        Access Request
+// @Param org_id path string true "Organization ID"
+// @Param state query string false "Filter by state"
+// @Produce     json
+// @Success     200 {array}  AccessRequest
+// @failure     401,403,404,500
+// @Router      /o/{org_id}/access-requests [get]
+func (r resource) query(c echo.Context) error {
+
+	var filter Filter
+	if err := c.Bind(&filter); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	requests, err := r.service.Query(c.Request().Context(), c.Param("org_id"), subject(c), filter)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, requests)
+}
+
+// @Description Get access request by ID.
+// @Tags        Access Request
+// @Param org_id path string true "Organization ID"
+// @Param id path string true "Access request ID"
+// @Produce     json
+// @Success     200 {object}  AccessRequest
+// @failure     401,403,404,500
+// @Router      /o/{org_id}/access-requests/{id} [get]
+func (r resource) get(c echo.Context) error {
+
+	request, err := r.service.Get(c.Request().Context(), c.Param("org_id"), subject(c), c.Param("id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, request)
+}
+
+// @Description Request a role or a group for a duration.
+// @Tags        Access Request
+// @Accept      json
+// @Param org_id path string true "Organization ID"
+// @Param request body CreateAccessRequest true "body"
+// @Produce     json
+// @Success     201 {object}  AccessRequest
+// @failure     400,401,403,404,409,500
+// @Router      /o/{org_id}/access-requests [post]
+func (r resource) create(c echo.Context) error {
+
+	var input CreateAccessRequest
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	request, err := r.service.Create(c.Request().Context(), c.Param("org_id"), subject(c), input)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusCreated, request)
+}
+
+// @Description Approve a pending access request and grant the access until it expires.
+// @Tags        Access Request
+// @Accept      json
+// @Param org_id path string true "Organization ID"
+// @Param id path string true "Access request ID"
+// @Param request body ReviewRequest false "body"
+// @Produce     json
+// @Success     200 {object}  AccessRequest
+// @failure     400,401,403,404,500
+// @Router      /o/{org_id}/access-requests/{id}/approve [post]
+func (r resource) approve(c echo.Context) error {
+
+	var input ReviewRequest
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	request, err := r.service.Approve(c.Request().Context(), c.Param("org_id"), subject(c), c.Param("id"), input)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, request)
+}
+
+// @Description Deny a pending access request.
+// @Tags        Access Request
+// @Accept      json
+// @Param org_id path string true "Organization ID"
+// @Param id path string true "Access request ID"
+// @Param request body ReviewRequest false "body"
+// @Produce     json
+// @Success     200 {object}  AccessRequest
+// @failure     400,401,403,404,500
+// @Router      /o/{org_id}/access-requests/{id}/deny [post]
+func (r resource) deny(c echo.Context) error {
+
+	var input ReviewRequest
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	request, err := r.service.Deny(c.Request().Context(), c.Param("org_id"), subject(c), c.Param("id"), input)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, request)
+}
+
+// @Description Cancel a pending access request of the caller.
+// @Tags        Access Request
+// @Param org_id path string true "Organization ID"
+// @Param id path string true "Access request ID"
+// @Produce     json
+// @Success     200 {object}  AccessRequest
+// @failure     400,401,403,404,500
+// @Router      /o/{org_id}/access-requests/{id}/cancel [post]
+func (r resource) cancel(c echo.Context) error {
+
+	request, err := r.service.Cancel(c.Request().Context(), c.Param("org_id"), subject(c), c.Param("id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, request)
+}
+
+// @Description Get the access request policy of the organization.
+// @Tags        Access Request
+// @Param org_id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {object}  Policy
+// @failure     404,500
+// @Router      /o/{org_id}/access-request-policy [get]
+func (r resource) getPolicy(c echo.Context) error {
+
+	policy, err := r.service.GetPolicy(c.Request().Context(), c.Param("org_id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, policy)
+}
+
+// @Description Update the approver roles and the maximum duration of access requests.
+// @Tags        Access Request
+// @Accept      json
+// @Param org_id path string true "Organization ID"
+// @Param request body Policy true "body"
+// @Produce     json
+// @Success     200 {object}  Policy
+// @failure     400,404,500
+// @Router      /o/{org_id}/access-request-policy [put]
+func (r resource) updatePolicy(c echo.Context) error {
+
+	var input Policy
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	policy, err := r.service.UpdatePolicy(c.Request().Context(), c.Param("org_id"), input)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, policy)
+}
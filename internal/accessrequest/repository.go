@@ -0,0 +1,187 @@
+package accessrequest
+
+import (
+	"context"
+	"time"
+
+	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Repository interface {
+	Query(ctx context.Context, org_id string) ([]mongo_entity.AccessRequest, error)
+	Get(ctx context.Context, org_id string, id string) (*mongo_entity.AccessRequest, error)
+	Create(ctx context.Context, org_id string, request mongo_entity.AccessRequest) error
+	Transition(ctx context.Context, org_id string, id string, from mongo_entity.AccessRequestState, event mongo_entity.AccessRequestEvent, expiresAt *time.Time) error
+	GetMembers(ctx context.Context, org_id string) (*mongo_entity.Organization, error)
+	GetPolicy(ctx context.Context, org_id string) (*mongo_entity.AccessRequestPolicy, error)
+	UpdatePolicy(ctx context.Context, org_id string, policy mongo_entity.AccessRequestPolicy) error
+}
+
+type repository struct {
+	mongoClient *mongo.Client
+	mongoColl   *mongo.Collection
+}
+
+func NewRepository(mongodb *db.MongoDB) Repository {
+
+	orgCollection := mongodb.MongoClient.Database(mongodb.MongoConfig.DBName).Collection(mongodb.MongoConfig.OrganizationCollectionName)
+
+	return repository{mongoClient: mongodb.MongoClient, mongoColl: orgCollection}
+}
+
+// Get all access requests of the organization.
+func (r repository) Query(ctx context.Context, org_id string) ([]mongo_entity.AccessRequest, error) {
+
+	ctx, span := telemetry.Repository(ctx, "accessrequest", "Query")
+	defer span.End()
+
+	org, err := r.find(ctx, org_id, bson.M{"access_requests": 1})
+	if err != nil {
+		return nil, err
+	}
+	return org.AccessRequests, nil
+}
+
+// Get access request by id.
+func (r repository) Get(ctx context.Context, org_id string, id string) (*mongo_entity.AccessRequest, error) {
+
+	ctx, span := telemetry.Repository(ctx, "accessrequest", "Get")
+	defer span.End()
+
+	requests, err := r.Query(ctx, org_id)
+	if err != nil {
+		return nil, err
+	}
+	for _, request := range requests {
+		if request.ID.Hex() == id {
+			return &request, nil
+		}
+	}
+	return nil, &util.NotFoundError{Path: "Access request"}
+}
+
+// Add a new access request to the organization.
+func (r repository) Create(ctx context.Context, org_id string, request mongo_entity.AccessRequest) error {
+
+	ctx, span := telemetry.Repository(ctx, "accessrequest", "Create")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": orgId}
+	update := bson.M{"$push": bson.M{"access_requests": request}}
+	result, err := r.mongoColl.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &util.NotFoundError{Path: "Organization"}
+	}
+	return nil
+}
+
+// Transition moves an access request in the from state to the state of the
+// event and records the event. It returns mongo.ErrNoDocuments when the
+// request is no longer in the from state.
+func (r repository) Transition(ctx context.Context, org_id string, id string, from mongo_entity.AccessRequestState, event mongo_entity.AccessRequestEvent, expiresAt *time.Time) error {
+
+	ctx, span := telemetry.Repository(ctx, "accessrequest", "Transition")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return err
+	}
+	requestId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": orgId, "access_requests": bson.M{"$elemMatch": bson.M{"_id": requestId, "state": from}}}
+	set := bson.M{"access_requests.$.state": event.State, "access_requests.$.updated_at": event.Time}
+	if expiresAt != nil {
+		set["access_requests.$.expires_at"] = expiresAt
+	}
+	update := bson.M{"$set": set, "$push": bson.M{"access_requests.$.history": event}}
+	result, err := r.mongoColl.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// GetMembers returns the users, groups and roles of the organization with
+// their assignments.
+func (r repository) GetMembers(ctx context.Context, org_id string) (*mongo_entity.Organization, error) {
+
+	ctx, span := telemetry.Repository(ctx, "accessrequest", "GetMembers")
+	defer span.End()
+
+	return r.find(ctx, org_id, bson.M{
+		"users._id": 1, "users.identifier": 1, "users.roles": 1, "users.groups": 1, "users.role_grants": 1, "users.group_grants": 1,
+		"groups._id": 1, "groups.roles": 1, "groups.role_grants": 1,
+		"roles._id": 1,
+	})
+}
+
+// GetPolicy returns the access request policy of the organization, nil when
+// none is set.
+func (r repository) GetPolicy(ctx context.Context, org_id string) (*mongo_entity.AccessRequestPolicy, error) {
+
+	ctx, span := telemetry.Repository(ctx, "accessrequest", "GetPolicy")
+	defer span.End()
+
+	org, err := r.find(ctx, org_id, bson.M{"access_request_policy": 1})
+	if err != nil {
+		return nil, err
+	}
+	return org.AccessRequestPolicy, nil
+}
+
+// UpdatePolicy replaces the access request policy of the organization.
+func (r repository) UpdatePolicy(ctx context.Context, org_id string, policy mongo_entity.AccessRequestPolicy) error {
+
+	ctx, span := telemetry.Repository(ctx, "accessrequest", "UpdatePolicy")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return err
+	}
+	result, err := r.mongoColl.UpdateOne(ctx, bson.M{"_id": orgId}, bson.M{"$set": bson.M{"access_request_policy": policy}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &util.NotFoundError{Path: "Organization"}
+	}
+	return nil
+}
+
+func (r repository) find(ctx context.Context, org_id string, projection bson.M) (*mongo_entity.Organization, error) {
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	var org mongo_entity.Organization
+	err = r.mongoColl.FindOne(ctx, bson.M{"_id": orgId}, options.FindOne().SetProjection(projection)).Decode(&org)
+	if err == mongo.ErrNoDocuments {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
@@ -0,0 +1,551 @@
+package accessrequest
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/shashimalcse/cronuseo/internal/assignment"
+	"github.com/shashimalcse/cronuseo/internal/audit"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/user"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// Service manages the access requests of users. subject is the identifier of
+// the calling user, requests are visible to their requester and to approvers.
+type Service interface {
+	Query(ctx context.Context, org_id string, subject string, filter Filter) ([]AccessRequest, error)
+	Get(ctx context.Context, org_id string, subject string, id string) (AccessRequest, error)
+	Create(ctx context.Context, org_id string, subject string, input CreateAccessRequest) (AccessRequest, error)
+	Approve(ctx context.Context, org_id string, subject string, id string, input ReviewRequest) (AccessRequest, error)
+	Deny(ctx context.Context, org_id string, subject string, id string, input ReviewRequest) (AccessRequest, error)
+	Cancel(ctx context.Context, org_id string, subject string, id string) (AccessRequest, error)
+	GetPolicy(ctx context.Context, org_id string) (Policy, error)
+	UpdatePolicy(ctx context.Context, org_id string, input Policy) (Policy, error)
+}
+
+type AccessRequest struct {
+	mongo_entity.AccessRequest
+}
+
+type Policy struct {
+	mongo_entity.AccessRequestPolicy
+}
+
+type Filter struct {
+	State string `query:"state"`
+}
+
+// CreateAccessRequest requests a role or a group for a duration such as "8h".
+type CreateAccessRequest struct {
+	Role     *primitive.ObjectID `json:"role,omitempty"`
+	Group    *primitive.ObjectID `json:"group,omitempty"`
+	Reason   string              `json:"reason"`
+	Duration string              `json:"duration"`
+}
+
+func (m CreateAccessRequest) Validate() error {
+
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Role, validation.When(m.Group == nil, validation.Required.Error("either role or group is required"))),
+		validation.Field(&m.Group, validation.When(m.Role != nil, validation.Nil.Error("only one of role and group can be requested"))),
+		validation.Field(&m.Reason, validation.Required, validation.Length(1, 1024)),
+		validation.Field(&m.Duration, validation.Required),
+	)
+}
+
+type ReviewRequest struct {
+	Comment string `json:"comment"`
+}
+
+func (m ReviewRequest) Validate() error {
+
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Comment, validation.Length(0, 1024)),
+	)
+}
+
+type service struct {
+	repo        Repository
+	users       user.Service
+	notifier    Notifier
+	recorder    audit.Recorder
+	maxDuration time.Duration
+	logger      *zap.Logger
+}
+
+// NewService creates the access request service. Approved requests are granted
+// through the user service, and maxDuration bounds the duration of requests
+// of organizations whose policy has no limit.
+func NewService(repo Repository, users user.Service, notifier Notifier, recorder audit.Recorder, maxDuration time.Duration, logger *zap.Logger) Service {
+
+	return service{repo: repo, users: users, notifier: notifier, recorder: recorder, maxDuration: maxDuration, logger: logger}
+}
+
+// Get the access requests visible to the subject, newest first.
+func (s service) Query(ctx context.Context, org_id string, subject string, filter Filter) ([]AccessRequest, error) {
+
+	ctx, span := telemetry.Start(ctx, "accessrequest.service.Query")
+	defer span.End()
+
+	caller, err := s.caller(ctx, org_id, subject)
+	if err != nil {
+		return []AccessRequest{}, err
+	}
+	requests, err := s.repo.Query(ctx, org_id)
+	if err != nil {
+		s.logger.Error("Error while retrieving access requests.", zap.String("organization_id", org_id))
+		return []AccessRequest{}, err
+	}
+	result := []AccessRequest{}
+	for _, request := range requests {
+		if !caller.canView(request) || (filter.State != "" && string(request.State) != filter.State) {
+			continue
+		}
+		result = append(result, AccessRequest{request})
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	return result, nil
+}
+
+// Get access request by id.
+func (s service) Get(ctx context.Context, org_id string, subject string, id string) (AccessRequest, error) {
+
+	ctx, span := telemetry.Start(ctx, "accessrequest.service.Get")
+	defer span.End()
+
+	caller, err := s.caller(ctx, org_id, subject)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	request, err := s.repo.Get(ctx, org_id, id)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	if !caller.canView(*request) {
+		return AccessRequest{}, &util.NotFoundError{Path: "Access request"}
+	}
+	return AccessRequest{*request}, nil
+}
+
+// Create an access request of the subject and notify the approvers.
+func (s service) Create(ctx context.Context, org_id string, subject string, req CreateAccessRequest) (AccessRequest, error) {
+
+	ctx, span := telemetry.Start(ctx, "accessrequest.service.Create")
+	defer span.End()
+
+	if err := req.Validate(); err != nil {
+		s.logger.Debug("Invalid access request.", zap.Error(err))
+		return AccessRequest{}, util.NewValidationError("Invalid input for access request.", err)
+	}
+	caller, err := s.caller(ctx, org_id, subject)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	if len(caller.policy.ApproverRoles) == 0 {
+		return AccessRequest{}, &util.InvalidInputError{Message: "Access requests are not enabled, the organization has no approver roles."}
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 {
+		return AccessRequest{}, &util.InvalidInputError{Message: "Invalid access request duration " + req.Duration + "."}
+	}
+	if max := s.policyMaxDuration(caller.policy); duration > max {
+		return AccessRequest{}, &util.InvalidInputError{Message: "Access request duration exceeds the maximum of " + max.String() + "."}
+	}
+	if req.Role != nil && !caller.hasRole(*req.Role) {
+		return AccessRequest{}, &util.InvalidInputError{Message: "Invalid role id " + req.Role.Hex()}
+	}
+	if req.Group != nil && !caller.hasGroup(*req.Group) {
+		return AccessRequest{}, &util.InvalidInputError{Message: "Invalid group id " + req.Group.Hex()}
+	}
+
+	requests, err := s.repo.Query(ctx, org_id)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	for _, request := range requests {
+		if request.State == mongo_entity.AccessRequestPending && request.Requester == caller.user.ID &&
+			sameTarget(request.Role, req.Role) && sameTarget(request.Group, req.Group) {
+			return AccessRequest{}, &util.AlreadyExistsError{Path: "Pending access request " + request.ID.Hex()}
+		}
+	}
+
+	now := time.Now().UTC()
+	request := mongo_entity.AccessRequest{
+		ID:                  primitive.NewObjectID(),
+		Requester:           caller.user.ID,
+		RequesterIdentifier: caller.user.Identifier,
+		Role:                req.Role,
+		Group:               req.Group,
+		Reason:              req.Reason,
+		Duration:            duration.String(),
+		State:               mongo_entity.AccessRequestPending,
+		CreatedAt:           now,
+		UpdatedAt:           now,
+		History: []mongo_entity.AccessRequestEvent{
+			{State: mongo_entity.AccessRequestPending, Actor: caller.user.Identifier, Comment: req.Reason, Time: now},
+		},
+	}
+	if err := s.repo.Create(ctx, org_id, request); err != nil {
+		s.logger.Error("Error while creating access request.", zap.String("organization_id", org_id))
+		return AccessRequest{}, err
+	}
+	s.publish(ctx, org_id, audit.ActionAccessRequestCreated, caller, request, now)
+	return AccessRequest{request}, nil
+}
+
+// Approve a pending access request and grant the requested role or group
+// until the request expires.
+func (s service) Approve(ctx context.Context, org_id string, subject string, id string, req ReviewRequest) (AccessRequest, error) {
+
+	ctx, span := telemetry.Start(ctx, "accessrequest.service.Approve")
+	defer span.End()
+
+	caller, request, err := s.review(ctx, org_id, subject, id, req)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	duration, err := time.ParseDuration(request.Duration)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	now := time.Now().UTC()
+	expiresAt := now.Add(duration)
+	event := mongo_entity.AccessRequestEvent{State: mongo_entity.AccessRequestApproved, Actor: caller.user.Identifier, Comment: req.Comment, Time: now}
+	if err := s.transition(ctx, org_id, request, event, &expiresAt); err != nil {
+		return AccessRequest{}, err
+	}
+
+	patch := user.PatchUserRequest{ValidUntil: &expiresAt}
+	if request.Role != nil {
+		patch.AddedRoles = []primitive.ObjectID{*request.Role}
+	} else {
+		patch.AddedGroups = []primitive.ObjectID{*request.Group}
+	}
+	if _, err := s.users.Patch(ctx, org_id, request.Requester.Hex(), patch); err != nil {
+		s.logger.Error("Error while granting access request.",
+			zap.String("organization_id", org_id),
+			zap.String("access_request_id", id),
+			zap.Error(err))
+		failed := mongo_entity.AccessRequestEvent{State: mongo_entity.AccessRequestFailed, Actor: audit.SystemActor, Comment: "Granting the access failed.", Time: time.Now().UTC()}
+		if err := s.repo.Transition(ctx, org_id, id, mongo_entity.AccessRequestApproved, failed, nil); err == nil {
+			s.record(ctx, org_id, audit.ActionAccessRequestFailed, audit.SystemActor, *request, failed.Time)
+		}
+		return AccessRequest{}, err
+	}
+	return s.reviewed(ctx, org_id, audit.ActionAccessRequestApproved, caller, id, now)
+}
+
+// Deny a pending access request.
+func (s service) Deny(ctx context.Context, org_id string, subject string, id string, req ReviewRequest) (AccessRequest, error) {
+
+	ctx, span := telemetry.Start(ctx, "accessrequest.service.Deny")
+	defer span.End()
+
+	caller, request, err := s.review(ctx, org_id, subject, id, req)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	now := time.Now().UTC()
+	event := mongo_entity.AccessRequestEvent{State: mongo_entity.AccessRequestDenied, Actor: caller.user.Identifier, Comment: req.Comment, Time: now}
+	if err := s.transition(ctx, org_id, request, event, nil); err != nil {
+		return AccessRequest{}, err
+	}
+	return s.reviewed(ctx, org_id, audit.ActionAccessRequestDenied, caller, id, now)
+}
+
+// Cancel a pending access request of the subject.
+func (s service) Cancel(ctx context.Context, org_id string, subject string, id string) (AccessRequest, error) {
+
+	ctx, span := telemetry.Start(ctx, "accessrequest.service.Cancel")
+	defer span.End()
+
+	caller, err := s.caller(ctx, org_id, subject)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	request, err := s.repo.Get(ctx, org_id, id)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	if !caller.canView(*request) {
+		return AccessRequest{}, &util.NotFoundError{Path: "Access request"}
+	}
+	if request.Requester != caller.user.ID {
+		return AccessRequest{}, &util.PermissionDeniedError{Message: "Only the requester can cancel an access request."}
+	}
+	now := time.Now().UTC()
+	event := mongo_entity.AccessRequestEvent{State: mongo_entity.AccessRequestCancelled, Actor: caller.user.Identifier, Time: now}
+	if err := s.transition(ctx, org_id, request, event, nil); err != nil {
+		return AccessRequest{}, err
+	}
+	return s.reviewed(ctx, org_id, audit.ActionAccessRequestCancelled, caller, id, now)
+}
+
+// Get the access request policy of the organization.
+func (s service) GetPolicy(ctx context.Context, org_id string) (Policy, error) {
+
+	ctx, span := telemetry.Start(ctx, "accessrequest.service.GetPolicy")
+	defer span.End()
+
+	policy, err := s.repo.GetPolicy(ctx, org_id)
+	if err != nil {
+		return Policy{}, err
+	}
+	if policy == nil {
+		return Policy{mongo_entity.AccessRequestPolicy{ApproverRoles: []primitive.ObjectID{}}}, nil
+	}
+	return Policy{*policy}, nil
+}
+
+// Update the access request policy of the organization.
+func (s service) UpdatePolicy(ctx context.Context, org_id string, req Policy) (Policy, error) {
+
+	ctx, span := telemetry.Start(ctx, "accessrequest.service.UpdatePolicy")
+	defer span.End()
+
+	if req.MaxDuration != "" {
+		duration, err := time.ParseDuration(req.MaxDuration)
+		if err != nil || duration <= 0 {
+			return Policy{}, &util.InvalidInputError{Message: "Invalid access request max_duration " + req.MaxDuration + "."}
+		}
+		req.MaxDuration = duration.String()
+	}
+	org, err := s.repo.GetMembers(ctx, org_id)
+	if err != nil {
+		return Policy{}, err
+	}
+	members := members{org: org}
+	for _, roleId := range req.ApproverRoles {
+		if !members.hasRole(roleId) {
+			return Policy{}, &util.InvalidInputError{Message: "Invalid role id " + roleId.Hex()}
+		}
+	}
+	if req.ApproverRoles == nil {
+		req.ApproverRoles = []primitive.ObjectID{}
+	}
+	if err := s.repo.UpdatePolicy(ctx, org_id, req.AccessRequestPolicy); err != nil {
+		s.logger.Error("Error while updating access request policy.", zap.String("organization_id", org_id))
+		return Policy{}, err
+	}
+	return req, nil
+}
+
+// review checks that the subject may review the pending request.
+func (s service) review(ctx context.Context, org_id string, subject string, id string, req ReviewRequest) (caller, *mongo_entity.AccessRequest, error) {
+
+	if err := req.Validate(); err != nil {
+		return caller{}, nil, util.NewValidationError("Invalid input for access request review.", err)
+	}
+	c, err := s.caller(ctx, org_id, subject)
+	if err != nil {
+		return caller{}, nil, err
+	}
+	request, err := s.repo.Get(ctx, org_id, id)
+	if err != nil {
+		return caller{}, nil, err
+	}
+	if !c.canView(*request) {
+		return caller{}, nil, &util.NotFoundError{Path: "Access request"}
+	}
+	if !c.approver {
+		return caller{}, nil, &util.PermissionDeniedError{Message: "Only approvers can review access requests."}
+	}
+	if request.Requester == c.user.ID {
+		return caller{}, nil, &util.PermissionDeniedError{Message: "Requesters cannot review their own access requests."}
+	}
+	return c, request, nil
+}
+
+// transition moves a pending request to the state of the event.
+func (s service) transition(ctx context.Context, org_id string, request *mongo_entity.AccessRequest, event mongo_entity.AccessRequestEvent, expiresAt *time.Time) error {
+
+	if request.State != mongo_entity.AccessRequestPending {
+		return &util.InvalidInputError{Message: "Access request is " + string(request.State) + ", only pending requests can be " + string(event.State) + "."}
+	}
+	err := s.repo.Transition(ctx, org_id, request.ID.Hex(), mongo_entity.AccessRequestPending, event, expiresAt)
+	if err == mongo.ErrNoDocuments {
+		return &util.InvalidInputError{Message: "Access request is no longer pending."}
+	}
+	return err
+}
+
+// reviewed returns the updated request and publishes the change.
+func (s service) reviewed(ctx context.Context, org_id string, action string, caller caller, id string, now time.Time) (AccessRequest, error) {
+
+	request, err := s.repo.Get(ctx, org_id, id)
+	if err != nil {
+		return AccessRequest{}, err
+	}
+	s.publish(ctx, org_id, action, caller, *request, now)
+	return AccessRequest{*request}, nil
+}
+
+// publish records the audit event of a change and notifies the approvers.
+func (s service) publish(ctx context.Context, org_id string, action string, caller caller, request mongo_entity.AccessRequest, now time.Time) {
+
+	s.record(ctx, org_id, action, caller.user.Identifier, request, now)
+	s.notifier.Notify(ctx, Notification{
+		Event:          action,
+		OrganizationID: org_id,
+		Request:        AccessRequest{request},
+		Approvers:      caller.approvers(),
+		Time:           now,
+	})
+}
+
+func (s service) record(ctx context.Context, org_id string, action string, actor string, request mongo_entity.AccessRequest, now time.Time) {
+
+	target := ""
+	if request.Role != nil {
+		target = "role:" + request.Role.Hex()
+	} else if request.Group != nil {
+		target = "group:" + request.Group.Hex()
+	}
+	details := map[string]string{"access_request_id": request.ID.Hex(), "duration": request.Duration}
+	if request.ExpiresAt != nil {
+		details["expires_at"] = request.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	s.recorder.Record(ctx, audit.Event{
+		Time:           now,
+		OrganizationID: org_id,
+		Actor:          actor,
+		Action:         action,
+		Subject:        "user:" + request.Requester.Hex(),
+		Target:         target,
+		Details:        details,
+	})
+}
+
+func (s service) policyMaxDuration(policy mongo_entity.AccessRequestPolicy) time.Duration {
+
+	if duration, err := time.ParseDuration(policy.MaxDuration); err == nil && duration > 0 {
+		return duration
+	}
+	return s.maxDuration
+}
+
+// caller resolves the subject to a user of the organization.
+func (s service) caller(ctx context.Context, org_id string, subject string) (caller, error) {
+
+	if subject == "" {
+		return caller{}, &util.UnauthorizedError{Message: "Access requests need an authenticated user."}
+	}
+	org, err := s.repo.GetMembers(ctx, org_id)
+	if err != nil {
+		return caller{}, err
+	}
+	policy, err := s.repo.GetPolicy(ctx, org_id)
+	if err != nil {
+		return caller{}, err
+	}
+	c := caller{members: members{org: org, now: time.Now()}}
+	if policy != nil {
+		c.policy = *policy
+	}
+	for _, u := range org.Users {
+		if u.Identifier == subject {
+			c.user = u
+			c.approver = c.holdsAny(u, c.policy.ApproverRoles)
+			return c, nil
+		}
+	}
+	return caller{}, &util.PermissionDeniedError{Message: "Only users of the organization can use access requests."}
+}
+
+// members are the users, groups and roles of an organization.
+type members struct {
+	org *mongo_entity.Organization
+	now time.Time
+}
+
+func (m members) hasRole(id primitive.ObjectID) bool {
+
+	for _, role := range m.org.Roles {
+		if role.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (m members) hasGroup(id primitive.ObjectID) bool {
+
+	for _, group := range m.org.Groups {
+		if group.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// roles returns the active roles of a user, directly assigned or inherited
+// from its active groups.
+func (m members) roles(u mongo_entity.User) map[primitive.ObjectID]bool {
+
+	roles := map[primitive.ObjectID]bool{}
+	for _, id := range assignment.Active(u.Roles, u.RoleGrants, m.now) {
+		roles[id] = true
+	}
+	groups := map[primitive.ObjectID]bool{}
+	for _, id := range assignment.Active(u.Groups, u.GroupGrants, m.now) {
+		groups[id] = true
+	}
+	for _, group := range m.org.Groups {
+		if groups[group.ID] {
+			for _, id := range assignment.Active(group.Roles, group.RoleGrants, m.now) {
+				roles[id] = true
+			}
+		}
+	}
+	return roles
+}
+
+func (m members) holdsAny(u mongo_entity.User, roleIds []primitive.ObjectID) bool {
+
+	roles := m.roles(u)
+	for _, id := range roleIds {
+		if roles[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// caller is the user calling the service and the policy of its organization.
+type caller struct {
+	members
+	user     mongo_entity.User
+	policy   mongo_entity.AccessRequestPolicy
+	approver bool
+}
+
+func (c caller) canView(request mongo_entity.AccessRequest) bool {
+	return c.approver || request.Requester == c.user.ID
+}
+
+// approvers returns the identifiers of the users holding an approver role.
+func (c caller) approvers() []string {
+
+	approvers := []string{}
+	for _, u := range c.org.Users {
+		if c.holdsAny(u, c.policy.ApproverRoles) {
+			approvers = append(approvers, u.Identifier)
+		}
+	}
+	return approvers
+}
+
+func sameTarget(a *primitive.ObjectID, b *primitive.ObjectID) bool {
+
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
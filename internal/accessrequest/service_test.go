@@ -0,0 +1,222 @@
+package accessrequest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/audit"
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/user"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+func TestService(t *testing.T) {
+
+	orgId := primitive.NewObjectID().Hex()
+	oncall, approver, other := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	leads := mongo_entity.Group{ID: primitive.NewObjectID(), Roles: []primitive.ObjectID{approver}}
+	alice := mongo_entity.User{ID: primitive.NewObjectID(), Identifier: "alice"}
+	bob := mongo_entity.User{ID: primitive.NewObjectID(), Identifier: "bob", Groups: []primitive.ObjectID{leads.ID}}
+	carol := mongo_entity.User{ID: primitive.NewObjectID(), Identifier: "carol", Roles: []primitive.ObjectID{approver}}
+	repo := &mockRepository{org: mongo_entity.Organization{
+		Users:  []mongo_entity.User{alice, bob, carol},
+		Groups: []mongo_entity.Group{leads},
+		Roles:  []mongo_entity.Role{{ID: oncall}, {ID: approver}, {ID: other}},
+	}}
+	users := &mockUserService{}
+	notifier := &mockNotifier{}
+	recorder := &mockRecorder{}
+	s := NewService(repo, users, notifier, recorder, 24*time.Hour, zap.NewNop())
+	ctx := context.Background()
+
+	// access requests need approver roles
+	request := CreateAccessRequest{Role: &oncall, Reason: "incident 42", Duration: "8h"}
+	_, err := s.Create(ctx, orgId, "alice", request)
+	assert.IsType(t, &util.InvalidInputError{}, err)
+	_, err = s.UpdatePolicy(ctx, orgId, Policy{mongo_entity.AccessRequestPolicy{ApproverRoles: []primitive.ObjectID{primitive.NewObjectID()}}})
+	assert.IsType(t, &util.InvalidInputError{}, err)
+	_, err = s.UpdatePolicy(ctx, orgId, Policy{mongo_entity.AccessRequestPolicy{ApproverRoles: []primitive.ObjectID{approver}, MaxDuration: "12h"}})
+	assert.Nil(t, err)
+
+	// validation
+	invalid := []CreateAccessRequest{
+		{Reason: "incident 42", Duration: "8h"},
+		{Role: &oncall, Group: &leads.ID, Reason: "incident 42", Duration: "8h"},
+		{Role: &oncall, Duration: "8h"},
+		{Role: &oncall, Reason: "incident 42", Duration: "soon"},
+		{Role: &oncall, Reason: "incident 42", Duration: "13h"},
+		{Role: &alice.ID, Reason: "incident 42", Duration: "8h"},
+	}
+	for i, req := range invalid {
+		_, err := s.Create(ctx, orgId, "alice", req)
+		assert.IsType(t, &util.InvalidInputError{}, err, i)
+	}
+	_, err = s.Create(ctx, orgId, "", request)
+	assert.IsType(t, &util.UnauthorizedError{}, err)
+	_, err = s.Create(ctx, orgId, "mallory", request)
+	assert.IsType(t, &util.PermissionDeniedError{}, err)
+
+	created, err := s.Create(ctx, orgId, "alice", request)
+	assert.Nil(t, err)
+	assert.Equal(t, mongo_entity.AccessRequestPending, created.State)
+	assert.Equal(t, alice.ID, created.Requester)
+	_, err = s.Create(ctx, orgId, "alice", request)
+	assert.IsType(t, &util.AlreadyExistsError{}, err)
+	assert.Equal(t, audit.ActionAccessRequestCreated, notifier.last().Event)
+	assert.Equal(t, []string{"bob", "carol"}, notifier.last().Approvers)
+
+	// requests are visible to their requester and the approvers
+	requests, _ := s.Query(ctx, orgId, "bob", Filter{State: "pending"})
+	assert.Len(t, requests, 1)
+	other2, err := s.Create(ctx, orgId, "carol", CreateAccessRequest{Role: &other, Reason: "audit", Duration: "1h"})
+	assert.Nil(t, err)
+	requests, _ = s.Query(ctx, orgId, "alice", Filter{})
+	assert.Len(t, requests, 1)
+	_, err = s.Get(ctx, orgId, "alice", other2.ID.Hex())
+	assert.IsType(t, &util.NotFoundError{}, err)
+
+	// only approvers other than the requester review
+	_, err = s.Approve(ctx, orgId, "alice", created.ID.Hex(), ReviewRequest{})
+	assert.IsType(t, &util.PermissionDeniedError{}, err)
+	_, err = s.Approve(ctx, orgId, "carol", other2.ID.Hex(), ReviewRequest{})
+	assert.IsType(t, &util.PermissionDeniedError{}, err)
+	_, err = s.Cancel(ctx, orgId, "bob", created.ID.Hex())
+	assert.IsType(t, &util.PermissionDeniedError{}, err)
+
+	// approval grants the role until the request expires
+	approved, err := s.Approve(ctx, orgId, "bob", created.ID.Hex(), ReviewRequest{Comment: "ok"})
+	assert.Nil(t, err)
+	assert.Equal(t, mongo_entity.AccessRequestApproved, approved.State)
+	assert.WithinDuration(t, time.Now().Add(8*time.Hour), *approved.ExpiresAt, time.Minute)
+	assert.Len(t, approved.History, 2)
+	assert.Equal(t, "bob", approved.History[1].Actor)
+	assert.Equal(t, alice.ID.Hex(), users.id)
+	assert.Equal(t, []primitive.ObjectID{oncall}, users.patch.AddedRoles)
+	assert.Equal(t, approved.ExpiresAt.Unix(), users.patch.ValidUntil.Unix())
+	assert.Equal(t, audit.ActionAccessRequestApproved, recorder.events[len(recorder.events)-1].Action)
+
+	// reviewed requests are final
+	_, err = s.Deny(ctx, orgId, "carol", created.ID.Hex(), ReviewRequest{})
+	assert.IsType(t, &util.InvalidInputError{}, err)
+	cancelled, err := s.Cancel(ctx, orgId, "carol", other2.ID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, mongo_entity.AccessRequestCancelled, cancelled.State)
+}
+
+func TestWebhook(t *testing.T) {
+
+	received := make(chan *http.Request, 1)
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		received <- r
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook(config.Webhook{URL: server.URL, Secret: "s3cret", Timeout: time.Second}, zap.NewNop())
+	notification := Notification{Event: audit.ActionAccessRequestCreated, OrganizationID: "org", Approvers: []string{"bob"}}
+	assert.Nil(t, webhook.Send(context.Background(), notification))
+	r := <-received
+	assert.Equal(t, "sha256="+Sign("s3cret", body), r.Header.Get(SignatureHeader))
+	var sent Notification
+	assert.Nil(t, json.Unmarshal(body, &sent))
+	assert.Equal(t, []string{"bob"}, sent.Approvers)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	assert.NotNil(t, NewWebhook(config.Webhook{URL: failing.URL}, zap.NewNop()).Send(context.Background(), notification))
+	assert.IsType(t, noopNotifier{}, NewNotifier(config.Webhook{}, zap.NewNop()))
+}
+
+type mockRepository struct {
+	org mongo_entity.Organization
+}
+
+func (m *mockRepository) Query(ctx context.Context, org_id string) ([]mongo_entity.AccessRequest, error) {
+	return m.org.AccessRequests, nil
+}
+
+func (m *mockRepository) Get(ctx context.Context, org_id string, id string) (*mongo_entity.AccessRequest, error) {
+	for _, request := range m.org.AccessRequests {
+		if request.ID.Hex() == id {
+			return &request, nil
+		}
+	}
+	return nil, &util.NotFoundError{Path: "Access request"}
+}
+
+func (m *mockRepository) Create(ctx context.Context, org_id string, request mongo_entity.AccessRequest) error {
+	m.org.AccessRequests = append(m.org.AccessRequests, request)
+	return nil
+}
+
+func (m *mockRepository) Transition(ctx context.Context, org_id string, id string, from mongo_entity.AccessRequestState, event mongo_entity.AccessRequestEvent, expiresAt *time.Time) error {
+	for i, request := range m.org.AccessRequests {
+		if request.ID.Hex() == id && request.State == from {
+			m.org.AccessRequests[i].State = event.State
+			m.org.AccessRequests[i].UpdatedAt = event.Time
+			m.org.AccessRequests[i].History = append(request.History, event)
+			if expiresAt != nil {
+				m.org.AccessRequests[i].ExpiresAt = expiresAt
+			}
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (m *mockRepository) GetMembers(ctx context.Context, org_id string) (*mongo_entity.Organization, error) {
+	return &m.org, nil
+}
+
+func (m *mockRepository) GetPolicy(ctx context.Context, org_id string) (*mongo_entity.AccessRequestPolicy, error) {
+	return m.org.AccessRequestPolicy, nil
+}
+
+func (m *mockRepository) UpdatePolicy(ctx context.Context, org_id string, policy mongo_entity.AccessRequestPolicy) error {
+	m.org.AccessRequestPolicy = &policy
+	return nil
+}
+
+type mockUserService struct {
+	user.Service
+	id    string
+	patch user.PatchUserRequest
+}
+
+func (m *mockUserService) Patch(ctx context.Context, org_id string, id string, input user.PatchUserRequest) (user.UserResponse, error) {
+	m.id, m.patch = id, input
+	return user.UserResponse{}, nil
+}
+
+type mockNotifier struct {
+	notifications []Notification
+}
+
+func (m *mockNotifier) Notify(ctx context.Context, notification Notification) {
+	m.notifications = append(m.notifications, notification)
+}
+
+func (m *mockNotifier) last() Notification {
+	return m.notifications[len(m.notifications)-1]
+}
+
+type mockRecorder struct {
+	events []audit.Event
+}
+
+func (m *mockRecorder) Record(ctx context.Context, event audit.Event) {
+	m.events = append(m.events, event)
+}
@@ -0,0 +1,110 @@
+package accessrequest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"go.uber.org/zap"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the webhook body, keyed by
+// the webhook secret, as "sha256=<hex>".
+const SignatureHeader = "X-Cronuseo-Signature"
+
+// Notification is the JSON body sent to the webhook. Approvers are the
+// identifiers of the users who can review the request.
+type Notification struct {
+	Event          string        `json:"event"`
+	OrganizationID string        `json:"organization_id"`
+	Request        AccessRequest `json:"request"`
+	Approvers      []string      `json:"approvers,omitempty"`
+	Time           time.Time     `json:"time"`
+}
+
+// Notifier notifies approvers and requesters of access request changes.
+type Notifier interface {
+	Notify(ctx context.Context, notification Notification)
+}
+
+// Webhook posts notifications to the configured URL.
+type Webhook struct {
+	cfg    config.Webhook
+	client *http.Client
+	logger *zap.Logger
+}
+
+type noopNotifier struct{}
+
+func (noopNotifier) Notify(ctx context.Context, notification Notification) {}
+
+// NewNotifier returns the webhook notifier of the configuration, or a notifier
+// doing nothing when no webhook URL is configured.
+func NewNotifier(cfg config.Webhook, logger *zap.Logger) Notifier {
+
+	if cfg.URL == "" {
+		return noopNotifier{}
+	}
+	return NewWebhook(cfg, logger)
+}
+
+func NewWebhook(cfg config.Webhook, logger *zap.Logger) *Webhook {
+
+	return &Webhook{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}, logger: logger}
+}
+
+// Notify sends the notification in the background, so that slow webhooks do
+// not delay reviews. Failures are logged.
+func (w *Webhook) Notify(ctx context.Context, notification Notification) {
+
+	go func() {
+		if err := w.Send(context.Background(), notification); err != nil {
+			w.logger.Error("Error while sending access request notification.",
+				zap.String("organization_id", notification.OrganizationID),
+				zap.String("event", notification.Event),
+				zap.Error(err))
+		}
+	}()
+}
+
+// Send posts the notification and fails unless the webhook responds with a
+// 2xx status.
+func (w *Webhook) Send(ctx context.Context, notification Notification) error {
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.cfg.Secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.cfg.Secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of the body keyed by the secret.
+func Sign(secret string, body []byte) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
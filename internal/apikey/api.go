@@ -0,0 +1,98 @@
+package apikey
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/util"
+)
+
+func RegisterHandlers(r *echo.Group, service Service) {
+	res := handler{service}
+	router := r.Group("/organizations/:id/api-keys")
+	router.GET("", res.query)
+	router.POST("", res.create)
+	router.DELETE("/:key_id", res.delete)
+	router.POST("/:key_id/rotate", res.rotate)
+}
+
+type handler struct {
+	service Service
+}
+
+// @Description Get all API keys of the organization.
+// @Tags        API Key
+// @Param id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {array}  APIKey
+// @failure     404,500
+// @Router      /organizations/{id}/api-keys [get]
+func (r handler) query(c echo.Context) error {
+
+	keys, err := r.service.Query(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, keys)
+}
+
+// @Description Create API key. The key is only returned in this response.
+// @Tags        API Key
+// @Accept      json
+// @Param id path string true "Organization ID"
+// @Param request body APIKeyCreationRequest true "body"
+// @Produce     json
+// @Success     201 {object}  CreatedAPIKey
+// @failure     400,404,500
+// @Router      /organizations/{id}/api-keys [post]
+func (r handler) create(c echo.Context) error {
+
+	var req APIKeyCreationRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	key, err := r.service.Create(c.Request().Context(), c.Param("id"), req)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusCreated, key)
+}
+
+// @Description Rotate API key. The old key stays valid for the grace period.
+// @Tags        API Key
+// @Accept      json
+// @Param id path string true "Organization ID"
+// @Param key_id path string true "API key ID"
+// @Param request body APIKeyRotationRequest false "body"
+// @Produce     json
+// @Success     201 {object}  CreatedAPIKey
+// @failure     400,404,500
+// @Router      /organizations/{id}/api-keys/{key_id}/rotate [post]
+func (r handler) rotate(c echo.Context) error {
+
+	var req APIKeyRotationRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	key, err := r.service.Rotate(c.Request().Context(), c.Param("id"), c.Param("key_id"), req)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusCreated, key)
+}
+
+// @Description Revoke API key.
+// @Tags        API Key
+// @Param id path string true "Organization ID"
+// @Param key_id path string true "API key ID"
+// @Produce     json
+// @Success     204
+// @failure     404,500
+// @Router      /organizations/{id}/api-keys/{key_id} [delete]
+func (r handler) delete(c echo.Context) error {
+
+	if err := r.service.Delete(c.Request().Context(), c.Param("id"), c.Param("key_id")); err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusNoContent, "")
+}
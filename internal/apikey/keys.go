@@ -0,0 +1,104 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// API key scopes. Admin keys are accepted wherever a key is.
+const (
+	ScopeCheck = "check"
+	ScopeSync  = "sync"
+	ScopeAdmin = "admin"
+)
+
+// DefaultName is the name of the key issued with an organization.
+const DefaultName = "default"
+
+// DefaultGracePeriod is how long a rotated key stays valid by default.
+const DefaultGracePeriod = 24 * time.Hour
+
+const (
+	keyPrefix     = "cro_"
+	prefixLength  = 12
+	touchInterval = time.Minute
+)
+
+// Generate creates a new random key. The plaintext key is returned once and
+// only its hash is kept in the entity.
+func Generate(name string, scopes []string, expiresAt *time.Time) (string, mongo_entity.APIKey, error) {
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", mongo_entity.APIKey{}, err
+	}
+	key := keyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return key, mongo_entity.APIKey{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		Prefix:    key[:prefixLength],
+		Hash:      Hash(key),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// Legacy converts a plaintext organization key into a hashed admin key.
+func Legacy(key string, expiresAt *time.Time) mongo_entity.APIKey {
+
+	prefix := key
+	if len(prefix) > prefixLength {
+		prefix = prefix[:prefixLength]
+	}
+	return mongo_entity.APIKey{
+		ID:        primitive.NewObjectID(),
+		Name:      "legacy",
+		Prefix:    prefix,
+		Hash:      Hash(key),
+		Scopes:    []string{ScopeAdmin},
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+}
+
+// Hash returns the stored form of a key.
+func Hash(key string) string {
+
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Match returns an api_keys filter matching an unexpired key granted the scope.
+func Match(key string, scope string, now time.Time) bson.M {
+
+	return bson.M{"$elemMatch": bson.M{
+		"hash":   Hash(key),
+		"scopes": bson.M{"$in": []string{scope, ScopeAdmin}},
+		"$or":    bson.A{bson.M{"expires_at": nil}, bson.M{"expires_at": bson.M{"$gt": now}}},
+	}}
+}
+
+// Touch records the use of a key of the organizations matching the filter.
+// last_used_at is only written once per minute to keep checks cheap.
+func Touch(ctx context.Context, coll *mongo.Collection, filter bson.M, key string, now time.Time) error {
+
+	touchFilter := bson.M{"api_keys": bson.M{"$elemMatch": bson.M{
+		"hash": Hash(key),
+		"$or":  bson.A{bson.M{"last_used_at": nil}, bson.M{"last_used_at": bson.M{"$lt": now.Add(-touchInterval)}}},
+	}}}
+	for k, v := range filter {
+		touchFilter[k] = v
+	}
+	_, err := coll.UpdateOne(ctx, touchFilter, bson.M{"$set": bson.M{"api_keys.$.last_used_at": now}})
+	return err
+}
@@ -0,0 +1,75 @@
+package apikey
+
+import (
+	"context"
+
+	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SCIMName is the name of the sync scoped key issued as the SCIM token.
+const SCIMName = "scim"
+
+// plaintextFilter matches organizations with a plaintext API key or SCIM token.
+var plaintextFilter = bson.M{"$or": bson.A{
+	bson.M{"api_key": bson.M{"$exists": true}},
+	bson.M{"scim_token": bson.M{"$exists": true}},
+}}
+
+// Migrate hashes the plaintext API keys and SCIM tokens of the organizations
+// into their keys, and returns how many organizations were migrated. The
+// plaintext values are removed.
+func Migrate(ctx context.Context, mongodb *db.MongoDB) (int64, error) {
+
+	coll := mongodb.MongoClient.Database(mongodb.MongoConfig.DBName).Collection(mongodb.MongoConfig.OrganizationCollectionName)
+	projection := bson.M{"api_key": 1, "scim_token": 1}
+	cursor, err := coll.Find(ctx, plaintextFilter, options.Find().SetProjection(projection))
+	if err != nil {
+		return 0, err
+	}
+	var orgs []mongo_entity.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return 0, err
+	}
+	migrated := int64(0)
+	for _, org := range orgs {
+		update := bson.M{"$unset": bson.M{"api_key": "", "scim_token": ""}}
+		if keys := plaintextKeys(org); len(keys) > 0 {
+			update["$push"] = bson.M{"api_keys": bson.M{"$each": keys}}
+		}
+		// The plaintext values are matched so that concurrent migrations do
+		// not add the keys twice.
+		filter := bson.M{"_id": org.ID, "api_key": org.API_KEY, "scim_token": org.SCIMToken}
+		if org.API_KEY == "" {
+			filter["api_key"] = bson.M{"$in": bson.A{nil, ""}}
+		}
+		if org.SCIMToken == "" {
+			filter["scim_token"] = bson.M{"$in": bson.A{nil, ""}}
+		}
+		result, err := coll.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return migrated, err
+		}
+		migrated += result.ModifiedCount
+	}
+	return migrated, nil
+}
+
+// plaintextKeys returns the hashed keys of the plaintext API key, an admin
+// key, and of the SCIM token, a sync key, of the organization.
+func plaintextKeys(org mongo_entity.Organization) []mongo_entity.APIKey {
+
+	keys := []mongo_entity.APIKey{}
+	if org.API_KEY != "" {
+		keys = append(keys, Legacy(org.API_KEY, nil))
+	}
+	if org.SCIMToken != "" {
+		key := Legacy(org.SCIMToken, nil)
+		key.Name = SCIMName
+		key.Scopes = []string{ScopeSync}
+		keys = append(keys, key)
+	}
+	return keys
+}
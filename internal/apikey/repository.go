@@ -0,0 +1,168 @@
+package apikey
+
+import (
+	"context"
+	"time"
+
+	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Repository interface {
+	Query(ctx context.Context, org_id string) ([]mongo_entity.APIKey, error)
+	Get(ctx context.Context, org_id string, id string) (*mongo_entity.APIKey, error)
+	Create(ctx context.Context, org_id string, key mongo_entity.APIKey) error
+	Expire(ctx context.Context, org_id string, id string, expiresAt time.Time) error
+	Delete(ctx context.Context, org_id string, id string) error
+	CheckOrgExistById(ctx context.Context, org_id string) (bool, error)
+}
+
+type repository struct {
+	mongoClient *mongo.Client
+	mongoColl   *mongo.Collection
+}
+
+func NewRepository(mongodb *db.MongoDB) Repository {
+
+	orgCollection := mongodb.MongoClient.Database(mongodb.MongoConfig.DBName).Collection(mongodb.MongoConfig.OrganizationCollectionName)
+
+	return repository{mongoClient: mongodb.MongoClient, mongoColl: orgCollection}
+}
+
+// Get all API keys of the organization.
+func (r repository) Query(ctx context.Context, org_id string) ([]mongo_entity.APIKey, error) {
+
+	ctx, span := telemetry.Repository(ctx, "apikey", "Query")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": orgId}
+	projection := bson.M{"api_keys": 1}
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	var org mongo_entity.Organization
+	if err := result.Decode(&org); err != nil {
+		return nil, err
+	}
+	return org.APIKeys, nil
+}
+
+// Get API key by id.
+func (r repository) Get(ctx context.Context, org_id string, id string) (*mongo_entity.APIKey, error) {
+
+	ctx, span := telemetry.Repository(ctx, "apikey", "Get")
+	defer span.End()
+
+	keys, err := r.Query(ctx, org_id)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if key.ID.Hex() == id {
+			return &key, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+// Add a new API key to the organization.
+func (r repository) Create(ctx context.Context, org_id string, key mongo_entity.APIKey) error {
+
+	ctx, span := telemetry.Repository(ctx, "apikey", "Create")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": orgId}
+	update := bson.M{"$push": bson.M{"api_keys": key}}
+	result, err := r.mongoColl.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Set the expiry time of an API key.
+func (r repository) Expire(ctx context.Context, org_id string, id string, expiresAt time.Time) error {
+
+	ctx, span := telemetry.Repository(ctx, "apikey", "Expire")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return err
+	}
+	keyId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": orgId, "api_keys._id": keyId}
+	update := bson.M{"$set": bson.M{"api_keys.$.expires_at": expiresAt}}
+	result, err := r.mongoColl.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Delete API key.
+func (r repository) Delete(ctx context.Context, org_id string, id string) error {
+
+	ctx, span := telemetry.Repository(ctx, "apikey", "Delete")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return err
+	}
+	keyId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": orgId}
+	update := bson.M{"$pull": bson.M{"api_keys": bson.M{"_id": keyId}}}
+	result, err := r.mongoColl.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Check if organization exists by id.
+func (r repository) CheckOrgExistById(ctx context.Context, org_id string) (bool, error) {
+
+	ctx, span := telemetry.Repository(ctx, "apikey", "CheckOrgExistById")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return false, err
+	}
+	count, err := r.mongoColl.CountDocuments(ctx, bson.M{"_id": orgId})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
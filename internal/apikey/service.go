@@ -0,0 +1,194 @@
+package apikey
+
+import (
+	"context"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.uber.org/zap"
+)
+
+type Service interface {
+	Query(ctx context.Context, org_id string) ([]APIKey, error)
+	Create(ctx context.Context, org_id string, req APIKeyCreationRequest) (CreatedAPIKey, error)
+	Rotate(ctx context.Context, org_id string, id string, req APIKeyRotationRequest) (CreatedAPIKey, error)
+	Delete(ctx context.Context, org_id string, id string) error
+}
+
+type APIKey struct {
+	mongo_entity.APIKey
+}
+
+// CreatedAPIKey carries the plaintext key, which is only returned once.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type APIKeyCreationRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (m APIKeyCreationRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Name, validation.Required, validation.Length(1, 64)),
+		validation.Field(&m.Scopes, validation.Required, validation.Each(validation.In(ScopeCheck, ScopeSync, ScopeAdmin))),
+	)
+}
+
+// APIKeyRotationRequest controls how long the rotated key stays valid. The
+// grace period is a duration such as "1h"; "0s" revokes the old key at once.
+type APIKeyRotationRequest struct {
+	GracePeriod string     `json:"grace_period"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+type service struct {
+	repo        Repository
+	gracePeriod time.Duration
+	logger      *zap.Logger
+}
+
+// NewService creates the API key service. gracePeriod is the default time a
+// rotated key stays valid.
+func NewService(repo Repository, gracePeriod time.Duration, logger *zap.Logger) Service {
+
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultGracePeriod
+	}
+	return service{repo: repo, gracePeriod: gracePeriod, logger: logger}
+}
+
+// Get all API keys of the organization.
+func (s service) Query(ctx context.Context, org_id string) ([]APIKey, error) {
+
+	ctx, span := telemetry.Start(ctx, "apikey.service.Query")
+	defer span.End()
+
+	exists, _ := s.repo.CheckOrgExistById(ctx, org_id)
+	if !exists {
+		s.logger.Debug("Organization not exists.", zap.String("organization_id", org_id))
+		return []APIKey{}, &util.NotFoundError{Path: "Organization " + org_id + " not exists."}
+	}
+	keys, err := s.repo.Query(ctx, org_id)
+	if err != nil {
+		s.logger.Error("Error while retrieving API keys.", zap.String("organization_id", org_id))
+		return []APIKey{}, err
+	}
+	result := []APIKey{}
+	for _, key := range keys {
+		result = append(result, APIKey{key})
+	}
+	return result, nil
+}
+
+// Create new API key.
+func (s service) Create(ctx context.Context, org_id string, req APIKeyCreationRequest) (CreatedAPIKey, error) {
+
+	ctx, span := telemetry.Start(ctx, "apikey.service.Create")
+	defer span.End()
+
+	if err := req.Validate(); err != nil {
+		s.logger.Debug("Invalid API key creation request.", zap.Error(err))
+		return CreatedAPIKey{}, util.NewValidationError("Invalid input for API key.", err)
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return CreatedAPIKey{}, &util.InvalidInputError{Message: "API key expiry must be in the future."}
+	}
+	exists, _ := s.repo.CheckOrgExistById(ctx, org_id)
+	if !exists {
+		s.logger.Debug("Organization not exists.", zap.String("organization_id", org_id))
+		return CreatedAPIKey{}, &util.NotFoundError{Path: "Organization " + org_id + " not exists."}
+	}
+
+	key, entity, err := Generate(req.Name, req.Scopes, utc(req.ExpiresAt))
+	if err != nil {
+		return CreatedAPIKey{}, err
+	}
+	if err := s.repo.Create(ctx, org_id, entity); err != nil {
+		s.logger.Error("Error while creating API key.", zap.String("organization_id", org_id))
+		return CreatedAPIKey{}, err
+	}
+	return CreatedAPIKey{APIKey: APIKey{entity}, Key: key}, nil
+}
+
+// Rotate issues a replacement for an API key with the same name and scopes.
+// The old key stays valid for the grace period so clients can switch over.
+func (s service) Rotate(ctx context.Context, org_id string, id string, req APIKeyRotationRequest) (CreatedAPIKey, error) {
+
+	ctx, span := telemetry.Start(ctx, "apikey.service.Rotate")
+	defer span.End()
+
+	gracePeriod := s.gracePeriod
+	if req.GracePeriod != "" {
+		d, err := time.ParseDuration(req.GracePeriod)
+		if err != nil || d < 0 {
+			return CreatedAPIKey{}, &util.InvalidInputError{Message: "Invalid API key grace period " + req.GracePeriod + "."}
+		}
+		gracePeriod = d
+	}
+	old, err := s.repo.Get(ctx, org_id, id)
+	if err != nil {
+		s.logger.Debug("API key not exists.", zap.String("organization_id", org_id), zap.String("api_key_id", id))
+		return CreatedAPIKey{}, &util.NotFoundError{Path: "API key " + id + " not exists."}
+	}
+
+	now := time.Now().UTC()
+	// Keep the lifetime of keys issued with an expiry.
+	expiresAt := utc(req.ExpiresAt)
+	if expiresAt == nil && old.ExpiresAt != nil {
+		renewed := now.Add(old.ExpiresAt.Sub(old.CreatedAt))
+		expiresAt = &renewed
+	}
+	if expiresAt != nil && !expiresAt.After(now) {
+		return CreatedAPIKey{}, &util.InvalidInputError{Message: "API key expiry must be in the future."}
+	}
+
+	key, entity, err := Generate(old.Name, old.Scopes, expiresAt)
+	if err != nil {
+		return CreatedAPIKey{}, err
+	}
+	if err := s.repo.Create(ctx, org_id, entity); err != nil {
+		s.logger.Error("Error while creating API key.", zap.String("organization_id", org_id))
+		return CreatedAPIKey{}, err
+	}
+	graceUntil := now.Add(gracePeriod)
+	if old.ExpiresAt == nil || old.ExpiresAt.After(graceUntil) {
+		if err := s.repo.Expire(ctx, org_id, id, graceUntil); err != nil {
+			s.logger.Error("Error while expiring rotated API key.", zap.String("organization_id", org_id), zap.String("api_key_id", id))
+			return CreatedAPIKey{}, err
+		}
+	}
+	return CreatedAPIKey{APIKey: APIKey{entity}, Key: key}, nil
+}
+
+// Delete revokes an API key immediately.
+func (s service) Delete(ctx context.Context, org_id string, id string) error {
+
+	ctx, span := telemetry.Start(ctx, "apikey.service.Delete")
+	defer span.End()
+
+	if _, err := s.repo.Get(ctx, org_id, id); err != nil {
+		s.logger.Debug("API key not exists.", zap.String("organization_id", org_id), zap.String("api_key_id", id))
+		return &util.NotFoundError{Path: "API key " + id + " not exists."}
+	}
+	if err := s.repo.Delete(ctx, org_id, id); err != nil {
+		s.logger.Error("Error while deleting API key.", zap.String("organization_id", org_id), zap.String("api_key_id", id))
+		return err
+	}
+	return nil
+}
+
+func utc(t *time.Time) *time.Time {
+
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
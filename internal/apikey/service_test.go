@@ -0,0 +1,131 @@
+package apikey
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+func TestService(t *testing.T) {
+
+	orgId := primitive.NewObjectID().Hex()
+	repo := &mockRepository{orgs: map[string][]mongo_entity.APIKey{orgId: {}}}
+	s := NewService(repo, time.Hour, zap.NewNop())
+	ctx := context.Background()
+
+	// validation errors
+	_, err := s.Create(ctx, orgId, APIKeyCreationRequest{Name: "ci", Scopes: []string{"write"}})
+	assert.IsType(t, &util.InvalidInputError{}, err)
+	past := time.Now().Add(-time.Minute)
+	_, err = s.Create(ctx, orgId, APIKeyCreationRequest{Name: "ci", Scopes: []string{ScopeCheck}, ExpiresAt: &past})
+	assert.IsType(t, &util.InvalidInputError{}, err)
+	_, err = s.Create(ctx, primitive.NewObjectID().Hex(), APIKeyCreationRequest{Name: "ci", Scopes: []string{ScopeCheck}})
+	assert.IsType(t, &util.NotFoundError{}, err)
+
+	// the plaintext key is returned once and only its hash is stored
+	expiresAt := time.Now().Add(90 * 24 * time.Hour)
+	created, err := s.Create(ctx, orgId, APIKeyCreationRequest{Name: "ci", Scopes: []string{ScopeCheck}, ExpiresAt: &expiresAt})
+	assert.Nil(t, err)
+	assert.True(t, len(created.Key) > prefixLength)
+	assert.Equal(t, created.Key[:prefixLength], created.Prefix)
+	keys, _ := s.Query(ctx, orgId)
+	assert.Equal(t, 1, len(keys))
+	assert.Equal(t, Hash(created.Key), keys[0].Hash)
+
+	// rotation keeps the old key for the grace period and renews the lifetime
+	rotated, err := s.Rotate(ctx, orgId, created.ID.Hex(), APIKeyRotationRequest{GracePeriod: "10m"})
+	assert.Nil(t, err)
+	assert.NotEqual(t, created.Key, rotated.Key)
+	assert.Equal(t, []string{ScopeCheck}, rotated.Scopes)
+	assert.WithinDuration(t, time.Now().Add(90*24*time.Hour), *rotated.ExpiresAt, time.Minute)
+	old, _ := repo.Get(ctx, orgId, created.ID.Hex())
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), *old.ExpiresAt, time.Minute)
+
+	_, err = s.Rotate(ctx, orgId, rotated.ID.Hex(), APIKeyRotationRequest{GracePeriod: "soon"})
+	assert.IsType(t, &util.InvalidInputError{}, err)
+
+	// revoke
+	assert.Nil(t, s.Delete(ctx, orgId, created.ID.Hex()))
+	assert.IsType(t, &util.NotFoundError{}, s.Delete(ctx, orgId, created.ID.Hex()))
+	keys, _ = s.Query(ctx, orgId)
+	assert.Equal(t, 1, len(keys))
+}
+
+func TestLegacy(t *testing.T) {
+
+	assert.Equal(t, Hash("key"), Legacy("key", nil).Hash)
+	assert.Equal(t, []string{ScopeAdmin}, Legacy("key", nil).Scopes)
+}
+
+type mockRepository struct {
+	orgs map[string][]mongo_entity.APIKey
+}
+
+func (m *mockRepository) Query(ctx context.Context, org_id string) ([]mongo_entity.APIKey, error) {
+	keys, ok := m.orgs[org_id]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	return keys, nil
+}
+
+func (m *mockRepository) Get(ctx context.Context, org_id string, id string) (*mongo_entity.APIKey, error) {
+	for _, key := range m.orgs[org_id] {
+		if key.ID.Hex() == id {
+			return &key, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *mockRepository) Create(ctx context.Context, org_id string, key mongo_entity.APIKey) error {
+	m.orgs[org_id] = append(m.orgs[org_id], key)
+	return nil
+}
+
+func (m *mockRepository) Expire(ctx context.Context, org_id string, id string, expiresAt time.Time) error {
+	for i, key := range m.orgs[org_id] {
+		if key.ID.Hex() == id {
+			m.orgs[org_id][i].ExpiresAt = &expiresAt
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (m *mockRepository) Delete(ctx context.Context, org_id string, id string) error {
+	keys := []mongo_entity.APIKey{}
+	for _, key := range m.orgs[org_id] {
+		if key.ID.Hex() != id {
+			keys = append(keys, key)
+		}
+	}
+	m.orgs[org_id] = keys
+	return nil
+}
+
+func (m *mockRepository) CheckOrgExistById(ctx context.Context, org_id string) (bool, error) {
+	_, ok := m.orgs[org_id]
+	return ok, nil
+}
+
+func TestPlaintextKeys(t *testing.T) {
+
+	assert.Empty(t, plaintextKeys(mongo_entity.Organization{}))
+
+	keys := plaintextKeys(mongo_entity.Organization{API_KEY: "legacy-key", SCIMToken: "scim-token"})
+	assert.Equal(t, 2, len(keys))
+	assert.Equal(t, Hash("legacy-key"), keys[0].Hash)
+	assert.Equal(t, []string{ScopeAdmin}, keys[0].Scopes)
+	assert.Equal(t, SCIMName, keys[1].Name)
+	assert.Equal(t, Hash("scim-token"), keys[1].Hash)
+	assert.Equal(t, []string{ScopeSync}, keys[1].Scopes)
+	assert.Nil(t, keys[1].ExpiresAt)
+}
@@ -0,0 +1,128 @@
+package assignment
+
+import (
+	"context"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Grant fields of the subjects of time-bound assignments.
+const (
+	RoleGrants  = "role_grants"
+	GroupGrants = "group_grants"
+)
+
+// Window is the validity of assignments made by a request. The zero window is
+// permanent.
+type Window struct {
+	ValidFrom  *time.Time
+	ValidUntil *time.Time
+}
+
+// Bounded reports whether the window limits the assignments.
+func (w Window) Bounded() bool {
+	return w.ValidFrom != nil || w.ValidUntil != nil
+}
+
+// Validate checks that the window ends in the future and after it starts.
+func (w Window) Validate(now time.Time) error {
+
+	if w.ValidUntil == nil {
+		return nil
+	}
+	if !w.ValidUntil.After(now) {
+		return &util.InvalidInputError{Message: "Assignment valid_until must be in the future."}
+	}
+	if w.ValidFrom != nil && !w.ValidUntil.After(*w.ValidFrom) {
+		return &util.InvalidInputError{Message: "Assignment valid_until must be after valid_from."}
+	}
+	return nil
+}
+
+// Grants returns the grants of the window for the assigned ids.
+func (w Window) Grants(ids []primitive.ObjectID) []mongo_entity.Grant {
+
+	grants := make([]mongo_entity.Grant, 0, len(ids))
+	for _, id := range ids {
+		grants = append(grants, mongo_entity.Grant{ID: id, ValidFrom: utc(w.ValidFrom), ValidUntil: utc(w.ValidUntil)})
+	}
+	return grants
+}
+
+// Active filters the assigned ids by their grants, dropping the ones whose
+// grant is not valid at the time.
+func Active(ids []primitive.ObjectID, grants []mongo_entity.Grant, now time.Time) []primitive.ObjectID {
+
+	if len(grants) == 0 {
+		return ids
+	}
+	inactive := map[primitive.ObjectID]bool{}
+	for _, grant := range grants {
+		if !grant.Active(now) {
+			inactive[grant.ID] = true
+		}
+	}
+	active := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if !inactive[id] {
+			active = append(active, id)
+		}
+	}
+	return active
+}
+
+// SetGrants replaces the grants of the assigned ids on the subject, an element
+// of the subjects array ("users" or "groups") of the organization. A permanent
+// window only removes them.
+func SetGrants(ctx context.Context, coll *mongo.Collection, orgId primitive.ObjectID, subjects string, subjectId primitive.ObjectID, field string, ids []primitive.ObjectID, window Window) error {
+
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := RemoveGrants(ctx, coll, orgId, subjects, []primitive.ObjectID{subjectId}, field, ids); err != nil {
+		return err
+	}
+	if !window.Bounded() {
+		return nil
+	}
+	filter := bson.M{"_id": orgId, subjects + "._id": subjectId}
+	update := bson.M{"$push": bson.M{subjects + ".$." + field: bson.M{"$each": window.Grants(ids)}}}
+	_, err := coll.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// RemoveGrants removes the grants of the assigned ids from the subjects, or
+// from every subject when no subject ids are given. It is called whenever
+// assignments are removed, so that re-adding them makes them permanent.
+func RemoveGrants(ctx context.Context, coll *mongo.Collection, orgId primitive.ObjectID, subjects string, subjectIds []primitive.ObjectID, field string, ids []primitive.ObjectID) error {
+
+	if len(ids) == 0 {
+		return nil
+	}
+	pull := bson.M{"$pull": bson.M{subjects + ".$[]." + field: bson.M{"_id": bson.M{"$in": ids}}}}
+	opts := options.Update()
+	if subjectIds != nil {
+		if len(subjectIds) == 0 {
+			return nil
+		}
+		pull = bson.M{"$pull": bson.M{subjects + ".$[subject]." + field: bson.M{"_id": bson.M{"$in": ids}}}}
+		opts.SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"subject._id": bson.M{"$in": subjectIds}}}})
+	}
+	_, err := coll.UpdateOne(ctx, bson.M{"_id": orgId}, pull, opts)
+	return err
+}
+
+func utc(t *time.Time) *time.Time {
+
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
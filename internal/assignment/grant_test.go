@@ -0,0 +1,49 @@
+package assignment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWindow(t *testing.T) {
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	later := now.Add(time.Hour)
+	muchLater := now.Add(2 * time.Hour)
+
+	assert.False(t, Window{}.Bounded())
+	assert.Nil(t, Window{}.Validate(now))
+	assert.True(t, Window{ValidUntil: &later}.Bounded())
+	assert.Nil(t, Window{ValidFrom: &past, ValidUntil: &later}.Validate(now))
+	assert.Nil(t, Window{ValidFrom: &later}.Validate(now))
+	assert.NotNil(t, Window{ValidUntil: &past}.Validate(now))
+	assert.NotNil(t, Window{ValidFrom: &muchLater, ValidUntil: &later}.Validate(now))
+
+	local := later.In(time.FixedZone("IST", 5*3600+1800))
+	id := primitive.NewObjectID()
+	grants := Window{ValidUntil: &local}.Grants([]primitive.ObjectID{id})
+	assert.Equal(t, []mongo_entity.Grant{{ID: id, ValidUntil: &later}}, grants)
+}
+
+func TestActive(t *testing.T) {
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	later := now.Add(time.Hour)
+	permanent, current, expired, upcoming := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	ids := []primitive.ObjectID{permanent, current, expired, upcoming}
+
+	assert.Equal(t, ids, Active(ids, nil, now))
+	assert.Equal(t, []primitive.ObjectID{permanent, current}, Active(ids, []mongo_entity.Grant{
+		{ID: current, ValidFrom: &past, ValidUntil: &later},
+		{ID: expired, ValidUntil: &past},
+		{ID: upcoming, ValidFrom: &later},
+	}, now))
+	// a grant ends at valid_until
+	assert.False(t, mongo_entity.Grant{ValidUntil: &now}.Active(now))
+}
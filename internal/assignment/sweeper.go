@@ -0,0 +1,169 @@
+package assignment
+
+import (
+	"context"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/audit"
+	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// link is a kind of time-bound assignment. The subject array holds the link
+// and its grants, the target array the reverse link.
+type link struct {
+	subjects string
+	subject  string
+	field    string
+	links    string
+	targets  string
+	target   string
+	backlink string
+}
+
+var (
+	userRoles  = link{subjects: "users", subject: "user", field: RoleGrants, links: "roles", targets: "roles", target: "role", backlink: "users"}
+	userGroups = link{subjects: "users", subject: "user", field: GroupGrants, links: "groups", targets: "groups", target: "group", backlink: "users"}
+	groupRoles = link{subjects: "groups", subject: "group", field: RoleGrants, links: "roles", targets: "roles", target: "role", backlink: "groups"}
+)
+
+// Sweeper removes the assignments whose grants expired and records an audit
+// event for each. Sweeps of several replicas are safe, an assignment is only
+// removed, and audited, once.
+type Sweeper struct {
+	coll     *mongo.Collection
+	recorder audit.Recorder
+	logger   *zap.Logger
+}
+
+func NewSweeper(mongodb *db.MongoDB, recorder audit.Recorder, logger *zap.Logger) *Sweeper {
+
+	coll := mongodb.MongoClient.Database(mongodb.MongoConfig.DBName).Collection(mongodb.MongoConfig.OrganizationCollectionName)
+	return &Sweeper{coll: coll, recorder: recorder, logger: logger}
+}
+
+// Run sweeps at the interval until the context is done.
+func (s *Sweeper) Run(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := s.Sweep(ctx, time.Now().UTC())
+			if err != nil && ctx.Err() == nil {
+				s.logger.Error("Error while removing expired assignments.", zap.Error(err))
+			}
+			if removed > 0 {
+				s.logger.Info("Removed expired assignments.", zap.Int("assignments", removed))
+			}
+		}
+	}
+}
+
+// Sweep removes the assignments expired at the time and returns how many were
+// removed.
+func (s *Sweeper) Sweep(ctx context.Context, now time.Time) (int, error) {
+
+	ctx, span := telemetry.Repository(ctx, "assignment", "Sweep")
+	defer span.End()
+
+	expired := bson.M{"valid_until": bson.M{"$lte": now}}
+	filter := bson.M{"$or": bson.A{
+		bson.M{"users.role_grants": bson.M{"$elemMatch": expired}},
+		bson.M{"users.group_grants": bson.M{"$elemMatch": expired}},
+		bson.M{"groups.role_grants": bson.M{"$elemMatch": expired}},
+	}}
+	projection := bson.M{
+		"users._id": 1, "users.identifier": 1, "users.role_grants": 1, "users.group_grants": 1,
+		"groups._id": 1, "groups.identifier": 1, "groups.role_grants": 1,
+	}
+	cursor, err := s.coll.Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	removed := 0
+	for cursor.Next(ctx) {
+		var org mongo_entity.Organization
+		if err := cursor.Decode(&org); err != nil {
+			return removed, err
+		}
+		for _, user := range org.Users {
+			n, err := s.expire(ctx, org.ID, userRoles, user.ID, user.Identifier, user.RoleGrants, now)
+			removed += n
+			if err != nil {
+				return removed, err
+			}
+			n, err = s.expire(ctx, org.ID, userGroups, user.ID, user.Identifier, user.GroupGrants, now)
+			removed += n
+			if err != nil {
+				return removed, err
+			}
+		}
+		for _, group := range org.Groups {
+			n, err := s.expire(ctx, org.ID, groupRoles, group.ID, group.Identifier, group.RoleGrants, now)
+			removed += n
+			if err != nil {
+				return removed, err
+			}
+		}
+	}
+	return removed, cursor.Err()
+}
+
+// expire removes the expired assignments of a subject on both sides, with
+// their grants. The grant must still be expired, so that a window extended
+// meanwhile is kept.
+func (s *Sweeper) expire(ctx context.Context, orgId primitive.ObjectID, l link, subjectId primitive.ObjectID, identifier string, grants []mongo_entity.Grant, now time.Time) (int, error) {
+
+	removed := 0
+	for _, grant := range grants {
+		if grant.ValidUntil == nil || grant.ValidUntil.After(now) {
+			continue
+		}
+		filter := bson.M{"_id": orgId, l.subjects: bson.M{"$elemMatch": bson.M{
+			"_id":   subjectId,
+			l.field: bson.M{"$elemMatch": bson.M{"_id": grant.ID, "valid_until": bson.M{"$lte": now}}},
+		}}}
+		update := bson.M{"$pull": bson.M{
+			l.subjects + ".$[subject]." + l.links:  grant.ID,
+			l.subjects + ".$[subject]." + l.field:  bson.M{"_id": grant.ID},
+			l.targets + ".$[target]." + l.backlink: subjectId,
+		}}
+		opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{
+			bson.M{"subject._id": subjectId},
+			bson.M{"target._id": grant.ID},
+		}})
+		result, err := s.coll.UpdateOne(ctx, filter, update, opts)
+		if err != nil {
+			return removed, err
+		}
+		if result.ModifiedCount == 0 {
+			continue
+		}
+		removed++
+		s.recorder.Record(ctx, audit.Event{
+			Time:           now,
+			OrganizationID: orgId.Hex(),
+			Actor:          audit.SystemActor,
+			Action:         audit.ActionAssignmentExpired,
+			Subject:        l.subject + ":" + subjectId.Hex(),
+			Target:         l.target + ":" + grant.ID.Hex(),
+			Details: map[string]string{
+				l.subject + "_identifier": identifier,
+				"valid_until":             grant.ValidUntil.UTC().Format(time.RFC3339),
+			},
+		})
+	}
+	return removed, nil
+}
@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Actions of audit events.
+const (
+	ActionAssignmentExpired      = "assignment.expired"
+	ActionAccessRequestCreated   = "access_request.created"
+	ActionAccessRequestApproved  = "access_request.approved"
+	ActionAccessRequestDenied    = "access_request.denied"
+	ActionAccessRequestCancelled = "access_request.cancelled"
+	ActionAccessRequestFailed    = "access_request.failed"
+)
+
+// SystemActor is the actor of changes cronuseo makes on its own, such as
+// removing expired assignments.
+const SystemActor = "system"
+
+// Event is an audit record of a change to an organization. Subject and Target
+// are typed references, e.g. "user:<id>" and "role:<id>".
+type Event struct {
+	Time           time.Time         `json:"time"`
+	OrganizationID string            `json:"organization_id"`
+	Actor          string            `json:"actor"`
+	Action         string            `json:"action"`
+	Subject        string            `json:"subject,omitempty"`
+	Target         string            `json:"target,omitempty"`
+	Details        map[string]string `json:"details,omitempty"`
+}
+
+// Recorder records audit events.
+type Recorder interface {
+	Record(ctx context.Context, event Event)
+}
+
+type logRecorder struct {
+	logger *zap.Logger
+}
+
+// NewRecorder returns a recorder writing events as structured log entries of
+// the "audit" logger, which log pipelines can route to the audit store.
+func NewRecorder(logger *zap.Logger) Recorder {
+
+	return logRecorder{logger: logger.Named("audit")}
+}
+
+func (r logRecorder) Record(ctx context.Context, event Event) {
+
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	fields := []zap.Field{
+		zap.Time("time", event.Time),
+		zap.String("organization_id", event.OrganizationID),
+		zap.String("actor", event.Actor),
+		zap.String("action", event.Action),
+	}
+	if event.Subject != "" {
+		fields = append(fields, zap.String("subject", event.Subject))
+	}
+	if event.Target != "" {
+		fields = append(fields, zap.String("target", event.Target))
+	}
+	for key, value := range event.Details {
+		fields = append(fields, zap.String("details."+key, value))
+	}
+	r.logger.Info("Audit event.", fields...)
+}
@@ -0,0 +1,35 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRecorder(t *testing.T) {
+
+	core, logs := observer.New(zap.InfoLevel)
+	recorder := NewRecorder(zap.New(core))
+	recorder.Record(context.Background(), Event{
+		OrganizationID: "org",
+		Actor:          SystemActor,
+		Action:         ActionAssignmentExpired,
+		Subject:        "user:u1",
+		Target:         "role:r1",
+		Details:        map[string]string{"valid_until": "2024-01-01T00:00:00Z"},
+	})
+
+	entries := logs.All()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "audit", entries[0].LoggerName)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "org", fields["organization_id"])
+	assert.Equal(t, ActionAssignmentExpired, fields["action"])
+	assert.Equal(t, "user:u1", fields["subject"])
+	assert.Equal(t, "role:r1", fields["target"])
+	assert.Equal(t, "2024-01-01T00:00:00Z", fields["details.valid_until"])
+	assert.NotNil(t, fields["time"])
+}
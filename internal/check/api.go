@@ -1,10 +1,10 @@
 package check
 
 import (
-	"context"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/limits"
 	"github.com/shashimalcse/cronuseo/internal/util"
 )
 
@@ -34,8 +34,11 @@ func (r permission_service) check(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
 	}
 
-	allow, err := r.service.Check(context.Background(), c.Param("org"), input, api_key, false)
+	allow, err := r.service.Check(c.Request().Context(), c.Param("org"), input, api_key, false)
 	if err != nil {
+		if e, ok := err.(*util.RateLimitError); ok {
+			c.Response().Header().Set("Retry-After", limits.RetryAfter(e.RetryAfter))
+		}
 		return util.HandleError(err)
 	}
 
@@ -0,0 +1,135 @@
+package check
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// Client certificate authentication modes of the check server.
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// ServerCredentials builds the TLS credentials of the gRPC check server.
+// Client certificates are verified against the client CA unless client
+// authentication is disabled.
+func ServerCredentials(cfg config.CheckServerTLS) (credentials.TransportCredentials, error) {
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid check server certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	switch cfg.ClientAuth {
+	case "", ClientAuthNone:
+		if len(cfg.Clients) > 0 {
+			return nil, errors.New("client identities require client_auth optional or require")
+		}
+		return credentials.NewTLS(tlsConfig), nil
+	case ClientAuthOptional:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid client_auth %q", cfg.ClientAuth)
+	}
+	if cfg.ClientCAFile == "" {
+		return nil, errors.New("client_ca_file is required to verify client certificates")
+	}
+	data, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %s", cfg.ClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// ClientIdentities maps verified client certificates to the organizations
+// they are allowed to check.
+type ClientIdentities struct {
+	clients []config.CheckClient
+}
+
+// NewClientIdentities creates the client certificate mapping.
+func NewClientIdentities(clients []config.CheckClient) (*ClientIdentities, error) {
+
+	for _, client := range clients {
+		if client.CommonName == "" && client.URI == "" {
+			return nil, fmt.Errorf("client %s: common_name or uri is required", client.Name)
+		}
+		if len(client.Organizations) == 0 {
+			return nil, fmt.Errorf("client %s: organizations are required", client.Name)
+		}
+	}
+	return &ClientIdentities{clients: clients}, nil
+}
+
+// Lookup returns the client of the verified peer certificate of the request.
+func (c *ClientIdentities) Lookup(ctx context.Context) (config.CheckClient, bool) {
+
+	if c == nil {
+		return config.CheckClient{}, false
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return config.CheckClient{}, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return config.CheckClient{}, false
+	}
+	return c.match(info.State.VerifiedChains[0][0])
+}
+
+func (c *ClientIdentities) match(cert *x509.Certificate) (config.CheckClient, bool) {
+
+	for _, client := range c.clients {
+		if client.CommonName != "" && client.CommonName != cert.Subject.CommonName {
+			continue
+		}
+		if client.URI != "" && !hasURI(cert, client.URI) {
+			continue
+		}
+		return client, true
+	}
+	return config.CheckClient{}, false
+}
+
+// Allowed reports whether the client may check the organization.
+func Allowed(client config.CheckClient, org_identifier string) bool {
+
+	for _, org := range client.Organizations {
+		if org == "*" || org == org_identifier {
+			return true
+		}
+	}
+	return false
+}
+
+func hasURI(cert *x509.Certificate, uri string) bool {
+
+	for _, u := range cert.URIs {
+		if u.String() == uri {
+			return true
+		}
+	}
+	return false
+}
@@ -2,42 +2,67 @@ package check
 
 import (
 	"context"
-	"errors"
 
+	"github.com/shashimalcse/cronuseo/internal/limits"
 	"github.com/shashimalcse/cronuseo/internal/util"
 	"github.com/shashimalcse/cronuseo/proto"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 )
 
-func NewGrpcService(service Service, logger *zap.Logger) proto.CheckServer {
+// NewGrpcService creates the gRPC check service. Callers authenticate with a
+// client certificate mapped in identities, or with the API_KEY metadata.
+// identities may be nil when mutual TLS is not configured.
+func NewGrpcService(service Service, identities *ClientIdentities, logger *zap.Logger) proto.CheckServer {
 
-	return grpcService{service: service, logger: logger}
+	return grpcService{service: service, identities: identities, logger: logger}
 }
 
 type grpcService struct {
-	service Service
-	logger  *zap.Logger
+	service    Service
+	identities *ClientIdentities
+	logger     *zap.Logger
 }
 
 func (s grpcService) Check(ctx context.Context, req *proto.GrpcCheckRequest) (*proto.GrpcCheckResponse, error) {
 
-	s.logger.Info("GRPC method : Check", zap.String("method", "Check"))
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return nil, errors.New("missing metadata from request")
+	s.logger.Debug("GRPC method : Check", zap.String("method", "Check"))
+	if req.Organization == "" || req.Username == "" || req.Resource == "" || req.Action == "" {
+		return nil, util.GrpcError(&util.InvalidInputError{Message: "organization, username, resource and action are required"})
 	}
-	apiKey := md.Get("API_KEY")[0]
-
 	input := CheckRequest{
 		Identifier: req.Username,
 		Action:     req.Action,
 		Resource:   req.Resource,
 	}
 
-	allow, err := s.service.Check(context.Background(), req.Organization, input, apiKey, false)
+	var allow CheckResponse
+	var err error
+	if client, ok := s.identities.Lookup(ctx); ok {
+		if !Allowed(client, req.Organization) {
+			s.logger.Debug("Client certificate is not allowed for the organization.",
+				zap.String("client", client.Name), zap.String("organization", req.Organization))
+			return nil, util.GrpcError(&util.PermissionDeniedError{Message: "client is not allowed to check this organization"})
+		}
+		allow, err = s.service.Evaluate(ctx, req.Organization, input)
+	} else {
+		apiKey := ""
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("API_KEY"); len(values) > 0 {
+				apiKey = values[0]
+			}
+		}
+		if apiKey == "" {
+			return nil, util.GrpcError(&util.UnauthorizedError{Message: "missing API_KEY metadata or client certificate"})
+		}
+		allow, err = s.service.Check(ctx, req.Organization, input, apiKey, false)
+	}
 	if err != nil {
-		return nil, util.HandleError(err)
+		if e, ok := err.(*util.RateLimitError); ok {
+			grpc.SetHeader(ctx, metadata.Pairs("retry-after", limits.RetryAfter(e.RetryAfter)))
+		}
+		return nil, util.GrpcError(err)
 	}
 
 	return &proto.GrpcCheckResponse{Allow: allow.Allowed}, nil
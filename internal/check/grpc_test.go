@@ -0,0 +1,230 @@
+package check
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"github.com/shashimalcse/cronuseo/proto"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type mockService struct {
+	apiKey string
+}
+
+func (m mockService) Check(ctx context.Context, org_identifier string, req CheckRequest, apiKey string, skipValidation bool) (CheckResponse, error) {
+	if !skipValidation && apiKey != m.apiKey {
+		return CheckResponse{}, &util.UnauthorizedError{Message: "invalid API key"}
+	}
+	return m.Evaluate(ctx, org_identifier, req)
+}
+
+func (m mockService) CheckVerified(ctx context.Context, org_identifier string, req CheckRequest, apiKey string, claims map[string]interface{}) (CheckResponse, error) {
+	return m.Check(ctx, org_identifier, req, apiKey, false)
+}
+
+func (m mockService) Evaluate(ctx context.Context, org_identifier string, req CheckRequest) (CheckResponse, error) {
+	if req.Identifier != "jane" {
+		return CheckResponse{}, &util.NotFoundError{Path: "User"}
+	}
+	return CheckResponse{Allowed: req.Action == "read"}, nil
+}
+
+func (m mockService) ValidateAPIKey(ctx context.Context, org_identifier string, apiKey string, scope string) (bool, error) {
+	return apiKey == m.apiKey, nil
+}
+
+func (m mockService) GetOrgIdentifier(ctx context.Context, org_id string) (string, error) {
+	return org_id, nil
+}
+
+func (m mockService) GetOrgAncestors(ctx context.Context, org_id string) ([]string, error) {
+	return []string{}, nil
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	ca.write(t, "ca.pem", "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) write(t *testing.T, name string, blockType string, der []byte) string {
+
+	file := filepath.Join(ca.dir, name)
+	assert.Nil(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+	return file
+}
+
+// issue writes a leaf certificate and key and returns their files.
+func (ca *testCA) issue(t *testing.T, name string, commonName string, uri string) (string, string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if uri != "" {
+		u, err := url.Parse(uri)
+		assert.Nil(t, err)
+		template.URIs = []*url.URL{u}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+	return ca.write(t, name+".pem", "CERTIFICATE", der), ca.write(t, name+"-key.pem", "PRIVATE KEY", keyDer)
+}
+
+func TestGrpcCheck(t *testing.T) {
+
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, "server", "check server", "")
+	tlsCfg := config.CheckServerTLS{
+		Enabled:      true,
+		CertFile:     serverCert,
+		KeyFile:      serverKey,
+		ClientCAFile: filepath.Join(ca.dir, "ca.pem"),
+		ClientAuth:   ClientAuthOptional,
+		Clients: []config.CheckClient{
+			{Name: "orders", URI: "spiffe://example.com/orders", Organizations: []string{"acme"}},
+			{Name: "gateway", CommonName: "gateway", Organizations: []string{"*"}},
+		},
+	}
+	creds, err := ServerCredentials(tlsCfg)
+	assert.Nil(t, err)
+	identities, err := NewClientIdentities(tlsCfg.Clients)
+	assert.Nil(t, err)
+
+	server := grpc.NewServer(grpc.Creds(creds))
+	proto.RegisterCheckServer(server, NewGrpcService(mockService{apiKey: "secret"}, identities, zap.NewNop()))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	dial := func(certFile string, keyFile string) proto.CheckClient {
+		tlsConfig := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		if certFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			assert.Nil(t, err)
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+		assert.Nil(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return proto.NewCheckClient(conn)
+	}
+	request := func(org string, username string) *proto.GrpcCheckRequest {
+		return &proto.GrpcCheckRequest{Organization: org, Username: username, Resource: "doc", Action: "read"}
+	}
+	code := func(err error) codes.Code { return status.Code(err) }
+	ctx := context.Background()
+
+	// without a client certificate the API key is required
+	anonymous := dial("", "")
+	_, err = anonymous.Check(ctx, request("acme", "jane"))
+	assert.Equal(t, codes.Unauthenticated, code(err))
+	_, err = anonymous.Check(metadata.AppendToOutgoingContext(ctx, "API_KEY", "wrong"), request("acme", "jane"))
+	assert.Equal(t, codes.Unauthenticated, code(err))
+	resp, err := anonymous.Check(metadata.AppendToOutgoingContext(ctx, "API_KEY", "secret"), request("acme", "jane"))
+	assert.Nil(t, err)
+	assert.True(t, resp.Allow)
+	_, err = anonymous.Check(ctx, &proto.GrpcCheckRequest{Organization: "acme"})
+	assert.Equal(t, codes.InvalidArgument, code(err))
+
+	// mapped client certificates are authorized for their organizations
+	orders := dial(ca.issue(t, "orders", "orders", "spiffe://example.com/orders"))
+	resp, err = orders.Check(ctx, request("acme", "jane"))
+	assert.Nil(t, err)
+	assert.True(t, resp.Allow)
+	_, err = orders.Check(ctx, request("globex", "jane"))
+	assert.Equal(t, codes.PermissionDenied, code(err))
+	_, err = orders.Check(ctx, request("acme", "joe"))
+	assert.Equal(t, codes.NotFound, code(err))
+
+	gateway := dial(ca.issue(t, "gateway", "gateway", ""))
+	resp, err = gateway.Check(ctx, request("globex", "jane"))
+	assert.Nil(t, err)
+	assert.True(t, resp.Allow)
+
+	// unmapped client certificates fall back to the API key
+	other := dial(ca.issue(t, "other", "other", ""))
+	_, err = other.Check(ctx, request("acme", "jane"))
+	assert.Equal(t, codes.Unauthenticated, code(err))
+}
+
+func TestServerCredentials(t *testing.T) {
+
+	ca := newTestCA(t)
+	cert, key := ca.issue(t, "server", "check server", "")
+	clients := []config.CheckClient{{Name: "gateway", CommonName: "gateway", Organizations: []string{"*"}}}
+
+	invalid := []config.CheckServerTLS{
+		{CertFile: cert, KeyFile: filepath.Join(ca.dir, "missing.pem")},
+		{CertFile: cert, KeyFile: key, ClientAuth: "always"},
+		{CertFile: cert, KeyFile: key, ClientAuth: ClientAuthRequire},
+		{CertFile: cert, KeyFile: key, Clients: clients},
+	}
+	for i, cfg := range invalid {
+		_, err := ServerCredentials(cfg)
+		assert.NotNil(t, err, i)
+	}
+	_, err := ServerCredentials(config.CheckServerTLS{CertFile: cert, KeyFile: key})
+	assert.Nil(t, err)
+
+	_, err = NewClientIdentities([]config.CheckClient{{Name: "a", Organizations: []string{"acme"}}})
+	assert.NotNil(t, err)
+	_, err = NewClientIdentities([]config.CheckClient{{Name: "a", CommonName: "a"}})
+	assert.NotNil(t, err)
+}
@@ -2,9 +2,14 @@ package check
 
 import (
 	"context"
+	"time"
 
+	"github.com/shashimalcse/cronuseo/internal/apikey"
+	"github.com/shashimalcse/cronuseo/internal/assignment"
 	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/orgtree"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
 	"github.com/shashimalcse/cronuseo/internal/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,7 +18,10 @@ import (
 )
 
 type Repository interface {
-	ValidateAPIKey(ctx context.Context, org_identifier string, apiKey string) (bool, error)
+	ValidateAPIKey(ctx context.Context, org_identifier string, apiKey string, scope string) (bool, error)
+	GetOrgIdentifier(ctx context.Context, org_id string) (string, error)
+	GetOrgAncestors(ctx context.Context, org_id string) ([]string, error)
+	GetOrgState(ctx context.Context, org_identifier string) (OrgState, error)
 	GetRolePermissions(ctx context.Context, org_identifier string, role_ids []primitive.ObjectID) (*[]mongo_entity.Permission, error)
 	GetCheckDetails(ctx context.Context, org_identifier string, identifier string) (CheckDetails, error)
 	GetActivePolicyVersionContents(ctx context.Context, org_identifier string, policy_ids []primitive.ObjectID) (map[string]string, error)
@@ -31,27 +39,154 @@ func NewRepository(mongodb *db.MongoDB) Repository {
 	return repository{mongoClient: mongodb.MongoClient, mongoColl: orgCollection}
 }
 
-func (r repository) ValidateAPIKey(ctx context.Context, org_identifier string, apiKey string) (bool, error) {
+// ValidateAPIKey checks the key against the hashed keys of the organization
+// granted the scope.
+func (r repository) ValidateAPIKey(ctx context.Context, org_identifier string, apiKey string, scope string) (bool, error) {
 
-	filter := bson.M{"identifier": org_identifier, "api_key": apiKey}
+	ctx, span := telemetry.Repository(ctx, "check", "ValidateAPIKey")
+	defer span.End()
+
+	if apiKey == "" {
+		return false, nil
+	}
+	now := time.Now().UTC()
+	filter := bson.M{"identifier": org_identifier, "api_keys": apikey.Match(apiKey, scope, now)}
 
 	// Search for the resource in the "organizations" collection
-	count, err := r.mongoColl.CountDocuments(context.Background(), filter)
+	count, err := r.mongoColl.CountDocuments(ctx, filter)
 
 	if err != nil {
 		return false, err
 	}
 	if count > 0 {
+		if err := apikey.Touch(ctx, r.mongoColl, bson.M{"identifier": org_identifier}, apiKey, now); err != nil {
+			return true, err
+		}
 		return true, nil
 	}
 	return false, nil
 }
 
+// GetOrgIdentifier returns the identifier of the organization with the id.
+func (r repository) GetOrgIdentifier(ctx context.Context, org_id string) (string, error) {
+
+	ctx, span := telemetry.Repository(ctx, "check", "GetOrgIdentifier")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return "", err
+	}
+	projection := bson.M{"identifier": 1}
+	result := r.mongoColl.FindOne(ctx, bson.M{"_id": orgId}, options.FindOne().SetProjection(projection))
+	if err := result.Err(); err != nil {
+		return "", err
+	}
+	var org mongo_entity.Organization
+	if err := result.Decode(&org); err != nil {
+		return "", err
+	}
+	return org.Identifier, nil
+}
+
+// GetOrgAncestors returns the identifiers of the ancestors of the
+// organization with the id, nearest first.
+func (r repository) GetOrgAncestors(ctx context.Context, org_id string) ([]string, error) {
+
+	ctx, span := telemetry.Repository(ctx, "check", "GetOrgAncestors")
+	defer span.End()
+
+	lineage, err := orgtree.LineageOf(ctx, r.mongoColl, org_id)
+	if err != nil {
+		return nil, err
+	}
+	ancestors := lineage[1:]
+	if len(ancestors) == 0 {
+		return []string{}, nil
+	}
+	projection := bson.M{"identifier": 1}
+	cursor, err := r.mongoColl.Find(ctx, bson.M{"_id": bson.M{"$in": ancestors}}, options.Find().SetProjection(projection))
+	if err != nil {
+		return nil, err
+	}
+	var orgs []mongo_entity.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return nil, err
+	}
+	identifiers := []string{}
+	for _, ancestor := range ancestors {
+		for _, org := range orgs {
+			if org.ID == ancestor {
+				identifiers = append(identifiers, org.Identifier)
+			}
+		}
+	}
+	return identifiers, nil
+}
+
+// GetOrgState returns whether the organization with the identifier can be
+// checked and the default decision of its settings.
+func (r repository) GetOrgState(ctx context.Context, org_identifier string) (OrgState, error) {
+
+	ctx, span := telemetry.Repository(ctx, "check", "GetOrgState")
+	defer span.End()
+
+	projection := bson.M{"status": 1, "ancestors": 1, "settings": 1}
+	var org mongo_entity.Organization
+	err := r.mongoColl.FindOne(ctx, bson.M{"identifier": org_identifier}, options.FindOne().SetProjection(projection)).Decode(&org)
+	if err == mongo.ErrNoDocuments {
+		return OrgState{}, &util.NotFoundError{Path: "Organization"}
+	}
+	if err != nil {
+		return OrgState{}, err
+	}
+	ancestors := []mongo_entity.Organization{}
+	if len(org.Ancestors) > 0 {
+		cursor, err := r.mongoColl.Find(ctx, bson.M{"_id": bson.M{"$in": org.Ancestors}}, options.Find().SetProjection(bson.M{"status": 1}))
+		if err != nil {
+			return OrgState{}, err
+		}
+		if err := cursor.All(ctx, &ancestors); err != nil {
+			return OrgState{}, err
+		}
+	}
+	return orgState(org, ancestors), nil
+}
+
+// orgState returns the state of the organization, which is inactive when it
+// or one of its ancestors is suspended or deleted.
+func orgState(org mongo_entity.Organization, ancestors []mongo_entity.Organization) OrgState {
+
+	state := OrgState{Active: active(org)}
+	for _, ancestor := range ancestors {
+		state.Active = state.Active && active(ancestor)
+	}
+	if org.Settings != nil {
+		state.DefaultDecision = org.Settings.DefaultDecision
+	}
+	return state
+}
+
+func active(org mongo_entity.Organization) bool {
+
+	return org.Status == "" || org.Status == mongo_entity.OrganizationActive
+}
+
 func (r repository) GetRolePermissions(ctx context.Context, org_identifier string, role_ids []primitive.ObjectID) (*[]mongo_entity.Permission, error) {
 
+	ctx, span := telemetry.Repository(ctx, "check", "GetRolePermissions")
+	defer span.End()
+
+	// Roles of ancestor organizations are inherited, so checks fall back to
+	// the roles of the parent.
+	lineage, err := orgtree.Lineage(ctx, r.mongoColl, bson.M{"identifier": org_identifier})
+	if err != nil {
+		return nil, err
+	}
+
 	// Define the aggregation pipeline
 	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: bson.M{"identifier": org_identifier}}},
+		{{Key: "$match", Value: bson.M{"_id": bson.M{"$in": lineage}}}},
 		{{Key: "$project", Value: bson.M{
 			"roles": bson.M{
 				"$filter": bson.M{
@@ -70,34 +205,75 @@ func (r repository) GetRolePermissions(ctx context.Context, org_identifier strin
 	}
 	defer cursor.Close(ctx)
 
-	// Assuming only one organization will match, decode the first result
-	var org struct {
+	var orgs []struct {
 		Roles []mongo_entity.Role `bson:"roles"`
 	}
-	if cursor.Next(ctx) {
-		if err := cursor.Decode(&org); err != nil {
-			return nil, err
-		}
-	} else {
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return nil, err
+	}
+	if len(orgs) == 0 {
 		return nil, &util.NotFoundError{Path: "Organization not found"}
 	}
 
 	// Initialize a slice to store permissions
 	var permissions []mongo_entity.Permission
-	for _, role := range org.Roles {
-		permissions = append(permissions, role.Permissions...)
+	for _, org := range orgs {
+		for _, role := range org.Roles {
+			permissions = append(permissions, role.Permissions...)
+		}
 	}
 
 	// Return the collected permissions
 	return &permissions, nil
 }
 
+// GetCheckDetails returns the roles, policies and properties of the user, or
+// of the service account, with the identifier.
 func (r repository) GetCheckDetails(ctx context.Context, org_identifier string, identifier string) (CheckDetails, error) {
 
+	ctx, span := telemetry.Repository(ctx, "check", "GetCheckDetails")
+	defer span.End()
+
 	filter := bson.M{"identifier": org_identifier, "users.identifier": identifier}
 	projection := bson.M{"users.$": 1, "groups": 1}
 
 	// Find the user and groups in the "organizations" collection
+	var org mongo_entity.Organization
+	err := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org)
+	if err == mongo.ErrNoDocuments {
+		return r.getServiceAccountCheckDetails(ctx, org_identifier, identifier)
+	}
+	if err != nil {
+		return CheckDetails{}, err
+	}
+
+	if len(org.Users) == 0 {
+		return CheckDetails{}, nil
+	}
+	return userCheckDetails(org.Groups, org.Users[0], time.Now()), nil
+}
+
+// userCheckDetails returns the check details of the user, deactivated users
+// have no roles and are denied.
+func userCheckDetails(groups []mongo_entity.Group, user mongo_entity.User, now time.Time) CheckDetails {
+
+	if !user.Active() {
+		return CheckDetails{}
+	}
+	roles := assignment.Active(user.Roles, user.RoleGrants, now)
+	groupIds := assignment.Active(user.Groups, user.GroupGrants, now)
+	return checkDetails(groups, roles, groupIds, user.Policies, user.UserProperties, now)
+}
+
+// getServiceAccountCheckDetails returns the check details of a service account.
+func (r repository) getServiceAccountCheckDetails(ctx context.Context, org_identifier string, identifier string) (CheckDetails, error) {
+
+	ctx, span := telemetry.Repository(ctx, "check", "getServiceAccountCheckDetails")
+	defer span.End()
+
+	filter := bson.M{"identifier": org_identifier, "service_accounts.identifier": identifier}
+	projection := bson.M{"service_accounts.$": 1, "groups": 1}
+
 	var org mongo_entity.Organization
 	err := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org)
 	if err != nil {
@@ -106,26 +282,33 @@ func (r repository) GetCheckDetails(ctx context.Context, org_identifier string,
 		}
 		return CheckDetails{}, err
 	}
-
-	if len(org.Users) == 0 {
+	if len(org.ServiceAccounts) == 0 {
 		return CheckDetails{}, nil
 	}
+	account := org.ServiceAccounts[0]
+	return checkDetails(org.Groups, account.Roles, account.Groups, account.Policies, map[string]interface{}{}, time.Now()), nil
+}
+
+// checkDetails collects the direct roles and policies of a subject and the
+// ones inherited from its groups, ignoring group roles whose grant is not
+// active at the time.
+func checkDetails(groups []mongo_entity.Group, roles []primitive.ObjectID, groupIds []primitive.ObjectID, policies []primitive.ObjectID, properties map[string]interface{}, now time.Time) CheckDetails {
 
 	// Create a map to store the unique role IDs
 	roleIDMap := make(map[primitive.ObjectID]struct{})
 	policyIDMap := make(map[primitive.ObjectID]struct{})
 	groupIDs := make(map[primitive.ObjectID]struct{})
 
-	for _, groupID := range org.Users[0].Groups {
+	for _, groupID := range groupIds {
 		groupIDs[groupID] = struct{}{}
 	}
-	for _, policyID := range org.Users[0].Policies {
+	for _, policyID := range policies {
 		policyIDMap[policyID] = struct{}{}
 	}
 
-	for _, group := range org.Groups {
+	for _, group := range groups {
 		if _, exists := groupIDs[group.ID]; exists {
-			for _, roleID := range group.Roles {
+			for _, roleID := range assignment.Active(group.Roles, group.RoleGrants, now) {
 				roleIDMap[roleID] = struct{}{}
 			}
 			for _, policyID := range group.Policies {
@@ -135,7 +318,7 @@ func (r repository) GetCheckDetails(ctx context.Context, org_identifier string,
 	}
 
 	var roleIDs []primitive.ObjectID
-	roleIDs = append(roleIDs, org.Users[0].Roles...)
+	roleIDs = append(roleIDs, roles...)
 	for roleID := range roleIDMap {
 		roleIDs = append(roleIDs, roleID)
 	}
@@ -148,12 +331,15 @@ func (r repository) GetCheckDetails(ctx context.Context, org_identifier string,
 	return CheckDetails{
 		Roles:          roleIDs,
 		Policies:       policyIDs,
-		UserProperties: org.Users[0].UserProperties,
-	}, nil
+		UserProperties: properties,
+	}
 }
 
 func (r repository) GetActivePolicyVersionContents(ctx context.Context, org_identifier string, policy_ids []primitive.ObjectID) (map[string]string, error) {
 
+	ctx, span := telemetry.Repository(ctx, "check", "GetActivePolicyVersionContents")
+	defer span.End()
+
 	// Filter to find documents with the specified policy IDs
 	filter := bson.M{
 		"identifier": org_identifier,
@@ -0,0 +1,52 @@
+package check
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCheckDetailsGroupRoleGrants(t *testing.T) {
+
+	now := time.Now()
+	expiredAt := now.Add(-time.Minute)
+	direct, inherited, expired, policy := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	group := mongo_entity.Group{
+		ID:         primitive.NewObjectID(),
+		Roles:      []primitive.ObjectID{inherited, expired},
+		Policies:   []primitive.ObjectID{policy},
+		RoleGrants: []mongo_entity.Grant{{ID: expired, ValidUntil: &expiredAt}},
+	}
+	other := mongo_entity.Group{ID: primitive.NewObjectID(), Roles: []primitive.ObjectID{primitive.NewObjectID()}}
+
+	details := checkDetails([]mongo_entity.Group{group, other}, []primitive.ObjectID{direct}, []primitive.ObjectID{group.ID}, nil, nil, now)
+	assert.ElementsMatch(t, []primitive.ObjectID{direct, inherited}, details.Roles)
+	assert.Equal(t, []primitive.ObjectID{policy}, details.Policies)
+}
+
+func TestOrgState(t *testing.T) {
+
+	org := mongo_entity.Organization{Settings: &mongo_entity.OrganizationSettings{DefaultDecision: mongo_entity.DecisionAllow}}
+	state := orgState(org, []mongo_entity.Organization{{Status: mongo_entity.OrganizationActive}})
+	assert.Equal(t, OrgState{Active: true, DefaultDecision: mongo_entity.DecisionAllow}, state)
+
+	org.Status = mongo_entity.OrganizationSuspended
+	assert.False(t, orgState(org, nil).Active)
+
+	org.Status = mongo_entity.OrganizationActive
+	assert.False(t, orgState(org, []mongo_entity.Organization{{Status: mongo_entity.OrganizationDeleted}}).Active)
+}
+
+func TestUserCheckDetailsDeactivated(t *testing.T) {
+
+	role := primitive.NewObjectID()
+	user := mongo_entity.User{Roles: []primitive.ObjectID{role}, UserProperties: map[string]interface{}{"active": true}}
+	assert.Equal(t, []primitive.ObjectID{role}, userCheckDetails(nil, user, time.Now()).Roles)
+
+	// users deactivated by SCIM lose all roles
+	user.UserProperties["active"] = false
+	assert.Empty(t, userCheckDetails(nil, user, time.Now()).Roles)
+}
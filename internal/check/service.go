@@ -3,7 +3,12 @@ package check
 import (
 	"context"
 	"encoding/json"
+	"time"
 
+	"github.com/shashimalcse/cronuseo/internal/apikey"
+	"github.com/shashimalcse/cronuseo/internal/limits"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
 	"github.com/shashimalcse/cronuseo/internal/util"
 	"github.com/shashimalcse/tunnel_go"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,13 +17,28 @@ import (
 
 type Service interface {
 	Check(ctx context.Context, org_identifier string, req CheckRequest, apiKey string, skipValidation bool) (CheckResponse, error)
-	ValidateAPIKey(ctx context.Context, org_identifier string, apiKey string) (bool, error)
+	CheckVerified(ctx context.Context, org_identifier string, req CheckRequest, apiKey string, claims map[string]interface{}) (CheckResponse, error)
+	Evaluate(ctx context.Context, org_identifier string, req CheckRequest) (CheckResponse, error)
+	ValidateAPIKey(ctx context.Context, org_identifier string, apiKey string, scope string) (bool, error)
+	GetOrgIdentifier(ctx context.Context, org_id string) (string, error)
+	GetOrgAncestors(ctx context.Context, org_id string) ([]string, error)
 }
 
 type CheckRequest struct {
 	Identifier string `json:"identifier"`
 	Action     string `json:"action"`
 	Resource   string `json:"resource"`
+
+	// Claims, or a token, of the subject provisioned just in time before the
+	// check. The identifier may be omitted.
+	Claims map[string]interface{} `json:"claims,omitempty"`
+	Token  string                 `json:"token,omitempty"`
+}
+
+// Provisioner creates or updates check subjects from their claims, or the
+// claims of their token, and returns their identifier.
+type Provisioner interface {
+	Provision(ctx context.Context, org_identifier string, claims map[string]interface{}, token string) (string, error)
 }
 
 type CheckResponse struct {
@@ -26,8 +46,12 @@ type CheckResponse struct {
 }
 
 type service struct {
-	repo   Repository
-	logger *zap.Logger
+	repo        Repository
+	logger      *zap.Logger
+	quotas      limits.Quotas
+	rateLimiter limits.RateLimiter
+
+	provisioner Provisioner
 }
 
 type CheckDetails struct {
@@ -36,22 +60,143 @@ type CheckDetails struct {
 	UserProperties map[string]interface{}
 }
 
-func NewService(repo Repository, logger *zap.Logger) Service {
+// OrgState is the state of an organization for checks.
+type OrgState struct {
+	Active          bool
+	DefaultDecision string
+}
 
-	return service{repo: repo, logger: logger}
+// NewService creates the check service. Checks are rate limited per validated
+// API key, and checks with claims are rejected when provisioner is nil.
+func NewService(repo Repository, logger *zap.Logger, quotas limits.Quotas, rateLimiter limits.RateLimiter, provisioner Provisioner) Service {
+
+	return service{repo: repo, logger: logger, quotas: quotas, rateLimiter: rateLimiter, provisioner: provisioner}
 }
 
 func (s service) Check(ctx context.Context, org_identifier string, req CheckRequest, apiKey string, skipValidation bool) (CheckResponse, error) {
 
-	// Check resource already exists.
-	if !skipValidation {
-		validated, _ := s.ValidateAPIKey(ctx, org_identifier, apiKey)
-		if !validated {
-			s.logger.Debug("API_KEY is not valid.")
-			return CheckResponse{}, &util.UnauthorizedError{}
+	ctx, span := telemetry.Start(ctx, "check.service.Check")
+	defer span.End()
+
+	// Permission checks of the admin API are not validated or counted.
+	if skipValidation {
+		return s.evaluate(ctx, org_identifier, req, false)
+	}
+	return s.check(ctx, org_identifier, req, apiKey, nil)
+}
+
+// CheckVerified checks the permission of the subject of claims the server
+// verified itself, e.g. of the bearer token of an ext_authz request, and
+// provisions it just in time. Unlike claims of the request, verified claims
+// do not need a key with the sync scope, so they must never be client input.
+func (s service) CheckVerified(ctx context.Context, org_identifier string, req CheckRequest, apiKey string, claims map[string]interface{}) (CheckResponse, error) {
+
+	ctx, span := telemetry.Start(ctx, "check.service.CheckVerified")
+	defer span.End()
+
+	return s.check(ctx, org_identifier, req, apiKey, claims)
+}
+
+// check validates the API key, then provisions and checks the subject of the
+// request, or of the verified claims when set.
+func (s service) check(ctx context.Context, org_identifier string, req CheckRequest, apiKey string, verified map[string]interface{}) (CheckResponse, error) {
+
+	start := time.Now()
+	validated, _ := s.ValidateAPIKey(ctx, org_identifier, apiKey, apikey.ScopeCheck)
+	if !validated {
+		s.logger.Debug("API_KEY is not valid.")
+		return CheckResponse{}, &util.UnauthorizedError{Message: "invalid API key"}
+	}
+	// Claims are trusted as sent, so only keys allowed to sync users may send
+	// them. Check keys send the subject's token, which is verified.
+	if req.Claims != nil {
+		if allowed, _ := s.ValidateAPIKey(ctx, org_identifier, apiKey, apikey.ScopeSync); !allowed {
+			return CheckResponse{}, &util.PermissionDeniedError{Message: "Claims require an API key with the sync scope, send a token instead."}
 		}
 	}
+	if err := s.rateLimiter.Allow(ctx, org_identifier, apikey.Hash(apiKey)); err != nil {
+		return CheckResponse{}, err
+	}
+	if err := s.quotas.RecordCheck(ctx, org_identifier); err != nil {
+		return CheckResponse{}, err
+	}
+	if verified != nil {
+		req.Claims, req.Token = verified, ""
+	}
+	req, err := s.provision(ctx, org_identifier, req)
+	if err != nil {
+		return CheckResponse{}, err
+	}
+	resp, err := s.evaluate(ctx, org_identifier, req, true)
+	telemetry.ObserveCheck(org_identifier, resp.Allowed, err, time.Since(start))
+	return resp, err
+}
+
+// Evaluate checks the permission including the user's policies for callers
+// that are already authenticated for the organization, such as mapped client
+// certificates.
+func (s service) Evaluate(ctx context.Context, org_identifier string, req CheckRequest) (CheckResponse, error) {
+
+	ctx, span := telemetry.Start(ctx, "check.service.Evaluate")
+	defer span.End()
+
+	start := time.Now()
+	// Authenticated clients share the rate limit of the organization.
+	if err := s.rateLimiter.Allow(ctx, org_identifier, ""); err != nil {
+		return CheckResponse{}, err
+	}
+	if err := s.quotas.RecordCheck(ctx, org_identifier); err != nil {
+		return CheckResponse{}, err
+	}
+	req, err := s.provision(ctx, org_identifier, req)
+	if err != nil {
+		return CheckResponse{}, err
+	}
+	resp, err := s.evaluate(ctx, org_identifier, req, true)
+	telemetry.ObserveCheck(org_identifier, resp.Allowed, err, time.Since(start))
+	return resp, err
+}
+
+// provision provisions the subject of the claims of the request, and returns
+// the request with its identifier.
+func (s service) provision(ctx context.Context, org_identifier string, req CheckRequest) (CheckRequest, error) {
+
+	if req.Claims == nil && req.Token == "" {
+		return req, nil
+	}
+	if s.provisioner == nil {
+		return req, &util.InvalidInputError{Message: "Just-in-time provisioning is not supported by this server."}
+	}
+	identifier, err := s.provisioner.Provision(ctx, org_identifier, req.Claims, req.Token)
+	if err != nil {
+		return req, err
+	}
+	if req.Identifier != "" && req.Identifier != identifier {
+		return req, &util.InvalidInputError{Message: "Identifier does not match the subject of the claims."}
+	}
+	req.Identifier = identifier
+	return req, nil
+}
+
+func (s service) evaluate(ctx context.Context, org_identifier string, req CheckRequest, withPolicies bool) (CheckResponse, error) {
+
+	ctx, span := telemetry.Start(ctx, "check.service.evaluate")
+	defer span.End()
+
+	// Checks of suspended and deleted organizations fail closed.
+	state, err := s.repo.GetOrgState(ctx, org_identifier)
+	if err != nil {
+		return CheckResponse{}, err
+	}
+	if !state.Active {
+		return CheckResponse{}, nil
+	}
+	// The default decision of unknown subjects only applies to client checks,
+	// permission checks of the admin API always fail closed.
 	checkDetails, err := s.repo.GetCheckDetails(ctx, org_identifier, req.Identifier)
+	if _, ok := err.(*util.NotFoundError); ok && withPolicies && state.DefaultDecision != "" {
+		return CheckResponse{Allowed: state.DefaultDecision == mongo_entity.DecisionAllow}, nil
+	}
 	if err != nil {
 		return CheckResponse{}, err
 	}
@@ -67,7 +212,7 @@ func (s service) Check(ctx context.Context, org_identifier string, req CheckRequ
 			}
 		}
 	}
-	if !skipValidation {
+	if withPolicies {
 		properties, err := json.Marshal(*&checkDetails.UserProperties)
 		if err != nil {
 			return CheckResponse{}, err
@@ -83,12 +228,48 @@ func (s service) Check(ctx context.Context, org_identifier string, req CheckRequ
 	return CheckResponse{Allowed: allow}, nil
 }
 
-func (s service) ValidateAPIKey(ctx context.Context, org_identifier string, apiKey string) (bool, error) {
+// ValidateAPIKey checks that the key is valid for the organization and scope.
+func (s service) ValidateAPIKey(ctx context.Context, org_identifier string, apiKey string, scope string) (bool, error) {
 
-	validated, _ := s.repo.ValidateAPIKey(ctx, org_identifier, apiKey)
+	ctx, span := telemetry.Start(ctx, "check.service.ValidateAPIKey")
+	defer span.End()
+
+	validated, err := s.repo.ValidateAPIKey(ctx, org_identifier, apiKey, scope)
+	if err != nil && validated {
+		s.logger.Warn("Error while recording API key usage.", zap.Error(err))
+	}
 	if !validated {
 		s.logger.Debug("API_KEY is not valid.")
-		return false, &util.UnauthorizedError{}
+		return false, &util.UnauthorizedError{Message: "invalid API key"}
 	}
 	return validated, nil
 }
+
+// GetOrgIdentifier returns the identifier of the organization with the id.
+func (s service) GetOrgIdentifier(ctx context.Context, org_id string) (string, error) {
+
+	ctx, span := telemetry.Start(ctx, "check.service.GetOrgIdentifier")
+	defer span.End()
+
+	identifier, err := s.repo.GetOrgIdentifier(ctx, org_id)
+	if err != nil {
+		s.logger.Debug("Organization not exists.", zap.String("organization_id", org_id))
+		return "", &util.NotFoundError{Path: "Organization " + org_id + " not exists."}
+	}
+	return identifier, nil
+}
+
+// GetOrgAncestors returns the identifiers of the ancestors of the organization
+// with the id, nearest first.
+func (s service) GetOrgAncestors(ctx context.Context, org_id string) ([]string, error) {
+
+	ctx, span := telemetry.Start(ctx, "check.service.GetOrgAncestors")
+	defer span.End()
+
+	ancestors, err := s.repo.GetOrgAncestors(ctx, org_id)
+	if err != nil {
+		s.logger.Debug("Organization not exists.", zap.String("organization_id", org_id))
+		return nil, &util.NotFoundError{Path: "Organization " + org_id + " not exists."}
+	}
+	return ancestors, nil
+}
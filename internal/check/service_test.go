@@ -0,0 +1,134 @@
+package check
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shashimalcse/cronuseo/internal/apikey"
+	"github.com/shashimalcse/cronuseo/internal/limits"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+func TestCheckClaims(t *testing.T) {
+
+	provisioner := &mockProvisioner{}
+	s := NewService(mockRepository{}, zap.NewNop(), mockLimits{}, mockLimits{}, provisioner)
+	ctx := context.Background()
+	claims := CheckRequest{Action: "read", Resource: "doc", Claims: map[string]interface{}{"sub": "jane"}}
+
+	// claims are only trusted from keys allowed to sync users
+	_, err := s.Check(ctx, "acme", claims, "check-key", false)
+	assert.IsType(t, &util.PermissionDeniedError{}, err)
+	assert.Equal(t, 0, provisioner.calls)
+	resp, err := s.Check(ctx, "acme", claims, "sync-key", false)
+	assert.Nil(t, err)
+	assert.True(t, resp.Allowed)
+	assert.Equal(t, 1, provisioner.calls)
+
+	// tokens are verified by the provisioner and accepted from check keys
+	resp, err = s.Check(ctx, "acme", CheckRequest{Action: "read", Resource: "doc", Token: "token"}, "check-key", false)
+	assert.Nil(t, err)
+	assert.True(t, resp.Allowed)
+	assert.Equal(t, 2, provisioner.calls)
+
+	_, err = s.Check(ctx, "acme", claims, "unknown", false)
+	assert.IsType(t, &util.UnauthorizedError{}, err)
+
+	// claims verified by the server are accepted from check keys
+	resp, err = s.CheckVerified(ctx, "acme", CheckRequest{Action: "read", Resource: "doc"}, "check-key", claims.Claims)
+	assert.Nil(t, err)
+	assert.True(t, resp.Allowed)
+	assert.Equal(t, 3, provisioner.calls)
+	_, err = s.CheckVerified(ctx, "acme", CheckRequest{Action: "read", Resource: "doc"}, "unknown", claims.Claims)
+	assert.IsType(t, &util.UnauthorizedError{}, err)
+	assert.Equal(t, 3, provisioner.calls)
+}
+
+func TestDefaultDecision(t *testing.T) {
+
+	s := NewService(mockRepository{state: OrgState{Active: true, DefaultDecision: mongo_entity.DecisionAllow}},
+		zap.NewNop(), mockLimits{}, mockLimits{}, nil)
+	ctx := context.Background()
+	unknown := CheckRequest{Identifier: "unknown", Action: "read", Resource: "doc"}
+
+	// client checks of unknown subjects get the default decision
+	resp, err := s.Check(ctx, "acme", unknown, "check-key", false)
+	assert.Nil(t, err)
+	assert.True(t, resp.Allowed)
+
+	// permission checks of the admin API fail closed
+	resp, err = s.Check(ctx, "acme", unknown, "nil", true)
+	assert.IsType(t, &util.NotFoundError{}, err)
+	assert.False(t, resp.Allowed)
+}
+
+type mockRepository struct {
+	state OrgState
+}
+
+func (m mockRepository) ValidateAPIKey(ctx context.Context, org_identifier string, apiKey string, scope string) (bool, error) {
+	switch apiKey {
+	case "check-key":
+		return scope == apikey.ScopeCheck, nil
+	case "sync-key":
+		return true, nil
+	}
+	return false, nil
+}
+
+func (m mockRepository) GetOrgIdentifier(ctx context.Context, org_id string) (string, error) {
+	return org_id, nil
+}
+
+func (m mockRepository) GetOrgAncestors(ctx context.Context, org_id string) ([]string, error) {
+	return []string{}, nil
+}
+
+func (m mockRepository) GetOrgState(ctx context.Context, org_identifier string) (OrgState, error) {
+	if m.state == (OrgState{}) {
+		return OrgState{Active: true}, nil
+	}
+	return m.state, nil
+}
+
+func (m mockRepository) GetRolePermissions(ctx context.Context, org_identifier string, role_ids []primitive.ObjectID) (*[]mongo_entity.Permission, error) {
+	return &[]mongo_entity.Permission{{Resource: "doc", Action: "read"}}, nil
+}
+
+func (m mockRepository) GetCheckDetails(ctx context.Context, org_identifier string, identifier string) (CheckDetails, error) {
+	if identifier != "jane" {
+		return CheckDetails{}, &util.NotFoundError{Path: "User"}
+	}
+	return CheckDetails{Roles: []primitive.ObjectID{primitive.NewObjectID()}}, nil
+}
+
+func (m mockRepository) GetActivePolicyVersionContents(ctx context.Context, org_identifier string, policy_ids []primitive.ObjectID) (map[string]string, error) {
+	return map[string]string{}, nil
+}
+
+type mockLimits struct{}
+
+func (mockLimits) CheckQuota(ctx context.Context, org_id string, quota limits.Quota) error {
+	return nil
+}
+
+func (mockLimits) RecordCheck(ctx context.Context, org_identifier string) error {
+	return nil
+}
+
+func (mockLimits) Allow(ctx context.Context, org string, principal string) error {
+	return nil
+}
+
+type mockProvisioner struct {
+	calls int
+}
+
+func (m *mockProvisioner) Provision(ctx context.Context, org_identifier string, claims map[string]interface{}, token string) (string, error) {
+	m.calls++
+	return "jane", nil
+}
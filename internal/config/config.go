@@ -1,37 +1,50 @@
 package config
 
 import (
-	"io/ioutil"
 	"reflect"
+	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
-	"gopkg.in/yaml.v2"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
 )
 
 type Config struct {
 	Config struct {
-		Level string `yaml:"level" env:"level"`
+		Level string `yaml:"level"`
 	} `yaml:"config"`
 	Server struct {
-		Endpoint string `yaml:"endpoint" env:"endpoint"`
+		Endpoint string `yaml:"endpoint"`
+		// ShutdownTimeout bounds how long in-flight requests are drained on
+		// SIGTERM.
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
 	} `yaml:"server"`
-	Auth struct {
-		JWKS string `yaml:"jwks" env:"JWKS"`
-	} `yaml:"auth"`
+	CheckServer struct {
+		Endpoint        string         `yaml:"endpoint"`
+		TLS             CheckServerTLS `yaml:"tls"`
+		MetricsEndpoint string         `yaml:"metrics_endpoint"`
+		ShutdownTimeout time.Duration  `yaml:"shutdown_timeout"`
+	} `yaml:"check_server"`
+	Auth     Auth `yaml:"auth"`
 	Database struct {
-		URL      string `yaml:"url" env:"URL,secret"`
-		Name     string `yaml:"name" env:"Name,secret"`
-		User     string `yaml:"user" env:"User,secret"`
-		Password string `yaml:"password" env:"Password,secret"`
+		URL      string `yaml:"url" env:",secret"`
+		Name     string `yaml:"name"`
+		User     string `yaml:"user" env:",secret"`
+		Password string `yaml:"password" env:",secret"`
 	} `yaml:"database"`
 	Log struct {
-		Enabled bool `yaml:"enabled" env:"enabled"`
+		Enabled bool `yaml:"enabled"`
+		// Level is debug, info, warn or error.
+		Level string `yaml:"level"`
 	} `yaml:"log"`
+	CORS struct {
+		AllowOrigins     []string `yaml:"allow_origins"`
+		AllowCredentials bool     `yaml:"allow_credentials"`
+	} `yaml:"cors"`
 	RootOrganization struct {
-		Name            string `yaml:"name" env:"Name"`
-		AdminIdentifier string `yaml:"admin_identifier" env:"AdminIdentifier"`
-		AdminName       string `yaml:"admin_name" env:"AdminName"`
-		AdminRoleName   string `yaml:"admin_role_name" env:"AdminRoleName"`
+		Name            string `yaml:"name"`
+		AdminIdentifier string `yaml:"admin_identifier"`
+		AdminName       string `yaml:"admin_name"`
+		AdminRoleName   string `yaml:"admin_role_name"`
 	} `yaml:"root_organization"`
 	SystemResources struct {
 		Organizations []string `yaml:"organizations"`
@@ -41,7 +54,128 @@ type Config struct {
 		Resources     []string `yaml:"resources"`
 		Polices       []string `yaml:"policies"`
 	} `yaml:"system_resources"`
-	APIEndpoints []APIEndpoint `yaml:"endpoints"`
+	APIKeys struct {
+		RotationGracePeriod time.Duration `yaml:"rotation_grace_period"`
+	} `yaml:"api_keys"`
+	Assignments struct {
+		// SweepInterval is how often expired time-bound assignments are
+		// removed. Zero disables the sweeper, checks ignore expired
+		// assignments regardless.
+		SweepInterval time.Duration `yaml:"sweep_interval"`
+	} `yaml:"assignments"`
+	AccessRequests  AccessRequests  `yaml:"access_requests"`
+	DirectorySync   DirectorySync   `yaml:"directory_sync"`
+	Organizations   Organizations   `yaml:"organizations"`
+	ServiceAccounts ServiceAccounts `yaml:"service_accounts"`
+	Limits          Limits          `yaml:"limits"`
+	Tracing         Tracing         `yaml:"tracing"`
+	APIEndpoints    []APIEndpoint   `yaml:"endpoints"`
+	ExtAuthz        ExtAuthz        `yaml:"ext_authz"`
+}
+
+// Auth configures the trusted issuers of admin tokens.
+type Auth struct {
+	JWKS    string   `yaml:"jwks"`
+	Issuers []Issuer `yaml:"issuers"`
+	// Leeway is the allowed clock skew when checking exp and nbf.
+	Leeway time.Duration `yaml:"leeway"`
+}
+
+// Issuer is a trusted token issuer. Keys are fetched from the JWKS URL or
+// loaded from local PEM or JWK set files. Organization or OrganizationClaim
+// restricts its tokens to a single organization.
+type Issuer struct {
+	Name              string   `yaml:"name"`
+	Issuer            string   `yaml:"issuer"`
+	Audiences         []string `yaml:"audiences"`
+	JWKS              string   `yaml:"jwks"`
+	KeyFiles          []string `yaml:"key_files"`
+	Organization      string   `yaml:"organization"`
+	OrganizationClaim string   `yaml:"organization_claim"`
+}
+
+// CheckServerTLS configures TLS of the gRPC check server. ClientAuth is
+// "none", "optional" (verify client certificates when given) or "require".
+type CheckServerTLS struct {
+	Enabled      bool          `yaml:"enabled"`
+	CertFile     string        `yaml:"cert_file"`
+	KeyFile      string        `yaml:"key_file"`
+	ClientCAFile string        `yaml:"client_ca_file"`
+	ClientAuth   string        `yaml:"client_auth"`
+	Clients      []CheckClient `yaml:"clients"`
+}
+
+// CheckClient maps a verified client certificate, by common name or URI SAN,
+// to the organizations it may check without an API key. "*" allows every
+// organization.
+type CheckClient struct {
+	Name          string   `yaml:"name"`
+	CommonName    string   `yaml:"common_name"`
+	URI           string   `yaml:"uri"`
+	Organizations []string `yaml:"organizations"`
+}
+
+// AccessRequests configures just-in-time access requests. MaxDuration bounds
+// the access of organizations without their own limit, and the webhook is
+// notified of every request and review.
+type AccessRequests struct {
+	MaxDuration time.Duration `yaml:"max_duration"`
+	Webhook     Webhook       `yaml:"webhook"`
+}
+
+// Webhook is an HTTP endpoint receiving JSON notifications, signed with an
+// HMAC-SHA256 of the secret when one is set.
+type Webhook struct {
+	URL     string        `yaml:"url"`
+	Secret  string        `yaml:"secret" env:",secret"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// DirectorySync configures the LDAP directory syncs of organizations. Due
+// scheduled syncs are run every CheckInterval, zero disables them, and Timeout
+// bounds the LDAP connection and every request.
+type DirectorySync struct {
+	CheckInterval time.Duration `yaml:"check_interval"`
+	Timeout       time.Duration `yaml:"timeout"`
+}
+
+// Organizations configures the lifecycle of organizations. Deleted
+// organizations can be restored for the Retention period and are purged every
+// PurgeInterval after it, zero disables purging.
+type Organizations struct {
+	Retention     time.Duration `yaml:"retention"`
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+}
+
+// ServiceAccounts configures the tokens cronuseo issues to service accounts.
+// A signing key is generated at startup when no key file is configured, so
+// issued tokens do not survive restarts.
+type ServiceAccounts struct {
+	Issuer         string        `yaml:"issuer"`
+	Audience       string        `yaml:"audience"`
+	TokenTTL       time.Duration `yaml:"token_ttl"`
+	SigningKeyFile string        `yaml:"signing_key_file"`
+}
+
+// Limits are the default rate limits and quotas of organizations, which
+// organizations can override. Zero values are unlimited.
+type Limits struct {
+	RateLimit         float64 `yaml:"rate_limit"`
+	Burst             int     `yaml:"burst"`
+	MaxUsers          int     `yaml:"max_users"`
+	MaxRoles          int     `yaml:"max_roles"`
+	MaxGroups         int     `yaml:"max_groups"`
+	MaxPolicies       int     `yaml:"max_policies"`
+	MaxChecksPerMonth int64   `yaml:"max_checks_per_month"`
+}
+
+// Tracing configures the export of OpenTelemetry traces over OTLP/gRPC.
+type Tracing struct {
+	Enabled     bool    `yaml:"enabled"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+	ServiceName string  `yaml:"service_name"`
 }
 
 type APIEndpoint struct {
@@ -55,6 +189,37 @@ type MethodDetail struct {
 	RequiredPermissions []string `yaml:"required_permissions"`
 }
 
+// ExtAuthz configures the Envoy external authorization service of the check server.
+type ExtAuthz struct {
+	Enabled      bool   `yaml:"enabled"`
+	Organization string `yaml:"organization"`
+	APIKey       string `yaml:"api_key" env:",secret"`
+	// Subject is read from the Header set by Envoy after its own jwt_authn
+	// check, or else from the JWTClaim of the bearer token verified against
+	// the trusted issuers.
+	Subject struct {
+		Header   string `yaml:"header"`
+		JWTClaim string `yaml:"jwt_claim"`
+		// Provision passes the verified claims of the subject to checks, to
+		// provision it just in time.
+		Provision bool `yaml:"provision"`
+	} `yaml:"subject"`
+	Routes []ExtAuthzRoute `yaml:"routes"`
+}
+
+// ExtAuthzRoute maps requests whose whole path matches the regex to the
+// resource, and each HTTP method to an action of it.
+type ExtAuthzRoute struct {
+	Path     string           `yaml:"path"`
+	Resource string           `yaml:"resource"`
+	Methods  []ExtAuthzMethod `yaml:"methods"`
+}
+
+type ExtAuthzMethod struct {
+	Method string `yaml:"method"`
+	Action string `yaml:"action"`
+}
+
 func Nested(target interface{}, fieldRules ...*validation.FieldRules) *validation.FieldRules {
 	return validation.Field(target, validation.By(func(value interface{}) error {
 		valueV := reflect.Indirect(reflect.ValueOf(value))
@@ -72,32 +237,186 @@ func (c Config) Validate() error {
 	return validation.ValidateStruct(&c,
 		Nested(&c.Server,
 			validation.Field(&c.Server.Endpoint, validation.Required),
+			validation.Field(&c.Server.ShutdownTimeout, validation.Required, validation.Min(time.Duration(0))),
+		),
+		Nested(&c.CheckServer,
+			validation.Field(&c.CheckServer.Endpoint, validation.Required),
+			validation.Field(&c.CheckServer.ShutdownTimeout, validation.Required, validation.Min(time.Duration(0))),
+			validation.Field(&c.CheckServer.TLS),
 		),
+		validation.Field(&c.Auth),
 		Nested(&c.Database,
 			validation.Field(&c.Database.URL, validation.Required),
 			validation.Field(&c.Database.Name, validation.Required),
 			validation.Field(&c.Database.User, validation.Required),
 			validation.Field(&c.Database.Password, validation.Required),
 		),
+		Nested(&c.Log,
+			validation.Field(&c.Log.Level, validation.Required, validation.In("debug", "info", "warn", "error")),
+		),
+		Nested(&c.CORS,
+			validation.Field(&c.CORS.AllowOrigins, validation.Each(validation.Required)),
+		),
 		Nested(&c.RootOrganization,
 			validation.Field(&c.RootOrganization.Name, validation.Required),
 		),
+		Nested(&c.APIKeys,
+			validation.Field(&c.APIKeys.RotationGracePeriod, validation.Min(time.Duration(0))),
+		),
+		Nested(&c.Assignments,
+			validation.Field(&c.Assignments.SweepInterval, validation.Min(time.Duration(0))),
+		),
+		validation.Field(&c.AccessRequests),
+		validation.Field(&c.DirectorySync),
+		validation.Field(&c.Organizations),
+		validation.Field(&c.ServiceAccounts),
+		validation.Field(&c.Limits),
+		validation.Field(&c.Tracing),
+		validation.Field(&c.APIEndpoints),
+		validation.Field(&c.ExtAuthz),
+	)
+}
+
+func (t CheckServerTLS) Validate() error {
+
+	return validation.ValidateStruct(&t,
+		validation.Field(&t.CertFile, validation.When(t.Enabled, validation.Required)),
+		validation.Field(&t.KeyFile, validation.When(t.Enabled, validation.Required)),
+		validation.Field(&t.ClientAuth, validation.In("none", "optional", "require")),
+		validation.Field(&t.ClientCAFile, validation.When(t.Enabled && t.ClientAuth != "" && t.ClientAuth != "none", validation.Required)),
+		validation.Field(&t.Clients),
+	)
+}
+
+func (c CheckClient) Validate() error {
+
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.Name, validation.Required),
+		validation.Field(&c.CommonName, validation.When(c.URI == "", validation.Required)),
+		validation.Field(&c.Organizations, validation.Required),
 	)
 }
 
-// Load the configuration from a file.
-func Load(file string) (*Config, error) {
+func (a Auth) Validate() error {
 
-	c := Config{}
-	bytes, err := ioutil.ReadFile(file)
-	if err != nil {
-		return nil, err
-	}
-	if err = yaml.Unmarshal(bytes, &c); err != nil {
-		return nil, err
-	}
-	if err = c.Validate(); err != nil {
-		return nil, err
-	}
-	return &c, err
+	return validation.ValidateStruct(&a,
+		validation.Field(&a.Issuers),
+		validation.Field(&a.Leeway, validation.Min(time.Duration(0))),
+	)
+}
+
+func (i Issuer) Validate() error {
+
+	return validation.ValidateStruct(&i,
+		validation.Field(&i.Name, validation.Required),
+		validation.Field(&i.JWKS, validation.When(len(i.KeyFiles) == 0, validation.Required)),
+	)
+}
+
+func (a AccessRequests) Validate() error {
+
+	return validation.ValidateStruct(&a,
+		validation.Field(&a.MaxDuration, validation.Required, validation.Min(time.Duration(0))),
+		validation.Field(&a.Webhook),
+	)
+}
+
+func (w Webhook) Validate() error {
+
+	return validation.ValidateStruct(&w,
+		validation.Field(&w.URL, is.URL),
+		validation.Field(&w.Timeout, validation.Min(time.Duration(0))),
+	)
+}
+
+func (d DirectorySync) Validate() error {
+
+	return validation.ValidateStruct(&d,
+		validation.Field(&d.CheckInterval, validation.Min(time.Duration(0))),
+		validation.Field(&d.Timeout, validation.Required, validation.Min(time.Duration(0))),
+	)
+}
+
+func (o Organizations) Validate() error {
+
+	return validation.ValidateStruct(&o,
+		validation.Field(&o.Retention, validation.Min(time.Duration(0))),
+		validation.Field(&o.PurgeInterval, validation.Min(time.Duration(0))),
+	)
+}
+
+func (s ServiceAccounts) Validate() error {
+
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.TokenTTL, validation.Min(time.Duration(0))),
+	)
+}
+
+func (l Limits) Validate() error {
+
+	return validation.ValidateStruct(&l,
+		validation.Field(&l.RateLimit, validation.Min(float64(0))),
+		validation.Field(&l.Burst, validation.Min(0)),
+		validation.Field(&l.MaxUsers, validation.Min(0)),
+		validation.Field(&l.MaxRoles, validation.Min(0)),
+		validation.Field(&l.MaxGroups, validation.Min(0)),
+		validation.Field(&l.MaxPolicies, validation.Min(0)),
+		validation.Field(&l.MaxChecksPerMonth, validation.Min(int64(0))),
+	)
+}
+
+func (t Tracing) Validate() error {
+
+	return validation.ValidateStruct(&t,
+		validation.Field(&t.Endpoint, validation.When(t.Enabled, validation.Required)),
+		validation.Field(&t.SampleRatio, validation.Min(float64(0)), validation.Max(float64(1))),
+	)
+}
+
+func (e APIEndpoint) Validate() error {
+
+	return validation.ValidateStruct(&e,
+		validation.Field(&e.Path, validation.Required),
+		validation.Field(&e.Resource, validation.Required),
+		validation.Field(&e.Methods, validation.Required),
+	)
+}
+
+func (m MethodDetail) Validate() error {
+
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Method, validation.Required),
+	)
+}
+
+func (e ExtAuthz) Validate() error {
+
+	return validation.ValidateStruct(&e,
+		validation.Field(&e.Organization, validation.When(e.Enabled, validation.Required)),
+		validation.Field(&e.APIKey, validation.When(e.Enabled, validation.Required)),
+		validation.Field(&e.Routes, validation.When(e.Enabled, validation.Required)),
+		validation.Field(&e.Subject, validation.By(func(interface{}) error {
+			if e.Subject.Provision && e.Subject.Header != "" {
+				return validation.NewError("validation_provision_requires_jwt", "provision requires JWT subjects, not a header")
+			}
+			return nil
+		})),
+	)
+}
+
+func (r ExtAuthzRoute) Validate() error {
+
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Path, validation.Required),
+		validation.Field(&r.Resource, validation.Required),
+		validation.Field(&r.Methods, validation.Required),
+	)
+}
+
+func (m ExtAuthzMethod) Validate() error {
+
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Method, validation.Required),
+		validation.Field(&m.Action, validation.Required),
+	)
 }
@@ -0,0 +1,312 @@
+package config
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// EnvPrefix prefixes the environment variables of configuration values,
+	// e.g. CRONUSEO_DATABASE_PASSWORD sets database.password. A variable with
+	// the _FILE suffix reads the value from a file.
+	EnvPrefix = "CRONUSEO_"
+	// secretFilePrefix marks the values of secret fields read from a file,
+	// e.g. "file:/run/secrets/mongo_password".
+	secretFilePrefix = "file:"
+	// reloadInterval is how often watched configuration files are checked for
+	// changes.
+	reloadInterval = 5 * time.Second
+)
+
+// Default returns the defaults the configuration files, environment variables
+// and flags override.
+func Default() Config {
+
+	c := Config{}
+	c.Server.Endpoint = ":8080"
+	c.Server.ShutdownTimeout = 30 * time.Second
+	c.CheckServer.Endpoint = ":5005"
+	c.CheckServer.ShutdownTimeout = 30 * time.Second
+	c.Log.Level = "info"
+	c.CORS.AllowOrigins = []string{"http://localhost:3000"}
+	c.CORS.AllowCredentials = true
+	c.Assignments.SweepInterval = time.Minute
+	c.AccessRequests.MaxDuration = 24 * time.Hour
+	c.AccessRequests.Webhook.Timeout = 10 * time.Second
+	c.DirectorySync.CheckInterval = time.Minute
+	c.DirectorySync.Timeout = 30 * time.Second
+	c.Organizations.Retention = 30 * 24 * time.Hour
+	c.Organizations.PurgeInterval = time.Hour
+	return c
+}
+
+// Source is the layers of the configuration. The defaults are overridden by
+// the YAML files in order, then by the CRONUSEO_ environment variables and
+// then by overrides of the form "key.path=value", e.g.
+// "check_server.endpoint=:5006". Slices of strings are comma separated.
+type Source struct {
+	Files     []string
+	Environ   []string
+	Overrides []string
+}
+
+// NewSource registers the -config and -set flags of the source on the flag
+// set. -config takes comma separated files and -set an override, both may be
+// repeated.
+func NewSource(fs *flag.FlagSet, defaultFile string) *Source {
+
+	s := &Source{Files: []string{defaultFile}, Environ: os.Environ()}
+	fs.Var(&filesFlag{files: &s.Files}, "config", "comma separated paths to the config files, later files override earlier ones")
+	fs.Var(&overridesFlag{overrides: &s.Overrides}, "set", "override a config value, e.g. -set server.endpoint=:8081")
+	return s
+}
+
+// Load the configuration from a file and the environment variables.
+func Load(file string) (*Config, error) {
+
+	return Source{Files: []string{file}, Environ: os.Environ()}.Load()
+}
+
+// Load the layers of the configuration and validate it.
+func (s Source) Load() (*Config, error) {
+
+	c := Default()
+	for _, file := range s.Files {
+		bytes, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err = yaml.Unmarshal(bytes, &c); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+
+	fields := leafFields(reflect.ValueOf(&c).Elem(), "")
+	env := map[string]string{}
+	for _, kv := range s.Environ {
+		if key, value, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(key, EnvPrefix) {
+			env[key] = value
+		}
+	}
+	for _, f := range fields {
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(f.key, ".", "_"))
+		if value, ok := env[name]; ok {
+			if err := f.set(value); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		if file, ok := env[name+"_FILE"]; ok {
+			value, err := readSecret(file)
+			if err != nil {
+				return nil, fmt.Errorf("%s_FILE: %v", name, err)
+			}
+			if err := f.set(value); err != nil {
+				return nil, fmt.Errorf("%s_FILE: %v", name, err)
+			}
+		}
+	}
+
+	byKey := map[string]field{}
+	for _, f := range fields {
+		byKey[f.key] = f
+	}
+	for _, override := range s.Overrides {
+		key, value, ok := strings.Cut(override, "=")
+		f, known := byKey[strings.TrimSpace(key)]
+		if !ok || !known {
+			return nil, fmt.Errorf("invalid config override %q", override)
+		}
+		if err := f.set(value); err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+
+	for _, f := range fields {
+		if !f.secret || f.value.Kind() != reflect.String || !strings.HasPrefix(f.value.String(), secretFilePrefix) {
+			continue
+		}
+		value, err := readSecret(strings.TrimPrefix(f.value.String(), secretFilePrefix))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.key, err)
+		}
+		f.value.SetString(value)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Watch loads the configuration on SIGHUP and when a configuration file
+// changes, and calls apply with it, until the context is done. Invalid
+// configurations are logged and not applied.
+func (s Source) Watch(ctx context.Context, logger *zap.Logger, apply func(*Config) error) {
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	versions := s.versions()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logger.Info("Reloading configuration on SIGHUP.")
+		case <-ticker.C:
+			current := s.versions()
+			if reflect.DeepEqual(current, versions) {
+				continue
+			}
+			logger.Info("Reloading changed configuration files.", zap.Strings("files", s.Files))
+		}
+		versions = s.versions()
+		cfg, err := s.Load()
+		if err == nil {
+			err = apply(cfg)
+		}
+		if err != nil {
+			logger.Error("Configuration was not reloaded.", zap.Error(err))
+		}
+	}
+}
+
+// versions returns the modification time and size of the files.
+func (s Source) versions() []string {
+
+	versions := make([]string, len(s.Files))
+	for i, file := range s.Files {
+		if info, err := os.Stat(file); err == nil {
+			versions[i] = fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return versions
+}
+
+// field is a configurable value, keyed by the dotted path of its YAML names.
+type field struct {
+	key    string
+	value  reflect.Value
+	secret bool
+}
+
+// leafFields returns the scalar and string slice fields of a struct. Slices of
+// structs, such as issuers and endpoints, are only configurable in files.
+func leafFields(v reflect.Value, prefix string) []field {
+
+	var fields []field
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		name, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		value := v.Field(i)
+		switch {
+		case value.Kind() == reflect.Struct:
+			fields = append(fields, leafFields(value, key+".")...)
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() != reflect.String,
+			value.Kind() == reflect.Map, value.Kind() == reflect.Ptr:
+			continue
+		default:
+			_, options, _ := strings.Cut(sf.Tag.Get("env"), ",")
+			fields = append(fields, field{key: key, value: value, secret: options == "secret"})
+		}
+	}
+	return fields
+}
+
+// set parses a value of the field from a string.
+func (f field) set(raw string) error {
+
+	switch {
+	case raw == "":
+		f.value.Set(reflect.Zero(f.value.Type()))
+	case f.value.Kind() == reflect.String:
+		f.value.SetString(raw)
+	case f.value.Kind() == reflect.Slice:
+		values := []string{}
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		f.value.Set(reflect.ValueOf(values))
+	default:
+		// Scalars are parsed like YAML values, e.g. durations as "30s".
+		return yaml.UnmarshalStrict([]byte(raw), f.value.Addr().Interface())
+	}
+	return nil
+}
+
+func readSecret(file string) (string, error) {
+
+	bytes, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bytes)), nil
+}
+
+// filesFlag replaces the default files when set, and appends when repeated.
+type filesFlag struct {
+	files *[]string
+	set   bool
+}
+
+func (f *filesFlag) String() string {
+
+	if f.files == nil {
+		return ""
+	}
+	return strings.Join(*f.files, ",")
+}
+
+func (f *filesFlag) Set(value string) error {
+
+	if !f.set {
+		*f.files = nil
+		f.set = true
+	}
+	for _, file := range strings.Split(value, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			*f.files = append(*f.files, file)
+		}
+	}
+	return nil
+}
+
+type overridesFlag struct {
+	overrides *[]string
+}
+
+func (f *overridesFlag) String() string {
+
+	if f.overrides == nil {
+		return ""
+	}
+	return strings.Join(*f.overrides, " ")
+}
+
+func (f *overridesFlag) Set(value string) error {
+
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected key.path=value")
+	}
+	*f.overrides = append(*f.overrides, value)
+	return nil
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const base = `
+server:
+  endpoint: ":8080"
+database:
+  url: "mongodb://localhost:27017"
+  name: "cronuseo"
+  user: "cronuseo"
+  password: "file:%s"
+root_organization:
+  name: "super"
+`
+
+func write(t *testing.T, name string, content string) string {
+
+	file := filepath.Join(t.TempDir(), name)
+	assert.Nil(t, os.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func TestSourceLoad(t *testing.T) {
+
+	password := write(t, "password", "s3cret\n")
+	baseFile := write(t, "base.yml", fmt.Sprintf(base, password))
+	overlay := write(t, "overlay.yml", "server:\n  shutdown_timeout: \"10s\"\nlog:\n  level: \"warn\"\n")
+	user := write(t, "user", "admin")
+
+	cfg, err := Source{
+		Files: []string{baseFile, overlay},
+		Environ: []string{
+			"CRONUSEO_SERVER_ENDPOINT=:9090",
+			"CRONUSEO_LIMITS_RATE_LIMIT=2.5",
+			"CRONUSEO_CORS_ALLOW_ORIGINS=https://a.example.com, https://b.example.com",
+			"CRONUSEO_DATABASE_USER_FILE=" + user,
+			"OTHER_SERVER_ENDPOINT=:1",
+		},
+		Overrides: []string{"server.endpoint=:9091", "check_server.shutdown_timeout=1m"},
+	}.Load()
+	assert.Nil(t, err)
+
+	assert.Equal(t, ":9091", cfg.Server.Endpoint)
+	assert.Equal(t, 10*time.Second, cfg.Server.ShutdownTimeout)
+	assert.Equal(t, time.Minute, cfg.CheckServer.ShutdownTimeout)
+	assert.Equal(t, ":5005", cfg.CheckServer.Endpoint)
+	assert.Equal(t, "warn", cfg.Log.Level)
+	assert.Equal(t, 2.5, cfg.Limits.RateLimit)
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, cfg.CORS.AllowOrigins)
+	assert.Equal(t, "admin", cfg.Database.User)
+	assert.Equal(t, "s3cret", cfg.Database.Password)
+
+	invalid := []Source{
+		{Files: []string{baseFile}, Overrides: []string{"server.unknown=1"}},
+		{Files: []string{baseFile}, Overrides: []string{"server.shutdown_timeout=soon"}},
+		{Files: []string{baseFile}, Overrides: []string{"log.level=verbose"}},
+		{Files: []string{baseFile}, Overrides: []string{"tracing.enabled=true", "tracing.endpoint="}},
+		{Files: []string{baseFile}, Environ: []string{"CRONUSEO_LIMITS_BURST=-1"}},
+		{Files: []string{baseFile, write(t, "tls.yml", "check_server:\n  tls:\n    enabled: true\n")}},
+		{Files: []string{filepath.Join(t.TempDir(), "missing.yml")}},
+	}
+	for i, source := range invalid {
+		_, err := source.Load()
+		assert.NotNil(t, err, i)
+	}
+}
+
+func TestNewSource(t *testing.T) {
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	source := NewSource(fs, "./config/local.yml")
+	assert.Nil(t, fs.Parse([]string{"-config", "a.yml,b.yml", "-config", "c.yml", "-set", "server.endpoint=:1"}))
+	assert.Equal(t, []string{"a.yml", "b.yml", "c.yml"}, source.Files)
+	assert.Equal(t, []string{"server.endpoint=:1"}, source.Overrides)
+	assert.NotNil(t, fs.Parse([]string{"-set", "server.endpoint"}))
+}
+
+// The shipped configurations must be valid once the database is configured.
+func TestShippedConfigs(t *testing.T) {
+
+	environ := []string{
+		"CRONUSEO_DATABASE_URL=mongodb://localhost:27017",
+		"CRONUSEO_DATABASE_NAME=cronuseo",
+		"CRONUSEO_DATABASE_USER=cronuseo",
+		"CRONUSEO_DATABASE_PASSWORD=cronuseo",
+	}
+	for _, file := range []string{"local.yml", "local-debug.yml", "run-test.yml"} {
+		_, err := Source{Files: []string{"../../config/" + file}, Environ: environ}.Load()
+		assert.Nil(t, err, file)
+	}
+}
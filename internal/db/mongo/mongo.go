@@ -2,11 +2,13 @@ package mongo
 
 import (
 	"context"
+	"time"
 
 	"github.com/shashimalcse/cronuseo/internal/config"
 	"github.com/shashimalcse/cronuseo/internal/util"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.uber.org/zap"
 )
 
@@ -21,7 +23,9 @@ func Init(cfg *config.Config, logger *zap.Logger) (*MongoDB, error) {
 		Username: cfg.Database.User,
 		Password: cfg.Database.Password,
 	}
-	mongoClient, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(cfg.Database.URL).SetAuth(credential))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.Database.URL).SetAuth(credential))
 	if err != nil {
 		logger.Fatal("Error while connecting to MongoDB", zap.String("error", err.Error()))
 		return nil, err
@@ -35,3 +39,16 @@ func Init(cfg *config.Config, logger *zap.Logger) (*MongoDB, error) {
 	mongodb := &MongoDB{MongoClient: mongoClient, MongoConfig: mongoConfig}
 	return mongodb, nil
 }
+
+// Ping checks that the primary of the MongoDB deployment is reachable.
+func (m *MongoDB) Ping(ctx context.Context) error {
+
+	return m.MongoClient.Ping(ctx, readpref.Primary())
+}
+
+// Disconnect closes the connections of the MongoDB client, waiting for in use
+// connections until the context is done.
+func (m *MongoDB) Disconnect(ctx context.Context) error {
+
+	return m.MongoClient.Disconnect(ctx)
+}
@@ -0,0 +1,109 @@
+package directory
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/util"
+)
+
+func RegisterHandlers(r *echo.Group, service Service) {
+	res := resource{service}
+	router := r.Group("/o/:org_id/directory-sync")
+	router.GET("", res.get)
+	router.PUT("", res.update)
+	router.DELETE("", res.delete)
+	router.POST("/run", res.run)
+	router.GET("/history", res.history)
+}
+
+type resource struct {
+	service Service
+}
+
+// @Description Get the directory sync configuration of the organization.
+// @Tags        User
+// @Param org_id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {object}  Config
+// @failure     404,500
+// @Router      /o/{org_id}/directory-sync [get]
+func (r resource) get(c echo.Context) error {
+
+	config, err := r.service.Get(c.Request().Context(), c.Param("org_id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, config)
+}
+
+// @Description Replace the directory sync configuration of the organization.
+// @Tags        User
+// @Accept      json
+// @Param org_id path string true "Organization ID"
+// @Param request body UpdateConfigRequest true "body"
+// @Produce     json
+// @Success     200 {object}  Config
+// @failure     400,404,500
+// @Router      /o/{org_id}/directory-sync [put]
+func (r resource) update(c echo.Context) error {
+
+	var input UpdateConfigRequest
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	config, err := r.service.Update(c.Request().Context(), c.Param("org_id"), input)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, config)
+}
+
+// @Description Delete the directory sync configuration and history of the organization.
+// @Tags        User
+// @Param org_id path string true "Organization ID"
+// @Success     204
+// @failure     404,500
+// @Router      /o/{org_id}/directory-sync [delete]
+func (r resource) delete(c echo.Context) error {
+
+	if err := r.service.Delete(c.Request().Context(), c.Param("org_id")); err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusNoContent, "")
+}
+
+// @Description Sync the directory of the organization, or report the changes of a dry run.
+// @Tags        User
+// @Param org_id path string true "Organization ID"
+// @Param dry_run query bool false "Only report the changes"
+// @Produce     json
+// @Success     200 {object}  Report
+// @failure     404,409,500
+// @Router      /o/{org_id}/directory-sync/run [post]
+func (r resource) run(c echo.Context) error {
+
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+	report, err := r.service.Run(c.Request().Context(), c.Param("org_id"), RunRequest{DryRun: dryRun})
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// @Description Get the recent directory sync runs of the organization, latest first.
+// @Tags        User
+// @Param org_id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {array}  Run
+// @failure     404,500
+// @Router      /o/{org_id}/directory-sync/history [get]
+func (r resource) history(c echo.Context) error {
+
+	runs, err := r.service.History(c.Request().Context(), c.Param("org_id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, runs)
+}
@@ -0,0 +1,222 @@
+package directory
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+)
+
+// Default filters and attributes, those of Active Directory.
+const (
+	DefaultUserFilter                = "(objectClass=person)"
+	DefaultGroupFilter               = "(objectClass=group)"
+	DefaultUserIdentifierAttribute   = "userPrincipalName"
+	DefaultUsernameAttribute         = "sAMAccountName"
+	DefaultGroupIdentifierAttribute  = "cn"
+	DefaultGroupDisplayNameAttribute = "displayName"
+	DefaultMemberAttribute           = "member"
+)
+
+// pageSize is the size of the pages of LDAP searches.
+const pageSize = 500
+
+// Snapshot is the users and groups of a directory.
+type Snapshot struct {
+	Users  []User
+	Groups []Group
+}
+
+// User is a user entry of the directory.
+type User struct {
+	DN         string
+	Identifier string
+	Username   string
+	Properties map[string]interface{}
+}
+
+// Group is a group entry of the directory, its members the DNs of the member
+// attribute.
+type Group struct {
+	DN          string
+	Identifier  string
+	DisplayName string
+	Members     []string
+}
+
+// Directory loads the users and groups of a directory sync configuration.
+type Directory interface {
+	Load(ctx context.Context, config mongo_entity.DirectorySync) (Snapshot, error)
+}
+
+type ldapDirectory struct {
+	timeout time.Duration
+}
+
+// NewLDAP creates the directory of LDAP servers. The timeout bounds the
+// connection and every request.
+func NewLDAP(timeout time.Duration) Directory {
+
+	return ldapDirectory{timeout: timeout}
+}
+
+// Load binds to the LDAP server of the configuration and searches the users
+// and groups under its base DNs. Entries without an identifier are skipped.
+func (d ldapDirectory) Load(ctx context.Context, config mongo_entity.DirectorySync) (Snapshot, error) {
+
+	ctx, span := telemetry.Start(ctx, "directory.ldap.Load")
+	defer span.End()
+
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: config.InsecureSkipVerify}
+	conn, err := ldap.DialURL(config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: d.timeout}), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer conn.Close()
+	conn.SetTimeout(d.timeout)
+	if config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			return Snapshot{}, err
+		}
+	}
+	if config.BindDN != "" {
+		if err := conn.Bind(config.BindDN, config.BindPassword); err != nil {
+			return Snapshot{}, err
+		}
+	}
+
+	attributes := withDefaults(config.Attributes)
+	userAttributes := []string{attributes.UserIdentifier, attributes.Username}
+	for _, property := range attributes.Properties {
+		userAttributes = append(userAttributes, property.Attribute)
+	}
+	entries, err := search(conn, config.UserBaseDNs, filterOrDefault(config.UserFilter, DefaultUserFilter), userAttributes)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snapshot := Snapshot{Users: []User{}, Groups: []Group{}}
+	for _, entry := range entries {
+		user := User{
+			DN:         entry.DN,
+			Identifier: entry.GetEqualFoldAttributeValue(attributes.UserIdentifier),
+			Username:   entry.GetEqualFoldAttributeValue(attributes.Username),
+		}
+		if user.Identifier == "" {
+			continue
+		}
+		if user.Username == "" {
+			user.Username = user.Identifier
+		}
+		for _, property := range attributes.Properties {
+			values := entry.GetEqualFoldAttributeValues(property.Attribute)
+			if len(values) == 0 {
+				continue
+			}
+			if user.Properties == nil {
+				user.Properties = map[string]interface{}{}
+			}
+			if len(values) == 1 {
+				user.Properties[property.Property] = values[0]
+			} else {
+				user.Properties[property.Property] = values
+			}
+		}
+		snapshot.Users = append(snapshot.Users, user)
+	}
+
+	groupAttributes := []string{attributes.GroupIdentifier, attributes.GroupDisplayName, attributes.Member}
+	entries, err = search(conn, config.GroupBaseDNs, filterOrDefault(config.GroupFilter, DefaultGroupFilter), groupAttributes)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	for _, entry := range entries {
+		group := Group{
+			DN:          entry.DN,
+			Identifier:  entry.GetEqualFoldAttributeValue(attributes.GroupIdentifier),
+			DisplayName: entry.GetEqualFoldAttributeValue(attributes.GroupDisplayName),
+			Members:     entry.GetEqualFoldAttributeValues(attributes.Member),
+		}
+		if group.Identifier == "" {
+			continue
+		}
+		if group.DisplayName == "" {
+			group.DisplayName = group.Identifier
+		}
+		snapshot.Groups = append(snapshot.Groups, group)
+	}
+	return snapshot, nil
+}
+
+// search returns the entries matching the filter under the base DNs.
+func search(conn *ldap.Conn, baseDNs []string, filter string, attributes []string) ([]*ldap.Entry, error) {
+
+	entries := []*ldap.Entry{}
+	for _, baseDN := range baseDNs {
+		req := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			filter, attributes, nil)
+		result, err := conn.SearchWithPaging(req, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, result.Entries...)
+	}
+	return entries, nil
+}
+
+// withDefaults fills the unset attributes with the defaults.
+func withDefaults(attributes mongo_entity.DirectoryAttributes) mongo_entity.DirectoryAttributes {
+
+	if attributes.UserIdentifier == "" {
+		attributes.UserIdentifier = DefaultUserIdentifierAttribute
+	}
+	if attributes.Username == "" {
+		attributes.Username = DefaultUsernameAttribute
+	}
+	if attributes.GroupIdentifier == "" {
+		attributes.GroupIdentifier = DefaultGroupIdentifierAttribute
+	}
+	if attributes.GroupDisplayName == "" {
+		attributes.GroupDisplayName = DefaultGroupDisplayNameAttribute
+	}
+	if attributes.Member == "" {
+		attributes.Member = DefaultMemberAttribute
+	}
+	return attributes
+}
+
+func filterOrDefault(filter string, defaultFilter string) string {
+
+	if filter == "" {
+		return defaultFilter
+	}
+	return filter
+}
+
+// dnKey returns the DN in a form that compares equal for equal DNs, ignoring
+// case and spacing.
+func dnKey(dn string) string {
+
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(dn))
+	}
+	rdns := make([]string, len(parsed.RDNs))
+	for i, rdn := range parsed.RDNs {
+		attributes := make([]string, len(rdn.Attributes))
+		for j, attribute := range rdn.Attributes {
+			attributes[j] = strings.ToLower(attribute.Type) + "=" + strings.ToLower(attribute.Value)
+		}
+		rdns[i] = strings.Join(attributes, "+")
+	}
+	return strings.Join(rdns, ",")
+}
@@ -0,0 +1,277 @@
+package directory
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testBindDN       = "cn=sync,dc=acme,dc=com"
+	testBindPassword = "secret"
+	testTimeout      = 5 * time.Second
+)
+
+// testServer is an embedded LDAP server with simple binds, and searches with
+// and, or, not, equality and presence filters.
+type testServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	entries  map[string]map[string][]string
+}
+
+func newTestServer(t *testing.T) *testServer {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{listener: listener, entries: map[string]map[string][]string{}}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) url() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// set adds or replaces the entry, its attributes as name value pairs.
+func (s *testServer) set(dn string, attributes ...string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := map[string][]string{}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		entry[attributes[i]] = append(entry[attributes[i]], attributes[i+1])
+	}
+	s.entries[dn] = entry
+}
+
+func (s *testServer) remove(dn string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, dn)
+}
+
+func (s *testServer) serve(conn net.Conn) {
+
+	defer conn.Close()
+	bound := false
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if op.Children[1].Data.String() == testBindDN && op.Children[2].Data.String() == testBindPassword {
+				code, bound = ldap.LDAPResultSuccess, true
+			}
+			conn.Write(message(id, ldap.ApplicationBindResponse, result(code)...).Bytes())
+		case ldap.ApplicationSearchRequest:
+			if !bound {
+				conn.Write(message(id, ldap.ApplicationSearchResultDone, result(ldap.LDAPResultInsufficientAccessRights)...).Bytes())
+				continue
+			}
+			base := dnKey(op.Children[0].Data.String())
+			requested := map[string]bool{}
+			for _, attribute := range op.Children[7].Children {
+				requested[strings.ToLower(attribute.Data.String())] = true
+			}
+			for _, entry := range s.search(base, op.Children[6], requested) {
+				conn.Write(message(id, ldap.ApplicationSearchResultEntry, entry...).Bytes())
+			}
+			conn.Write(message(id, ldap.ApplicationSearchResultDone, result(ldap.LDAPResultSuccess)...).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+// search returns the requested attributes of the entries under the base
+// matching the filter.
+func (s *testServer) search(base string, filter *ber.Packet, requested map[string]bool) [][]*ber.Packet {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := [][]*ber.Packet{}
+	for dn, attributes := range s.entries {
+		key := dnKey(dn)
+		if (key != base && !strings.HasSuffix(key, ","+base)) || !matches(filter, attributes) {
+			continue
+		}
+		list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		for name, values := range attributes {
+			if !requested[strings.ToLower(name)] {
+				continue
+			}
+			attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, value := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+			}
+			attribute.AppendChild(set)
+			list.AppendChild(attribute)
+		}
+		results = append(results, []*ber.Packet{
+			ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""),
+			list,
+		})
+	}
+	return results
+}
+
+func matches(filter *ber.Packet, attributes map[string][]string) bool {
+
+	values := func(name string) []string {
+		for key, values := range attributes {
+			if strings.EqualFold(key, name) {
+				return values
+			}
+		}
+		return nil
+	}
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(child, attributes) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matches(child, attributes) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matches(filter.Children[0], attributes)
+	case ldap.FilterEqualityMatch:
+		for _, value := range values(filter.Children[0].Data.String()) {
+			if strings.EqualFold(value, filter.Children[1].Data.String()) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(values(filter.Data.String())) > 0
+	}
+	return false
+}
+
+func message(id int64, tag ber.Tag, children ...*ber.Packet) *ber.Packet {
+
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	for _, child := range children {
+		op.AppendChild(child)
+	}
+	packet.AppendChild(op)
+	return packet
+}
+
+func result(code uint16) []*ber.Packet {
+
+	return []*ber.Packet{
+		ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""),
+		ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""),
+		ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""),
+	}
+}
+
+// newTestDirectory returns an Active Directory like server with two users in
+// the sales group.
+func newTestDirectory(t *testing.T) *testServer {
+
+	s := newTestServer(t)
+	s.set("CN=Jane Doe,OU=Users,DC=acme,DC=com", "objectClass", "person", "userPrincipalName", "jane@acme.com",
+		"sAMAccountName", "jane", "department", "finance", "mail", "jane@acme.com", "mail", "jd@acme.com")
+	s.set("CN=John Roe,OU=Users,DC=acme,DC=com", "objectClass", "person", "userPrincipalName", "john@acme.com",
+		"sAMAccountName", "john")
+	s.set("CN=Printer,OU=Users,DC=acme,DC=com", "objectClass", "person", "sAMAccountName", "printer")
+	s.set("CN=Sales,OU=Groups,DC=acme,DC=com", "objectClass", "group", "cn", "sales", "displayName", "Sales",
+		"member", "cn=jane doe,ou=users,dc=acme,dc=com", "member", "CN=John Roe, OU=Users, DC=acme, DC=com")
+	s.set("CN=Elsewhere,OU=Other,DC=acme,DC=com", "objectClass", "group", "cn", "elsewhere")
+	return s
+}
+
+func testConfig(s *testServer) mongo_entity.DirectorySync {
+
+	return mongo_entity.DirectorySync{
+		Enabled:      true,
+		URL:          s.url(),
+		BindDN:       testBindDN,
+		BindPassword: testBindPassword,
+		UserBaseDNs:  []string{"OU=Users,DC=acme,DC=com"},
+		GroupBaseDNs: []string{"OU=Groups,DC=acme,DC=com"},
+		Attributes: mongo_entity.DirectoryAttributes{Properties: []mongo_entity.DirectoryAttribute{
+			{Attribute: "department", Property: "department"},
+			{Attribute: "mail", Property: "emails"},
+		}},
+	}
+}
+
+func TestLoad(t *testing.T) {
+
+	s := newTestDirectory(t)
+	config := testConfig(s)
+	snapshot, err := NewLDAP(testTimeout).Load(context.Background(), config)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []User{
+		{
+			DN:         "CN=Jane Doe,OU=Users,DC=acme,DC=com",
+			Identifier: "jane@acme.com",
+			Username:   "jane",
+			Properties: map[string]interface{}{"department": "finance", "emails": []string{"jane@acme.com", "jd@acme.com"}},
+		},
+		{DN: "CN=John Roe,OU=Users,DC=acme,DC=com", Identifier: "john@acme.com", Username: "john"},
+	}, snapshot.Users)
+	assert.Equal(t, []Group{{
+		DN:          "CN=Sales,OU=Groups,DC=acme,DC=com",
+		Identifier:  "sales",
+		DisplayName: "Sales",
+		Members:     []string{"cn=jane doe,ou=users,dc=acme,dc=com", "CN=John Roe, OU=Users, DC=acme, DC=com"},
+	}}, snapshot.Groups)
+
+	config.UserFilter = "(&(objectClass=person)(!(sAMAccountName=john)))"
+	config.GroupBaseDNs = nil
+	snapshot, err = NewLDAP(testTimeout).Load(context.Background(), config)
+	assert.Nil(t, err)
+	assert.Len(t, snapshot.Users, 1)
+	assert.Empty(t, snapshot.Groups)
+
+	config.BindPassword = "wrong"
+	_, err = NewLDAP(testTimeout).Load(context.Background(), config)
+	assert.True(t, ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials))
+}
+
+func TestDNKey(t *testing.T) {
+
+	assert.Equal(t, dnKey("CN=John Roe,OU=Users,DC=acme,DC=com"), dnKey("cn=john roe, ou=users, dc=acme, dc=com"))
+	assert.NotEqual(t, dnKey("CN=John Roe,OU=Users,DC=acme,DC=com"), dnKey("CN=Jane Doe,OU=Users,DC=acme,DC=com"))
+}
@@ -0,0 +1,346 @@
+package directory
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"github.com/shashimalcse/cronuseo/internal/group"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/user"
+	"github.com/shashimalcse/cronuseo/internal/userschema"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Actions of sync changes.
+const (
+	ActionCreateUser   = "create_user"
+	ActionUpdateUser   = "update_user"
+	ActionDeleteUser   = "delete_user"
+	ActionCreateGroup  = "create_group"
+	ActionUpdateGroup  = "update_group"
+	ActionDeleteGroup  = "delete_group"
+	ActionAddMember    = "add_member"
+	ActionRemoveMember = "remove_member"
+)
+
+// reconciler applies the changes of a sync run, or only reports them in a
+// dry run. Users and groups are keyed by identifier, those created in a dry
+// run have zero ids.
+type reconciler struct {
+	s         service
+	org_id    string
+	dryRun    bool
+	report    *Report
+	users     map[string]primitive.ObjectID
+	groups    map[string]primitive.ObjectID
+	newGroups map[string]bool
+}
+
+// reconcile makes the users, groups and memberships of the organization match
+// the snapshot. Users and groups are created and updated, and deleted when
+// they were synced before, left the directory and the sync deletes missing
+// ones. Only the memberships of directory users are changed, and user
+// properties are only set, never removed.
+func (s service) reconcile(ctx context.Context, org_id string, config mongo_entity.DirectorySync, snapshot Snapshot, report *Report) error {
+
+	if config.DeleteMissing && len(snapshot.Users) == 0 && len(config.Synced.Users) > 0 {
+		return errors.New("the directory returned no users, refusing to delete all synced users")
+	}
+	existingUsers, err := s.users.Query(ctx, org_id, user.Filter{})
+	if err != nil {
+		return err
+	}
+	existingGroups, err := s.groups.Query(ctx, org_id, group.Filter{})
+	if err != nil {
+		return err
+	}
+	r := reconciler{
+		s:         s,
+		org_id:    org_id,
+		dryRun:    report.DryRun,
+		report:    report,
+		users:     map[string]primitive.ObjectID{},
+		groups:    map[string]primitive.ObjectID{},
+		newGroups: map[string]bool{},
+	}
+	users := map[string]user.User{}
+	for _, existing := range existingUsers {
+		users[existing.Identifier] = existing
+	}
+	groups := map[string]group.Group{}
+	for _, existing := range existingGroups {
+		groups[existing.Identifier] = existing
+	}
+
+	synced := mongo_entity.DirectorySyncState{Users: []string{}, Groups: []string{}}
+	directoryUsers := map[string]bool{}
+	dns := map[string]string{}
+	for _, u := range snapshot.Users {
+		if directoryUsers[u.Identifier] {
+			continue
+		}
+		directoryUsers[u.Identifier] = true
+		dns[dnKey(u.DN)] = u.Identifier
+		if r.user(ctx, u, users) {
+			synced.Users = append(synced.Users, u.Identifier)
+		}
+	}
+	directoryGroups := map[string]bool{}
+	for _, g := range snapshot.Groups {
+		if directoryGroups[g.Identifier] {
+			continue
+		}
+		directoryGroups[g.Identifier] = true
+		if r.group(ctx, g, groups) {
+			synced.Groups = append(synced.Groups, g.Identifier)
+		}
+	}
+
+	// The members of previously synced users are managed too, so that users
+	// leaving the directory leave its groups.
+	managed := map[string]bool{}
+	for identifier := range directoryUsers {
+		managed[identifier] = true
+	}
+	for _, identifier := range config.Synced.Users {
+		managed[identifier] = true
+	}
+	reconciled := map[string]bool{}
+	for _, g := range snapshot.Groups {
+		if reconciled[g.Identifier] {
+			continue
+		}
+		reconciled[g.Identifier] = true
+		if err := r.members(ctx, g, dns, managed); err != nil {
+			return err
+		}
+	}
+
+	// Synced users and groups that left the directory are deleted, or stay
+	// synced so that a later sync may delete them.
+	for _, identifier := range config.Synced.Users {
+		existing, ok := users[identifier]
+		if !ok || directoryUsers[identifier] {
+			continue
+		}
+		if !config.DeleteMissing {
+			synced.Users = append(synced.Users, identifier)
+			continue
+		}
+		change := Change{Action: ActionDeleteUser, User: identifier}
+		if !r.dryRun {
+			change.Error = errorResponse(s.users.Delete(ctx, org_id, existing.ID.Hex()))
+		}
+		r.record(change, &report.Summary.UsersDeleted)
+		if change.Error != nil {
+			synced.Users = append(synced.Users, identifier)
+		}
+	}
+	for _, identifier := range config.Synced.Groups {
+		existing, ok := groups[identifier]
+		if !ok || directoryGroups[identifier] {
+			continue
+		}
+		if !config.DeleteMissing {
+			synced.Groups = append(synced.Groups, identifier)
+			continue
+		}
+		change := Change{Action: ActionDeleteGroup, Group: identifier}
+		if !r.dryRun {
+			change.Error = errorResponse(s.groups.Delete(ctx, org_id, existing.ID.Hex()))
+		}
+		r.record(change, &report.Summary.GroupsDeleted)
+		if change.Error != nil {
+			synced.Groups = append(synced.Groups, identifier)
+		}
+	}
+	if r.dryRun {
+		return nil
+	}
+	return s.repo.SetSynced(ctx, org_id, synced)
+}
+
+// user creates or updates the user, and returns whether it exists.
+func (r *reconciler) user(ctx context.Context, u User, existing map[string]user.User) bool {
+
+	current, ok := existing[u.Identifier]
+	if !ok {
+		change := Change{Action: ActionCreateUser, User: u.Identifier}
+		var id primitive.ObjectID
+		if !r.dryRun {
+			created, err := r.s.users.Create(ctx, r.org_id, user.CreateUserRequest{
+				Username:       u.Username,
+				Identifier:     u.Identifier,
+				UserProperties: u.Properties,
+			})
+			change.Error = errorResponse(err)
+			id = created.ID
+		}
+		r.record(change, &r.report.Summary.UsersCreated)
+		if change.Error != nil {
+			return false
+		}
+		r.users[u.Identifier] = id
+		return true
+	}
+
+	r.users[u.Identifier] = current.ID
+	changed := changedProperties(current.UserProperties, u.Properties)
+	if current.Username == u.Username && changed == nil {
+		return true
+	}
+	change := Change{Action: ActionUpdateUser, User: u.Identifier}
+	if !r.dryRun {
+		var err error
+		if current.Username != u.Username {
+			_, err = r.s.users.Update(ctx, r.org_id, current.ID.Hex(), user.UpdateUserRequest{Username: &u.Username})
+		}
+		if err == nil && changed != nil {
+			_, err = r.s.users.Patch(ctx, r.org_id, current.ID.Hex(), user.PatchUserRequest{UserProperties: changed})
+		}
+		change.Error = errorResponse(err)
+	}
+	r.record(change, &r.report.Summary.UsersUpdated)
+	return true
+}
+
+// group creates or updates the group, and returns whether it exists.
+func (r *reconciler) group(ctx context.Context, g Group, existing map[string]group.Group) bool {
+
+	current, ok := existing[g.Identifier]
+	if !ok {
+		change := Change{Action: ActionCreateGroup, Group: g.Identifier}
+		var id primitive.ObjectID
+		if !r.dryRun {
+			created, err := r.s.groups.Create(ctx, r.org_id, group.CreateGroupRequest{
+				Identifier:  g.Identifier,
+				DisplayName: g.DisplayName,
+			})
+			change.Error = errorResponse(err)
+			id = created.ID
+		}
+		r.record(change, &r.report.Summary.GroupsCreated)
+		if change.Error != nil {
+			return false
+		}
+		r.groups[g.Identifier] = id
+		r.newGroups[g.Identifier] = true
+		return true
+	}
+
+	r.groups[g.Identifier] = current.ID
+	if current.DisplayName == g.DisplayName {
+		return true
+	}
+	change := Change{Action: ActionUpdateGroup, Group: g.Identifier}
+	if !r.dryRun {
+		_, err := r.s.groups.Update(ctx, r.org_id, current.ID.Hex(), group.UpdateGroupRequest{DisplayName: &g.DisplayName})
+		change.Error = errorResponse(err)
+	}
+	r.record(change, &r.report.Summary.GroupsUpdated)
+	return true
+}
+
+// members adds the directory members of the group and removes the managed
+// users that are no longer members.
+func (r *reconciler) members(ctx context.Context, g Group, dns map[string]string, managed map[string]bool) error {
+
+	id, ok := r.groups[g.Identifier]
+	if !ok {
+		return nil
+	}
+	members := []mongo_entity.AssignedUser{}
+	if !r.newGroups[g.Identifier] {
+		existing, err := r.s.groups.Get(ctx, r.org_id, id.Hex())
+		if err != nil {
+			return err
+		}
+		members = existing.Users
+	}
+	current := map[string]bool{}
+	for _, member := range members {
+		current[member.Identifier] = true
+	}
+
+	desired := map[string]bool{}
+	patch := group.PatchGroupRequest{}
+	changes := []Change{}
+	for _, dn := range g.Members {
+		identifier, ok := dns[dnKey(dn)]
+		if !ok || desired[identifier] {
+			continue
+		}
+		desired[identifier] = true
+		if current[identifier] {
+			continue
+		}
+		userId, ok := r.users[identifier]
+		if !ok {
+			continue
+		}
+		patch.AddedUsers = append(patch.AddedUsers, userId)
+		changes = append(changes, Change{Action: ActionAddMember, User: identifier, Group: g.Identifier})
+	}
+	for _, member := range members {
+		if managed[member.Identifier] && !desired[member.Identifier] {
+			patch.RemovedUsers = append(patch.RemovedUsers, member.ID)
+			changes = append(changes, Change{Action: ActionRemoveMember, User: member.Identifier, Group: g.Identifier})
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	var failed *util.ErrorResponse
+	if !r.dryRun {
+		_, err := r.s.groups.Patch(ctx, r.org_id, id.Hex(), patch)
+		failed = errorResponse(err)
+	}
+	for _, change := range changes {
+		change.Error = failed
+		if change.Action == ActionAddMember {
+			r.record(change, &r.report.Summary.MembershipsAdded)
+		} else {
+			r.record(change, &r.report.Summary.MembershipsRemoved)
+		}
+	}
+	return nil
+}
+
+// record adds the change to the report and counts it, or counts it as failed.
+func (r *reconciler) record(change Change, counter *int) {
+
+	if change.Error != nil {
+		r.report.Summary.Failed++
+	} else {
+		*counter++
+	}
+	r.report.Changes = append(r.report.Changes, change)
+}
+
+// changedProperties returns the properties of the directory that differ from
+// the existing ones, nil when none do.
+func changedProperties(existing map[string]interface{}, properties map[string]interface{}) map[string]interface{} {
+
+	changed := map[string]interface{}{}
+	for key, value := range properties {
+		if current, ok := existing[key]; !ok || !reflect.DeepEqual(userschema.Normalize(current), userschema.Normalize(value)) {
+			changed[key] = value
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return changed
+}
+
+func errorResponse(err error) *util.ErrorResponse {
+
+	if err == nil {
+		return nil
+	}
+	_, body := util.NewErrorResponse(err)
+	return &body
+}
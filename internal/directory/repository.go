@@ -0,0 +1,229 @@
+package directory
+
+import (
+	"context"
+	"time"
+
+	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxRuns is the number of runs kept in the sync history of an organization.
+const MaxRuns = 50
+
+type Repository interface {
+	Get(ctx context.Context, org_id string) (*mongo_entity.DirectorySync, error)
+	Update(ctx context.Context, org_id string, config *mongo_entity.DirectorySync) error
+	// Lock marks the sync of the organization running until the time, unless
+	// it already runs.
+	Lock(ctx context.Context, org_id string, now time.Time, until time.Time) (bool, error)
+	Unlock(ctx context.Context, org_id string) error
+	SetSynced(ctx context.Context, org_id string, synced mongo_entity.DirectorySyncState) error
+	AddRun(ctx context.Context, org_id string, run mongo_entity.DirectorySyncRun) error
+	Runs(ctx context.Context, org_id string) ([]mongo_entity.DirectorySyncRun, error)
+	// Due returns the ids of the organizations whose scheduled sync is due.
+	Due(ctx context.Context, now time.Time) ([]string, error)
+	// Schedule moves the next run of a due sync to the time, unless another
+	// scheduler did first.
+	Schedule(ctx context.Context, org_id string, now time.Time, next time.Time) (bool, error)
+}
+
+type repository struct {
+	mongoClient *mongo.Client
+	mongoColl   *mongo.Collection
+}
+
+func NewRepository(mongodb *db.MongoDB) Repository {
+
+	orgCollection := mongodb.MongoClient.Database(mongodb.MongoConfig.DBName).Collection(mongodb.MongoConfig.OrganizationCollectionName)
+
+	return repository{mongoClient: mongodb.MongoClient, mongoColl: orgCollection}
+}
+
+// Get returns the directory sync of the organization, nil when none is set.
+func (r repository) Get(ctx context.Context, org_id string) (*mongo_entity.DirectorySync, error) {
+
+	ctx, span := telemetry.Repository(ctx, "directory", "Get")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	var org mongo_entity.Organization
+	opts := options.FindOne().SetProjection(bson.M{"directory_sync": 1})
+	err = r.mongoColl.FindOne(ctx, bson.M{"_id": orgId}, opts).Decode(&org)
+	if err == mongo.ErrNoDocuments {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return org.DirectorySync, nil
+}
+
+// Update replaces the directory sync of the organization, or removes it with
+// its history when the config is nil.
+func (r repository) Update(ctx context.Context, org_id string, config *mongo_entity.DirectorySync) error {
+
+	ctx, span := telemetry.Repository(ctx, "directory", "Update")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return &util.NotFoundError{Path: "Organization"}
+	}
+	update := bson.M{"$set": bson.M{"directory_sync": config}}
+	if config == nil {
+		update = bson.M{"$unset": bson.M{"directory_sync": "", "directory_sync_runs": ""}}
+	}
+	result, err := r.mongoColl.UpdateOne(ctx, bson.M{"_id": orgId}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &util.NotFoundError{Path: "Organization"}
+	}
+	return nil
+}
+
+func (r repository) Lock(ctx context.Context, org_id string, now time.Time, until time.Time) (bool, error) {
+
+	ctx, span := telemetry.Repository(ctx, "directory", "Lock")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return false, &util.NotFoundError{Path: "Organization"}
+	}
+	filter := bson.M{
+		"_id":            orgId,
+		"directory_sync": bson.M{"$exists": true},
+		"$or": bson.A{
+			bson.M{"directory_sync.running_until": bson.M{"$exists": false}},
+			bson.M{"directory_sync.running_until": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{"directory_sync.running_until": until}}
+	result, err := r.mongoColl.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (r repository) Unlock(ctx context.Context, org_id string) error {
+
+	ctx, span := telemetry.Repository(ctx, "directory", "Unlock")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return &util.NotFoundError{Path: "Organization"}
+	}
+	filter := bson.M{"_id": orgId, "directory_sync": bson.M{"$exists": true}}
+	_, err = r.mongoColl.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"directory_sync.running_until": ""}})
+	return err
+}
+
+// SetSynced replaces the synced users and groups of the directory sync.
+func (r repository) SetSynced(ctx context.Context, org_id string, synced mongo_entity.DirectorySyncState) error {
+
+	ctx, span := telemetry.Repository(ctx, "directory", "SetSynced")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return &util.NotFoundError{Path: "Organization"}
+	}
+	filter := bson.M{"_id": orgId, "directory_sync": bson.M{"$exists": true}}
+	_, err = r.mongoColl.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"directory_sync.synced": synced}})
+	return err
+}
+
+// AddRun adds the run to the sync history, keeping the last MaxRuns runs.
+func (r repository) AddRun(ctx context.Context, org_id string, run mongo_entity.DirectorySyncRun) error {
+
+	ctx, span := telemetry.Repository(ctx, "directory", "AddRun")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return &util.NotFoundError{Path: "Organization"}
+	}
+	update := bson.M{"$push": bson.M{"directory_sync_runs": bson.M{"$each": bson.A{run}, "$slice": -MaxRuns}}}
+	_, err = r.mongoColl.UpdateOne(ctx, bson.M{"_id": orgId}, update)
+	return err
+}
+
+// Runs returns the sync history of the organization, oldest first.
+func (r repository) Runs(ctx context.Context, org_id string) ([]mongo_entity.DirectorySyncRun, error) {
+
+	ctx, span := telemetry.Repository(ctx, "directory", "Runs")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	var org mongo_entity.Organization
+	opts := options.FindOne().SetProjection(bson.M{"directory_sync_runs": 1})
+	err = r.mongoColl.FindOne(ctx, bson.M{"_id": orgId}, opts).Decode(&org)
+	if err == mongo.ErrNoDocuments {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return org.DirectorySyncRuns, nil
+}
+
+func (r repository) Due(ctx context.Context, now time.Time) ([]string, error) {
+
+	ctx, span := telemetry.Repository(ctx, "directory", "Due")
+	defer span.End()
+
+	filter := bson.M{
+		"directory_sync.enabled":     true,
+		"directory_sync.interval":    bson.M{"$exists": true, "$ne": ""},
+		"directory_sync.next_run_at": bson.M{"$lte": now},
+	}
+	cursor, err := r.mongoColl.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ids := []string{}
+	for cursor.Next(ctx) {
+		var org mongo_entity.Organization
+		if err := cursor.Decode(&org); err != nil {
+			return ids, err
+		}
+		ids = append(ids, org.ID.Hex())
+	}
+	return ids, cursor.Err()
+}
+
+func (r repository) Schedule(ctx context.Context, org_id string, now time.Time, next time.Time) (bool, error) {
+
+	ctx, span := telemetry.Repository(ctx, "directory", "Schedule")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return false, &util.NotFoundError{Path: "Organization"}
+	}
+	filter := bson.M{"_id": orgId, "directory_sync.next_run_at": bson.M{"$lte": now}}
+	result, err := r.mongoColl.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"directory_sync.next_run_at": next}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
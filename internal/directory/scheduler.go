@@ -0,0 +1,76 @@
+package directory
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Scheduler runs the scheduled syncs that are due. Schedulers of several
+// replicas are safe, a due sync only runs once.
+type Scheduler struct {
+	repo    Repository
+	service Service
+	logger  *zap.Logger
+}
+
+func NewScheduler(repo Repository, service Service, logger *zap.Logger) *Scheduler {
+
+	return &Scheduler{repo: repo, service: service, logger: logger}
+}
+
+// Run checks for due syncs at the interval until the context is done.
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ran, err := s.RunDue(ctx, time.Now().UTC())
+			if err != nil && ctx.Err() == nil {
+				s.logger.Error("Error while running scheduled directory syncs.", zap.Error(err))
+			}
+			if ran > 0 {
+				s.logger.Info("Ran scheduled directory syncs.", zap.Int("syncs", ran))
+			}
+		}
+	}
+}
+
+// RunDue runs the syncs due at the time, schedules their next runs and
+// returns how many ran.
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) (int, error) {
+
+	ids, err := s.repo.Due(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	ran := 0
+	for _, org_id := range ids {
+		config, err := s.repo.Get(ctx, org_id)
+		if err != nil || config == nil {
+			continue
+		}
+		interval, err := time.ParseDuration(config.Interval)
+		if err != nil {
+			continue
+		}
+		scheduled, err := s.repo.Schedule(ctx, org_id, now, now.Add(interval))
+		if err != nil {
+			return ran, err
+		}
+		if !scheduled {
+			continue
+		}
+		if _, err := s.service.Run(ctx, org_id, RunRequest{Trigger: TriggerSchedule}); err != nil {
+			s.logger.Warn("Scheduled directory sync did not run.", zap.String("organization_id", org_id), zap.Error(err))
+			continue
+		}
+		ran++
+	}
+	return ran, nil
+}
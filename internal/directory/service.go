@@ -0,0 +1,337 @@
+package directory
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/shashimalcse/cronuseo/internal/group"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/user"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// Triggers of sync runs.
+const (
+	TriggerManual   = "manual"
+	TriggerSchedule = "schedule"
+)
+
+// Statuses of sync runs. A partial run failed to apply some changes, a failed
+// run applied none.
+const (
+	RunSucceeded = "succeeded"
+	RunPartial   = "partial"
+	RunFailed    = "failed"
+)
+
+// MinInterval bounds how often scheduled syncs run.
+const MinInterval = time.Minute
+
+// lockTTL is how long a sync stays locked when its server stops during the run.
+const lockTTL = time.Hour
+
+type Service interface {
+	Get(ctx context.Context, org_id string) (Config, error)
+	Update(ctx context.Context, org_id string, req UpdateConfigRequest) (Config, error)
+	Delete(ctx context.Context, org_id string) error
+	// Run syncs the directory of the organization, or only reports the
+	// changes of a dry run.
+	Run(ctx context.Context, org_id string, req RunRequest) (Report, error)
+	History(ctx context.Context, org_id string) ([]Run, error)
+}
+
+type Config struct {
+	mongo_entity.DirectorySync
+}
+
+type Run struct {
+	mongo_entity.DirectorySyncRun
+}
+
+// Report is a sync run with its changes.
+type Report struct {
+	Run
+	Changes []Change `json:"changes"`
+}
+
+// Change is a change of a sync run, with the error that failed it.
+type Change struct {
+	Action string              `json:"action"`
+	User   string              `json:"user,omitempty"`
+	Group  string              `json:"group,omitempty"`
+	Error  *util.ErrorResponse `json:"error,omitempty"`
+}
+
+// RunRequest runs a sync, only reporting its changes when DryRun is set.
+// Trigger defaults to TriggerManual.
+type RunRequest struct {
+	DryRun  bool
+	Trigger string
+}
+
+// UpdateConfigRequest replaces the directory sync configuration. An empty
+// bind password keeps the current one.
+type UpdateConfigRequest struct {
+	Enabled            bool       `json:"enabled"`
+	URL                string     `json:"url"`
+	StartTLS           bool       `json:"start_tls,omitempty"`
+	InsecureSkipVerify bool       `json:"insecure_skip_verify,omitempty"`
+	BindDN             string     `json:"bind_dn,omitempty"`
+	BindPassword       string     `json:"bind_password,omitempty"`
+	UserBaseDNs        []string   `json:"user_base_dns"`
+	UserFilter         string     `json:"user_filter,omitempty"`
+	GroupBaseDNs       []string   `json:"group_base_dns,omitempty"`
+	GroupFilter        string     `json:"group_filter,omitempty"`
+	Attributes         Attributes `json:"attributes"`
+	Interval           string     `json:"interval,omitempty"`
+	DeleteMissing      bool       `json:"delete_missing,omitempty"`
+}
+
+func (m UpdateConfigRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.URL, validation.Required, validation.By(validateURL)),
+		validation.Field(&m.UserBaseDNs, validation.Required, validation.Each(validation.Required)),
+		validation.Field(&m.UserFilter, validation.By(validateFilter)),
+		validation.Field(&m.GroupBaseDNs, validation.Each(validation.Required)),
+		validation.Field(&m.GroupFilter, validation.By(validateFilter)),
+		validation.Field(&m.Attributes),
+		validation.Field(&m.Interval, validation.By(validateInterval)),
+	)
+}
+
+type Attributes struct {
+	mongo_entity.DirectoryAttributes
+}
+
+func (m Attributes) Validate() error {
+	return validation.ValidateStruct(&m.DirectoryAttributes,
+		validation.Field(&m.Properties, validation.Each(validation.By(func(value interface{}) error {
+			return Attribute{value.(mongo_entity.DirectoryAttribute)}.Validate()
+		}))),
+	)
+}
+
+type Attribute struct {
+	mongo_entity.DirectoryAttribute
+}
+
+func (m Attribute) Validate() error {
+	return validation.ValidateStruct(&m.DirectoryAttribute,
+		validation.Field(&m.Attribute, validation.Required),
+		validation.Field(&m.Property, validation.Required),
+	)
+}
+
+func validateURL(value interface{}) error {
+
+	u, err := url.Parse(value.(string))
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return validation.NewError("validation_ldap_url", "must be an ldap:// or ldaps:// URL")
+	}
+	return nil
+}
+
+func validateFilter(value interface{}) error {
+
+	if filter := value.(string); filter != "" {
+		if _, err := ldap.CompileFilter(filter); err != nil {
+			return validation.NewError("validation_ldap_filter", "must be a valid LDAP filter")
+		}
+	}
+	return nil
+}
+
+func validateInterval(value interface{}) error {
+
+	if interval := value.(string); interval != "" {
+		if d, err := time.ParseDuration(interval); err != nil || d < MinInterval {
+			return validation.NewError("validation_interval", "must be a duration of at least "+MinInterval.String())
+		}
+	}
+	return nil
+}
+
+type service struct {
+	repo      Repository
+	users     user.Service
+	groups    group.Service
+	directory Directory
+	logger    *zap.Logger
+}
+
+// NewService creates the directory sync service, reconciling the users and
+// groups of the directory through the user and group services.
+func NewService(repo Repository, users user.Service, groups group.Service, directory Directory, logger *zap.Logger) Service {
+
+	return service{repo: repo, users: users, groups: groups, directory: directory, logger: logger}
+}
+
+// Get the directory sync configuration of the organization.
+func (s service) Get(ctx context.Context, org_id string) (Config, error) {
+
+	ctx, span := telemetry.Start(ctx, "directory.service.Get")
+	defer span.End()
+
+	config, err := s.repo.Get(ctx, org_id)
+	if err != nil {
+		return Config{}, err
+	}
+	if config == nil {
+		return Config{}, &util.NotFoundError{Path: "Directory sync"}
+	}
+	return Config{*config}, nil
+}
+
+// Update the directory sync configuration of the organization. The synced
+// users and groups are kept, and an enabled scheduled sync runs next at once.
+func (s service) Update(ctx context.Context, org_id string, req UpdateConfigRequest) (Config, error) {
+
+	ctx, span := telemetry.Start(ctx, "directory.service.Update")
+	defer span.End()
+
+	if err := req.Validate(); err != nil {
+		s.logger.Debug("Error while validating directory sync.", zap.String("organization_id", org_id))
+		return Config{}, util.NewValidationError("Invalid input for directory sync.", err)
+	}
+	existing, err := s.repo.Get(ctx, org_id)
+	if err != nil {
+		return Config{}, err
+	}
+	config := mongo_entity.DirectorySync{
+		Enabled:            req.Enabled,
+		URL:                req.URL,
+		StartTLS:           req.StartTLS,
+		InsecureSkipVerify: req.InsecureSkipVerify,
+		BindDN:             req.BindDN,
+		BindPassword:       req.BindPassword,
+		UserBaseDNs:        req.UserBaseDNs,
+		UserFilter:         req.UserFilter,
+		GroupBaseDNs:       req.GroupBaseDNs,
+		GroupFilter:        req.GroupFilter,
+		Attributes:         req.Attributes.DirectoryAttributes,
+		Interval:           req.Interval,
+		DeleteMissing:      req.DeleteMissing,
+	}
+	if existing != nil {
+		if config.BindPassword == "" {
+			config.BindPassword = existing.BindPassword
+		}
+		config.RunningUntil = existing.RunningUntil
+		config.Synced = existing.Synced
+	}
+	if config.Enabled && config.Interval != "" {
+		now := time.Now().UTC()
+		config.NextRunAt = &now
+	}
+	if err := s.repo.Update(ctx, org_id, &config); err != nil {
+		s.logger.Error("Error while updating directory sync.", zap.String("organization_id", org_id))
+		return Config{}, err
+	}
+	return Config{config}, nil
+}
+
+// Delete the directory sync configuration and history of the organization.
+// Synced users and groups are kept.
+func (s service) Delete(ctx context.Context, org_id string) error {
+
+	ctx, span := telemetry.Start(ctx, "directory.service.Delete")
+	defer span.End()
+
+	if _, err := s.Get(ctx, org_id); err != nil {
+		return err
+	}
+	if err := s.repo.Update(ctx, org_id, nil); err != nil {
+		s.logger.Error("Error while deleting directory sync.", zap.String("organization_id", org_id))
+		return err
+	}
+	return nil
+}
+
+// Run loads the directory and reconciles the users, groups and memberships of
+// the organization with it, and records the run in the sync history. Runs
+// that cannot load the directory are reported as failed, not returned as
+// errors.
+func (s service) Run(ctx context.Context, org_id string, req RunRequest) (Report, error) {
+
+	ctx, span := telemetry.Start(ctx, "directory.service.Run")
+	defer span.End()
+
+	config, err := s.repo.Get(ctx, org_id)
+	if err != nil {
+		return Report{}, err
+	}
+	if config == nil {
+		return Report{}, &util.NotFoundError{Path: "Directory sync"}
+	}
+	if req.Trigger == "" {
+		req.Trigger = TriggerManual
+	}
+	now := time.Now().UTC()
+	if !req.DryRun {
+		locked, err := s.repo.Lock(ctx, org_id, now, now.Add(lockTTL))
+		if err != nil {
+			return Report{}, err
+		}
+		if !locked {
+			return Report{}, &util.ConflictError{Message: "A directory sync of the organization is already running."}
+		}
+		defer func() {
+			if err := s.repo.Unlock(ctx, org_id); err != nil {
+				s.logger.Error("Error while unlocking directory sync.", zap.String("organization_id", org_id), zap.Error(err))
+			}
+		}()
+	}
+
+	report := Report{
+		Run: Run{mongo_entity.DirectorySyncRun{
+			ID:        primitive.NewObjectID(),
+			Trigger:   req.Trigger,
+			DryRun:    req.DryRun,
+			StartedAt: now,
+		}},
+		Changes: []Change{},
+	}
+	snapshot, err := s.directory.Load(ctx, *config)
+	if err == nil {
+		err = s.reconcile(ctx, org_id, *config, snapshot, &report)
+	}
+	report.FinishedAt = time.Now().UTC()
+	switch {
+	case err != nil:
+		report.Status = RunFailed
+		report.Error = err.Error()
+		s.logger.Warn("Directory sync failed.", zap.String("organization_id", org_id), zap.Error(err))
+	case report.Summary.Failed > 0:
+		report.Status = RunPartial
+	default:
+		report.Status = RunSucceeded
+	}
+	if err := s.repo.AddRun(ctx, org_id, report.DirectorySyncRun); err != nil {
+		s.logger.Error("Error while recording directory sync run.", zap.String("organization_id", org_id), zap.Error(err))
+	}
+	return report, nil
+}
+
+// History returns the recent sync runs of the organization, latest first.
+func (s service) History(ctx context.Context, org_id string) ([]Run, error) {
+
+	ctx, span := telemetry.Start(ctx, "directory.service.History")
+	defer span.End()
+
+	runs, err := s.repo.Runs(ctx, org_id)
+	if err != nil {
+		return []Run{}, err
+	}
+	result := []Run{}
+	for i := len(runs) - 1; i >= 0; i-- {
+		result = append(result, Run{runs[i]})
+	}
+	return result, nil
+}
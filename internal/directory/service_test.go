@@ -0,0 +1,455 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/group"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/user"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+type mockRepository struct {
+	config *mongo_entity.DirectorySync
+	runs   []mongo_entity.DirectorySyncRun
+}
+
+func (m *mockRepository) Get(ctx context.Context, org_id string) (*mongo_entity.DirectorySync, error) {
+
+	if m.config == nil {
+		return nil, nil
+	}
+	config := *m.config
+	return &config, nil
+}
+
+func (m *mockRepository) Update(ctx context.Context, org_id string, config *mongo_entity.DirectorySync) error {
+
+	m.config = config
+	if config == nil {
+		m.runs = nil
+	}
+	return nil
+}
+
+func (m *mockRepository) Lock(ctx context.Context, org_id string, now time.Time, until time.Time) (bool, error) {
+
+	if m.config.RunningUntil != nil && m.config.RunningUntil.After(now) {
+		return false, nil
+	}
+	m.config.RunningUntil = &until
+	return true, nil
+}
+
+func (m *mockRepository) Unlock(ctx context.Context, org_id string) error {
+
+	m.config.RunningUntil = nil
+	return nil
+}
+
+func (m *mockRepository) SetSynced(ctx context.Context, org_id string, synced mongo_entity.DirectorySyncState) error {
+
+	m.config.Synced = synced
+	return nil
+}
+
+func (m *mockRepository) AddRun(ctx context.Context, org_id string, run mongo_entity.DirectorySyncRun) error {
+
+	m.runs = append(m.runs, run)
+	return nil
+}
+
+func (m *mockRepository) Runs(ctx context.Context, org_id string) ([]mongo_entity.DirectorySyncRun, error) {
+	return m.runs, nil
+}
+
+func (m *mockRepository) Due(ctx context.Context, now time.Time) ([]string, error) {
+
+	if m.config != nil && m.config.Enabled && m.config.Interval != "" && !m.config.NextRunAt.After(now) {
+		return []string{"acme"}, nil
+	}
+	return []string{}, nil
+}
+
+func (m *mockRepository) Schedule(ctx context.Context, org_id string, now time.Time, next time.Time) (bool, error) {
+
+	if m.config.NextRunAt.After(now) {
+		return false, nil
+	}
+	m.config.NextRunAt = &next
+	return true, nil
+}
+
+type mockUserService struct {
+	user.Service
+	users []mongo_entity.User
+}
+
+func (m *mockUserService) find(id string) *mongo_entity.User {
+
+	for i := range m.users {
+		if m.users[i].ID.Hex() == id {
+			return &m.users[i]
+		}
+	}
+	return nil
+}
+
+func (m *mockUserService) Query(ctx context.Context, org_id string, filter user.Filter) ([]user.User, error) {
+
+	result := []user.User{}
+	for _, u := range m.users {
+		result = append(result, user.User{User: u})
+	}
+	return result, nil
+}
+
+func (m *mockUserService) Create(ctx context.Context, org_id string, req user.CreateUserRequest) (user.UserResponse, error) {
+
+	if req.Identifier == "blocked@acme.com" {
+		return user.UserResponse{}, &util.QuotaExceededError{Path: "users", Limit: 3}
+	}
+	created := mongo_entity.User{ID: primitive.NewObjectID(), Identifier: req.Identifier, Username: req.Username, UserProperties: req.UserProperties}
+	m.users = append(m.users, created)
+	return user.UserResponse{ID: created.ID, Identifier: created.Identifier}, nil
+}
+
+func (m *mockUserService) Update(ctx context.Context, org_id string, id string, req user.UpdateUserRequest) (user.UserResponse, error) {
+
+	m.find(id).Username = *req.Username
+	return user.UserResponse{}, nil
+}
+
+func (m *mockUserService) Patch(ctx context.Context, org_id string, id string, req user.PatchUserRequest) (user.UserResponse, error) {
+
+	u := m.find(id)
+	if u.UserProperties == nil {
+		u.UserProperties = map[string]interface{}{}
+	}
+	for key, value := range req.UserProperties {
+		u.UserProperties[key] = value
+	}
+	return user.UserResponse{}, nil
+}
+
+func (m *mockUserService) Delete(ctx context.Context, org_id string, id string) error {
+
+	for i := range m.users {
+		if m.users[i].ID.Hex() == id {
+			m.users = append(m.users[:i], m.users[i+1:]...)
+			return nil
+		}
+	}
+	return &util.NotFoundError{Path: "User"}
+}
+
+type mockGroupService struct {
+	group.Service
+	users  *mockUserService
+	groups []mongo_entity.Group
+}
+
+func (m *mockGroupService) find(id string) *mongo_entity.Group {
+
+	for i := range m.groups {
+		if m.groups[i].ID.Hex() == id {
+			return &m.groups[i]
+		}
+	}
+	return nil
+}
+
+// members returns the identifiers of the members of the group.
+func (m *mockGroupService) members(identifier string) []string {
+
+	members := []string{}
+	for _, g := range m.groups {
+		if g.Identifier == identifier {
+			for _, id := range g.Users {
+				if u := m.users.find(id.Hex()); u != nil {
+					members = append(members, u.Identifier)
+				}
+			}
+		}
+	}
+	return members
+}
+
+func (m *mockGroupService) Query(ctx context.Context, org_id string, filter group.Filter) ([]group.Group, error) {
+
+	result := []group.Group{}
+	for _, g := range m.groups {
+		result = append(result, group.Group{Group: g})
+	}
+	return result, nil
+}
+
+func (m *mockGroupService) Get(ctx context.Context, org_id string, id string) (group.GroupResponse, error) {
+
+	g := m.find(id)
+	response := group.GroupResponse{ID: g.ID, Identifier: g.Identifier, DisplayName: g.DisplayName}
+	for _, userId := range g.Users {
+		if u := m.users.find(userId.Hex()); u != nil {
+			response.Users = append(response.Users, mongo_entity.AssignedUser{ID: u.ID, Identifier: u.Identifier, Username: u.Username})
+		}
+	}
+	return response, nil
+}
+
+func (m *mockGroupService) Create(ctx context.Context, org_id string, req group.CreateGroupRequest) (group.GroupResponse, error) {
+
+	created := mongo_entity.Group{ID: primitive.NewObjectID(), Identifier: req.Identifier, DisplayName: req.DisplayName}
+	m.groups = append(m.groups, created)
+	return group.GroupResponse{ID: created.ID, Identifier: created.Identifier}, nil
+}
+
+func (m *mockGroupService) Update(ctx context.Context, org_id string, id string, req group.UpdateGroupRequest) (group.GroupResponse, error) {
+
+	m.find(id).DisplayName = *req.DisplayName
+	return group.GroupResponse{}, nil
+}
+
+func (m *mockGroupService) Patch(ctx context.Context, org_id string, id string, req group.PatchGroupRequest) (group.GroupResponse, error) {
+
+	g := m.find(id)
+	g.Users = append(g.Users, req.AddedUsers...)
+	for _, removed := range req.RemovedUsers {
+		for i, userId := range g.Users {
+			if userId == removed {
+				g.Users = append(g.Users[:i], g.Users[i+1:]...)
+				break
+			}
+		}
+	}
+	return group.GroupResponse{}, nil
+}
+
+func (m *mockGroupService) Delete(ctx context.Context, org_id string, id string) error {
+
+	for i := range m.groups {
+		if m.groups[i].ID.Hex() == id {
+			m.groups = append(m.groups[:i], m.groups[i+1:]...)
+			return nil
+		}
+	}
+	return &util.NotFoundError{Path: "Group"}
+}
+
+func testRequest(config mongo_entity.DirectorySync) UpdateConfigRequest {
+
+	return UpdateConfigRequest{
+		Enabled:      config.Enabled,
+		URL:          config.URL,
+		BindDN:       config.BindDN,
+		BindPassword: config.BindPassword,
+		UserBaseDNs:  config.UserBaseDNs,
+		GroupBaseDNs: config.GroupBaseDNs,
+		Attributes:   Attributes{config.Attributes},
+	}
+}
+
+func actions(report Report) map[string]int {
+
+	counts := map[string]int{}
+	for _, change := range report.Changes {
+		counts[change.Action]++
+	}
+	return counts
+}
+
+func TestUpdate(t *testing.T) {
+
+	ctx := context.Background()
+	repo := &mockRepository{}
+	s := NewService(repo, &mockUserService{}, &mockGroupService{}, NewLDAP(testTimeout), zap.NewNop())
+
+	_, err := s.Get(ctx, "")
+	assert.IsType(t, &util.NotFoundError{}, err)
+
+	_, err = s.Update(ctx, "", UpdateConfigRequest{
+		URL:         "http://ldap.acme.com",
+		UserBaseDNs: []string{""},
+		UserFilter:  "(objectClass=person",
+		Interval:    "10s",
+		Attributes: Attributes{mongo_entity.DirectoryAttributes{
+			Properties: []mongo_entity.DirectoryAttribute{{Attribute: "mail"}},
+		}},
+	})
+	fields := []string{}
+	for _, field := range err.(*util.InvalidInputError).Fields {
+		fields = append(fields, field.Field)
+	}
+	assert.Equal(t, []string{"attributes.properties.0.property", "interval", "url", "user_base_dns.0", "user_filter"}, fields)
+
+	config, err := s.Update(ctx, "", UpdateConfigRequest{
+		Enabled:      true,
+		URL:          "ldaps://ldap.acme.com",
+		BindDN:       testBindDN,
+		BindPassword: testBindPassword,
+		UserBaseDNs:  []string{"OU=Users,DC=acme,DC=com"},
+		Interval:     "1h",
+	})
+	assert.Nil(t, err)
+	assert.NotNil(t, config.NextRunAt)
+
+	// the bind password is kept, and never returned
+	config, err = s.Update(ctx, "", UpdateConfigRequest{URL: "ldaps://ldap.acme.com", UserBaseDNs: []string{"OU=Users,DC=acme,DC=com"}})
+	assert.Nil(t, err)
+	assert.Nil(t, config.NextRunAt)
+	assert.Equal(t, testBindPassword, repo.config.BindPassword)
+	body, _ := json.Marshal(config)
+	assert.NotContains(t, string(body), testBindPassword)
+
+	assert.Nil(t, s.Delete(ctx, ""))
+	_, err = s.Get(ctx, "")
+	assert.IsType(t, &util.NotFoundError{}, err)
+}
+
+func TestRun(t *testing.T) {
+
+	ctx := context.Background()
+	directory := newTestDirectory(t)
+	repo := &mockRepository{}
+	users := &mockUserService{}
+	groups := &mockGroupService{users: users}
+	s := NewService(repo, users, groups, NewLDAP(testTimeout), zap.NewNop())
+
+	_, err := s.Run(ctx, "", RunRequest{})
+	assert.IsType(t, &util.NotFoundError{}, err)
+
+	// a local user and group, and a local member of the directory group
+	local := mongo_entity.User{ID: primitive.NewObjectID(), Identifier: "admin@acme.com", Username: "admin"}
+	users.users = append(users.users, local)
+	groups.groups = append(groups.groups,
+		mongo_entity.Group{ID: primitive.NewObjectID(), Identifier: "sales", DisplayName: "sales", Users: []primitive.ObjectID{local.ID}},
+		mongo_entity.Group{ID: primitive.NewObjectID(), Identifier: "ops", DisplayName: "Ops"})
+	_, err = s.Update(ctx, "", testRequest(testConfig(directory)))
+	assert.Nil(t, err)
+
+	report, err := s.Run(ctx, "", RunRequest{DryRun: true})
+	assert.Nil(t, err)
+	assert.Equal(t, RunSucceeded, report.Status)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, map[string]int{ActionCreateUser: 2, ActionUpdateGroup: 1, ActionAddMember: 2}, actions(report))
+	assert.Len(t, users.users, 1)
+	assert.Equal(t, "sales", groups.groups[0].DisplayName)
+
+	report, err = s.Run(ctx, "", RunRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, RunSucceeded, report.Status)
+	assert.Equal(t, TriggerManual, report.Trigger)
+	assert.Equal(t, mongo_entity.DirectorySyncSummary{UsersCreated: 2, GroupsUpdated: 1, MembershipsAdded: 2}, report.Summary)
+	assert.Len(t, users.users, 3)
+	assert.Equal(t, "Sales", groups.groups[0].DisplayName)
+	assert.ElementsMatch(t, []string{"admin@acme.com", "jane@acme.com", "john@acme.com"}, groups.members("sales"))
+	assert.ElementsMatch(t, []string{"jane@acme.com", "john@acme.com"}, repo.config.Synced.Users)
+	assert.Equal(t, []string{"sales"}, repo.config.Synced.Groups)
+	assert.Nil(t, repo.config.RunningUntil)
+
+	// synced again without changes
+	report, err = s.Run(ctx, "", RunRequest{})
+	assert.Nil(t, err)
+	assert.Empty(t, report.Changes)
+
+	// john leaves sales and the directory, jane changes department
+	directory.set("CN=Jane Doe,OU=Users,DC=acme,DC=com", "objectClass", "person", "userPrincipalName", "jane@acme.com",
+		"sAMAccountName", "jane.doe", "department", "sales", "mail", "jane@acme.com", "mail", "jd@acme.com")
+	directory.set("CN=Sales,OU=Groups,DC=acme,DC=com", "objectClass", "group", "cn", "sales", "displayName", "Sales",
+		"member", "CN=Jane Doe,OU=Users,DC=acme,DC=com")
+	directory.set("CN=Support,OU=Groups,DC=acme,DC=com", "objectClass", "group", "cn", "support",
+		"member", "CN=John Roe,OU=Users,DC=acme,DC=com", "member", "CN=Blocked,OU=Users,DC=acme,DC=com")
+	directory.set("CN=Blocked,OU=Users,DC=acme,DC=com", "objectClass", "person", "userPrincipalName", "blocked@acme.com")
+	directory.remove("CN=John Roe,OU=Users,DC=acme,DC=com")
+	report, err = s.Run(ctx, "", RunRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, RunPartial, report.Status)
+	assert.Equal(t, mongo_entity.DirectorySyncSummary{UsersUpdated: 1, GroupsCreated: 1, MembershipsRemoved: 1, Failed: 1}, report.Summary)
+	assert.Equal(t, util.CodeQuotaExceeded, report.Changes[0].Error.Code)
+	jane := users.users[1]
+	assert.Equal(t, "jane.doe", jane.Username)
+	assert.Equal(t, "sales", jane.UserProperties["department"])
+	assert.ElementsMatch(t, []string{"admin@acme.com", "jane@acme.com"}, groups.members("sales"))
+	assert.Empty(t, groups.members("support"))
+	assert.Len(t, users.users, 3)
+
+	// missing users and groups are deleted
+	config := testConfig(directory)
+	req := testRequest(config)
+	req.DeleteMissing = true
+	_, err = s.Update(ctx, "", req)
+	assert.Nil(t, err)
+	directory.remove("CN=Support,OU=Groups,DC=acme,DC=com")
+	report, err = s.Run(ctx, "", RunRequest{DryRun: true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{ActionCreateUser: 1, ActionDeleteUser: 1, ActionDeleteGroup: 1}, actions(report))
+	report, err = s.Run(ctx, "", RunRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Summary.UsersDeleted)
+	assert.Equal(t, 1, report.Summary.GroupsDeleted)
+	assert.ElementsMatch(t, []string{"admin@acme.com", "jane@acme.com"}, []string{users.users[0].Identifier, users.users[1].Identifier})
+	assert.Len(t, users.users, 2)
+	assert.Len(t, groups.groups, 2)
+
+	// an empty directory does not delete every synced user
+	directory.remove("CN=Jane Doe,OU=Users,DC=acme,DC=com")
+	directory.remove("CN=Blocked,OU=Users,DC=acme,DC=com")
+	report, err = s.Run(ctx, "", RunRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, RunFailed, report.Status)
+	assert.Len(t, users.users, 2)
+
+	// unreachable directories fail the run
+	repo.config.URL = "ldap://127.0.0.1:1"
+	report, err = s.Run(ctx, "", RunRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, RunFailed, report.Status)
+	assert.NotEmpty(t, report.Error)
+
+	// runs of the organization do not overlap
+	until := time.Now().Add(time.Minute)
+	repo.config.RunningUntil = &until
+	_, err = s.Run(ctx, "", RunRequest{})
+	assert.IsType(t, &util.ConflictError{}, err)
+
+	history, err := s.History(ctx, "")
+	assert.Nil(t, err)
+	assert.Len(t, history, 8)
+	assert.Equal(t, RunFailed, history[0].Status)
+	assert.True(t, history[len(history)-1].DryRun)
+}
+
+func TestScheduler(t *testing.T) {
+
+	ctx := context.Background()
+	directory := newTestDirectory(t)
+	repo := &mockRepository{}
+	users := &mockUserService{}
+	s := NewService(repo, users, &mockGroupService{users: users}, NewLDAP(testTimeout), zap.NewNop())
+	scheduler := NewScheduler(repo, s, zap.NewNop())
+
+	req := testRequest(testConfig(directory))
+	req.Interval = "1h"
+	_, err := s.Update(ctx, "", req)
+	assert.Nil(t, err)
+
+	now := time.Now().UTC()
+	ran, err := scheduler.RunDue(ctx, now)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, ran)
+	assert.Len(t, users.users, 2)
+	assert.Equal(t, TriggerSchedule, repo.runs[0].Trigger)
+	assert.Equal(t, now.Add(time.Hour), *repo.config.NextRunAt)
+
+	ran, err = scheduler.RunDue(ctx, now.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, ran)
+	ran, err = scheduler.RunDue(ctx, now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, ran)
+}
@@ -0,0 +1,67 @@
+package extauthz
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/shashimalcse/cronuseo/internal/config"
+)
+
+// route is a compiled ext_authz route rule.
+type route struct {
+	path     *regexp.Regexp
+	resource string
+	actions  map[string]string
+}
+
+// Routes maps HTTP requests to cronuseo permissions. Rules are matched in
+// configuration order and the first matching rule wins.
+type Routes []route
+
+// CompileRoutes validates and compiles the configured route rules.
+func CompileRoutes(rules []config.ExtAuthzRoute) (Routes, error) {
+
+	routes := Routes{}
+	for i, rule := range rules {
+		if rule.Path == "" || rule.Resource == "" || len(rule.Methods) == 0 {
+			return nil, fmt.Errorf("ext_authz route %d: path, resource and methods are required", i)
+		}
+		// Paths must match whole, like endpoint permissions.
+		path, err := regexp.Compile("^(?:" + rule.Path + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("ext_authz route %d: invalid path %q: %v", i, rule.Path, err)
+		}
+		actions := map[string]string{}
+		for _, method := range rule.Methods {
+			if method.Method == "" || method.Action == "" {
+				return nil, fmt.Errorf("ext_authz route %d: method and action are required", i)
+			}
+			actions[strings.ToUpper(method.Method)] = method.Action
+		}
+		routes = append(routes, route{path: path, resource: rule.Resource, actions: actions})
+	}
+	return routes, nil
+}
+
+// Match returns the resource and action of a request. A method of "*" matches
+// any method not listed explicitly.
+func (r Routes) Match(method string, path string) (string, string, bool) {
+
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	for _, rt := range r {
+		if !rt.path.MatchString(path) {
+			continue
+		}
+		action, ok := rt.actions[strings.ToUpper(method)]
+		if !ok {
+			action, ok = rt.actions["*"]
+		}
+		if ok {
+			return rt.resource, action, true
+		}
+	}
+	return "", "", false
+}
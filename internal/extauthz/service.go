@@ -0,0 +1,136 @@
+package extauthz
+
+import (
+	"context"
+	"strings"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/shashimalcse/cronuseo/internal/check"
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/token"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+)
+
+type service struct {
+	cfg          config.ExtAuthz
+	routes       Routes
+	checkService check.Service
+	verifier     *token.Verifier
+	logger       *zap.Logger
+}
+
+// NewService creates the Envoy external authorization service. verifier
+// verifies subject JWTs and may be nil when the subject is read from a header.
+func NewService(cfg config.ExtAuthz, routes Routes, checkService check.Service, verifier *token.Verifier, logger *zap.Logger) authv3.AuthorizationServer {
+
+	return service{cfg: cfg, routes: routes, checkService: checkService, verifier: verifier, logger: logger}
+}
+
+// Check authorizes a request forwarded by Envoy. Requests that match no route
+// or carry no subject are denied.
+func (s service) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+
+	ctx, span := telemetry.Start(ctx, "extauthz.service.Check")
+	defer span.End()
+
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	method, path := httpReq.GetMethod(), httpReq.GetPath()
+
+	resource, action, ok := s.routes.Match(method, path)
+	if !ok {
+		s.logger.Debug("No ext_authz route matched.", zap.String("method", method), zap.String("path", path))
+		return denied(typev3.StatusCode_Forbidden, code.Code_PERMISSION_DENIED, "no route for request"), nil
+	}
+
+	subject, claims, ok := s.subject(httpReq.GetHeaders())
+	if !ok {
+		return denied(typev3.StatusCode_Unauthorized, code.Code_UNAUTHENTICATED, "missing or invalid subject"), nil
+	}
+
+	input := check.CheckRequest{
+		Identifier: subject,
+		Resource:   resource,
+		Action:     action,
+	}
+	var result check.CheckResponse
+	var err error
+	if s.cfg.Subject.Provision && claims != nil {
+		result, err = s.checkService.CheckVerified(ctx, s.cfg.Organization, input, s.cfg.APIKey, claims)
+	} else {
+		result, err = s.checkService.Check(ctx, s.cfg.Organization, input, s.cfg.APIKey, false)
+	}
+	if err != nil {
+		switch err.(type) {
+		case *util.InvalidInputError, *util.ConflictError:
+			s.logger.Debug("ext_authz subject was not provisioned.", zap.String("subject", subject), zap.Error(err))
+			return denied(typev3.StatusCode_Forbidden, code.Code_PERMISSION_DENIED, "insufficient permissions"), nil
+		case *util.NotFoundError:
+			return denied(typev3.StatusCode_Forbidden, code.Code_PERMISSION_DENIED, "insufficient permissions"), nil
+		case *util.QuotaExceededError:
+			return denied(typev3.StatusCode_Forbidden, code.Code_RESOURCE_EXHAUSTED, err.Error()), nil
+		case *util.UnauthorizedError:
+			s.logger.Error("ext_authz API key was rejected.", zap.String("organization", s.cfg.Organization))
+		default:
+			s.logger.Error("Error while checking ext_authz request.", zap.Error(err))
+		}
+		return denied(typev3.StatusCode_ServiceUnavailable, code.Code_UNAVAILABLE, "authorization unavailable"), nil
+	}
+	if !result.Allowed {
+		return denied(typev3.StatusCode_Forbidden, code.Code_PERMISSION_DENIED, "insufficient permissions"), nil
+	}
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(code.Code_OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{}},
+	}, nil
+}
+
+// subject returns the user identifier from the configured header, which
+// Envoy sets after verifying the request, or from a claim of the bearer JWT
+// with the claims. Bearer JWTs are always verified and must not be restricted
+// to another organization.
+func (s service) subject(headers map[string]string) (string, map[string]interface{}, bool) {
+
+	if s.cfg.Subject.Header != "" {
+		subject := headers[strings.ToLower(s.cfg.Subject.Header)]
+		return subject, nil, subject != ""
+	}
+
+	raw := strings.TrimSpace(headers["authorization"])
+	if len(raw) < 7 || !strings.EqualFold(raw[:7], "bearer ") || s.verifier == nil {
+		return "", nil, false
+	}
+	identity, err := s.verifier.Verify(strings.TrimSpace(raw[7:]))
+	if err != nil {
+		s.logger.Debug("Invalid ext_authz JWT.", zap.Error(err))
+		return "", nil, false
+	}
+	// Tokens of other organizations, e.g. of their service accounts or of
+	// issuers mapped to them, are not subjects of this organization.
+	if identity.Organization != "" && identity.Organization != s.cfg.Organization {
+		s.logger.Debug("ext_authz JWT is not valid for the organization.", zap.String("organization", identity.Organization))
+		return "", nil, false
+	}
+
+	claim := s.cfg.Subject.JWTClaim
+	if claim == "" {
+		claim = "sub"
+	}
+	subject, ok := identity.Claims[claim].(string)
+	return subject, identity.Claims, ok && subject != ""
+}
+
+func denied(httpCode typev3.StatusCode, grpcCode code.Code, message string) *authv3.CheckResponse {
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(grpcCode), Message: message},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+			Status: &typev3.HttpStatus{Code: httpCode},
+			Body:   message,
+		}},
+	}
+}
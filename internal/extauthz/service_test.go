@@ -0,0 +1,214 @@
+package extauthz
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	jwtv4 "github.com/golang-jwt/jwt/v4"
+	"github.com/shashimalcse/cronuseo/internal/check"
+	"github.com/shashimalcse/cronuseo/internal/config"
+	tokens "github.com/shashimalcse/cronuseo/internal/token"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/code"
+)
+
+type mockCheckService struct {
+	requests []check.CheckRequest
+}
+
+func (m *mockCheckService) Check(ctx context.Context, org_identifier string, req check.CheckRequest, apiKey string, skipValidation bool) (check.CheckResponse, error) {
+
+	m.requests = append(m.requests, req)
+	if req.Identifier == "ghost" {
+		return check.CheckResponse{}, &util.NotFoundError{Path: "User"}
+	}
+	return check.CheckResponse{Allowed: req.Identifier == "jane" && req.Action == "read"}, nil
+}
+
+func (m *mockCheckService) CheckVerified(ctx context.Context, org_identifier string, req check.CheckRequest, apiKey string, claims map[string]interface{}) (check.CheckResponse, error) {
+
+	req.Claims = claims
+	return m.Check(ctx, org_identifier, req, apiKey, false)
+}
+
+func (m *mockCheckService) GetOrgIdentifier(ctx context.Context, org_id string) (string, error) {
+	return "", &util.NotFoundError{Path: "Organization"}
+}
+
+func (m *mockCheckService) GetOrgAncestors(ctx context.Context, org_id string) ([]string, error) {
+	return nil, &util.NotFoundError{Path: "Organization"}
+}
+
+func (m *mockCheckService) Evaluate(ctx context.Context, org_identifier string, req check.CheckRequest) (check.CheckResponse, error) {
+	return m.Check(ctx, org_identifier, req, "", true)
+}
+
+func (m *mockCheckService) ValidateAPIKey(ctx context.Context, org_identifier string, apiKey string, scope string) (bool, error) {
+	return true, nil
+}
+
+func testRoutes(t *testing.T) Routes {
+
+	routes, err := CompileRoutes([]config.ExtAuthzRoute{
+		{Path: "^/invoices(/[^/]+)?$", Resource: "invoices", Methods: []config.ExtAuthzMethod{
+			{Method: "GET", Action: "read"}, {Method: "*", Action: "write"}}},
+	})
+	assert.Nil(t, err)
+	return routes
+}
+
+func TestRoutes(t *testing.T) {
+
+	routes := testRoutes(t)
+	resource, action, ok := routes.Match("get", "/invoices/42?expand=lines")
+	assert.True(t, ok)
+	assert.Equal(t, "invoices", resource)
+	assert.Equal(t, "read", action)
+	_, action, _ = routes.Match("DELETE", "/invoices/42")
+	assert.Equal(t, "write", action)
+	_, _, ok = routes.Match("GET", "/reports")
+	assert.False(t, ok)
+
+	// Paths match whole, not as a prefix or suffix.
+	admin, err := CompileRoutes([]config.ExtAuthzRoute{{Path: "/admin", Resource: "admin", Methods: []config.ExtAuthzMethod{{Method: "*", Action: "manage"}}}})
+	assert.Nil(t, err)
+	_, _, ok = admin.Match("GET", "/admin")
+	assert.True(t, ok)
+	_, _, ok = admin.Match("GET", "/x/admin")
+	assert.False(t, ok)
+	_, _, ok = admin.Match("GET", "/admin-public")
+	assert.False(t, ok)
+
+	_, err = CompileRoutes([]config.ExtAuthzRoute{{Path: "([", Resource: "x", Methods: []config.ExtAuthzMethod{{Method: "GET", Action: "read"}}}})
+	assert.NotNil(t, err)
+	_, err = CompileRoutes([]config.ExtAuthzRoute{{Path: "^/x$", Resource: "x"}})
+	assert.NotNil(t, err)
+}
+
+func request(method string, path string, headers map[string]string) *authv3.CheckRequest {
+
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Request: &authv3.AttributeContext_Request{
+			Http: &authv3.AttributeContext_HttpRequest{Method: method, Path: path, Headers: headers},
+		},
+	}}
+}
+
+func TestCheck(t *testing.T) {
+
+	checkService := &mockCheckService{}
+	cfg := config.ExtAuthz{Organization: "acme"}
+	cfg.Subject.Header = "X-User"
+	s := NewService(cfg, testRoutes(t), checkService, nil, zap.NewNop())
+
+	tests := []struct {
+		method string
+		path   string
+		user   string
+		want   code.Code
+	}{
+		{"GET", "/invoices", "jane", code.Code_OK},
+		{"POST", "/invoices", "jane", code.Code_PERMISSION_DENIED},
+		{"GET", "/invoices", "ghost", code.Code_PERMISSION_DENIED},
+		{"GET", "/invoices", "", code.Code_UNAUTHENTICATED},
+		{"GET", "/reports", "jane", code.Code_PERMISSION_DENIED},
+	}
+	for _, tc := range tests {
+		resp, err := s.Check(context.Background(), request(tc.method, tc.path, map[string]string{"x-user": tc.user}))
+		assert.Nil(t, err)
+		assert.Equal(t, int32(tc.want), resp.Status.Code, tc.method+" "+tc.path+" "+tc.user)
+	}
+	assert.Equal(t, check.CheckRequest{Identifier: "jane", Resource: "invoices", Action: "write"}, checkService.requests[1])
+}
+
+func TestJWTSubject(t *testing.T) {
+
+	cfg := config.ExtAuthz{Organization: "acme"}
+	cfg.Subject.JWTClaim = "email"
+	s := NewService(cfg, testRoutes(t), &mockCheckService{}, nil, zap.NewNop())
+
+	// Tokens are never trusted without verification.
+	token, err := jwtv4.NewWithClaims(jwtv4.SigningMethodHS256, jwtv4.MapClaims{"sub": "1", "email": "jane"}).SignedString([]byte("secret"))
+	assert.Nil(t, err)
+	resp, err := s.Check(context.Background(), request("GET", "/invoices", map[string]string{"authorization": "Bearer " + token}))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(code.Code_UNAUTHENTICATED), resp.Status.Code)
+
+	// Tokens that fail verification are rejected.
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.Nil(t, err)
+	keyFile := filepath.Join(t.TempDir(), "issuer.pem")
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+	verifier, err := tokens.NewVerifier(config.Auth{Issuers: []config.Issuer{{Name: "test", KeyFiles: []string{keyFile}}}}, nil, zap.NewNop())
+	assert.Nil(t, err)
+
+	s = NewService(cfg, testRoutes(t), &mockCheckService{}, verifier, zap.NewNop())
+	resp, err = s.Check(context.Background(), request("GET", "/invoices", map[string]string{"authorization": "Bearer " + token}))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(code.Code_UNAUTHENTICATED), resp.Status.Code)
+
+	token, err = jwtv4.NewWithClaims(jwtv4.SigningMethodRS256, jwtv4.MapClaims{
+		"sub":   "1",
+		"email": "jane",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}).SignedString(key)
+	assert.Nil(t, err)
+	checkService := &mockCheckService{}
+	s = NewService(cfg, testRoutes(t), checkService, verifier, zap.NewNop())
+	resp, err = s.Check(context.Background(), request("GET", "/invoices", map[string]string{"authorization": "Bearer " + token}))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(code.Code_OK), resp.Status.Code)
+	assert.Nil(t, checkService.requests[0].Claims)
+
+	// Verified claims are passed to checks to provision the subject.
+	cfg.Subject.Provision = true
+	s = NewService(cfg, testRoutes(t), checkService, verifier, zap.NewNop())
+	resp, err = s.Check(context.Background(), request("GET", "/invoices", map[string]string{"authorization": "Bearer " + token}))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(code.Code_OK), resp.Status.Code)
+	assert.Equal(t, "jane", checkService.requests[1].Claims["email"])
+}
+
+func TestForeignOrganizationToken(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	keyFile := filepath.Join(t.TempDir(), "signing.pem")
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600))
+	signer, err := tokens.NewSigner(config.ServiceAccounts{SigningKeyFile: keyFile}, zap.NewNop())
+	assert.Nil(t, err)
+	verifier, err := tokens.NewVerifier(config.Auth{}, signer, zap.NewNop())
+	assert.Nil(t, err)
+
+	cfg := config.ExtAuthz{Organization: "acme"}
+	cfg.Subject.Provision = true
+	checkService := &mockCheckService{}
+	s := NewService(cfg, testRoutes(t), checkService, verifier, zap.NewNop())
+
+	// service account tokens of other organizations are rejected before they
+	// are checked or provisioned
+	foreign, _, err := signer.Sign("jane", "globex", nil)
+	assert.Nil(t, err)
+	resp, err := s.Check(context.Background(), request("GET", "/invoices", map[string]string{"authorization": "Bearer " + foreign}))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(code.Code_UNAUTHENTICATED), resp.Status.Code)
+	assert.Empty(t, checkService.requests)
+
+	own, _, err := signer.Sign("jane", "acme", nil)
+	assert.Nil(t, err)
+	resp, err = s.Check(context.Background(), request("GET", "/invoices", map[string]string{"authorization": "Bearer " + own}))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(code.Code_OK), resp.Status.Code)
+}
@@ -3,8 +3,11 @@ package group
 import (
 	"context"
 
+	"github.com/shashimalcse/cronuseo/internal/assignment"
 	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/orgtree"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
 	"github.com/shashimalcse/cronuseo/internal/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -44,6 +47,9 @@ func NewRepository(mongodb *db.MongoDB) Repository {
 // Get group by id.
 func (r repository) Get(ctx context.Context, org_id string, id string) (*GroupResponse, error) {
 
+	ctx, span := telemetry.Repository(ctx, "group", "Get")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return nil, err
@@ -58,7 +64,7 @@ func (r repository) Get(ctx context.Context, org_id string, id string) (*GroupRe
 	filter := bson.M{"_id": orgId, "groups._id": groupId}
 	projection := bson.M{"groups.$": 1}
 	// Find the group document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return nil, err
 	}
@@ -86,6 +92,7 @@ func (r repository) Get(ctx context.Context, org_id string, id string) (*GroupRe
 		Users:       assignedUsers,
 		Roles:       assignedRoles,
 		Policies:    assignedPolicies,
+		RoleGrants:  group.RoleGrants,
 	}
 	return &roleResponse, nil
 }
@@ -93,6 +100,9 @@ func (r repository) Get(ctx context.Context, org_id string, id string) (*GroupRe
 // Create new group.
 func (r repository) Create(ctx context.Context, org_id string, group mongo_entity.Group) error {
 
+	ctx, span := telemetry.Repository(ctx, "group", "Create")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -138,6 +148,9 @@ func (r repository) Create(ctx context.Context, org_id string, group mongo_entit
 
 func (r repository) Update(ctx context.Context, org_id string, id string, update_group UpdateGroup) error {
 
+	ctx, span := telemetry.Repository(ctx, "group", "Update")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -151,7 +164,7 @@ func (r repository) Update(ctx context.Context, org_id string, id string, update
 	filter := bson.M{"_id": orgId, "groups._id": groupId}
 	update := bson.M{"$set": bson.M{}}
 	if update_group.DisplayName != nil && *update_group.DisplayName != "" {
-		update["$set"].(bson.M)["groups.$.first_name"] = *update_group.DisplayName
+		update["$set"].(bson.M)["groups.$.display_name"] = *update_group.DisplayName
 	}
 	_, err = r.mongoColl.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
 	if err != nil {
@@ -162,6 +175,9 @@ func (r repository) Update(ctx context.Context, org_id string, id string, update
 
 func (r repository) Patch(ctx context.Context, org_id string, id string, patch_group PatchGroup) error {
 
+	ctx, span := telemetry.Repository(ctx, "group", "Patch")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -195,6 +211,11 @@ func (r repository) Patch(ctx context.Context, org_id string, id string, patch_g
 
 	}
 
+	// set the grants of the added roles
+	if err := assignment.SetGrants(ctx, r.mongoColl, orgId, "groups", groupId, assignment.RoleGrants, patch_group.RoleGrants, patch_group.Window); err != nil {
+		return err
+	}
+
 	// remove roles
 	if len(patch_group.RemovedRoles) > 0 {
 
@@ -213,6 +234,10 @@ func (r repository) Patch(ctx context.Context, org_id string, id string, patch_g
 				return err
 			}
 		}
+
+		if err := assignment.RemoveGrants(ctx, r.mongoColl, orgId, "groups", []primitive.ObjectID{groupId}, assignment.RoleGrants, patch_group.RemovedRoles); err != nil {
+			return err
+		}
 	}
 
 	// add users
@@ -256,6 +281,10 @@ func (r repository) Patch(ctx context.Context, org_id string, id string, patch_g
 				return err
 			}
 		}
+
+		if err := assignment.RemoveGrants(ctx, r.mongoColl, orgId, "users", patch_group.RemovedUsers, assignment.GroupGrants, []primitive.ObjectID{groupId}); err != nil {
+			return err
+		}
 	}
 
 	// add policies
@@ -288,6 +317,9 @@ func (r repository) Patch(ctx context.Context, org_id string, id string, patch_g
 // Delete existing group.
 func (r repository) Delete(ctx context.Context, org_id string, id string) error {
 
+	ctx, span := telemetry.Repository(ctx, "group", "Delete")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -302,7 +334,7 @@ func (r repository) Delete(ctx context.Context, org_id string, id string) error
 	filter := bson.M{"_id": orgId}
 	update := bson.M{"$pull": bson.M{"groups": bson.M{"_id": groupId}}}
 	// Find the group document in the "organizations" collection
-	result, err := r.mongoColl.UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(false))
+	result, err := r.mongoColl.UpdateOne(ctx, filter, update, options.Update().SetUpsert(false))
 	if err != nil {
 		return err
 	}
@@ -326,12 +358,26 @@ func (r repository) Delete(ctx context.Context, org_id string, id string) error
 		return err
 	}
 
+	if err := assignment.RemoveGrants(ctx, r.mongoColl, orgId, "users", nil, assignment.GroupGrants, []primitive.ObjectID{groupId}); err != nil {
+		return err
+	}
+
+	filter = bson.M{"_id": orgId, "service_accounts.groups": groupId}
+	update = bson.M{"$pull": bson.M{"service_accounts.$[].groups": groupId}}
+	_, err = r.mongoColl.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
 // Get all groups.
 func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.Group, error) {
 
+	ctx, span := telemetry.Repository(ctx, "group", "Query")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return nil, err
@@ -341,7 +387,7 @@ func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.G
 	filter := bson.M{"_id": orgId}
 	projection := bson.M{"groups.roles": 0, "groups.users": 0}
 	// Find the group document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return nil, err
 	}
@@ -361,6 +407,9 @@ func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.G
 // Check if group exists by id.
 func (r repository) CheckGroupExistById(ctx context.Context, org_id string, id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "group", "CheckGroupExistById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -374,7 +423,7 @@ func (r repository) CheckGroupExistById(ctx context.Context, org_id string, id s
 	filter := bson.M{"_id": orgId, "groups._id": groupId}
 
 	// Search for the group in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the group was found
 	if result.Err() == nil {
@@ -389,6 +438,9 @@ func (r repository) CheckGroupExistById(ctx context.Context, org_id string, id s
 // Check if group exists by key.
 func (r repository) CheckGroupExistsByIdentifier(ctx context.Context, org_id string, identifier string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "group", "CheckGroupExistsByIdentifier")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -396,7 +448,7 @@ func (r repository) CheckGroupExistsByIdentifier(ctx context.Context, org_id str
 	filter := bson.M{"_id": orgId, "groups.identifier": identifier}
 
 	// Search for the group in the "organizations" collection
-	count, err := r.mongoColl.CountDocuments(context.Background(), filter)
+	count, err := r.mongoColl.CountDocuments(ctx, filter)
 
 	if err != nil {
 		return false, err
@@ -410,7 +462,11 @@ func (r repository) CheckGroupExistsByIdentifier(ctx context.Context, org_id str
 // Check if role exists by id.
 func (r repository) CheckRoleExistById(ctx context.Context, org_id string, id string) (bool, error) {
 
-	orgId, err := primitive.ObjectIDFromHex(org_id)
+	ctx, span := telemetry.Repository(ctx, "group", "CheckRoleExistById")
+	defer span.End()
+
+	// Roles of ancestor organizations are inherited.
+	lineage, err := orgtree.LineageOf(ctx, r.mongoColl, org_id)
 	if err != nil {
 		return false, err
 	}
@@ -420,10 +476,10 @@ func (r repository) CheckRoleExistById(ctx context.Context, org_id string, id st
 		return false, err
 	}
 
-	filter := bson.M{"_id": orgId, "roles._id": roleId}
+	filter := bson.M{"_id": bson.M{"$in": lineage}, "roles._id": roleId}
 
 	// Search for the role in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the role was found
 	if result.Err() == nil {
@@ -438,6 +494,9 @@ func (r repository) CheckRoleExistById(ctx context.Context, org_id string, id st
 // Check if role already assign to group by id.
 func (r repository) CheckRoleAlreadyAssignToGroupById(ctx context.Context, org_id string, group_id string, role_id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "group", "CheckRoleAlreadyAssignToGroupById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -457,7 +516,7 @@ func (r repository) CheckRoleAlreadyAssignToGroupById(ctx context.Context, org_i
 	projection := bson.M{"groups.$": 1}
 	org := mongo_entity.Organization{}
 	// Search for the role in the "organizations" collection
-	err = r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection)).Decode(&org)
+	err = r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org)
 	if err != nil {
 		return false, err
 	}
@@ -476,6 +535,9 @@ func (r repository) CheckRoleAlreadyAssignToGroupById(ctx context.Context, org_i
 // Check if user exists by id.
 func (r repository) CheckUserExistById(ctx context.Context, org_id string, id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "group", "CheckUserExistById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -489,7 +551,7 @@ func (r repository) CheckUserExistById(ctx context.Context, org_id string, id st
 	filter := bson.M{"_id": orgId, "users._id": userId}
 
 	// Search for the user in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the user was found
 	if result.Err() == nil {
@@ -504,6 +566,9 @@ func (r repository) CheckUserExistById(ctx context.Context, org_id string, id st
 // Check if role already assign to group by id.
 func (r repository) CheckUserAlreadyAssignToGroupById(ctx context.Context, org_id string, group_id string, user_id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "group", "CheckUserAlreadyAssignToGroupById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -523,7 +588,7 @@ func (r repository) CheckUserAlreadyAssignToGroupById(ctx context.Context, org_i
 	projection := bson.M{"groups.$": 1}
 	org := mongo_entity.Organization{}
 	// Search for the user in the "organizations" collection
-	err = r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection)).Decode(&org)
+	err = r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org)
 	if err != nil {
 		return false, err
 	}
@@ -542,6 +607,9 @@ func (r repository) CheckUserAlreadyAssignToGroupById(ctx context.Context, org_i
 // Check if policy exists by id.
 func (r repository) CheckPolicyExistById(ctx context.Context, org_id string, id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "group", "CheckPolicyExistById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -555,7 +623,7 @@ func (r repository) CheckPolicyExistById(ctx context.Context, org_id string, id
 	filter := bson.M{"_id": orgId, "policies._id": groupId}
 
 	// Search for the policy in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the policy was found
 	if result.Err() == nil {
@@ -570,6 +638,9 @@ func (r repository) CheckPolicyExistById(ctx context.Context, org_id string, id
 // Check if policy already assign to group by id.
 func (r repository) CheckPolicyAlreadyAssignToGroupById(ctx context.Context, org_id string, group_id string, policy_id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "group", "CheckPolicyAlreadyAssignToGroupById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -589,7 +660,7 @@ func (r repository) CheckPolicyAlreadyAssignToGroupById(ctx context.Context, org
 	projection := bson.M{"groups.$": 1}
 	org := mongo_entity.Organization{}
 	// Search for the role in the "organizations" collection
-	err = r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection)).Decode(&org)
+	err = r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org)
 	if err != nil {
 		return false, err
 	}
@@ -607,6 +678,9 @@ func (r repository) CheckPolicyAlreadyAssignToGroupById(ctx context.Context, org
 
 func (r repository) resolveAssignedUsers(ctx context.Context, orgId primitive.ObjectID, userIDs []primitive.ObjectID) ([]mongo_entity.AssignedUser, error) {
 
+	ctx, span := telemetry.Repository(ctx, "group", "resolveAssignedUsers")
+	defer span.End()
+
 	pipeline := mongo.Pipeline{
 		bson.D{{Key: "$match", Value: bson.M{"_id": orgId}}},
 		bson.D{{Key: "$unwind", Value: "$users"}},
@@ -635,11 +709,19 @@ func (r repository) resolveAssignedUsers(ctx context.Context, orgId primitive.Ob
 
 func (r repository) resolveAssignedRoles(ctx context.Context, orgId primitive.ObjectID, roleIDs []primitive.ObjectID) ([]mongo_entity.AssignedRole, error) {
 
+	ctx, span := telemetry.Repository(ctx, "group", "resolveAssignedRoles")
+	defer span.End()
+
+	// Roles of ancestor organizations are inherited.
+	lineage, err := orgtree.Lineage(ctx, r.mongoColl, bson.M{"_id": orgId})
+	if err != nil {
+		return nil, err
+	}
 	pipeline := mongo.Pipeline{
-		bson.D{{Key: "$match", Value: bson.M{"_id": orgId}}},
+		bson.D{{Key: "$match", Value: bson.M{"_id": bson.M{"$in": lineage}}}},
 		bson.D{{Key: "$unwind", Value: "$roles"}},
 		bson.D{{Key: "$match", Value: bson.M{"roles._id": bson.M{"$in": roleIDs}}}},
-		bson.D{{Key: "$group", Value: bson.M{"_id": "$_id", "roles": bson.M{"$push": "$roles"}}}},
+		bson.D{{Key: "$group", Value: bson.M{"_id": nil, "roles": bson.M{"$push": "$roles"}}}},
 	}
 
 	cursor, err := r.mongoColl.Aggregate(ctx, pipeline)
@@ -664,6 +746,9 @@ func (r repository) resolveAssignedRoles(ctx context.Context, orgId primitive.Ob
 
 func (r repository) resolveAssignedPolicies(ctx context.Context, orgId primitive.ObjectID, policyIDs []primitive.ObjectID) ([]mongo_entity.AssignedPolicy, error) {
 
+	ctx, span := telemetry.Repository(ctx, "group", "resolveAssignedPolicies")
+	defer span.End()
+
 	pipeline := mongo.Pipeline{
 		bson.D{{Key: "$match", Value: bson.M{"_id": orgId}}},
 		bson.D{{Key: "$unwind", Value: "$policies"}},
@@ -2,8 +2,13 @@ package group
 
 import (
 	"context"
+	"time"
 
+	"github.com/shashimalcse/cronuseo/internal/assignment"
+	"github.com/shashimalcse/cronuseo/internal/limits"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/sod"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
 	"github.com/shashimalcse/cronuseo/internal/util"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
@@ -31,6 +36,8 @@ type GroupResponse struct {
 	Users       []mongo_entity.AssignedUser   `json:"users,omitempty" bson:"users"`
 	Roles       []mongo_entity.AssignedRole   `json:"roles,omitempty" bson:"roles"`
 	Policies    []mongo_entity.AssignedPolicy `json:"policies,omitempty" bson:"policies"`
+
+	RoleGrants []mongo_entity.Grant `json:"role_grants,omitempty" bson:"role_grants"`
 }
 
 type CreateGroupRequest struct {
@@ -59,6 +66,12 @@ type PatchGroupRequest struct {
 	RemovedUsers    []primitive.ObjectID `json:"removed_users,omitempty" bson:"removed_users"`
 	AddedPolicies   []primitive.ObjectID `json:"added_policies,omitempty" bson:"added_policies"`
 	RemovedPolicies []primitive.ObjectID `json:"removed_policies,omitempty" bson:"removed_policies"`
+
+	// ValidFrom and ValidUntil make the added roles time-bound. Adding an
+	// assigned role again replaces its window, and adding it without one makes
+	// it permanent.
+	ValidFrom  *time.Time `json:"valid_from,omitempty" bson:"valid_from"`
+	ValidUntil *time.Time `json:"valid_until,omitempty" bson:"valid_until"`
 }
 
 type UpdateGroup struct {
@@ -72,6 +85,11 @@ type PatchGroup struct {
 	RemovedUsers    []primitive.ObjectID `json:"removed_users,omitempty" bson:"removed_users"`
 	AddedPolicies   []primitive.ObjectID `json:"added_policies,omitempty" bson:"added_policies"`
 	RemovedPolicies []primitive.ObjectID `json:"removed_policies,omitempty" bson:"removed_policies"`
+
+	// RoleGrants are the requested roles, assigned or not, whose grants are
+	// set to the window.
+	Window     assignment.Window    `json:"-" bson:"-"`
+	RoleGrants []primitive.ObjectID `json:"-" bson:"-"`
 }
 
 func (m UpdateGroupRequest) Validate() error {
@@ -81,16 +99,21 @@ func (m UpdateGroupRequest) Validate() error {
 type service struct {
 	repo   Repository
 	logger *zap.Logger
+	quotas limits.Quotas
+	duties sod.Enforcer
 }
 
-func NewService(repo Repository, logger *zap.Logger) Service {
+func NewService(repo Repository, logger *zap.Logger, quotas limits.Quotas, duties sod.Enforcer) Service {
 
-	return service{repo: repo, logger: logger}
+	return service{repo: repo, logger: logger, quotas: quotas, duties: duties}
 }
 
 // Get group by id.
 func (s service) Get(ctx context.Context, org_id string, id string) (GroupResponse, error) {
 
+	ctx, span := telemetry.Start(ctx, "group.service.Get")
+	defer span.End()
+
 	group, err := s.repo.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Error("Error while getting the group.",
@@ -104,10 +127,13 @@ func (s service) Get(ctx context.Context, org_id string, id string) (GroupRespon
 // Create new group.
 func (s service) Create(ctx context.Context, org_id string, req CreateGroupRequest) (GroupResponse, error) {
 
+	ctx, span := telemetry.Start(ctx, "group.service.Create")
+	defer span.End()
+
 	// Validate group request.
 	if err := req.Validate(); err != nil {
 		s.logger.Error("Error while validating group create request.")
-		return GroupResponse{}, &util.InvalidInputError{Path: "Invalid input for group."}
+		return GroupResponse{}, util.NewValidationError("Invalid input for group.", err)
 	}
 
 	// Check group already exists.
@@ -118,27 +144,31 @@ func (s service) Create(ctx context.Context, org_id string, req CreateGroupReque
 
 	}
 
+	if err := s.quotas.CheckQuota(ctx, org_id, limits.QuotaGroups); err != nil {
+		return GroupResponse{}, err
+	}
+
 	// Generate group id.
 	groupId := primitive.NewObjectID()
 
 	for _, roleId := range req.Roles {
 		exists, _ := s.repo.CheckRoleExistById(ctx, org_id, roleId.Hex())
 		if !exists {
-			return GroupResponse{}, &util.InvalidInputError{Path: "Invalid role id " + roleId.String()}
+			return GroupResponse{}, &util.InvalidInputError{Message: "Invalid role id " + roleId.String()}
 		}
 	}
 
 	for _, userId := range req.Users {
 		exists, _ := s.repo.CheckUserExistById(ctx, org_id, userId.Hex())
 		if !exists {
-			return GroupResponse{}, &util.InvalidInputError{Path: "Invalid role id " + userId.String()}
+			return GroupResponse{}, &util.InvalidInputError{Message: "Invalid role id " + userId.String()}
 		}
 	}
 
 	for _, policyId := range req.Policies {
 		exists, _ := s.repo.CheckPolicyExistById(ctx, org_id, policyId.Hex())
 		if !exists {
-			return GroupResponse{}, &util.InvalidInputError{Path: "Invalid policy id " + policyId.String()}
+			return GroupResponse{}, &util.InvalidInputError{Message: "Invalid policy id " + policyId.String()}
 		}
 	}
 
@@ -163,6 +193,13 @@ func (s service) Create(ctx context.Context, org_id string, req CreateGroupReque
 		policies = req.Policies
 	}
 
+	if err := s.duties.Enforce(ctx, org_id, sod.Change{
+		AddedUserGroups: sod.Linked(users, groupId),
+		AddedGroupRoles: sod.Links(groupId, roles),
+	}); err != nil {
+		return GroupResponse{}, err
+	}
+
 	err := s.repo.Create(ctx, org_id, mongo_entity.Group{
 		ID:          groupId,
 		DisplayName: req.DisplayName,
@@ -183,6 +220,9 @@ func (s service) Create(ctx context.Context, org_id string, req CreateGroupReque
 // // Update group.
 func (s service) Update(ctx context.Context, org_id string, id string, req UpdateGroupRequest) (GroupResponse, error) {
 
+	ctx, span := telemetry.Start(ctx, "group.service.Update")
+	defer span.End()
+
 	_, err := s.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Debug("Group not exists.", zap.String("group_id", id))
@@ -202,23 +242,34 @@ func (s service) Update(ctx context.Context, org_id string, id string, req Updat
 
 func (s service) Patch(ctx context.Context, org_id string, id string, req PatchGroupRequest) (GroupResponse, error) {
 
+	ctx, span := telemetry.Start(ctx, "group.service.Patch")
+	defer span.End()
+
 	_, err := s.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Debug("Group not exists.", zap.String("group_id", id))
 		return GroupResponse{}, &util.NotFoundError{Path: "Group " + id + " not exists."}
 	}
 
+	window := assignment.Window{ValidFrom: req.ValidFrom, ValidUntil: req.ValidUntil}
+	if window.Bounded() && len(req.AddedRoles) == 0 {
+		return GroupResponse{}, &util.InvalidInputError{Message: "Assignment window requires added roles."}
+	}
+	if err := window.Validate(time.Now()); err != nil {
+		return GroupResponse{}, err
+	}
+
 	// roles
 	for _, roleId := range req.AddedRoles {
 		exists, _ := s.repo.CheckRoleExistById(ctx, org_id, roleId.Hex())
 		if !exists {
-			return GroupResponse{}, &util.InvalidInputError{Path: "Invalid role id " + roleId.String()}
+			return GroupResponse{}, &util.InvalidInputError{Message: "Invalid role id " + roleId.String()}
 		}
 	}
 	for _, roleId := range req.RemovedRoles {
 		exists, _ := s.repo.CheckRoleExistById(ctx, org_id, roleId.Hex())
 		if !exists {
-			return GroupResponse{}, &util.InvalidInputError{Path: "Invalid role id " + roleId.String()}
+			return GroupResponse{}, &util.InvalidInputError{Message: "Invalid role id " + roleId.String()}
 		}
 	}
 	added_roles := []primitive.ObjectID{}
@@ -241,13 +292,13 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchG
 	for _, userId := range req.AddedUsers {
 		exists, _ := s.repo.CheckUserExistById(ctx, org_id, userId.Hex())
 		if !exists {
-			return GroupResponse{}, &util.InvalidInputError{Path: "Invalid user id " + userId.String()}
+			return GroupResponse{}, &util.InvalidInputError{Message: "Invalid user id " + userId.String()}
 		}
 	}
 	for _, userId := range req.RemovedUsers {
 		exists, _ := s.repo.CheckUserExistById(ctx, org_id, userId.Hex())
 		if !exists {
-			return GroupResponse{}, &util.InvalidInputError{Path: "Invalid user id " + userId.String()}
+			return GroupResponse{}, &util.InvalidInputError{Message: "Invalid user id " + userId.String()}
 		}
 	}
 	added_users := []primitive.ObjectID{}
@@ -270,13 +321,13 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchG
 	for _, policyId := range req.AddedPolicies {
 		exists, _ := s.repo.CheckPolicyExistById(ctx, org_id, policyId.Hex())
 		if !exists {
-			return GroupResponse{}, &util.InvalidInputError{Path: "Invalid policy id " + policyId.String()}
+			return GroupResponse{}, &util.InvalidInputError{Message: "Invalid policy id " + policyId.String()}
 		}
 	}
 	for _, policyId := range req.RemovedPolicies {
 		exists, _ := s.repo.CheckPolicyExistById(ctx, org_id, policyId.Hex())
 		if !exists {
-			return GroupResponse{}, &util.InvalidInputError{Path: "Invalid policy id " + policyId.String()}
+			return GroupResponse{}, &util.InvalidInputError{Message: "Invalid policy id " + policyId.String()}
 		}
 	}
 	added_policies := []primitive.ObjectID{}
@@ -295,6 +346,17 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchG
 		}
 	}
 
+	// Group roles apply to every member, so they are enforced together.
+	groupId, _ := primitive.ObjectIDFromHex(id)
+	if err := s.duties.Enforce(ctx, org_id, sod.Change{
+		AddedUserGroups:   sod.Linked(added_users, groupId),
+		RemovedUserGroups: sod.Linked(removed_users, groupId),
+		AddedGroupRoles:   sod.Links(groupId, added_roles),
+		RemovedGroupRoles: sod.Links(groupId, removed_roles),
+	}); err != nil {
+		return GroupResponse{}, err
+	}
+
 	if err := s.repo.Patch(ctx, org_id, id, PatchGroup{
 		AddedRoles:      added_roles,
 		RemovedRoles:    removed_roles,
@@ -302,6 +364,8 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchG
 		RemovedUsers:    removed_users,
 		AddedPolicies:   added_policies,
 		RemovedPolicies: removed_policies,
+		Window:          window,
+		RoleGrants:      req.AddedRoles,
 	}); err != nil {
 		s.logger.Error("Error while updating group.",
 			zap.String("organization_id", org_id),
@@ -314,6 +378,9 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchG
 // Delete group.
 func (s service) Delete(ctx context.Context, org_id string, id string) error {
 
+	ctx, span := telemetry.Start(ctx, "group.service.Delete")
+	defer span.End()
+
 	_, err := s.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Error("Group not exists.", zap.String("group_id", id))
@@ -339,6 +406,9 @@ type Filter struct {
 // // Get all group.
 func (s service) Query(ctx context.Context, org_id string, filter Filter) ([]Group, error) {
 
+	ctx, span := telemetry.Start(ctx, "group.service.Query")
+	defer span.End()
+
 	result := []Group{}
 	items, err := s.repo.Query(ctx, org_id)
 	if err != nil {
@@ -0,0 +1,157 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// checkTimeout bounds each dependency check of a readiness probe.
+const checkTimeout = 2 * time.Second
+
+// Check returns an error when a dependency is not available.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Report is the result of a readiness probe, with the error of each failed
+// dependency check.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Health tracks the dependency checks of a server and whether it is shutting
+// down. Servers are not ready while shutting down, so load balancers stop
+// sending requests before in-flight requests are drained.
+type Health struct {
+	logger       *zap.Logger
+	mu           sync.RWMutex
+	checks       []namedCheck
+	shuttingDown atomic.Bool
+}
+
+// New creates the health of a server without dependency checks.
+func New(logger *zap.Logger) *Health {
+
+	return &Health{logger: logger}
+}
+
+// Add a dependency check the server needs to be ready.
+func (h *Health) Add(name string, check Check) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+}
+
+// Shutdown marks the server as shutting down.
+func (h *Health) Shutdown() {
+
+	h.shuttingDown.Store(true)
+}
+
+// Ready runs the dependency checks concurrently.
+func (h *Health) Ready(ctx context.Context) (Report, bool) {
+
+	if h.shuttingDown.Load() {
+		return Report{Status: "shutting down"}, false
+	}
+	h.mu.RLock()
+	checks := h.checks
+	h.mu.RUnlock()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c namedCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			errs[i] = c.check(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: "ok"}
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if report.Checks == nil {
+			report.Checks = map[string]string{}
+		}
+		report.Status = "unavailable"
+		report.Checks[checks[i].name] = err.Error()
+	}
+	return report, report.Checks == nil
+}
+
+// Liveness serves /healthz. The server is alive as long as it serves requests.
+func (h *Health) Liveness() http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		write(w, http.StatusOK, Report{Status: "ok"})
+	})
+}
+
+// Readiness serves /readyz with the report of the dependency checks.
+func (h *Health) Readiness() http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, ready := h.Ready(r.Context())
+		if !ready {
+			h.logger.Debug("Server is not ready.", zap.Any("report", report))
+			write(w, http.StatusServiceUnavailable, report)
+			return
+		}
+		write(w, http.StatusOK, report)
+	})
+}
+
+// Watch updates the serving status of the gRPC health server with the
+// dependency checks until the context is done. The overall status and the
+// status of each service are the same.
+func (h *Health) Watch(ctx context.Context, server *grpchealth.Server, interval time.Duration, services ...string) {
+
+	update := func() {
+		_, ready := h.Ready(ctx)
+		status := healthpb.HealthCheckResponse_SERVING
+		if !ready {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		for _, service := range append([]string{""}, services...) {
+			server.SetServingStatus(service, status)
+		}
+	}
+	update()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			update()
+		}
+	}
+}
+
+func write(w http.ResponseWriter, code int, report Report) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,89 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func probe(t *testing.T, handler http.Handler, path string) (int, Report) {
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var report Report
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	return rec.Code, report
+}
+
+func TestReadiness(t *testing.T) {
+
+	h := New(zap.NewNop())
+	h.Add("mongodb", func(ctx context.Context) error { return nil })
+
+	code, report := probe(t, h.Readiness(), "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", report.Status)
+
+	h.Add("jwks", func(ctx context.Context) error { return errors.New("signing keys are not available") })
+	code, report = probe(t, h.Readiness(), "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, map[string]string{"jwks": "signing keys are not available"}, report.Checks)
+
+	h.Shutdown()
+	code, report = probe(t, h.Readiness(), "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "shutting down", report.Status)
+
+	// Liveness does not depend on the dependency checks.
+	code, _ = probe(t, h.Liveness(), "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestReadinessTimeout(t *testing.T) {
+
+	h := New(zap.NewNop())
+	h.Add("mongodb", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	start := time.Now()
+	_, ready := h.Ready(context.Background())
+	assert.False(t, ready)
+	assert.Less(t, time.Since(start), 2*checkTimeout)
+}
+
+func TestWatch(t *testing.T) {
+
+	h := New(zap.NewNop())
+	server := grpchealth.NewServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.Watch(ctx, server, 10*time.Millisecond, "cronuseo.Check")
+
+	status := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return healthpb.HealthCheckResponse_UNKNOWN
+		}
+		return resp.Status
+	}
+	assert.Eventually(t, func() bool {
+		return status("") == healthpb.HealthCheckResponse_SERVING &&
+			status("cronuseo.Check") == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, 10*time.Millisecond)
+
+	h.Shutdown()
+	assert.Eventually(t, func() bool {
+		return status("cronuseo.Check") == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 10*time.Millisecond)
+}
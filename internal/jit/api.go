@@ -0,0 +1,72 @@
+package jit
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/util"
+)
+
+func RegisterHandlers(r *echo.Group, service Service) {
+	res := resource{service}
+	router := r.Group("/o/:org_id/claim-mapping")
+	router.GET("", res.get)
+	router.PUT("", res.update)
+	router.DELETE("", res.delete)
+}
+
+type resource struct {
+	service Service
+}
+
+// @Description Get the just-in-time provisioning claim mapping of the organization.
+// @Tags        User
+// @Param org_id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {object}  Mapping
+// @failure     404,500
+// @Router      /o/{org_id}/claim-mapping [get]
+func (r resource) get(c echo.Context) error {
+
+	mapping, err := r.service.Get(c.Request().Context(), c.Param("org_id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, mapping)
+}
+
+// @Description Replace the just-in-time provisioning claim mapping of the organization.
+// @Tags        User
+// @Accept      json
+// @Param org_id path string true "Organization ID"
+// @Param request body UpdateMappingRequest true "body"
+// @Produce     json
+// @Success     200 {object}  Mapping
+// @failure     400,404,500
+// @Router      /o/{org_id}/claim-mapping [put]
+func (r resource) update(c echo.Context) error {
+
+	var input UpdateMappingRequest
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	mapping, err := r.service.Update(c.Request().Context(), c.Param("org_id"), input)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, mapping)
+}
+
+// @Description Delete the claim mapping of the organization, disabling just-in-time provisioning.
+// @Tags        User
+// @Param org_id path string true "Organization ID"
+// @Success     204
+// @failure     404,500
+// @Router      /o/{org_id}/claim-mapping [delete]
+func (r resource) delete(c echo.Context) error {
+
+	if err := r.service.Delete(c.Request().Context(), c.Param("org_id")); err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusNoContent, "")
+}
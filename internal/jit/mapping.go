@@ -0,0 +1,126 @@
+package jit
+
+import (
+	"strings"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/user"
+	"github.com/shashimalcse/cronuseo/internal/util"
+)
+
+// Default claims of the subject identifier and username. Subjects without a
+// username claim use the identifier as username.
+const (
+	DefaultSubjectClaim  = "sub"
+	DefaultUsernameClaim = "preferred_username"
+)
+
+// syncRequest maps the claims to the sync request of the subject. Only the
+// existing roles and groups are assigned, claims never create them.
+func syncRequest(mapping mongo_entity.ClaimMapping, claims map[string]interface{}, roles map[string]bool, groups map[string]bool) (user.SyncUserRequest, error) {
+
+	subjectClaim := mapping.SubjectClaim
+	if subjectClaim == "" {
+		subjectClaim = DefaultSubjectClaim
+	}
+	identifier, _ := claim(claims, subjectClaim).(string)
+	if identifier == "" {
+		return user.SyncUserRequest{}, &util.InvalidInputError{Message: "Invalid claims.",
+			Fields: []util.FieldError{{Field: "claims." + subjectClaim, Code: "validation_required", Message: "cannot be blank"}}}
+	}
+	usernameClaim := mapping.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = DefaultUsernameClaim
+	}
+	username, _ := claim(claims, usernameClaim).(string)
+	if username == "" {
+		username = identifier
+	}
+
+	req := user.SyncUserRequest{
+		Username:   username,
+		Identifier: identifier,
+		Roles:      []string{},
+		Groups:     []string{},
+		Mode:       mapping.Mode,
+	}
+	for _, attribute := range mapping.Attributes {
+		if value := claim(claims, attribute.Claim); value != nil {
+			if req.UserProperties == nil {
+				req.UserProperties = map[string]interface{}{}
+			}
+			req.UserProperties[attribute.Property] = value
+		}
+	}
+	seenRoles, seenGroups := map[string]bool{}, map[string]bool{}
+	addRoles := func(identifiers []string) {
+		for _, identifier := range identifiers {
+			if roles[identifier] && !seenRoles[identifier] {
+				seenRoles[identifier] = true
+				req.Roles = append(req.Roles, identifier)
+			}
+		}
+	}
+	addGroups := func(identifiers []string) {
+		for _, identifier := range identifiers {
+			if groups[identifier] && !seenGroups[identifier] {
+				seenGroups[identifier] = true
+				req.Groups = append(req.Groups, identifier)
+			}
+		}
+	}
+	if mapping.RolesClaim != "" {
+		addRoles(values(claim(claims, mapping.RolesClaim)))
+	}
+	if mapping.GroupsClaim != "" {
+		addGroups(values(claim(claims, mapping.GroupsClaim)))
+	}
+	for _, rule := range mapping.Rules {
+		for _, value := range values(claim(claims, rule.Claim)) {
+			if value == rule.Value {
+				addRoles(rule.Roles)
+				addGroups(rule.Groups)
+				break
+			}
+		}
+	}
+	return req, nil
+}
+
+// claim returns the value of the claim. Names with dots refer to nested
+// claims unless a claim has the whole name.
+func claim(claims map[string]interface{}, name string) interface{} {
+
+	if value, ok := claims[name]; ok {
+		return value
+	}
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) < 2 {
+		return nil
+	}
+	nested, ok := claims[parts[0]].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return claim(nested, parts[1])
+}
+
+// values returns the string values of a claim, a string or a list.
+func values(value interface{}) []string {
+
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		result := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
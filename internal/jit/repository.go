@@ -0,0 +1,118 @@
+package jit
+
+import (
+	"context"
+
+	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/orgtree"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Repository interface {
+	Get(ctx context.Context, org_id string) (*mongo_entity.ClaimMapping, error)
+	GetByIdentifier(ctx context.Context, org_identifier string) (*mongo_entity.Organization, error)
+	GetInheritedRoles(ctx context.Context, org_identifier string) ([]mongo_entity.Role, error)
+	Update(ctx context.Context, org_id string, mapping *mongo_entity.ClaimMapping) error
+}
+
+type repository struct {
+	mongoClient *mongo.Client
+	mongoColl   *mongo.Collection
+}
+
+func NewRepository(mongodb *db.MongoDB) Repository {
+
+	orgCollection := mongodb.MongoClient.Database(mongodb.MongoConfig.DBName).Collection(mongodb.MongoConfig.OrganizationCollectionName)
+
+	return repository{mongoClient: mongodb.MongoClient, mongoColl: orgCollection}
+}
+
+// Get returns the claim mapping of the organization, nil when none is set.
+func (r repository) Get(ctx context.Context, org_id string) (*mongo_entity.ClaimMapping, error) {
+
+	ctx, span := telemetry.Repository(ctx, "jit", "Get")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	var org mongo_entity.Organization
+	opts := options.FindOne().SetProjection(bson.M{"claim_mapping": 1})
+	err = r.mongoColl.FindOne(ctx, bson.M{"_id": orgId}, opts).Decode(&org)
+	if err == mongo.ErrNoDocuments {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return org.ClaimMapping, nil
+}
+
+// GetByIdentifier returns the claim mapping of the organization with the
+// identifier, and the identifiers of its roles and groups.
+func (r repository) GetByIdentifier(ctx context.Context, org_identifier string) (*mongo_entity.Organization, error) {
+
+	ctx, span := telemetry.Repository(ctx, "jit", "GetByIdentifier")
+	defer span.End()
+
+	var org mongo_entity.Organization
+	opts := options.FindOne().SetProjection(bson.M{
+		"identifier": 1, "claim_mapping": 1,
+		"roles._id": 1, "roles.identifier": 1,
+		"groups._id": 1, "groups.identifier": 1,
+	})
+	err := r.mongoColl.FindOne(ctx, bson.M{"identifier": org_identifier}, opts).Decode(&org)
+	if err == mongo.ErrNoDocuments {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// GetInheritedRoles returns the roles of the ancestors of the organization
+// with the identifier, nearest first.
+func (r repository) GetInheritedRoles(ctx context.Context, org_identifier string) ([]mongo_entity.Role, error) {
+
+	ctx, span := telemetry.Repository(ctx, "jit", "GetInheritedRoles")
+	defer span.End()
+
+	lineage, err := orgtree.Lineage(ctx, r.mongoColl, bson.M{"identifier": org_identifier})
+	if err != nil {
+		return nil, err
+	}
+	return orgtree.Roles(ctx, r.mongoColl, lineage[1:])
+}
+
+// Update replaces the claim mapping of the organization, or removes it when
+// the mapping is nil.
+func (r repository) Update(ctx context.Context, org_id string, mapping *mongo_entity.ClaimMapping) error {
+
+	ctx, span := telemetry.Repository(ctx, "jit", "Update")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return &util.NotFoundError{Path: "Organization"}
+	}
+	update := bson.M{"$set": bson.M{"claim_mapping": mapping}}
+	if mapping == nil {
+		update = bson.M{"$unset": bson.M{"claim_mapping": ""}}
+	}
+	result, err := r.mongoColl.UpdateOne(ctx, bson.M{"_id": orgId}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &util.NotFoundError{Path: "Organization"}
+	}
+	return nil
+}
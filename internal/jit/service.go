@@ -0,0 +1,275 @@
+package jit
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/token"
+	"github.com/shashimalcse/cronuseo/internal/user"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.uber.org/zap"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// ProvisionTTL is how long a provisioned subject is not synced again for the
+// same claims.
+const ProvisionTTL = time.Minute
+
+// maxProvisioned bounds the number of recently provisioned subjects kept.
+const maxProvisioned = 10000
+
+// Verifier verifies the tokens of check subjects.
+type Verifier interface {
+	Verify(raw string) (token.Identity, error)
+}
+
+type Service interface {
+	Get(ctx context.Context, org_id string) (Mapping, error)
+	Update(ctx context.Context, org_id string, req UpdateMappingRequest) (Mapping, error)
+	Delete(ctx context.Context, org_id string) error
+	// Provision creates or updates the user of the claims, or of the claims of
+	// the token, and returns its identifier.
+	Provision(ctx context.Context, org_identifier string, claims map[string]interface{}, raw string) (string, error)
+}
+
+type Mapping struct {
+	mongo_entity.ClaimMapping
+}
+
+type UpdateMappingRequest struct {
+	Enabled       bool        `json:"enabled"`
+	SubjectClaim  string      `json:"subject_claim,omitempty"`
+	UsernameClaim string      `json:"username_claim,omitempty"`
+	RolesClaim    string      `json:"roles_claim,omitempty"`
+	GroupsClaim   string      `json:"groups_claim,omitempty"`
+	Attributes    []Attribute `json:"attributes,omitempty"`
+	Rules         []Rule      `json:"rules,omitempty"`
+	Mode          string      `json:"mode,omitempty"`
+}
+
+func (m UpdateMappingRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Attributes),
+		validation.Field(&m.Rules),
+		validation.Field(&m.Mode, validation.In(user.SyncModeMerge, user.SyncModeReplace)),
+	)
+}
+
+type Attribute struct {
+	mongo_entity.ClaimAttribute
+}
+
+func (m Attribute) Validate() error {
+	return validation.ValidateStruct(&m.ClaimAttribute,
+		validation.Field(&m.Claim, validation.Required),
+		validation.Field(&m.Property, validation.Required),
+	)
+}
+
+type Rule struct {
+	mongo_entity.ClaimRule
+}
+
+func (m Rule) Validate() error {
+	return validation.ValidateStruct(&m.ClaimRule,
+		validation.Field(&m.Claim, validation.Required),
+		validation.Field(&m.Value, validation.Required),
+		validation.Field(&m.Roles, validation.Each(validation.Required)),
+		validation.Field(&m.Groups, validation.Each(validation.Required)),
+	)
+}
+
+type service struct {
+	repo     Repository
+	users    user.Service
+	verifier Verifier
+	logger   *zap.Logger
+	recent   *recent
+}
+
+// NewService creates the just-in-time provisioning service. Tokens are not
+// accepted when verifier is nil.
+func NewService(repo Repository, users user.Service, verifier Verifier, logger *zap.Logger) Service {
+
+	return service{repo: repo, users: users, verifier: verifier, logger: logger, recent: newRecent(ProvisionTTL)}
+}
+
+// Get the claim mapping of the organization.
+func (s service) Get(ctx context.Context, org_id string) (Mapping, error) {
+
+	ctx, span := telemetry.Start(ctx, "jit.service.Get")
+	defer span.End()
+
+	mapping, err := s.repo.Get(ctx, org_id)
+	if err != nil {
+		return Mapping{}, err
+	}
+	if mapping == nil {
+		return Mapping{}, &util.NotFoundError{Path: "Claim mapping"}
+	}
+	return Mapping{*mapping}, nil
+}
+
+// Update the claim mapping of the organization.
+func (s service) Update(ctx context.Context, org_id string, req UpdateMappingRequest) (Mapping, error) {
+
+	ctx, span := telemetry.Start(ctx, "jit.service.Update")
+	defer span.End()
+
+	if err := req.Validate(); err != nil {
+		s.logger.Debug("Error while validating claim mapping.", zap.String("organization_id", org_id))
+		return Mapping{}, util.NewValidationError("Invalid input for claim mapping.", err)
+	}
+	mapping := mongo_entity.ClaimMapping{
+		Enabled:       req.Enabled,
+		SubjectClaim:  req.SubjectClaim,
+		UsernameClaim: req.UsernameClaim,
+		RolesClaim:    req.RolesClaim,
+		GroupsClaim:   req.GroupsClaim,
+		Mode:          req.Mode,
+	}
+	for _, attribute := range req.Attributes {
+		mapping.Attributes = append(mapping.Attributes, attribute.ClaimAttribute)
+	}
+	for _, rule := range req.Rules {
+		mapping.Rules = append(mapping.Rules, rule.ClaimRule)
+	}
+	if err := s.repo.Update(ctx, org_id, &mapping); err != nil {
+		s.logger.Error("Error while updating claim mapping.", zap.String("organization_id", org_id))
+		return Mapping{}, err
+	}
+	return Mapping{mapping}, nil
+}
+
+// Delete the claim mapping of the organization, disabling just-in-time
+// provisioning.
+func (s service) Delete(ctx context.Context, org_id string) error {
+
+	ctx, span := telemetry.Start(ctx, "jit.service.Delete")
+	defer span.End()
+
+	if _, err := s.Get(ctx, org_id); err != nil {
+		return err
+	}
+	if err := s.repo.Update(ctx, org_id, nil); err != nil {
+		s.logger.Error("Error while deleting claim mapping.", zap.String("organization_id", org_id))
+		return err
+	}
+	return nil
+}
+
+// Provision syncs the user of the claims with the claim mapping of the
+// organization. Claims are trusted as is, so callers must only pass claims of
+// authenticated sources, while tokens are verified. Subjects provisioned with
+// the same claims in the last ProvisionTTL are not synced again.
+func (s service) Provision(ctx context.Context, org_identifier string, claims map[string]interface{}, raw string) (string, error) {
+
+	ctx, span := telemetry.Start(ctx, "jit.service.Provision")
+	defer span.End()
+
+	if raw != "" {
+		if claims != nil {
+			return "", &util.InvalidInputError{Message: "Only one of claims and token is accepted."}
+		}
+		if s.verifier == nil {
+			return "", &util.InvalidInputError{Message: "Tokens are not accepted by this server."}
+		}
+		identity, err := s.verifier.Verify(raw)
+		if err != nil {
+			s.logger.Debug("Invalid subject token.", zap.Error(err))
+			return "", &util.UnauthorizedError{Message: "invalid or expired subject token"}
+		}
+		if identity.Organization != "" && identity.Organization != org_identifier {
+			return "", &util.UnauthorizedError{Message: "subject token is not valid for this organization"}
+		}
+		claims = identity.Claims
+	}
+
+	org, err := s.repo.GetByIdentifier(ctx, org_identifier)
+	if err != nil {
+		return "", err
+	}
+	if org.ClaimMapping == nil || !org.ClaimMapping.Enabled {
+		return "", &util.InvalidInputError{Message: "Just-in-time provisioning is not enabled for the organization."}
+	}
+	// Roles of ancestors are inherited, the user sync resolves them.
+	inherited, err := s.repo.GetInheritedRoles(ctx, org_identifier)
+	if err != nil {
+		return "", err
+	}
+	roles, groups := map[string]bool{}, map[string]bool{}
+	for _, role := range append(org.Roles, inherited...) {
+		roles[role.Identifier] = true
+	}
+	for _, group := range org.Groups {
+		groups[group.Identifier] = true
+	}
+	req, err := syncRequest(*org.ClaimMapping, claims, roles, groups)
+	if err != nil {
+		return "", err
+	}
+
+	key := org_identifier + "\x00" + req.Identifier
+	digest, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	if s.recent.seen(key, string(digest)) {
+		return req.Identifier, nil
+	}
+	if _, err := s.users.Sync(ctx, org_identifier, req); err != nil {
+		s.logger.Debug("Error while provisioning check subject.", zap.String("organization", org_identifier),
+			zap.String("identifier", req.Identifier), zap.Error(err))
+		return "", err
+	}
+	s.recent.add(key, string(digest))
+	return req.Identifier, nil
+}
+
+// recent remembers the claims subjects were recently provisioned with.
+type recent struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]provisioned
+}
+
+type provisioned struct {
+	digest  string
+	expires time.Time
+}
+
+func newRecent(ttl time.Duration) *recent {
+	return &recent{ttl: ttl, now: time.Now, entries: map[string]provisioned{}}
+}
+
+func (r *recent) seen(key string, digest string) bool {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.entries[key]
+	return ok && entry.digest == digest && r.now().Before(entry.expires)
+}
+
+func (r *recent) add(key string, digest string) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if len(r.entries) >= maxProvisioned {
+		for k, entry := range r.entries {
+			if !now.Before(entry.expires) {
+				delete(r.entries, k)
+			}
+		}
+		if len(r.entries) >= maxProvisioned {
+			r.entries = map[string]provisioned{}
+		}
+	}
+	r.entries[key] = provisioned{digest: digest, expires: now.Add(r.ttl)}
+}
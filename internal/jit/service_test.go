@@ -0,0 +1,204 @@
+package jit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/token"
+	"github.com/shashimalcse/cronuseo/internal/user"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+type mockRepository struct {
+	org       mongo_entity.Organization
+	inherited []mongo_entity.Role
+}
+
+func (m *mockRepository) Get(ctx context.Context, org_id string) (*mongo_entity.ClaimMapping, error) {
+	return m.org.ClaimMapping, nil
+}
+
+func (m *mockRepository) GetByIdentifier(ctx context.Context, org_identifier string) (*mongo_entity.Organization, error) {
+
+	if org_identifier != m.org.Identifier {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	return &m.org, nil
+}
+
+func (m *mockRepository) GetInheritedRoles(ctx context.Context, org_identifier string) ([]mongo_entity.Role, error) {
+	return m.inherited, nil
+}
+
+func (m *mockRepository) Update(ctx context.Context, org_id string, mapping *mongo_entity.ClaimMapping) error {
+
+	m.org.ClaimMapping = mapping
+	return nil
+}
+
+type mockUserService struct {
+	user.Service
+	synced []user.SyncUserRequest
+}
+
+func (m *mockUserService) Sync(ctx context.Context, org_identifier string, req user.SyncUserRequest) (user.SyncUserResponse, error) {
+
+	m.synced = append(m.synced, req)
+	return user.SyncUserResponse{Identifier: req.Identifier}, nil
+}
+
+type mockVerifier struct{}
+
+func (mockVerifier) Verify(raw string) (token.Identity, error) {
+
+	switch raw {
+	case "acme":
+		return token.Identity{Subject: "jane", Organization: "acme", Claims: map[string]interface{}{"sub": "jane"}}, nil
+	case "globex":
+		return token.Identity{Subject: "jane", Organization: "globex", Claims: map[string]interface{}{"sub": "jane"}}, nil
+	}
+	return token.Identity{}, errors.New("invalid token signature")
+}
+
+func testOrganization() mongo_entity.Organization {
+
+	return mongo_entity.Organization{
+		Identifier: "acme",
+		Roles: []mongo_entity.Role{
+			{ID: primitive.NewObjectID(), Identifier: "viewer"},
+			{ID: primitive.NewObjectID(), Identifier: "admin"},
+		},
+		Groups: []mongo_entity.Group{{ID: primitive.NewObjectID(), Identifier: "sales"}},
+	}
+}
+
+func TestSyncRequest(t *testing.T) {
+
+	mapping := mongo_entity.ClaimMapping{
+		Enabled:     true,
+		RolesClaim:  "roles",
+		GroupsClaim: "groups",
+		Attributes: []mongo_entity.ClaimAttribute{
+			{Claim: "department", Property: "department"},
+			{Claim: "address.country", Property: "country"},
+			{Claim: "missing", Property: "missing"},
+		},
+		Rules: []mongo_entity.ClaimRule{
+			{Claim: "email_verified_domain", Value: "acme.com", Roles: []string{"viewer"}},
+			{Claim: "groups", Value: "Domain Admins", Roles: []string{"admin", "auditor"}},
+		},
+		Mode: user.SyncModeReplace,
+	}
+	roles := map[string]bool{"viewer": true, "admin": true}
+	groups := map[string]bool{"sales": true}
+
+	req, err := syncRequest(mapping, map[string]interface{}{
+		"sub":                   "jane@acme.com",
+		"preferred_username":    "jane",
+		"roles":                 []interface{}{"viewer", "unknown"},
+		"groups":                []interface{}{"sales", "Domain Admins"},
+		"department":            "finance",
+		"address":               map[string]interface{}{"country": "LK"},
+		"email_verified_domain": "acme.com",
+	}, roles, groups)
+	assert.Nil(t, err)
+	assert.Equal(t, user.SyncUserRequest{
+		Username:       "jane",
+		Identifier:     "jane@acme.com",
+		UserProperties: map[string]interface{}{"department": "finance", "country": "LK"},
+		Roles:          []string{"viewer", "admin"},
+		Groups:         []string{"sales"},
+		Mode:           user.SyncModeReplace,
+	}, req)
+
+	// the username defaults to the subject
+	mapping.SubjectClaim = "oid"
+	req, err = syncRequest(mapping, map[string]interface{}{"oid": "42", "roles": "admin"}, roles, groups)
+	assert.Nil(t, err)
+	assert.Equal(t, "42", req.Username)
+	assert.Equal(t, []string{"admin"}, req.Roles)
+	assert.Equal(t, []string{}, req.Groups)
+
+	_, err = syncRequest(mapping, map[string]interface{}{"sub": "42"}, roles, groups)
+	assert.Equal(t, "claims.oid", err.(*util.InvalidInputError).Fields[0].Field)
+}
+
+func TestProvision(t *testing.T) {
+
+	ctx := context.Background()
+	repo := &mockRepository{org: testOrganization()}
+	users := &mockUserService{}
+	s := NewService(repo, users, mockVerifier{}, zap.NewNop())
+
+	claims := map[string]interface{}{"sub": "jane", "roles": []interface{}{"viewer"}}
+	_, err := s.Provision(ctx, "acme", claims, "")
+	assert.IsType(t, &util.InvalidInputError{}, err)
+
+	_, err = s.Update(ctx, "", UpdateMappingRequest{Enabled: true, RolesClaim: "roles", Mode: "sync"})
+	assert.IsType(t, &util.InvalidInputError{}, err)
+	_, err = s.Update(ctx, "", UpdateMappingRequest{Enabled: true, RolesClaim: "roles",
+		Rules: []Rule{{mongo_entity.ClaimRule{Claim: "groups"}}}})
+	assert.Equal(t, "rules.0.value", err.(*util.InvalidInputError).Fields[0].Field)
+	mapping, err := s.Update(ctx, "", UpdateMappingRequest{Enabled: true, RolesClaim: "roles"})
+	assert.Nil(t, err)
+	assert.Equal(t, "roles", mapping.RolesClaim)
+
+	identifier, err := s.Provision(ctx, "acme", claims, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "jane", identifier)
+	assert.Equal(t, []string{"viewer"}, users.synced[0].Roles)
+
+	// subjects are not synced again with the same claims
+	_, err = s.Provision(ctx, "acme", claims, "")
+	assert.Nil(t, err)
+	assert.Len(t, users.synced, 1)
+	claims["roles"] = []interface{}{"viewer", "admin"}
+	_, err = s.Provision(ctx, "acme", claims, "")
+	assert.Nil(t, err)
+	assert.Len(t, users.synced, 2)
+
+	// roles inherited from ancestors are mapped
+	repo.inherited = []mongo_entity.Role{{ID: primitive.NewObjectID(), Identifier: "auditor"}}
+	claims["roles"] = []interface{}{"viewer", "auditor", "unknown"}
+	_, err = s.Provision(ctx, "acme", claims, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"viewer", "auditor"}, users.synced[2].Roles)
+
+	identifier, err = s.Provision(ctx, "acme", nil, "acme")
+	assert.Nil(t, err)
+	assert.Equal(t, "jane", identifier)
+	_, err = s.Provision(ctx, "acme", nil, "globex")
+	assert.IsType(t, &util.UnauthorizedError{}, err)
+	_, err = s.Provision(ctx, "acme", nil, "forged")
+	assert.IsType(t, &util.UnauthorizedError{}, err)
+	_, err = s.Provision(ctx, "acme", claims, "acme")
+	assert.IsType(t, &util.InvalidInputError{}, err)
+
+	s = NewService(repo, users, nil, zap.NewNop())
+	_, err = s.Provision(ctx, "acme", nil, "acme")
+	assert.IsType(t, &util.InvalidInputError{}, err)
+	_, err = s.Provision(ctx, "globex", claims, "")
+	assert.IsType(t, &util.NotFoundError{}, err)
+
+	assert.Nil(t, s.Delete(ctx, ""))
+	_, err = s.Get(ctx, "")
+	assert.IsType(t, &util.NotFoundError{}, err)
+}
+
+func TestRecent(t *testing.T) {
+
+	now := time.Now()
+	r := newRecent(time.Minute)
+	r.now = func() time.Time { return now }
+	r.add("acme\x00jane", "a")
+	assert.True(t, r.seen("acme\x00jane", "a"))
+	assert.False(t, r.seen("acme\x00jane", "b"))
+	now = now.Add(time.Minute)
+	assert.False(t, r.seen("acme\x00jane", "a"))
+}
@@ -0,0 +1,57 @@
+package limits
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/util"
+)
+
+func RegisterHandlers(r *echo.Group, service Service) {
+	res := handler{service}
+	router := r.Group("/organizations/:id/limits")
+	router.GET("", res.get)
+	router.PUT("", res.update)
+}
+
+type handler struct {
+	service Service
+}
+
+// @Description Get the rate limits and quotas of the organization.
+// @Tags        Organization
+// @Param id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {object}  Limits
+// @failure     404,500
+// @Router      /organizations/{id}/limits [get]
+func (r handler) get(c echo.Context) error {
+
+	limits, err := r.service.Get(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, limits)
+}
+
+// @Description Set the rate limits and quotas of the organization. Zero values use the defaults and negative values are unlimited.
+// @Tags        Organization
+// @Accept      json
+// @Param id path string true "Organization ID"
+// @Param request body UpdateLimitsRequest true "body"
+// @Produce     json
+// @Success     200 {object}  Limits
+// @failure     400,404,500
+// @Router      /organizations/{id}/limits [put]
+func (r handler) update(c echo.Context) error {
+
+	var req UpdateLimitsRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	limits, err := r.service.Update(c.Request().Context(), c.Param("id"), req)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, limits)
+}
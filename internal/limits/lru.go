@@ -0,0 +1,67 @@
+package limits
+
+import "container/list"
+
+// lru is a cache of at most size entries, evicting the least recently used
+// entry when full. It is not safe for concurrent use.
+type lru[V any] struct {
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+type lruItem[V any] struct {
+	key   string
+	value V
+}
+
+func newLRU[V any](size int) *lru[V] {
+
+	return &lru[V]{size: size, items: map[string]*list.Element{}, order: list.New()}
+}
+
+// Get returns the value of the key and marks it as recently used.
+func (c *lru[V]) Get(key string) (V, bool) {
+
+	element, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruItem[V]).value, true
+}
+
+// Add sets the value of the key and evicts the least recently used entry when
+// the cache is full.
+func (c *lru[V]) Add(key string, value V) {
+
+	if element, ok := c.items[key]; ok {
+		element.Value.(*lruItem[V]).value = value
+		c.order.MoveToFront(element)
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruItem[V]).key)
+	}
+	c.items[key] = c.order.PushFront(&lruItem[V]{key: key, value: value})
+}
+
+// RemoveFunc removes the entries for which remove returns true.
+func (c *lru[V]) RemoveFunc(remove func(key string, value V) bool) {
+
+	for key, element := range c.items {
+		if remove(key, element.Value.(*lruItem[V]).value) {
+			c.order.Remove(element)
+			delete(c.items, key)
+		}
+	}
+}
+
+// Len returns the number of entries.
+func (c *lru[V]) Len() int {
+
+	return c.order.Len()
+}
@@ -0,0 +1,43 @@
+package limits
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/util"
+)
+
+// RateLimit limits the requests to organization scoped routes per
+// organization and the principal authenticated by an earlier middleware, e.g.
+// the subject of the verified token. Requests without a principal are not
+// limited, so it must run after authentication.
+func RateLimit(service RateLimiter, principal func(c echo.Context) string) echo.MiddlewareFunc {
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			org := c.Param("org_id")
+			if org == "" {
+				return next(c)
+			}
+			authenticated := principal(c)
+			if authenticated == "" {
+				return next(c)
+			}
+			if err := service.Allow(c.Request().Context(), org, authenticated); err != nil {
+				if e, ok := err.(*util.RateLimitError); ok {
+					c.Response().Header().Set("Retry-After", RetryAfter(e.RetryAfter))
+				}
+				return util.HandleError(err)
+			}
+			return next(c)
+		}
+	}
+}
+
+// RetryAfter formats the delay of a rate limited request in whole seconds.
+func RetryAfter(d time.Duration) string {
+
+	return strconv.Itoa(int(math.Max(1, math.Ceil(d.Seconds()))))
+}
@@ -0,0 +1,134 @@
+package limits
+
+import (
+	"context"
+
+	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Repository interface {
+	Get(ctx context.Context, org string) (*Organization, error)
+	Update(ctx context.Context, org_id string, limits mongo_entity.OrganizationLimits) error
+	Count(ctx context.Context, org_id string, quota Quota) (int64, error)
+	IncrementChecks(ctx context.Context, org_identifier string, month string) (int64, error)
+}
+
+// Organization is the organization limits are resolved for.
+type Organization struct {
+	ID         primitive.ObjectID               `bson:"_id"`
+	Identifier string                           `bson:"identifier"`
+	Limits     *mongo_entity.OrganizationLimits `bson:"limits"`
+}
+
+type repository struct {
+	mongoClient *mongo.Client
+	mongoColl   *mongo.Collection
+}
+
+func NewRepository(mongodb *db.MongoDB) Repository {
+
+	orgCollection := mongodb.MongoClient.Database(mongodb.MongoConfig.DBName).Collection(mongodb.MongoConfig.OrganizationCollectionName)
+
+	return repository{mongoClient: mongodb.MongoClient, mongoColl: orgCollection}
+}
+
+// Get the limits of the organization with the id or identifier.
+func (r repository) Get(ctx context.Context, org string) (*Organization, error) {
+
+	ctx, span := telemetry.Repository(ctx, "limits", "Get")
+	defer span.End()
+
+	filter := bson.M{"identifier": org}
+	if orgId, err := primitive.ObjectIDFromHex(org); err == nil {
+		filter = bson.M{"$or": bson.A{bson.M{"_id": orgId}, bson.M{"identifier": org}}}
+	}
+	projection := bson.M{"identifier": 1, "limits": 1}
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+	var organization Organization
+	if err := result.Decode(&organization); err != nil {
+		return nil, err
+	}
+	return &organization, nil
+}
+
+// Update the limits of the organization.
+func (r repository) Update(ctx context.Context, org_id string, limits mongo_entity.OrganizationLimits) error {
+
+	ctx, span := telemetry.Repository(ctx, "limits", "Update")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return err
+	}
+	result, err := r.mongoColl.UpdateOne(ctx, bson.M{"_id": orgId}, bson.M{"$set": bson.M{"limits": limits}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Count the entities of the organization the quota applies to.
+func (r repository) Count(ctx context.Context, org_id string, quota Quota) (int64, error) {
+
+	ctx, span := telemetry.Repository(ctx, "limits", "Count")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return 0, err
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": orgId}}},
+		{{Key: "$project", Value: bson.M{"count": bson.M{"$size": bson.M{"$ifNull": bson.A{"$" + string(quota), bson.A{}}}}}}},
+	}
+	cursor, err := r.mongoColl.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+	if !cursor.Next(ctx) {
+		return 0, mongo.ErrNoDocuments
+	}
+	var result struct {
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.Decode(&result); err != nil {
+		return 0, err
+	}
+	return result.Count, nil
+}
+
+// IncrementChecks counts a check request of the month and returns the count.
+func (r repository) IncrementChecks(ctx context.Context, org_identifier string, month string) (int64, error) {
+
+	ctx, span := telemetry.Repository(ctx, "limits", "IncrementChecks")
+	defer span.End()
+
+	field := "usage.checks." + month
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{field: 1})
+	result := r.mongoColl.FindOneAndUpdate(ctx, bson.M{"identifier": org_identifier}, bson.M{"$inc": bson.M{field: 1}}, opts)
+	if err := result.Err(); err != nil {
+		return 0, err
+	}
+	var org mongo_entity.Organization
+	if err := result.Decode(&org); err != nil {
+		return 0, err
+	}
+	if org.Usage == nil {
+		return 0, nil
+	}
+	return org.Usage.Checks[month], nil
+}
@@ -0,0 +1,326 @@
+package limits
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// Quota is an entity quota of organizations, named after the entity field.
+type Quota string
+
+const (
+	QuotaUsers    Quota = "users"
+	QuotaRoles    Quota = "roles"
+	QuotaGroups   Quota = "groups"
+	QuotaPolicies Quota = "policies"
+)
+
+const (
+	// cacheTTL is how long the limits of an organization are cached, so rate
+	// limiting does not read the organization on every request.
+	cacheTTL = 30 * time.Second
+	// maxEntries bounds the cached organizations and the rate limiters, the
+	// least recently used are dropped first.
+	maxEntries = 10000
+	// maxUnknown bounds the cached unknown organizations, which are kept apart
+	// so that lookups of unknown organizations cannot evict known ones.
+	maxUnknown = 1000
+)
+
+// Quotas enforces the entity and check quotas of organizations.
+type Quotas interface {
+	CheckQuota(ctx context.Context, org_id string, quota Quota) error
+	RecordCheck(ctx context.Context, org_identifier string) error
+}
+
+// RateLimiter limits the request rate of organizations per authenticated
+// principal.
+type RateLimiter interface {
+	Allow(ctx context.Context, org string, principal string) error
+}
+
+type Service interface {
+	Quotas
+	RateLimiter
+	Get(ctx context.Context, org_id string) (Limits, error)
+	Update(ctx context.Context, org_id string, req UpdateLimitsRequest) (Limits, error)
+}
+
+// Limits are the limits set on the organization and the effective limits
+// after applying the defaults. Effective zero values are unlimited.
+type Limits struct {
+	Organization mongo_entity.OrganizationLimits `json:"organization"`
+	Effective    mongo_entity.OrganizationLimits `json:"effective"`
+}
+
+type UpdateLimitsRequest struct {
+	mongo_entity.OrganizationLimits
+}
+
+func (m UpdateLimitsRequest) Validate() error {
+	return validation.ValidateStruct(&m.OrganizationLimits,
+		validation.Field(&m.Burst, validation.Min(0)),
+	)
+}
+
+type entry struct {
+	org       *Organization
+	effective mongo_entity.OrganizationLimits
+	expires   time.Time
+}
+
+type service struct {
+	repo     Repository
+	defaults config.Limits
+	logger   *zap.Logger
+	now      func() time.Time
+
+	mu       sync.Mutex
+	cache    *lru[entry]
+	unknown  *lru[time.Time]
+	limiters *lru[*rate.Limiter]
+}
+
+// NewService creates the limits service with the default limits of
+// organizations.
+func NewService(repo Repository, defaults config.Limits, logger *zap.Logger) Service {
+
+	return &service{
+		repo:     repo,
+		defaults: defaults,
+		logger:   logger,
+		now:      time.Now,
+		cache:    newLRU[entry](maxEntries),
+		unknown:  newLRU[time.Time](maxUnknown),
+		limiters: newLRU[*rate.Limiter](maxEntries),
+	}
+}
+
+// Get the limits of the organization.
+func (s *service) Get(ctx context.Context, org_id string) (Limits, error) {
+
+	ctx, span := telemetry.Start(ctx, "limits.service.Get")
+	defer span.End()
+
+	org, err := s.repo.Get(ctx, org_id)
+	if err != nil {
+		s.logger.Debug("Organization not exists.", zap.String("organization_id", org_id))
+		return Limits{}, &util.NotFoundError{Path: "Organization " + org_id + " not exists."}
+	}
+	limits := Limits{Effective: s.effective(org.Limits)}
+	if org.Limits != nil {
+		limits.Organization = *org.Limits
+	}
+	return limits, nil
+}
+
+// Update the limits of the organization.
+func (s *service) Update(ctx context.Context, org_id string, req UpdateLimitsRequest) (Limits, error) {
+
+	ctx, span := telemetry.Start(ctx, "limits.service.Update")
+	defer span.End()
+
+	if err := req.Validate(); err != nil {
+		s.logger.Error("Error while validating limits update request.")
+		return Limits{}, util.NewValidationError("Invalid input for limits.", err)
+	}
+	if err := s.repo.Update(ctx, org_id, req.OrganizationLimits); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return Limits{}, &util.NotFoundError{Path: "Organization " + org_id + " not exists."}
+		}
+		s.logger.Error("Error while updating limits.", zap.String("organization_id", org_id))
+		return Limits{}, err
+	}
+
+	// Drop the cached limits of the organization under all its keys.
+	s.mu.Lock()
+	s.cache.RemoveFunc(func(key string, e entry) bool { return e.org.ID.Hex() == org_id })
+	s.mu.Unlock()
+	return s.Get(ctx, org_id)
+}
+
+// Allow takes a request from the rate limit of the organization and principal,
+// e.g. a validated API key. Principals must be authenticated for the
+// organization, as each one gets its own limiter. Unknown organizations are
+// limited with the defaults.
+func (s *service) Allow(ctx context.Context, org string, principal string) error {
+
+	ctx, span := telemetry.Start(ctx, "limits.service.Allow")
+	defer span.End()
+
+	e, err := s.lookup(ctx, org)
+	if err != nil {
+		s.logger.Warn("Error while getting organization limits.", zap.String("organization", org), zap.Error(err))
+		return nil
+	}
+	limit, burst := e.effective.RateLimit, e.effective.Burst
+	if limit <= 0 {
+		return nil
+	}
+	if e.org != nil {
+		org = e.org.ID.Hex()
+	}
+	key := org + "/" + principal
+
+	now := s.now()
+	s.mu.Lock()
+	limiter, ok := s.limiters.Get(key)
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(limit), burst)
+		s.limiters.Add(key, limiter)
+	} else if limiter.Limit() != rate.Limit(limit) || limiter.Burst() != burst {
+		limiter.SetLimitAt(now, rate.Limit(limit))
+		limiter.SetBurstAt(now, burst)
+	}
+	s.mu.Unlock()
+
+	if !limiter.AllowN(now, 1) {
+		return &util.RateLimitError{RetryAfter: time.Duration(math.Ceil(float64(time.Second) / limit))}
+	}
+	return nil
+}
+
+// CheckQuota returns a QuotaExceededError when the organization cannot have
+// more entities of the quota.
+func (s *service) CheckQuota(ctx context.Context, org_id string, quota Quota) error {
+
+	ctx, span := telemetry.Start(ctx, "limits.service.CheckQuota")
+	defer span.End()
+
+	e, err := s.lookup(ctx, org_id)
+	if err != nil || e.org == nil {
+		return nil
+	}
+	var max int
+	switch quota {
+	case QuotaUsers:
+		max = e.effective.MaxUsers
+	case QuotaRoles:
+		max = e.effective.MaxRoles
+	case QuotaGroups:
+		max = e.effective.MaxGroups
+	case QuotaPolicies:
+		max = e.effective.MaxPolicies
+	}
+	if max <= 0 {
+		return nil
+	}
+	count, err := s.repo.Count(ctx, e.org.ID.Hex(), quota)
+	if err != nil {
+		s.logger.Error("Error while counting organization entities.", zap.String("organization_id", org_id), zap.Error(err))
+		return err
+	}
+	if count >= int64(max) {
+		s.logger.Debug("Organization quota exceeded.", zap.String("organization_id", org_id), zap.String("quota", string(quota)))
+		return &util.QuotaExceededError{Path: string(quota), Limit: int64(max)}
+	}
+	return nil
+}
+
+// RecordCheck counts a check request of the organization and returns a
+// QuotaExceededError when the monthly check quota is used up. Checks are
+// only counted for organizations with a check quota.
+func (s *service) RecordCheck(ctx context.Context, org_identifier string) error {
+
+	ctx, span := telemetry.Start(ctx, "limits.service.RecordCheck")
+	defer span.End()
+
+	e, err := s.lookup(ctx, org_identifier)
+	if err != nil || e.org == nil {
+		return nil
+	}
+	max := e.effective.MaxChecksPerMonth
+	if max <= 0 {
+		return nil
+	}
+	count, err := s.repo.IncrementChecks(ctx, e.org.Identifier, s.now().UTC().Format("2006-01"))
+	if err != nil {
+		s.logger.Warn("Error while recording check usage.", zap.String("organization", org_identifier), zap.Error(err))
+		return nil
+	}
+	if count > max {
+		s.logger.Debug("Organization check quota exceeded.", zap.String("organization", org_identifier))
+		return &util.QuotaExceededError{Path: "check requests", Limit: max}
+	}
+	return nil
+}
+
+// lookup returns the cached limits of the organization with the id or
+// identifier. Unknown organizations have no organization and the default
+// limits, and are cached as unknown.
+func (s *service) lookup(ctx context.Context, org string) (entry, error) {
+
+	ctx, span := telemetry.Start(ctx, "limits.service.lookup")
+	defer span.End()
+
+	now := s.now()
+	s.mu.Lock()
+	e, ok := s.cache.Get(org)
+	expires, unknown := s.unknown.Get(org)
+	s.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e, nil
+	}
+	if unknown && now.Before(expires) {
+		return entry{effective: s.effective(nil), expires: expires}, nil
+	}
+
+	found, err := s.repo.Get(ctx, org)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return entry{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if found == nil {
+		s.unknown.Add(org, now.Add(cacheTTL))
+		return entry{effective: s.effective(nil), expires: now.Add(cacheTTL)}, nil
+	}
+	e = entry{org: found, effective: s.effective(found.Limits), expires: now.Add(cacheTTL)}
+	s.cache.Add(org, e)
+	return e, nil
+}
+
+// effective applies the defaults to the limits of an organization.
+func (s *service) effective(limits *mongo_entity.OrganizationLimits) mongo_entity.OrganizationLimits {
+
+	if limits == nil {
+		limits = &mongo_entity.OrganizationLimits{}
+	}
+	effective := mongo_entity.OrganizationLimits{
+		RateLimit:         pick(limits.RateLimit, s.defaults.RateLimit),
+		Burst:             pick(limits.Burst, s.defaults.Burst),
+		MaxUsers:          pick(limits.MaxUsers, s.defaults.MaxUsers),
+		MaxRoles:          pick(limits.MaxRoles, s.defaults.MaxRoles),
+		MaxGroups:         pick(limits.MaxGroups, s.defaults.MaxGroups),
+		MaxPolicies:       pick(limits.MaxPolicies, s.defaults.MaxPolicies),
+		MaxChecksPerMonth: pick(limits.MaxChecksPerMonth, s.defaults.MaxChecksPerMonth),
+	}
+	if effective.RateLimit > 0 && effective.Burst <= 0 {
+		effective.Burst = int(math.Max(1, math.Ceil(effective.RateLimit)))
+	}
+	return effective
+}
+
+// pick returns the organization value, or the default when it is zero.
+// Negative values are unlimited.
+func pick[T int | int64 | float64](value T, def T) T {
+
+	if value == 0 {
+		value = def
+	}
+	if value < 0 {
+		return 0
+	}
+	return value
+}
@@ -0,0 +1,204 @@
+package limits
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+func TestService(t *testing.T) {
+
+	acme := &Organization{ID: primitive.NewObjectID(), Identifier: "acme"}
+	globex := &Organization{ID: primitive.NewObjectID(), Identifier: "globex",
+		Limits: &mongo_entity.OrganizationLimits{RateLimit: 1, Burst: 1, MaxUsers: -1, MaxChecksPerMonth: 1}}
+	repo := &mockRepository{
+		orgs:   []*Organization{acme, globex},
+		counts: map[Quota]int64{QuotaUsers: 2, QuotaRoles: 1},
+		checks: map[string]int64{},
+	}
+	s := NewService(repo, config.Limits{RateLimit: 2, MaxUsers: 2, MaxRoles: 5}, zap.NewNop()).(*service)
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// the burst defaults to the rate and each API key has its own limiter
+	assert.Nil(t, s.Allow(ctx, "acme", "key"))
+	assert.Nil(t, s.Allow(ctx, acme.ID.Hex(), "key"))
+	err := s.Allow(ctx, "acme", "key")
+	assert.IsType(t, &util.RateLimitError{}, err)
+	assert.Equal(t, 500*time.Millisecond, err.(*util.RateLimitError).RetryAfter)
+	assert.Nil(t, s.Allow(ctx, "acme", "other"))
+	now = now.Add(time.Second)
+	assert.Nil(t, s.Allow(ctx, "acme", "key"))
+
+	// organizations override the defaults
+	assert.Nil(t, s.Allow(ctx, "globex", "key"))
+	assert.IsType(t, &util.RateLimitError{}, s.Allow(ctx, "globex", "key"))
+	assert.Nil(t, s.CheckQuota(ctx, globex.ID.Hex(), QuotaUsers))
+	assert.Equal(t, &util.QuotaExceededError{Path: "users", Limit: 2}, s.CheckQuota(ctx, acme.ID.Hex(), QuotaUsers))
+	assert.Nil(t, s.CheckQuota(ctx, acme.ID.Hex(), QuotaRoles))
+	assert.Nil(t, s.CheckQuota(ctx, acme.ID.Hex(), QuotaGroups))
+
+	// checks are only counted with a check quota
+	assert.Nil(t, s.RecordCheck(ctx, "acme"))
+	assert.Nil(t, s.RecordCheck(ctx, "globex"))
+	assert.IsType(t, &util.QuotaExceededError{}, s.RecordCheck(ctx, "globex"))
+	assert.Equal(t, map[string]int64{"globex/2026-10": 2}, repo.checks)
+	now = now.AddDate(0, 1, 0)
+	assert.Nil(t, s.RecordCheck(ctx, "globex"))
+
+	// updates replace the cached limits
+	limits, err := s.Update(ctx, acme.ID.Hex(), UpdateLimitsRequest{mongo_entity.OrganizationLimits{MaxUsers: 3, RateLimit: -1}})
+	assert.Nil(t, err)
+	assert.Equal(t, mongo_entity.OrganizationLimits{MaxUsers: 3, MaxRoles: 5}, limits.Effective)
+	assert.Nil(t, s.CheckQuota(ctx, acme.ID.Hex(), QuotaUsers))
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, s.Allow(ctx, "acme", "key"))
+	}
+	_, err = s.Update(ctx, acme.ID.Hex(), UpdateLimitsRequest{mongo_entity.OrganizationLimits{Burst: -1}})
+	assert.IsType(t, &util.InvalidInputError{}, err)
+	_, err = s.Get(ctx, primitive.NewObjectID().Hex())
+	assert.IsType(t, &util.NotFoundError{}, err)
+
+	// unknown organizations are rate limited with the defaults
+	assert.Nil(t, s.Allow(ctx, "unknown", ""))
+	assert.Nil(t, s.Allow(ctx, "unknown", ""))
+	assert.IsType(t, &util.RateLimitError{}, s.Allow(ctx, "unknown", ""))
+	assert.Nil(t, s.RecordCheck(ctx, "unknown"))
+}
+
+func TestLookupCache(t *testing.T) {
+
+	acme := &Organization{ID: primitive.NewObjectID(), Identifier: "acme"}
+	repo := &mockRepository{orgs: []*Organization{acme}}
+	s := NewService(repo, config.Limits{RateLimit: 100}, zap.NewNop()).(*service)
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// unknown organizations are only read once until the cache expires
+	assert.Nil(t, s.Allow(ctx, "unknown", "key"))
+	assert.Nil(t, s.Allow(ctx, "unknown", "key"))
+	assert.Equal(t, 1, repo.gets)
+	now = now.Add(cacheTTL)
+	assert.Nil(t, s.Allow(ctx, "unknown", "key"))
+	assert.Equal(t, 2, repo.gets)
+
+	// lookups of unknown organizations do not evict known ones
+	assert.Nil(t, s.Allow(ctx, "acme", "key"))
+	for i := 0; i < maxUnknown+1; i++ {
+		assert.Nil(t, s.RecordCheck(ctx, primitive.NewObjectID().Hex()))
+	}
+	gets := repo.gets
+	assert.Nil(t, s.Allow(ctx, "acme", "key"))
+	assert.Equal(t, gets, repo.gets)
+	assert.Equal(t, maxUnknown, s.unknown.Len())
+	assert.Equal(t, 1, s.cache.Len())
+}
+
+func TestLRU(t *testing.T) {
+
+	cache := newLRU[int](2)
+	cache.Add("a", 1)
+	cache.Add("b", 2)
+	_, ok := cache.Get("a")
+	assert.True(t, ok)
+	cache.Add("c", 3)
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+	value, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	cache.Add("a", 4)
+	value, _ = cache.Get("a")
+	assert.Equal(t, 4, value)
+	assert.Equal(t, 2, cache.Len())
+
+	cache.RemoveFunc(func(key string, value int) bool { return value > 3 })
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestRateLimit(t *testing.T) {
+
+	repo := &mockRepository{}
+	s := NewService(repo, config.Limits{RateLimit: 1}, zap.NewNop())
+	principal := ""
+	handler := RateLimit(s, func(c echo.Context) string { return principal })(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	serve := func(org string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		c.SetParamNames("org_id")
+		c.SetParamValues(org)
+		if err := handler(c); err != nil {
+			code, _ := util.NewErrorResponse(err)
+			rec.Code = code
+		}
+		return rec
+	}
+
+	// requests are limited per authenticated principal
+	principal = "jane"
+	assert.Equal(t, http.StatusOK, serve("acme").Code)
+	rec := serve("acme")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	principal = "joe"
+	assert.Equal(t, http.StatusOK, serve("acme").Code)
+
+	// unauthenticated requests do not get limiters
+	principal = ""
+	assert.Equal(t, http.StatusOK, serve("acme").Code)
+	assert.Equal(t, 2, s.(*service).limiters.Len())
+}
+
+type mockRepository struct {
+	gets   int
+	orgs   []*Organization
+	counts map[Quota]int64
+	checks map[string]int64
+}
+
+func (m *mockRepository) Get(ctx context.Context, org string) (*Organization, error) {
+	m.gets++
+	for _, o := range m.orgs {
+		if o.ID.Hex() == org || o.Identifier == org {
+			copied := *o
+			return &copied, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *mockRepository) Update(ctx context.Context, org_id string, limits mongo_entity.OrganizationLimits) error {
+	for _, o := range m.orgs {
+		if o.ID.Hex() == org_id {
+			o.Limits = &limits
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+func (m *mockRepository) Count(ctx context.Context, org_id string, quota Quota) (int64, error) {
+	return m.counts[quota], nil
+}
+
+func (m *mockRepository) IncrementChecks(ctx context.Context, org_identifier string, month string) (int64, error) {
+	m.checks[org_identifier+"/"+month]++
+	return m.checks[org_identifier+"/"+month], nil
+}
@@ -13,8 +13,11 @@ func Init(cfg *config.Config) (*zap.Logger, error) {
 	zap_config := zap.NewProductionEncoderConfig()
 	zap_config.EncodeTime = zapcore.ISO8601TimeEncoder
 	consoleEncoder := zapcore.NewConsoleEncoder(zap_config)
-	defaultLogLevel := zapcore.DebugLevel
-	core := zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), defaultLogLevel)
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(cfg.Log.Level)); err != nil {
+		return nil, err
+	}
+	core := zapcore.NewCore(consoleEncoder, zapcore.AddSync(os.Stdout), level)
 	if cfg.Log.Enabled {
 		fileEncoder := zapcore.NewJSONEncoder(zap_config)
 		logDir := "./log"
@@ -26,7 +29,7 @@ func Init(cfg *config.Config) (*zap.Logger, error) {
 		if err != nil {
 			return nil, err
 		}
-		fileCore := zapcore.NewCore(fileEncoder, zapcore.AddSync(logFile), defaultLogLevel)
+		fileCore := zapcore.NewCore(fileEncoder, zapcore.AddSync(logFile), level)
 		core = zapcore.NewTee(core, fileCore)
 	}
 	return zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel)), nil
@@ -1,139 +1,135 @@
 package middleware
 
 import (
-	"fmt"
+	"context"
 	"net/http"
-	"regexp"
+
 	"strings"
 
-	"github.com/MicahParks/keyfunc"
-	"github.com/golang-jwt/jwt"
-	jwtv4 "github.com/golang-jwt/jwt/v4"
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
+	"github.com/shashimalcse/cronuseo/internal/apikey"
 	"github.com/shashimalcse/cronuseo/internal/check"
 	"github.com/shashimalcse/cronuseo/internal/config"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/token"
 	"go.uber.org/zap"
 )
 
-type MethodPath struct {
-	Method   string
-	Path     string
-	Resource string
-}
-
-func Auth(cfg *config.Config, logger *zap.Logger, requiredPermissions map[MethodPath][]string, checkService check.Service) echo.MiddlewareFunc {
+// IdentityKey is the echo context key of the verified token identity.
+const IdentityKey = "identity"
 
-	jwks, err := keyfunc.Get(cfg.Auth.JWKS, keyfunc.Options{
-		RefreshErrorHandler: func(err error) {
-			logger.Error("There was an error with the jwt.KeyFunc", zap.Error(err))
-		},
-	})
-
-	if err != nil {
-		logger.Error("Failed to create JWKs from resource at the given URL.", zap.Error(err))
-	}
+// Auth verifies the admin JWT with the trusted issuers and checks the
+// permissions bound to the matched route. Routes without a permission mapping
+// are rejected.
+func Auth(cfg *config.Config, logger *zap.Logger, verifier *token.Verifier, permissions *Permissions, checkService check.Service) echo.MiddlewareFunc {
 
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			keyFunc := func(token *jwt.Token) (interface{}, error) {
-				t, _, err := new(jwtv4.Parser).ParseUnverified(token.Raw, jwtv4.MapClaims{})
-				if err != nil {
-					return nil, err
-				}
+			method, route := c.Request().Method, c.Path()
 
-				claims, ok := t.Claims.(jwtv4.MapClaims)
-				if !ok {
-					return nil, echo.NewHTTPError(http.StatusUnauthorized, "unexpected claims type")
-				}
+			// Unknown paths fall through to echo's 404 handler.
+			if permissions.isNotFound(method, route) {
+				return next(c)
+			}
 
-				//Extract and validate scopes
-				sub, ok := claims["sub"].(string)
-				if !ok {
-					return nil, echo.NewHTTPError(http.StatusUnauthorized, "invalid or missing sub claim")
-				}
+			raw, ok := bearerToken(c.Request().Header.Get(echo.HeaderAuthorization))
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing or malformed jwt")
+			}
+			identity, err := verifier.Verify(raw)
+			if err != nil {
+				logger.Debug("error while validating token", zap.Error(err))
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired jwt")
+			}
+			c.Set(IdentityKey, identity)
+			ctx := c.Request().Context()
 
-				methodPath := MethodPath{
-					Method: c.Request().Method,
-					Path:   c.Request().URL.Path,
-				}
-				pathMatched, err := regexp.MatchString("/api/v1/o/[^/]+/users/sync", methodPath.Path)
-				if err != nil {
-					return nil, err
+			if route == SyncRoute || route == SyncBatchRoute {
+				orgIdentifier := c.Param("org_id")
+				if identifier, err := checkService.GetOrgIdentifier(ctx, orgIdentifier); err == nil {
+					orgIdentifier = identifier
 				}
-				if pathMatched {
-					orgIdentifier := getOrgIdentifier(methodPath.Path)
-					logger.Debug("orgIdentifier", zap.String("orgIdentifier", orgIdentifier))
-					apiKey := c.Request().Header.Get("API_KEY")
-					validated, _ := checkService.ValidateAPIKey(nil, orgIdentifier, apiKey)
-					if !validated {
-						return nil, echo.NewHTTPError(http.StatusUnauthorized, "insufficient permissions to invoke this endpoint")
-					}
-				} else {
-					endpointPermissions, err := getPermissionsForMethodPath(methodPath, requiredPermissions)
-					if err != nil {
-						return nil, err
-					}
-
-					if !checkPermissions(sub, endpointPermissions, cfg, checkService) {
-						logger.Debug("error while validating permissions")
-						return nil, echo.NewHTTPError(http.StatusUnauthorized, "insufficient permissions to invoke this endpoint")
-					}
+				validated, _ := checkService.ValidateAPIKey(ctx, orgIdentifier, c.Request().Header.Get("API_KEY"), apikey.ScopeSync)
+				if !validated {
+					return echo.NewHTTPError(http.StatusUnauthorized, "insufficient permissions to invoke this endpoint")
 				}
-				key, keyErr := jwks.Keyfunc(t)
-				if keyErr != nil {
-					return nil, echo.NewHTTPError(http.StatusUnauthorized, "JWT key function error: %w", keyErr)
-				}
-
-				return key, nil
+				return next(c)
 			}
 
-			jwtMiddleware := middleware.JWTWithConfig(middleware.JWTConfig{
-				KeyFunc: keyFunc,
-				ErrorHandlerWithContext: func(err error, c echo.Context) error {
-					logger.Debug("error while validating token", zap.Error(err))
-					if httpErr, ok := err.(*echo.HTTPError); ok {
-						if internalErr, ok := httpErr.Internal.(*jwt.ValidationError); ok {
-							return internalErr.Inner
-						} else {
-							return httpErr
-						}
-					}
-					if validationErr, ok := err.(*jwt.ValidationError); ok {
-						return echo.NewHTTPError(http.StatusUnauthorized, validationErr.Inner.Error())
-					} else {
-						return validationErr
-					}
-				},
-			})
-			return jwtMiddleware(next)(c)
+			requiredPermissions, ok := permissions.Lookup(method, route)
+			if !ok {
+				logger.Warn("No permission mapping for route.", zap.String("method", method), zap.String("route", route))
+				return echo.NewHTTPError(http.StatusForbidden, "no permission mapping for this endpoint")
+			}
+			if !authorize(ctx, identity, c.Param("org_id"), requiredPermissions, cfg, checkService) {
+				logger.Debug("error while validating permissions", zap.String("sub", identity.Subject), zap.String("route", route))
+				return echo.NewHTTPError(http.StatusForbidden, "insufficient permissions to invoke this endpoint")
+			}
+			return next(c)
 		}
 	}
 }
 
-// getScopesForMethodPath returns the scopes for a given method and path based on wildcard patterns.
-func getPermissionsForMethodPath(methodPath MethodPath, requiredPermissions map[MethodPath][]string) ([]mongo_entity.Permission, error) {
-	for pattern, permissions := range requiredPermissions {
-		pathMatched, err := regexp.MatchString(pattern.Path, methodPath.Path)
-		if err != nil {
-			return nil, err
-		}
-		methodMatched := strings.EqualFold(pattern.Method, methodPath.Method) || pattern.Method == "*"
+// Principal returns the issuer and subject of the verified token of the
+// request, or "" when the request is not authenticated.
+func Principal(c echo.Context) string {
 
-		if pathMatched && methodMatched {
-			requiredPermissions := []mongo_entity.Permission{}
-			for _, permission := range permissions {
-				requiredPermissions = append(requiredPermissions, mongo_entity.Permission{Action: permission, Resource: pattern.Resource})
-			}
-			return requiredPermissions, nil
+	identity, ok := c.Get(IdentityKey).(token.Identity)
+	if !ok {
+		return ""
+	}
+	return identity.Issuer + " " + identity.Subject
+}
+
+// bearerToken returns the token of a bearer authorization header.
+func bearerToken(header string) (string, bool) {
+
+	const prefix = "Bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+// authorize checks the required permissions in the root organization, which
+// grants access to every organization, and for organization scoped routes in
+// the organization of the route and then in its ancestors, so that admins of
+// a parent organization manage its children. Identities of issuers mapped to
+// an organization are only checked in that organization. Organization claims
+// of external issuers never grant the root organization, it needs an explicit
+// issuer mapping.
+func authorize(ctx context.Context, identity token.Identity, orgId string, requiredPermissions []mongo_entity.Permission, cfg *config.Config, checkService check.Service) bool {
+
+	root := cfg.RootOrganization.Name
+	if identity.Organization == "" || (identity.Organization == root && !identity.Claimed) {
+		if checkPermissions(ctx, identity.Subject, root, requiredPermissions, checkService) {
+			return true
 		}
 	}
-	return nil, fmt.Errorf("no matching scopes found for method and path: %s %s", methodPath.Method, methodPath.Path)
+	if orgId == "" {
+		return false
+	}
+	orgIdentifier, err := checkService.GetOrgIdentifier(ctx, orgId)
+	if err != nil || orgIdentifier == root {
+		return false
+	}
+	ancestors, err := checkService.GetOrgAncestors(ctx, orgId)
+	if err != nil {
+		ancestors = []string{}
+	}
+	for _, identifier := range append([]string{orgIdentifier}, ancestors...) {
+		if identifier == root || (identity.Organization != "" && identity.Organization != identifier) {
+			continue
+		}
+		if checkPermissions(ctx, identity.Subject, identifier, requiredPermissions, checkService) {
+			return true
+		}
+	}
+	return false
 }
 
-// checkScopes validates the required scopes are present for a given endpoint.
-func checkPermissions(sub string, requiredPermissions []mongo_entity.Permission, cfg *config.Config, checkService check.Service) bool {
+// checkPermissions validates the required permissions are granted to the user in the organization.
+func checkPermissions(ctx context.Context, sub string, orgIdentifier string, requiredPermissions []mongo_entity.Permission, checkService check.Service) bool {
 
 	for _, permission := range requiredPermissions {
 		checkReq := check.CheckRequest{
@@ -141,7 +137,7 @@ func checkPermissions(sub string, requiredPermissions []mongo_entity.Permission,
 			Action:     permission.Action,
 			Resource:   permission.Resource,
 		}
-		allow, _ := checkService.Check(nil, cfg.RootOrganization.Name, checkReq, "nil", true)
+		allow, _ := checkService.Check(ctx, orgIdentifier, checkReq, "nil", true)
 		if !allow.Allowed {
 			return false
 		}
@@ -149,18 +145,6 @@ func checkPermissions(sub string, requiredPermissions []mongo_entity.Permission,
 	return true
 }
 
-func getOrgIdentifier(path string) string {
-
-	re := regexp.MustCompile(`/api/v1/o/([^/]+)/users/sync`)
-	matches := re.FindStringSubmatch(path)
-
-	if len(matches) > 1 {
-		org := matches[1]
-		return org
-	}
-	return ""
-}
-
 func MockAuthHeader() http.Header {
 	header := http.Header{}
 	header.Add("Authorization", "TEST")
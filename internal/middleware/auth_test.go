@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shashimalcse/cronuseo/internal/check"
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/token"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// mockCheckService grants permissions per organization identifier and user.
+type mockCheckService struct {
+	grants    map[string]map[string]bool
+	orgs      map[string]string
+	ancestors map[string][]string
+}
+
+func (m mockCheckService) Check(ctx context.Context, org_identifier string, req check.CheckRequest, apiKey string, skipValidation bool) (check.CheckResponse, error) {
+	return check.CheckResponse{Allowed: m.grants[org_identifier][req.Identifier+" "+req.Action]}, nil
+}
+
+func (m mockCheckService) CheckVerified(ctx context.Context, org_identifier string, req check.CheckRequest, apiKey string, claims map[string]interface{}) (check.CheckResponse, error) {
+	return m.Check(ctx, org_identifier, req, apiKey, false)
+}
+
+func (m mockCheckService) Evaluate(ctx context.Context, org_identifier string, req check.CheckRequest) (check.CheckResponse, error) {
+	return m.Check(ctx, org_identifier, req, "", true)
+}
+
+func (m mockCheckService) ValidateAPIKey(ctx context.Context, org_identifier string, apiKey string, scope string) (bool, error) {
+	return false, nil
+}
+
+func (m mockCheckService) GetOrgIdentifier(ctx context.Context, org_id string) (string, error) {
+	if identifier, ok := m.orgs[org_id]; ok {
+		return identifier, nil
+	}
+	return "", &util.NotFoundError{Path: "Organization"}
+}
+
+func (m mockCheckService) GetOrgAncestors(ctx context.Context, org_id string) ([]string, error) {
+	if _, ok := m.orgs[org_id]; !ok {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	return append([]string{}, m.ancestors[org_id]...), nil
+}
+
+func TestAuthorize(t *testing.T) {
+
+	cfg := &config.Config{}
+	cfg.RootOrganization.Name = "super"
+	checkService := mockCheckService{
+		grants: map[string]map[string]bool{
+			"super": {"root users:create": true},
+			"acme":  {"tenant users:create": true},
+		},
+		orgs:      map[string]string{"1": "acme", "2": "globex", "0": "super", "3": "acme-sales", "4": "acme-sales-emea"},
+		ancestors: map[string][]string{"3": {"acme"}, "4": {"acme-sales", "acme"}},
+	}
+	permissions := []mongo_entity.Permission{{Resource: "users", Action: "users:create"}}
+	ctx := context.Background()
+
+	root := token.Identity{Subject: "root"}
+	tenant := token.Identity{Subject: "tenant"}
+
+	// root admins manage every organization
+	assert.True(t, authorize(ctx, root, "2", permissions, cfg, checkService))
+	assert.True(t, authorize(ctx, root, "", permissions, cfg, checkService))
+
+	// tenant admins only manage their own organization
+	assert.True(t, authorize(ctx, tenant, "1", permissions, cfg, checkService))
+	assert.False(t, authorize(ctx, tenant, "2", permissions, cfg, checkService))
+	assert.False(t, authorize(ctx, tenant, "unknown", permissions, cfg, checkService))
+	assert.False(t, authorize(ctx, tenant, "", permissions, cfg, checkService))
+
+	// identities of organization mapped issuers stay in their organization
+	mappedRoot := token.Identity{Subject: "root", Organization: "acme"}
+	assert.False(t, authorize(ctx, mappedRoot, "2", permissions, cfg, checkService))
+	mappedTenant := token.Identity{Subject: "tenant", Organization: "acme"}
+	assert.True(t, authorize(ctx, mappedTenant, "1", permissions, cfg, checkService))
+	mappedTenant.Organization = "globex"
+	assert.False(t, authorize(ctx, mappedTenant, "1", permissions, cfg, checkService))
+
+	// organization claims of external issuers never grant the root
+	// organization, static issuer mappings do
+	claimedRoot := token.Identity{Subject: "root", Organization: "super", Claimed: true}
+	assert.False(t, authorize(ctx, claimedRoot, "2", permissions, cfg, checkService))
+	assert.False(t, authorize(ctx, claimedRoot, "", permissions, cfg, checkService))
+	claimedRoot.Claimed = false
+	assert.True(t, authorize(ctx, claimedRoot, "2", permissions, cfg, checkService))
+
+	// parent admins manage all descendants, but not their parent
+	assert.True(t, authorize(ctx, tenant, "3", permissions, cfg, checkService))
+	assert.True(t, authorize(ctx, tenant, "4", permissions, cfg, checkService))
+	mappedTenant.Organization = "acme"
+	assert.True(t, authorize(ctx, mappedTenant, "4", permissions, cfg, checkService))
+	mappedTenant.Organization = "acme-sales"
+	assert.False(t, authorize(ctx, mappedTenant, "4", permissions, cfg, checkService))
+	checkService.grants["acme-sales"] = map[string]bool{"manager users:create": true}
+	manager := token.Identity{Subject: "manager"}
+	assert.True(t, authorize(ctx, manager, "4", permissions, cfg, checkService))
+	assert.False(t, authorize(ctx, manager, "1", permissions, cfg, checkService))
+}
+
+// defaultAllowRepository is the check repository of an organization whose
+// default decision allows unknown subjects.
+type defaultAllowRepository struct {
+	check.Repository
+}
+
+func (defaultAllowRepository) GetOrgState(ctx context.Context, org_identifier string) (check.OrgState, error) {
+	return check.OrgState{Active: true, DefaultDecision: mongo_entity.DecisionAllow}, nil
+}
+
+func (defaultAllowRepository) GetCheckDetails(ctx context.Context, org_identifier string, identifier string) (check.CheckDetails, error) {
+	return check.CheckDetails{}, &util.NotFoundError{Path: "User"}
+}
+
+func TestCheckPermissionsDefaultDecision(t *testing.T) {
+
+	checkService := check.NewService(defaultAllowRepository{}, zap.NewNop(), nil, nil, nil)
+	permissions := []mongo_entity.Permission{{Resource: "users", Action: "users:create"}}
+
+	// the default decision of client checks never grants admin permissions
+	assert.False(t, checkPermissions(context.Background(), "unknown", "acme", permissions, checkService))
+}
+
+func TestBearerToken(t *testing.T) {
+
+	raw, ok := bearerToken("Bearer abc")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", raw)
+	raw, ok = bearerToken("bearer abc")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", raw)
+	_, ok = bearerToken("Basic abc")
+	assert.False(t, ok)
+	_, ok = bearerToken("Bearer ")
+	assert.False(t, ok)
+}
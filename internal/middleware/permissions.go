@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+)
+
+// SyncRoute and SyncBatchRoute are authenticated with the organization API key
+// instead of admin permissions.
+const (
+	SyncRoute      = "/api/v1/o/:org_id/users/sync"
+	SyncBatchRoute = "/api/v1/o/:org_id/users/sync/batch"
+)
+
+// notFoundHandlerName is the name of the catch all routes echo registers for
+// groups with middleware.
+var notFoundHandlerName = runtime.FuncForPC(reflect.ValueOf(echo.NotFoundHandler).Pointer()).Name()
+
+type permissionRule struct {
+	path        *regexp.Regexp
+	method      string
+	resource    string
+	permissions []string
+}
+
+// Permissions binds the required permissions of the configured endpoints to
+// the registered echo route templates. Endpoint paths are regular expressions
+// matched against the whole route template, e.g. "/api/v1/o/:org_id/users".
+type Permissions struct {
+	mu       sync.RWMutex
+	rules    []permissionRule
+	routes   map[string][]mongo_entity.Permission
+	notFound map[string]bool
+
+	// The routes of the last Bind, which reloaded rules are bound to.
+	prefix string
+	bound  []*echo.Route
+	public []*echo.Route
+}
+
+// NewPermissions compiles the endpoint rules of the configuration.
+func NewPermissions(endpoints []config.APIEndpoint) (*Permissions, error) {
+
+	p := &Permissions{routes: map[string][]mongo_entity.Permission{}, notFound: map[string]bool{}}
+	for _, endpoint := range endpoints {
+		path, err := regexp.Compile("^(?:" + endpoint.Path + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint path %q: %v", endpoint.Path, err)
+		}
+		for _, method := range endpoint.Methods {
+			p.rules = append(p.rules, permissionRule{
+				path:        path,
+				method:      strings.ToUpper(method.Method),
+				resource:    endpoint.Resource,
+				permissions: method.RequiredPermissions,
+			})
+		}
+	}
+	return p, nil
+}
+
+// Bind resolves the permissions of every route under prefix, except the public
+// routes registered before the auth middleware. It fails if a route has no
+// permission mapping or is matched by rules requiring different permissions.
+func (p *Permissions) Bind(prefix string, routes []*echo.Route, public []*echo.Route) error {
+
+	skip := map[string]bool{routeKey("POST", SyncRoute): true, routeKey("POST", SyncBatchRoute): true}
+	for _, route := range public {
+		skip[routeKey(route.Method, route.Path)] = true
+	}
+
+	bound := map[string][]mongo_entity.Permission{}
+	notFound := map[string]bool{}
+	problems := []string{}
+	for _, route := range routes {
+		key := routeKey(route.Method, route.Path)
+		if route.Name == notFoundHandlerName {
+			notFound[key] = true
+			continue
+		}
+		if !strings.HasPrefix(route.Path, prefix+"/") || skip[key] {
+			continue
+		}
+		rule, err := p.match(route.Method, route.Path)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		permissions := []mongo_entity.Permission{}
+		for _, permission := range rule.permissions {
+			permissions = append(permissions, mongo_entity.Permission{Resource: rule.resource, Action: permission})
+		}
+		bound[key] = permissions
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid endpoint permissions:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.routes, p.notFound = bound, notFound
+	p.prefix, p.bound, p.public = prefix, routes, public
+	return nil
+}
+
+// Reload replaces the rules with the endpoints and binds them to the routes of
+// the last Bind. The current permissions are kept when the endpoints are
+// invalid or do not cover every route.
+func (p *Permissions) Reload(endpoints []config.APIEndpoint) error {
+
+	next, err := NewPermissions(endpoints)
+	if err != nil {
+		return err
+	}
+	p.mu.RLock()
+	prefix, routes, public := p.prefix, p.bound, p.public
+	p.mu.RUnlock()
+	if err := next.Bind(prefix, routes, public); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules, p.routes = next.rules, next.routes
+	return nil
+}
+
+// match returns the rule of a route. Rules for the exact method take
+// precedence over "*" rules.
+func (p *Permissions) match(method string, path string) (permissionRule, error) {
+
+	var exact, wildcard []permissionRule
+	for _, rule := range p.rules {
+		if !rule.path.MatchString(path) {
+			continue
+		}
+		if rule.method == method {
+			exact = append(exact, rule)
+		} else if rule.method == "*" {
+			wildcard = append(wildcard, rule)
+		}
+	}
+	candidates := exact
+	if len(candidates) == 0 {
+		candidates = wildcard
+	}
+	if len(candidates) == 0 {
+		return permissionRule{}, fmt.Errorf("%s %s has no permission mapping", method, path)
+	}
+	for _, rule := range candidates[1:] {
+		if rule.resource != candidates[0].resource || !reflect.DeepEqual(rule.permissions, candidates[0].permissions) {
+			return permissionRule{}, fmt.Errorf("%s %s matches %q and %q with different permissions", method, path, candidates[0].path, rule.path)
+		}
+	}
+	return candidates[0], nil
+}
+
+// Lookup returns the required permissions of a bound route.
+func (p *Permissions) Lookup(method string, path string) ([]mongo_entity.Permission, bool) {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	permissions, ok := p.routes[routeKey(method, path)]
+	return permissions, ok
+}
+
+// isNotFound reports whether the route is a catch all route of echo.
+func (p *Permissions) isNotFound(method string, path string) bool {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.notFound[routeKey(method, path)]
+}
+
+func routeKey(method string, path string) string {
+
+	return strings.ToUpper(method) + " " + path
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func endpoint(path string, resource string, methods ...config.MethodDetail) config.APIEndpoint {
+	return config.APIEndpoint{Path: path, Resource: resource, Methods: methods}
+}
+
+func method(name string, permissions ...string) config.MethodDetail {
+	return config.MethodDetail{Method: name, RequiredPermissions: permissions}
+}
+
+func TestPermissions(t *testing.T) {
+
+	handler := func(c echo.Context) error { return nil }
+	routes := func() (*echo.Echo, []*echo.Route) {
+		e := echo.New()
+		apiV1 := e.Group("/api/v1")
+		apiV1.POST("/o/:org/check", handler)
+		public := e.Routes()
+		apiV1.Use(func(next echo.HandlerFunc) echo.HandlerFunc { return next })
+		apiV1.GET("/o/:org_id/users", handler)
+		apiV1.POST("/o/:org_id/users/sync", handler)
+		apiV1.POST("/o/:org_id/users/sync/batch", handler)
+		apiV1.GET("/o/:org_id/users/:id", handler)
+		apiV1.DELETE("/o/:org_id/users/:id", handler)
+		return e, public
+	}
+
+	// exact methods take precedence over "*"
+	permissions, err := NewPermissions([]config.APIEndpoint{
+		endpoint("/api/v1/o/[^/]+/users", "users", method("GET", "users:read_all")),
+		endpoint("/api/v1/o/[^/]+/users/[^/]+", "users", method("*", "users:update"), method("GET", "users:read")),
+	})
+	assert.Nil(t, err)
+	e, public := routes()
+	assert.Nil(t, permissions.Bind("/api/v1", e.Routes(), public))
+
+	required, ok := permissions.Lookup("GET", "/api/v1/o/:org_id/users/:id")
+	assert.True(t, ok)
+	assert.Equal(t, []mongo_entity.Permission{{Resource: "users", Action: "users:read"}}, required)
+	required, _ = permissions.Lookup("DELETE", "/api/v1/o/:org_id/users/:id")
+	assert.Equal(t, []mongo_entity.Permission{{Resource: "users", Action: "users:update"}}, required)
+	_, ok = permissions.Lookup("POST", "/api/v1/o/:org/check")
+	assert.False(t, ok)
+	assert.True(t, permissions.isNotFound("GET", "/api/v1/*"))
+
+	// patterns match whole route templates, so unmapped routes are reported
+	permissions, err = NewPermissions([]config.APIEndpoint{
+		endpoint("/api/v1/o/[^/]+/users", "users", method("GET", "users:read_all")),
+	})
+	assert.Nil(t, err)
+	e, public = routes()
+	err = permissions.Bind("/api/v1", e.Routes(), public)
+	assert.EqualError(t, err, "invalid endpoint permissions:\n"+
+		"  DELETE /api/v1/o/:org_id/users/:id has no permission mapping\n"+
+		"  GET /api/v1/o/:org_id/users/:id has no permission mapping")
+
+	// overlapping patterns must agree
+	permissions, err = NewPermissions([]config.APIEndpoint{
+		endpoint("/api/v1/o/[^/]+/users.*", "users", method("GET", "users:read_all"), method("DELETE", "users:delete")),
+		endpoint("/api/v1/o/[^/]+/users/[^/]+", "users", method("GET", "users:read")),
+	})
+	assert.Nil(t, err)
+	e, public = routes()
+	err = permissions.Bind("/api/v1", e.Routes(), public)
+	assert.Contains(t, err.Error(), "GET /api/v1/o/:org_id/users/:id matches")
+
+	_, err = NewPermissions([]config.APIEndpoint{endpoint("/api/v1/(", "users", method("GET", "users:read"))})
+	assert.NotNil(t, err)
+
+	// reloaded endpoints are bound to the same routes, and kept only when
+	// they cover every route
+	permissions, err = NewPermissions([]config.APIEndpoint{
+		endpoint("/api/v1/o/[^/]+/users(/[^/]+)?", "users", method("*", "users:read")),
+	})
+	assert.Nil(t, err)
+	e, public = routes()
+	assert.Nil(t, permissions.Bind("/api/v1", e.Routes(), public))
+	assert.Nil(t, permissions.Reload([]config.APIEndpoint{
+		endpoint("/api/v1/o/[^/]+/users(/[^/]+)?", "users", method("*", "users:admin")),
+	}))
+	required, _ = permissions.Lookup("DELETE", "/api/v1/o/:org_id/users/:id")
+	assert.Equal(t, []mongo_entity.Permission{{Resource: "users", Action: "users:admin"}}, required)
+	assert.True(t, permissions.isNotFound("GET", "/api/v1/*"))
+
+	assert.NotNil(t, permissions.Reload([]config.APIEndpoint{
+		endpoint("/api/v1/o/[^/]+/users", "users", method("GET", "users:read_all")),
+	}))
+	required, _ = permissions.Lookup("DELETE", "/api/v1/o/:org_id/users/:id")
+	assert.Equal(t, []mongo_entity.Permission{{Resource: "users", Action: "users:admin"}}, required)
+}
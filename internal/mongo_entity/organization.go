@@ -1,6 +1,10 @@
 package mongo_entity
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type ResourceType string
 
@@ -10,15 +14,94 @@ const (
 )
 
 type Organization struct {
-	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Identifier  string             `json:"identifier" bson:"identifier"`
-	DisplayName string             `json:"display_name" bson:"display_name"`
-	API_KEY     string             `json:"api_key" bson:"api_key"`
-	Resources   []Resource         `json:"resources,omitempty" bson:"resources"`
-	Users       []User             `json:"users,omitempty" bson:"users"`
-	Roles       []Role             `json:"roles,omitempty" bson:"roles"`
-	Groups      []Group            `json:"groups,omitempty" bson:"groups"`
-	Polices     []Policy           `json:"policies,omitempty" bson:"policies"`
+	ID              primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Identifier      string             `json:"identifier" bson:"identifier"`
+	DisplayName     string             `json:"display_name" bson:"display_name"`
+	API_KEY         string             `json:"api_key,omitempty" bson:"api_key,omitempty"`
+	APIKeys         []APIKey           `json:"-" bson:"api_keys,omitempty"`
+	SCIMToken       string             `json:"scim_token,omitempty" bson:"scim_token,omitempty"`
+	Resources       []Resource         `json:"resources,omitempty" bson:"resources"`
+	Users           []User             `json:"users,omitempty" bson:"users"`
+	Roles           []Role             `json:"roles,omitempty" bson:"roles"`
+	Groups          []Group            `json:"groups,omitempty" bson:"groups"`
+	Polices         []Policy           `json:"policies,omitempty" bson:"policies"`
+	ServiceAccounts []ServiceAccount   `json:"service_accounts,omitempty" bson:"service_accounts,omitempty"`
+
+	// The parent organization and all ancestors, nearest first, of child
+	// organizations. Children inherit the roles and resources of ancestors.
+	ParentID  *primitive.ObjectID  `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	Ancestors []primitive.ObjectID `json:"ancestors,omitempty" bson:"ancestors,omitempty"`
+
+	Settings  *OrganizationSettings `json:"settings,omitempty" bson:"settings,omitempty"`
+	Status    OrganizationStatus    `json:"status,omitempty" bson:"status,omitempty"`
+	DeletedAt *time.Time            `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+
+	Limits *OrganizationLimits `json:"limits,omitempty" bson:"limits,omitempty"`
+	Usage  *OrganizationUsage  `json:"-" bson:"usage,omitempty"`
+
+	AccessRequests      []AccessRequest      `json:"-" bson:"access_requests,omitempty"`
+	AccessRequestPolicy *AccessRequestPolicy `json:"-" bson:"access_request_policy,omitempty"`
+
+	SeparationOfDuties *SeparationOfDuties `json:"-" bson:"separation_of_duties,omitempty"`
+	UserPropertySchema *PropertySchema     `json:"-" bson:"user_property_schema,omitempty"`
+
+	ClaimMapping *ClaimMapping `json:"-" bson:"claim_mapping,omitempty"`
+
+	DirectorySync     *DirectorySync     `json:"-" bson:"directory_sync,omitempty"`
+	DirectorySyncRuns []DirectorySyncRun `json:"-" bson:"directory_sync_runs,omitempty"`
+}
+
+// OrganizationStatus is the lifecycle state of an organization. Organizations
+// without a status are active.
+type OrganizationStatus string
+
+const (
+	OrganizationActive    OrganizationStatus = "active"
+	OrganizationSuspended OrganizationStatus = "suspended"
+	OrganizationDeleted   OrganizationStatus = "deleted"
+)
+
+// Decisions of checks whose subject is unknown to the organization.
+const (
+	DecisionDeny  = "deny"
+	DecisionAllow = "allow"
+)
+
+// OrganizationSettings are the settings of an organization. DefaultDecision
+// is the decision of checks whose subject is unknown to the organization,
+// which fail with not found when it is empty. CORSOrigins are allowed to call
+// the routes of the organization in addition to the configured origins.
+type OrganizationSettings struct {
+	DefaultDecision string   `json:"default_decision,omitempty" bson:"default_decision,omitempty"`
+	CORSOrigins     []string `json:"cors_origins,omitempty" bson:"cors_origins,omitempty"`
+}
+
+// OrganizationLimits override the configured default limits. Zero values
+// inherit the default and negative values are unlimited.
+type OrganizationLimits struct {
+	RateLimit         float64 `json:"rate_limit,omitempty" bson:"rate_limit,omitempty"`
+	Burst             int     `json:"burst,omitempty" bson:"burst,omitempty"`
+	MaxUsers          int     `json:"max_users,omitempty" bson:"max_users,omitempty"`
+	MaxRoles          int     `json:"max_roles,omitempty" bson:"max_roles,omitempty"`
+	MaxGroups         int     `json:"max_groups,omitempty" bson:"max_groups,omitempty"`
+	MaxPolicies       int     `json:"max_policies,omitempty" bson:"max_policies,omitempty"`
+	MaxChecksPerMonth int64   `json:"max_checks_per_month,omitempty" bson:"max_checks_per_month,omitempty"`
+}
+
+// OrganizationUsage counts the check requests of each month, keyed by YYYY-MM.
+type OrganizationUsage struct {
+	Checks map[string]int64 `json:"checks,omitempty" bson:"checks,omitempty"`
+}
+
+type APIKey struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name       string             `json:"name" bson:"name"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	Hash       string             `json:"-" bson:"hash"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt  *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
 }
 
 type Resource struct {
@@ -43,6 +126,54 @@ type User struct {
 	Roles          []primitive.ObjectID   `json:"roles,omitempty" bson:"roles"`
 	Groups         []primitive.ObjectID   `json:"groups,omitempty" bson:"groups"`
 	Policies       []primitive.ObjectID   `json:"policies,omitempty" bson:"policies"`
+
+	RoleGrants  []Grant `json:"role_grants,omitempty" bson:"role_grants,omitempty"`
+	GroupGrants []Grant `json:"group_grants,omitempty" bson:"group_grants,omitempty"`
+}
+
+// Active reports whether the user is active. Users are deactivated by
+// setting the "active" property to false, for example by SCIM provisioning.
+func (u User) Active() bool {
+
+	active, ok := u.UserProperties["active"].(bool)
+	return !ok || active
+}
+
+// Grant is the validity window of a time-bound assignment, keyed by the id of
+// the assigned role or group. Assignments without a grant are permanent.
+type Grant struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	ValidFrom  *time.Time         `json:"valid_from,omitempty" bson:"valid_from,omitempty"`
+	ValidUntil *time.Time         `json:"valid_until,omitempty" bson:"valid_until,omitempty"`
+}
+
+// Active reports whether the grant is valid at the time.
+func (g Grant) Active(now time.Time) bool {
+
+	if g.ValidFrom != nil && now.Before(*g.ValidFrom) {
+		return false
+	}
+	return g.ValidUntil == nil || now.Before(*g.ValidUntil)
+}
+
+type ServiceAccount struct {
+	ID          primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Identifier  string               `json:"identifier" bson:"identifier"`
+	DisplayName string               `json:"display_name" bson:"display_name"`
+	ClientID    string               `json:"client_id" bson:"client_id"`
+	Secrets     []ClientSecret       `json:"secrets,omitempty" bson:"secrets"`
+	Roles       []primitive.ObjectID `json:"roles,omitempty" bson:"roles"`
+	Groups      []primitive.ObjectID `json:"groups,omitempty" bson:"groups"`
+	Policies    []primitive.ObjectID `json:"policies,omitempty" bson:"policies"`
+	CreatedAt   time.Time            `json:"created_at" bson:"created_at"`
+}
+
+type ClientSecret struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Prefix    string             `json:"prefix" bson:"prefix"`
+	Hash      string             `json:"-" bson:"hash"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt *time.Time         `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
 }
 
 type AssignedUser struct {
@@ -52,12 +183,13 @@ type AssignedUser struct {
 }
 
 type Role struct {
-	ID          primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
-	Identifier  string               `json:"identifier" bson:"identifier"`
-	DisplayName string               `json:"display_name" bson:"display_name"`
-	Users       []primitive.ObjectID `json:"users,omitempty" bson:"users"`
-	Groups      []primitive.ObjectID `json:"groups,omitempty" bson:"groups"`
-	Permissions []Permission         `json:"permissions,omitempty" bson:"permissions"`
+	ID              primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Identifier      string               `json:"identifier" bson:"identifier"`
+	DisplayName     string               `json:"display_name" bson:"display_name"`
+	Users           []primitive.ObjectID `json:"users,omitempty" bson:"users"`
+	Groups          []primitive.ObjectID `json:"groups,omitempty" bson:"groups"`
+	Permissions     []Permission         `json:"permissions,omitempty" bson:"permissions"`
+	ServiceAccounts []primitive.ObjectID `json:"service_accounts,omitempty" bson:"service_accounts,omitempty"`
 }
 
 type AssignedRole struct {
@@ -67,12 +199,15 @@ type AssignedRole struct {
 }
 
 type Group struct {
-	ID          primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
-	Identifier  string               `json:"identifier" bson:"identifier"`
-	DisplayName string               `json:"display_name" bson:"display_name"`
-	Users       []primitive.ObjectID `json:"users,omitempty" bson:"users"`
-	Roles       []primitive.ObjectID `json:"roles,omitempty" bson:"roles"`
-	Policies    []primitive.ObjectID `json:"policies,omitempty" bson:"policies"`
+	ID              primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Identifier      string               `json:"identifier" bson:"identifier"`
+	DisplayName     string               `json:"display_name" bson:"display_name"`
+	Users           []primitive.ObjectID `json:"users,omitempty" bson:"users"`
+	Roles           []primitive.ObjectID `json:"roles,omitempty" bson:"roles"`
+	Policies        []primitive.ObjectID `json:"policies,omitempty" bson:"policies"`
+	ServiceAccounts []primitive.ObjectID `json:"service_accounts,omitempty" bson:"service_accounts,omitempty"`
+
+	RoleGrants []Grant `json:"role_grants,omitempty" bson:"role_grants,omitempty"`
 }
 
 type AssignedGroup struct {
@@ -106,3 +241,185 @@ type PolicyContent struct {
 	Version string             `json:"version" bson:"version"`
 	Policy  string             `json:"policy" bson:"policy"`
 }
+
+type AccessRequestState string
+
+const (
+	AccessRequestPending   AccessRequestState = "pending"
+	AccessRequestApproved  AccessRequestState = "approved"
+	AccessRequestDenied    AccessRequestState = "denied"
+	AccessRequestCancelled AccessRequestState = "cancelled"
+	AccessRequestFailed    AccessRequestState = "failed"
+)
+
+// AccessRequest is a request of a user for temporary access to a role or a
+// group. Approved requests are granted until ExpiresAt.
+type AccessRequest struct {
+	ID                  primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Requester           primitive.ObjectID   `json:"requester" bson:"requester"`
+	RequesterIdentifier string               `json:"requester_identifier" bson:"requester_identifier"`
+	Role                *primitive.ObjectID  `json:"role,omitempty" bson:"role,omitempty"`
+	Group               *primitive.ObjectID  `json:"group,omitempty" bson:"group,omitempty"`
+	Reason              string               `json:"reason" bson:"reason"`
+	Duration            string               `json:"duration" bson:"duration"`
+	State               AccessRequestState   `json:"state" bson:"state"`
+	CreatedAt           time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt           time.Time            `json:"updated_at" bson:"updated_at"`
+	ExpiresAt           *time.Time           `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	History             []AccessRequestEvent `json:"history" bson:"history"`
+}
+
+// AccessRequestEvent is a state change of an access request, by the
+// identifier of the user who made it.
+type AccessRequestEvent struct {
+	State   AccessRequestState `json:"state" bson:"state"`
+	Actor   string             `json:"actor" bson:"actor"`
+	Comment string             `json:"comment,omitempty" bson:"comment,omitempty"`
+	Time    time.Time          `json:"time" bson:"time"`
+}
+
+// AccessRequestPolicy configures the access requests of an organization.
+// Users holding one of the approver roles review the requests, and requests
+// may last up to MaxDuration, e.g. "8h".
+type AccessRequestPolicy struct {
+	ApproverRoles []primitive.ObjectID `json:"approver_roles" bson:"approver_roles"`
+	MaxDuration   string               `json:"max_duration,omitempty" bson:"max_duration,omitempty"`
+}
+
+// SeparationOfDuties are the static separation of duties rules of an
+// organization. They apply to the roles users hold directly or through their
+// groups.
+type SeparationOfDuties struct {
+	ExclusiveRoles    []ExclusiveRoles  `json:"exclusive_roles" bson:"exclusive_roles"`
+	RoleCardinalities []RoleCardinality `json:"role_cardinalities" bson:"role_cardinalities"`
+}
+
+// ExclusiveRoles is a set of mutually exclusive roles. A user may hold at most
+// one of them.
+type ExclusiveRoles struct {
+	Name  string               `json:"name" bson:"name"`
+	Roles []primitive.ObjectID `json:"roles" bson:"roles"`
+}
+
+// RoleCardinality limits the number of users holding a role.
+type RoleCardinality struct {
+	Role     primitive.ObjectID `json:"role" bson:"role"`
+	MaxUsers int                `json:"max_users" bson:"max_users"`
+}
+
+// PropertySchema is a JSON Schema like schema of user properties. Types are
+// "object", "string", "number", "integer", "boolean" and "array", and the
+// schema of the user properties is an object schema.
+type PropertySchema struct {
+	Type                 string                     `json:"type" bson:"type"`
+	Properties           map[string]*PropertySchema `json:"properties,omitempty" bson:"properties,omitempty"`
+	Required             []string                   `json:"required,omitempty" bson:"required,omitempty"`
+	AdditionalProperties *bool                      `json:"additional_properties,omitempty" bson:"additional_properties,omitempty"`
+	Items                *PropertySchema            `json:"items,omitempty" bson:"items,omitempty"`
+	Enum                 []interface{}              `json:"enum,omitempty" bson:"enum,omitempty"`
+	Default              interface{}                `json:"default,omitempty" bson:"default,omitempty"`
+}
+
+// ClaimMapping maps the claims of check subjects to users for just-in-time
+// provisioning. Roles and groups are the values of the roles and groups
+// claims, and the ones of the rules matching the claims.
+type ClaimMapping struct {
+	Enabled       bool             `json:"enabled" bson:"enabled"`
+	SubjectClaim  string           `json:"subject_claim,omitempty" bson:"subject_claim,omitempty"`
+	UsernameClaim string           `json:"username_claim,omitempty" bson:"username_claim,omitempty"`
+	RolesClaim    string           `json:"roles_claim,omitempty" bson:"roles_claim,omitempty"`
+	GroupsClaim   string           `json:"groups_claim,omitempty" bson:"groups_claim,omitempty"`
+	Attributes    []ClaimAttribute `json:"attributes,omitempty" bson:"attributes,omitempty"`
+	Rules         []ClaimRule      `json:"rules,omitempty" bson:"rules,omitempty"`
+	Mode          string           `json:"mode,omitempty" bson:"mode,omitempty"`
+}
+
+// ClaimAttribute maps a claim to a user property.
+type ClaimAttribute struct {
+	Claim    string `json:"claim" bson:"claim"`
+	Property string `json:"property" bson:"property"`
+}
+
+// ClaimRule assigns roles and groups to subjects whose claim has the value, or
+// contains it when the claim is a list.
+type ClaimRule struct {
+	Claim  string   `json:"claim" bson:"claim"`
+	Value  string   `json:"value" bson:"value"`
+	Roles  []string `json:"roles,omitempty" bson:"roles,omitempty"`
+	Groups []string `json:"groups,omitempty" bson:"groups,omitempty"`
+}
+
+// DirectorySync configures the synchronization of the users and groups of an
+// LDAP directory. The scheduler runs it every Interval, e.g. "1h", and
+// DeleteMissing deletes the synced users and groups removed from the
+// directory. The bind password is never returned.
+type DirectorySync struct {
+	Enabled            bool                `json:"enabled" bson:"enabled"`
+	URL                string              `json:"url" bson:"url"`
+	StartTLS           bool                `json:"start_tls,omitempty" bson:"start_tls,omitempty"`
+	InsecureSkipVerify bool                `json:"insecure_skip_verify,omitempty" bson:"insecure_skip_verify,omitempty"`
+	BindDN             string              `json:"bind_dn,omitempty" bson:"bind_dn,omitempty"`
+	BindPassword       string              `json:"-" bson:"bind_password,omitempty"`
+	UserBaseDNs        []string            `json:"user_base_dns" bson:"user_base_dns"`
+	UserFilter         string              `json:"user_filter,omitempty" bson:"user_filter,omitempty"`
+	GroupBaseDNs       []string            `json:"group_base_dns,omitempty" bson:"group_base_dns,omitempty"`
+	GroupFilter        string              `json:"group_filter,omitempty" bson:"group_filter,omitempty"`
+	Attributes         DirectoryAttributes `json:"attributes" bson:"attributes"`
+	Interval           string              `json:"interval,omitempty" bson:"interval,omitempty"`
+	DeleteMissing      bool                `json:"delete_missing,omitempty" bson:"delete_missing,omitempty"`
+
+	// NextRunAt is when the scheduler runs the sync next, and RunningUntil
+	// locks it while it runs. Synced are the users and groups of the last
+	// sync, the ones DeleteMissing may delete.
+	NextRunAt    *time.Time         `json:"next_run_at,omitempty" bson:"next_run_at,omitempty"`
+	RunningUntil *time.Time         `json:"-" bson:"running_until,omitempty"`
+	Synced       DirectorySyncState `json:"-" bson:"synced"`
+}
+
+// DirectoryAttributes maps the LDAP attributes of entries to users and
+// groups. Group members are the DNs of the member attribute.
+type DirectoryAttributes struct {
+	UserIdentifier   string               `json:"user_identifier,omitempty" bson:"user_identifier,omitempty"`
+	Username         string               `json:"username,omitempty" bson:"username,omitempty"`
+	GroupIdentifier  string               `json:"group_identifier,omitempty" bson:"group_identifier,omitempty"`
+	GroupDisplayName string               `json:"group_display_name,omitempty" bson:"group_display_name,omitempty"`
+	Member           string               `json:"member,omitempty" bson:"member,omitempty"`
+	Properties       []DirectoryAttribute `json:"properties,omitempty" bson:"properties,omitempty"`
+}
+
+// DirectoryAttribute maps an LDAP attribute to a user property.
+type DirectoryAttribute struct {
+	Attribute string `json:"attribute" bson:"attribute"`
+	Property  string `json:"property" bson:"property"`
+}
+
+// DirectorySyncState holds the identifiers of the synced users and groups.
+type DirectorySyncState struct {
+	Users  []string `json:"users,omitempty" bson:"users,omitempty"`
+	Groups []string `json:"groups,omitempty" bson:"groups,omitempty"`
+}
+
+// DirectorySyncRun is a run of the directory sync in the sync history.
+type DirectorySyncRun struct {
+	ID         primitive.ObjectID   `json:"id" bson:"_id"`
+	Trigger    string               `json:"trigger" bson:"trigger"`
+	DryRun     bool                 `json:"dry_run" bson:"dry_run"`
+	Status     string               `json:"status" bson:"status"`
+	Error      string               `json:"error,omitempty" bson:"error,omitempty"`
+	StartedAt  time.Time            `json:"started_at" bson:"started_at"`
+	FinishedAt time.Time            `json:"finished_at" bson:"finished_at"`
+	Summary    DirectorySyncSummary `json:"summary" bson:"summary"`
+}
+
+// DirectorySyncSummary counts the changes of a directory sync run.
+type DirectorySyncSummary struct {
+	UsersCreated       int `json:"users_created" bson:"users_created"`
+	UsersUpdated       int `json:"users_updated" bson:"users_updated"`
+	UsersDeleted       int `json:"users_deleted" bson:"users_deleted"`
+	GroupsCreated      int `json:"groups_created" bson:"groups_created"`
+	GroupsUpdated      int `json:"groups_updated" bson:"groups_updated"`
+	GroupsDeleted      int `json:"groups_deleted" bson:"groups_deleted"`
+	MembershipsAdded   int `json:"memberships_added" bson:"memberships_added"`
+	MembershipsRemoved int `json:"memberships_removed" bson:"memberships_removed"`
+	Failed             int `json:"failed" bson:"failed"`
+}
@@ -2,6 +2,7 @@ package organization
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 	"github.com/shashimalcse/cronuseo/internal/util"
@@ -13,8 +14,20 @@ func RegisterHandlers(r *echo.Group, service Service) {
 	router.GET("", res.query)
 	router.GET("/:id", res.get)
 	router.POST("", res.create)
+	router.POST("/:id", res.update)
 	router.DELETE("/:id", res.delete)
+	router.POST("/:id/restore", res.restore)
+	router.POST("/:id/suspend", res.suspend)
+	router.POST("/:id/resume", res.resume)
 	router.POST("/:id/regenerate-key", res.regenerateAPIKey)
+	router.POST("/:id/regenerate-scim-token", res.regenerateSCIMToken)
+
+	children := r.Group("/o/:org_id/organizations")
+	children.GET("", res.children)
+	children.POST("", res.createChild)
+	children.GET("/tree", res.tree)
+	children.GET("/:id", res.getDescendant)
+	children.DELETE("/:id", res.deleteDescendant)
 }
 
 type resource struct {
@@ -37,15 +50,17 @@ func (r resource) get(c echo.Context) error {
 	return c.JSON(http.StatusOK, organization)
 }
 
-// @Description Get all organizations.
+// @Description Get all organizations, or only the deleted ones.
 // @Tags        Organization
+// @Param deleted query bool false "Only the deleted organizations"
 // @Produce     json
 // @Success     200 {array}  Organization
 // @failure     500
 // @Router      /organization [get]
 func (r resource) query(c echo.Context) error {
 
-	organizations, err := r.service.Query(c.Request().Context())
+	deleted, _ := strconv.ParseBool(c.QueryParam("deleted"))
+	organizations, err := r.service.Query(c.Request().Context(), deleted)
 	if err != nil {
 		return util.HandleError(err)
 	}
@@ -73,16 +88,88 @@ func (r resource) create(c echo.Context) error {
 	return c.JSON(http.StatusCreated, organization)
 }
 
-// @Description Delete organization.
+// @Description Update the display name and settings of the organization.
+// @Tags        Organization
+// @Accept      json
+// @Param id path string true "Organization ID"
+// @Param request body UpdateOrganizationRequest true "body"
+// @Produce     json
+// @Success     200 {object}  Organization
+// @failure     400,404,500
+// @Router      /organization/{id} [post]
+func (r resource) update(c echo.Context) error {
+
+	var req UpdateOrganizationRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	organization, err := r.service.Update(c.Request().Context(), c.Param("id"), req)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, organization)
+}
+
+// @Description Restore a deleted organization and the descendants deleted with it.
 // @Tags        Organization
 // @Param id path string true "Organization ID"
 // @Produce     json
+// @Success     200 {object}  Organization
+// @failure     404,409,500
+// @Router      /organization/{id}/restore [post]
+func (r resource) restore(c echo.Context) error {
+
+	organization, err := r.service.Restore(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, organization)
+}
+
+// @Description Suspend the organization. Its checks, and the checks of its descendants, are denied.
+// @Tags        Organization
+// @Param id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {object}  Organization
+// @failure     400,404,500
+// @Router      /organization/{id}/suspend [post]
+func (r resource) suspend(c echo.Context) error {
+
+	organization, err := r.service.Suspend(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, organization)
+}
+
+// @Description Resume a suspended organization.
+// @Tags        Organization
+// @Param id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {object}  Organization
+// @failure     400,404,500
+// @Router      /organization/{id}/resume [post]
+func (r resource) resume(c echo.Context) error {
+
+	organization, err := r.service.Resume(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, organization)
+}
+
+// @Description Delete organization until it is purged after the retention period. Organizations with children are only deleted with cascade.
+// @Tags        Organization
+// @Param id path string true "Organization ID"
+// @Param cascade query bool false "Delete all descendants too"
+// @Produce     json
 // @Success     204
-// @failure     404,500
+// @failure     404,409,500
 // @Router      /organization/{id} [delete]
 func (r resource) delete(c echo.Context) error {
 
-	_, err := r.service.Delete(c.Request().Context(), c.Param("id"))
+	cascade, _ := strconv.ParseBool(c.QueryParam("cascade"))
+	_, err := r.service.Delete(c.Request().Context(), c.Param("id"), cascade)
 	if err != nil {
 		return util.HandleError(err)
 	}
@@ -105,3 +192,113 @@ func (r resource) regenerateAPIKey(c echo.Context) error {
 	}
 	return c.JSON(http.StatusOK, organization)
 }
+
+// @Description Regenerate organization SCIM token.
+// @Tags        Organization
+// @Accept      json
+// @Param id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {object}  Organization
+// @failure     400,403,404,500
+// @Router      /organization/{id}/regenerate-scim-token [post]
+func (r resource) regenerateSCIMToken(c echo.Context) error {
+
+	organization, err := r.service.RegenerateSCIMToken(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, organization)
+}
+
+// @Description Get the child organizations of the organization.
+// @Tags        Organization
+// @Param org_id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {array}  Organization
+// @failure     404,500
+// @Router      /o/{org_id}/organizations [get]
+func (r resource) children(c echo.Context) error {
+
+	organizations, err := r.service.Children(c.Request().Context(), c.Param("org_id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, organizations)
+}
+
+// @Description Create a child organization of the organization.
+// @Tags        Organization
+// @Accept      json
+// @Param org_id path string true "Organization ID"
+// @Param request body OrganizationCreationRequest true "body"
+// @Produce     json
+// @Success     201 {object}  Organization
+// @failure     400,404,500
+// @Router      /o/{org_id}/organizations [post]
+func (r resource) createChild(c echo.Context) error {
+
+	var req OrganizationCreationRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	req.ParentID = c.Param("org_id")
+	organization, err := r.service.Create(c.Request().Context(), req)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusCreated, organization)
+}
+
+// @Description Get the organization and all of its descendants as a tree.
+// @Tags        Organization
+// @Param org_id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {object}  Tree
+// @failure     404,500
+// @Router      /o/{org_id}/organizations/tree [get]
+func (r resource) tree(c echo.Context) error {
+
+	tree, err := r.service.Tree(c.Request().Context(), c.Param("org_id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, tree)
+}
+
+// @Description Get a descendant organization of the organization.
+// @Tags        Organization
+// @Param org_id path string true "Organization ID"
+// @Param id path string true "Descendant organization ID"
+// @Produce     json
+// @Success     200 {object}  Organization
+// @failure     404,500
+// @Router      /o/{org_id}/organizations/{id} [get]
+func (r resource) getDescendant(c echo.Context) error {
+
+	organization, err := r.service.GetDescendant(c.Request().Context(), c.Param("org_id"), c.Param("id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, organization)
+}
+
+// @Description Delete a descendant organization of the organization until it is purged. Organizations with children are only deleted with cascade.
+// @Tags        Organization
+// @Param org_id path string true "Organization ID"
+// @Param id path string true "Descendant organization ID"
+// @Param cascade query bool false "Delete all descendants too"
+// @Success     204
+// @failure     404,409,500
+// @Router      /o/{org_id}/organizations/{id} [delete]
+func (r resource) deleteDescendant(c echo.Context) error {
+
+	ctx := c.Request().Context()
+	if _, err := r.service.GetDescendant(ctx, c.Param("org_id"), c.Param("id")); err != nil {
+		return util.HandleError(err)
+	}
+	cascade, _ := strconv.ParseBool(c.QueryParam("cascade"))
+	if _, err := r.service.Delete(ctx, c.Param("id"), cascade); err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusNoContent, "")
+}
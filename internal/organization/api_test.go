@@ -3,6 +3,7 @@ package organization
 import (
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/shashimalcse/cronuseo/internal/middleware"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
@@ -16,7 +17,7 @@ func TestAPI(t *testing.T) {
 	repo := &mockRepository{orgs: []mongo_entity.Organization{
 		{ID: primitive.NewObjectID(), Identifier: "test", DisplayName: "test"},
 	}}
-	RegisterHandlers(router.Group(""), NewService(repo, logger))
+	RegisterHandlers(router.Group(""), NewService(repo, time.Hour, TenantDefaults{}, &mockLimits{}, logger))
 	header := middleware.MockAuthHeader()
 
 	tests := []test.APITestCase{
@@ -0,0 +1,72 @@
+package organization
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// corsTTL is how long the CORS origins of an organization are cached.
+const corsTTL = 30 * time.Second
+
+type corsEntry struct {
+	origins []string
+	expires time.Time
+}
+
+// CORS allows cross-origin requests from the configured origins, and on
+// organization scoped routes from the CORS origins of the organization.
+func CORS(config middleware.CORSConfig, service Service) echo.MiddlewareFunc {
+
+	var mu sync.Mutex
+	cache := map[string]corsEntry{}
+	origins := func(c echo.Context, org string) []string {
+		now := time.Now()
+		mu.Lock()
+		e, ok := cache[org]
+		mu.Unlock()
+		if ok && now.Before(e.expires) {
+			return e.origins
+		}
+		settings, _ := service.Settings(c.Request().Context(), org)
+		e = corsEntry{origins: settings.CORSOrigins, expires: now.Add(corsTTL)}
+		mu.Lock()
+		if len(cache) >= 10000 {
+			cache = map[string]corsEntry{}
+		}
+		cache[org] = e
+		mu.Unlock()
+		return e.origins
+	}
+
+	defaults := middleware.CORSWithConfig(config)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		handler := defaults(next)
+		return func(c echo.Context) error {
+			origin := strings.TrimSuffix(c.Request().Header.Get(echo.HeaderOrigin), "/")
+			org := c.Param("org")
+			if org == "" {
+				org = c.Param("org_id")
+			}
+			if origin == "" || org == "" || allowed(config.AllowOrigins, origin) || !allowed(origins(c, org), origin) {
+				return handler(c)
+			}
+			orgConfig := config
+			orgConfig.AllowOrigins = []string{origin}
+			return middleware.CORSWithConfig(orgConfig)(next)(c)
+		}
+	}
+}
+
+func allowed(origins []string, origin string) bool {
+
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package organization
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/shashimalcse/cronuseo/internal/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	logger := test.InitLogger()
+	s := NewService(&mockRepository{}, time.Hour, TenantDefaults{}, &mockLimits{}, logger)
+	ctx := context.Background()
+	acme, _ := s.Create(ctx, OrganizationCreationRequest{Identifier: "acme", DisplayName: "acme"})
+	_, err := s.Update(ctx, acme.ID.Hex(), UpdateOrganizationRequest{Settings: &SettingsRequest{CORSOrigins: []string{"https://app.acme.com"}}})
+	assert.Nil(t, err)
+
+	e := echo.New()
+	e.Use(CORS(middleware.CORSConfig{AllowOrigins: []string{"https://console.example.com"}}, s))
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/api/v1/o/:org_id/users", ok)
+	e.GET("/api/v1/organizations", ok)
+
+	allowed := func(path string, origin string) string {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(echo.HeaderOrigin, origin)
+		res := httptest.NewRecorder()
+		e.ServeHTTP(res, req)
+		return res.Header().Get(echo.HeaderAccessControlAllowOrigin)
+	}
+
+	// organization origins are only allowed on routes of the organization
+	assert.Equal(t, "https://console.example.com", allowed("/api/v1/o/"+acme.ID.Hex()+"/users", "https://console.example.com"))
+	assert.Equal(t, "https://app.acme.com", allowed("/api/v1/o/"+acme.ID.Hex()+"/users", "https://app.acme.com"))
+	assert.Empty(t, allowed("/api/v1/organizations", "https://app.acme.com"))
+	assert.Empty(t, allowed("/api/v1/o/"+acme.ID.Hex()+"/users", "https://evil.example.com"))
+}
@@ -0,0 +1,92 @@
+package organization
+
+import (
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SystemResource is a system resource and its actions.
+type SystemResource struct {
+	Identifier string
+	Actions    []string
+}
+
+// TenantDefaults describes what is seeded into new organizations so that their
+// own admins can manage them. The root organization is seeded by the server at
+// startup instead.
+type TenantDefaults struct {
+	RootOrganization string
+	AdminRoleName    string
+	Resources        []SystemResource
+}
+
+// NewTenantDefaults builds the tenant defaults from the system resources of
+// the configuration. Tenant admins manage their child organizations with the
+// organizations resource, the organization list itself is only managed from
+// the root organization.
+func NewTenantDefaults(cfg *config.Config) TenantDefaults {
+
+	return TenantDefaults{
+		RootOrganization: cfg.RootOrganization.Name,
+		AdminRoleName:    cfg.RootOrganization.AdminRoleName,
+		Resources: []SystemResource{
+			{Identifier: "organizations", Actions: cfg.SystemResources.Organizations},
+			{Identifier: "users", Actions: cfg.SystemResources.Users},
+			{Identifier: "groups", Actions: cfg.SystemResources.Groups},
+			{Identifier: "roles", Actions: cfg.SystemResources.Roles},
+			{Identifier: "resources", Actions: cfg.SystemResources.Resources},
+			{Identifier: "policies", Actions: cfg.SystemResources.Polices},
+		},
+	}
+}
+
+// seed returns the system resources, the admin role granting all of their
+// actions and, when adminIdentifier is set, the admin user holding that role.
+func (d TenantDefaults) seed(adminIdentifier string) ([]mongo_entity.Resource, []mongo_entity.Role, []mongo_entity.User) {
+
+	resources := []mongo_entity.Resource{}
+	permissions := []mongo_entity.Permission{}
+	for _, systemResource := range d.Resources {
+		actions := []mongo_entity.Action{}
+		for _, action := range systemResource.Actions {
+			actions = append(actions, mongo_entity.Action{ID: primitive.NewObjectID(), Identifier: action, DisplayName: action})
+			permissions = append(permissions, mongo_entity.Permission{Resource: systemResource.Identifier, Action: action})
+		}
+		resources = append(resources, mongo_entity.Resource{
+			ID:          primitive.NewObjectID(),
+			Identifier:  systemResource.Identifier,
+			DisplayName: systemResource.Identifier,
+			Type:        mongo_entity.SystemResource,
+			Actions:     actions,
+		})
+	}
+
+	roleName := d.AdminRoleName
+	if roleName == "" {
+		roleName = "admin"
+	}
+	adminRole := mongo_entity.Role{
+		ID:          primitive.NewObjectID(),
+		Identifier:  roleName,
+		DisplayName: roleName,
+		Users:       []primitive.ObjectID{},
+		Groups:      []primitive.ObjectID{},
+		Permissions: permissions,
+	}
+	users := []mongo_entity.User{}
+	if adminIdentifier != "" {
+		admin := mongo_entity.User{
+			ID:             primitive.NewObjectID(),
+			Username:       adminIdentifier,
+			Identifier:     adminIdentifier,
+			UserProperties: map[string]interface{}{},
+			Roles:          []primitive.ObjectID{adminRole.ID},
+			Groups:         []primitive.ObjectID{},
+			Policies:       []primitive.ObjectID{},
+		}
+		adminRole.Users = append(adminRole.Users, admin.ID)
+		users = append(users, admin)
+	}
+	return resources, []mongo_entity.Role{adminRole}, users
+}
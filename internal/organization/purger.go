@@ -0,0 +1,49 @@
+package organization
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Purger permanently deletes the organizations deleted longer than the
+// retention period ago.
+type Purger struct {
+	repo      Repository
+	retention time.Duration
+	logger    *zap.Logger
+}
+
+func NewPurger(repo Repository, retention time.Duration, logger *zap.Logger) *Purger {
+
+	return &Purger{repo: repo, retention: retention, logger: logger}
+}
+
+// Run purges at the interval until the context is done.
+func (p *Purger) Run(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := p.Purge(ctx, time.Now().UTC())
+			if err != nil && ctx.Err() == nil {
+				p.logger.Error("Error while purging deleted organizations.", zap.Error(err))
+			}
+			if purged > 0 {
+				p.logger.Info("Purged deleted organizations.", zap.Int64("organizations", purged))
+			}
+		}
+	}
+}
+
+// Purge permanently deletes the organizations whose retention period ended
+// at the time, and returns how many were purged.
+func (p *Purger) Purge(ctx context.Context, now time.Time) (int64, error) {
+
+	return p.repo.Purge(ctx, now.Add(-p.retention))
+}
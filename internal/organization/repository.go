@@ -3,9 +3,11 @@ package organization
 import (
 	"context"
 	"fmt"
+	"time"
 
 	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -13,16 +15,29 @@ import (
 )
 
 type Repository interface {
-Get(ctx context.Context, id string) (*mongo_entity.Organization, error)
+	Get(ctx context.Context, id string) (*mongo_entity.Organization, error)
 	GetIdByIdentifier(ctx context.Context, identifier string) (string, error)
-	Query(ctx context.Context) ([]mongo_entity.Organization, error)
+	Query(ctx context.Context, deleted bool) ([]mongo_entity.Organization, error)
 	Create(ctx context.Context, organization mongo_entity.Organization) (string, error)
-	Delete(ctx context.Context, id string) error
-	RefreshAPIKey(ctx context.Context, apiKey string, id string) error
+	Update(ctx context.Context, id string, update UpdateOrganization) error
+	SetStatus(ctx context.Context, id string, status mongo_entity.OrganizationStatus) error
+	Delete(ctx context.Context, id string, cascade bool, deletedAt time.Time) error
+	Restore(ctx context.Context, id string, deletedAt time.Time) error
+	Purge(ctx context.Context, before time.Time) (int64, error)
+	QueryChildren(ctx context.Context, id string) ([]mongo_entity.Organization, error)
+	QueryDescendants(ctx context.Context, id string) ([]mongo_entity.Organization, error)
+	GetSettings(ctx context.Context, org string) (*mongo_entity.OrganizationSettings, error)
+	RefreshAPIKey(ctx context.Context, keys []mongo_entity.APIKey, id string) error
 	CheckOrgExistById(ctx context.Context, id string) (bool, error)
 	CheckOrgExistByIdentifier(ctx context.Context, identifier string) (bool, error)
 }
 
+// UpdateOrganization is an update of an organization, nil fields are kept.
+type UpdateOrganization struct {
+	DisplayName *string
+	Settings    *mongo_entity.OrganizationSettings
+}
+
 type repository struct {
 	mongoClient *mongo.Client
 	mongoColl   *mongo.Collection
@@ -38,6 +53,9 @@ func NewRepository(mongodb *db.MongoDB) Repository {
 // Get organization by id.
 func (r repository) Get(ctx context.Context, id string) (*mongo_entity.Organization, error) {
 
+	ctx, span := telemetry.Repository(ctx, "organization", "Get")
+	defer span.End()
+
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
@@ -46,7 +64,7 @@ func (r repository) Get(ctx context.Context, id string) (*mongo_entity.Organizat
 	filter := bson.M{"_id": objID}
 	projection := bson.M{"resources": 0, "users": 0, "roles": 0, "groups": 0, "policies": 0}
 	// Find the organization document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return nil, err
 	}
@@ -63,11 +81,14 @@ func (r repository) Get(ctx context.Context, id string) (*mongo_entity.Organizat
 // Get organization by id.
 func (r repository) GetIdByIdentifier(ctx context.Context, identifier string) (string, error) {
 
+	ctx, span := telemetry.Repository(ctx, "organization", "GetIdByIdentifier")
+	defer span.End()
+
 	// Define filter to find the organization by its ID
 	filter := bson.M{"identifier": identifier}
 	projection := bson.M{"resources": 0, "users": 0, "roles": 0, "groups": 0, "role_permissions": 0}
 	// Find the organization document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return "", err
 	}
@@ -83,7 +104,10 @@ func (r repository) GetIdByIdentifier(ctx context.Context, identifier string) (s
 // Create new organization.
 func (r repository) Create(ctx context.Context, organization mongo_entity.Organization) (string, error) {
 
-	result, err := r.mongoColl.InsertOne(context.Background(), organization)
+	ctx, span := telemetry.Repository(ctx, "organization", "Create")
+	defer span.End()
+
+	result, err := r.mongoColl.InsertOne(ctx, organization)
 	if err != nil {
 		return "", err
 	}
@@ -97,33 +121,189 @@ func (r repository) Create(ctx context.Context, organization mongo_entity.Organi
 	return orgID, nil
 }
 
-// Delete organization.
-func (r repository) Delete(ctx context.Context, id string) error {
+// Update organization.
+func (r repository) Update(ctx context.Context, id string, update UpdateOrganization) error {
+
+	ctx, span := telemetry.Repository(ctx, "organization", "Update")
+	defer span.End()
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return err
 	}
+	set := bson.M{}
+	if update.DisplayName != nil {
+		set["display_name"] = *update.DisplayName
+	}
+	if update.Settings != nil {
+		set["settings"] = update.Settings
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	result, err := r.mongoColl.UpdateOne(ctx, bson.M{"_id": objID, "status": bson.M{"$ne": mongo_entity.OrganizationDeleted}}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
 
-	// Define the filter to search for the organization by ID
-	filter := bson.M{"_id": objID}
+// Set the status of an organization that is not deleted.
+func (r repository) SetStatus(ctx context.Context, id string, status mongo_entity.OrganizationStatus) error {
+
+	ctx, span := telemetry.Repository(ctx, "organization", "SetStatus")
+	defer span.End()
 
-	// Delete the organization from the "organizations" collection
-	result, err := r.mongoColl.DeleteOne(context.Background(), filter)
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": objID, "status": bson.M{"$ne": mongo_entity.OrganizationDeleted}}
+	result, err := r.mongoColl.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": status}})
 	if err != nil {
 		return err
 	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Delete organization, and with cascade its descendants, until they are
+// restored or purged.
+func (r repository) Delete(ctx context.Context, id string, cascade bool, deletedAt time.Time) error {
 
-	// Check if the organization was deleted
-	if result.DeletedCount == 0 {
+	ctx, span := telemetry.Repository(ctx, "organization", "Delete")
+	defer span.End()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": objID}
+	if cascade {
+		filter = bson.M{"$or": bson.A{bson.M{"_id": objID}, bson.M{"ancestors": objID}}}
+	}
+	filter["status"] = bson.M{"$ne": mongo_entity.OrganizationDeleted}
+	update := bson.M{"$set": bson.M{"status": mongo_entity.OrganizationDeleted, "deleted_at": deletedAt}}
+	result, err := r.mongoColl.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
 		return fmt.Errorf("Organization with ID %s not found", id)
 	}
+	return nil
+}
 
+// Restore a deleted organization and the descendants deleted with it.
+func (r repository) Restore(ctx context.Context, id string, deletedAt time.Time) error {
+
+	ctx, span := telemetry.Repository(ctx, "organization", "Restore")
+	defer span.End()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{
+		"$or":        bson.A{bson.M{"_id": objID}, bson.M{"ancestors": objID}},
+		"status":     mongo_entity.OrganizationDeleted,
+		"deleted_at": deletedAt,
+	}
+	update := bson.M{"$unset": bson.M{"status": "", "deleted_at": ""}}
+	result, err := r.mongoColl.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
 	return nil
 }
 
+// Purge the organizations deleted before the time, and returns how many were
+// purged.
+func (r repository) Purge(ctx context.Context, before time.Time) (int64, error) {
+
+	ctx, span := telemetry.Repository(ctx, "organization", "Purge")
+	defer span.End()
+
+	filter := bson.M{"status": mongo_entity.OrganizationDeleted, "deleted_at": bson.M{"$lte": before}}
+	result, err := r.mongoColl.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// Get the settings of the organization with the id or identifier.
+func (r repository) GetSettings(ctx context.Context, org string) (*mongo_entity.OrganizationSettings, error) {
+
+	ctx, span := telemetry.Repository(ctx, "organization", "GetSettings")
+	defer span.End()
+
+	filter := bson.M{"identifier": org}
+	if objID, err := primitive.ObjectIDFromHex(org); err == nil {
+		filter = bson.M{"$or": bson.A{bson.M{"_id": objID}, bson.M{"identifier": org}}}
+	}
+	var found mongo_entity.Organization
+	err := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"settings": 1})).Decode(&found)
+	if err != nil {
+		return nil, err
+	}
+	return found.Settings, nil
+}
+
+// Query the direct children of the organization.
+func (r repository) QueryChildren(ctx context.Context, id string) ([]mongo_entity.Organization, error) {
+
+	ctx, span := telemetry.Repository(ctx, "organization", "QueryChildren")
+	defer span.End()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return r.find(ctx, bson.M{"parent_id": objID, "status": bson.M{"$ne": mongo_entity.OrganizationDeleted}})
+}
+
+// Query all descendants of the organization.
+func (r repository) QueryDescendants(ctx context.Context, id string) ([]mongo_entity.Organization, error) {
+
+	ctx, span := telemetry.Repository(ctx, "organization", "QueryDescendants")
+	defer span.End()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return r.find(ctx, bson.M{"ancestors": objID, "status": bson.M{"$ne": mongo_entity.OrganizationDeleted}})
+}
+
+// find returns the organizations matching the filter without their entities.
+func (r repository) find(ctx context.Context, filter bson.M) ([]mongo_entity.Organization, error) {
+
+	projection := bson.M{"resources": 0, "users": 0, "roles": 0, "groups": 0, "policies": 0}
+	cursor, err := r.mongoColl.Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		return nil, err
+	}
+	orgs := []mongo_entity.Organization{}
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
 // Refresh API key in mongo.
-func (r repository) RefreshAPIKey(ctx context.Context, apiKey string, id string) error {
+func (r repository) RefreshAPIKey(ctx context.Context, keys []mongo_entity.APIKey, id string) error {
+
+	ctx, span := telemetry.Repository(ctx, "organization", "RefreshAPIKey")
+	defer span.End()
 
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -133,14 +313,14 @@ func (r repository) RefreshAPIKey(ctx context.Context, apiKey string, id string)
 	// Define filter to find the organization by its ID
 	filter := bson.M{"_id": objID}
 
-	// Define update to set the new name
-	update := bson.M{"$set": bson.M{"api_key": apiKey}}
+	// Define update to set the new keys
+	update := bson.M{"$set": bson.M{"api_keys": keys}, "$unset": bson.M{"api_key": ""}}
 
 	// Define options for update operation
 	options := options.Update().SetUpsert(false)
 
 	// Update the organization document in the "organizations" collection
-	result, err := r.mongoColl.UpdateOne(context.Background(), filter, update, options)
+	result, err := r.mongoColl.UpdateOne(ctx, filter, update, options)
 	if err != nil {
 		return err
 	}
@@ -152,8 +332,11 @@ func (r repository) RefreshAPIKey(ctx context.Context, apiKey string, id string)
 	return nil
 }
 
-// Query organizations.
-func (r repository) Query(ctx context.Context) ([]mongo_entity.Organization, error) {
+// Query organizations, or only the deleted ones.
+func (r repository) Query(ctx context.Context, deleted bool) ([]mongo_entity.Organization, error) {
+
+	ctx, span := telemetry.Repository(ctx, "organization", "Query")
+	defer span.End()
 
 	// Define an empty slice to store the organizations
 	var orgs []mongo_entity.Organization
@@ -161,14 +344,18 @@ func (r repository) Query(ctx context.Context) ([]mongo_entity.Organization, err
 	projection := bson.M{"resources": 0, "users": 0, "roles": 0, "groups": 0, "role_permissions": 0}
 
 	// Search for all organizations in the "organizations" collection
-	cursor, err := r.mongoColl.Find(context.Background(), bson.M{}, options.Find().SetProjection(projection))
+	filter := bson.M{"status": bson.M{"$ne": mongo_entity.OrganizationDeleted}}
+	if deleted {
+		filter = bson.M{"status": mongo_entity.OrganizationDeleted}
+	}
+	cursor, err := r.mongoColl.Find(ctx, filter, options.Find().SetProjection(projection))
 	if err != nil {
 		return orgs, err
 	}
-	defer cursor.Close(context.Background())
+	defer cursor.Close(ctx)
 
 	// Iterate over the results and add each organization to the slice
-	for cursor.Next(context.Background()) {
+	for cursor.Next(ctx) {
 		var org mongo_entity.Organization
 		if err := cursor.Decode(&org); err != nil {
 			return orgs, err
@@ -187,6 +374,9 @@ func (r repository) Query(ctx context.Context) ([]mongo_entity.Organization, err
 // Check if organization exists by id.
 func (r repository) CheckOrgExistById(ctx context.Context, id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "organization", "CheckOrgExistById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return false, err
@@ -194,7 +384,7 @@ func (r repository) CheckOrgExistById(ctx context.Context, id string) (bool, err
 	filter := bson.M{"_id": orgId}
 
 	// Search for the organization in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the organization was found
 	if result.Err() == nil {
@@ -209,10 +399,13 @@ func (r repository) CheckOrgExistById(ctx context.Context, id string) (bool, err
 // Check if organization exists by identifier.
 func (r repository) CheckOrgExistByIdentifier(ctx context.Context, identifier string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "organization", "CheckOrgExistByIdentifier")
+	defer span.End()
+
 	filter := bson.M{"identifier": identifier}
 
 	// Search for the organization in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the organization was found
 	if result.Err() == nil {
@@ -2,10 +2,10 @@ package organization
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
 	"testing"
 
+	"github.com/shashimalcse/cronuseo/internal/apikey"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
 	"github.com/shashimalcse/cronuseo/internal/test"
 	"github.com/stretchr/testify/assert"
 )
@@ -23,7 +23,7 @@ func TestRepository(t *testing.T) {
 	assert.Equal(t, true, bool)
 
 	// Get all organizations.
-	orgs, err := repo.Query(ctx)
+	orgs, err := repo.Query(ctx, false)
 	assert.Nil(t, err)
 	assert.Equal(t, 1, len(orgs))
 
@@ -31,13 +31,10 @@ func TestRepository(t *testing.T) {
 	defaultOrg := orgs[0]
 
 	// Generate new API key.
-	key := make([]byte, 32)
-	_, err = rand.Read(key)
+	_, key, err := apikey.Generate(apikey.DefaultName, []string{apikey.ScopeAdmin}, nil)
 	assert.Nil(t, err)
 
-	newAPIKey := base64.StdEncoding.EncodeToString(key)
-
 	// Refresh API key.
-	err = repo.RefreshAPIKey(ctx, newAPIKey, defaultOrg.ID.Hex())
+	err = repo.RefreshAPIKey(ctx, []mongo_entity.APIKey{key}, defaultOrg.ID.Hex())
 	assert.Nil(t, err)
 }
@@ -2,11 +2,17 @@ package organization
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/shashimalcse/cronuseo/internal/apikey"
+	"github.com/shashimalcse/cronuseo/internal/limits"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/orgtree"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
 	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
@@ -15,26 +21,42 @@ import (
 type Service interface {
 	Get(ctx context.Context, id string) (Organization, error)
 	GetIdByIdentifier(ctx context.Context, identifier string) (string, error)
-	Query(ctx context.Context) ([]Organization, error)
+	Query(ctx context.Context, deleted bool) ([]Organization, error)
 	Create(ctx context.Context, req OrganizationCreationRequest) (Organization, error)
+	Update(ctx context.Context, id string, req UpdateOrganizationRequest) (Organization, error)
+	Suspend(ctx context.Context, id string) (Organization, error)
+	Resume(ctx context.Context, id string) (Organization, error)
+	Restore(ctx context.Context, id string) (Organization, error)
+	Settings(ctx context.Context, org string) (mongo_entity.OrganizationSettings, error)
 	RegenerateAPIKey(ctx context.Context, id string) (Organization, error)
-	Delete(ctx context.Context, id string) (Organization, error)
+	RegenerateSCIMToken(ctx context.Context, id string) (Organization, error)
+	Delete(ctx context.Context, id string, cascade bool) (Organization, error)
 	CheckOrgExistByIdentifier(ctx context.Context, identifier string) (bool, error)
+	Children(ctx context.Context, id string) ([]Organization, error)
+	Tree(ctx context.Context, id string) (Tree, error)
+	GetDescendant(ctx context.Context, ancestor_id string, id string) (Organization, error)
 }
 
 type Organization struct {
 	mongo_entity.Organization
 }
 
+// Tree is an organization and its descendants.
+type Tree struct {
+	Organization
+	Children []Tree `json:"children"`
+}
+
 type OrganizationCreationRequest struct {
-	Identifier  string `json:"identifier" bson:"identifier"`
-	DisplayName string `json:"display_name" bson:"display_name"`
-	API_KEY     string
-	Resources   []mongo_entity.Resource
-	Users       []mongo_entity.User
-	Roles       []mongo_entity.Role
-	Groups      []mongo_entity.Group
-	Policies    []mongo_entity.Policy
+	Identifier      string `json:"identifier" bson:"identifier"`
+	DisplayName     string `json:"display_name" bson:"display_name"`
+	AdminIdentifier string `json:"admin_identifier" bson:"-"`
+	ParentID        string `json:"parent_id" bson:"-"`
+	Resources       []mongo_entity.Resource
+	Users           []mongo_entity.User
+	Roles           []mongo_entity.Role
+	Groups          []mongo_entity.Group
+	Policies        []mongo_entity.Policy
 }
 
 func (m OrganizationCreationRequest) Validate() error {
@@ -44,18 +66,84 @@ func (m OrganizationCreationRequest) Validate() error {
 	)
 }
 
+// UpdateOrganizationRequest updates the display name and settings of an
+// organization. Omitted fields are kept.
+type UpdateOrganizationRequest struct {
+	DisplayName *string          `json:"display_name,omitempty"`
+	Settings    *SettingsRequest `json:"settings,omitempty"`
+}
+
+// SettingsRequest updates the settings of an organization, nil fields are
+// kept and an empty list clears the CORS origins. Quotas replace the limits of
+// the organization, see limits.UpdateLimitsRequest.
+type SettingsRequest struct {
+	DefaultDecision *string                          `json:"default_decision,omitempty"`
+	CORSOrigins     []string                         `json:"cors_origins,omitempty"`
+	Quotas          *mongo_entity.OrganizationLimits `json:"quotas,omitempty"`
+}
+
+func (m UpdateOrganizationRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.DisplayName, validation.NilOrNotEmpty),
+		validation.Field(&m.Settings),
+	)
+}
+
+func (m SettingsRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.DefaultDecision, validation.In(mongo_entity.DecisionDeny, mongo_entity.DecisionAllow)),
+		validation.Field(&m.CORSOrigins, validation.Each(validation.By(origin))),
+		validation.Field(&m.Quotas, validation.By(func(interface{}) error {
+			if m.Quotas == nil {
+				return nil
+			}
+			return limits.UpdateLimitsRequest{OrganizationLimits: *m.Quotas}.Validate()
+		})),
+	)
+}
+
+// origin validates a CORS origin, a scheme and host without a path.
+func origin(value interface{}) error {
+
+	raw, _ := value.(string)
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return validation.NewError("validation_invalid_origin", "must be an http or https origin such as https://app.example.com")
+	}
+	return nil
+}
+
+// Limits updates the quotas of organizations.
+type Limits interface {
+	Update(ctx context.Context, org_id string, req limits.UpdateLimitsRequest) (limits.Limits, error)
+}
+
 type service struct {
-	repo   Repository
-	logger *zap.Logger
+	repo        Repository
+	gracePeriod time.Duration
+	defaults    TenantDefaults
+	limits      Limits
+	logger      *zap.Logger
 }
 
-func NewService(repo Repository, logger *zap.Logger) Service {
-	return service{repo: repo, logger: logger}
+// NewService creates the organization service. gracePeriod is how long the
+// previous API key stays valid after it is regenerated, and defaults are
+// seeded into every new organization except the root organization.
+func NewService(repo Repository, gracePeriod time.Duration, defaults TenantDefaults, limits Limits, logger *zap.Logger) Service {
+
+	if gracePeriod <= 0 {
+		gracePeriod = apikey.DefaultGracePeriod
+	}
+	return service{repo: repo, gracePeriod: gracePeriod, defaults: defaults, limits: limits, logger: logger}
 }
 
 // Get organization by id.
 func (s service) Get(ctx context.Context, id string) (Organization, error) {
 
+	ctx, span := telemetry.Start(ctx, "organization.service.Get")
+	defer span.End()
+
 	org, err := s.repo.Get(ctx, id)
 	if err != nil {
 		return Organization{}, &util.NotFoundError{Path: "Organization"}
@@ -66,6 +154,9 @@ func (s service) Get(ctx context.Context, id string) (Organization, error) {
 // Get organization id by identifier.
 func (s service) GetIdByIdentifier(ctx context.Context, id string) (string, error) {
 
+	ctx, span := telemetry.Start(ctx, "organization.service.GetIdByIdentifier")
+	defer span.End()
+
 	orgId, err := s.repo.GetIdByIdentifier(ctx, id)
 	if err != nil {
 		return "", &util.NotFoundError{Path: "Organization"}
@@ -76,9 +167,12 @@ func (s service) GetIdByIdentifier(ctx context.Context, id string) (string, erro
 // Create new organization.
 func (s service) Create(ctx context.Context, req OrganizationCreationRequest) (Organization, error) {
 
+	ctx, span := telemetry.Start(ctx, "organization.service.Create")
+	defer span.End()
+
 	// Validate organization
 	if err := req.Validate(); err != nil {
-		return Organization{}, &util.InvalidInputError{Path: "Invalid input for organization."}
+		return Organization{}, util.NewValidationError("Invalid input for organization.", err)
 
 	}
 
@@ -98,7 +192,7 @@ func (s service) Create(ctx context.Context, req OrganizationCreationRequest) (O
 	}
 
 	var users []mongo_entity.User
-	if req.Groups == nil {
+	if req.Users == nil {
 		users = []mongo_entity.User{}
 	} else {
 		users = req.Users
@@ -125,76 +219,296 @@ func (s service) Create(ctx context.Context, req OrganizationCreationRequest) (O
 		resources = req.Resources
 	}
 
-	// Generate API-Key for organization.
-	key := make([]byte, 32)
+	// Children inherit the roles and resources of their ancestors.
+	var parentID *primitive.ObjectID
+	var ancestors []primitive.ObjectID
+	if req.ParentID != "" {
+		parent, err := s.repo.Get(ctx, req.ParentID)
+		if err != nil || parent.Status == mongo_entity.OrganizationDeleted {
+			return Organization{}, &util.NotFoundError{Path: "Parent organization " + req.ParentID + " not exists."}
+		}
+		if parent.Identifier == s.defaults.RootOrganization {
+			return Organization{}, &util.InvalidInputError{Message: "The root organization cannot have child organizations."}
+		}
+		parentID = &parent.ID
+		ancestors = orgtree.Ancestors(*parent)
+	}
 
-	if _, err := rand.Read(key); err != nil {
-		return Organization{}, err
+	// Seed the system resources and the admin role so that the organization
+	// can be managed by its own admins.
+	if req.Identifier != s.defaults.RootOrganization && len(s.defaults.Resources) > 0 {
+		systemResources, adminRoles, admins := s.defaults.seed(req.AdminIdentifier)
+		resources = append(systemResources, resources...)
+		roles = append(adminRoles, roles...)
+		users = append(admins, users...)
+	}
 
+	// Generate the default API key of the organization.
+	APIKey, key, err := apikey.Generate(apikey.DefaultName, []string{apikey.ScopeAdmin}, nil)
+	if err != nil {
+		return Organization{}, err
 	}
-	APIKey := base64.StdEncoding.EncodeToString(key)
 
 	id, err := s.repo.Create(ctx, mongo_entity.Organization{
 		Identifier:  req.Identifier,
 		DisplayName: req.DisplayName,
-		API_KEY:     APIKey,
+		APIKeys:     []mongo_entity.APIKey{key},
 		Users:       users,
 		Groups:      groups,
 		Roles:       roles,
 		Resources:   resources,
 		Polices:     policies,
+		ParentID:    parentID,
+		Ancestors:   ancestors,
 	})
 	if err != nil {
 		s.logger.Error("Error while creating organization.")
 		return Organization{}, err
 	}
-	return s.Get(ctx, id)
+	organization, err := s.Get(ctx, id)
+	if err != nil {
+		return Organization{}, err
+	}
+	// The plaintext key is only returned once.
+	organization.API_KEY = APIKey
+	return organization, nil
 }
 
-// Delete organization by id.
-func (s service) Delete(ctx context.Context, id string) (Organization, error) {
+// Delete organization by id. Organizations with children are only deleted
+// with cascade, which deletes all of their descendants too. Deleted
+// organizations can be restored until they are purged after the retention
+// period.
+func (s service) Delete(ctx context.Context, id string, cascade bool) (Organization, error) {
+
+	ctx, span := telemetry.Start(ctx, "organization.service.Delete")
+	defer span.End()
 
 	organization, err := s.Get(ctx, id)
-	if err != nil {
+	if err != nil || organization.Status == mongo_entity.OrganizationDeleted {
 		s.logger.Debug("Organization not exists.", zap.String("organization_id", id))
 		return Organization{}, &util.NotFoundError{Path: "Organization " + id + " not exists."}
 	}
-	if err = s.repo.Delete(ctx, id); err != nil {
+	children, err := s.repo.QueryChildren(ctx, id)
+	if err != nil {
+		return Organization{}, err
+	}
+	if len(children) > 0 && !cascade {
+		return Organization{}, &util.ConflictError{Message: "Organization " + id + " has child organizations. Delete them first or delete with cascade."}
+	}
+	if err = s.repo.Delete(ctx, id, cascade, time.Now().UTC()); err != nil {
 		s.logger.Error("Error while deleting organization.", zap.String("organization_id", id))
 		return Organization{}, err
 	}
 	return organization, nil
 }
 
-// Regenerate API key of the organization.
+// Restore a deleted organization and the descendants deleted with it.
+func (s service) Restore(ctx context.Context, id string) (Organization, error) {
+
+	ctx, span := telemetry.Start(ctx, "organization.service.Restore")
+	defer span.End()
+
+	org, err := s.Get(ctx, id)
+	if err != nil {
+		return Organization{}, err
+	}
+	if org.Status != mongo_entity.OrganizationDeleted || org.DeletedAt == nil {
+		return Organization{}, &util.ConflictError{Message: "Organization " + id + " is not deleted."}
+	}
+	if org.ParentID != nil {
+		parent, err := s.repo.Get(ctx, org.ParentID.Hex())
+		if err != nil || parent.Status == mongo_entity.OrganizationDeleted {
+			return Organization{}, &util.ConflictError{Message: "The parent organization of " + id + " is deleted. Restore it first."}
+		}
+	}
+	if err := s.repo.Restore(ctx, id, *org.DeletedAt); err != nil {
+		s.logger.Error("Error while restoring organization.", zap.String("organization_id", id))
+		return Organization{}, err
+	}
+	return s.Get(ctx, id)
+}
+
+// Update the display name and settings of the organization.
+func (s service) Update(ctx context.Context, id string, req UpdateOrganizationRequest) (Organization, error) {
+
+	ctx, span := telemetry.Start(ctx, "organization.service.Update")
+	defer span.End()
+
+	if err := req.Validate(); err != nil {
+		return Organization{}, util.NewValidationError("Invalid input for organization.", err)
+	}
+	org, err := s.Get(ctx, id)
+	if err != nil || org.Status == mongo_entity.OrganizationDeleted {
+		s.logger.Debug("Organization not exists.", zap.String("organization_id", id))
+		return Organization{}, &util.NotFoundError{Path: "Organization " + id + " not exists."}
+	}
+
+	update := UpdateOrganization{DisplayName: req.DisplayName}
+	if req.Settings != nil {
+		settings := mongo_entity.OrganizationSettings{}
+		if org.Settings != nil {
+			settings = *org.Settings
+		}
+		if req.Settings.DefaultDecision != nil {
+			settings.DefaultDecision = *req.Settings.DefaultDecision
+		}
+		if req.Settings.CORSOrigins != nil {
+			settings.CORSOrigins = []string{}
+			for _, origin := range req.Settings.CORSOrigins {
+				settings.CORSOrigins = append(settings.CORSOrigins, strings.TrimSuffix(origin, "/"))
+			}
+		}
+		update.Settings = &settings
+	}
+	if err := s.repo.Update(ctx, id, update); err != nil {
+		s.logger.Error("Error while updating organization.", zap.String("organization_id", id))
+		return Organization{}, err
+	}
+	if req.Settings != nil && req.Settings.Quotas != nil {
+		if _, err := s.limits.Update(ctx, id, limits.UpdateLimitsRequest{OrganizationLimits: *req.Settings.Quotas}); err != nil {
+			return Organization{}, err
+		}
+	}
+	return s.Get(ctx, id)
+}
+
+// Suspend the organization. Checks in suspended organizations, and in their
+// descendants, are denied until the organization is resumed.
+func (s service) Suspend(ctx context.Context, id string) (Organization, error) {
+
+	ctx, span := telemetry.Start(ctx, "organization.service.Suspend")
+	defer span.End()
+
+	return s.setStatus(ctx, id, mongo_entity.OrganizationSuspended)
+}
+
+// Resume a suspended organization.
+func (s service) Resume(ctx context.Context, id string) (Organization, error) {
+
+	ctx, span := telemetry.Start(ctx, "organization.service.Resume")
+	defer span.End()
+
+	return s.setStatus(ctx, id, mongo_entity.OrganizationActive)
+}
+
+func (s service) setStatus(ctx context.Context, id string, status mongo_entity.OrganizationStatus) (Organization, error) {
+
+	org, err := s.Get(ctx, id)
+	if err != nil || org.Status == mongo_entity.OrganizationDeleted {
+		s.logger.Debug("Organization not exists.", zap.String("organization_id", id))
+		return Organization{}, &util.NotFoundError{Path: "Organization " + id + " not exists."}
+	}
+	if org.Identifier == s.defaults.RootOrganization {
+		return Organization{}, &util.InvalidInputError{Message: "The root organization cannot be suspended."}
+	}
+	if err := s.repo.SetStatus(ctx, id, status); err != nil {
+		s.logger.Error("Error while updating organization status.", zap.String("organization_id", id))
+		return Organization{}, err
+	}
+	return s.Get(ctx, id)
+}
+
+// Settings returns the settings of the organization with the id or
+// identifier.
+func (s service) Settings(ctx context.Context, org string) (mongo_entity.OrganizationSettings, error) {
+
+	ctx, span := telemetry.Start(ctx, "organization.service.Settings")
+	defer span.End()
+
+	settings, err := s.repo.GetSettings(ctx, org)
+	if err != nil {
+		return mongo_entity.OrganizationSettings{}, &util.NotFoundError{Path: "Organization"}
+	}
+	if settings == nil {
+		return mongo_entity.OrganizationSettings{}, nil
+	}
+	return *settings, nil
+}
+
+// Regenerate API key of the organization. The previous default key and any
+// legacy plaintext key stay valid for the rotation grace period.
 func (s service) RegenerateAPIKey(ctx context.Context, id string) (Organization, error) {
 
+	ctx, span := telemetry.Start(ctx, "organization.service.RegenerateAPIKey")
+	defer span.End()
+
 	// Get organization
-	exists, _ := s.repo.CheckOrgExistById(ctx, id)
-	if !exists {
+	org, err := s.repo.Get(ctx, id)
+	if err != nil {
 		s.logger.Debug("Organization not exists.", zap.String("organization_id", id))
 		return Organization{}, &util.NotFoundError{Path: "Organization " + id + " not exists."}
 	}
 
 	// Generate new API key.
-	key := make([]byte, 32)
+	APIKey, key, err := apikey.Generate(apikey.DefaultName, []string{apikey.ScopeAdmin}, nil)
+	if err != nil {
+		return Organization{}, err
+	}
+	graceUntil := time.Now().UTC().Add(s.gracePeriod)
+	keys := []mongo_entity.APIKey{}
+	for _, k := range org.APIKeys {
+		if k.Name == apikey.DefaultName && (k.ExpiresAt == nil || k.ExpiresAt.After(graceUntil)) {
+			k.ExpiresAt = &graceUntil
+		}
+		keys = append(keys, k)
+	}
+	keys = append(keys, key)
+	if err := s.repo.RefreshAPIKey(ctx, keys, id); err != nil {
+		s.logger.Error("Error while updating organization.", zap.String("organization_id", id))
+		return Organization{}, err
+	}
+	organization, err := s.Get(ctx, id)
+	if err != nil {
+		return Organization{}, err
+	}
+	organization.API_KEY = APIKey
+	return organization, nil
+}
 
-	if _, err := rand.Read(key); err != nil {
+// Regenerate the dedicated SCIM provisioning token of the organization, a
+// sync scoped key replacing the previous token.
+func (s service) RegenerateSCIMToken(ctx context.Context, id string) (Organization, error) {
+
+	ctx, span := telemetry.Start(ctx, "organization.service.RegenerateSCIMToken")
+	defer span.End()
+
+	org, err := s.repo.Get(ctx, id)
+	if err != nil {
+		s.logger.Debug("Organization not exists.", zap.String("organization_id", id))
+		return Organization{}, &util.NotFoundError{Path: "Organization " + id + " not exists."}
+	}
+
+	token, key, err := apikey.Generate(apikey.SCIMName, []string{apikey.ScopeSync}, nil)
+	if err != nil {
 		return Organization{}, err
 	}
-	APIKey := base64.StdEncoding.EncodeToString(key)
-	if err := s.repo.RefreshAPIKey(ctx, APIKey, id); err != nil {
+	keys := []mongo_entity.APIKey{}
+	for _, k := range org.APIKeys {
+		if k.Name != apikey.SCIMName {
+			keys = append(keys, k)
+		}
+	}
+	keys = append(keys, key)
+	if err := s.repo.RefreshAPIKey(ctx, keys, id); err != nil {
 		s.logger.Error("Error while updating organization.", zap.String("organization_id", id))
 		return Organization{}, err
 	}
 	organization, err := s.Get(ctx, id)
-	return organization, err
+	if err != nil {
+		return Organization{}, err
+	}
+	// The plaintext token is only returned once.
+	organization.SCIMToken = token
+	return organization, nil
 }
 
-// Get all organizations.
-func (s service) Query(ctx context.Context) ([]Organization, error) {
+// Get all organizations, or only the deleted ones.
+func (s service) Query(ctx context.Context, deleted bool) ([]Organization, error) {
+
+	ctx, span := telemetry.Start(ctx, "organization.service.Query")
+	defer span.End()
 
-	items, err := s.repo.Query(ctx)
+	items, err := s.repo.Query(ctx, deleted)
 	if err != nil {
 		s.logger.Error("Error while retrieving all organizations.")
 		return []Organization{}, err
@@ -206,7 +520,83 @@ func (s service) Query(ctx context.Context) ([]Organization, error) {
 	return result, nil
 }
 
+// Get the direct children of the organization.
+func (s service) Children(ctx context.Context, id string) ([]Organization, error) {
+
+	ctx, span := telemetry.Start(ctx, "organization.service.Children")
+	defer span.End()
+
+	if _, err := s.Get(ctx, id); err != nil {
+		return []Organization{}, err
+	}
+	items, err := s.repo.QueryChildren(ctx, id)
+	if err != nil {
+		s.logger.Error("Error while retrieving child organizations.", zap.String("organization_id", id))
+		return []Organization{}, err
+	}
+	result := []Organization{}
+	for _, item := range items {
+		result = append(result, Organization{item})
+	}
+	return result, nil
+}
+
+// Get the organization and all of its descendants as a tree.
+func (s service) Tree(ctx context.Context, id string) (Tree, error) {
+
+	ctx, span := telemetry.Start(ctx, "organization.service.Tree")
+	defer span.End()
+
+	org, err := s.Get(ctx, id)
+	if err != nil {
+		return Tree{}, err
+	}
+	descendants, err := s.repo.QueryDescendants(ctx, id)
+	if err != nil {
+		s.logger.Error("Error while retrieving child organizations.", zap.String("organization_id", id))
+		return Tree{}, err
+	}
+	children := map[primitive.ObjectID][]mongo_entity.Organization{}
+	for _, descendant := range descendants {
+		if descendant.ParentID != nil {
+			children[*descendant.ParentID] = append(children[*descendant.ParentID], descendant)
+		}
+	}
+	return tree(org.Organization, children), nil
+}
+
+func tree(org mongo_entity.Organization, children map[primitive.ObjectID][]mongo_entity.Organization) Tree {
+
+	node := Tree{Organization: Organization{org}, Children: []Tree{}}
+	for _, child := range children[org.ID] {
+		node.Children = append(node.Children, tree(child, children))
+	}
+	return node
+}
+
+// Get the organization when it is a descendant of the ancestor, so that admins
+// of the ancestor can manage it.
+func (s service) GetDescendant(ctx context.Context, ancestor_id string, id string) (Organization, error) {
+
+	ctx, span := telemetry.Start(ctx, "organization.service.GetDescendant")
+	defer span.End()
+
+	org, err := s.Get(ctx, id)
+	if err != nil || org.Status == mongo_entity.OrganizationDeleted {
+		return Organization{}, &util.NotFoundError{Path: "Organization " + id + " not exists."}
+	}
+	for _, ancestor := range org.Ancestors {
+		if ancestor.Hex() == ancestor_id {
+			return org, nil
+		}
+	}
+	return Organization{}, &util.NotFoundError{Path: "Organization " + id + " not exists."}
+}
+
 func (s service) CheckOrgExistByIdentifier(ctx context.Context, identifier string) (bool, error) {
 
+	ctx, span := telemetry.Start(ctx, "organization.service.CheckOrgExistByIdentifier")
+	defer span.End()
+
 	return s.repo.CheckOrgExistByIdentifier(ctx, identifier)
 }
@@ -3,7 +3,10 @@ package organization
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/shashimalcse/cronuseo/internal/apikey"
+	"github.com/shashimalcse/cronuseo/internal/limits"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
 	"github.com/shashimalcse/cronuseo/internal/test"
 	"github.com/shashimalcse/cronuseo/internal/util"
@@ -13,7 +16,7 @@ import (
 
 func Test_service(t *testing.T) {
 	logger := test.InitLogger()
-	s := NewService(&mockRepository{}, logger)
+	s := NewService(&mockRepository{}, time.Hour, TenantDefaults{}, &mockLimits{}, logger)
 
 	ctx := context.Background()
 
@@ -26,8 +29,36 @@ func Test_service(t *testing.T) {
 	assert.NotEmpty(t, org.ID)
 	assert.Equal(t, "test", org.Identifier)
 	assert.Equal(t, "test", org.DisplayName)
+	assert.NotEmpty(t, org.API_KEY)
+
+	// only the hash of the key is stored
+	stored, _ := s.Get(ctx, org.ID.Hex())
+	assert.Empty(t, stored.API_KEY)
+	assert.Equal(t, 1, len(stored.APIKeys))
+	assert.Equal(t, apikey.Hash(org.API_KEY), stored.APIKeys[0].Hash)
+
+	// regenerated keys keep the previous key for the grace period
+	regenerated, err := s.RegenerateAPIKey(ctx, org.ID.Hex())
+	assert.Nil(t, err)
+	assert.NotEqual(t, org.API_KEY, regenerated.API_KEY)
+	stored, _ = s.Get(ctx, org.ID.Hex())
+	assert.Equal(t, 2, len(stored.APIKeys))
+	assert.NotNil(t, stored.APIKeys[0].ExpiresAt)
+	assert.Nil(t, stored.APIKeys[1].ExpiresAt)
+
+	// SCIM tokens are sync scoped keys replacing the previous token
+	scim, err := s.RegenerateSCIMToken(ctx, org.ID.Hex())
+	assert.Nil(t, err)
+	assert.NotEmpty(t, scim.SCIMToken)
+	_, err = s.RegenerateSCIMToken(ctx, org.ID.Hex())
+	assert.Nil(t, err)
+	stored, _ = s.Get(ctx, org.ID.Hex())
+	assert.Empty(t, stored.SCIMToken)
+	assert.Equal(t, 3, len(stored.APIKeys))
+	assert.Equal(t, apikey.SCIMName, stored.APIKeys[2].Name)
+	assert.Equal(t, []string{apikey.ScopeSync}, stored.APIKeys[2].Scopes)
+	assert.NotEqual(t, apikey.Hash(scim.SCIMToken), stored.APIKeys[2].Hash)
 
-	// validation error in creation
 	_, err = s.Create(ctx, OrganizationCreationRequest{
 		DisplayName: "test",
 	})
@@ -61,23 +92,110 @@ func (m *mockRepository) Create(ctx context.Context, organization mongo_entity.O
 	m.orgs = append(m.orgs, organization)
 	return id.Hex(), nil
 }
-func (m mockRepository) Query(ctx context.Context) ([]mongo_entity.Organization, error) {
-	return m.orgs, nil
+func (m mockRepository) Query(ctx context.Context, deleted bool) ([]mongo_entity.Organization, error) {
+	orgs := []mongo_entity.Organization{}
+	for _, org := range m.orgs {
+		if (org.Status == mongo_entity.OrganizationDeleted) == deleted {
+			orgs = append(orgs, org)
+		}
+	}
+	return orgs, nil
 }
-func (m mockRepository) Delete(ctx context.Context, id string) error {
+func (m *mockRepository) Update(ctx context.Context, id string, update UpdateOrganization) error {
 	for i, org := range m.orgs {
 		if org.ID.Hex() == id {
-			m.orgs[i] = m.orgs[len(m.orgs)-1]
-			m.orgs = m.orgs[:len(m.orgs)-1]
-			break
+			if update.DisplayName != nil {
+				m.orgs[i].DisplayName = *update.DisplayName
+			}
+			if update.Settings != nil {
+				m.orgs[i].Settings = update.Settings
+			}
+			return nil
+		}
+	}
+	return &util.NotFoundError{Path: "Organization"}
+}
+func (m *mockRepository) SetStatus(ctx context.Context, id string, status mongo_entity.OrganizationStatus) error {
+	for i, org := range m.orgs {
+		if org.ID.Hex() == id {
+			m.orgs[i].Status = status
+			return nil
+		}
+	}
+	return &util.NotFoundError{Path: "Organization"}
+}
+func (m *mockRepository) Delete(ctx context.Context, id string, cascade bool, deletedAt time.Time) error {
+	for i, org := range m.orgs {
+		if org.Status == mongo_entity.OrganizationDeleted {
+			continue
+		}
+		if org.ID.Hex() == id || (cascade && isDescendant(org, id)) {
+			m.orgs[i].Status = mongo_entity.OrganizationDeleted
+			m.orgs[i].DeletedAt = &deletedAt
 		}
 	}
 	return nil
 }
-func (m *mockRepository) RefreshAPIKey(ctx context.Context, apiKey string, id string) error {
+func (m *mockRepository) Restore(ctx context.Context, id string, deletedAt time.Time) error {
+	for i, org := range m.orgs {
+		if org.DeletedAt != nil && org.DeletedAt.Equal(deletedAt) && (org.ID.Hex() == id || isDescendant(org, id)) {
+			m.orgs[i].Status = ""
+			m.orgs[i].DeletedAt = nil
+		}
+	}
+	return nil
+}
+func (m *mockRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	orgs := []mongo_entity.Organization{}
+	for _, org := range m.orgs {
+		if org.Status != mongo_entity.OrganizationDeleted || org.DeletedAt.After(before) {
+			orgs = append(orgs, org)
+		}
+	}
+	purged := int64(len(m.orgs) - len(orgs))
+	m.orgs = orgs
+	return purged, nil
+}
+func (m mockRepository) GetSettings(ctx context.Context, org string) (*mongo_entity.OrganizationSettings, error) {
+	for _, o := range m.orgs {
+		if o.ID.Hex() == org || o.Identifier == org {
+			return o.Settings, nil
+		}
+	}
+	return nil, &util.NotFoundError{Path: "Organization"}
+}
+func (m mockRepository) QueryChildren(ctx context.Context, id string) ([]mongo_entity.Organization, error) {
+	children := []mongo_entity.Organization{}
 	for _, org := range m.orgs {
+		if org.ParentID != nil && org.ParentID.Hex() == id && org.Status != mongo_entity.OrganizationDeleted {
+			children = append(children, org)
+		}
+	}
+	return children, nil
+}
+func (m mockRepository) QueryDescendants(ctx context.Context, id string) ([]mongo_entity.Organization, error) {
+	descendants := []mongo_entity.Organization{}
+	for _, org := range m.orgs {
+		if isDescendant(org, id) && org.Status != mongo_entity.OrganizationDeleted {
+			descendants = append(descendants, org)
+		}
+	}
+	return descendants, nil
+}
+func isDescendant(org mongo_entity.Organization, id string) bool {
+	for _, ancestor := range org.Ancestors {
+		if ancestor.Hex() == id {
+			return true
+		}
+	}
+	return false
+}
+func (m *mockRepository) RefreshAPIKey(ctx context.Context, keys []mongo_entity.APIKey, id string) error {
+	for i, org := range m.orgs {
 		if org.ID.Hex() == id {
-			org.API_KEY = apiKey
+			m.orgs[i].APIKeys = keys
+			m.orgs[i].API_KEY = ""
+			return nil
 		}
 	}
 	return &util.NotFoundError{Path: "Organization"}
@@ -90,6 +208,19 @@ func (m mockRepository) CheckOrgExistById(ctx context.Context, id string) (bool,
 	}
 	return false, nil
 }
+
+type mockLimits struct {
+	updated map[string]limits.UpdateLimitsRequest
+}
+
+func (m *mockLimits) Update(ctx context.Context, org_id string, req limits.UpdateLimitsRequest) (limits.Limits, error) {
+	if m.updated == nil {
+		m.updated = map[string]limits.UpdateLimitsRequest{}
+	}
+	m.updated[org_id] = req
+	return limits.Limits{}, nil
+}
+
 func (m mockRepository) CheckOrgExistByIdentifier(ctx context.Context, identifier string) (bool, error) {
 	for _, org := range m.orgs {
 		if org.Identifier == identifier {
@@ -98,3 +229,178 @@ func (m mockRepository) CheckOrgExistByIdentifier(ctx context.Context, identifie
 	}
 	return false, nil
 }
+
+func TestTenantDefaults(t *testing.T) {
+	logger := test.InitLogger()
+	defaults := TenantDefaults{
+		RootOrganization: "super",
+		AdminRoleName:    "admin",
+		Resources:        []SystemResource{{Identifier: "users", Actions: []string{"users:create", "users:read"}}},
+	}
+	repo := &mockRepository{}
+	s := NewService(repo, time.Hour, defaults, &mockLimits{}, logger)
+	ctx := context.Background()
+
+	// tenant organizations get the system resources and an admin role
+	org, err := s.Create(ctx, OrganizationCreationRequest{Identifier: "acme", DisplayName: "acme", AdminIdentifier: "alice"})
+	assert.Nil(t, err)
+	created := repo.orgs[0]
+	assert.Equal(t, org.ID, created.ID)
+	assert.Equal(t, 1, len(created.Resources))
+	assert.Equal(t, mongo_entity.SystemResource, created.Resources[0].Type)
+	assert.Equal(t, 2, len(created.Resources[0].Actions))
+	assert.Equal(t, 1, len(created.Roles))
+	assert.Equal(t, "admin", created.Roles[0].Identifier)
+	assert.Equal(t, []mongo_entity.Permission{{Resource: "users", Action: "users:create"}, {Resource: "users", Action: "users:read"}}, created.Roles[0].Permissions)
+	assert.Equal(t, 1, len(created.Users))
+	assert.Equal(t, "alice", created.Users[0].Identifier)
+	assert.Equal(t, []primitive.ObjectID{created.Roles[0].ID}, created.Users[0].Roles)
+	assert.Equal(t, []primitive.ObjectID{created.Users[0].ID}, created.Roles[0].Users)
+
+	// the root organization is seeded by the server
+	_, err = s.Create(ctx, OrganizationCreationRequest{Identifier: "super", DisplayName: "super"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(repo.orgs[1].Resources))
+	assert.Equal(t, 0, len(repo.orgs[1].Roles))
+}
+
+func TestHierarchy(t *testing.T) {
+	logger := test.InitLogger()
+	repo := &mockRepository{}
+	s := NewService(repo, time.Hour, TenantDefaults{RootOrganization: "super"}, &mockLimits{}, logger)
+	ctx := context.Background()
+
+	root, _ := s.Create(ctx, OrganizationCreationRequest{Identifier: "super", DisplayName: "super"})
+	acme, err := s.Create(ctx, OrganizationCreationRequest{Identifier: "acme", DisplayName: "acme"})
+	assert.Nil(t, err)
+	assert.Nil(t, acme.ParentID)
+
+	// children keep their parent and all ancestors, nearest first
+	sales, err := s.Create(ctx, OrganizationCreationRequest{Identifier: "acme-sales", DisplayName: "sales", ParentID: acme.ID.Hex()})
+	assert.Nil(t, err)
+	assert.Equal(t, acme.ID, *sales.ParentID)
+	emea, err := s.Create(ctx, OrganizationCreationRequest{Identifier: "acme-sales-emea", DisplayName: "emea", ParentID: sales.ID.Hex()})
+	assert.Nil(t, err)
+	assert.Equal(t, []primitive.ObjectID{sales.ID, acme.ID}, emea.Ancestors)
+	_, err = s.Create(ctx, OrganizationCreationRequest{Identifier: "acme-hr", DisplayName: "hr", ParentID: acme.ID.Hex()})
+	assert.Nil(t, err)
+
+	// parents must exist and cannot be the root organization
+	_, err = s.Create(ctx, OrganizationCreationRequest{Identifier: "orphan", DisplayName: "orphan", ParentID: primitive.NewObjectID().Hex()})
+	assert.IsType(t, &util.NotFoundError{}, err)
+	_, err = s.Create(ctx, OrganizationCreationRequest{Identifier: "top", DisplayName: "top", ParentID: root.ID.Hex()})
+	assert.IsType(t, &util.InvalidInputError{}, err)
+
+	children, err := s.Children(ctx, acme.ID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(children))
+
+	tree, err := s.Tree(ctx, acme.ID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, "acme", tree.Identifier)
+	assert.Equal(t, 2, len(tree.Children))
+	for _, child := range tree.Children {
+		if child.Identifier == "acme-sales" {
+			assert.Equal(t, 1, len(child.Children))
+			assert.Equal(t, "acme-sales-emea", child.Children[0].Identifier)
+		} else {
+			assert.Empty(t, child.Children)
+		}
+	}
+
+	// only descendants are managed through an ancestor
+	_, err = s.GetDescendant(ctx, acme.ID.Hex(), emea.ID.Hex())
+	assert.Nil(t, err)
+	_, err = s.GetDescendant(ctx, sales.ID.Hex(), acme.ID.Hex())
+	assert.IsType(t, &util.NotFoundError{}, err)
+
+	// organizations with children are only deleted with cascade
+	_, err = s.Delete(ctx, acme.ID.Hex(), false)
+	assert.IsType(t, &util.ConflictError{}, err)
+	_, err = s.Delete(ctx, emea.ID.Hex(), false)
+	assert.Nil(t, err)
+	_, err = s.Delete(ctx, acme.ID.Hex(), true)
+	assert.Nil(t, err)
+	active, _ := s.Query(ctx, false)
+	assert.Equal(t, 1, len(active))
+	assert.Equal(t, "super", active[0].Identifier)
+}
+
+func TestLifecycle(t *testing.T) {
+	logger := test.InitLogger()
+	repo := &mockRepository{}
+	quotas := &mockLimits{}
+	s := NewService(repo, time.Hour, TenantDefaults{RootOrganization: "super"}, quotas, logger)
+	ctx := context.Background()
+
+	root, _ := s.Create(ctx, OrganizationCreationRequest{Identifier: "super", DisplayName: "super"})
+	acme, _ := s.Create(ctx, OrganizationCreationRequest{Identifier: "acme", DisplayName: "acme"})
+	sales, _ := s.Create(ctx, OrganizationCreationRequest{Identifier: "acme-sales", DisplayName: "sales", ParentID: acme.ID.Hex()})
+
+	// settings are merged into the existing ones
+	name, allow, origins := "Acme", mongo_entity.DecisionAllow, []string{"https://app.acme.com/"}
+	org, err := s.Update(ctx, acme.ID.Hex(), UpdateOrganizationRequest{DisplayName: &name, Settings: &SettingsRequest{DefaultDecision: &allow}})
+	assert.Nil(t, err)
+	assert.Equal(t, "Acme", org.DisplayName)
+	org, err = s.Update(ctx, acme.ID.Hex(), UpdateOrganizationRequest{Settings: &SettingsRequest{CORSOrigins: origins, Quotas: &mongo_entity.OrganizationLimits{MaxUsers: 10}}})
+	assert.Nil(t, err)
+	assert.Equal(t, mongo_entity.OrganizationSettings{DefaultDecision: allow, CORSOrigins: []string{"https://app.acme.com"}}, *org.Settings)
+	assert.Equal(t, 10, quotas.updated[acme.ID.Hex()].MaxUsers)
+	settings, err := s.Settings(ctx, "acme")
+	assert.Nil(t, err)
+	assert.Equal(t, allow, settings.DefaultDecision)
+
+	// invalid settings are rejected
+	empty, decision, invalid := "", "maybe", []string{"ftp://acme.com", "https://acme.com/app"}
+	_, err = s.Update(ctx, acme.ID.Hex(), UpdateOrganizationRequest{DisplayName: &empty})
+	assert.NotNil(t, err)
+	_, err = s.Update(ctx, acme.ID.Hex(), UpdateOrganizationRequest{Settings: &SettingsRequest{DefaultDecision: &decision}})
+	assert.NotNil(t, err)
+	_, err = s.Update(ctx, acme.ID.Hex(), UpdateOrganizationRequest{Settings: &SettingsRequest{CORSOrigins: invalid}})
+	assert.NotNil(t, err)
+	_, err = s.Update(ctx, acme.ID.Hex(), UpdateOrganizationRequest{Settings: &SettingsRequest{Quotas: &mongo_entity.OrganizationLimits{Burst: -1}}})
+	assert.NotNil(t, err)
+
+	// organizations are suspended until resumed, except the root organization
+	org, err = s.Suspend(ctx, acme.ID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, mongo_entity.OrganizationSuspended, org.Status)
+	org, err = s.Resume(ctx, acme.ID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, mongo_entity.OrganizationActive, org.Status)
+	_, err = s.Suspend(ctx, root.ID.Hex())
+	assert.IsType(t, &util.InvalidInputError{}, err)
+
+	// deleted organizations are kept until restored or purged
+	_, err = s.Delete(ctx, acme.ID.Hex(), true)
+	assert.Nil(t, err)
+	deleted, _ := s.Query(ctx, true)
+	assert.Equal(t, 2, len(deleted))
+	_, err = s.Update(ctx, acme.ID.Hex(), UpdateOrganizationRequest{DisplayName: &name})
+	assert.IsType(t, &util.NotFoundError{}, err)
+	_, err = s.Suspend(ctx, acme.ID.Hex())
+	assert.IsType(t, &util.NotFoundError{}, err)
+	_, err = s.Restore(ctx, sales.ID.Hex())
+	assert.IsType(t, &util.ConflictError{}, err)
+
+	// descendants deleted with the organization are restored with it
+	org, err = s.Restore(ctx, acme.ID.Hex())
+	assert.Nil(t, err)
+	assert.Nil(t, org.DeletedAt)
+	children, _ := s.Children(ctx, acme.ID.Hex())
+	assert.Equal(t, 1, len(children))
+	_, err = s.Restore(ctx, acme.ID.Hex())
+	assert.IsType(t, &util.ConflictError{}, err)
+
+	// organizations are purged after the retention period
+	_, err = s.Delete(ctx, sales.ID.Hex(), false)
+	assert.Nil(t, err)
+	purger := NewPurger(repo, 24*time.Hour, logger)
+	purged, err := purger.Purge(ctx, time.Now().UTC())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), purged)
+	purged, err = purger.Purge(ctx, time.Now().UTC().Add(25*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), purged)
+	assert.Equal(t, 2, len(repo.orgs))
+}
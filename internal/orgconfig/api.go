@@ -0,0 +1,103 @@
+package orgconfig
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"gopkg.in/yaml.v2"
+)
+
+const yamlContentType = "application/yaml"
+
+func RegisterHandlers(r *echo.Group, service Service) {
+	res := handler{service}
+	router := r.Group("/organizations")
+	router.GET("/:id/export", res.export)
+	router.POST("/:id/apply", res.apply)
+}
+
+type handler struct {
+	service Service
+}
+
+// @Description Export organization configuration as JSON or YAML.
+// @Tags        Organization
+// @Param id path string true "Organization ID"
+// @Param format query string false "json or yaml"
+// @Produce     json,application/yaml
+// @Success     200 {object}  Document
+// @failure     404,500
+// @Router      /organizations/{id}/export [get]
+func (r handler) export(c echo.Context) error {
+
+	doc, err := r.service.Export(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	if wantsYAML(c.QueryParam("format"), c.Request().Header.Get(echo.HeaderAccept)) {
+		out, err := yaml.Marshal(doc)
+		if err != nil {
+			return util.HandleError(err)
+		}
+		return c.Blob(http.StatusOK, yamlContentType, out)
+	}
+	return c.JSON(http.StatusOK, doc)
+}
+
+// @Description Apply organization configuration. Set dry_run to preview the changes.
+// @Tags        Organization
+// @Accept      json,application/yaml
+// @Param id path string true "Organization ID"
+// @Param dry_run query bool false "Only compute the changes"
+// @Param request body Document true "body"
+// @Produce     json
+// @Success     200 {object}  Plan
+// @failure     400,404,409,500 {object} ApplyErrorResponse
+// @Router      /organizations/{id}/apply [post]
+func (r handler) apply(c echo.Context) error {
+
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	var doc Document
+	if wantsYAML("", c.Request().Header.Get(echo.HeaderContentType)) {
+		err = yaml.UnmarshalStrict(body, &doc)
+	} else {
+		err = json.Unmarshal(body, &doc)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+	plan, err := r.service.Apply(c.Request().Context(), c.Param("id"), doc, dryRun)
+	if err != nil && len(plan.Changes) > 0 {
+		code, response := util.NewErrorResponse(err)
+		response.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+		return c.JSON(code, ApplyErrorResponse{ErrorResponse: response, Applied: plan.Changes})
+	}
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, plan)
+}
+
+// ApplyErrorResponse is the error response of an apply that failed after
+// some of its changes were applied.
+type ApplyErrorResponse struct {
+	util.ErrorResponse
+	Applied []Change `json:"applied"`
+}
+
+func wantsYAML(format string, mediaType string) bool {
+
+	if format != "" {
+		return strings.EqualFold(format, "yaml") || strings.EqualFold(format, "yml")
+	}
+	return strings.Contains(mediaType, "yaml")
+}
@@ -0,0 +1,206 @@
+package orgconfig
+
+import (
+	"fmt"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// DocumentVersion is the schema version of exported configuration documents.
+const DocumentVersion = "cronuseo/v1"
+
+// Document is the declarative configuration of an organization. Users and
+// their assignments are runtime data and are not part of the document.
+type Document struct {
+	Version      string         `json:"version" yaml:"version"`
+	Organization string         `json:"organization,omitempty" yaml:"organization,omitempty"`
+	Resources    []ResourceSpec `json:"resources" yaml:"resources"`
+	Roles        []RoleSpec     `json:"roles" yaml:"roles"`
+	Groups       []GroupSpec    `json:"groups" yaml:"groups"`
+	Policies     []PolicySpec   `json:"policies" yaml:"policies"`
+}
+
+type ResourceSpec struct {
+	Identifier  string       `json:"identifier" yaml:"identifier"`
+	DisplayName string       `json:"display_name,omitempty" yaml:"display_name,omitempty"`
+	Actions     []ActionSpec `json:"actions,omitempty" yaml:"actions,omitempty"`
+}
+
+type ActionSpec struct {
+	Identifier  string `json:"identifier" yaml:"identifier"`
+	DisplayName string `json:"display_name,omitempty" yaml:"display_name,omitempty"`
+}
+
+type RoleSpec struct {
+	Identifier  string           `json:"identifier" yaml:"identifier"`
+	DisplayName string           `json:"display_name,omitempty" yaml:"display_name,omitempty"`
+	Permissions []PermissionSpec `json:"permissions,omitempty" yaml:"permissions,omitempty"`
+}
+
+type PermissionSpec struct {
+	Resource string `json:"resource" yaml:"resource"`
+	Action   string `json:"action" yaml:"action"`
+}
+
+type GroupSpec struct {
+	Identifier  string   `json:"identifier" yaml:"identifier"`
+	DisplayName string   `json:"display_name,omitempty" yaml:"display_name,omitempty"`
+	Roles       []string `json:"roles,omitempty" yaml:"roles,omitempty"`
+	Policies    []string `json:"policies,omitempty" yaml:"policies,omitempty"`
+}
+
+type PolicySpec struct {
+	Identifier    string              `json:"identifier" yaml:"identifier"`
+	DisplayName   string              `json:"display_name,omitempty" yaml:"display_name,omitempty"`
+	ActiveVersion string              `json:"active_version" yaml:"active_version"`
+	Versions      []PolicyVersionSpec `json:"versions" yaml:"versions"`
+}
+
+type PolicyVersionSpec struct {
+	Version string `json:"version" yaml:"version"`
+	Policy  string `json:"policy" yaml:"policy"`
+}
+
+func (m ResourceSpec) Validate() error {
+
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Identifier, validation.Required),
+	)
+}
+
+func (m ActionSpec) Validate() error {
+
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Identifier, validation.Required),
+	)
+}
+
+func (m RoleSpec) Validate() error {
+
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Identifier, validation.Required),
+	)
+}
+
+func (m GroupSpec) Validate() error {
+
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Identifier, validation.Required),
+	)
+}
+
+func (m PolicySpec) Validate() error {
+
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Identifier, validation.Required),
+		validation.Field(&m.ActiveVersion, validation.Required),
+		validation.Field(&m.Versions, validation.Required),
+	)
+}
+
+func (m PolicyVersionSpec) Validate() error {
+
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Version, validation.Required),
+		validation.Field(&m.Policy, validation.Required),
+	)
+}
+
+// Validate checks the document is self-consistent. Role permissions may also
+// reference the given system resource actions, which are managed by cronuseo
+// and never part of a document.
+func (d Document) Validate(systemActions map[string]bool) error {
+
+	if d.Version != DocumentVersion {
+		return fmt.Errorf("unsupported document version %q, expected %q", d.Version, DocumentVersion)
+	}
+
+	actions := map[string]bool{}
+	resources := map[string]bool{}
+	for _, resource := range d.Resources {
+		if err := resource.Validate(); err != nil {
+			return fmt.Errorf("resource %q: %v", resource.Identifier, err)
+		}
+		if resources[resource.Identifier] {
+			return fmt.Errorf("duplicate resource %q", resource.Identifier)
+		}
+		resources[resource.Identifier] = true
+		for _, action := range resource.Actions {
+			if err := action.Validate(); err != nil {
+				return fmt.Errorf("resource %q action: %v", resource.Identifier, err)
+			}
+			key := permissionKey(resource.Identifier, action.Identifier)
+			if actions[key] {
+				return fmt.Errorf("duplicate action %q in resource %q", action.Identifier, resource.Identifier)
+			}
+			actions[key] = true
+		}
+	}
+
+	policies := map[string]bool{}
+	for _, policy := range d.Policies {
+		if err := policy.Validate(); err != nil {
+			return fmt.Errorf("policy %q: %v", policy.Identifier, err)
+		}
+		if policies[policy.Identifier] {
+			return fmt.Errorf("duplicate policy %q", policy.Identifier)
+		}
+		policies[policy.Identifier] = true
+		versions := map[string]bool{}
+		for _, version := range policy.Versions {
+			if err := version.Validate(); err != nil {
+				return fmt.Errorf("policy %q version: %v", policy.Identifier, err)
+			}
+			if versions[version.Version] {
+				return fmt.Errorf("duplicate version %q in policy %q", version.Version, policy.Identifier)
+			}
+			versions[version.Version] = true
+		}
+		if !versions[policy.ActiveVersion] {
+			return fmt.Errorf("active version %q of policy %q is not defined", policy.ActiveVersion, policy.Identifier)
+		}
+	}
+
+	roles := map[string]bool{}
+	for _, role := range d.Roles {
+		if err := role.Validate(); err != nil {
+			return fmt.Errorf("role %q: %v", role.Identifier, err)
+		}
+		if roles[role.Identifier] {
+			return fmt.Errorf("duplicate role %q", role.Identifier)
+		}
+		roles[role.Identifier] = true
+		for _, permission := range role.Permissions {
+			key := permissionKey(permission.Resource, permission.Action)
+			if !actions[key] && !systemActions[key] {
+				return fmt.Errorf("role %q references unknown permission %s", role.Identifier, key)
+			}
+		}
+	}
+
+	groups := map[string]bool{}
+	for _, group := range d.Groups {
+		if err := group.Validate(); err != nil {
+			return fmt.Errorf("group %q: %v", group.Identifier, err)
+		}
+		if groups[group.Identifier] {
+			return fmt.Errorf("duplicate group %q", group.Identifier)
+		}
+		groups[group.Identifier] = true
+		for _, role := range group.Roles {
+			if !roles[role] {
+				return fmt.Errorf("group %q references unknown role %q", group.Identifier, role)
+			}
+		}
+		for _, policy := range group.Policies {
+			if !policies[policy] {
+				return fmt.Errorf("group %q references unknown policy %q", group.Identifier, policy)
+			}
+		}
+	}
+	return nil
+}
+
+func permissionKey(resource string, action string) string {
+	return resource + ":" + action
+}
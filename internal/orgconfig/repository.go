@@ -0,0 +1,88 @@
+package orgconfig
+
+import (
+	"context"
+
+	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Repository interface {
+	Get(ctx context.Context, org_id string) (*mongo_entity.Organization, error)
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) (bool, error)
+}
+
+type repository struct {
+	mongoClient *mongo.Client
+	mongoColl   *mongo.Collection
+}
+
+func NewRepository(mongodb *db.MongoDB) Repository {
+
+	orgCollection := mongodb.MongoClient.Database(mongodb.MongoConfig.DBName).Collection(mongodb.MongoConfig.OrganizationCollectionName)
+
+	return repository{mongoClient: mongodb.MongoClient, mongoColl: orgCollection}
+}
+
+// Transaction runs fn in a transaction and reports whether it did. On
+// deployments without transaction support, standalone servers, fn runs
+// without one, so changes made before an error are kept.
+func (r repository) Transaction(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+
+	ctx, span := telemetry.Repository(ctx, "orgconfig", "Transaction")
+	defer span.End()
+
+	// Transactions need a replica set or a sharded cluster.
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := r.mongoClient.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return false, err
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return false, fn(ctx)
+	}
+
+	session, err := r.mongoClient.StartSession()
+	if err != nil {
+		return false, err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return true, err
+}
+
+// Get organization with its resources, roles, groups and policies.
+func (r repository) Get(ctx context.Context, org_id string) (*mongo_entity.Organization, error) {
+
+	ctx, span := telemetry.Repository(ctx, "orgconfig", "Get")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"_id": orgId}
+	projection := bson.M{"users": 0}
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	var org mongo_entity.Organization
+	if err := result.Decode(&org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
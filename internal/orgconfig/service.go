@@ -0,0 +1,693 @@
+package orgconfig
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/shashimalcse/cronuseo/internal/group"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/policy"
+	"github.com/shashimalcse/cronuseo/internal/resource"
+	"github.com/shashimalcse/cronuseo/internal/role"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+type Service interface {
+	Export(ctx context.Context, org_id string) (Document, error)
+	Apply(ctx context.Context, org_id string, doc Document, dry_run bool) (Plan, error)
+}
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+
+	KindResource = "resource"
+	KindRole     = "role"
+	KindGroup    = "group"
+	KindPolicy   = "policy"
+)
+
+// Change is a single step needed to move an organization to the desired state.
+type Change struct {
+	Action     string   `json:"action"`
+	Kind       string   `json:"kind"`
+	Identifier string   `json:"identifier"`
+	Details    []string `json:"details,omitempty"`
+
+	apply func(ctx context.Context) error
+}
+
+// Plan lists the changes of an apply request in execution order. When an
+// apply fails, the plan lists the changes applied before the failure.
+type Plan struct {
+	DryRun  bool     `json:"dry_run"`
+	Changes []Change `json:"changes"`
+}
+
+type service struct {
+	repo            Repository
+	resourceService resource.Service
+	roleService     role.Service
+	groupService    group.Service
+	policyService   policy.Service
+	logger          *zap.Logger
+}
+
+func NewService(repo Repository, resourceService resource.Service, roleService role.Service, groupService group.Service,
+	policyService policy.Service, logger *zap.Logger) Service {
+
+	return service{
+		repo:            repo,
+		resourceService: resourceService,
+		roleService:     roleService,
+		groupService:    groupService,
+		policyService:   policyService,
+		logger:          logger,
+	}
+}
+
+// Export organization configuration.
+func (s service) Export(ctx context.Context, org_id string) (Document, error) {
+
+	ctx, span := telemetry.Start(ctx, "orgconfig.service.Export")
+	defer span.End()
+
+	org, err := s.repo.Get(ctx, org_id)
+	if err != nil {
+		s.logger.Error("Error while getting the organization.", zap.String("organization_id", org_id))
+		return Document{}, &util.NotFoundError{Path: "Organization " + org_id + " not exists."}
+	}
+	return toDocument(org), nil
+}
+
+// Apply a desired state document to the organization. Entities are matched by
+// identifier and created, updated or deleted through the entity services.
+func (s service) Apply(ctx context.Context, org_id string, doc Document, dry_run bool) (Plan, error) {
+
+	ctx, span := telemetry.Start(ctx, "orgconfig.service.Apply")
+	defer span.End()
+
+	org, err := s.repo.Get(ctx, org_id)
+	if err != nil {
+		s.logger.Error("Error while getting the organization.", zap.String("organization_id", org_id))
+		return Plan{}, &util.NotFoundError{Path: "Organization " + org_id + " not exists."}
+	}
+
+	systemActions := map[string]bool{}
+	systemResources := map[string]bool{}
+	for _, res := range org.Resources {
+		if res.Type != mongo_entity.SystemResource {
+			continue
+		}
+		systemResources[res.Identifier] = true
+		for _, action := range res.Actions {
+			systemActions[permissionKey(res.Identifier, action.Identifier)] = true
+		}
+	}
+	for _, res := range doc.Resources {
+		if systemResources[res.Identifier] {
+			return Plan{}, &util.InvalidInputError{Message: "Invalid resource " + res.Identifier + ", system resources cannot be applied."}
+		}
+	}
+	if err := doc.Validate(systemActions); err != nil {
+		s.logger.Debug("Invalid organization configuration.", zap.String("organization_id", org_id), zap.Error(err))
+		return Plan{}, &util.InvalidInputError{Message: "Invalid organization configuration: " + err.Error()}
+	}
+
+	plan := Plan{DryRun: dry_run, Changes: s.plan(org_id, org, doc)}
+	if dry_run {
+		return plan, nil
+	}
+	if applied, err := s.execute(ctx, org_id, plan.Changes); err != nil {
+		return Plan{Changes: applied}, err
+	}
+	return plan, nil
+}
+
+// execute applies the changes in one transaction, which is retried from
+// scratch on transient errors. On failure it returns the changes that were
+// applied and kept, none when the transaction was rolled back.
+func (s service) execute(ctx context.Context, org_id string, changes []Change) ([]Change, error) {
+
+	var applied []Change
+	transactional, err := s.repo.Transaction(ctx, func(ctx context.Context) error {
+		applied = []Change{}
+		for _, change := range changes {
+			if err := change.apply(ctx); err != nil {
+				s.logger.Error("Error while applying organization configuration.",
+					zap.String("organization_id", org_id),
+					zap.String("kind", change.Kind),
+					zap.String("identifier", change.Identifier),
+					zap.Int("applied_changes", len(applied)))
+				return err
+			}
+			applied = append(applied, change)
+		}
+		return nil
+	})
+	if err != nil && transactional {
+		return []Change{}, err
+	}
+	return applied, err
+}
+
+// plan diffs the current organization against the desired document. Creates
+// and updates run in dependency order (resources, policies, roles, groups)
+// and deletes run in the reverse order.
+func (s service) plan(org_id string, org *mongo_entity.Organization, doc Document) []Change {
+
+	// Ids of existing entities, extended as entities get created during apply.
+	ids := map[string]primitive.ObjectID{}
+	resolve := func(kind string, identifiers []string) []primitive.ObjectID {
+		result := []primitive.ObjectID{}
+		for _, identifier := range identifiers {
+			result = append(result, ids[kind+"/"+identifier])
+		}
+		return result
+	}
+
+	changes := []Change{}
+	deletes := []Change{}
+
+	// Resources.
+	currentResources := map[string]mongo_entity.Resource{}
+	for _, res := range org.Resources {
+		if res.Type != mongo_entity.SystemResource {
+			currentResources[res.Identifier] = res
+		}
+	}
+	for _, desired := range doc.Resources {
+		desired := desired
+		current, exists := currentResources[desired.Identifier]
+		if !exists {
+			actions := []mongo_entity.Action{}
+			details := []string{}
+			for _, action := range desired.Actions {
+				actions = append(actions, mongo_entity.Action{Identifier: action.Identifier, DisplayName: action.DisplayName})
+				details = append(details, "add action "+action.Identifier)
+			}
+			changes = append(changes, Change{Action: ActionCreate, Kind: KindResource, Identifier: desired.Identifier, Details: details,
+				apply: func(ctx context.Context) error {
+					_, err := s.resourceService.Create(ctx, org_id, resource.CreateResourceRequest{
+						Identifier:  desired.Identifier,
+						DisplayName: desired.DisplayName,
+						Actions:     actions,
+						Type:        mongo_entity.BusinessResource,
+					})
+					return err
+				}})
+			continue
+		}
+
+		id := current.ID.Hex()
+		details := []string{}
+		var displayName *string
+		if desired.DisplayName != "" && desired.DisplayName != current.DisplayName {
+			displayName = &desired.DisplayName
+			details = append(details, fmt.Sprintf("display_name: %q -> %q", current.DisplayName, desired.DisplayName))
+		}
+		currentActions := map[string]mongo_entity.Action{}
+		for _, action := range current.Actions {
+			currentActions[action.Identifier] = action
+		}
+		desiredActions := map[string]bool{}
+		added := []mongo_entity.Action{}
+		removed := []string{}
+		for _, action := range desired.Actions {
+			desiredActions[action.Identifier] = true
+			existing, ok := currentActions[action.Identifier]
+			if ok && (action.DisplayName == "" || action.DisplayName == existing.DisplayName) {
+				continue
+			}
+			// Actions cannot be updated in place, so a renamed action is re-created.
+			if ok {
+				removed = append(removed, action.Identifier)
+				details = append(details, "update action "+action.Identifier)
+			} else {
+				details = append(details, "add action "+action.Identifier)
+			}
+			added = append(added, mongo_entity.Action{Identifier: action.Identifier, DisplayName: action.DisplayName})
+		}
+		for _, action := range current.Actions {
+			if !desiredActions[action.Identifier] {
+				removed = append(removed, action.Identifier)
+				details = append(details, "remove action "+action.Identifier)
+			}
+		}
+		if len(details) == 0 {
+			continue
+		}
+		changes = append(changes, Change{Action: ActionUpdate, Kind: KindResource, Identifier: desired.Identifier, Details: details,
+			apply: func(ctx context.Context) error {
+				if displayName != nil {
+					if _, err := s.resourceService.Update(ctx, org_id, id, resource.UpdateResourceRequest{DisplayName: displayName}); err != nil {
+						return err
+					}
+				}
+				if len(removed) > 0 {
+					if _, err := s.resourceService.Patch(ctx, org_id, id, resource.PatchResourceRequest{RemovedActions: removed}); err != nil {
+						return err
+					}
+				}
+				if len(added) > 0 {
+					if _, err := s.resourceService.Patch(ctx, org_id, id, resource.PatchResourceRequest{AddedActions: added}); err != nil {
+						return err
+					}
+				}
+				return nil
+			}})
+	}
+	desiredResources := map[string]bool{}
+	for _, res := range doc.Resources {
+		desiredResources[res.Identifier] = true
+	}
+	for _, current := range org.Resources {
+		if current.Type == mongo_entity.SystemResource || desiredResources[current.Identifier] {
+			continue
+		}
+		id := current.ID.Hex()
+		deletes = append([]Change{{Action: ActionDelete, Kind: KindResource, Identifier: current.Identifier,
+			apply: func(ctx context.Context) error {
+				return s.resourceService.Delete(ctx, org_id, id)
+			}}}, deletes...)
+	}
+
+	// Policies.
+	currentPolicies := map[string]mongo_entity.Policy{}
+	for _, pol := range org.Polices {
+		currentPolicies[pol.Identifier] = pol
+		ids[KindPolicy+"/"+pol.Identifier] = pol.ID
+	}
+	for _, desired := range doc.Policies {
+		desired := desired
+		current, exists := currentPolicies[desired.Identifier]
+		if !exists {
+			active := PolicyVersionSpec{}
+			others := []mongo_entity.PolicyContent{}
+			details := []string{}
+			for _, version := range desired.Versions {
+				details = append(details, "add version "+version.Version)
+				if version.Version == desired.ActiveVersion {
+					active = version
+				} else {
+					others = append(others, mongo_entity.PolicyContent{Version: version.Version, Policy: version.Policy})
+				}
+			}
+			changes = append(changes, Change{Action: ActionCreate, Kind: KindPolicy, Identifier: desired.Identifier, Details: details,
+				apply: func(ctx context.Context) error {
+					created, err := s.policyService.Create(ctx, org_id, policy.CreatePolicyRequest{
+						Identifier:  desired.Identifier,
+						DisplayName: desired.DisplayName,
+						Version:     active.Version,
+						Policy:      active.Policy,
+					})
+					if err != nil {
+						return err
+					}
+					ids[KindPolicy+"/"+desired.Identifier] = created.ID
+					if len(others) > 0 {
+						_, err = s.policyService.Patch(ctx, org_id, created.ID.Hex(), policy.PatchPolicyRequest{AddedPolicies: others})
+					}
+					return err
+				}})
+			continue
+		}
+
+		id := current.ID.Hex()
+		details := []string{}
+		currentVersions := map[string]string{}
+		for _, content := range current.PolicyContents {
+			currentVersions[content.Version] = content.Policy
+		}
+		desiredVersions := map[string]bool{}
+		added := []mongo_entity.PolicyContent{}
+		updated := []PolicyVersionSpec{}
+		removed := []string{}
+		for _, version := range desired.Versions {
+			desiredVersions[version.Version] = true
+			content, ok := currentVersions[version.Version]
+			if !ok {
+				added = append(added, mongo_entity.PolicyContent{Version: version.Version, Policy: version.Policy})
+				details = append(details, "add version "+version.Version)
+			} else if content != version.Policy {
+				updated = append(updated, version)
+				details = append(details, "update version "+version.Version)
+			}
+		}
+		for _, content := range current.PolicyContents {
+			if !desiredVersions[content.Version] {
+				removed = append(removed, content.Version)
+				details = append(details, "remove version "+content.Version)
+			}
+		}
+		update := policy.UpdatePolicyRequest{}
+		if desired.DisplayName != "" && desired.DisplayName != current.DisplayName {
+			update.DisplayName = &desired.DisplayName
+			details = append(details, fmt.Sprintf("display_name: %q -> %q", current.DisplayName, desired.DisplayName))
+		}
+		if desired.ActiveVersion != current.ActiveVersion {
+			update.ActiveVersion = &desired.ActiveVersion
+			details = append(details, fmt.Sprintf("active_version: %q -> %q", current.ActiveVersion, desired.ActiveVersion))
+		}
+		if len(details) == 0 {
+			continue
+		}
+		changes = append(changes, Change{Action: ActionUpdate, Kind: KindPolicy, Identifier: desired.Identifier, Details: details,
+			apply: func(ctx context.Context) error {
+				if len(added) > 0 {
+					if _, err := s.policyService.Patch(ctx, org_id, id, policy.PatchPolicyRequest{AddedPolicies: added}); err != nil {
+						return err
+					}
+				}
+				for _, version := range updated {
+					version := version
+					content := policy.UpdatePolicyContent{Version: &version.Version, Policy: &version.Policy}
+					if _, err := s.policyService.Update(ctx, org_id, id, policy.UpdatePolicyRequest{PolicyContent: &content}); err != nil {
+						return err
+					}
+				}
+				if update.DisplayName != nil || update.ActiveVersion != nil {
+					if _, err := s.policyService.Update(ctx, org_id, id, update); err != nil {
+						return err
+					}
+				}
+				if len(removed) > 0 {
+					if _, err := s.policyService.Patch(ctx, org_id, id, policy.PatchPolicyRequest{RemovedPolicies: removed}); err != nil {
+						return err
+					}
+				}
+				return nil
+			}})
+	}
+	desiredPolicies := map[string]bool{}
+	for _, pol := range doc.Policies {
+		desiredPolicies[pol.Identifier] = true
+	}
+	for _, current := range org.Polices {
+		if desiredPolicies[current.Identifier] {
+			continue
+		}
+		id := current.ID.Hex()
+		deletes = append([]Change{{Action: ActionDelete, Kind: KindPolicy, Identifier: current.Identifier,
+			apply: func(ctx context.Context) error {
+				return s.policyService.Delete(ctx, org_id, id)
+			}}}, deletes...)
+	}
+
+	// Roles.
+	currentRoles := map[string]mongo_entity.Role{}
+	for _, r := range org.Roles {
+		currentRoles[r.Identifier] = r
+		ids[KindRole+"/"+r.Identifier] = r.ID
+	}
+	for _, desired := range doc.Roles {
+		desired := desired
+		current, exists := currentRoles[desired.Identifier]
+		if !exists {
+			permissions := []mongo_entity.Permission{}
+			details := []string{}
+			for _, permission := range desired.Permissions {
+				permissions = append(permissions, mongo_entity.Permission{Resource: permission.Resource, Action: permission.Action})
+				details = append(details, "add permission "+permissionKey(permission.Resource, permission.Action))
+			}
+			changes = append(changes, Change{Action: ActionCreate, Kind: KindRole, Identifier: desired.Identifier, Details: details,
+				apply: func(ctx context.Context) error {
+					created, err := s.roleService.Create(ctx, org_id, role.CreateRoleRequest{
+						Identifier:  desired.Identifier,
+						DisplayName: desired.DisplayName,
+						Permissions: permissions,
+					})
+					if err != nil {
+						return err
+					}
+					ids[KindRole+"/"+desired.Identifier] = created.ID
+					return nil
+				}})
+			continue
+		}
+
+		id := current.ID.Hex()
+		details := []string{}
+		var displayName *string
+		if desired.DisplayName != "" && desired.DisplayName != current.DisplayName {
+			displayName = &desired.DisplayName
+			details = append(details, fmt.Sprintf("display_name: %q -> %q", current.DisplayName, desired.DisplayName))
+		}
+		currentPermissions := map[mongo_entity.Permission]bool{}
+		for _, permission := range current.Permissions {
+			currentPermissions[permission] = true
+		}
+		desiredPermissions := map[mongo_entity.Permission]bool{}
+		patch := role.PatchRoleRequest{}
+		for _, spec := range desired.Permissions {
+			permission := mongo_entity.Permission{Resource: spec.Resource, Action: spec.Action}
+			desiredPermissions[permission] = true
+			if !currentPermissions[permission] {
+				patch.AddedPermissions = append(patch.AddedPermissions, permission)
+				details = append(details, "add permission "+permissionKey(permission.Resource, permission.Action))
+			}
+		}
+		for _, permission := range current.Permissions {
+			if !desiredPermissions[permission] {
+				patch.RemovedPermissions = append(patch.RemovedPermissions, permission)
+				details = append(details, "remove permission "+permissionKey(permission.Resource, permission.Action))
+			}
+		}
+		if len(details) == 0 {
+			continue
+		}
+		changes = append(changes, Change{Action: ActionUpdate, Kind: KindRole, Identifier: desired.Identifier, Details: details,
+			apply: func(ctx context.Context) error {
+				if displayName != nil {
+					if _, err := s.roleService.Update(ctx, org_id, id, role.UpdateRoleRequest{DisplayName: displayName}); err != nil {
+						return err
+					}
+				}
+				if len(patch.AddedPermissions) > 0 || len(patch.RemovedPermissions) > 0 {
+					if _, err := s.roleService.Patch(ctx, org_id, id, patch); err != nil {
+						return err
+					}
+				}
+				return nil
+			}})
+	}
+	desiredRoles := map[string]bool{}
+	for _, r := range doc.Roles {
+		desiredRoles[r.Identifier] = true
+	}
+	for _, current := range org.Roles {
+		if desiredRoles[current.Identifier] {
+			continue
+		}
+		id := current.ID.Hex()
+		deletes = append([]Change{{Action: ActionDelete, Kind: KindRole, Identifier: current.Identifier,
+			apply: func(ctx context.Context) error {
+				return s.roleService.Delete(ctx, org_id, id)
+			}}}, deletes...)
+	}
+
+	// Groups.
+	identifiers := map[primitive.ObjectID]string{}
+	for _, r := range org.Roles {
+		identifiers[r.ID] = r.Identifier
+	}
+	for _, pol := range org.Polices {
+		identifiers[pol.ID] = pol.Identifier
+	}
+	currentGroups := map[string]mongo_entity.Group{}
+	for _, g := range org.Groups {
+		currentGroups[g.Identifier] = g
+	}
+	for _, desired := range doc.Groups {
+		desired := desired
+		current, exists := currentGroups[desired.Identifier]
+		if !exists {
+			details := []string{}
+			for _, r := range desired.Roles {
+				details = append(details, "add role "+r)
+			}
+			for _, pol := range desired.Policies {
+				details = append(details, "add policy "+pol)
+			}
+			changes = append(changes, Change{Action: ActionCreate, Kind: KindGroup, Identifier: desired.Identifier, Details: details,
+				apply: func(ctx context.Context) error {
+					_, err := s.groupService.Create(ctx, org_id, group.CreateGroupRequest{
+						Identifier:  desired.Identifier,
+						DisplayName: desired.DisplayName,
+						Roles:       resolve(KindRole, desired.Roles),
+						Policies:    resolve(KindPolicy, desired.Policies),
+					})
+					return err
+				}})
+			continue
+		}
+
+		id := current.ID.Hex()
+		details := []string{}
+		var displayName *string
+		if desired.DisplayName != "" && desired.DisplayName != current.DisplayName {
+			displayName = &desired.DisplayName
+			details = append(details, fmt.Sprintf("display_name: %q -> %q", current.DisplayName, desired.DisplayName))
+		}
+		addedRoles, removedRoles := diffReferences(identifiers, current.Roles, desired.Roles)
+		addedPolicies, removedPolicies := diffReferences(identifiers, current.Policies, desired.Policies)
+		for _, r := range addedRoles {
+			details = append(details, "add role "+r)
+		}
+		for _, r := range removedRoles {
+			details = append(details, "remove role "+r)
+		}
+		for _, pol := range addedPolicies {
+			details = append(details, "add policy "+pol)
+		}
+		for _, pol := range removedPolicies {
+			details = append(details, "remove policy "+pol)
+		}
+		if len(details) == 0 {
+			continue
+		}
+		changes = append(changes, Change{Action: ActionUpdate, Kind: KindGroup, Identifier: desired.Identifier, Details: details,
+			apply: func(ctx context.Context) error {
+				if displayName != nil {
+					if _, err := s.groupService.Update(ctx, org_id, id, group.UpdateGroupRequest{DisplayName: displayName}); err != nil {
+						return err
+					}
+				}
+				patch := group.PatchGroupRequest{
+					AddedRoles:      resolve(KindRole, addedRoles),
+					RemovedRoles:    resolve(KindRole, removedRoles),
+					AddedPolicies:   resolve(KindPolicy, addedPolicies),
+					RemovedPolicies: resolve(KindPolicy, removedPolicies),
+				}
+				if len(patch.AddedRoles) > 0 || len(patch.RemovedRoles) > 0 || len(patch.AddedPolicies) > 0 || len(patch.RemovedPolicies) > 0 {
+					if _, err := s.groupService.Patch(ctx, org_id, id, patch); err != nil {
+						return err
+					}
+				}
+				return nil
+			}})
+	}
+	desiredGroups := map[string]bool{}
+	for _, g := range doc.Groups {
+		desiredGroups[g.Identifier] = true
+	}
+	for _, current := range org.Groups {
+		if desiredGroups[current.Identifier] {
+			continue
+		}
+		id := current.ID.Hex()
+		deletes = append([]Change{{Action: ActionDelete, Kind: KindGroup, Identifier: current.Identifier,
+			apply: func(ctx context.Context) error {
+				return s.groupService.Delete(ctx, org_id, id)
+			}}}, deletes...)
+	}
+
+	return append(changes, deletes...)
+}
+
+// diffReferences compares the assigned entity ids with the desired
+// identifiers. Ids that no longer resolve to an entity are left untouched.
+func diffReferences(identifiers map[primitive.ObjectID]string, current []primitive.ObjectID, desired []string) ([]string, []string) {
+
+	assigned := map[string]bool{}
+	for _, id := range current {
+		if identifier, ok := identifiers[id]; ok {
+			assigned[identifier] = true
+		}
+	}
+	wanted := map[string]bool{}
+	added := []string{}
+	for _, identifier := range desired {
+		wanted[identifier] = true
+		if !assigned[identifier] {
+			added = append(added, identifier)
+		}
+	}
+	removed := []string{}
+	for _, id := range current {
+		if identifier, ok := identifiers[id]; ok && !wanted[identifier] {
+			removed = append(removed, identifier)
+		}
+	}
+	return added, removed
+}
+
+// toDocument converts an organization to its configuration document, sorted
+// by identifier so exports diff cleanly.
+func toDocument(org *mongo_entity.Organization) Document {
+
+	identifiers := map[primitive.ObjectID]string{}
+	for _, r := range org.Roles {
+		identifiers[r.ID] = r.Identifier
+	}
+	for _, pol := range org.Polices {
+		identifiers[pol.ID] = pol.Identifier
+	}
+	references := func(ids []primitive.ObjectID) []string {
+		result := []string{}
+		for _, id := range ids {
+			if identifier, ok := identifiers[id]; ok {
+				result = append(result, identifier)
+			}
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	doc := Document{
+		Version:      DocumentVersion,
+		Organization: org.Identifier,
+		Resources:    []ResourceSpec{},
+		Roles:        []RoleSpec{},
+		Groups:       []GroupSpec{},
+		Policies:     []PolicySpec{},
+	}
+	for _, res := range org.Resources {
+		if res.Type == mongo_entity.SystemResource {
+			continue
+		}
+		spec := ResourceSpec{Identifier: res.Identifier, DisplayName: res.DisplayName}
+		for _, action := range res.Actions {
+			spec.Actions = append(spec.Actions, ActionSpec{Identifier: action.Identifier, DisplayName: action.DisplayName})
+		}
+		sort.Slice(spec.Actions, func(i, j int) bool { return spec.Actions[i].Identifier < spec.Actions[j].Identifier })
+		doc.Resources = append(doc.Resources, spec)
+	}
+	for _, r := range org.Roles {
+		spec := RoleSpec{Identifier: r.Identifier, DisplayName: r.DisplayName}
+		for _, permission := range r.Permissions {
+			spec.Permissions = append(spec.Permissions, PermissionSpec{Resource: permission.Resource, Action: permission.Action})
+		}
+		sort.Slice(spec.Permissions, func(i, j int) bool {
+			return permissionKey(spec.Permissions[i].Resource, spec.Permissions[i].Action) <
+				permissionKey(spec.Permissions[j].Resource, spec.Permissions[j].Action)
+		})
+		doc.Roles = append(doc.Roles, spec)
+	}
+	for _, g := range org.Groups {
+		doc.Groups = append(doc.Groups, GroupSpec{
+			Identifier:  g.Identifier,
+			DisplayName: g.DisplayName,
+			Roles:       references(g.Roles),
+			Policies:    references(g.Policies),
+		})
+	}
+	for _, pol := range org.Polices {
+		spec := PolicySpec{Identifier: pol.Identifier, DisplayName: pol.DisplayName, ActiveVersion: pol.ActiveVersion}
+		for _, content := range pol.PolicyContents {
+			spec.Versions = append(spec.Versions, PolicyVersionSpec{Version: content.Version, Policy: content.Policy})
+		}
+		doc.Policies = append(doc.Policies, spec)
+	}
+
+	sort.Slice(doc.Resources, func(i, j int) bool { return doc.Resources[i].Identifier < doc.Resources[j].Identifier })
+	sort.Slice(doc.Roles, func(i, j int) bool { return doc.Roles[i].Identifier < doc.Roles[j].Identifier })
+	sort.Slice(doc.Groups, func(i, j int) bool { return doc.Groups[i].Identifier < doc.Groups[j].Identifier })
+	sort.Slice(doc.Policies, func(i, j int) bool { return doc.Policies[i].Identifier < doc.Policies[j].Identifier })
+	return doc
+}
@@ -0,0 +1,154 @@
+package orgconfig
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/test"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testOrganization() *mongo_entity.Organization {
+
+	adminId := primitive.NewObjectID()
+	readerId := primitive.NewObjectID()
+	policyId := primitive.NewObjectID()
+	return &mongo_entity.Organization{
+		Identifier: "acme",
+		Resources: []mongo_entity.Resource{
+			{ID: primitive.NewObjectID(), Identifier: "users", Type: mongo_entity.SystemResource,
+				Actions: []mongo_entity.Action{{Identifier: "users:read"}}},
+			{ID: primitive.NewObjectID(), Identifier: "invoices", DisplayName: "Invoices", Type: mongo_entity.BusinessResource,
+				Actions: []mongo_entity.Action{{Identifier: "write"}, {Identifier: "read"}}},
+			{ID: primitive.NewObjectID(), Identifier: "reports", Type: mongo_entity.BusinessResource},
+		},
+		Roles: []mongo_entity.Role{
+			{ID: readerId, Identifier: "reader", Permissions: []mongo_entity.Permission{{Resource: "invoices", Action: "read"}}},
+			{ID: adminId, Identifier: "admin", Permissions: []mongo_entity.Permission{
+				{Resource: "invoices", Action: "write"}, {Resource: "invoices", Action: "read"}}},
+		},
+		Groups: []mongo_entity.Group{
+			{ID: primitive.NewObjectID(), Identifier: "finance", Roles: []primitive.ObjectID{readerId, adminId}},
+		},
+		Polices: []mongo_entity.Policy{
+			{ID: policyId, Identifier: "office-hours", ActiveVersion: "1",
+				PolicyContents: []mongo_entity.PolicyContent{{Version: "1", Policy: "[]"}}},
+		},
+	}
+}
+
+func TestToDocument(t *testing.T) {
+
+	doc := toDocument(testOrganization())
+	assert.Equal(t, DocumentVersion, doc.Version)
+	assert.Equal(t, "acme", doc.Organization)
+	assert.Equal(t, 2, len(doc.Resources))
+	assert.Equal(t, "invoices", doc.Resources[0].Identifier)
+	assert.Equal(t, []ActionSpec{{Identifier: "read"}, {Identifier: "write"}}, doc.Resources[0].Actions)
+	assert.Equal(t, "admin", doc.Roles[0].Identifier)
+	assert.Equal(t, []string{"admin", "reader"}, doc.Groups[0].Roles)
+	assert.Equal(t, "1", doc.Policies[0].ActiveVersion)
+	assert.Nil(t, doc.Validate(map[string]bool{}))
+}
+
+func TestValidate(t *testing.T) {
+
+	doc := toDocument(testOrganization())
+	doc.Version = "v0"
+	assert.NotNil(t, doc.Validate(nil))
+
+	doc = toDocument(testOrganization())
+	doc.Roles[0].Permissions = append(doc.Roles[0].Permissions, PermissionSpec{Resource: "users", Action: "users:read"})
+	assert.NotNil(t, doc.Validate(nil))
+	assert.Nil(t, doc.Validate(map[string]bool{permissionKey("users", "users:read"): true}))
+
+	doc = toDocument(testOrganization())
+	doc.Groups[0].Policies = []string{"missing"}
+	assert.NotNil(t, doc.Validate(nil))
+
+	doc = toDocument(testOrganization())
+	doc.Policies[0].ActiveVersion = "2"
+	assert.NotNil(t, doc.Validate(nil))
+}
+
+func TestPlan(t *testing.T) {
+
+	org := testOrganization()
+	s := service{}
+
+	// Applying an export of the organization is a no-op.
+	assert.Empty(t, s.plan("org", org, toDocument(org)))
+
+	doc := toDocument(org)
+	doc.Resources = doc.Resources[:1]
+	doc.Resources[0].Actions = []ActionSpec{{Identifier: "read"}, {Identifier: "approve"}}
+	doc.Roles[0].Permissions = []PermissionSpec{{Resource: "invoices", Action: "read"}}
+	doc.Roles = append(doc.Roles, RoleSpec{Identifier: "approver", Permissions: []PermissionSpec{{Resource: "invoices", Action: "approve"}}})
+	doc.Groups[0].Roles = []string{"approver", "reader"}
+	doc.Policies[0].Versions = append(doc.Policies[0].Versions, PolicyVersionSpec{Version: "2", Policy: "[]"})
+	doc.Policies[0].ActiveVersion = "2"
+
+	assert.Nil(t, doc.Validate(nil))
+
+	changes := s.plan("org", org, doc)
+	summary := []string{}
+	for _, change := range changes {
+		summary = append(summary, change.Action+" "+change.Kind+" "+change.Identifier)
+	}
+	assert.Equal(t, []string{
+		"update resource invoices",
+		"update policy office-hours",
+		"update role admin",
+		"create role approver",
+		"update group finance",
+		"delete resource reports",
+	}, summary)
+	assert.Equal(t, []string{"add action approve", "remove action write"}, changes[0].Details)
+	assert.Equal(t, []string{"add role approver", "remove role admin"}, changes[4].Details)
+}
+
+type mockRepository struct {
+	transactional bool
+}
+
+func (m mockRepository) Get(ctx context.Context, org_id string) (*mongo_entity.Organization, error) {
+	return testOrganization(), nil
+}
+func (m mockRepository) Transaction(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	return m.transactional, fn(ctx)
+}
+
+func TestExecuteFailure(t *testing.T) {
+
+	failure := errors.New("write failed")
+	applied := []string{}
+	changes := []Change{}
+	for _, identifier := range []string{"a", "b", "c"} {
+		identifier := identifier
+		changes = append(changes, Change{Action: ActionCreate, Kind: KindRole, Identifier: identifier,
+			apply: func(ctx context.Context) error {
+				if identifier == "b" {
+					return failure
+				}
+				applied = append(applied, identifier)
+				return nil
+			}})
+	}
+
+	// Without transactions the changes applied before the failure are reported.
+	s := service{repo: mockRepository{}, logger: test.InitLogger()}
+	result, err := s.execute(context.Background(), "org", changes)
+	assert.Equal(t, failure, err)
+	assert.Equal(t, 1, len(result))
+	assert.Equal(t, "a", result[0].Identifier)
+	assert.Equal(t, []string{"a"}, applied)
+
+	// Rolled back transactions applied nothing.
+	s.repo = mockRepository{transactional: true}
+	result, err = s.execute(context.Background(), "org", changes)
+	assert.Equal(t, failure, err)
+	assert.Empty(t, result)
+}
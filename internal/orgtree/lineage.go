@@ -0,0 +1,72 @@
+package orgtree
+
+import (
+	"context"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Lineage returns the id of the organization matching the filter followed by
+// the ids of its ancestors, nearest first. Roles and resources of the lineage
+// are inherited by the organization.
+func Lineage(ctx context.Context, coll *mongo.Collection, filter bson.M) ([]primitive.ObjectID, error) {
+
+	var org mongo_entity.Organization
+	projection := bson.M{"_id": 1, "ancestors": 1}
+	err := coll.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org)
+	if err == mongo.ErrNoDocuments {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append([]primitive.ObjectID{org.ID}, org.Ancestors...), nil
+}
+
+// LineageOf returns the lineage of the organization with the id.
+func LineageOf(ctx context.Context, coll *mongo.Collection, org_id string) ([]primitive.ObjectID, error) {
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	return Lineage(ctx, coll, bson.M{"_id": orgId})
+}
+
+// Roles returns the ids and identifiers of the roles of the organizations
+// with the ids, in the order of the ids.
+func Roles(ctx context.Context, coll *mongo.Collection, ids []primitive.ObjectID) ([]mongo_entity.Role, error) {
+
+	if len(ids) == 0 {
+		return []mongo_entity.Role{}, nil
+	}
+	projection := bson.M{"_id": 1, "roles._id": 1, "roles.identifier": 1}
+	cursor, err := coll.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(projection))
+	if err != nil {
+		return nil, err
+	}
+	var orgs []mongo_entity.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return nil, err
+	}
+	byId := map[primitive.ObjectID][]mongo_entity.Role{}
+	for _, org := range orgs {
+		byId[org.ID] = org.Roles
+	}
+	roles := []mongo_entity.Role{}
+	for _, id := range ids {
+		roles = append(roles, byId[id]...)
+	}
+	return roles, nil
+}
+
+// Ancestors returns the ancestors of a new child of the parent, nearest first.
+func Ancestors(parent mongo_entity.Organization) []primitive.ObjectID {
+
+	return append([]primitive.ObjectID{parent.ID}, parent.Ancestors...)
+}
@@ -5,6 +5,7 @@ import (
 
 	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
 	"github.com/shashimalcse/cronuseo/internal/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -21,7 +22,7 @@ type Repository interface {
 	Delete(ctx context.Context, org_id string, id string) error
 	CheckPolicyExistById(ctx context.Context, org_id string, id string) (bool, error)
 	CheckPolicyExistsByIdentifier(ctx context.Context, org_id string, identifier string) (bool, error)
-	CheckPolicyContentExistsByVersion(ctx context.Context, org_id string, version string) (bool, error)
+	CheckPolicyContentExistsByVersion(ctx context.Context, org_id string, id string, version string) (bool, error)
 }
 
 type repository struct {
@@ -39,6 +40,9 @@ func NewRepository(mongodb *db.MongoDB) Repository {
 // Get policy by id.
 func (r repository) Get(ctx context.Context, org_id string, id string) (*mongo_entity.Policy, error) {
 
+	ctx, span := telemetry.Repository(ctx, "policy", "Get")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return nil, err
@@ -53,7 +57,7 @@ func (r repository) Get(ctx context.Context, org_id string, id string) (*mongo_e
 	filter := bson.M{"_id": orgId, "policies._id": policyId}
 	projection := bson.M{"policies.$": 1}
 	// Find the user document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return nil, err
 	}
@@ -73,6 +77,9 @@ func (r repository) Get(ctx context.Context, org_id string, id string) (*mongo_e
 // Create new policy.
 func (r repository) Create(ctx context.Context, org_id string, policy mongo_entity.Policy) error {
 
+	ctx, span := telemetry.Repository(ctx, "policy", "Create")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -91,6 +98,9 @@ func (r repository) Create(ctx context.Context, org_id string, policy mongo_enti
 
 func (r repository) Update(ctx context.Context, org_id string, id string, update_policy UpdatePolicy) error {
 
+	ctx, span := telemetry.Repository(ctx, "policy", "Update")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -141,6 +151,9 @@ func (r repository) Update(ctx context.Context, org_id string, id string, update
 
 func (r repository) Patch(ctx context.Context, org_id string, id string, patch_user PatchPolicy) error {
 
+	ctx, span := telemetry.Repository(ctx, "policy", "Patch")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -186,6 +199,9 @@ func (r repository) Patch(ctx context.Context, org_id string, id string, patch_u
 // Delete existing policy.
 func (r repository) Delete(ctx context.Context, org_id string, id string) error {
 
+	ctx, span := telemetry.Repository(ctx, "policy", "Delete")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -200,7 +216,7 @@ func (r repository) Delete(ctx context.Context, org_id string, id string) error
 	filter := bson.M{"_id": orgId}
 	update := bson.M{"$pull": bson.M{"policies": bson.M{"_id": policyId}}}
 	// Find the policy document in the "organizations" collection
-	result, err := r.mongoColl.UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(false))
+	result, err := r.mongoColl.UpdateOne(ctx, filter, update, options.Update().SetUpsert(false))
 	if err != nil {
 		return err
 	}
@@ -216,12 +232,22 @@ func (r repository) Delete(ctx context.Context, org_id string, id string) error
 	if err != nil {
 		return err
 	}
+
+	filter = bson.M{"_id": orgId, "service_accounts.policies": policyId}
+	update = bson.M{"$pull": bson.M{"service_accounts.$[].policies": policyId}}
+	_, err = r.mongoColl.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
 // Get all policies.
 func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.Policy, error) {
 
+	ctx, span := telemetry.Repository(ctx, "policy", "Query")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return nil, err
@@ -231,7 +257,7 @@ func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.P
 	filter := bson.M{"_id": orgId}
 	projection := bson.M{"policies.policy_contents": 0}
 	// Find the policy document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return nil, err
 	}
@@ -251,6 +277,9 @@ func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.P
 // Check if policy exists by id.
 func (r repository) CheckPolicyExistById(ctx context.Context, org_id string, id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "policy", "CheckPolicyExistById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -264,7 +293,7 @@ func (r repository) CheckPolicyExistById(ctx context.Context, org_id string, id
 	filter := bson.M{"_id": orgId, "policies._id": policyId}
 
 	// Search for the policy in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the policy was found
 	if result.Err() == nil {
@@ -279,6 +308,9 @@ func (r repository) CheckPolicyExistById(ctx context.Context, org_id string, id
 // Check if policy exists by key.
 func (r repository) CheckPolicyExistsByIdentifier(ctx context.Context, org_id string, identifier string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "policy", "CheckPolicyExistsByIdentifier")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -286,7 +318,7 @@ func (r repository) CheckPolicyExistsByIdentifier(ctx context.Context, org_id st
 	filter := bson.M{"_id": orgId, "policies.identifier": identifier}
 
 	// Search for the policy in the "organizations" collection
-	count, err := r.mongoColl.CountDocuments(context.Background(), filter)
+	count, err := r.mongoColl.CountDocuments(ctx, filter)
 
 	if err != nil {
 		return false, err
@@ -298,16 +330,24 @@ func (r repository) CheckPolicyExistsByIdentifier(ctx context.Context, org_id st
 }
 
 // Check if policy content exists by version.
-func (r repository) CheckPolicyContentExistsByVersion(ctx context.Context, org_id string, version string) (bool, error) {
+func (r repository) CheckPolicyContentExistsByVersion(ctx context.Context, org_id string, id string, version string) (bool, error) {
+
+	ctx, span := telemetry.Repository(ctx, "policy", "CheckPolicyContentExistsByVersion")
+	defer span.End()
 
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
 	}
-	filter := bson.M{"_id": orgId, "policies.policy_contents.version": version}
+
+	policyId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+	filter := bson.M{"_id": orgId, "policies": bson.M{"$elemMatch": bson.M{"_id": policyId, "policy_contents.version": version}}}
 
 	// Search for the policy in the "organizations" collection
-	count, err := r.mongoColl.CountDocuments(context.Background(), filter)
+	count, err := r.mongoColl.CountDocuments(ctx, filter)
 
 	if err != nil {
 		return false, err
@@ -334,7 +374,7 @@ func (r repository) CheckPolicyContentExistsByVersion(ctx context.Context, org_i
 // 	filter := bson.M{"_id": orgId, "roles._id": roleId}
 
 // 	// Search for the role in the "organizations" collection
-// 	result := r.mongoColl.FindOne(context.Background(), filter)
+// 	result := r.mongoColl.FindOne(ctx, filter)
 
 // 	// Check if the role was found
 // 	if result.Err() == nil {
@@ -368,7 +408,7 @@ func (r repository) CheckPolicyContentExistsByVersion(ctx context.Context, org_i
 // 	projection := bson.M{"users.$": 1}
 // 	org := mongo_entity.Organization{}
 // 	// Search for the role in the "organizations" collection
-// 	err = r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection)).Decode(&org)
+// 	err = r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org)
 // 	if err != nil {
 // 		return false, err
 // 	}
@@ -400,7 +440,7 @@ func (r repository) CheckPolicyContentExistsByVersion(ctx context.Context, org_i
 // 	filter := bson.M{"_id": orgId, "groups._id": groupId}
 
 // 	// Search for the group in the "organizations" collection
-// 	result := r.mongoColl.FindOne(context.Background(), filter)
+// 	result := r.mongoColl.FindOne(ctx, filter)
 
 // 	// Check if the group was found
 // 	if result.Err() == nil {
@@ -434,7 +474,7 @@ func (r repository) CheckPolicyContentExistsByVersion(ctx context.Context, org_i
 // 	projection := bson.M{"users.$": 1}
 // 	org := mongo_entity.Organization{}
 // 	// Search for the role in the "organizations" collection
-// 	err = r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection)).Decode(&org)
+// 	err = r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org)
 // 	if err != nil {
 // 		return false, err
 // 	}
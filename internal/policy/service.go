@@ -3,7 +3,10 @@ package policy
 import (
 	"context"
 
+	"github.com/shashimalcse/cronuseo/internal/limits"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/userschema"
 	"github.com/shashimalcse/cronuseo/internal/util"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
@@ -71,16 +74,22 @@ func (m CreatePolicyRequest) Validate() error {
 type service struct {
 	repo   Repository
 	logger *zap.Logger
+	quotas limits.Quotas
+
+	properties userschema.Validator
 }
 
-func NewService(repo Repository, logger *zap.Logger) Service {
+func NewService(repo Repository, logger *zap.Logger, quotas limits.Quotas, properties userschema.Validator) Service {
 
-	return service{repo: repo, logger: logger}
+	return service{repo: repo, logger: logger, quotas: quotas, properties: properties}
 }
 
 // Get policy by id.
 func (s service) Get(ctx context.Context, org_id string, id string) (Policy, error) {
 
+	ctx, span := telemetry.Start(ctx, "policy.service.Get")
+	defer span.End()
+
 	policy, err := s.repo.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Error("Error while getting the user.",
@@ -94,10 +103,13 @@ func (s service) Get(ctx context.Context, org_id string, id string) (Policy, err
 // Create new policy.
 func (s service) Create(ctx context.Context, org_id string, req CreatePolicyRequest) (Policy, error) {
 
+	ctx, span := telemetry.Start(ctx, "policy.service.Create")
+	defer span.End()
+
 	// Validate policy request.
 	if err := req.Validate(); err != nil {
 		s.logger.Error("Error while validating policy create request.")
-		return Policy{}, &util.InvalidInputError{Path: "Invalid input for policy."}
+		return Policy{}, util.NewValidationError("Invalid input for policy.", err)
 	}
 
 	// Check policy already exists.
@@ -108,6 +120,14 @@ func (s service) Create(ctx context.Context, org_id string, req CreatePolicyRequ
 
 	}
 
+	if err := s.quotas.CheckQuota(ctx, org_id, limits.QuotaPolicies); err != nil {
+		return Policy{}, err
+	}
+
+	if err := s.properties.ValidatePolicy(ctx, org_id, req.Policy); err != nil {
+		return Policy{}, err
+	}
+
 	// Generate policy id.
 	policyId := primitive.NewObjectID()
 	policContentId := primitive.NewObjectID()
@@ -135,6 +155,9 @@ func (s service) Create(ctx context.Context, org_id string, req CreatePolicyRequ
 // // Update policy.
 func (s service) Update(ctx context.Context, org_id string, id string, req UpdatePolicyRequest) (Policy, error) {
 
+	ctx, span := telemetry.Start(ctx, "policy.service.Update")
+	defer span.End()
+
 	_, err := s.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Debug("Policy not exists.", zap.String("policy_id", id))
@@ -143,14 +166,17 @@ func (s service) Update(ctx context.Context, org_id string, id string, req Updat
 
 	if req.PolicyContent != nil {
 		if req.PolicyContent.Version == nil || *req.PolicyContent.Version == "" {
-			return Policy{}, &util.InvalidInputError{Path: "Invalid input for policy."}
+			return Policy{}, &util.InvalidInputError{Message: "Invalid input for policy."}
 		}
-		exists, _ := s.repo.CheckPolicyContentExistsByVersion(ctx, org_id, *req.PolicyContent.Version)
+		exists, _ := s.repo.CheckPolicyContentExistsByVersion(ctx, org_id, id, *req.PolicyContent.Version)
 		if !exists {
-			return Policy{}, &util.InvalidInputError{Path: "Invalid policy version " + *req.PolicyContent.Version}
+			return Policy{}, &util.InvalidInputError{Message: "Invalid policy version " + *req.PolicyContent.Version}
 		}
 		if req.PolicyContent.Policy == nil || *req.PolicyContent.Policy == "" {
-			return Policy{}, &util.InvalidInputError{Path: "Invalid input for policy."}
+			return Policy{}, &util.InvalidInputError{Message: "Invalid input for policy."}
+		}
+		if err := s.properties.ValidatePolicy(ctx, org_id, *req.PolicyContent.Policy); err != nil {
+			return Policy{}, err
 		}
 	}
 
@@ -174,6 +200,9 @@ func (s service) Update(ctx context.Context, org_id string, id string, req Updat
 
 func (s service) Patch(ctx context.Context, org_id string, id string, req PatchPolicyRequest) (Policy, error) {
 
+	ctx, span := telemetry.Start(ctx, "policy.service.Patch")
+	defer span.End()
+
 	_, err := s.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Debug("User not exists.", zap.String("user_id", id))
@@ -182,15 +211,18 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchP
 
 	// roles
 	for _, policy := range req.AddedPolicies {
-		exists, _ := s.repo.CheckPolicyContentExistsByVersion(ctx, org_id, policy.Version)
+		exists, _ := s.repo.CheckPolicyContentExistsByVersion(ctx, org_id, id, policy.Version)
 		if exists {
-			return Policy{}, &util.InvalidInputError{Path: "Invalid policy version " + policy.Version}
+			return Policy{}, &util.InvalidInputError{Message: "Invalid policy version " + policy.Version}
+		}
+		if err := s.properties.ValidatePolicy(ctx, org_id, policy.Policy); err != nil {
+			return Policy{}, err
 		}
 	}
 	for _, version := range req.RemovedPolicies {
-		exists, _ := s.repo.CheckPolicyContentExistsByVersion(ctx, org_id, version)
+		exists, _ := s.repo.CheckPolicyContentExistsByVersion(ctx, org_id, id, version)
 		if !exists {
-			return Policy{}, &util.InvalidInputError{Path: "Invalid policy version " + version}
+			return Policy{}, &util.InvalidInputError{Message: "Invalid policy version " + version}
 		}
 	}
 
@@ -223,6 +255,9 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchP
 // Delete user.
 func (s service) Delete(ctx context.Context, org_id string, id string) error {
 
+	ctx, span := telemetry.Start(ctx, "policy.service.Delete")
+	defer span.End()
+
 	_, err := s.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Error("User not exists.", zap.String("user_id", id))
@@ -248,6 +283,9 @@ type Filter struct {
 // // Get all user.
 func (s service) Query(ctx context.Context, org_id string, filter Filter) ([]Policy, error) {
 
+	ctx, span := telemetry.Start(ctx, "policy.service.Query")
+	defer span.End()
+
 	result := []Policy{}
 	items, err := s.repo.Query(ctx, org_id)
 	if err != nil {
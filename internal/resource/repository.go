@@ -5,6 +5,8 @@ import (
 
 	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/orgtree"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
 	"github.com/shashimalcse/cronuseo/internal/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,6 +18,7 @@ type Repository interface {
 	Get(ctx context.Context, org_id string, id string) (*mongo_entity.Resource, error)
 	Query(ctx context.Context, org_id string) (*[]mongo_entity.Resource, error)
 	QueryWithActions(ctx context.Context, org_id string) (*[]mongo_entity.Resource, error)
+	QueryInherited(ctx context.Context, org_id string) ([]mongo_entity.Resource, error)
 	Create(ctx context.Context, org_id string, resource mongo_entity.Resource) error
 	Update(ctx context.Context, org_id string, id string, update_resource UpdateResource) error
 	Patch(ctx context.Context, org_id string, id string, patch_resource PatchResource) error
@@ -41,6 +44,9 @@ func NewRepository(mongodb *db.MongoDB) Repository {
 // Get resource by id.
 func (r repository) Get(ctx context.Context, org_id string, id string) (*mongo_entity.Resource, error) {
 
+	ctx, span := telemetry.Repository(ctx, "resource", "Get")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return nil, err
@@ -55,7 +61,7 @@ func (r repository) Get(ctx context.Context, org_id string, id string) (*mongo_e
 	filter := bson.M{"_id": orgId, "resources._id": resId}
 	projection := bson.M{"resources.$": 1}
 	// Find the role document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return nil, err
 	}
@@ -75,6 +81,9 @@ func (r repository) Get(ctx context.Context, org_id string, id string) (*mongo_e
 // Create new resource.
 func (r repository) Create(ctx context.Context, org_id string, resource mongo_entity.Resource) error {
 
+	ctx, span := telemetry.Repository(ctx, "resource", "Create")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -93,6 +102,9 @@ func (r repository) Create(ctx context.Context, org_id string, resource mongo_en
 
 func (r repository) Update(ctx context.Context, org_id string, id string, update_resource UpdateResource) error {
 
+	ctx, span := telemetry.Repository(ctx, "resource", "Update")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -117,6 +129,9 @@ func (r repository) Update(ctx context.Context, org_id string, id string, update
 
 func (r repository) Patch(ctx context.Context, org_id string, id string, patch_resource PatchResource) error {
 
+	ctx, span := telemetry.Repository(ctx, "resource", "Patch")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -158,6 +173,9 @@ func (r repository) Patch(ctx context.Context, org_id string, id string, patch_r
 // Get all resources.
 func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.Resource, error) {
 
+	ctx, span := telemetry.Repository(ctx, "resource", "Query")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return nil, err
@@ -167,7 +185,7 @@ func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.R
 	filter := bson.M{"_id": orgId}
 	projection := bson.M{"resources.actions": 0}
 	// Find the resource document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return nil, err
 	}
@@ -186,6 +204,9 @@ func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.R
 
 func (r repository) QueryWithActions(ctx context.Context, org_id string) (*[]mongo_entity.Resource, error) {
 
+	ctx, span := telemetry.Repository(ctx, "resource", "QueryWithActions")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return nil, err
@@ -194,7 +215,7 @@ func (r repository) QueryWithActions(ctx context.Context, org_id string) (*[]mon
 	// Define filter to find the resource by its ID
 	filter := bson.M{"_id": orgId}
 	// Find the resource document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 	if err := result.Err(); err != nil {
 		return nil, err
 	}
@@ -211,9 +232,44 @@ func (r repository) QueryWithActions(ctx context.Context, org_id string) (*[]mon
 	return &org.Resources, nil
 }
 
+// Query the resources inherited from the ancestors of the organization,
+// nearest ancestor first.
+func (r repository) QueryInherited(ctx context.Context, org_id string) ([]mongo_entity.Resource, error) {
+
+	ctx, span := telemetry.Repository(ctx, "resource", "QueryInherited")
+	defer span.End()
+
+	lineage, err := orgtree.LineageOf(ctx, r.mongoColl, org_id)
+	if err != nil {
+		return nil, err
+	}
+	ancestors := lineage[1:]
+	projection := bson.M{"resources": 1}
+	cursor, err := r.mongoColl.Find(ctx, bson.M{"_id": bson.M{"$in": ancestors}}, options.Find().SetProjection(projection))
+	if err != nil {
+		return nil, err
+	}
+	var orgs []mongo_entity.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return nil, err
+	}
+	resources := []mongo_entity.Resource{}
+	for _, ancestor := range ancestors {
+		for _, org := range orgs {
+			if org.ID == ancestor {
+				resources = append(resources, org.Resources...)
+			}
+		}
+	}
+	return resources, nil
+}
+
 // Delete existing resource.
 func (r repository) Delete(ctx context.Context, org_id string, id string) error {
 
+	ctx, span := telemetry.Repository(ctx, "resource", "Delete")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -228,7 +284,7 @@ func (r repository) Delete(ctx context.Context, org_id string, id string) error
 	filter := bson.M{"_id": orgId}
 	update := bson.M{"$pull": bson.M{"resources": bson.M{"_id": resId}}}
 	// Find the resource document in the "organizations" collection
-	result, err := r.mongoColl.UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(false))
+	result, err := r.mongoColl.UpdateOne(ctx, filter, update, options.Update().SetUpsert(false))
 	if err != nil {
 		return err
 	}
@@ -244,6 +300,9 @@ func (r repository) Delete(ctx context.Context, org_id string, id string) error
 // Check if resource exists by id.
 func (r repository) CheckResourceExistById(ctx context.Context, org_id string, id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "resource", "CheckResourceExistById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -257,7 +316,7 @@ func (r repository) CheckResourceExistById(ctx context.Context, org_id string, i
 	filter := bson.M{"_id": orgId, "resources._id": resId}
 
 	// Search for the resource in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the resource was found
 	if result.Err() == nil {
@@ -272,6 +331,9 @@ func (r repository) CheckResourceExistById(ctx context.Context, org_id string, i
 // Check if resource exists by key.
 func (r repository) CheckResourceExistsByIdentifier(ctx context.Context, org_id string, identifier string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "resource", "CheckResourceExistsByIdentifier")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -279,7 +341,7 @@ func (r repository) CheckResourceExistsByIdentifier(ctx context.Context, org_id
 	filter := bson.M{"_id": orgId, "resources.identifier": identifier}
 
 	// Search for the resource in the "organizations" collection
-	count, err := r.mongoColl.CountDocuments(context.Background(), filter)
+	count, err := r.mongoColl.CountDocuments(ctx, filter)
 
 	if err != nil {
 		return false, err
@@ -293,6 +355,9 @@ func (r repository) CheckResourceExistsByIdentifier(ctx context.Context, org_id
 // check user already added to role
 func (r repository) CheckActionAlreadyAddedToResourceByIdentifier(ctx context.Context, org_id string, resource_id string, action_identifier string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "resource", "CheckActionAlreadyAddedToResourceByIdentifier")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -307,7 +372,7 @@ func (r repository) CheckActionAlreadyAddedToResourceByIdentifier(ctx context.Co
 	projection := bson.M{"resources.$": 1}
 	org := mongo_entity.Organization{}
 	// Search for the resource in the "organizations" collection
-	err = r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection)).Decode(&org)
+	err = r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org)
 	if err != nil {
 		return false, err
 	}
@@ -325,6 +390,9 @@ func (r repository) CheckActionAlreadyAddedToResourceByIdentifier(ctx context.Co
 
 func (r repository) CheckActionExistsByIdentifier(ctx context.Context, org_id string, resource_id string, action_identifier string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "resource", "CheckActionExistsByIdentifier")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -337,7 +405,7 @@ func (r repository) CheckActionExistsByIdentifier(ctx context.Context, org_id st
 
 	filter := bson.M{"_id": orgId, "resources._id": resourceId, "resources.actions.identifier": action_identifier}
 
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the resource was found
 	if result.Err() == nil {
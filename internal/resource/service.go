@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
 	"github.com/shashimalcse/cronuseo/internal/util"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
@@ -75,6 +76,9 @@ func NewService(repo Repository, logger *zap.Logger) Service {
 // Get resource by id.
 func (s service) Get(ctx context.Context, org_id string, id string) (Resource, error) {
 
+	ctx, span := telemetry.Start(ctx, "resource.service.Get")
+	defer span.End()
+
 	resource, err := s.repo.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Error("Error while getting the resource.", zap.String("organization_id", org_id), zap.String("resource_id", id))
@@ -86,10 +90,13 @@ func (s service) Get(ctx context.Context, org_id string, id string) (Resource, e
 // Create new resource.
 func (s service) Create(ctx context.Context, org_id string, req CreateResourceRequest) (Resource, error) {
 
+	ctx, span := telemetry.Start(ctx, "resource.service.Create")
+	defer span.End()
+
 	// Validate resource request.
 	if err := req.Validate(); err != nil {
 		s.logger.Error("Error while validating resource create request.")
-		return Resource{}, &util.InvalidInputError{Path: "Invalid input for resource."}
+		return Resource{}, util.NewValidationError("Invalid input for resource.", err)
 	}
 
 	// Check resource already exists.
@@ -126,6 +133,9 @@ func (s service) Create(ctx context.Context, org_id string, req CreateResourceRe
 // Update resource.
 func (s service) Update(ctx context.Context, org_id string, id string, req UpdateResourceRequest) (Resource, error) {
 
+	ctx, span := telemetry.Start(ctx, "resource.service.Update")
+	defer span.End()
+
 	// Get resource to check resource exists.
 	_, err := s.Get(ctx, org_id, id)
 	if err != nil {
@@ -152,6 +162,9 @@ func (s service) Update(ctx context.Context, org_id string, id string, req Updat
 // Patch resource.
 func (s service) Patch(ctx context.Context, org_id string, id string, req PatchResourceRequest) (Resource, error) {
 
+	ctx, span := telemetry.Start(ctx, "resource.service.Patch")
+	defer span.End()
+
 	// Get resource.
 	_, err := s.Get(ctx, org_id, id)
 	if err != nil {
@@ -204,6 +217,9 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchR
 // Delete resource.
 func (s service) Delete(ctx context.Context, org_id string, id string) error {
 
+	ctx, span := telemetry.Start(ctx, "resource.service.Delete")
+	defer span.End()
+
 	_, err := s.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Error("Resource not exists.", zap.String("resource_id", id))
@@ -223,11 +239,17 @@ type Filter struct {
 	Cursor int    `json:"cursor" query:"cursor"`
 	Limit  int    `json:"limit" query:"limit"`
 	Name   string `json:"name" query:"name"`
+
+	// Include the resources inherited from ancestor organizations.
+	Inherited bool `json:"inherited" query:"inherited"`
 }
 
 // Get all resources.
 func (s service) Query(ctx context.Context, org_id string, filter Filter) ([]Resource, error) {
 
+	ctx, span := telemetry.Start(ctx, "resource.service.Query")
+	defer span.End()
+
 	result := []Resource{}
 	items, err := s.repo.Query(ctx, org_id)
 	if err != nil {
@@ -239,11 +261,26 @@ func (s service) Query(ctx context.Context, org_id string, filter Filter) ([]Res
 	for _, item := range *items {
 		result = append(result, Resource{item})
 	}
+	if filter.Inherited {
+		inherited, err := s.repo.QueryInherited(ctx, org_id)
+		if err != nil {
+			s.logger.Error("Error while retrieving inherited resources.",
+				zap.String("organization_id", org_id))
+			return []Resource{}, err
+		}
+		for _, item := range inherited {
+			item.Actions = nil
+			result = append(result, Resource{item})
+		}
+	}
 	return result, err
 }
 
 func (s service) QueryActions(ctx context.Context, org_id string, filter Filter) ([]Action, error) {
 
+	ctx, span := telemetry.Start(ctx, "resource.service.QueryActions")
+	defer span.End()
+
 	actions := []Action{}
 	resources, err := s.repo.QueryWithActions(ctx, org_id)
 	if err != nil {
@@ -252,7 +289,17 @@ func (s service) QueryActions(ctx context.Context, org_id string, filter Filter)
 		return []Action{}, err
 	}
 
-	for _, resource := range *resources {
+	all := *resources
+	if filter.Inherited {
+		inherited, err := s.repo.QueryInherited(ctx, org_id)
+		if err != nil {
+			s.logger.Error("Error while retrieving inherited resources.",
+				zap.String("organization_id", org_id))
+			return []Action{}, err
+		}
+		all = append(all, inherited...)
+	}
+	for _, resource := range all {
 		for _, action := range resource.Actions {
 			actions = append(actions, Action{Resource: resource.Identifier, Action: action.Identifier})
 		}
@@ -3,8 +3,11 @@ package role
 import (
 	"context"
 
+	"github.com/shashimalcse/cronuseo/internal/assignment"
 	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/orgtree"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
 	"github.com/shashimalcse/cronuseo/internal/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,6 +19,7 @@ type Repository interface {
 	Get(ctx context.Context, org_id string, id string) (*RoleResponse, error)
 	GetRoleByIdentifier(ctx context.Context, org_id string, identifier string) (*mongo_entity.Role, error)
 	Query(ctx context.Context, org_id string) (*[]mongo_entity.Role, error)
+	QueryInherited(ctx context.Context, org_id string) ([]mongo_entity.Role, error)
 	Create(ctx context.Context, org_id string, user mongo_entity.Role) error
 	Update(ctx context.Context, org_id string, id string, update_role UpdateRole) error
 	Patch(ctx context.Context, org_id string, id string, update_role PatchRole) error
@@ -46,6 +50,9 @@ func NewRepository(mongodb *db.MongoDB) Repository {
 // Get role by id.
 func (r repository) Get(ctx context.Context, org_id string, id string) (*RoleResponse, error) {
 
+	ctx, span := telemetry.Repository(ctx, "role", "Get")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return nil, err
@@ -60,7 +67,7 @@ func (r repository) Get(ctx context.Context, org_id string, id string) (*RoleRes
 	filter := bson.M{"_id": orgId, "roles._id": roleId}
 	projection := bson.M{"roles.$": 1}
 	// Find the role document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return nil, err
 	}
@@ -91,6 +98,9 @@ func (r repository) Get(ctx context.Context, org_id string, id string) (*RoleRes
 
 func (r repository) GetRoleByIdentifier(ctx context.Context, org_id string, identifier string) (*mongo_entity.Role, error) {
 
+	ctx, span := telemetry.Repository(ctx, "role", "GetRoleByIdentifier")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return nil, err
@@ -100,7 +110,7 @@ func (r repository) GetRoleByIdentifier(ctx context.Context, org_id string, iden
 	filter := bson.M{"_id": orgId, "roles.identifier": identifier}
 	projection := bson.M{"roles.$": 1}
 	// Find the role document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return nil, err
 	}
@@ -119,6 +129,9 @@ func (r repository) GetRoleByIdentifier(ctx context.Context, org_id string, iden
 // Create new role.
 func (r repository) Create(ctx context.Context, org_id string, role mongo_entity.Role) error {
 
+	ctx, span := telemetry.Repository(ctx, "role", "Create")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -157,6 +170,9 @@ func (r repository) Create(ctx context.Context, org_id string, role mongo_entity
 // Update role.
 func (r repository) Update(ctx context.Context, org_id string, id string, update_role UpdateRole) error {
 
+	ctx, span := telemetry.Repository(ctx, "role", "Update")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -182,6 +198,9 @@ func (r repository) Update(ctx context.Context, org_id string, id string, update
 
 func (r repository) Patch(ctx context.Context, org_id string, id string, patch_role PatchRole) error {
 
+	ctx, span := telemetry.Repository(ctx, "role", "Patch")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -232,6 +251,10 @@ func (r repository) Patch(ctx context.Context, org_id string, id string, patch_r
 				return err
 			}
 		}
+
+		if err := assignment.RemoveGrants(ctx, r.mongoColl, orgId, "users", patch_role.RemovedUsers, assignment.RoleGrants, []primitive.ObjectID{roleId}); err != nil {
+			return err
+		}
 	}
 
 	// add groups
@@ -275,6 +298,10 @@ func (r repository) Patch(ctx context.Context, org_id string, id string, patch_r
 				return err
 			}
 		}
+
+		if err := assignment.RemoveGrants(ctx, r.mongoColl, orgId, "groups", patch_role.RemovedGroups, assignment.RoleGrants, []primitive.ObjectID{roleId}); err != nil {
+			return err
+		}
 	}
 
 	// add permissions
@@ -306,6 +333,9 @@ func (r repository) Patch(ctx context.Context, org_id string, id string, patch_r
 // Delete role.
 func (r repository) Delete(ctx context.Context, org_id string, id string) error {
 
+	ctx, span := telemetry.Repository(ctx, "role", "Delete")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -320,7 +350,7 @@ func (r repository) Delete(ctx context.Context, org_id string, id string) error
 	filter := bson.M{"_id": orgId}
 	update := bson.M{"$pull": bson.M{"roles": bson.M{"_id": roleId}}}
 	// Find the role document in the "organizations" collection
-	result, err := r.mongoColl.UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(false))
+	result, err := r.mongoColl.UpdateOne(ctx, filter, update, options.Update().SetUpsert(false))
 	if err != nil {
 		return err
 	}
@@ -343,12 +373,29 @@ func (r repository) Delete(ctx context.Context, org_id string, id string) error
 	if err != nil {
 		return err
 	}
+
+	if err := assignment.RemoveGrants(ctx, r.mongoColl, orgId, "users", nil, assignment.RoleGrants, []primitive.ObjectID{roleId}); err != nil {
+		return err
+	}
+	if err := assignment.RemoveGrants(ctx, r.mongoColl, orgId, "groups", nil, assignment.RoleGrants, []primitive.ObjectID{roleId}); err != nil {
+		return err
+	}
+
+	filter = bson.M{"_id": orgId, "service_accounts.roles": roleId}
+	update = bson.M{"$pull": bson.M{"service_accounts.$[].roles": roleId}}
+	_, err = r.mongoColl.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
 // Query roles.
 func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.Role, error) {
 
+	ctx, span := telemetry.Repository(ctx, "role", "Query")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return nil, err
@@ -358,7 +405,7 @@ func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.R
 	filter := bson.M{"_id": orgId}
 	projection := bson.M{"roles.groups": 0, "roles.users": 0, "roles.permissions": 0}
 	// Find the role document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return nil, err
 	}
@@ -375,9 +422,44 @@ func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.R
 	return &org.Roles, nil
 }
 
+// Query the roles inherited from the ancestors of the organization, nearest
+// ancestor first.
+func (r repository) QueryInherited(ctx context.Context, org_id string) ([]mongo_entity.Role, error) {
+
+	ctx, span := telemetry.Repository(ctx, "role", "QueryInherited")
+	defer span.End()
+
+	lineage, err := orgtree.LineageOf(ctx, r.mongoColl, org_id)
+	if err != nil {
+		return nil, err
+	}
+	ancestors := lineage[1:]
+	projection := bson.M{"roles.groups": 0, "roles.users": 0, "roles.permissions": 0}
+	cursor, err := r.mongoColl.Find(ctx, bson.M{"_id": bson.M{"$in": ancestors}}, options.Find().SetProjection(projection))
+	if err != nil {
+		return nil, err
+	}
+	var orgs []mongo_entity.Organization
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return nil, err
+	}
+	roles := []mongo_entity.Role{}
+	for _, ancestor := range ancestors {
+		for _, org := range orgs {
+			if org.ID == ancestor {
+				roles = append(roles, org.Roles...)
+			}
+		}
+	}
+	return roles, nil
+}
+
 // Check if role exists by id.
 func (r repository) CheckRoleExistById(ctx context.Context, org_id string, id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "role", "CheckRoleExistById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -391,7 +473,7 @@ func (r repository) CheckRoleExistById(ctx context.Context, org_id string, id st
 	filter := bson.M{"_id": orgId, "roles._id": roleId}
 
 	// Search for the role in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the role was found
 	if result.Err() == nil {
@@ -406,6 +488,9 @@ func (r repository) CheckRoleExistById(ctx context.Context, org_id string, id st
 // Check if role exists by key.
 func (r repository) CheckRoleExistsByIdentifier(ctx context.Context, org_id string, identifier string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "role", "CheckRoleExistsByIdentifier")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -413,7 +498,7 @@ func (r repository) CheckRoleExistsByIdentifier(ctx context.Context, org_id stri
 	filter := bson.M{"_id": orgId, "roles.identifier": identifier}
 
 	// Search for the user in the "organizations" collection
-	count, err := r.mongoColl.CountDocuments(context.Background(), filter)
+	count, err := r.mongoColl.CountDocuments(ctx, filter)
 
 	if err != nil {
 		return false, err
@@ -427,6 +512,9 @@ func (r repository) CheckRoleExistsByIdentifier(ctx context.Context, org_id stri
 // Check if user exists by id.
 func (r repository) CheckUserExistById(ctx context.Context, org_id string, id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "role", "CheckUserExistById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -440,7 +528,7 @@ func (r repository) CheckUserExistById(ctx context.Context, org_id string, id st
 	filter := bson.M{"_id": orgId, "users._id": roleId}
 
 	// Search for the user in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the user was found
 	if result.Err() == nil {
@@ -455,6 +543,9 @@ func (r repository) CheckUserExistById(ctx context.Context, org_id string, id st
 // check user already added to role
 func (r repository) CheckUserAlreadyAssignToRoleById(ctx context.Context, org_id string, role_id string, user_id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "role", "CheckUserAlreadyAssignToRoleById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -474,7 +565,7 @@ func (r repository) CheckUserAlreadyAssignToRoleById(ctx context.Context, org_id
 	projection := bson.M{"roles.$": 1}
 	org := mongo_entity.Organization{}
 	// Search for the role in the "organizations" collection
-	err = r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection)).Decode(&org)
+	err = r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org)
 	if err != nil {
 		return false, err
 	}
@@ -493,13 +584,17 @@ func (r repository) CheckUserAlreadyAssignToRoleById(ctx context.Context, org_id
 // check user already added to role
 func (r repository) CheckResourceActionExists(ctx context.Context, org_id string, resource_identifier string, action_identifier string) (bool, error) {
 
-	orgId, err := primitive.ObjectIDFromHex(org_id)
+	ctx, span := telemetry.Repository(ctx, "role", "CheckResourceActionExists")
+	defer span.End()
+
+	// Resources of ancestor organizations are inherited.
+	lineage, err := orgtree.LineageOf(ctx, r.mongoColl, org_id)
 	if err != nil {
 		return false, err
 	}
 
-	filter := bson.M{"_id": orgId, "resources.identifier": resource_identifier, "resources.actions.identifier": action_identifier}
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	filter := bson.M{"_id": bson.M{"$in": lineage}, "resources.identifier": resource_identifier, "resources.actions.identifier": action_identifier}
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the resource was found
 	if result.Err() == nil {
@@ -514,6 +609,9 @@ func (r repository) CheckResourceActionExists(ctx context.Context, org_id string
 // check user already added to role
 func (r repository) CheckPermissionExists(ctx context.Context, org_id string, role_id string, resource_identifier string, action_identifier string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "role", "CheckPermissionExists")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -526,25 +624,26 @@ func (r repository) CheckPermissionExists(ctx context.Context, org_id string, ro
 
 	pipeline := mongo.Pipeline{
 		// Match the organization
-		bson.D{{"$match", bson.M{"_id": orgId}}},
+		bson.D{{Key: "$match", Value: bson.M{"_id": orgId}}},
 
 		// Unwind the roles array
-		bson.D{{"$unwind", "$roles"}},
+		bson.D{{Key: "$unwind", Value: "$roles"}},
 
 		// Match the specific role
-		bson.D{{"$match", bson.M{"roles._id": roleId}}},
+		bson.D{{Key: "$match", Value: bson.M{"roles._id": roleId}}},
 
 		// Check if the role has the permission
-		bson.D{{"$match", bson.M{"roles.permissions": bson.M{"$not": bson.M{"$elemMatch": bson.M{"resource": resource_identifier, "action": action_identifier}}}}}},
+		bson.D{{Key: "$match", Value: bson.M{"roles.permissions": bson.M{"$not": bson.M{"$elemMatch": bson.M{"resource": resource_identifier, "action": action_identifier}}}}}},
 	}
 
-	cursor, err := r.mongoColl.Aggregate(context.Background(), pipeline)
+	cursor, err := r.mongoColl.Aggregate(ctx, pipeline)
 	if err != nil {
-		// handle error
+		return false, err
 	}
+	defer cursor.Close(ctx)
 
 	// Check if the role without the specified permission exists
-	if cursor.Next(context.Background()) {
+	if cursor.Next(ctx) {
 		return false, nil // The role exists without the specified permission
 	} else {
 		return true, nil // The role does not exist or already has the permission
@@ -554,6 +653,9 @@ func (r repository) CheckPermissionExists(ctx context.Context, org_id string, ro
 // Get all resources.
 func (r repository) GetPermissions(ctx context.Context, org_id string, role_id string) (*[]mongo_entity.Permission, error) {
 
+	ctx, span := telemetry.Repository(ctx, "role", "GetPermissions")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return nil, err
@@ -568,7 +670,7 @@ func (r repository) GetPermissions(ctx context.Context, org_id string, role_id s
 	filter := bson.M{"_id": orgId, "roles._id": roleId}
 	projection := bson.M{"permissions.$": 1}
 	// Find the permission document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return nil, err
 	}
@@ -588,6 +690,9 @@ func (r repository) GetPermissions(ctx context.Context, org_id string, role_id s
 // Check if group exists by id.
 func (r repository) CheckGroupExistById(ctx context.Context, org_id string, id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "role", "CheckGroupExistById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -601,7 +706,7 @@ func (r repository) CheckGroupExistById(ctx context.Context, org_id string, id s
 	filter := bson.M{"_id": orgId, "groups._id": groupId}
 
 	// Search for the group in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the group was found
 	if result.Err() == nil {
@@ -616,6 +721,9 @@ func (r repository) CheckGroupExistById(ctx context.Context, org_id string, id s
 // Check if group already assign to user by id.
 func (r repository) CheckGroupAlreadyAssignToRoleById(ctx context.Context, org_id string, role_id string, group_id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "role", "CheckGroupAlreadyAssignToRoleById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -635,7 +743,7 @@ func (r repository) CheckGroupAlreadyAssignToRoleById(ctx context.Context, org_i
 	projection := bson.M{"roles.$": 1}
 	org := mongo_entity.Organization{}
 	// Search for the role in the "organizations" collection
-	err = r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection)).Decode(&org)
+	err = r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org)
 	if err != nil {
 		return false, err
 	}
@@ -653,6 +761,9 @@ func (r repository) CheckGroupAlreadyAssignToRoleById(ctx context.Context, org_i
 
 func (r repository) resolveAssignedUsers(ctx context.Context, orgId primitive.ObjectID, userIDs []primitive.ObjectID) ([]mongo_entity.AssignedUser, error) {
 
+	ctx, span := telemetry.Repository(ctx, "role", "resolveAssignedUsers")
+	defer span.End()
+
 	pipeline := mongo.Pipeline{
 		bson.D{{Key: "$match", Value: bson.M{"_id": orgId}}},
 		bson.D{{Key: "$unwind", Value: "$users"}},
@@ -682,6 +793,9 @@ func (r repository) resolveAssignedUsers(ctx context.Context, orgId primitive.Ob
 
 func (r repository) resolveAssignedGroups(ctx context.Context, orgId primitive.ObjectID, groupIDs []primitive.ObjectID) ([]mongo_entity.AssignedGroup, error) {
 
+	ctx, span := telemetry.Repository(ctx, "role", "resolveAssignedGroups")
+	defer span.End()
+
 	pipeline := mongo.Pipeline{
 		bson.D{{Key: "$match", Value: bson.M{"_id": orgId}}},
 		bson.D{{Key: "$unwind", Value: "$groups"}},
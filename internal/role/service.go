@@ -3,7 +3,10 @@ package role
 import (
 	"context"
 
+	"github.com/shashimalcse/cronuseo/internal/limits"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/sod"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
 	"github.com/shashimalcse/cronuseo/internal/util"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
@@ -84,16 +87,21 @@ func (m UpdateRoleRequest) Validate() error {
 type service struct {
 	repo   Repository
 	logger *zap.Logger
+	quotas limits.Quotas
+	duties sod.Enforcer
 }
 
-func NewService(repo Repository, logger *zap.Logger) Service {
+func NewService(repo Repository, logger *zap.Logger, quotas limits.Quotas, duties sod.Enforcer) Service {
 
-	return service{repo: repo, logger: logger}
+	return service{repo: repo, logger: logger, quotas: quotas, duties: duties}
 }
 
 // Get role by id.
 func (s service) Get(ctx context.Context, org_id string, id string) (RoleResponse, error) {
 
+	ctx, span := telemetry.Start(ctx, "role.service.Get")
+	defer span.End()
+
 	role, err := s.repo.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Error("Error while getting the role.",
@@ -107,6 +115,9 @@ func (s service) Get(ctx context.Context, org_id string, id string) (RoleRespons
 // Get role by identifier.
 func (s service) GetRoleByIdentifier(ctx context.Context, org_id string, identifier string) (Role, error) {
 
+	ctx, span := telemetry.Start(ctx, "role.service.GetRoleByIdentifier")
+	defer span.End()
+
 	role, err := s.repo.GetRoleByIdentifier(ctx, org_id, identifier)
 	if err != nil {
 		s.logger.Error("Error while getting the role.",
@@ -120,10 +131,13 @@ func (s service) GetRoleByIdentifier(ctx context.Context, org_id string, identif
 // Create role.
 func (s service) Create(ctx context.Context, org_id string, req CreateRoleRequest) (RoleResponse, error) {
 
+	ctx, span := telemetry.Start(ctx, "role.service.Create")
+	defer span.End()
+
 	// Validate role request.
 	if err := req.Validate(); err != nil {
 		s.logger.Error("Error while validating role creation request.")
-		return RoleResponse{}, &util.InvalidInputError{Path: "Invalid input for role."}
+		return RoleResponse{}, util.NewValidationError("Invalid input for role.", err)
 	}
 
 	exists, _ := s.repo.CheckRoleExistsByIdentifier(ctx, org_id, req.Identifier)
@@ -133,20 +147,24 @@ func (s service) Create(ctx context.Context, org_id string, req CreateRoleReques
 
 	}
 
+	if err := s.quotas.CheckQuota(ctx, org_id, limits.QuotaRoles); err != nil {
+		return RoleResponse{}, err
+	}
+
 	// Generate role id.
 	roleId := primitive.NewObjectID()
 
 	for _, userId := range req.Users {
 		exists, _ := s.repo.CheckUserExistById(ctx, org_id, userId.Hex())
 		if !exists {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Invalid user id " + userId.String()}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Invalid user id " + userId.String()}
 		}
 	}
 
 	for _, groupId := range req.Groups {
 		exists, _ := s.repo.CheckGroupExistById(ctx, org_id, groupId.Hex())
 		if !exists {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Invalid group id " + groupId.String()}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Invalid group id " + groupId.String()}
 		}
 	}
 
@@ -154,7 +172,7 @@ func (s service) Create(ctx context.Context, org_id string, req CreateRoleReques
 
 		exists, _ := s.repo.CheckResourceActionExists(ctx, org_id, permission.Resource, permission.Action)
 		if !exists {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Invalid permission, Resource : " + permission.Resource + " Action : " + permission.Action}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Invalid permission, Resource : " + permission.Resource + " Action : " + permission.Action}
 		}
 	}
 
@@ -179,6 +197,13 @@ func (s service) Create(ctx context.Context, org_id string, req CreateRoleReques
 		permissions = req.Permissions
 	}
 
+	if err := s.duties.Enforce(ctx, org_id, sod.Change{
+		AddedUserRoles:  sod.Linked(users, roleId),
+		AddedGroupRoles: sod.Linked(groups, roleId),
+	}); err != nil {
+		return RoleResponse{}, err
+	}
+
 	err := s.repo.Create(ctx, org_id, mongo_entity.Role{
 		ID:          roleId,
 		Identifier:  req.Identifier,
@@ -200,6 +225,9 @@ func (s service) Create(ctx context.Context, org_id string, req CreateRoleReques
 // Update role.
 func (s service) Update(ctx context.Context, org_id string, id string, req UpdateRoleRequest) (RoleResponse, error) {
 
+	ctx, span := telemetry.Start(ctx, "role.service.Update")
+	defer span.End()
+
 	_, err := s.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Debug("Role not exists.", zap.String("role_id", id))
@@ -217,6 +245,9 @@ func (s service) Update(ctx context.Context, org_id string, id string, req Updat
 
 func (s service) Patch(ctx context.Context, org_id string, id string, req PatchRoleRequest) (RoleResponse, error) {
 
+	ctx, span := telemetry.Start(ctx, "role.service.Patch")
+	defer span.End()
+
 	_, err := s.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Debug("Role not exists.", zap.String("role_id", id))
@@ -226,28 +257,28 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchR
 	for _, userId := range req.AddedUsers {
 		exists, _ := s.repo.CheckUserExistById(ctx, org_id, userId.Hex())
 		if !exists {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Invalid role id " + userId.String()}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Invalid role id " + userId.String()}
 		}
 	}
 
 	for _, userId := range req.RemovedUsers {
 		exists, _ := s.repo.CheckUserExistById(ctx, org_id, userId.Hex())
 		if !exists {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Invalid role id " + userId.String()}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Invalid role id " + userId.String()}
 		}
 	}
 
 	for _, userId := range req.AddedUsers {
 		already_added, _ := s.repo.CheckUserAlreadyAssignToRoleById(ctx, org_id, id, userId.Hex())
 		if already_added {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Group : " + userId.Hex() + " already assigned to role :" + id}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Group : " + userId.Hex() + " already assigned to role :" + id}
 		}
 	}
 
 	for _, userId := range req.RemovedUsers {
 		already_added, _ := s.repo.CheckUserAlreadyAssignToRoleById(ctx, org_id, id, userId.Hex())
 		if !already_added {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Group : " + userId.Hex() + " not assigned to role :" + id}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Group : " + userId.Hex() + " not assigned to role :" + id}
 		}
 	}
 
@@ -255,27 +286,27 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchR
 	for _, groupId := range req.AddedGroups {
 		exists, _ := s.repo.CheckGroupExistById(ctx, org_id, groupId.Hex())
 		if !exists {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Invalid group id " + groupId.String()}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Invalid group id " + groupId.String()}
 		}
 	}
 	for _, groupId := range req.RemovedGroups {
 		exists, _ := s.repo.CheckGroupExistById(ctx, org_id, groupId.Hex())
 		if !exists {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Invalid group id " + groupId.String()}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Invalid group id " + groupId.String()}
 		}
 	}
 
 	for _, groupId := range req.AddedGroups {
 		already_added, _ := s.repo.CheckGroupAlreadyAssignToRoleById(ctx, org_id, id, groupId.Hex())
 		if already_added {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Group : " + groupId.Hex() + " already assigned to role :" + id}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Group : " + groupId.Hex() + " already assigned to role :" + id}
 		}
 	}
 
 	for _, groupId := range req.RemovedGroups {
 		already_added, _ := s.repo.CheckGroupAlreadyAssignToRoleById(ctx, org_id, id, groupId.Hex())
 		if !already_added {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Group : " + groupId.Hex() + " not assigned to role :" + id}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Group : " + groupId.Hex() + " not assigned to role :" + id}
 		}
 	}
 
@@ -284,12 +315,12 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchR
 
 		exists, _ := s.repo.CheckResourceActionExists(ctx, org_id, permission.Resource, permission.Action)
 		if !exists {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Invalid permission resource : " + permission.Resource + " action :" + permission.Action}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Invalid permission resource : " + permission.Resource + " action :" + permission.Action}
 		}
 
 		exists, _ = s.repo.CheckPermissionExists(ctx, org_id, id, permission.Resource, permission.Action)
 		if exists {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Invalid permission resource : " + permission.Resource + " action :" + permission.Action}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Invalid permission resource : " + permission.Resource + " action :" + permission.Action}
 		}
 
 	}
@@ -298,16 +329,26 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchR
 
 		exists, _ := s.repo.CheckResourceActionExists(ctx, org_id, permission.Resource, permission.Action)
 		if !exists {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Invalid permission resource : " + permission.Resource + " action :" + permission.Action}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Invalid permission resource : " + permission.Resource + " action :" + permission.Action}
 		}
 
 		exists, _ = s.repo.CheckPermissionExists(ctx, org_id, id, permission.Resource, permission.Action)
 		if !exists {
-			return RoleResponse{}, &util.InvalidInputError{Path: "Invalid permission resource : " + permission.Resource + " action :" + permission.Action}
+			return RoleResponse{}, &util.InvalidInputError{Message: "Invalid permission resource : " + permission.Resource + " action :" + permission.Action}
 		}
 
 	}
 
+	roleId, _ := primitive.ObjectIDFromHex(id)
+	if err := s.duties.Enforce(ctx, org_id, sod.Change{
+		AddedUserRoles:    sod.Linked(req.AddedUsers, roleId),
+		RemovedUserRoles:  sod.Linked(req.RemovedUsers, roleId),
+		AddedGroupRoles:   sod.Linked(req.AddedGroups, roleId),
+		RemovedGroupRoles: sod.Linked(req.RemovedGroups, roleId),
+	}); err != nil {
+		return RoleResponse{}, err
+	}
+
 	if err := s.repo.Patch(ctx, org_id, id, PatchRole{
 		AddedUsers:         req.AddedUsers,
 		RemovedUsers:       req.RemovedUsers,
@@ -325,6 +366,9 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchR
 // Delete role.
 func (s service) Delete(ctx context.Context, org_id string, id string) error {
 
+	ctx, span := telemetry.Start(ctx, "role.service.Delete")
+	defer span.End()
+
 	_, err := s.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Error("Resource not exists.", zap.String("resource_id", id))
@@ -343,11 +387,17 @@ type Filter struct {
 	Cursor int    `json:"cursor" query:"cursor"`
 	Limit  int    `json:"limit" query:"limit"`
 	Name   string `json:"name" query:"name"`
+
+	// Include the roles inherited from ancestor organizations.
+	Inherited bool `json:"inherited" query:"inherited"`
 }
 
 // Get all roles.
 func (s service) Query(ctx context.Context, org_id string, filter Filter) ([]Role, error) {
 
+	ctx, span := telemetry.Start(ctx, "role.service.Query")
+	defer span.End()
+
 	result := []Role{}
 	items, err := s.repo.Query(ctx, org_id)
 	if err != nil {
@@ -359,12 +409,26 @@ func (s service) Query(ctx context.Context, org_id string, filter Filter) ([]Rol
 	for _, item := range *items {
 		result = append(result, Role{item})
 	}
+	if filter.Inherited {
+		inherited, err := s.repo.QueryInherited(ctx, org_id)
+		if err != nil {
+			s.logger.Error("Error while retrieving inherited roles.",
+				zap.String("organization_id", org_id))
+			return []Role{}, err
+		}
+		for _, item := range inherited {
+			result = append(result, Role{item})
+		}
+	}
 	return result, err
 }
 
 // Get permissions.
 func (s service) GetPermissions(ctx context.Context, org_id string, role_id string) ([]mongo_entity.Permission, error) {
 
+	ctx, span := telemetry.Start(ctx, "role.service.GetPermissions")
+	defer span.End()
+
 	result := []mongo_entity.Permission{}
 	items, err := s.repo.GetPermissions(ctx, org_id, role_id)
 	if err != nil {
@@ -381,5 +445,8 @@ func (s service) GetPermissions(ctx context.Context, org_id string, role_id stri
 
 func (s service) CheckRoleExistsByIdentifier(ctx context.Context, org_id string, identifier string) (bool, error) {
 
+	ctx, span := telemetry.Start(ctx, "role.service.CheckRoleExistsByIdentifier")
+	defer span.End()
+
 	return s.repo.CheckRoleExistsByIdentifier(ctx, org_id, identifier)
 }
@@ -0,0 +1,360 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/util"
+)
+
+const contentType = "application/scim+json"
+
+func RegisterHandlers(r *echo.Group, service Service) {
+	res := resource{service}
+	r.Use(res.authenticate)
+	r.GET("/ServiceProviderConfig", res.serviceProviderConfig)
+	r.GET("/ResourceTypes", res.resourceTypes)
+	r.GET("/Schemas", res.schemas)
+	r.GET("/Users", res.queryUsers)
+	r.GET("/Users/:id", res.getUser)
+	r.POST("/Users", res.createUser)
+	r.PUT("/Users/:id", res.replaceUser)
+	r.PATCH("/Users/:id", res.patchUser)
+	r.DELETE("/Users/:id", res.deleteUser)
+	r.GET("/Groups", res.queryGroups)
+	r.GET("/Groups/:id", res.getGroup)
+	r.POST("/Groups", res.createGroup)
+	r.PUT("/Groups/:id", res.replaceGroup)
+	r.PATCH("/Groups/:id", res.patchGroup)
+	r.DELETE("/Groups/:id", res.deleteGroup)
+}
+
+type resource struct {
+	service Service
+}
+
+// authenticate resolves the organization from the bearer token, which can be
+// the organization API key or its dedicated SCIM token.
+func (r resource) authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := strings.TrimSpace(strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer "))
+		orgId, err := r.service.Authenticate(c.Request().Context(), token)
+		if err != nil {
+			return writeError(c, err)
+		}
+		c.Set("org_id", orgId)
+		return next(c)
+	}
+}
+
+func orgId(c echo.Context) string {
+	id, _ := c.Get("org_id").(string)
+	return id
+}
+
+func listQuery(c echo.Context) ListQuery {
+	startIndex, _ := strconv.Atoi(c.QueryParam("startIndex"))
+	query := ListQuery{Filter: c.QueryParam("filter"), StartIndex: startIndex}
+	if count, err := strconv.Atoi(c.QueryParam("count")); err == nil {
+		query.Count = &count
+	}
+	return query
+}
+
+// @Description SCIM service provider configuration.
+// @Tags        SCIM
+// @Produce     json
+// @Success     200
+// @failure     401
+// @Router      /scim/v2/ServiceProviderConfig [get]
+func (r resource) serviceProviderConfig(c echo.Context) error {
+
+	return write(c, http.StatusOK, serviceProviderConfig())
+}
+
+// @Description SCIM resource types.
+// @Tags        SCIM
+// @Produce     json
+// @Success     200
+// @failure     401
+// @Router      /scim/v2/ResourceTypes [get]
+func (r resource) resourceTypes(c echo.Context) error {
+
+	types := resourceTypes()
+	return write(c, http.StatusOK, ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: len(types),
+		StartIndex:   1,
+		ItemsPerPage: len(types),
+		Resources:    types,
+	})
+}
+
+// @Description SCIM schemas.
+// @Tags        SCIM
+// @Produce     json
+// @Success     200
+// @failure     401
+// @Router      /scim/v2/Schemas [get]
+func (r resource) schemas(c echo.Context) error {
+
+	schemas := schemas()
+	return write(c, http.StatusOK, ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: len(schemas),
+		StartIndex:   1,
+		ItemsPerPage: len(schemas),
+		Resources:    schemas,
+	})
+}
+
+// @Description Get all SCIM users.
+// @Tags        SCIM
+// @Param filter query string false "SCIM filter"
+// @Produce     json
+// @Success     200
+// @failure     400,401,500
+// @Router      /scim/v2/Users [get]
+func (r resource) queryUsers(c echo.Context) error {
+
+	users, err := r.service.QueryUsers(c.Request().Context(), orgId(c), listQuery(c))
+	if err != nil {
+		return writeError(c, err)
+	}
+	return write(c, http.StatusOK, users)
+}
+
+// @Description Get SCIM user by ID.
+// @Tags        SCIM
+// @Param id path string true "User ID"
+// @Produce     json
+// @Success     200
+// @failure     401,404,500
+// @Router      /scim/v2/Users/{id} [get]
+func (r resource) getUser(c echo.Context) error {
+
+	user, err := r.service.GetUser(c.Request().Context(), orgId(c), c.Param("id"))
+	if err != nil {
+		return writeError(c, err)
+	}
+	return write(c, http.StatusOK, user)
+}
+
+// @Description Provision SCIM user.
+// @Tags        SCIM
+// @Accept      json
+// @Produce     json
+// @Success     201
+// @failure     400,401,409,500
+// @Router      /scim/v2/Users [post]
+func (r resource) createUser(c echo.Context) error {
+
+	var input Resource
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return writeError(c, &Error{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "Invalid SCIM user."})
+	}
+	user, err := r.service.CreateUser(c.Request().Context(), orgId(c), input)
+	if err != nil {
+		return writeError(c, err)
+	}
+	return write(c, http.StatusCreated, user)
+}
+
+// @Description Replace SCIM user.
+// @Tags        SCIM
+// @Accept      json
+// @Param id path string true "User ID"
+// @Produce     json
+// @Success     200
+// @failure     400,401,404,500
+// @Router      /scim/v2/Users/{id} [put]
+func (r resource) replaceUser(c echo.Context) error {
+
+	var input Resource
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return writeError(c, &Error{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "Invalid SCIM user."})
+	}
+	user, err := r.service.ReplaceUser(c.Request().Context(), orgId(c), c.Param("id"), input)
+	if err != nil {
+		return writeError(c, err)
+	}
+	return write(c, http.StatusOK, user)
+}
+
+// @Description Patch SCIM user.
+// @Tags        SCIM
+// @Accept      json
+// @Param id path string true "User ID"
+// @Produce     json
+// @Success     200
+// @failure     400,401,404,500
+// @Router      /scim/v2/Users/{id} [patch]
+func (r resource) patchUser(c echo.Context) error {
+
+	var input PatchRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return writeError(c, &Error{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "Invalid SCIM patch request."})
+	}
+	user, err := r.service.PatchUser(c.Request().Context(), orgId(c), c.Param("id"), input)
+	if err != nil {
+		return writeError(c, err)
+	}
+	return write(c, http.StatusOK, user)
+}
+
+// @Description Deprovision SCIM user.
+// @Tags        SCIM
+// @Param id path string true "User ID"
+// @Success     204
+// @failure     401,404,500
+// @Router      /scim/v2/Users/{id} [delete]
+func (r resource) deleteUser(c echo.Context) error {
+
+	if err := r.service.DeleteUser(c.Request().Context(), orgId(c), c.Param("id")); err != nil {
+		return writeError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// @Description Get all SCIM groups.
+// @Tags        SCIM
+// @Param filter query string false "SCIM filter"
+// @Produce     json
+// @Success     200
+// @failure     400,401,500
+// @Router      /scim/v2/Groups [get]
+func (r resource) queryGroups(c echo.Context) error {
+
+	groups, err := r.service.QueryGroups(c.Request().Context(), orgId(c), listQuery(c))
+	if err != nil {
+		return writeError(c, err)
+	}
+	return write(c, http.StatusOK, groups)
+}
+
+// @Description Get SCIM group by ID.
+// @Tags        SCIM
+// @Param id path string true "Group ID"
+// @Produce     json
+// @Success     200
+// @failure     401,404,500
+// @Router      /scim/v2/Groups/{id} [get]
+func (r resource) getGroup(c echo.Context) error {
+
+	group, err := r.service.GetGroup(c.Request().Context(), orgId(c), c.Param("id"))
+	if err != nil {
+		return writeError(c, err)
+	}
+	return write(c, http.StatusOK, group)
+}
+
+// @Description Provision SCIM group.
+// @Tags        SCIM
+// @Accept      json
+// @Produce     json
+// @Success     201
+// @failure     400,401,409,500
+// @Router      /scim/v2/Groups [post]
+func (r resource) createGroup(c echo.Context) error {
+
+	var input Resource
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return writeError(c, &Error{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "Invalid SCIM group."})
+	}
+	group, err := r.service.CreateGroup(c.Request().Context(), orgId(c), input)
+	if err != nil {
+		return writeError(c, err)
+	}
+	return write(c, http.StatusCreated, group)
+}
+
+// @Description Replace SCIM group.
+// @Tags        SCIM
+// @Accept      json
+// @Param id path string true "Group ID"
+// @Produce     json
+// @Success     200
+// @failure     400,401,404,500
+// @Router      /scim/v2/Groups/{id} [put]
+func (r resource) replaceGroup(c echo.Context) error {
+
+	var input Resource
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return writeError(c, &Error{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "Invalid SCIM group."})
+	}
+	group, err := r.service.ReplaceGroup(c.Request().Context(), orgId(c), c.Param("id"), input)
+	if err != nil {
+		return writeError(c, err)
+	}
+	return write(c, http.StatusOK, group)
+}
+
+// @Description Patch SCIM group.
+// @Tags        SCIM
+// @Accept      json
+// @Param id path string true "Group ID"
+// @Produce     json
+// @Success     200
+// @failure     400,401,404,500
+// @Router      /scim/v2/Groups/{id} [patch]
+func (r resource) patchGroup(c echo.Context) error {
+
+	var input PatchRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&input); err != nil {
+		return writeError(c, &Error{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "Invalid SCIM patch request."})
+	}
+	group, err := r.service.PatchGroup(c.Request().Context(), orgId(c), c.Param("id"), input)
+	if err != nil {
+		return writeError(c, err)
+	}
+	return write(c, http.StatusOK, group)
+}
+
+// @Description Deprovision SCIM group.
+// @Tags        SCIM
+// @Param id path string true "Group ID"
+// @Success     204
+// @failure     401,404,500
+// @Router      /scim/v2/Groups/{id} [delete]
+func (r resource) deleteGroup(c echo.Context) error {
+
+	if err := r.service.DeleteGroup(c.Request().Context(), orgId(c), c.Param("id")); err != nil {
+		return writeError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func write(c echo.Context, status int, body interface{}) error {
+
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
+	c.Response().WriteHeader(status)
+	return json.NewEncoder(c.Response()).Encode(body)
+}
+
+// writeError renders an error in the SCIM error format.
+func writeError(c echo.Context, err error) error {
+
+	scimErr, ok := err.(*Error)
+	if !ok {
+		code, response := util.NewErrorResponse(err)
+		scimErr = &Error{Status: code, Detail: response.Message}
+		switch err.(type) {
+		case *util.AlreadyExistsError:
+			scimErr.ScimType = "uniqueness"
+		case *util.InvalidInputError:
+			scimErr.ScimType = "invalidValue"
+		}
+	}
+	body := map[string]interface{}{
+		"schemas": []string{ErrorSchema},
+		"status":  strconv.Itoa(scimErr.Status),
+		"detail":  scimErr.Detail,
+	}
+	if scimErr.ScimType != "" {
+		body["scimType"] = scimErr.ScimType
+	}
+	return write(c, scimErr.Status, body)
+}
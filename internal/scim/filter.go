@@ -0,0 +1,485 @@
+package scim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter is a parsed SCIM filter expression (RFC 7644 section 3.4.2.2).
+type Filter interface {
+	Match(resource map[string]interface{}) bool
+}
+
+type logicalFilter struct {
+	op          string
+	left, right Filter
+}
+
+type notFilter struct {
+	inner Filter
+}
+
+type attributeFilter struct {
+	path  []string
+	op    string
+	value interface{}
+}
+
+type valuePathFilter struct {
+	path   []string
+	filter Filter
+}
+
+// ParseFilter parses a SCIM filter expression.
+func ParseFilter(input string) (Filter, error) {
+
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected token %q", p.peek().value)
+	}
+	return filter, nil
+}
+
+func (f logicalFilter) Match(resource map[string]interface{}) bool {
+
+	if f.op == "and" {
+		return f.left.Match(resource) && f.right.Match(resource)
+	}
+	return f.left.Match(resource) || f.right.Match(resource)
+}
+
+func (f notFilter) Match(resource map[string]interface{}) bool {
+
+	return !f.inner.Match(resource)
+}
+
+func (f attributeFilter) Match(resource map[string]interface{}) bool {
+
+	values := lookup(resource, f.path)
+	if f.op == "pr" {
+		for _, value := range values {
+			if !isEmpty(value) {
+				return true
+			}
+		}
+		return false
+	}
+	if f.op == "ne" {
+		for _, value := range values {
+			if compare(value, "eq", f.value) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, value := range values {
+		if compare(value, f.op, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f valuePathFilter) Match(resource map[string]interface{}) bool {
+
+	for _, value := range lookup(resource, f.path) {
+		if item, ok := value.(map[string]interface{}); ok && f.filter.Match(item) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup resolves an attribute path against a resource. Multi-valued
+// attributes are flattened so that a filter matches if any value matches.
+func lookup(resource map[string]interface{}, path []string) []interface{} {
+
+	current := []interface{}{resource}
+	for _, segment := range path {
+		next := []interface{}{}
+		for _, item := range current {
+			object, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			value, found := getCaseInsensitive(object, segment)
+			if !found {
+				continue
+			}
+			if values, ok := value.([]interface{}); ok {
+				next = append(next, values...)
+			} else {
+				next = append(next, value)
+			}
+		}
+		current = next
+	}
+	return current
+}
+
+func getCaseInsensitive(object map[string]interface{}, key string) (interface{}, bool) {
+
+	if value, ok := object[key]; ok {
+		return value, true
+	}
+	for k, value := range object {
+		if strings.EqualFold(k, key) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func isEmpty(value interface{}) bool {
+
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+func compare(actual interface{}, op string, expected interface{}) bool {
+
+	switch e := expected.(type) {
+	case string:
+		a, ok := actual.(string)
+		if !ok {
+			return false
+		}
+		a, e = strings.ToLower(a), strings.ToLower(e)
+		switch op {
+		case "eq":
+			return a == e
+		case "co":
+			return strings.Contains(a, e)
+		case "sw":
+			return strings.HasPrefix(a, e)
+		case "ew":
+			return strings.HasSuffix(a, e)
+		case "gt":
+			return a > e
+		case "ge":
+			return a >= e
+		case "lt":
+			return a < e
+		case "le":
+			return a <= e
+		}
+	case bool:
+		a, ok := actual.(bool)
+		return ok && op == "eq" && a == e
+	case float64:
+		a, ok := toFloat(actual)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "eq":
+			return a == e
+		case "gt":
+			return a > e
+		case "ge":
+			return a >= e
+		case "lt":
+			return a < e
+		case "le":
+			return a <= e
+		}
+	case nil:
+		return op == "eq" && actual == nil
+	}
+	return false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// splitPath splits an attribute path into its segments. The core schema URN
+// prefix is dropped and extension URNs are kept as a single segment.
+func splitPath(path string) []string {
+
+	if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		idx := strings.LastIndex(path, ":")
+		schema, attribute := path[:idx], path[idx+1:]
+		for _, core := range []string{UserSchema, GroupSchema} {
+			if strings.EqualFold(schema, core) {
+				return strings.Split(attribute, ".")
+			}
+		}
+		return append([]string{schema}, strings.Split(attribute, ".")...)
+	}
+	return strings.Split(path, ".")
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenOpenParen
+	tokenCloseParen
+	tokenOpenBracket
+	tokenCloseBracket
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+func tokenize(input string) ([]token, error) {
+
+	tokens := []token{}
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenOpenParen, value: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenCloseParen, value: ")"})
+			i++
+		case r == '[':
+			tokens = append(tokens, token{kind: tokenOpenBracket, value: "["})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{kind: tokenCloseBracket, value: "]"})
+			i++
+		case r == '"':
+			var sb strings.Builder
+			i++
+			closed := false
+			for i < len(runes) {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					sb.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				if runes[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			tokens = append(tokens, token{kind: tokenString, value: sb.String()})
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("()[]\"", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, value: string(runes[start:i])})
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() (token, error) {
+
+	if p.done() {
+		return token{}, fmt.Errorf("unexpected end of filter")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *parser) peekKeyword(keyword string) bool {
+	return !p.done() && p.peek().kind == tokenWord && strings.EqualFold(p.peek().value, keyword)
+}
+
+func (p *parser) parseOr() (Filter, error) {
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalFilter{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalFilter{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Filter, error) {
+
+	if p.peekKeyword("not") {
+		p.pos++
+		inner, err := p.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{inner: inner}, nil
+	}
+	if !p.done() && p.peek().kind == tokenOpenParen {
+		return p.parseGroup()
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseGroup() (Filter, error) {
+
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokenOpenParen {
+		return nil, fmt.Errorf("expected '(' but found %q", t.value)
+	}
+	inner, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	t, err = p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokenCloseParen {
+		return nil, fmt.Errorf("expected ')' but found %q", t.value)
+	}
+	return inner, nil
+}
+
+func (p *parser) parseComparison() (Filter, error) {
+
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokenWord {
+		return nil, fmt.Errorf("expected attribute path but found %q", t.value)
+	}
+	path := splitPath(t.value)
+
+	if !p.done() && p.peek().kind == tokenOpenBracket {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if closing.kind != tokenCloseBracket {
+			return nil, fmt.Errorf("expected ']' but found %q", closing.value)
+		}
+		return valuePathFilter{path: path, filter: inner}, nil
+	}
+
+	opToken, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	op := strings.ToLower(opToken.value)
+	switch op {
+	case "pr":
+		return attributeFilter{path: path, op: op}, nil
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, fmt.Errorf("unsupported filter operator %q", opToken.value)
+	}
+
+	valueToken, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	value, err := parseValue(valueToken)
+	if err != nil {
+		return nil, err
+	}
+	return attributeFilter{path: path, op: op, value: value}, nil
+}
+
+func parseValue(t token) (interface{}, error) {
+
+	if t.kind == tokenString {
+		return t.value, nil
+	}
+	if t.kind != tokenWord {
+		return nil, fmt.Errorf("expected comparison value but found %q", t.value)
+	}
+	switch strings.ToLower(t.value) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	number, err := strconv.ParseFloat(t.value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid comparison value %q", t.value)
+	}
+	return number, nil
+}
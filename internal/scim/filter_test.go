@@ -0,0 +1,154 @@
+package scim
+
+import (
+	"testing"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func testUser() Resource {
+	return Resource{
+		"userName":    "bjensen@example.com",
+		"displayName": "Babs Jensen",
+		"active":      true,
+		"name":        map[string]interface{}{"givenName": "Barbara", "familyName": "Jensen"},
+		"emails": []interface{}{
+			map[string]interface{}{"value": "bjensen@example.com", "type": "work", "primary": true},
+			map[string]interface{}{"value": "babs@home.example", "type": "home"},
+		},
+		EnterpriseUserSchema: map[string]interface{}{"department": "Finance"},
+	}
+}
+
+func TestFilter(t *testing.T) {
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{`userName eq "BJENSEN@example.com"`, true},
+		{`userName ne "bjensen@example.com"`, false},
+		{`name.familyName co "ens"`, true},
+		{`userName sw "bj" and active eq true`, true},
+		{`userName sw "x" or displayName ew "Jensen"`, true},
+		{`not (active eq true)`, false},
+		{`title pr`, false},
+		{`emails[type eq "home" and value co "babs"]`, true},
+		{`emails.value eq "babs@home.example"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bjensen@example.com"`, true},
+		{`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department eq "finance"`, true},
+	}
+	for _, tc := range tests {
+		filter, err := ParseFilter(tc.filter)
+		assert.Nil(t, err, tc.filter)
+		assert.Equal(t, tc.want, filter.Match(testUser()), tc.filter)
+	}
+
+	_, err := ParseFilter(`userName eq`)
+	assert.NotNil(t, err)
+	_, err = ParseFilter(`userName xx "a"`)
+	assert.NotNil(t, err)
+	_, err = ParseFilter(`(userName eq "a"`)
+	assert.NotNil(t, err)
+}
+
+func TestApplyPatch(t *testing.T) {
+
+	resource := testUser()
+	err := applyPatch(resource, []PatchOperation{
+		{Op: "Replace", Value: map[string]interface{}{"active": false}},
+		{Op: "replace", Path: `emails[type eq "work"].value`, Value: "barbara@example.com"},
+		{Op: "add", Path: "name.middleName", Value: "Jane"},
+		{Op: "remove", Path: `emails[type eq "home"]`},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, false, resource["active"])
+	assert.Equal(t, "Jane", resource["name"].(map[string]interface{})["middleName"])
+	emails := resource["emails"].([]interface{})
+	assert.Equal(t, 1, len(emails))
+	assert.Equal(t, "barbara@example.com", emails[0].(map[string]interface{})["value"])
+
+	group := Resource{"displayName": "finance", "members": []interface{}{
+		map[string]interface{}{"value": "a"},
+		map[string]interface{}{"value": "b"},
+	}}
+	err = applyPatch(group, []PatchOperation{
+		{Op: "add", Path: "members", Value: []interface{}{map[string]interface{}{"value": "c"}, map[string]interface{}{"value": "a"}}},
+		{Op: "remove", Path: `members[value eq "b"]`},
+		{Op: "remove", Path: "members", Value: []interface{}{map[string]interface{}{"value": "c"}}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a"}, memberIds(group))
+
+	err = applyPatch(group, []PatchOperation{{Op: "remove"}})
+	assert.NotNil(t, err)
+}
+
+func TestUserMapping(t *testing.T) {
+
+	userName, displayName, properties := resourceToUser(testUser())
+	assert.Equal(t, "bjensen@example.com", userName)
+	assert.Equal(t, "Babs Jensen", displayName)
+	assert.Equal(t, "Barbara", properties["given_name"])
+	assert.Equal(t, "bjensen@example.com", properties["email"])
+	assert.Equal(t, "Finance", properties["department"])
+	assert.Equal(t, true, properties["active"])
+
+	resource := userToResource("id", userName, displayName, properties, nil)
+	assert.Equal(t, "Jensen", resource["name"].(map[string]interface{})["familyName"])
+	assert.Equal(t, "bjensen@example.com", primaryValue(lookup(resource, []string{"emails"})))
+}
+
+func TestUserDeactivation(t *testing.T) {
+
+	resource := testUser()
+	resource["active"] = false
+	_, _, properties := resourceToUser(resource)
+	assert.Equal(t, false, userToResource("id", "bjensen", "Babs", properties, nil)["active"])
+	assert.False(t, mongo_entity.User{UserProperties: properties}.Active())
+
+	// users without the property are active
+	assert.Equal(t, true, userToResource("id", "bjensen", "Babs", map[string]interface{}{}, nil)["active"])
+	assert.True(t, mongo_entity.User{}.Active())
+}
+
+func TestPaginate(t *testing.T) {
+
+	resources := []Resource{testUser(), testUser(), testUser()}
+	count := func(n int) *int { return &n }
+
+	// count 0 only returns the total results
+	list, err := paginate(resources, ListQuery{Count: count(0)})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, list.TotalResults)
+	assert.Equal(t, 0, list.ItemsPerPage)
+	assert.Empty(t, list.Resources)
+	list, _ = paginate(resources, ListQuery{Count: count(-1)})
+	assert.Empty(t, list.Resources)
+
+	list, _ = paginate(resources, ListQuery{})
+	assert.Equal(t, 3, list.ItemsPerPage)
+	list, _ = paginate(resources, ListQuery{StartIndex: 2, Count: count(1)})
+	assert.Equal(t, 1, list.ItemsPerPage)
+	assert.Equal(t, 2, list.StartIndex)
+	list, _ = paginate(resources, ListQuery{Count: count(maxResults + 1)})
+	assert.Equal(t, 3, list.ItemsPerPage)
+}
+
+func TestGroupResources(t *testing.T) {
+
+	jane := mongo_entity.User{ID: primitive.NewObjectID(), Identifier: "jane@example.com", Username: "jane"}
+	org := &mongo_entity.Organization{
+		Users:  []mongo_entity.User{jane},
+		Groups: []mongo_entity.Group{{ID: primitive.NewObjectID(), Identifier: "sales", DisplayName: "Sales", Users: []primitive.ObjectID{jane.ID, primitive.NewObjectID()}}},
+	}
+	resources := groupResources(org)
+	assert.Len(t, resources, 1)
+	assert.Equal(t, "Sales", resources[0]["displayName"])
+	members := resources[0]["members"].([]interface{})
+	assert.Len(t, members, 1)
+	assert.Equal(t, "jane", members[0].(map[string]interface{})["display"])
+	assert.Equal(t, []string{jane.ID.Hex()}, memberIds(resources[0]))
+}
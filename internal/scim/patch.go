@@ -0,0 +1,210 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// patchPath is a parsed PATCH operation path such as `members`,
+// `name.givenName` or `emails[type eq "work"].value`.
+type patchPath struct {
+	attribute []string
+	filter    Filter
+	subAttr   string
+}
+
+func parsePatchPath(path string) (patchPath, error) {
+
+	open := strings.Index(path, "[")
+	if open < 0 {
+		return patchPath{attribute: splitPath(path)}, nil
+	}
+	close := strings.LastIndex(path, "]")
+	if close < open {
+		return patchPath{}, fmt.Errorf("invalid path %q", path)
+	}
+	filter, err := ParseFilter(path[open+1 : close])
+	if err != nil {
+		return patchPath{}, err
+	}
+	return patchPath{
+		attribute: splitPath(path[:open]),
+		filter:    filter,
+		subAttr:   strings.TrimPrefix(path[close+1:], "."),
+	}, nil
+}
+
+// applyPatch applies SCIM PATCH operations (RFC 7644 section 3.5.2) to a
+// resource in place.
+func applyPatch(resource Resource, operations []PatchOperation) error {
+
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		if operation.Path == "" {
+			if op == "remove" {
+				return fmt.Errorf("remove operation requires a path")
+			}
+			values, ok := operation.Value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s operation without a path requires an object value", op)
+			}
+			for key, value := range values {
+				if err := applyOperation(resource, op, patchPath{attribute: splitPath(key)}, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		path, err := parsePatchPath(operation.Path)
+		if err != nil {
+			return err
+		}
+		if err := applyOperation(resource, op, path, operation.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyOperation(resource Resource, op string, path patchPath, value interface{}) error {
+
+	parent, key := parentOf(resource, path.attribute, op != "remove")
+	if parent == nil {
+		return nil
+	}
+
+	if path.filter != nil {
+		items, _ := parent[key].([]interface{})
+		remaining := []interface{}{}
+		for _, item := range items {
+			element, ok := item.(map[string]interface{})
+			if !ok || !path.filter.Match(element) {
+				remaining = append(remaining, item)
+				continue
+			}
+			switch op {
+			case "add", "replace":
+				if path.subAttr != "" {
+					element[resolveKey(element, path.subAttr)] = value
+				} else if object, ok := value.(map[string]interface{}); ok {
+					for k, v := range object {
+						element[resolveKey(element, k)] = v
+					}
+				}
+				remaining = append(remaining, element)
+			case "remove":
+				if path.subAttr != "" {
+					delete(element, resolveKey(element, path.subAttr))
+					remaining = append(remaining, element)
+				}
+			default:
+				return fmt.Errorf("unsupported patch operation %q", op)
+			}
+		}
+		parent[key] = remaining
+		return nil
+	}
+
+	switch op {
+	case "add":
+		existing, exists := parent[key]
+		switch current := existing.(type) {
+		case []interface{}:
+			if values, ok := value.([]interface{}); ok && exists {
+				parent[key] = appendUnique(current, values)
+				return nil
+			}
+		case map[string]interface{}:
+			if object, ok := value.(map[string]interface{}); ok && exists {
+				for k, v := range object {
+					current[resolveKey(current, k)] = v
+				}
+				return nil
+			}
+		}
+		parent[key] = value
+	case "replace":
+		parent[key] = value
+	case "remove":
+		// Some providers send the members to remove as the operation value
+		// instead of using a value filter in the path.
+		if current, ok := parent[key].([]interface{}); ok && value != nil {
+			parent[key] = removeValues(current, value)
+			return nil
+		}
+		delete(parent, key)
+	default:
+		return fmt.Errorf("unsupported patch operation %q", op)
+	}
+	return nil
+}
+
+// parentOf walks the attribute path and returns the object holding the last
+// segment together with the key to use within it.
+func parentOf(resource Resource, attribute []string, create bool) (map[string]interface{}, string) {
+
+	current := map[string]interface{}(resource)
+	for _, segment := range attribute[:len(attribute)-1] {
+		key := resolveKey(current, segment)
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			if !create {
+				return nil, ""
+			}
+			next = map[string]interface{}{}
+			current[key] = next
+		}
+		current = next
+	}
+	return current, resolveKey(current, attribute[len(attribute)-1])
+}
+
+func appendUnique(current []interface{}, values []interface{}) []interface{} {
+
+	seen := map[string]bool{}
+	for _, item := range current {
+		if object, ok := item.(map[string]interface{}); ok {
+			if value, ok := object["value"].(string); ok {
+				seen[value] = true
+			}
+		}
+	}
+	for _, item := range values {
+		if object, ok := item.(map[string]interface{}); ok {
+			if value, ok := object["value"].(string); ok {
+				if seen[value] {
+					continue
+				}
+				seen[value] = true
+			}
+		}
+		current = append(current, item)
+	}
+	return current
+}
+
+func removeValues(current []interface{}, value interface{}) []interface{} {
+
+	removed := map[string]bool{}
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+	for _, item := range values {
+		if object, ok := item.(map[string]interface{}); ok {
+			if v, ok := object["value"].(string); ok {
+				removed[v] = true
+			}
+		}
+	}
+	remaining := []interface{}{}
+	for _, item := range current {
+		if object, ok := item.(map[string]interface{}); ok {
+			if v, ok := object["value"].(string); ok && removed[v] {
+				continue
+			}
+		}
+		remaining = append(remaining, item)
+	}
+	return remaining
+}
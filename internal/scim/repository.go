@@ -0,0 +1,79 @@
+package scim
+
+import (
+	"context"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/apikey"
+	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Repository interface {
+	GetOrganizationByToken(ctx context.Context, token string) (*mongo_entity.Organization, error)
+	GetGroups(ctx context.Context, org_id string) (*mongo_entity.Organization, error)
+}
+
+type repository struct {
+	mongoClient *mongo.Client
+	mongoColl   *mongo.Collection
+}
+
+func NewRepository(mongodb *db.MongoDB) Repository {
+
+	orgCollection := mongodb.MongoClient.Database(mongodb.MongoConfig.DBName).Collection(mongodb.MongoConfig.OrganizationCollectionName)
+
+	return repository{mongoClient: mongodb.MongoClient, mongoColl: orgCollection}
+}
+
+// Get the organization owning the given SCIM token or sync scoped API key.
+func (r repository) GetOrganizationByToken(ctx context.Context, token string) (*mongo_entity.Organization, error) {
+
+	ctx, span := telemetry.Repository(ctx, "scim", "GetOrganizationByToken")
+	defer span.End()
+
+	now := time.Now().UTC()
+	filter := bson.M{"api_keys": apikey.Match(token, apikey.ScopeSync, now)}
+	projection := bson.M{"resources": 0, "users": 0, "roles": 0, "groups": 0, "policies": 0}
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	var org mongo_entity.Organization
+	if err := result.Decode(&org); err != nil {
+		return nil, err
+	}
+	// Usage tracking is best effort and does not fail authentication.
+	_ = apikey.Touch(ctx, r.mongoColl, bson.M{"_id": org.ID}, token, now)
+	return &org, nil
+}
+
+// GetGroups returns the groups of the organization and the ids, identifiers
+// and usernames of its users, so groups are listed with one read.
+func (r repository) GetGroups(ctx context.Context, org_id string) (*mongo_entity.Organization, error) {
+
+	ctx, span := telemetry.Repository(ctx, "scim", "GetGroups")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	projection := bson.M{"groups": 1, "users._id": 1, "users.identifier": 1, "users.username": 1}
+	var org mongo_entity.Organization
+	err = r.mongoColl.FindOne(ctx, bson.M{"_id": orgId}, options.FindOne().SetProjection(projection)).Decode(&org)
+	if err == mongo.ErrNoDocuments {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
@@ -0,0 +1,442 @@
+package scim
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/shashimalcse/cronuseo/internal/group"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	UserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	EnterpriseUserSchema        = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	ListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ResourceTypeSchema          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+)
+
+// Resource is the JSON representation of a SCIM resource.
+type Resource map[string]interface{}
+
+type ListResponse struct {
+	Schemas      []string   `json:"schemas"`
+	TotalResults int        `json:"totalResults"`
+	StartIndex   int        `json:"startIndex"`
+	ItemsPerPage int        `json:"itemsPerPage"`
+	Resources    []Resource `json:"Resources"`
+}
+
+// ListQuery is a SCIM list request. Count is nil when no count is requested,
+// zero only returns the total results.
+type ListQuery struct {
+	Filter     string
+	StartIndex int
+	Count      *int
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// propertyMapping binds a single-valued SCIM attribute to a key in
+// mongo_entity.User.UserProperties.
+type propertyMapping struct {
+	path     []string
+	property string
+}
+
+var userPropertyMappings = []propertyMapping{
+	{path: []string{"externalId"}, property: "external_id"},
+	{path: []string{"name", "formatted"}, property: "formatted_name"},
+	{path: []string{"name", "givenName"}, property: "given_name"},
+	{path: []string{"name", "familyName"}, property: "family_name"},
+	{path: []string{"name", "middleName"}, property: "middle_name"},
+	{path: []string{"nickName"}, property: "nick_name"},
+	{path: []string{"title"}, property: "title"},
+	{path: []string{"userType"}, property: "user_type"},
+	{path: []string{"preferredLanguage"}, property: "preferred_language"},
+	{path: []string{"locale"}, property: "locale"},
+	{path: []string{"timezone"}, property: "timezone"},
+	{path: []string{"active"}, property: "active"},
+	{path: []string{EnterpriseUserSchema, "employeeNumber"}, property: "employee_number"},
+	{path: []string{EnterpriseUserSchema, "costCenter"}, property: "cost_center"},
+	{path: []string{EnterpriseUserSchema, "organization"}, property: "organization"},
+	{path: []string{EnterpriseUserSchema, "division"}, property: "division"},
+	{path: []string{EnterpriseUserSchema, "department"}, property: "department"},
+	{path: []string{EnterpriseUserSchema, "manager", "value"}, property: "manager"},
+}
+
+// Multi-valued attributes whose primary value is kept as a user property.
+var userMultiValuedMappings = []propertyMapping{
+	{path: []string{"emails"}, property: "email"},
+	{path: []string{"phoneNumbers"}, property: "phone_number"},
+}
+
+// isMappedProperty reports whether a user property is owned by SCIM.
+func isMappedProperty(property string) bool {
+
+	for _, mapping := range append(userPropertyMappings, userMultiValuedMappings...) {
+		if mapping.property == property {
+			return true
+		}
+	}
+	return false
+}
+
+func userToResource(id string, userName string, displayName string, properties map[string]interface{}, groups []mongo_entity.AssignedGroup) Resource {
+
+	resource := Resource{
+		"schemas":     []interface{}{UserSchema, EnterpriseUserSchema},
+		"id":          id,
+		"userName":    userName,
+		"displayName": displayName,
+		"active":      mongo_entity.User{UserProperties: properties}.Active(),
+		"meta": map[string]interface{}{
+			"resourceType": "User",
+			"location":     "/scim/v2/Users/" + id,
+		},
+	}
+	for _, mapping := range userPropertyMappings {
+		if mapping.property == "active" {
+			continue
+		}
+		if value, ok := properties[mapping.property]; ok && value != nil {
+			setPath(resource, mapping.path, value)
+		}
+	}
+	for _, mapping := range userMultiValuedMappings {
+		if value, ok := properties[mapping.property].(string); ok && value != "" {
+			resource[mapping.path[0]] = []interface{}{
+				map[string]interface{}{"value": value, "primary": true},
+			}
+		}
+	}
+	if groups != nil {
+		members := []interface{}{}
+		for _, g := range groups {
+			members = append(members, map[string]interface{}{
+				"value":   g.ID.Hex(),
+				"display": g.DisplayName,
+				"$ref":    "/scim/v2/Groups/" + g.ID.Hex(),
+			})
+		}
+		resource["groups"] = members
+	}
+	return resource
+}
+
+func userResponseToResource(u user.UserResponse) Resource {
+
+	return userToResource(u.ID.Hex(), u.Identifier, u.Username, u.UserProperties, u.Groups)
+}
+
+// resourceToUser extracts the cronuseo view of a SCIM user. Only the
+// properties owned by SCIM are returned.
+func resourceToUser(resource Resource) (string, string, map[string]interface{}) {
+
+	userName, _ := getString(resource, "userName")
+	displayName, _ := getString(resource, "displayName")
+	properties := map[string]interface{}{}
+	for _, mapping := range userPropertyMappings {
+		values := lookup(resource, mapping.path)
+		if len(values) == 0 || values[0] == nil {
+			continue
+		}
+		value := values[0]
+		if mapping.property == "active" {
+			value = toBool(value)
+		}
+		properties[mapping.property] = value
+	}
+	for _, mapping := range userMultiValuedMappings {
+		if value := primaryValue(lookup(resource, mapping.path)); value != "" {
+			properties[mapping.property] = value
+		}
+	}
+	return userName, displayName, properties
+}
+
+func groupToResource(g group.GroupResponse) Resource {
+
+	members := []interface{}{}
+	for _, u := range g.Users {
+		members = append(members, map[string]interface{}{
+			"value":   u.ID.Hex(),
+			"display": u.Username,
+			"type":    "User",
+			"$ref":    "/scim/v2/Users/" + u.ID.Hex(),
+		})
+	}
+	return Resource{
+		"schemas":     []interface{}{GroupSchema},
+		"id":          g.ID.Hex(),
+		"externalId":  g.Identifier,
+		"displayName": g.DisplayName,
+		"members":     members,
+		"meta": map[string]interface{}{
+			"resourceType": "Group",
+			"location":     "/scim/v2/Groups/" + g.ID.Hex(),
+		},
+	}
+}
+
+// groupResources returns the resources of the groups of the organization,
+// with the usernames of their members from the users of the organization.
+func groupResources(org *mongo_entity.Organization) []Resource {
+
+	users := map[primitive.ObjectID]mongo_entity.User{}
+	for _, u := range org.Users {
+		users[u.ID] = u
+	}
+	resources := []Resource{}
+	for _, g := range org.Groups {
+		response := group.GroupResponse{ID: g.ID, Identifier: g.Identifier, DisplayName: g.DisplayName}
+		for _, id := range g.Users {
+			if u, ok := users[id]; ok {
+				response.Users = append(response.Users, mongo_entity.AssignedUser{ID: u.ID, Username: u.Username, Identifier: u.Identifier})
+			}
+		}
+		resources = append(resources, groupToResource(response))
+	}
+	return resources
+}
+
+// memberIds returns the user ids referenced by the members attribute.
+func memberIds(resource Resource) []string {
+
+	ids := []string{}
+	for _, member := range lookup(resource, []string{"members", "value"}) {
+		if id, ok := member.(string); ok && id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func primaryValue(values []interface{}) string {
+
+	first := ""
+	for _, item := range values {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value, _ := object["value"].(string)
+		if toBool(object["primary"]) {
+			return value
+		}
+		if first == "" {
+			first = value
+		}
+	}
+	return first
+}
+
+func getString(resource map[string]interface{}, key string) (string, bool) {
+
+	value, ok := getCaseInsensitive(resource, key)
+	if !ok {
+		return "", false
+	}
+	s, ok := value.(string)
+	return s, ok
+}
+
+func toBool(value interface{}) bool {
+
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(strings.ToLower(v))
+		return b
+	}
+	return false
+}
+
+func setPath(resource map[string]interface{}, path []string, value interface{}) {
+
+	current := resource
+	for _, segment := range path[:len(path)-1] {
+		key := resolveKey(current, segment)
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			current[key] = next
+		}
+		current = next
+	}
+	current[resolveKey(current, path[len(path)-1])] = value
+}
+
+// resolveKey returns the existing key matching name case-insensitively, or
+// name itself when the attribute is not present yet.
+func resolveKey(object map[string]interface{}, name string) string {
+
+	for key := range object {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
+
+func serviceProviderConfig() Resource {
+
+	return Resource{
+		"schemas":          []interface{}{ServiceProviderConfigSchema},
+		"documentationUri": "https://github.com/shashimalcse/cronuseo",
+		"patch":            map[string]interface{}{"supported": true},
+		"bulk":             map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           map[string]interface{}{"supported": true, "maxResults": maxResults},
+		"changePassword":   map[string]interface{}{"supported": false},
+		"sort":             map[string]interface{}{"supported": false},
+		"etag":             map[string]interface{}{"supported": false},
+		"authenticationSchemes": []interface{}{
+			map[string]interface{}{
+				"type":        "oauthbearertoken",
+				"name":        "Bearer token",
+				"description": "Organization API key or SCIM token sent as a bearer token.",
+				"primary":     true,
+			},
+		},
+		"meta": map[string]interface{}{
+			"resourceType": "ServiceProviderConfig",
+			"location":     "/scim/v2/ServiceProviderConfig",
+		},
+	}
+}
+
+func resourceTypes() []Resource {
+
+	return []Resource{
+		{
+			"schemas":  []interface{}{ResourceTypeSchema},
+			"id":       "User",
+			"name":     "User",
+			"endpoint": "/Users",
+			"schema":   UserSchema,
+			"schemaExtensions": []interface{}{
+				map[string]interface{}{"schema": EnterpriseUserSchema, "required": false},
+			},
+			"meta": map[string]interface{}{"resourceType": "ResourceType", "location": "/scim/v2/ResourceTypes/User"},
+		},
+		{
+			"schemas":  []interface{}{ResourceTypeSchema},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   GroupSchema,
+			"meta":     map[string]interface{}{"resourceType": "ResourceType", "location": "/scim/v2/ResourceTypes/Group"},
+		},
+	}
+}
+
+func attribute(name string, attrType string, multiValued bool, required bool, mutability string, subAttributes ...map[string]interface{}) map[string]interface{} {
+
+	attr := map[string]interface{}{
+		"name":        name,
+		"type":        attrType,
+		"multiValued": multiValued,
+		"required":    required,
+		"caseExact":   false,
+		"mutability":  mutability,
+		"returned":    "default",
+		"uniqueness":  "none",
+	}
+	if len(subAttributes) > 0 {
+		subs := []interface{}{}
+		for _, sub := range subAttributes {
+			subs = append(subs, sub)
+		}
+		attr["subAttributes"] = subs
+	}
+	return attr
+}
+
+func schemas() []Resource {
+
+	userName := attribute("userName", "string", false, true, "immutable")
+	userName["uniqueness"] = "server"
+	multiValue := func(name string) map[string]interface{} {
+		return attribute(name, "complex", true, false, "readWrite",
+			attribute("value", "string", false, false, "readWrite"),
+			attribute("type", "string", false, false, "readWrite"),
+			attribute("primary", "boolean", false, false, "readWrite"),
+		)
+	}
+	return []Resource{
+		{
+			"schemas": []interface{}{SchemaSchema},
+			"id":      UserSchema,
+			"name":    "User",
+			"attributes": []interface{}{
+				userName,
+				attribute("displayName", "string", false, false, "readWrite"),
+				attribute("name", "complex", false, false, "readWrite",
+					attribute("formatted", "string", false, false, "readWrite"),
+					attribute("givenName", "string", false, false, "readWrite"),
+					attribute("familyName", "string", false, false, "readWrite"),
+					attribute("middleName", "string", false, false, "readWrite"),
+				),
+				attribute("nickName", "string", false, false, "readWrite"),
+				attribute("title", "string", false, false, "readWrite"),
+				attribute("userType", "string", false, false, "readWrite"),
+				attribute("preferredLanguage", "string", false, false, "readWrite"),
+				attribute("locale", "string", false, false, "readWrite"),
+				attribute("timezone", "string", false, false, "readWrite"),
+				attribute("active", "boolean", false, false, "readWrite"),
+				multiValue("emails"),
+				multiValue("phoneNumbers"),
+				attribute("groups", "complex", true, false, "readOnly",
+					attribute("value", "string", false, false, "readOnly"),
+					attribute("display", "string", false, false, "readOnly"),
+				),
+			},
+			"meta": map[string]interface{}{"resourceType": "Schema", "location": "/scim/v2/Schemas/" + UserSchema},
+		},
+		{
+			"schemas": []interface{}{SchemaSchema},
+			"id":      EnterpriseUserSchema,
+			"name":    "EnterpriseUser",
+			"attributes": []interface{}{
+				attribute("employeeNumber", "string", false, false, "readWrite"),
+				attribute("costCenter", "string", false, false, "readWrite"),
+				attribute("organization", "string", false, false, "readWrite"),
+				attribute("division", "string", false, false, "readWrite"),
+				attribute("department", "string", false, false, "readWrite"),
+				attribute("manager", "complex", false, false, "readWrite",
+					attribute("value", "string", false, false, "readWrite"),
+				),
+			},
+			"meta": map[string]interface{}{"resourceType": "Schema", "location": "/scim/v2/Schemas/" + EnterpriseUserSchema},
+		},
+		{
+			"schemas": []interface{}{SchemaSchema},
+			"id":      GroupSchema,
+			"name":    "Group",
+			"attributes": []interface{}{
+				attribute("displayName", "string", false, true, "readWrite"),
+				attribute("members", "complex", true, false, "readWrite",
+					attribute("value", "string", false, false, "immutable"),
+					attribute("display", "string", false, false, "readOnly"),
+				),
+			},
+			"meta": map[string]interface{}{"resourceType": "Schema", "location": "/scim/v2/Schemas/" + GroupSchema},
+		},
+	}
+}
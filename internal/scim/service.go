@@ -0,0 +1,369 @@
+package scim
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/shashimalcse/cronuseo/internal/group"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/user"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const maxResults = 200
+
+type Service interface {
+	Authenticate(ctx context.Context, token string) (string, error)
+	GetUser(ctx context.Context, org_id string, id string) (Resource, error)
+	QueryUsers(ctx context.Context, org_id string, query ListQuery) (ListResponse, error)
+	CreateUser(ctx context.Context, org_id string, input Resource) (Resource, error)
+	ReplaceUser(ctx context.Context, org_id string, id string, input Resource) (Resource, error)
+	PatchUser(ctx context.Context, org_id string, id string, input PatchRequest) (Resource, error)
+	DeleteUser(ctx context.Context, org_id string, id string) error
+	GetGroup(ctx context.Context, org_id string, id string) (Resource, error)
+	QueryGroups(ctx context.Context, org_id string, query ListQuery) (ListResponse, error)
+	CreateGroup(ctx context.Context, org_id string, input Resource) (Resource, error)
+	ReplaceGroup(ctx context.Context, org_id string, id string, input Resource) (Resource, error)
+	PatchGroup(ctx context.Context, org_id string, id string, input PatchRequest) (Resource, error)
+	DeleteGroup(ctx context.Context, org_id string, id string) error
+}
+
+// Error is a SCIM protocol error (RFC 7644 section 3.12).
+type Error struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+type service struct {
+	repo         Repository
+	userService  user.Service
+	groupService group.Service
+	logger       *zap.Logger
+}
+
+func NewService(repo Repository, userService user.Service, groupService group.Service, logger *zap.Logger) Service {
+
+	return service{repo: repo, userService: userService, groupService: groupService, logger: logger}
+}
+
+// Authenticate resolves the organization id of a bearer token.
+func (s service) Authenticate(ctx context.Context, token string) (string, error) {
+
+	ctx, span := telemetry.Start(ctx, "scim.service.Authenticate")
+	defer span.End()
+
+	if token == "" {
+		return "", &util.UnauthorizedError{Message: "Missing SCIM bearer token."}
+	}
+	org, err := s.repo.GetOrganizationByToken(ctx, token)
+	if err != nil {
+		s.logger.Debug("Invalid SCIM bearer token.")
+		return "", &util.UnauthorizedError{Message: "Invalid SCIM bearer token."}
+	}
+	return org.ID.Hex(), nil
+}
+
+// Get user by id.
+func (s service) GetUser(ctx context.Context, org_id string, id string) (Resource, error) {
+
+	ctx, span := telemetry.Start(ctx, "scim.service.GetUser")
+	defer span.End()
+
+	u, err := s.userService.Get(ctx, org_id, id)
+	if err != nil {
+		return nil, err
+	}
+	return userResponseToResource(u), nil
+}
+
+// Query users with an optional SCIM filter.
+func (s service) QueryUsers(ctx context.Context, org_id string, query ListQuery) (ListResponse, error) {
+
+	ctx, span := telemetry.Start(ctx, "scim.service.QueryUsers")
+	defer span.End()
+
+	users, err := s.userService.Query(ctx, org_id, user.Filter{})
+	if err != nil {
+		return ListResponse{}, err
+	}
+	resources := []Resource{}
+	for _, u := range users {
+		resources = append(resources, userToResource(u.ID.Hex(), u.Identifier, u.Username, u.UserProperties, nil))
+	}
+	return paginate(resources, query)
+}
+
+// Create a user from a SCIM resource.
+func (s service) CreateUser(ctx context.Context, org_id string, input Resource) (Resource, error) {
+
+	ctx, span := telemetry.Start(ctx, "scim.service.CreateUser")
+	defer span.End()
+
+	userName, displayName, properties := resourceToUser(input)
+	if userName == "" {
+		return nil, &Error{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "userName is required."}
+	}
+	if _, err := s.userService.GetIdByIdentifier(ctx, org_id, userName); err == nil {
+		return nil, &Error{Status: http.StatusConflict, ScimType: "uniqueness", Detail: "User " + userName + " already exists."}
+	}
+	if displayName == "" {
+		displayName = userName
+	}
+	created, err := s.userService.Create(ctx, org_id, user.CreateUserRequest{
+		Username:       displayName,
+		Identifier:     userName,
+		UserProperties: properties,
+	})
+	if err != nil {
+		s.logger.Error("Error while provisioning SCIM user.", zap.String("organization_id", org_id))
+		return nil, err
+	}
+	return s.GetUser(ctx, org_id, created.ID.Hex())
+}
+
+// Replace a user with a SCIM resource. Properties not owned by SCIM are kept.
+func (s service) ReplaceUser(ctx context.Context, org_id string, id string, input Resource) (Resource, error) {
+
+	ctx, span := telemetry.Start(ctx, "scim.service.ReplaceUser")
+	defer span.End()
+
+	existing, err := s.userService.Get(ctx, org_id, id)
+	if err != nil {
+		return nil, err
+	}
+	userName, displayName, properties := resourceToUser(input)
+	if userName != "" && userName != existing.Identifier {
+		return nil, &Error{Status: http.StatusBadRequest, ScimType: "mutability", Detail: "userName cannot be changed."}
+	}
+	for key, value := range existing.UserProperties {
+		if !isMappedProperty(key) {
+			properties[key] = value
+		}
+	}
+	req := user.UpdateUserRequest{UserProperties: properties}
+	if displayName != "" && displayName != existing.Username {
+		req.Username = &displayName
+	}
+	if _, err := s.userService.Update(ctx, org_id, id, req); err != nil {
+		return nil, err
+	}
+	return s.GetUser(ctx, org_id, id)
+}
+
+// Patch a user with SCIM patch operations.
+func (s service) PatchUser(ctx context.Context, org_id string, id string, input PatchRequest) (Resource, error) {
+
+	ctx, span := telemetry.Start(ctx, "scim.service.PatchUser")
+	defer span.End()
+
+	resource, err := s.GetUser(ctx, org_id, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyPatch(resource, input.Operations); err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, ScimType: "invalidPath", Detail: err.Error()}
+	}
+	return s.ReplaceUser(ctx, org_id, id, resource)
+}
+
+// Delete user.
+func (s service) DeleteUser(ctx context.Context, org_id string, id string) error {
+
+	ctx, span := telemetry.Start(ctx, "scim.service.DeleteUser")
+	defer span.End()
+
+	return s.userService.Delete(ctx, org_id, id)
+}
+
+// Get group by id.
+func (s service) GetGroup(ctx context.Context, org_id string, id string) (Resource, error) {
+
+	ctx, span := telemetry.Start(ctx, "scim.service.GetGroup")
+	defer span.End()
+
+	g, err := s.groupService.Get(ctx, org_id, id)
+	if err != nil {
+		return nil, err
+	}
+	return groupToResource(g), nil
+}
+
+// Query groups with an optional SCIM filter.
+func (s service) QueryGroups(ctx context.Context, org_id string, query ListQuery) (ListResponse, error) {
+
+	ctx, span := telemetry.Start(ctx, "scim.service.QueryGroups")
+	defer span.End()
+
+	org, err := s.repo.GetGroups(ctx, org_id)
+	if err != nil {
+		return ListResponse{}, err
+	}
+	return paginate(groupResources(org), query)
+}
+
+// Create a group from a SCIM resource.
+func (s service) CreateGroup(ctx context.Context, org_id string, input Resource) (Resource, error) {
+
+	ctx, span := telemetry.Start(ctx, "scim.service.CreateGroup")
+	defer span.End()
+
+	displayName, _ := getString(input, "displayName")
+	if displayName == "" {
+		return nil, &Error{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "displayName is required."}
+	}
+	identifier, _ := getString(input, "externalId")
+	if identifier == "" {
+		identifier = displayName
+	}
+	members, err := toObjectIds(memberIds(input))
+	if err != nil {
+		return nil, err
+	}
+	created, err := s.groupService.Create(ctx, org_id, group.CreateGroupRequest{
+		Identifier:  identifier,
+		DisplayName: displayName,
+		Users:       members,
+	})
+	if err != nil {
+		s.logger.Error("Error while provisioning SCIM group.", zap.String("organization_id", org_id))
+		return nil, err
+	}
+	return s.GetGroup(ctx, org_id, created.ID.Hex())
+}
+
+// Replace a group with a SCIM resource.
+func (s service) ReplaceGroup(ctx context.Context, org_id string, id string, input Resource) (Resource, error) {
+
+	ctx, span := telemetry.Start(ctx, "scim.service.ReplaceGroup")
+	defer span.End()
+
+	existing, err := s.groupService.Get(ctx, org_id, id)
+	if err != nil {
+		return nil, err
+	}
+	displayName, _ := getString(input, "displayName")
+	if displayName != "" && displayName != existing.DisplayName {
+		if _, err := s.groupService.Update(ctx, org_id, id, group.UpdateGroupRequest{DisplayName: &displayName}); err != nil {
+			return nil, err
+		}
+	}
+
+	desired, err := toObjectIds(memberIds(input))
+	if err != nil {
+		return nil, err
+	}
+	current := map[primitive.ObjectID]bool{}
+	for _, u := range existing.Users {
+		current[u.ID] = true
+	}
+	wanted := map[primitive.ObjectID]bool{}
+	patch := group.PatchGroupRequest{}
+	for _, userId := range desired {
+		wanted[userId] = true
+		if !current[userId] {
+			patch.AddedUsers = append(patch.AddedUsers, userId)
+		}
+	}
+	for userId := range current {
+		if !wanted[userId] {
+			patch.RemovedUsers = append(patch.RemovedUsers, userId)
+		}
+	}
+	if len(patch.AddedUsers) > 0 || len(patch.RemovedUsers) > 0 {
+		if _, err := s.groupService.Patch(ctx, org_id, id, patch); err != nil {
+			return nil, err
+		}
+	}
+	return s.GetGroup(ctx, org_id, id)
+}
+
+// Patch a group with SCIM patch operations.
+func (s service) PatchGroup(ctx context.Context, org_id string, id string, input PatchRequest) (Resource, error) {
+
+	ctx, span := telemetry.Start(ctx, "scim.service.PatchGroup")
+	defer span.End()
+
+	resource, err := s.GetGroup(ctx, org_id, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyPatch(resource, input.Operations); err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, ScimType: "invalidPath", Detail: err.Error()}
+	}
+	return s.ReplaceGroup(ctx, org_id, id, resource)
+}
+
+// Delete group.
+func (s service) DeleteGroup(ctx context.Context, org_id string, id string) error {
+
+	ctx, span := telemetry.Start(ctx, "scim.service.DeleteGroup")
+	defer span.End()
+
+	return s.groupService.Delete(ctx, org_id, id)
+}
+
+func paginate(resources []Resource, query ListQuery) (ListResponse, error) {
+
+	if query.Filter != "" {
+		filter, err := ParseFilter(query.Filter)
+		if err != nil {
+			return ListResponse{}, &Error{Status: http.StatusBadRequest, ScimType: "invalidFilter", Detail: err.Error()}
+		}
+		matched := []Resource{}
+		for _, resource := range resources {
+			if filter.Match(resource) {
+				matched = append(matched, resource)
+			}
+		}
+		resources = matched
+	}
+
+	startIndex := query.StartIndex
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	// A count of zero, or negative, only returns the total results.
+	count := maxResults
+	if query.Count != nil && *query.Count < maxResults {
+		count = *query.Count
+	}
+	if count < 0 {
+		count = 0
+	}
+	page := []Resource{}
+	if startIndex <= len(resources) {
+		end := startIndex - 1 + count
+		if end > len(resources) {
+			end = len(resources)
+		}
+		page = resources[startIndex-1 : end]
+	}
+	return ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}, nil
+}
+
+func toObjectIds(ids []string) ([]primitive.ObjectID, error) {
+
+	objectIds := []primitive.ObjectID{}
+	for _, id := range ids {
+		objectId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return nil, &Error{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: fmt.Sprintf("Invalid member %s.", id)}
+		}
+		objectIds = append(objectIds, objectId)
+	}
+	return objectIds, nil
+}
@@ -0,0 +1,217 @@
+package serviceaccount
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/util"
+)
+
+func RegisterHandlers(r *echo.Group, service Service) {
+	res := resource{service}
+	router := r.Group("/o/:org_id/service-accounts")
+	router.GET("", res.query)
+	router.GET("/:id", res.get)
+	router.POST("", res.create)
+	router.PUT("/:id", res.update)
+	router.PATCH("/:id", res.patch)
+	router.DELETE("/:id", res.delete)
+	router.POST("/:id/rotate-secret", res.rotateSecret)
+}
+
+// RegisterTokenHandlers registers the public OAuth 2.0 token endpoint and the
+// JWKS of the issued tokens.
+func RegisterTokenHandlers(r *echo.Group, service Service) {
+	res := resource{service}
+	r.POST("/token", res.token)
+	r.GET("/jwks", res.jwks)
+}
+
+type resource struct {
+	service Service
+}
+
+// @Description Get all service accounts.
+// @Tags        Service Account
+// @Param org_id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {array}  ServiceAccount
+// @failure     404,500
+// @Router      /o/{org_id}/service-accounts [get]
+func (r resource) query(c echo.Context) error {
+
+	accounts, err := r.service.Query(c.Request().Context(), c.Param("org_id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, accounts)
+}
+
+// @Description Get service account by ID.
+// @Tags        Service Account
+// @Param org_id path string true "Organization ID"
+// @Param id path string true "Service account ID"
+// @Produce     json
+// @Success     200 {object}  ServiceAccount
+// @failure     404,500
+// @Router      /o/{org_id}/service-accounts/{id} [get]
+func (r resource) get(c echo.Context) error {
+
+	account, err := r.service.Get(c.Request().Context(), c.Param("org_id"), c.Param("id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, account)
+}
+
+// @Description Create service account. The client secret is only returned in this response.
+// @Tags        Service Account
+// @Accept      json
+// @Param org_id path string true "Organization ID"
+// @Param request body CreateServiceAccountRequest true "body"
+// @Produce     json
+// @Success     201 {object}  CreatedServiceAccount
+// @failure     400,404,409,500
+// @Router      /o/{org_id}/service-accounts [post]
+func (r resource) create(c echo.Context) error {
+
+	var input CreateServiceAccountRequest
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	account, err := r.service.Create(c.Request().Context(), c.Param("org_id"), input)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusCreated, account)
+}
+
+// @Description Update service account.
+// @Tags        Service Account
+// @Accept      json
+// @Param org_id path string true "Organization ID"
+// @Param id path string true "Service account ID"
+// @Param request body UpdateServiceAccountRequest true "body"
+// @Produce     json
+// @Success     200 {object}  ServiceAccount
+// @failure     400,404,500
+// @Router      /o/{org_id}/service-accounts/{id} [put]
+func (r resource) update(c echo.Context) error {
+
+	var input UpdateServiceAccountRequest
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	account, err := r.service.Update(c.Request().Context(), c.Param("org_id"), c.Param("id"), input)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, account)
+}
+
+// @Description Assign or remove roles, groups and policies of a service account.
+// @Tags        Service Account
+// @Accept      json
+// @Param org_id path string true "Organization ID"
+// @Param id path string true "Service account ID"
+// @Param request body PatchServiceAccountRequest true "body"
+// @Produce     json
+// @Success     200 {object}  ServiceAccount
+// @failure     400,404,500
+// @Router      /o/{org_id}/service-accounts/{id} [patch]
+func (r resource) patch(c echo.Context) error {
+
+	var input PatchServiceAccountRequest
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	account, err := r.service.Patch(c.Request().Context(), c.Param("org_id"), c.Param("id"), input)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, account)
+}
+
+// @Description Delete service account.
+// @Tags        Service Account
+// @Param org_id path string true "Organization ID"
+// @Param id path string true "Service account ID"
+// @Success     204
+// @failure     404,500
+// @Router      /o/{org_id}/service-accounts/{id} [delete]
+func (r resource) delete(c echo.Context) error {
+
+	if err := r.service.Delete(c.Request().Context(), c.Param("org_id"), c.Param("id")); err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusNoContent, "")
+}
+
+// @Description Issue a new client secret. Previous secrets stay valid for the grace period.
+// @Tags        Service Account
+// @Accept      json
+// @Param org_id path string true "Organization ID"
+// @Param id path string true "Service account ID"
+// @Param request body RotateSecretRequest false "body"
+// @Produce     json
+// @Success     200 {object}  CreatedServiceAccount
+// @failure     400,404,500
+// @Router      /o/{org_id}/service-accounts/{id}/rotate-secret [post]
+func (r resource) rotateSecret(c echo.Context) error {
+
+	var input RotateSecretRequest
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	account, err := r.service.RotateSecret(c.Request().Context(), c.Param("org_id"), c.Param("id"), input)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, account)
+}
+
+// @Description Issue an access token with the client credentials grant.
+// @Tags        Service Account
+// @Accept      x-www-form-urlencoded
+// @Param grant_type formData string true "client_credentials"
+// @Param client_id formData string false "Client ID, unless HTTP basic authentication is used"
+// @Param client_secret formData string false "Client secret, unless HTTP basic authentication is used"
+// @Produce     json
+// @Success     200 {object}  TokenResponse
+// @failure     400,401 {object} TokenError
+// @Router      /oauth2/token [post]
+func (r resource) token(c echo.Context) error {
+
+	var input TokenRequest
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, TokenError{Code: "invalid_request"})
+	}
+	if id, secret, ok := c.Request().BasicAuth(); ok {
+		input.ClientID, input.ClientSecret = id, secret
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	resp, err := r.service.Token(c.Request().Context(), input)
+	if err != nil {
+		tokenErr, ok := err.(TokenError)
+		if !ok {
+			return util.HandleError(err)
+		}
+		status := http.StatusBadRequest
+		if tokenErr.Code == "invalid_client" {
+			status = http.StatusUnauthorized
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="cronuseo"`)
+		}
+		return c.JSON(status, tokenErr)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// @Description Public keys of the tokens issued to service accounts.
+// @Tags        Service Account
+// @Produce     json
+// @Success     200
+// @Router      /oauth2/jwks [get]
+func (r resource) jwks(c echo.Context) error {
+
+	return c.JSON(http.StatusOK, r.service.JWKS())
+}
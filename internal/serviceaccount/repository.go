@@ -0,0 +1,358 @@
+package serviceaccount
+
+import (
+	"context"
+
+	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/orgtree"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Repository interface {
+	Query(ctx context.Context, org_id string) ([]mongo_entity.ServiceAccount, error)
+	Get(ctx context.Context, org_id string, id string) (*mongo_entity.ServiceAccount, error)
+	GetByClientID(ctx context.Context, client_id string) (*Client, error)
+	Create(ctx context.Context, org_id string, account mongo_entity.ServiceAccount) error
+	Update(ctx context.Context, org_id string, id string, displayName string) error
+	Patch(ctx context.Context, org_id string, id string, patch PatchServiceAccount) error
+	SetSecrets(ctx context.Context, org_id string, id string, secrets []mongo_entity.ClientSecret) error
+	Delete(ctx context.Context, org_id string, id string) error
+	CheckOrgExistById(ctx context.Context, org_id string) (bool, error)
+	CheckIdentifierExists(ctx context.Context, org_id string, identifier string) (bool, error)
+	CheckRoleExistById(ctx context.Context, org_id string, id primitive.ObjectID) (bool, error)
+	CheckGroupExistById(ctx context.Context, org_id string, id primitive.ObjectID) (bool, error)
+	CheckPolicyExistById(ctx context.Context, org_id string, id primitive.ObjectID) (bool, error)
+}
+
+// Client is a service account with the organization it belongs to.
+type Client struct {
+	OrgID          primitive.ObjectID
+	OrgIdentifier  string
+	ServiceAccount mongo_entity.ServiceAccount
+}
+
+type repository struct {
+	mongoClient *mongo.Client
+	mongoColl   *mongo.Collection
+}
+
+func NewRepository(mongodb *db.MongoDB) Repository {
+
+	orgCollection := mongodb.MongoClient.Database(mongodb.MongoConfig.DBName).Collection(mongodb.MongoConfig.OrganizationCollectionName)
+
+	return repository{mongoClient: mongodb.MongoClient, mongoColl: orgCollection}
+}
+
+// Get all service accounts of the organization.
+func (r repository) Query(ctx context.Context, org_id string) ([]mongo_entity.ServiceAccount, error) {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "Query")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": orgId}
+	projection := bson.M{"service_accounts": 1}
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	var org mongo_entity.Organization
+	if err := result.Decode(&org); err != nil {
+		return nil, err
+	}
+	return org.ServiceAccounts, nil
+}
+
+// Get service account by id.
+func (r repository) Get(ctx context.Context, org_id string, id string) (*mongo_entity.ServiceAccount, error) {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "Get")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return nil, err
+	}
+	accountId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": orgId, "service_accounts._id": accountId}
+	projection := bson.M{"service_accounts.$": 1}
+	var org mongo_entity.Organization
+	if err := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org); err != nil {
+		return nil, err
+	}
+	if len(org.ServiceAccounts) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &org.ServiceAccounts[0], nil
+}
+
+// Get service account by client id. Client ids are unique across
+// organizations.
+func (r repository) GetByClientID(ctx context.Context, client_id string) (*Client, error) {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "GetByClientID")
+	defer span.End()
+
+	filter := bson.M{"service_accounts.client_id": client_id}
+	projection := bson.M{"identifier": 1, "service_accounts.$": 1}
+	var org mongo_entity.Organization
+	if err := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org); err != nil {
+		return nil, err
+	}
+	if len(org.ServiceAccounts) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &Client{OrgID: org.ID, OrgIdentifier: org.Identifier, ServiceAccount: org.ServiceAccounts[0]}, nil
+}
+
+// Create new service account and assign it to its roles and groups.
+func (r repository) Create(ctx context.Context, org_id string, account mongo_entity.ServiceAccount) error {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "Create")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": orgId}
+	update := bson.M{"$push": bson.M{"service_accounts": account}}
+	if _, err := r.mongoColl.UpdateOne(ctx, filter, update); err != nil {
+		return err
+	}
+	if err := r.link(ctx, orgId, account.ID, "roles", account.Roles, "$addToSet"); err != nil {
+		return err
+	}
+	return r.link(ctx, orgId, account.ID, "groups", account.Groups, "$addToSet")
+}
+
+// Update the display name of a service account.
+func (r repository) Update(ctx context.Context, org_id string, id string, displayName string) error {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "Update")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return err
+	}
+	accountId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": orgId, "service_accounts._id": accountId}
+	update := bson.M{"$set": bson.M{"service_accounts.$.display_name": displayName}}
+	_, err = r.mongoColl.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// Patch the roles, groups and policies of a service account.
+func (r repository) Patch(ctx context.Context, org_id string, id string, patch PatchServiceAccount) error {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "Patch")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return err
+	}
+	accountId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": orgId, "service_accounts._id": accountId}
+	changes := []struct {
+		field  string
+		ids    []primitive.ObjectID
+		added  bool
+		linked bool
+	}{
+		{field: "roles", ids: patch.AddedRoles, added: true, linked: true},
+		{field: "roles", ids: patch.RemovedRoles, linked: true},
+		{field: "groups", ids: patch.AddedGroups, added: true, linked: true},
+		{field: "groups", ids: patch.RemovedGroups, linked: true},
+		{field: "policies", ids: patch.AddedPolicies, added: true},
+		{field: "policies", ids: patch.RemovedPolicies},
+	}
+	for _, change := range changes {
+		if len(change.ids) == 0 {
+			continue
+		}
+		update := bson.M{"$pull": bson.M{"service_accounts.$." + change.field: bson.M{"$in": change.ids}}}
+		operator := "$pull"
+		if change.added {
+			update = bson.M{"$addToSet": bson.M{"service_accounts.$." + change.field: bson.M{"$each": change.ids}}}
+			operator = "$addToSet"
+		}
+		if _, err := r.mongoColl.UpdateOne(ctx, filter, update); err != nil {
+			return err
+		}
+		if change.linked {
+			if err := r.link(ctx, orgId, accountId, change.field, change.ids, operator); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// link adds the service account to, or removes it from, the service accounts
+// of the roles or groups.
+func (r repository) link(ctx context.Context, orgId primitive.ObjectID, accountId primitive.ObjectID, field string, ids []primitive.ObjectID, operator string) error {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "link")
+	defer span.End()
+
+	for _, id := range ids {
+		filter := bson.M{"_id": orgId, field + "._id": id}
+		update := bson.M{operator: bson.M{field + ".$.service_accounts": accountId}}
+		if _, err := r.mongoColl.UpdateOne(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replace the client secrets of a service account.
+func (r repository) SetSecrets(ctx context.Context, org_id string, id string, secrets []mongo_entity.ClientSecret) error {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "SetSecrets")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return err
+	}
+	accountId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": orgId, "service_accounts._id": accountId}
+	update := bson.M{"$set": bson.M{"service_accounts.$.secrets": secrets}}
+	_, err = r.mongoColl.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// Delete existing service account and remove it from its roles and groups.
+func (r repository) Delete(ctx context.Context, org_id string, id string) error {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "Delete")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return err
+	}
+	accountId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": orgId}
+	update := bson.M{"$pull": bson.M{"service_accounts": bson.M{"_id": accountId}}}
+	if _, err := r.mongoColl.UpdateOne(ctx, filter, update); err != nil {
+		return err
+	}
+	for _, field := range []string{"roles", "groups"} {
+		filter := bson.M{"_id": orgId, field + ".service_accounts": accountId}
+		update := bson.M{"$pull": bson.M{field + ".$[].service_accounts": accountId}}
+		if _, err := r.mongoColl.UpdateOne(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check if organization exists by id.
+func (r repository) CheckOrgExistById(ctx context.Context, org_id string) (bool, error) {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "CheckOrgExistById")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return false, err
+	}
+	return r.exists(ctx, bson.M{"_id": orgId})
+}
+
+// Check if a user or service account uses the identifier. Both are check
+// subjects, so their identifiers must not collide.
+func (r repository) CheckIdentifierExists(ctx context.Context, org_id string, identifier string) (bool, error) {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "CheckIdentifierExists")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return false, err
+	}
+	return r.exists(ctx, bson.M{"_id": orgId, "$or": bson.A{
+		bson.M{"users.identifier": identifier},
+		bson.M{"service_accounts.identifier": identifier},
+	}})
+}
+
+// Check if role exists by id.
+func (r repository) CheckRoleExistById(ctx context.Context, org_id string, id primitive.ObjectID) (bool, error) {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "CheckRoleExistById")
+	defer span.End()
+
+	// Roles of ancestor organizations are inherited.
+	lineage, err := orgtree.LineageOf(ctx, r.mongoColl, org_id)
+	if err != nil {
+		return false, err
+	}
+	return r.exists(ctx, bson.M{"_id": bson.M{"$in": lineage}, "roles._id": id})
+}
+
+// Check if group exists by id.
+func (r repository) CheckGroupExistById(ctx context.Context, org_id string, id primitive.ObjectID) (bool, error) {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "CheckGroupExistById")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return false, err
+	}
+	return r.exists(ctx, bson.M{"_id": orgId, "groups._id": id})
+}
+
+// Check if policy exists by id.
+func (r repository) CheckPolicyExistById(ctx context.Context, org_id string, id primitive.ObjectID) (bool, error) {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "CheckPolicyExistById")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return false, err
+	}
+	return r.exists(ctx, bson.M{"_id": orgId, "policies._id": id})
+}
+
+func (r repository) exists(ctx context.Context, filter bson.M) (bool, error) {
+
+	ctx, span := telemetry.Repository(ctx, "serviceaccount", "exists")
+	defer span.End()
+
+	count, err := r.mongoColl.CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,492 @@
+package serviceaccount
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/shashimalcse/cronuseo/internal/apikey"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/token"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// GrantClientCredentials is the only grant type of the token endpoint.
+const GrantClientCredentials = "client_credentials"
+
+const (
+	clientIDPrefix     = "sa_"
+	clientSecretPrefix = "cros_"
+	secretPrefixLength = 12
+)
+
+type Service interface {
+	Query(ctx context.Context, org_id string) ([]ServiceAccount, error)
+	Get(ctx context.Context, org_id string, id string) (ServiceAccount, error)
+	Create(ctx context.Context, org_id string, req CreateServiceAccountRequest) (CreatedServiceAccount, error)
+	Update(ctx context.Context, org_id string, id string, req UpdateServiceAccountRequest) (ServiceAccount, error)
+	Patch(ctx context.Context, org_id string, id string, req PatchServiceAccountRequest) (ServiceAccount, error)
+	RotateSecret(ctx context.Context, org_id string, id string, req RotateSecretRequest) (CreatedServiceAccount, error)
+	Delete(ctx context.Context, org_id string, id string) error
+	Token(ctx context.Context, req TokenRequest) (TokenResponse, error)
+	JWKS() token.JWKSet
+}
+
+type ServiceAccount struct {
+	mongo_entity.ServiceAccount
+}
+
+// CreatedServiceAccount carries the plaintext client secret, which is only
+// returned once.
+type CreatedServiceAccount struct {
+	ServiceAccount
+	ClientSecret string `json:"client_secret"`
+}
+
+type CreateServiceAccountRequest struct {
+	Identifier  string               `json:"identifier"`
+	DisplayName string               `json:"display_name"`
+	Roles       []primitive.ObjectID `json:"roles,omitempty"`
+	Groups      []primitive.ObjectID `json:"groups,omitempty"`
+	Policies    []primitive.ObjectID `json:"policies,omitempty"`
+}
+
+func (m CreateServiceAccountRequest) Validate() error {
+
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Identifier, validation.Required),
+	)
+}
+
+type UpdateServiceAccountRequest struct {
+	DisplayName *string `json:"display_name,omitempty"`
+}
+
+type PatchServiceAccountRequest struct {
+	AddedRoles      []primitive.ObjectID `json:"added_roles,omitempty"`
+	RemovedRoles    []primitive.ObjectID `json:"removed_roles,omitempty"`
+	AddedGroups     []primitive.ObjectID `json:"added_groups,omitempty"`
+	RemovedGroups   []primitive.ObjectID `json:"removed_groups,omitempty"`
+	AddedPolicies   []primitive.ObjectID `json:"added_policies,omitempty"`
+	RemovedPolicies []primitive.ObjectID `json:"removed_policies,omitempty"`
+}
+
+type PatchServiceAccount struct {
+	AddedRoles      []primitive.ObjectID
+	RemovedRoles    []primitive.ObjectID
+	AddedGroups     []primitive.ObjectID
+	RemovedGroups   []primitive.ObjectID
+	AddedPolicies   []primitive.ObjectID
+	RemovedPolicies []primitive.ObjectID
+}
+
+// RotateSecretRequest controls how long the previous secrets stay valid. The
+// grace period is a duration such as "1h"; "0s" revokes them at once.
+type RotateSecretRequest struct {
+	GracePeriod string `json:"grace_period"`
+}
+
+// TokenRequest is an OAuth 2.0 client credentials token request.
+type TokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	ClientID     string `form:"client_id"`
+	ClientSecret string `form:"client_secret"`
+}
+
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// TokenError is an OAuth 2.0 error of the token endpoint.
+type TokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e TokenError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+type service struct {
+	repo        Repository
+	signer      *token.Signer
+	gracePeriod time.Duration
+	logger      *zap.Logger
+	now         func() time.Time
+}
+
+// NewService creates the service account service. Tokens are signed with
+// signer, nil when tokens are disabled, and gracePeriod is the default time
+// rotated secrets stay valid.
+func NewService(repo Repository, signer *token.Signer, gracePeriod time.Duration, logger *zap.Logger) Service {
+
+	if gracePeriod <= 0 {
+		gracePeriod = apikey.DefaultGracePeriod
+	}
+	return service{repo: repo, signer: signer, gracePeriod: gracePeriod, logger: logger, now: time.Now}
+}
+
+// Get all service accounts of the organization.
+func (s service) Query(ctx context.Context, org_id string) ([]ServiceAccount, error) {
+
+	ctx, span := telemetry.Start(ctx, "serviceaccount.service.Query")
+	defer span.End()
+
+	exists, _ := s.repo.CheckOrgExistById(ctx, org_id)
+	if !exists {
+		s.logger.Debug("Organization not exists.", zap.String("organization_id", org_id))
+		return []ServiceAccount{}, &util.NotFoundError{Path: "Organization " + org_id + " not exists."}
+	}
+	accounts, err := s.repo.Query(ctx, org_id)
+	if err != nil {
+		s.logger.Error("Error while retrieving service accounts.", zap.String("organization_id", org_id))
+		return []ServiceAccount{}, err
+	}
+	result := []ServiceAccount{}
+	for _, account := range accounts {
+		result = append(result, ServiceAccount{account})
+	}
+	return result, nil
+}
+
+// Get service account by id.
+func (s service) Get(ctx context.Context, org_id string, id string) (ServiceAccount, error) {
+
+	ctx, span := telemetry.Start(ctx, "serviceaccount.service.Get")
+	defer span.End()
+
+	account, err := s.repo.Get(ctx, org_id, id)
+	if err != nil {
+		s.logger.Debug("Service account not exists.", zap.String("organization_id", org_id), zap.String("service_account_id", id))
+		return ServiceAccount{}, &util.NotFoundError{Path: "Service account " + id + " not exists."}
+	}
+	return ServiceAccount{*account}, nil
+}
+
+// Create new service account with its first client secret.
+func (s service) Create(ctx context.Context, org_id string, req CreateServiceAccountRequest) (CreatedServiceAccount, error) {
+
+	ctx, span := telemetry.Start(ctx, "serviceaccount.service.Create")
+	defer span.End()
+
+	if err := req.Validate(); err != nil {
+		s.logger.Debug("Invalid service account creation request.", zap.Error(err))
+		return CreatedServiceAccount{}, util.NewValidationError("Invalid input for service account.", err)
+	}
+	exists, _ := s.repo.CheckOrgExistById(ctx, org_id)
+	if !exists {
+		s.logger.Debug("Organization not exists.", zap.String("organization_id", org_id))
+		return CreatedServiceAccount{}, &util.NotFoundError{Path: "Organization " + org_id + " not exists."}
+	}
+	exists, _ = s.repo.CheckIdentifierExists(ctx, org_id, req.Identifier)
+	if exists {
+		s.logger.Debug("Service account identifier already exists.", zap.String("identifier", req.Identifier))
+		return CreatedServiceAccount{}, &util.AlreadyExistsError{Path: "Service account : " + req.Identifier}
+	}
+	if err := s.validateAssignments(ctx, org_id, req.Roles, req.Groups, req.Policies); err != nil {
+		return CreatedServiceAccount{}, err
+	}
+
+	clientID, err := randomString(clientIDPrefix, 16)
+	if err != nil {
+		return CreatedServiceAccount{}, err
+	}
+	secret, entity, err := s.generateSecret()
+	if err != nil {
+		return CreatedServiceAccount{}, err
+	}
+	displayName := req.DisplayName
+	if displayName == "" {
+		displayName = req.Identifier
+	}
+	account := mongo_entity.ServiceAccount{
+		ID:          primitive.NewObjectID(),
+		Identifier:  req.Identifier,
+		DisplayName: displayName,
+		ClientID:    clientID,
+		Secrets:     []mongo_entity.ClientSecret{entity},
+		Roles:       orEmpty(req.Roles),
+		Groups:      orEmpty(req.Groups),
+		Policies:    orEmpty(req.Policies),
+		CreatedAt:   s.now().UTC(),
+	}
+	if err := s.repo.Create(ctx, org_id, account); err != nil {
+		s.logger.Error("Error while creating service account.", zap.String("organization_id", org_id))
+		return CreatedServiceAccount{}, err
+	}
+	return CreatedServiceAccount{ServiceAccount: ServiceAccount{account}, ClientSecret: secret}, nil
+}
+
+// Update service account.
+func (s service) Update(ctx context.Context, org_id string, id string, req UpdateServiceAccountRequest) (ServiceAccount, error) {
+
+	ctx, span := telemetry.Start(ctx, "serviceaccount.service.Update")
+	defer span.End()
+
+	if _, err := s.Get(ctx, org_id, id); err != nil {
+		return ServiceAccount{}, err
+	}
+	if req.DisplayName != nil && *req.DisplayName != "" {
+		if err := s.repo.Update(ctx, org_id, id, *req.DisplayName); err != nil {
+			s.logger.Error("Error while updating service account.", zap.String("organization_id", org_id), zap.String("service_account_id", id))
+			return ServiceAccount{}, err
+		}
+	}
+	return s.Get(ctx, org_id, id)
+}
+
+// Patch assigns roles, groups and policies to, or removes them from, a
+// service account.
+func (s service) Patch(ctx context.Context, org_id string, id string, req PatchServiceAccountRequest) (ServiceAccount, error) {
+
+	ctx, span := telemetry.Start(ctx, "serviceaccount.service.Patch")
+	defer span.End()
+
+	account, err := s.Get(ctx, org_id, id)
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+	if err := s.validateAssignments(ctx, org_id, req.AddedRoles, req.AddedGroups, req.AddedPolicies); err != nil {
+		return ServiceAccount{}, err
+	}
+	if err := s.validateAssignments(ctx, org_id, req.RemovedRoles, req.RemovedGroups, req.RemovedPolicies); err != nil {
+		return ServiceAccount{}, err
+	}
+
+	if err := s.repo.Patch(ctx, org_id, id, PatchServiceAccount{
+		AddedRoles:      missing(account.Roles, req.AddedRoles),
+		RemovedRoles:    present(account.Roles, req.RemovedRoles),
+		AddedGroups:     missing(account.Groups, req.AddedGroups),
+		RemovedGroups:   present(account.Groups, req.RemovedGroups),
+		AddedPolicies:   missing(account.Policies, req.AddedPolicies),
+		RemovedPolicies: present(account.Policies, req.RemovedPolicies),
+	}); err != nil {
+		s.logger.Error("Error while patching service account.", zap.String("organization_id", org_id), zap.String("service_account_id", id))
+		return ServiceAccount{}, err
+	}
+	return s.Get(ctx, org_id, id)
+}
+
+// RotateSecret issues a new client secret. The previous secrets stay valid
+// for the grace period so callers can switch over.
+func (s service) RotateSecret(ctx context.Context, org_id string, id string, req RotateSecretRequest) (CreatedServiceAccount, error) {
+
+	ctx, span := telemetry.Start(ctx, "serviceaccount.service.RotateSecret")
+	defer span.End()
+
+	gracePeriod := s.gracePeriod
+	if req.GracePeriod != "" {
+		d, err := time.ParseDuration(req.GracePeriod)
+		if err != nil || d < 0 {
+			return CreatedServiceAccount{}, &util.InvalidInputError{Message: "Invalid client secret grace period " + req.GracePeriod + "."}
+		}
+		gracePeriod = d
+	}
+	account, err := s.Get(ctx, org_id, id)
+	if err != nil {
+		return CreatedServiceAccount{}, err
+	}
+
+	now := s.now().UTC()
+	graceUntil := now.Add(gracePeriod)
+	secrets := []mongo_entity.ClientSecret{}
+	for _, secret := range account.Secrets {
+		if secret.ExpiresAt != nil && !secret.ExpiresAt.After(now) {
+			continue
+		}
+		if gracePeriod == 0 {
+			continue
+		}
+		if secret.ExpiresAt == nil || secret.ExpiresAt.After(graceUntil) {
+			secret.ExpiresAt = &graceUntil
+		}
+		secrets = append(secrets, secret)
+	}
+	plaintext, entity, err := s.generateSecret()
+	if err != nil {
+		return CreatedServiceAccount{}, err
+	}
+	secrets = append(secrets, entity)
+	if err := s.repo.SetSecrets(ctx, org_id, id, secrets); err != nil {
+		s.logger.Error("Error while rotating client secret.", zap.String("organization_id", org_id), zap.String("service_account_id", id))
+		return CreatedServiceAccount{}, err
+	}
+	account.Secrets = secrets
+	return CreatedServiceAccount{ServiceAccount: account, ClientSecret: plaintext}, nil
+}
+
+// Delete service account. Tokens already issued to it stop granting
+// permissions because it is no longer a check subject.
+func (s service) Delete(ctx context.Context, org_id string, id string) error {
+
+	ctx, span := telemetry.Start(ctx, "serviceaccount.service.Delete")
+	defer span.End()
+
+	if _, err := s.Get(ctx, org_id, id); err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, org_id, id); err != nil {
+		s.logger.Error("Error while deleting service account.", zap.String("organization_id", org_id), zap.String("service_account_id", id))
+		return err
+	}
+	return nil
+}
+
+// Token issues an access token for valid client credentials.
+func (s service) Token(ctx context.Context, req TokenRequest) (TokenResponse, error) {
+
+	ctx, span := telemetry.Start(ctx, "serviceaccount.service.Token")
+	defer span.End()
+
+	if req.GrantType != GrantClientCredentials {
+		return TokenResponse{}, TokenError{Code: "unsupported_grant_type", Description: "only client_credentials is supported"}
+	}
+	if s.signer == nil {
+		return TokenResponse{}, TokenError{Code: "unauthorized_client", Description: "service account tokens are disabled, no signing key is configured"}
+	}
+	invalidClient := TokenError{Code: "invalid_client", Description: "client authentication failed"}
+	if req.ClientID == "" || req.ClientSecret == "" {
+		return TokenResponse{}, invalidClient
+	}
+	client, err := s.repo.GetByClientID(ctx, req.ClientID)
+	if err != nil {
+		s.logger.Debug("Unknown service account client.", zap.String("client_id", req.ClientID))
+		return TokenResponse{}, invalidClient
+	}
+	if !validSecret(client.ServiceAccount.Secrets, req.ClientSecret, s.now()) {
+		s.logger.Debug("Invalid service account secret.", zap.String("client_id", req.ClientID))
+		return TokenResponse{}, invalidClient
+	}
+
+	raw, ttl, err := s.signer.Sign(client.ServiceAccount.Identifier, client.OrgIdentifier, map[string]interface{}{
+		"client_id": client.ServiceAccount.ClientID,
+	})
+	if err != nil {
+		s.logger.Error("Error while signing service account token.", zap.Error(err))
+		return TokenResponse{}, err
+	}
+	return TokenResponse{AccessToken: raw, TokenType: "Bearer", ExpiresIn: int64(ttl.Seconds())}, nil
+}
+
+// JWKS returns the public keys of the issued tokens.
+func (s service) JWKS() token.JWKSet {
+
+	if s.signer == nil {
+		return token.JWKSet{Keys: []token.JWK{}}
+	}
+	return s.signer.JWKS()
+}
+
+func (s service) validateAssignments(ctx context.Context, org_id string, roles []primitive.ObjectID, groups []primitive.ObjectID, policies []primitive.ObjectID) error {
+
+	ctx, span := telemetry.Start(ctx, "serviceaccount.service.validateAssignments")
+	defer span.End()
+
+	for _, id := range roles {
+		if exists, _ := s.repo.CheckRoleExistById(ctx, org_id, id); !exists {
+			return &util.InvalidInputError{Message: "Invalid role id " + id.Hex()}
+		}
+	}
+	for _, id := range groups {
+		if exists, _ := s.repo.CheckGroupExistById(ctx, org_id, id); !exists {
+			return &util.InvalidInputError{Message: "Invalid group id " + id.Hex()}
+		}
+	}
+	for _, id := range policies {
+		if exists, _ := s.repo.CheckPolicyExistById(ctx, org_id, id); !exists {
+			return &util.InvalidInputError{Message: "Invalid policy id " + id.Hex()}
+		}
+	}
+	return nil
+}
+
+func (s service) generateSecret() (string, mongo_entity.ClientSecret, error) {
+
+	secret, err := randomString(clientSecretPrefix, 32)
+	if err != nil {
+		return "", mongo_entity.ClientSecret{}, err
+	}
+	return secret, mongo_entity.ClientSecret{
+		ID:        primitive.NewObjectID(),
+		Prefix:    secret[:secretPrefixLength],
+		Hash:      apikey.Hash(secret),
+		CreatedAt: s.now().UTC(),
+	}, nil
+}
+
+// validSecret reports whether the secret matches an unexpired client secret.
+func validSecret(secrets []mongo_entity.ClientSecret, secret string, now time.Time) bool {
+
+	hash, _ := hex.DecodeString(apikey.Hash(secret))
+	valid := false
+	for _, s := range secrets {
+		stored, err := hex.DecodeString(s.Hash)
+		if err != nil {
+			continue
+		}
+		if subtle.ConstantTimeCompare(stored, hash) == 1 && (s.ExpiresAt == nil || s.ExpiresAt.After(now)) {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func randomString(prefix string, size int) (string, error) {
+
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func orEmpty(ids []primitive.ObjectID) []primitive.ObjectID {
+
+	if ids == nil {
+		return []primitive.ObjectID{}
+	}
+	return ids
+}
+
+// missing returns the ids that are not in assigned.
+func missing(assigned []primitive.ObjectID, ids []primitive.ObjectID) []primitive.ObjectID {
+
+	result := []primitive.ObjectID{}
+	for _, id := range ids {
+		if !contains(assigned, id) && !contains(result, id) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// present returns the ids that are in assigned.
+func present(assigned []primitive.ObjectID, ids []primitive.ObjectID) []primitive.ObjectID {
+
+	result := []primitive.ObjectID{}
+	for _, id := range ids {
+		if contains(assigned, id) && !contains(result, id) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func contains(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
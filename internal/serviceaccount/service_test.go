@@ -0,0 +1,217 @@
+package serviceaccount
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/token"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+func TestService(t *testing.T) {
+
+	orgId := primitive.NewObjectID().Hex()
+	roleId := primitive.NewObjectID()
+	repo := &mockRepository{
+		orgs:       map[string][]mongo_entity.ServiceAccount{orgId: {}},
+		identifier: map[string]string{orgId: "acme"},
+		roles:      map[primitive.ObjectID]bool{roleId: true},
+		users:      map[string]bool{"jane": true},
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	keyFile := filepath.Join(t.TempDir(), "signing.pem")
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600))
+	signer, err := token.NewSigner(config.ServiceAccounts{SigningKeyFile: keyFile}, zap.NewNop())
+	assert.Nil(t, err)
+	s := NewService(repo, signer, time.Hour, zap.NewNop())
+	ctx := context.Background()
+
+	// validation errors
+	_, err = s.Create(ctx, orgId, CreateServiceAccountRequest{})
+	assert.IsType(t, &util.InvalidInputError{}, err)
+	_, err = s.Create(ctx, orgId, CreateServiceAccountRequest{Identifier: "jane"})
+	assert.IsType(t, &util.AlreadyExistsError{}, err)
+	_, err = s.Create(ctx, orgId, CreateServiceAccountRequest{Identifier: "billing", Roles: []primitive.ObjectID{primitive.NewObjectID()}})
+	assert.IsType(t, &util.InvalidInputError{}, err)
+	_, err = s.Create(ctx, primitive.NewObjectID().Hex(), CreateServiceAccountRequest{Identifier: "billing"})
+	assert.IsType(t, &util.NotFoundError{}, err)
+
+	// the plaintext secret is returned once and only its hash is stored
+	created, err := s.Create(ctx, orgId, CreateServiceAccountRequest{Identifier: "billing", Roles: []primitive.ObjectID{roleId}})
+	assert.Nil(t, err)
+	assert.Equal(t, "billing", created.DisplayName)
+	assert.Equal(t, []primitive.ObjectID{roleId}, created.Roles)
+	assert.NotEqual(t, created.ClientSecret, created.Secrets[0].Hash)
+
+	// client credentials are exchanged for a token of the organization
+	_, err = s.Token(ctx, TokenRequest{GrantType: "password", ClientID: created.ClientID, ClientSecret: created.ClientSecret})
+	assert.Equal(t, "unsupported_grant_type", err.(TokenError).Code)
+	_, err = s.Token(ctx, TokenRequest{GrantType: GrantClientCredentials, ClientID: created.ClientID, ClientSecret: "wrong"})
+	assert.Equal(t, "invalid_client", err.(TokenError).Code)
+	resp, err := s.Token(ctx, TokenRequest{GrantType: GrantClientCredentials, ClientID: created.ClientID, ClientSecret: created.ClientSecret})
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer", resp.TokenType)
+	assert.Equal(t, int64(token.DefaultTokenTTL.Seconds()), resp.ExpiresIn)
+
+	verifier, err := token.NewVerifier(config.Auth{}, signer, zap.NewNop())
+	assert.Nil(t, err)
+	identity, err := verifier.Verify(resp.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "billing", identity.Subject)
+	assert.Equal(t, "acme", identity.Organization)
+
+	// rotation keeps the old secret for the grace period
+	rotated, err := s.RotateSecret(ctx, orgId, created.ID.Hex(), RotateSecretRequest{GracePeriod: "10m"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(rotated.Secrets))
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), *rotated.Secrets[0].ExpiresAt, time.Minute)
+	_, err = s.Token(ctx, TokenRequest{GrantType: GrantClientCredentials, ClientID: created.ClientID, ClientSecret: created.ClientSecret})
+	assert.Nil(t, err)
+
+	// a zero grace period revokes the previous secrets
+	revoked, err := s.RotateSecret(ctx, orgId, created.ID.Hex(), RotateSecretRequest{GracePeriod: "0s"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(revoked.Secrets))
+	_, err = s.Token(ctx, TokenRequest{GrantType: GrantClientCredentials, ClientID: created.ClientID, ClientSecret: rotated.ClientSecret})
+	assert.Equal(t, "invalid_client", err.(TokenError).Code)
+	_, err = s.Token(ctx, TokenRequest{GrantType: GrantClientCredentials, ClientID: created.ClientID, ClientSecret: revoked.ClientSecret})
+	assert.Nil(t, err)
+
+	// only new assignments are added and only existing ones removed
+	_, err = s.Patch(ctx, orgId, created.ID.Hex(), PatchServiceAccountRequest{AddedRoles: []primitive.ObjectID{roleId}, RemovedRoles: []primitive.ObjectID{roleId}})
+	assert.Nil(t, err)
+	assert.Equal(t, PatchServiceAccount{
+		AddedRoles: []primitive.ObjectID{}, RemovedRoles: []primitive.ObjectID{roleId},
+		AddedGroups: []primitive.ObjectID{}, RemovedGroups: []primitive.ObjectID{},
+		AddedPolicies: []primitive.ObjectID{}, RemovedPolicies: []primitive.ObjectID{},
+	}, repo.patched)
+
+	assert.Nil(t, s.Delete(ctx, orgId, created.ID.Hex()))
+	assert.IsType(t, &util.NotFoundError{}, s.Delete(ctx, orgId, created.ID.Hex()))
+}
+
+type mockRepository struct {
+	orgs       map[string][]mongo_entity.ServiceAccount
+	identifier map[string]string
+	roles      map[primitive.ObjectID]bool
+	users      map[string]bool
+	patched    PatchServiceAccount
+}
+
+func (m *mockRepository) Query(ctx context.Context, org_id string) ([]mongo_entity.ServiceAccount, error) {
+	return m.orgs[org_id], nil
+}
+
+func (m *mockRepository) Get(ctx context.Context, org_id string, id string) (*mongo_entity.ServiceAccount, error) {
+	for _, account := range m.orgs[org_id] {
+		if account.ID.Hex() == id {
+			return &account, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *mockRepository) GetByClientID(ctx context.Context, client_id string) (*Client, error) {
+	for org_id, accounts := range m.orgs {
+		for _, account := range accounts {
+			if account.ClientID == client_id {
+				return &Client{OrgIdentifier: m.identifier[org_id], ServiceAccount: account}, nil
+			}
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *mockRepository) Create(ctx context.Context, org_id string, account mongo_entity.ServiceAccount) error {
+	m.orgs[org_id] = append(m.orgs[org_id], account)
+	return nil
+}
+
+func (m *mockRepository) Update(ctx context.Context, org_id string, id string, displayName string) error {
+	return m.update(org_id, id, func(account *mongo_entity.ServiceAccount) { account.DisplayName = displayName })
+}
+
+func (m *mockRepository) Patch(ctx context.Context, org_id string, id string, patch PatchServiceAccount) error {
+	m.patched = patch
+	return nil
+}
+
+func (m *mockRepository) SetSecrets(ctx context.Context, org_id string, id string, secrets []mongo_entity.ClientSecret) error {
+	return m.update(org_id, id, func(account *mongo_entity.ServiceAccount) { account.Secrets = secrets })
+}
+
+func (m *mockRepository) Delete(ctx context.Context, org_id string, id string) error {
+	accounts := []mongo_entity.ServiceAccount{}
+	for _, account := range m.orgs[org_id] {
+		if account.ID.Hex() != id {
+			accounts = append(accounts, account)
+		}
+	}
+	m.orgs[org_id] = accounts
+	return nil
+}
+
+func (m *mockRepository) CheckOrgExistById(ctx context.Context, org_id string) (bool, error) {
+	_, ok := m.orgs[org_id]
+	return ok, nil
+}
+
+func (m *mockRepository) CheckIdentifierExists(ctx context.Context, org_id string, identifier string) (bool, error) {
+	if m.users[identifier] {
+		return true, nil
+	}
+	for _, account := range m.orgs[org_id] {
+		if account.Identifier == identifier {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockRepository) CheckRoleExistById(ctx context.Context, org_id string, id primitive.ObjectID) (bool, error) {
+	return m.roles[id], nil
+}
+
+func (m *mockRepository) CheckGroupExistById(ctx context.Context, org_id string, id primitive.ObjectID) (bool, error) {
+	return false, nil
+}
+
+func (m *mockRepository) CheckPolicyExistById(ctx context.Context, org_id string, id primitive.ObjectID) (bool, error) {
+	return false, nil
+}
+
+func (m *mockRepository) update(org_id string, id string, fn func(*mongo_entity.ServiceAccount)) error {
+	for i := range m.orgs[org_id] {
+		if m.orgs[org_id][i].ID.Hex() == id {
+			fn(&m.orgs[org_id][i])
+			return nil
+		}
+	}
+	return mongo.ErrNoDocuments
+}
+
+func TestTokensDisabled(t *testing.T) {
+
+	// without a signing key no tokens are issued
+	signer, err := token.NewSigner(config.ServiceAccounts{}, zap.NewNop())
+	assert.Nil(t, err)
+	assert.Nil(t, signer)
+	s := NewService(&mockRepository{}, signer, time.Hour, zap.NewNop())
+	_, err = s.Token(context.Background(), TokenRequest{GrantType: GrantClientCredentials, ClientID: "id", ClientSecret: "secret"})
+	assert.Equal(t, "unauthorized_client", err.(TokenError).Code)
+	assert.Empty(t, s.JWKS().Keys)
+}
@@ -0,0 +1,74 @@
+package sod
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/util"
+)
+
+func RegisterHandlers(r *echo.Group, service Service) {
+	res := resource{service}
+	router := r.Group("/o/:org_id/separation-of-duties")
+	router.GET("", res.get)
+	router.PUT("", res.update)
+	router.GET("/violations", res.report)
+}
+
+type resource struct {
+	service Service
+}
+
+// @Description Get the separation of duties rules of the organization.
+// @Tags        Separation of Duties
+// @Param org_id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {object}  Rules
+// @failure     404,500
+// @Router      /o/{org_id}/separation-of-duties [get]
+func (r resource) get(c echo.Context) error {
+
+	rules, err := r.service.Get(c.Request().Context(), c.Param("org_id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, rules)
+}
+
+// @Description Replace the mutually exclusive roles and role cardinality rules of the organization.
+// @Tags        Separation of Duties
+// @Accept      json
+// @Param org_id path string true "Organization ID"
+// @Param request body UpdateRulesRequest true "body"
+// @Produce     json
+// @Success     200 {object}  Rules
+// @failure     400,404,500
+// @Router      /o/{org_id}/separation-of-duties [put]
+func (r resource) update(c echo.Context) error {
+
+	var input UpdateRulesRequest
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	rules, err := r.service.Update(c.Request().Context(), c.Param("org_id"), input)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, rules)
+}
+
+// @Description List the existing violations of the separation of duties rules of the organization.
+// @Tags        Separation of Duties
+// @Param org_id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {object}  Report
+// @failure     404,500
+// @Router      /o/{org_id}/separation-of-duties/violations [get]
+func (r resource) report(c echo.Context) error {
+
+	report, err := r.service.Report(c.Request().Context(), c.Param("org_id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,79 @@
+package sod
+
+import (
+	"context"
+
+	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Repository interface {
+	Get(ctx context.Context, org_id string) (*mongo_entity.Organization, error)
+	Update(ctx context.Context, org_id string, rules mongo_entity.SeparationOfDuties) error
+}
+
+type repository struct {
+	mongoClient *mongo.Client
+	mongoColl   *mongo.Collection
+}
+
+func NewRepository(mongodb *db.MongoDB) Repository {
+
+	orgCollection := mongodb.MongoClient.Database(mongodb.MongoConfig.DBName).Collection(mongodb.MongoConfig.OrganizationCollectionName)
+
+	return repository{mongoClient: mongodb.MongoClient, mongoColl: orgCollection}
+}
+
+// Get returns the separation of duties rules of the organization with its
+// users, groups and roles and their assignments.
+func (r repository) Get(ctx context.Context, org_id string) (*mongo_entity.Organization, error) {
+
+	ctx, span := telemetry.Repository(ctx, "sod", "Get")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	projection := bson.M{
+		"separation_of_duties": 1,
+		"users._id":            1, "users.username": 1, "users.identifier": 1, "users.roles": 1, "users.groups": 1,
+		"groups._id": 1, "groups.roles": 1,
+		"roles._id": 1, "roles.identifier": 1, "roles.display_name": 1,
+	}
+	var org mongo_entity.Organization
+	err = r.mongoColl.FindOne(ctx, bson.M{"_id": orgId}, options.FindOne().SetProjection(projection)).Decode(&org)
+	if err == mongo.ErrNoDocuments {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// Update replaces the separation of duties rules of the organization.
+func (r repository) Update(ctx context.Context, org_id string, rules mongo_entity.SeparationOfDuties) error {
+
+	ctx, span := telemetry.Repository(ctx, "sod", "Update")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return &util.NotFoundError{Path: "Organization"}
+	}
+	result, err := r.mongoColl.UpdateOne(ctx, bson.M{"_id": orgId}, bson.M{"$set": bson.M{"separation_of_duties": rules}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &util.NotFoundError{Path: "Organization"}
+	}
+	return nil
+}
@@ -0,0 +1,426 @@
+package sod
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// Rules of the violations.
+const (
+	RuleExclusiveRoles  = "exclusive_roles"
+	RuleRoleCardinality = "role_cardinality"
+)
+
+// Enforcer enforces the separation of duties rules of organizations on
+// assignment changes.
+type Enforcer interface {
+	// Enforce returns a ConflictError when the change would add violations of
+	// the rules. Existing violations do not block changes that keep or reduce
+	// them.
+	Enforce(ctx context.Context, org_id string, change Change) error
+}
+
+type Service interface {
+	Enforcer
+	Get(ctx context.Context, org_id string) (Rules, error)
+	Update(ctx context.Context, org_id string, req UpdateRulesRequest) (Rules, error)
+	Report(ctx context.Context, org_id string) (Report, error)
+}
+
+type Rules struct {
+	mongo_entity.SeparationOfDuties
+}
+
+type UpdateRulesRequest struct {
+	ExclusiveRoles    []ExclusiveRoles  `json:"exclusive_roles"`
+	RoleCardinalities []RoleCardinality `json:"role_cardinalities"`
+}
+
+func (m UpdateRulesRequest) Validate() error {
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.ExclusiveRoles),
+		validation.Field(&m.RoleCardinalities),
+	)
+}
+
+type ExclusiveRoles struct {
+	mongo_entity.ExclusiveRoles
+}
+
+func (m ExclusiveRoles) Validate() error {
+	return validation.ValidateStruct(&m.ExclusiveRoles,
+		validation.Field(&m.Name, validation.Required),
+		validation.Field(&m.Roles, validation.Length(2, 0)),
+	)
+}
+
+type RoleCardinality struct {
+	mongo_entity.RoleCardinality
+}
+
+func (m RoleCardinality) Validate() error {
+	return validation.ValidateStruct(&m.RoleCardinality,
+		validation.Field(&m.Role, validation.Required),
+		validation.Field(&m.MaxUsers, validation.Required, validation.Min(1)),
+	)
+}
+
+// Link is an assignment of a role or group to a user, or of a role to a group.
+type Link struct {
+	From primitive.ObjectID
+	To   primitive.ObjectID
+}
+
+// Links returns the links of the subject to the targets.
+func Links(from primitive.ObjectID, to []primitive.ObjectID) []Link {
+
+	links := make([]Link, 0, len(to))
+	for _, id := range to {
+		links = append(links, Link{From: from, To: id})
+	}
+	return links
+}
+
+// Linked returns the links of the subjects to the target.
+func Linked(from []primitive.ObjectID, to primitive.ObjectID) []Link {
+
+	links := make([]Link, 0, len(from))
+	for _, id := range from {
+		links = append(links, Link{From: id, To: to})
+	}
+	return links
+}
+
+// Change is a change of the role and group assignments of an organization.
+// Users and groups created by the change are linked like existing ones.
+type Change struct {
+	AddedUserRoles    []Link
+	RemovedUserRoles  []Link
+	AddedUserGroups   []Link
+	RemovedUserGroups []Link
+	AddedGroupRoles   []Link
+	RemovedGroupRoles []Link
+}
+
+// Violation is a violation of a separation of duties rule. Exclusive roles
+// violations are reported per user with the exclusive roles they hold, and
+// role cardinality violations with the users holding the role.
+type Violation struct {
+	Rule     string                      `json:"rule"`
+	Name     string                      `json:"name,omitempty"`
+	MaxUsers int                         `json:"max_users,omitempty"`
+	Roles    []mongo_entity.AssignedRole `json:"roles"`
+	Users    []mongo_entity.AssignedUser `json:"users"`
+
+	key string
+}
+
+// size is how far the violation exceeds its rule.
+func (v Violation) size() int {
+
+	if v.Rule == RuleExclusiveRoles {
+		return len(v.Roles)
+	}
+	return len(v.Users)
+}
+
+func (v Violation) String() string {
+
+	if v.Rule == RuleExclusiveRoles {
+		roles := make([]string, 0, len(v.Roles))
+		for _, role := range v.Roles {
+			roles = append(roles, role.Identifier)
+		}
+		return fmt.Sprintf("User %s would hold the mutually exclusive roles %s of %q.", v.Users[0].Identifier, strings.Join(roles, ", "), v.Name)
+	}
+	return fmt.Sprintf("Role %s would be held by %d users, more than %d.", v.Roles[0].Identifier, len(v.Users), v.MaxUsers)
+}
+
+type Report struct {
+	Violations []Violation `json:"violations"`
+}
+
+type service struct {
+	repo   Repository
+	logger *zap.Logger
+}
+
+func NewService(repo Repository, logger *zap.Logger) Service {
+
+	return service{repo: repo, logger: logger}
+}
+
+// Get the separation of duties rules of the organization.
+func (s service) Get(ctx context.Context, org_id string) (Rules, error) {
+
+	ctx, span := telemetry.Start(ctx, "sod.service.Get")
+	defer span.End()
+
+	org, err := s.repo.Get(ctx, org_id)
+	if err != nil {
+		return Rules{}, err
+	}
+	return rulesOf(org), nil
+}
+
+// Update the separation of duties rules of the organization. Existing
+// violations of the new rules are listed by the report.
+func (s service) Update(ctx context.Context, org_id string, req UpdateRulesRequest) (Rules, error) {
+
+	ctx, span := telemetry.Start(ctx, "sod.service.Update")
+	defer span.End()
+
+	if err := req.Validate(); err != nil {
+		s.logger.Error("Error while validating separation of duties rules.")
+		return Rules{}, util.NewValidationError("Invalid input for separation of duties rules.", err)
+	}
+	org, err := s.repo.Get(ctx, org_id)
+	if err != nil {
+		return Rules{}, err
+	}
+	roles := map[primitive.ObjectID]bool{}
+	for _, role := range org.Roles {
+		roles[role.ID] = true
+	}
+	rules := mongo_entity.SeparationOfDuties{
+		ExclusiveRoles:    []mongo_entity.ExclusiveRoles{},
+		RoleCardinalities: []mongo_entity.RoleCardinality{},
+	}
+	for _, rule := range req.ExclusiveRoles {
+		for _, roleId := range rule.Roles {
+			if !roles[roleId] {
+				return Rules{}, &util.InvalidInputError{Message: "Invalid role id " + roleId.Hex()}
+			}
+		}
+		rules.ExclusiveRoles = append(rules.ExclusiveRoles, rule.ExclusiveRoles)
+	}
+	for _, rule := range req.RoleCardinalities {
+		if !roles[rule.Role] {
+			return Rules{}, &util.InvalidInputError{Message: "Invalid role id " + rule.Role.Hex()}
+		}
+		rules.RoleCardinalities = append(rules.RoleCardinalities, rule.RoleCardinality)
+	}
+	if err := s.repo.Update(ctx, org_id, rules); err != nil {
+		s.logger.Error("Error while updating separation of duties rules.", zap.String("organization_id", org_id))
+		return Rules{}, err
+	}
+	return Rules{rules}, nil
+}
+
+// Report the existing violations of the separation of duties rules of the
+// organization.
+func (s service) Report(ctx context.Context, org_id string) (Report, error) {
+
+	ctx, span := telemetry.Start(ctx, "sod.service.Report")
+	defer span.End()
+
+	org, err := s.repo.Get(ctx, org_id)
+	if err != nil {
+		return Report{}, err
+	}
+	return Report{Violations: newMembership(org).violations(rulesOf(org))}, nil
+}
+
+// Enforce the separation of duties rules of the organization on the change.
+func (s service) Enforce(ctx context.Context, org_id string, change Change) error {
+
+	ctx, span := telemetry.Start(ctx, "sod.service.Enforce")
+	defer span.End()
+
+	org, err := s.repo.Get(ctx, org_id)
+	if err != nil {
+		return err
+	}
+	if org.SeparationOfDuties == nil {
+		return nil
+	}
+	rules := rulesOf(org)
+	membership := newMembership(org)
+	before := map[string]int{}
+	for _, violation := range membership.violations(rules) {
+		before[violation.key] = violation.size()
+	}
+	membership.apply(change)
+	messages := []string{}
+	for _, violation := range membership.violations(rules) {
+		if violation.size() > before[violation.key] {
+			messages = append(messages, violation.String())
+		}
+	}
+	if len(messages) > 0 {
+		s.logger.Debug("Separation of duties violated.", zap.String("organization_id", org_id), zap.Strings("violations", messages))
+		return &util.ConflictError{Message: "Separation of duties violated. " + strings.Join(messages, " ")}
+	}
+	return nil
+}
+
+func rulesOf(org *mongo_entity.Organization) Rules {
+
+	if org.SeparationOfDuties == nil {
+		return Rules{mongo_entity.SeparationOfDuties{
+			ExclusiveRoles:    []mongo_entity.ExclusiveRoles{},
+			RoleCardinalities: []mongo_entity.RoleCardinality{},
+		}}
+	}
+	return Rules{*org.SeparationOfDuties}
+}
+
+type set map[primitive.ObjectID]bool
+
+// membership are the role and group assignments of the users and groups of an
+// organization, regardless of their validity windows.
+type membership struct {
+	users      map[primitive.ObjectID]mongo_entity.AssignedUser
+	roles      map[primitive.ObjectID]mongo_entity.AssignedRole
+	userRoles  map[primitive.ObjectID]set
+	userGroups map[primitive.ObjectID]set
+	groupRoles map[primitive.ObjectID]set
+}
+
+func newMembership(org *mongo_entity.Organization) membership {
+
+	m := membership{
+		users:      map[primitive.ObjectID]mongo_entity.AssignedUser{},
+		roles:      map[primitive.ObjectID]mongo_entity.AssignedRole{},
+		userRoles:  map[primitive.ObjectID]set{},
+		userGroups: map[primitive.ObjectID]set{},
+		groupRoles: map[primitive.ObjectID]set{},
+	}
+	for _, role := range org.Roles {
+		m.roles[role.ID] = mongo_entity.AssignedRole{ID: role.ID, Identifier: role.Identifier, DisplayName: role.DisplayName}
+	}
+	for _, user := range org.Users {
+		m.users[user.ID] = mongo_entity.AssignedUser{ID: user.ID, Username: user.Username, Identifier: user.Identifier}
+		link(m.userRoles, Links(user.ID, user.Roles), true)
+		link(m.userGroups, Links(user.ID, user.Groups), true)
+	}
+	for _, group := range org.Groups {
+		link(m.groupRoles, Links(group.ID, group.Roles), true)
+	}
+	return m
+}
+
+func link(links map[primitive.ObjectID]set, changed []Link, added bool) {
+
+	for _, l := range changed {
+		if links[l.From] == nil {
+			links[l.From] = set{}
+		}
+		if added {
+			links[l.From][l.To] = true
+		} else {
+			delete(links[l.From], l.To)
+		}
+	}
+}
+
+func (m membership) apply(change Change) {
+
+	link(m.userRoles, change.AddedUserRoles, true)
+	link(m.userRoles, change.RemovedUserRoles, false)
+	link(m.userGroups, change.AddedUserGroups, true)
+	link(m.userGroups, change.RemovedUserGroups, false)
+	link(m.groupRoles, change.AddedGroupRoles, true)
+	link(m.groupRoles, change.RemovedGroupRoles, false)
+}
+
+// holds returns whether the user holds the role directly or through a group.
+func (m membership) holds(userId primitive.ObjectID, roleId primitive.ObjectID) bool {
+
+	if m.userRoles[userId][roleId] {
+		return true
+	}
+	for groupId := range m.userGroups[userId] {
+		if m.groupRoles[groupId][roleId] {
+			return true
+		}
+	}
+	return false
+}
+
+// userIds returns the ids of the users with assignments in a stable order.
+func (m membership) userIds() []primitive.ObjectID {
+
+	seen := set{}
+	for userId := range m.userRoles {
+		seen[userId] = true
+	}
+	for userId := range m.userGroups {
+		seen[userId] = true
+	}
+	ids := make([]primitive.ObjectID, 0, len(seen))
+	for userId := range seen {
+		ids = append(ids, userId)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Hex() < ids[j].Hex() })
+	return ids
+}
+
+func (m membership) user(id primitive.ObjectID) mongo_entity.AssignedUser {
+
+	if user, ok := m.users[id]; ok {
+		return user
+	}
+	return mongo_entity.AssignedUser{ID: id, Identifier: id.Hex()}
+}
+
+func (m membership) role(id primitive.ObjectID) mongo_entity.AssignedRole {
+
+	if role, ok := m.roles[id]; ok {
+		return role
+	}
+	return mongo_entity.AssignedRole{ID: id, Identifier: id.Hex()}
+}
+
+// violations returns the violations of the rules.
+func (m membership) violations(rules Rules) []Violation {
+
+	violations := []Violation{}
+	userIds := m.userIds()
+	for i, rule := range rules.ExclusiveRoles {
+		for _, userId := range userIds {
+			held := []mongo_entity.AssignedRole{}
+			for _, roleId := range rule.Roles {
+				if m.holds(userId, roleId) {
+					held = append(held, m.role(roleId))
+				}
+			}
+			if len(held) > 1 {
+				violations = append(violations, Violation{
+					Rule:  RuleExclusiveRoles,
+					Name:  rule.Name,
+					Roles: held,
+					Users: []mongo_entity.AssignedUser{m.user(userId)},
+					key:   fmt.Sprintf("%s/%d/%s", RuleExclusiveRoles, i, userId.Hex()),
+				})
+			}
+		}
+	}
+	for i, rule := range rules.RoleCardinalities {
+		holders := []mongo_entity.AssignedUser{}
+		for _, userId := range userIds {
+			if m.holds(userId, rule.Role) {
+				holders = append(holders, m.user(userId))
+			}
+		}
+		if len(holders) > rule.MaxUsers {
+			violations = append(violations, Violation{
+				Rule:     RuleRoleCardinality,
+				MaxUsers: rule.MaxUsers,
+				Roles:    []mongo_entity.AssignedRole{m.role(rule.Role)},
+				Users:    holders,
+				key:      fmt.Sprintf("%s/%d", RuleRoleCardinality, i),
+			})
+		}
+	}
+	return violations
+}
@@ -0,0 +1,101 @@
+package sod
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+func TestService(t *testing.T) {
+
+	orgId := primitive.NewObjectID().Hex()
+	creator := mongo_entity.Role{ID: primitive.NewObjectID(), Identifier: "payment-creator"}
+	approver := mongo_entity.Role{ID: primitive.NewObjectID(), Identifier: "payment-approver"}
+	finance := mongo_entity.Group{ID: primitive.NewObjectID(), Identifier: "finance", Roles: []primitive.ObjectID{approver.ID}}
+	alice := mongo_entity.User{ID: primitive.NewObjectID(), Identifier: "alice", Roles: []primitive.ObjectID{creator.ID}}
+	bob := mongo_entity.User{ID: primitive.NewObjectID(), Identifier: "bob", Groups: []primitive.ObjectID{finance.ID}}
+	repo := &mockRepository{org: mongo_entity.Organization{
+		Users:  []mongo_entity.User{alice, bob},
+		Groups: []mongo_entity.Group{finance},
+		Roles:  []mongo_entity.Role{creator, approver},
+	}}
+	s := NewService(repo, zap.NewNop())
+	ctx := context.Background()
+
+	// without rules every change is allowed
+	assert.Nil(t, s.Enforce(ctx, orgId, Change{AddedUserRoles: Links(alice.ID, []primitive.ObjectID{approver.ID})}))
+
+	invalid := []UpdateRulesRequest{
+		{ExclusiveRoles: []ExclusiveRoles{{mongo_entity.ExclusiveRoles{Roles: []primitive.ObjectID{creator.ID, approver.ID}}}}},
+		{ExclusiveRoles: []ExclusiveRoles{{mongo_entity.ExclusiveRoles{Name: "payments", Roles: []primitive.ObjectID{creator.ID}}}}},
+		{ExclusiveRoles: []ExclusiveRoles{{mongo_entity.ExclusiveRoles{Name: "payments", Roles: []primitive.ObjectID{creator.ID, primitive.NewObjectID()}}}}},
+		{RoleCardinalities: []RoleCardinality{{mongo_entity.RoleCardinality{Role: approver.ID}}}},
+		{RoleCardinalities: []RoleCardinality{{mongo_entity.RoleCardinality{Role: primitive.NewObjectID(), MaxUsers: 1}}}},
+	}
+	for i, req := range invalid {
+		_, err := s.Update(ctx, orgId, req)
+		assert.IsType(t, &util.InvalidInputError{}, err, i)
+	}
+	rules, err := s.Update(ctx, orgId, UpdateRulesRequest{
+		ExclusiveRoles:    []ExclusiveRoles{{mongo_entity.ExclusiveRoles{Name: "payments", Roles: []primitive.ObjectID{creator.ID, approver.ID}}}},
+		RoleCardinalities: []RoleCardinality{{mongo_entity.RoleCardinality{Role: approver.ID, MaxUsers: 1}}},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, rules.ExclusiveRoles, 1)
+
+	// exclusive roles held directly or through groups
+	err = s.Enforce(ctx, orgId, Change{AddedUserRoles: Links(alice.ID, []primitive.ObjectID{approver.ID})})
+	assert.IsType(t, &util.ConflictError{}, err)
+	assert.Contains(t, err.Error(), "alice")
+	assert.IsType(t, &util.ConflictError{}, s.Enforce(ctx, orgId, Change{AddedUserGroups: Linked([]primitive.ObjectID{alice.ID}, finance.ID)}))
+	assert.IsType(t, &util.ConflictError{}, s.Enforce(ctx, orgId, Change{AddedUserRoles: Links(bob.ID, []primitive.ObjectID{creator.ID})}))
+	assert.IsType(t, &util.ConflictError{}, s.Enforce(ctx, orgId, Change{AddedGroupRoles: Links(finance.ID, []primitive.ObjectID{creator.ID})}))
+	// swapping roles in one change is allowed
+	assert.Nil(t, s.Enforce(ctx, orgId, Change{
+		AddedUserRoles:    Links(alice.ID, []primitive.ObjectID{approver.ID}),
+		RemovedUserRoles:  Links(alice.ID, []primitive.ObjectID{creator.ID}),
+		RemovedUserGroups: Linked([]primitive.ObjectID{bob.ID}, finance.ID),
+	}))
+
+	// role cardinality, including new users
+	newUser := primitive.NewObjectID()
+	err = s.Enforce(ctx, orgId, Change{AddedUserRoles: Links(newUser, []primitive.ObjectID{approver.ID})})
+	assert.IsType(t, &util.ConflictError{}, err)
+	assert.Contains(t, err.Error(), "payment-approver")
+	assert.Nil(t, s.Enforce(ctx, orgId, Change{AddedUserRoles: Links(newUser, []primitive.ObjectID{creator.ID})}))
+
+	report, err := s.Report(ctx, orgId)
+	assert.Nil(t, err)
+	assert.Empty(t, report.Violations)
+
+	// existing violations are reported and do not block other changes
+	repo.org.Users[0].Groups = []primitive.ObjectID{finance.ID}
+	report, _ = s.Report(ctx, orgId)
+	assert.Len(t, report.Violations, 2)
+	assert.Equal(t, RuleExclusiveRoles, report.Violations[0].Rule)
+	assert.Equal(t, "alice", report.Violations[0].Users[0].Identifier)
+	assert.Equal(t, RuleRoleCardinality, report.Violations[1].Rule)
+	assert.Len(t, report.Violations[1].Users, 2)
+	assert.Nil(t, s.Enforce(ctx, orgId, Change{RemovedUserGroups: Linked([]primitive.ObjectID{bob.ID}, finance.ID)}))
+	assert.Nil(t, s.Enforce(ctx, orgId, Change{AddedUserRoles: Links(newUser, []primitive.ObjectID{creator.ID})}))
+	assert.IsType(t, &util.ConflictError{}, s.Enforce(ctx, orgId, Change{AddedUserRoles: Links(newUser, []primitive.ObjectID{approver.ID})}))
+}
+
+type mockRepository struct {
+	org mongo_entity.Organization
+}
+
+func (m *mockRepository) Get(ctx context.Context, org_id string) (*mongo_entity.Organization, error) {
+	org := m.org
+	return &org, nil
+}
+
+func (m *mockRepository) Update(ctx context.Context, org_id string, rules mongo_entity.SeparationOfDuties) error {
+	m.org.SeparationOfDuties = &rules
+	return nil
+}
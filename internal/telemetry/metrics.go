@@ -0,0 +1,82 @@
+package telemetry
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "cronuseo"
+
+var (
+	checkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "check_duration_seconds",
+		Help:      "Latency of permission checks.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"organization"})
+
+	checkDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "check_decisions_total",
+		Help:      "Permission check decisions by organization and result (allow, deny or error).",
+	}, []string{"organization", "decision"})
+
+	repositoryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "repository_duration_seconds",
+		Help:      "Latency of MongoDB operations by repository method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"repository", "method"})
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	grpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "gRPC requests by method and status code.",
+	}, []string{"method", "code"})
+
+	grpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Latency of gRPC requests by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+func init() {
+	prometheus.MustRegister(checkDuration, checkDecisions, repositoryDuration, httpRequests, httpDuration, grpcRequests, grpcDuration)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+
+	return promhttp.Handler()
+}
+
+// ObserveCheck records the latency and decision of a permission check.
+func ObserveCheck(org_identifier string, allowed bool, err error, duration time.Duration) {
+
+	decision := "deny"
+	if err != nil {
+		decision = "error"
+	} else if allowed {
+		decision = "allow"
+	}
+	checkDuration.WithLabelValues(org_identifier).Observe(duration.Seconds())
+	checkDecisions.WithLabelValues(org_identifier, decision).Inc()
+}
@@ -0,0 +1,73 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestMiddleware(t *testing.T) {
+
+	shutdown, err := InitTracing(config.Tracing{}, "cronuseo", zap.NewNop())
+	assert.Nil(t, err)
+	defer shutdown(context.Background())
+
+	e := echo.New()
+	e.Use(Middleware())
+	var traceID string
+	e.GET("/o/:org/items", func(c echo.Context) error {
+		traceID = trace.SpanContextFromContext(c.Request().Context()).TraceID().String()
+		return echo.NewHTTPError(http.StatusNotFound)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/o/acme/items", nil)
+	req.Header.Set("traceparent", traceparent)
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	assert.Equal(t, float64(1), testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/o/:org/items", "404")))
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+
+	_, err := InitTracing(config.Tracing{}, "cronuseo", zap.NewNop())
+	assert.Nil(t, err)
+
+	interceptor := UnaryServerInterceptor()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", traceparent))
+	info := &grpc.UnaryServerInfo{FullMethod: "/cronuseo.Check/Check"}
+	var traceID string
+	_, err = interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		traceID = trace.SpanContextFromContext(ctx).TraceID().String()
+		return nil, status.Error(codes.NotFound, "user not found")
+	})
+
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	assert.Equal(t, float64(1), testutil.ToFloat64(grpcRequests.WithLabelValues("/cronuseo.Check/Check", "NotFound")))
+}
+
+func TestRepository(t *testing.T) {
+
+	_, span := Repository(nil, "user", "Get")
+	span.End()
+	assert.Equal(t, 1, testutil.CollectAndCount(repositoryDuration))
+
+	ObserveCheck("acme", true, nil, 0)
+	ObserveCheck("acme", false, nil, 0)
+	assert.Equal(t, float64(1), testutil.ToFloat64(checkDecisions.WithLabelValues("acme", "deny")))
+}
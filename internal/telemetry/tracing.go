@@ -0,0 +1,88 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const instrumentation = "github.com/shashimalcse/cronuseo"
+
+// InitTracing installs the W3C trace context propagator and, when tracing is
+// enabled, a tracer provider exporting spans over OTLP/gRPC. The returned
+// function flushes and stops the exporter.
+func InitTracing(cfg config.Tracing, service string, logger *zap.Logger) (func(context.Context) error, error) {
+
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options := []otlptracegrpc.Option{}
+	if cfg.Endpoint != "" {
+		options = append(options, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ServiceName != "" {
+		service = cfg.ServiceName
+	}
+	ratio := cfg.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service))),
+	)
+	otel.SetTracerProvider(provider)
+	logger.Info("Tracing enabled", zap.String("endpoint", cfg.Endpoint), zap.Float64("sample_ratio", ratio))
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the span in the context.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return otel.Tracer(instrumentation).Start(ctx, name, opts...)
+}
+
+// Operation is the span of a repository method, which records the method
+// latency when it ends.
+type Operation struct {
+	trace.Span
+	repository string
+	method     string
+	start      time.Time
+}
+
+// Repository starts the span of a repository method.
+func Repository(ctx context.Context, repository string, method string) (context.Context, *Operation) {
+
+	ctx, span := Start(ctx, repository+".repository."+method, trace.WithSpanKind(trace.SpanKindClient))
+	return ctx, &Operation{Span: span, repository: repository, method: method, start: time.Now()}
+}
+
+// End ends the span and records the latency.
+func (o *Operation) End(options ...trace.SpanEndOption) {
+
+	repositoryDuration.WithLabelValues(o.repository, o.method).Observe(time.Since(o.start).Seconds())
+	o.Span.End(options...)
+}
@@ -0,0 +1,183 @@
+package token
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MicahParks/keyfunc"
+	jwtv4 "github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+)
+
+// jwksRetryInterval limits how often an unreachable JWKS endpoint is retried.
+const jwksRetryInterval = 10 * time.Second
+
+var errKeysUnavailable = errors.New("signing keys are not available")
+
+// keySource returns the candidate verification keys of a token.
+type keySource interface {
+	keys(token *jwtv4.Token) ([]interface{}, error)
+}
+
+// staticKeys are keys loaded from local PEM or JWK set files.
+type staticKeys struct {
+	byKID map[string]interface{}
+}
+
+// loadKeyFiles loads public keys from PEM files (public keys or certificates)
+// and JSON JWK set files.
+func loadKeyFiles(files []string) (*staticKeys, error) {
+
+	s := &staticKeys{byKID: map[string]interface{}{}}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+			jwks, err := keyfunc.NewJSON(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("invalid JWK set %s: %v", file, err)
+			}
+			for kid, key := range jwks.ReadOnlyKeys() {
+				s.byKID[kid] = key
+			}
+			continue
+		}
+		keys, err := parsePEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PEM file %s: %v", file, err)
+		}
+		for i, key := range keys {
+			s.byKID[file+"#"+strconv.Itoa(i)] = key
+		}
+	}
+	if len(s.byKID) == 0 {
+		return nil, fmt.Errorf("no keys found in %v", files)
+	}
+	return s, nil
+}
+
+// keys returns the key with the kid of the token, or every key when the token
+// has no kid or an unknown one.
+func (s *staticKeys) keys(token *jwtv4.Token) ([]interface{}, error) {
+
+	if kid, ok := token.Header["kid"].(string); ok {
+		if key, ok := s.byKID[kid]; ok {
+			return []interface{}{key}, nil
+		}
+	}
+	kids := make([]string, 0, len(s.byKID))
+	for kid := range s.byKID {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	keys := make([]interface{}, 0, len(kids))
+	for _, kid := range kids {
+		keys = append(keys, s.byKID[kid])
+	}
+	return keys, nil
+}
+
+func parsePEM(data []byte) ([]interface{}, error) {
+
+	keys := []interface{}{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys or certificates")
+	}
+	return keys, nil
+}
+
+// remoteKeys are fetched from a JWKS endpoint. The first fetch happens on
+// demand, so an unreachable endpoint at startup only fails requests until it
+// becomes reachable.
+type remoteKeys struct {
+	url         string
+	logger      *zap.Logger
+	mu          sync.Mutex
+	jwks        *keyfunc.JWKS
+	lastAttempt time.Time
+}
+
+func newRemoteKeys(url string, logger *zap.Logger) *remoteKeys {
+
+	r := &remoteKeys{url: url, logger: logger}
+	if _, err := r.get(); err != nil {
+		logger.Error("Failed to fetch JWKS, retrying on demand.", zap.String("jwks", url), zap.Error(err))
+	}
+	return r
+}
+
+func (r *remoteKeys) get() (*keyfunc.JWKS, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.jwks != nil {
+		return r.jwks, nil
+	}
+	if time.Since(r.lastAttempt) < jwksRetryInterval {
+		return nil, errKeysUnavailable
+	}
+	r.lastAttempt = time.Now()
+	jwks, err := keyfunc.Get(r.url, keyfunc.Options{
+		RefreshErrorHandler: func(err error) {
+			r.logger.Error("There was an error with the jwt.KeyFunc", zap.String("jwks", r.url), zap.Error(err))
+		},
+		RefreshInterval:   time.Hour,
+		RefreshRateLimit:  time.Minute,
+		RefreshTimeout:    10 * time.Second,
+		RefreshUnknownKID: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.jwks = jwks
+	return jwks, nil
+}
+
+func (r *remoteKeys) keys(token *jwtv4.Token) ([]interface{}, error) {
+
+	jwks, err := r.get()
+	if err != nil {
+		return nil, errKeysUnavailable
+	}
+	key, err := jwks.Keyfunc(token)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{key}, nil
+}
@@ -0,0 +1,185 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	jwtv4 "github.com/golang-jwt/jwt/v4"
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"go.uber.org/zap"
+)
+
+// Defaults of the tokens issued by cronuseo.
+const (
+	DefaultIssuer   = "cronuseo"
+	DefaultAudience = "cronuseo"
+	DefaultTokenTTL = 15 * time.Minute
+)
+
+// OrganizationClaim holds the organization identifier in tokens issued by
+// cronuseo.
+const OrganizationClaim = "org"
+
+// JWK is a public JSON web key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKSet is a published set of public keys.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Signer signs the tokens cronuseo issues with an RSA key.
+type Signer struct {
+	key      *rsa.PrivateKey
+	kid      string
+	issuer   string
+	audience string
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+// NewSigner loads the signing key of the configuration. It returns nil when no
+// key file is configured, a generated key would invalidate the issued tokens
+// on restart and could not be shared between replicas.
+func NewSigner(cfg config.ServiceAccounts, logger *zap.Logger) (*Signer, error) {
+
+	if cfg.SigningKeyFile == "" {
+		logger.Warn("No service account signing key configured, service account tokens are disabled.")
+		return nil, nil
+	}
+	key, err := loadPrivateKey(cfg.SigningKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return newSigner(cfg, key)
+}
+
+func newSigner(cfg config.ServiceAccounts, key *rsa.PrivateKey) (*Signer, error) {
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	s := &Signer{
+		key:      key,
+		kid:      base64.RawURLEncoding.EncodeToString(sum[:16]),
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		ttl:      cfg.TokenTTL,
+		now:      time.Now,
+	}
+	if s.issuer == "" {
+		s.issuer = DefaultIssuer
+	}
+	if s.audience == "" {
+		s.audience = DefaultAudience
+	}
+	if s.ttl <= 0 {
+		s.ttl = DefaultTokenTTL
+	}
+	return s, nil
+}
+
+func loadPrivateKey(file string) (*rsa.PrivateKey, error) {
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", file)
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+			return rsaKey, nil
+		}
+		return nil, errors.New("signing key must be an RSA key")
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, file)
+	}
+}
+
+// Sign issues a token for the subject of the organization. It returns the
+// token and its lifetime.
+func (s *Signer) Sign(subject string, organization string, claims jwtv4.MapClaims) (string, time.Duration, error) {
+
+	now := s.now()
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", 0, err
+	}
+	all := jwtv4.MapClaims{}
+	for k, v := range claims {
+		all[k] = v
+	}
+	all["iss"] = s.issuer
+	all["aud"] = s.audience
+	all["sub"] = subject
+	all[OrganizationClaim] = organization
+	all["iat"] = now.Unix()
+	all["nbf"] = now.Unix()
+	all["exp"] = now.Add(s.ttl).Unix()
+	all["jti"] = base64.RawURLEncoding.EncodeToString(jti)
+
+	token := jwtv4.NewWithClaims(jwtv4.SigningMethodRS256, all)
+	token.Header["kid"] = s.kid
+	raw, err := token.SignedString(s.key)
+	if err != nil {
+		return "", 0, err
+	}
+	return raw, s.ttl, nil
+}
+
+// JWKS returns the public key set of the signer.
+func (s *Signer) JWKS() JWKSet {
+
+	return JWKSet{Keys: []JWK{{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: s.kid,
+		N:   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+	}}}
+}
+
+// trustedIssuer returns the issuer of the tokens signed by s. The tokens are
+// restricted to the organization of the service account.
+func (s *Signer) trustedIssuer() issuer {
+
+	return issuer{
+		Issuer: config.Issuer{
+			Name:              DefaultIssuer,
+			Issuer:            s.issuer,
+			Audiences:         []string{s.audience},
+			OrganizationClaim: OrganizationClaim,
+		},
+		source:   &staticKeys{byKID: map[string]interface{}{s.kid: &s.key.PublicKey}},
+		internal: true,
+	}
+}
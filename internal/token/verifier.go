@@ -0,0 +1,243 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	jwtv4 "github.com/golang-jwt/jwt/v4"
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"go.uber.org/zap"
+)
+
+// signingMethods are the accepted JWT algorithms. Symmetric algorithms are not
+// accepted because trusted issuers only publish public keys.
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// Identity is the verified identity of a token.
+type Identity struct {
+	Subject string
+	Issuer  string
+	// Organization restricts the identity to one organization when the issuer
+	// maps tokens to organizations. Claimed is set when it was taken from a
+	// claim of a token of an external issuer.
+	Organization string
+	Claimed      bool
+	Claims       jwtv4.MapClaims
+}
+
+type issuer struct {
+	config.Issuer
+	source keySource
+	// internal is set for the issuer of service account tokens.
+	internal bool
+}
+
+// Verifier verifies JWTs of the trusted issuers.
+type Verifier struct {
+	issuers []issuer
+	leeway  time.Duration
+	now     func() time.Time
+}
+
+// NewVerifier creates a verifier for the configured issuers and, when signer
+// is not nil, the service account tokens cronuseo issues. The legacy
+// auth.jwks URL is trusted as an issuer without iss and aud checks when no
+// issuers are configured.
+func NewVerifier(cfg config.Auth, signer *Signer, logger *zap.Logger) (*Verifier, error) {
+
+	issuers := cfg.Issuers
+	if len(issuers) == 0 && cfg.JWKS != "" {
+		issuers = []config.Issuer{{Name: "default", JWKS: cfg.JWKS}}
+	}
+	if len(issuers) == 0 && signer == nil {
+		return nil, errors.New("no trusted token issuers are configured")
+	}
+
+	v := &Verifier{leeway: cfg.Leeway, now: time.Now}
+	if signer != nil {
+		v.issuers = append(v.issuers, signer.trustedIssuer())
+	}
+	fallbacks, unmapped := 0, 0
+	for _, iss := range issuers {
+		if signer != nil && iss.Issuer == signer.issuer {
+			return nil, fmt.Errorf("issuer %s: %s is the issuer of service account tokens", iss.Name, iss.Issuer)
+		}
+		if iss.Issuer == "" {
+			fallbacks++
+		}
+		if iss.OrganizationClaim != "" && iss.Organization != "" {
+			return nil, fmt.Errorf("issuer %s: organization and organization_claim are exclusive", iss.Name)
+		}
+		if iss.OrganizationClaim == "" && iss.Organization == "" {
+			unmapped++
+		}
+		var source keySource
+		switch {
+		case iss.JWKS != "" && len(iss.KeyFiles) > 0:
+			return nil, fmt.Errorf("issuer %s: jwks and key_files are exclusive", iss.Name)
+		case iss.JWKS != "":
+			source = newRemoteKeys(iss.JWKS, logger)
+		case len(iss.KeyFiles) > 0:
+			keys, err := loadKeyFiles(iss.KeyFiles)
+			if err != nil {
+				return nil, fmt.Errorf("issuer %s: %v", iss.Name, err)
+			}
+			source = keys
+		default:
+			return nil, fmt.Errorf("issuer %s: jwks or key_files is required", iss.Name)
+		}
+		v.issuers = append(v.issuers, issuer{Issuer: iss, source: source})
+	}
+	if fallbacks > 1 {
+		return nil, errors.New("only one issuer may omit the issuer value")
+	}
+	// Subjects of unmapped issuers are looked up in every organization, so
+	// they would collide between issuers.
+	if unmapped > 1 {
+		return nil, errors.New("only one issuer may omit organization and organization_claim")
+	}
+	return v, nil
+}
+
+// Verify checks the signature and the iss, aud, exp and nbf claims of a token.
+func (v *Verifier) Verify(raw string) (Identity, error) {
+
+	parser := jwtv4.NewParser(jwtv4.WithValidMethods(signingMethods), jwtv4.WithoutClaimsValidation())
+	unverified, _, err := parser.ParseUnverified(raw, jwtv4.MapClaims{})
+	if err != nil {
+		return Identity{}, errors.New("malformed token")
+	}
+	claims := unverified.Claims.(jwtv4.MapClaims)
+	iss, _ := claims["iss"].(string)
+	trusted, ok := v.issuer(iss)
+	if !ok {
+		return Identity{}, fmt.Errorf("untrusted issuer %q", iss)
+	}
+
+	keys, err := trusted.source.keys(unverified)
+	if err != nil {
+		return Identity{}, fmt.Errorf("no signing key: %v", err)
+	}
+	var token *jwtv4.Token
+	for _, key := range keys {
+		key := key
+		if token, err = parser.Parse(raw, func(*jwtv4.Token) (interface{}, error) { return key, nil }); err == nil {
+			break
+		}
+	}
+	if err != nil || token == nil || !token.Valid {
+		return Identity{}, errors.New("invalid token signature")
+	}
+	claims = token.Claims.(jwtv4.MapClaims)
+	if err := v.validateClaims(trusted, claims); err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{Issuer: iss, Organization: trusted.Organization, Claims: claims}
+	identity.Subject, _ = claims["sub"].(string)
+	if identity.Subject == "" {
+		return Identity{}, errors.New("invalid or missing sub claim")
+	}
+	if trusted.OrganizationClaim != "" {
+		identity.Organization, _ = claims[trusted.OrganizationClaim].(string)
+		identity.Claimed = !trusted.internal
+		if identity.Organization == "" {
+			return Identity{}, fmt.Errorf("missing %s claim", trusted.OrganizationClaim)
+		}
+	}
+	return identity, nil
+}
+
+// issuer returns the issuer with the iss value, or the issuer without one.
+func (v *Verifier) issuer(iss string) (issuer, bool) {
+
+	for _, i := range v.issuers {
+		if i.Issuer.Issuer != "" && i.Issuer.Issuer == iss {
+			return i, true
+		}
+	}
+	for _, i := range v.issuers {
+		if i.Issuer.Issuer == "" {
+			return i, true
+		}
+	}
+	return issuer{}, false
+}
+
+func (v *Verifier) validateClaims(trusted issuer, claims jwtv4.MapClaims) error {
+
+	now := v.now()
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return errors.New("missing or invalid exp claim")
+	}
+	if !now.Before(exp.Add(v.leeway)) {
+		return errors.New("token is expired")
+	}
+	if _, present := claims["nbf"]; present {
+		nbf, ok := numericDate(claims["nbf"])
+		if !ok {
+			return errors.New("invalid nbf claim")
+		}
+		if now.Add(v.leeway).Before(nbf) {
+			return errors.New("token is not valid yet")
+		}
+	}
+	if len(trusted.Audiences) > 0 && !audience(claims["aud"], trusted.Audiences) {
+		return errors.New("token audience is not accepted")
+	}
+	return nil
+}
+
+func numericDate(value interface{}) (time.Time, bool) {
+
+	switch v := value.(type) {
+	case float64:
+		return time.Unix(0, int64(v*float64(time.Second))), true
+	case int64:
+		return time.Unix(v, 0), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// audience reports whether the aud claim contains one of the accepted audiences.
+func audience(aud interface{}, accepted []string) bool {
+
+	var values []string
+	switch v := aud.(type) {
+	case string:
+		values = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	for _, value := range values {
+		for _, a := range accepted {
+			if value == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Ready returns an error when the keys of an issuer with a JWKS endpoint are
+// not loaded yet.
+func (v *Verifier) Ready() error {
+
+	for _, iss := range v.issuers {
+		remote, ok := iss.source.(*remoteKeys)
+		if !ok {
+			continue
+		}
+		if _, err := remote.get(); err != nil {
+			return fmt.Errorf("issuer %s: %v", iss.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwtv4 "github.com/golang-jwt/jwt/v4"
+	"github.com/shashimalcse/cronuseo/internal/config"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func writePEM(t *testing.T, key interface{}) string {
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	assert.Nil(t, err)
+	file := filepath.Join(t.TempDir(), "key.pem")
+	assert.Nil(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+	return file
+}
+
+func writeJWKS(t *testing.T, kid string, key *ecdsa.PublicKey) string {
+
+	encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.FillBytes(make([]byte, 32))) }
+	data, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{"kty": "EC", "crv": "P-256", "kid": kid, "x": encode(key.X), "y": encode(key.Y)}},
+	})
+	assert.Nil(t, err)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	assert.Nil(t, os.WriteFile(file, data, 0600))
+	return file
+}
+
+func sign(t *testing.T, method jwtv4.SigningMethod, kid string, key interface{}, claims jwtv4.MapClaims) string {
+
+	token := jwtv4.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	raw, err := token.SignedString(key)
+	assert.Nil(t, err)
+	return raw
+}
+
+func TestVerifier(t *testing.T) {
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	verifier, err := NewVerifier(config.Auth{
+		Leeway: time.Minute,
+		Issuers: []config.Issuer{
+			{Name: "corp", Issuer: "https://corp.example.com", Audiences: []string{"cronuseo"}, KeyFiles: []string{writePEM(t, &rsaKey.PublicKey)}},
+			{Name: "partner", Issuer: "https://partner.example.com", KeyFiles: []string{writeJWKS(t, "p1", &ecKey.PublicKey)}, OrganizationClaim: "org"},
+		},
+	}, nil, zap.NewNop())
+	assert.Nil(t, err)
+	now := time.Now()
+	exp := now.Add(time.Hour).Unix()
+
+	identity, err := verifier.Verify(sign(t, jwtv4.SigningMethodRS256, "", rsaKey, jwtv4.MapClaims{
+		"iss": "https://corp.example.com", "aud": []string{"other", "cronuseo"}, "sub": "jane", "exp": exp,
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, "jane", identity.Subject)
+	assert.Equal(t, "", identity.Organization)
+
+	identity, err = verifier.Verify(sign(t, jwtv4.SigningMethodES256, "p1", ecKey, jwtv4.MapClaims{
+		"iss": "https://partner.example.com", "sub": "joe", "org": "acme", "exp": exp,
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, "acme", identity.Organization)
+	assert.True(t, identity.Claimed)
+
+	rejected := map[string]string{
+		"wrong audience": sign(t, jwtv4.SigningMethodRS256, "", rsaKey, jwtv4.MapClaims{
+			"iss": "https://corp.example.com", "aud": "other", "sub": "jane", "exp": exp,
+		}),
+		"untrusted issuer": sign(t, jwtv4.SigningMethodRS256, "", rsaKey, jwtv4.MapClaims{
+			"iss": "https://evil.example.com", "aud": "cronuseo", "sub": "jane", "exp": exp,
+		}),
+		"key of another issuer": sign(t, jwtv4.SigningMethodES256, "p1", ecKey, jwtv4.MapClaims{
+			"iss": "https://corp.example.com", "aud": "cronuseo", "sub": "jane", "exp": exp,
+		}),
+		"expired": sign(t, jwtv4.SigningMethodRS256, "", rsaKey, jwtv4.MapClaims{
+			"iss": "https://corp.example.com", "aud": "cronuseo", "sub": "jane", "exp": now.Add(-2 * time.Minute).Unix(),
+		}),
+		"missing exp": sign(t, jwtv4.SigningMethodRS256, "", rsaKey, jwtv4.MapClaims{
+			"iss": "https://corp.example.com", "aud": "cronuseo", "sub": "jane",
+		}),
+		"not valid yet": sign(t, jwtv4.SigningMethodRS256, "", rsaKey, jwtv4.MapClaims{
+			"iss": "https://corp.example.com", "aud": "cronuseo", "sub": "jane", "exp": exp, "nbf": now.Add(10 * time.Minute).Unix(),
+		}),
+		"missing sub": sign(t, jwtv4.SigningMethodRS256, "", rsaKey, jwtv4.MapClaims{
+			"iss": "https://corp.example.com", "aud": "cronuseo", "exp": exp,
+		}),
+		"missing organization claim": sign(t, jwtv4.SigningMethodES256, "p1", ecKey, jwtv4.MapClaims{
+			"iss": "https://partner.example.com", "sub": "joe", "exp": exp,
+		}),
+		"symmetric": sign(t, jwtv4.SigningMethodHS256, "", []byte("secret"), jwtv4.MapClaims{
+			"iss": "https://corp.example.com", "aud": "cronuseo", "sub": "jane", "exp": exp,
+		}),
+		"malformed": "not-a-token",
+	}
+	for name, raw := range rejected {
+		_, err := verifier.Verify(raw)
+		assert.NotNil(t, err, name)
+	}
+
+	// exp and nbf are accepted within the leeway
+	_, err = verifier.Verify(sign(t, jwtv4.SigningMethodRS256, "", rsaKey, jwtv4.MapClaims{
+		"iss": "https://corp.example.com", "aud": "cronuseo", "sub": "jane",
+		"exp": now.Add(-30 * time.Second).Unix(), "nbf": now.Add(30 * time.Second).Unix(),
+	}))
+	assert.Nil(t, err)
+}
+
+func TestNewVerifier(t *testing.T) {
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	keyFile := writePEM(t, &rsaKey.PublicKey)
+
+	invalid := []config.Auth{
+		{},
+		{Issuers: []config.Issuer{{Name: "a"}}},
+		{Issuers: []config.Issuer{{Name: "a", JWKS: "http://localhost/jwks", KeyFiles: []string{keyFile}}}},
+		{Issuers: []config.Issuer{{Name: "a", KeyFiles: []string{keyFile}, Organization: "acme", OrganizationClaim: "org"}}},
+		{Issuers: []config.Issuer{{Name: "a", KeyFiles: []string{keyFile}}, {Name: "b", KeyFiles: []string{keyFile}}}},
+		// subjects of unmapped issuers would share one namespace
+		{Issuers: []config.Issuer{{Name: "a", Issuer: "https://a", KeyFiles: []string{keyFile}}, {Name: "b", Issuer: "https://b", KeyFiles: []string{keyFile}}}},
+		{Issuers: []config.Issuer{{Name: "a", KeyFiles: []string{filepath.Join(t.TempDir(), "missing.pem")}}}},
+	}
+	for i, cfg := range invalid {
+		_, err := NewVerifier(cfg, nil, zap.NewNop())
+		assert.NotNil(t, err, i)
+	}
+
+	// an issuer without an issuer value accepts tokens of any iss and maps
+	// them to its static organization
+	verifier, err := NewVerifier(config.Auth{Issuers: []config.Issuer{{Name: "local", KeyFiles: []string{keyFile}, Organization: "acme"}}}, nil, zap.NewNop())
+	assert.Nil(t, err)
+	identity, err := verifier.Verify(sign(t, jwtv4.SigningMethodRS256, "", rsaKey, jwtv4.MapClaims{"sub": "jane", "exp": time.Now().Add(time.Hour).Unix()}))
+	assert.Nil(t, err)
+	assert.Equal(t, "acme", identity.Organization)
+	assert.Nil(t, verifier.Ready())
+
+	// a JWKS endpoint that cannot be fetched makes the verifier not ready
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	verifier, err = NewVerifier(config.Auth{JWKS: unreachable.URL}, nil, zap.NewNop())
+	assert.Nil(t, err)
+	assert.NotNil(t, verifier.Ready())
+}
+
+func TestSigner(t *testing.T) {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	signer, err := newSigner(config.ServiceAccounts{TokenTTL: time.Minute}, key)
+	assert.Nil(t, err)
+
+	raw, ttl, err := signer.Sign("billing-job", "acme", jwtv4.MapClaims{"client_id": "sa_1"})
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	verifier, err := NewVerifier(config.Auth{}, signer, zap.NewNop())
+	assert.Nil(t, err)
+	identity, err := verifier.Verify(raw)
+	assert.Nil(t, err)
+	assert.Equal(t, "billing-job", identity.Subject)
+	assert.Equal(t, DefaultIssuer, identity.Issuer)
+	assert.Equal(t, "acme", identity.Organization)
+	assert.False(t, identity.Claimed)
+	assert.Equal(t, "sa_1", identity.Claims["client_id"])
+
+	// the published JWKS verifies the issued tokens
+	data, err := json.Marshal(signer.JWKS())
+	assert.Nil(t, err)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	assert.Nil(t, os.WriteFile(file, data, 0600))
+	external, err := NewVerifier(config.Auth{Issuers: []config.Issuer{{Name: "cronuseo", Issuer: DefaultIssuer, KeyFiles: []string{file}}}}, nil, zap.NewNop())
+	assert.Nil(t, err)
+	_, err = external.Verify(raw)
+	assert.Nil(t, err)
+
+	// tokens of other keys claiming the cronuseo issuer are rejected
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	forged := sign(t, jwtv4.SigningMethodRS256, "", other, jwtv4.MapClaims{
+		"iss": DefaultIssuer, "aud": DefaultAudience, "sub": "root", "org": "acme", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	_, err = verifier.Verify(forged)
+	assert.NotNil(t, err)
+
+	// configured issuers cannot reuse the issuer of service account tokens
+	_, err = NewVerifier(config.Auth{Issuers: []config.Issuer{{Name: "a", Issuer: DefaultIssuer, KeyFiles: []string{file}}}}, signer, zap.NewNop())
+	assert.NotNil(t, err)
+}
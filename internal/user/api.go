@@ -17,6 +17,7 @@ func RegisterHandlers(r *echo.Group, service Service) {
 	router.PUT("/:id", res.update)
 	router.PATCH("/:id", res.patch)
 	router.POST("/sync", res.sync)
+	router.POST("/sync/batch", res.syncBatch)
 }
 
 type resource struct {
@@ -175,3 +176,26 @@ func (r resource) sync(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, user)
 }
+
+// @Description Sync users in a batch.
+// @Tags        User
+// @Accept      json
+// @Param org_id path string true "Organization ID"
+// @Param request body SyncBatchRequest true "body"
+// @Produce     json
+// @Success     200 {object}  SyncBatchResponse
+// @failure     400,403,500
+// @Router      /{org_id}/users/sync/batch [post]
+func (r resource) syncBatch(c echo.Context) error {
+
+	var input SyncBatchRequest
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	response, err := r.service.SyncBatch(c.Request().Context(), c.Param("org_id"), input)
+	if err != nil {
+		return util.HandleError(err)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
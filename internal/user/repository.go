@@ -3,8 +3,11 @@ package user
 import (
 	"context"
 
+	"github.com/shashimalcse/cronuseo/internal/assignment"
 	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/orgtree"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
 	"github.com/shashimalcse/cronuseo/internal/util"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -22,6 +25,7 @@ type Repository interface {
 	Delete(ctx context.Context, org_id string, id string) error
 	CheckUserExistById(ctx context.Context, org_id string, id string) (bool, error)
 	CheckUserExistsByIdentifier(ctx context.Context, org_id string, key string) (bool, error)
+	CheckServiceAccountExistsByIdentifier(ctx context.Context, org_id string, identifier string) (bool, error)
 	CheckRoleExistById(ctx context.Context, org_id string, id string) (bool, error)
 	CheckRoleAlreadyAssignToUserById(ctx context.Context, org_id string, user_id string, role_id string) (bool, error)
 	CheckGroupExistById(ctx context.Context, org_id string, id string) (bool, error)
@@ -29,6 +33,8 @@ type Repository interface {
 	CheckPolicyExistById(ctx context.Context, org_id string, id string) (bool, error)
 	CheckPolicyAlreadyAssignToUserById(ctx context.Context, org_id string, user_id string, policy_id string) (bool, error)
 	GetOrgIdByIdentifier(ctx context.Context, identifier string) (string, error)
+	GetMembers(ctx context.Context, org_id string) (*mongo_entity.Organization, error)
+	GetInheritedRoles(ctx context.Context, org_id string) ([]mongo_entity.Role, error)
 }
 
 type repository struct {
@@ -46,6 +52,9 @@ func NewRepository(mongodb *db.MongoDB) Repository {
 // Get user by id.
 func (r repository) Get(ctx context.Context, org_id string, id string) (*UserResponse, error) {
 
+	ctx, span := telemetry.Repository(ctx, "user", "Get")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return nil, err
@@ -60,7 +69,7 @@ func (r repository) Get(ctx context.Context, org_id string, id string) (*UserRes
 	filter := bson.M{"_id": orgId, "users._id": userId}
 	projection := bson.M{"users.$": 1}
 	// Find the user document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return nil, err
 	}
@@ -88,6 +97,8 @@ func (r repository) Get(ctx context.Context, org_id string, id string) (*UserRes
 		Roles:          assignedRoles,
 		Groups:         assignedGroups,
 		Policies:       assignedPolicies,
+		RoleGrants:     user.RoleGrants,
+		GroupGrants:    user.GroupGrants,
 	}
 	return &userResponse, nil
 }
@@ -95,6 +106,9 @@ func (r repository) Get(ctx context.Context, org_id string, id string) (*UserRes
 // Get user id by identifier.
 func (r repository) GetIdByIdentifier(ctx context.Context, org_id string, identifier string) (string, error) {
 
+	ctx, span := telemetry.Repository(ctx, "user", "GetIdByIdentifier")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return "", err
@@ -104,7 +118,7 @@ func (r repository) GetIdByIdentifier(ctx context.Context, org_id string, identi
 	filter := bson.M{"_id": orgId, "users.identifier": identifier}
 	projection := bson.M{"users.$": 1}
 	// Find the user document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return "", err
 	}
@@ -124,6 +138,9 @@ func (r repository) GetIdByIdentifier(ctx context.Context, org_id string, identi
 // Create new user.
 func (r repository) Create(ctx context.Context, org_id string, user mongo_entity.User) error {
 
+	ctx, span := telemetry.Repository(ctx, "user", "Create")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -166,6 +183,9 @@ func (r repository) Create(ctx context.Context, org_id string, user mongo_entity
 
 func (r repository) Update(ctx context.Context, org_id string, id string, update_user UpdateUser) error {
 
+	ctx, span := telemetry.Repository(ctx, "user", "Update")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -178,9 +198,15 @@ func (r repository) Update(ctx context.Context, org_id string, id string, update
 
 	filter := bson.M{"_id": orgId, "users._id": userId}
 	update := bson.M{"$set": bson.M{}}
+	if update_user.Username != nil && *update_user.Username != "" {
+		update["$set"].(bson.M)["users.$.username"] = *update_user.Username
+	}
 	if update_user.UserProperties != nil {
 		update["$set"].(bson.M)["users.$.user_properties"] = *&update_user.UserProperties
 	}
+	if len(update["$set"].(bson.M)) == 0 {
+		return nil
+	}
 	_, err = r.mongoColl.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
 	if err != nil {
 		return err
@@ -191,6 +217,9 @@ func (r repository) Update(ctx context.Context, org_id string, id string, update
 
 func (r repository) Patch(ctx context.Context, org_id string, id string, patch_user PatchUser) error {
 
+	ctx, span := telemetry.Repository(ctx, "user", "Patch")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -236,6 +265,11 @@ func (r repository) Patch(ctx context.Context, org_id string, id string, patch_u
 
 	}
 
+	// set the grants of the added roles
+	if err := assignment.SetGrants(ctx, r.mongoColl, orgId, "users", userId, assignment.RoleGrants, patch_user.RoleGrants, patch_user.Window); err != nil {
+		return err
+	}
+
 	// remove roles
 	if len(patch_user.RemovedRoles) > 0 {
 
@@ -254,6 +288,10 @@ func (r repository) Patch(ctx context.Context, org_id string, id string, patch_u
 				return err
 			}
 		}
+
+		if err := assignment.RemoveGrants(ctx, r.mongoColl, orgId, "users", []primitive.ObjectID{userId}, assignment.RoleGrants, patch_user.RemovedRoles); err != nil {
+			return err
+		}
 	}
 
 	// add groups
@@ -279,6 +317,11 @@ func (r repository) Patch(ctx context.Context, org_id string, id string, patch_u
 
 	}
 
+	// set the grants of the added groups
+	if err := assignment.SetGrants(ctx, r.mongoColl, orgId, "users", userId, assignment.GroupGrants, patch_user.GroupGrants, patch_user.Window); err != nil {
+		return err
+	}
+
 	// remove groups
 	if len(patch_user.RemovedGroups) > 0 {
 
@@ -297,6 +340,10 @@ func (r repository) Patch(ctx context.Context, org_id string, id string, patch_u
 				return err
 			}
 		}
+
+		if err := assignment.RemoveGrants(ctx, r.mongoColl, orgId, "users", []primitive.ObjectID{userId}, assignment.GroupGrants, patch_user.RemovedGroups); err != nil {
+			return err
+		}
 	}
 
 	// add policies
@@ -329,6 +376,9 @@ func (r repository) Patch(ctx context.Context, org_id string, id string, patch_u
 // Delete existing user.
 func (r repository) Delete(ctx context.Context, org_id string, id string) error {
 
+	ctx, span := telemetry.Repository(ctx, "user", "Delete")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return err
@@ -343,7 +393,7 @@ func (r repository) Delete(ctx context.Context, org_id string, id string) error
 	filter := bson.M{"_id": orgId}
 	update := bson.M{"$pull": bson.M{"users": bson.M{"_id": userId}}}
 	// Find the user document in the "organizations" collection
-	result, err := r.mongoColl.UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(false))
+	result, err := r.mongoColl.UpdateOne(ctx, filter, update, options.Update().SetUpsert(false))
 	if err != nil {
 		return err
 	}
@@ -373,6 +423,9 @@ func (r repository) Delete(ctx context.Context, org_id string, id string) error
 // Get all users.
 func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.User, error) {
 
+	ctx, span := telemetry.Repository(ctx, "user", "Query")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return nil, err
@@ -382,7 +435,7 @@ func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.U
 	filter := bson.M{"_id": orgId}
 	projection := bson.M{"users.roles": 0, "users.groups": 0}
 	// Find the user document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return nil, err
 	}
@@ -402,6 +455,9 @@ func (r repository) Query(ctx context.Context, org_id string) (*[]mongo_entity.U
 // Check if user exists by id.
 func (r repository) CheckUserExistById(ctx context.Context, org_id string, id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "user", "CheckUserExistById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -415,7 +471,7 @@ func (r repository) CheckUserExistById(ctx context.Context, org_id string, id st
 	filter := bson.M{"_id": orgId, "users._id": userId}
 
 	// Search for the user in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the user was found
 	if result.Err() == nil {
@@ -430,6 +486,9 @@ func (r repository) CheckUserExistById(ctx context.Context, org_id string, id st
 // Check if user exists by key.
 func (r repository) CheckUserExistsByIdentifier(ctx context.Context, org_id string, identifier string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "user", "CheckUserExistsByIdentifier")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -437,7 +496,7 @@ func (r repository) CheckUserExistsByIdentifier(ctx context.Context, org_id stri
 	filter := bson.M{"_id": orgId, "users.identifier": identifier}
 
 	// Search for the user in the "organizations" collection
-	count, err := r.mongoColl.CountDocuments(context.Background(), filter)
+	count, err := r.mongoColl.CountDocuments(ctx, filter)
 
 	if err != nil {
 		return false, err
@@ -448,10 +507,31 @@ func (r repository) CheckUserExistsByIdentifier(ctx context.Context, org_id stri
 	return false, nil
 }
 
+// Check if a service account uses the identifier.
+func (r repository) CheckServiceAccountExistsByIdentifier(ctx context.Context, org_id string, identifier string) (bool, error) {
+
+	ctx, span := telemetry.Repository(ctx, "user", "CheckServiceAccountExistsByIdentifier")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return false, err
+	}
+	count, err := r.mongoColl.CountDocuments(ctx, bson.M{"_id": orgId, "service_accounts.identifier": identifier})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
 // Check if role exists by id.
 func (r repository) CheckRoleExistById(ctx context.Context, org_id string, id string) (bool, error) {
 
-	orgId, err := primitive.ObjectIDFromHex(org_id)
+	ctx, span := telemetry.Repository(ctx, "user", "CheckRoleExistById")
+	defer span.End()
+
+	// Roles of ancestor organizations are inherited.
+	lineage, err := orgtree.LineageOf(ctx, r.mongoColl, org_id)
 	if err != nil {
 		return false, err
 	}
@@ -461,10 +541,10 @@ func (r repository) CheckRoleExistById(ctx context.Context, org_id string, id st
 		return false, err
 	}
 
-	filter := bson.M{"_id": orgId, "roles._id": roleId}
+	filter := bson.M{"_id": bson.M{"$in": lineage}, "roles._id": roleId}
 
 	// Search for the role in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the role was found
 	if result.Err() == nil {
@@ -479,6 +559,9 @@ func (r repository) CheckRoleExistById(ctx context.Context, org_id string, id st
 // Check if role already assign to user by id.
 func (r repository) CheckRoleAlreadyAssignToUserById(ctx context.Context, org_id string, user_id string, role_id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "user", "CheckRoleAlreadyAssignToUserById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -498,7 +581,7 @@ func (r repository) CheckRoleAlreadyAssignToUserById(ctx context.Context, org_id
 	projection := bson.M{"users.$": 1}
 	org := mongo_entity.Organization{}
 	// Search for the role in the "organizations" collection
-	err = r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection)).Decode(&org)
+	err = r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org)
 	if err != nil {
 		return false, err
 	}
@@ -517,6 +600,9 @@ func (r repository) CheckRoleAlreadyAssignToUserById(ctx context.Context, org_id
 // Check if group exists by id.
 func (r repository) CheckGroupExistById(ctx context.Context, org_id string, id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "user", "CheckGroupExistById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -530,7 +616,7 @@ func (r repository) CheckGroupExistById(ctx context.Context, org_id string, id s
 	filter := bson.M{"_id": orgId, "groups._id": groupId}
 
 	// Search for the group in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the group was found
 	if result.Err() == nil {
@@ -545,6 +631,9 @@ func (r repository) CheckGroupExistById(ctx context.Context, org_id string, id s
 // Check if group already assign to user by id.
 func (r repository) CheckGroupAlreadyAssignToUserById(ctx context.Context, org_id string, user_id string, group_id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "user", "CheckGroupAlreadyAssignToUserById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -564,7 +653,7 @@ func (r repository) CheckGroupAlreadyAssignToUserById(ctx context.Context, org_i
 	projection := bson.M{"users.$": 1}
 	org := mongo_entity.Organization{}
 	// Search for the role in the "organizations" collection
-	err = r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection)).Decode(&org)
+	err = r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org)
 	if err != nil {
 		return false, err
 	}
@@ -583,6 +672,9 @@ func (r repository) CheckGroupAlreadyAssignToUserById(ctx context.Context, org_i
 // Check if policy exists by id.
 func (r repository) CheckPolicyExistById(ctx context.Context, org_id string, id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "user", "CheckPolicyExistById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -596,7 +688,7 @@ func (r repository) CheckPolicyExistById(ctx context.Context, org_id string, id
 	filter := bson.M{"_id": orgId, "policies._id": groupId}
 
 	// Search for the policy in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter)
+	result := r.mongoColl.FindOne(ctx, filter)
 
 	// Check if the policy was found
 	if result.Err() == nil {
@@ -611,6 +703,9 @@ func (r repository) CheckPolicyExistById(ctx context.Context, org_id string, id
 // Check if policy already assign to user by id.
 func (r repository) CheckPolicyAlreadyAssignToUserById(ctx context.Context, org_id string, user_id string, policy_id string) (bool, error) {
 
+	ctx, span := telemetry.Repository(ctx, "user", "CheckPolicyAlreadyAssignToUserById")
+	defer span.End()
+
 	orgId, err := primitive.ObjectIDFromHex(org_id)
 	if err != nil {
 		return false, err
@@ -630,7 +725,7 @@ func (r repository) CheckPolicyAlreadyAssignToUserById(ctx context.Context, org_
 	projection := bson.M{"users.$": 1}
 	org := mongo_entity.Organization{}
 	// Search for the role in the "organizations" collection
-	err = r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection)).Decode(&org)
+	err = r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&org)
 	if err != nil {
 		return false, err
 	}
@@ -649,11 +744,14 @@ func (r repository) CheckPolicyAlreadyAssignToUserById(ctx context.Context, org_
 // Get org id by identifier.
 func (r repository) GetOrgIdByIdentifier(ctx context.Context, identifier string) (string, error) {
 
+	ctx, span := telemetry.Repository(ctx, "user", "GetOrgIdByIdentifier")
+	defer span.End()
+
 	// Define filter to find the org by its identifier
 	filter := bson.M{"identifier": identifier}
 	projection := bson.M{"resources": 0, "users": 0, "roles": 0, "groups": 0, "policies": 0}
 	// Find the user document in the "organizations" collection
-	result := r.mongoColl.FindOne(context.Background(), filter, options.FindOne().SetProjection(projection))
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
 	if err := result.Err(); err != nil {
 		return "", err
 	}
@@ -670,13 +768,55 @@ func (r repository) GetOrgIdByIdentifier(ctx context.Context, identifier string)
 	return org.ID.Hex(), nil
 }
 
+// Get users with their roles, groups and properties, and the role and group
+// identifiers of the organization.
+func (r repository) GetMembers(ctx context.Context, org_id string) (*mongo_entity.Organization, error) {
+
+	ctx, span := telemetry.Repository(ctx, "user", "GetMembers")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"_id": orgId}
+	projection := bson.M{
+		"users._id": 1, "users.identifier": 1, "users.username": 1, "users.user_properties": 1,
+		"users.roles": 1, "users.groups": 1, "users.role_grants": 1, "users.group_grants": 1,
+		"roles._id": 1, "roles.identifier": 1,
+		"groups._id": 1, "groups.identifier": 1,
+	}
+	result := r.mongoColl.FindOne(ctx, filter, options.FindOne().SetProjection(projection))
+	if err := result.Err(); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, &util.NotFoundError{Path: "Organization"}
+		}
+		return nil, err
+	}
+
+	var org mongo_entity.Organization
+	if err := result.Decode(&org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
 func (r repository) resolveAssignedRoles(ctx context.Context, orgId primitive.ObjectID, roleIDs []primitive.ObjectID) ([]mongo_entity.AssignedRole, error) {
 
+	ctx, span := telemetry.Repository(ctx, "user", "resolveAssignedRoles")
+	defer span.End()
+
+	// Roles of ancestor organizations are inherited.
+	lineage, err := orgtree.Lineage(ctx, r.mongoColl, bson.M{"_id": orgId})
+	if err != nil {
+		return nil, err
+	}
 	pipeline := mongo.Pipeline{
-		bson.D{{Key: "$match", Value: bson.M{"_id": orgId}}},
+		bson.D{{Key: "$match", Value: bson.M{"_id": bson.M{"$in": lineage}}}},
 		bson.D{{Key: "$unwind", Value: "$roles"}},
 		bson.D{{Key: "$match", Value: bson.M{"roles._id": bson.M{"$in": roleIDs}}}},
-		bson.D{{Key: "$group", Value: bson.M{"_id": "$_id", "roles": bson.M{"$push": "$roles"}}}},
+		bson.D{{Key: "$group", Value: bson.M{"_id": nil, "roles": bson.M{"$push": "$roles"}}}},
 	}
 
 	cursor, err := r.mongoColl.Aggregate(ctx, pipeline)
@@ -701,6 +841,9 @@ func (r repository) resolveAssignedRoles(ctx context.Context, orgId primitive.Ob
 
 func (r repository) resolveAssignedGroups(ctx context.Context, orgId primitive.ObjectID, groupIDs []primitive.ObjectID) ([]mongo_entity.AssignedGroup, error) {
 
+	ctx, span := telemetry.Repository(ctx, "user", "resolveAssignedGroups")
+	defer span.End()
+
 	pipeline := mongo.Pipeline{
 		bson.D{{Key: "$match", Value: bson.M{"_id": orgId}}},
 		bson.D{{Key: "$unwind", Value: "$groups"}},
@@ -730,6 +873,9 @@ func (r repository) resolveAssignedGroups(ctx context.Context, orgId primitive.O
 
 func (r repository) resolveAssignedPolicies(ctx context.Context, orgId primitive.ObjectID, policyIDs []primitive.ObjectID) ([]mongo_entity.AssignedPolicy, error) {
 
+	ctx, span := telemetry.Repository(ctx, "user", "resolveAssignedPolicies")
+	defer span.End()
+
 	pipeline := mongo.Pipeline{
 		bson.D{{Key: "$match", Value: bson.M{"_id": orgId}}},
 		bson.D{{Key: "$unwind", Value: "$policies"}},
@@ -756,3 +902,17 @@ func (r repository) resolveAssignedPolicies(ctx context.Context, orgId primitive
 
 	return results[0].Policies, nil
 }
+
+// GetInheritedRoles returns the roles of the ancestors of the organization,
+// nearest first.
+func (r repository) GetInheritedRoles(ctx context.Context, org_id string) ([]mongo_entity.Role, error) {
+
+	ctx, span := telemetry.Repository(ctx, "user", "GetInheritedRoles")
+	defer span.End()
+
+	lineage, err := orgtree.LineageOf(ctx, r.mongoColl, org_id)
+	if err != nil {
+		return nil, err
+	}
+	return orgtree.Roles(ctx, r.mongoColl, lineage[1:])
+}
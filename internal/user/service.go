@@ -2,9 +2,16 @@ package user
 
 import (
 	"context"
+	"time"
 
+	"github.com/shashimalcse/cronuseo/internal/assignment"
+	"github.com/shashimalcse/cronuseo/internal/group"
+	"github.com/shashimalcse/cronuseo/internal/limits"
 	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
 	"github.com/shashimalcse/cronuseo/internal/role"
+	"github.com/shashimalcse/cronuseo/internal/sod"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/userschema"
 	"github.com/shashimalcse/cronuseo/internal/util"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
@@ -18,6 +25,7 @@ type Service interface {
 	Query(ctx context.Context, org_id string, filter Filter) ([]User, error)
 	Create(ctx context.Context, org_id string, input CreateUserRequest) (UserResponse, error)
 	Sync(ctx context.Context, org_id string, input SyncUserRequest) (SyncUserResponse, error)
+	SyncBatch(ctx context.Context, org_id string, input SyncBatchRequest) (SyncBatchResponse, error)
 	Update(ctx context.Context, org_id string, id string, input UpdateUserRequest) (UserResponse, error)
 	Patch(ctx context.Context, org_id string, id string, input PatchUserRequest) (UserResponse, error)
 	Delete(ctx context.Context, org_id string, id string) error
@@ -35,6 +43,9 @@ type UserResponse struct {
 	Roles          []mongo_entity.AssignedRole   `json:"roles,omitempty" bson:"roles"`
 	Groups         []mongo_entity.AssignedGroup  `json:"groups,omitempty" bson:"groups"`
 	Policies       []mongo_entity.AssignedPolicy `json:"policies,omitempty" bson:"policies"`
+
+	RoleGrants  []mongo_entity.Grant `json:"role_grants,omitempty" bson:"role_grants"`
+	GroupGrants []mongo_entity.Grant `json:"group_grants,omitempty" bson:"group_grants"`
 }
 
 type SyncUserResponse struct {
@@ -63,6 +74,9 @@ type SyncUserRequest struct {
 	UserProperties map[string]interface{} `json:"user_properties" bson:"user_properties"`
 	Roles          []string               `json:"roles,omitempty" bson:"roles"`
 	Groups         []string               `json:"groups,omitempty" bson:"groups"`
+
+	// Mode is the sync mode of the roles and groups, merge by default.
+	Mode string `json:"mode,omitempty" bson:"-"`
 }
 
 func (m CreateUserRequest) Validate() error {
@@ -78,10 +92,14 @@ func (m SyncUserRequest) Validate() error {
 	return validation.ValidateStruct(&m,
 		validation.Field(&m.Username, validation.Required),
 		validation.Field(&m.Identifier, validation.Required),
+		validation.Field(&m.Roles, validation.Each(validation.Required)),
+		validation.Field(&m.Groups, validation.Each(validation.Required)),
+		validation.Field(&m.Mode, validation.In(SyncModeMerge, SyncModeReplace)),
 	)
 }
 
 type UpdateUserRequest struct {
+	Username       *string                `json:"username,omitempty" bson:"username"`
 	UserProperties map[string]interface{} `json:"user_properties" bson:"user_properties"`
 }
 
@@ -93,9 +111,16 @@ type PatchUserRequest struct {
 	RemovedGroups   []primitive.ObjectID   `json:"removed_groups,omitempty" bson:"removed_groups"`
 	AddedPolicies   []primitive.ObjectID   `json:"added_policies,omitempty" bson:"added_policies"`
 	RemovedPolicies []primitive.ObjectID   `json:"removed_policies,omitempty" bson:"removed_policies"`
+
+	// ValidFrom and ValidUntil make the added roles and groups time-bound.
+	// Adding an assigned role or group again replaces its window, and adding
+	// it without one makes it permanent.
+	ValidFrom  *time.Time `json:"valid_from,omitempty" bson:"valid_from"`
+	ValidUntil *time.Time `json:"valid_until,omitempty" bson:"valid_until"`
 }
 
 type UpdateUser struct {
+	Username       *string                `json:"username,omitempty" bson:"username"`
 	UserProperties map[string]interface{} `json:"user_properties" bson:"user_properties"`
 }
 
@@ -107,6 +132,12 @@ type PatchUser struct {
 	RemovedGroups   []primitive.ObjectID   `json:"removed_groups,omitempty" bson:"removed_groups"`
 	AddedPolicies   []primitive.ObjectID   `json:"added_policies,omitempty" bson:"added_policies"`
 	RemovedPolicies []primitive.ObjectID   `json:"removed_policies,omitempty" bson:"removed_policies"`
+
+	// RoleGrants and GroupGrants are the requested roles and groups, assigned
+	// or not, whose grants are set to the window.
+	Window      assignment.Window    `json:"-" bson:"-"`
+	RoleGrants  []primitive.ObjectID `json:"-" bson:"-"`
+	GroupGrants []primitive.ObjectID `json:"-" bson:"-"`
 }
 
 func (m UpdateUserRequest) Validate() error {
@@ -117,16 +148,26 @@ type service struct {
 	repo        Repository
 	logger      *zap.Logger
 	roleService role.Service
+	quotas      limits.Quotas
+	duties      sod.Enforcer
+	properties  userschema.Validator
+
+	groupService group.Service
 }
 
-func NewService(repo Repository, logger *zap.Logger, roleService role.Service) Service {
+func NewService(repo Repository, logger *zap.Logger, roleService role.Service, groupService group.Service, quotas limits.Quotas,
+	duties sod.Enforcer, properties userschema.Validator) Service {
 
-	return service{repo: repo, logger: logger, roleService: roleService}
+	return service{repo: repo, logger: logger, roleService: roleService, groupService: groupService, quotas: quotas, duties: duties,
+		properties: properties}
 }
 
 // Get user by id.
 func (s service) Get(ctx context.Context, org_id string, id string) (UserResponse, error) {
 
+	ctx, span := telemetry.Start(ctx, "user.service.Get")
+	defer span.End()
+
 	user, err := s.repo.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Error("Error while getting the user.",
@@ -140,6 +181,9 @@ func (s service) Get(ctx context.Context, org_id string, id string) (UserRespons
 // Get user id by identifier.
 func (s service) GetIdByIdentifier(ctx context.Context, org_id string, identifier string) (string, error) {
 
+	ctx, span := telemetry.Start(ctx, "user.service.GetIdByIdentifier")
+	defer span.End()
+
 	userId, err := s.repo.GetIdByIdentifier(ctx, org_id, identifier)
 	if err != nil {
 		s.logger.Error("Error while getting the user id.",
@@ -153,19 +197,36 @@ func (s service) GetIdByIdentifier(ctx context.Context, org_id string, identifie
 // Create new user.
 func (s service) Create(ctx context.Context, org_id string, req CreateUserRequest) (UserResponse, error) {
 
+	ctx, span := telemetry.Start(ctx, "user.service.Create")
+	defer span.End()
+
 	// Validate user request.
 	if err := req.Validate(); err != nil {
 		s.logger.Error("Error while validating user create request.")
-		return UserResponse{}, &util.InvalidInputError{Path: "Invalid input for user."}
+		return UserResponse{}, util.NewValidationError("Invalid input for user.", err)
 	}
 
 	// Check user already exists.
-	exists, _ := s.repo.CheckUserExistsByIdentifier(ctx, org_id, req.Username)
+	exists, _ := s.repo.CheckUserExistsByIdentifier(ctx, org_id, req.Identifier)
 	if exists {
 		s.logger.Debug("User already exists.")
-		return UserResponse{}, &util.AlreadyExistsError{Path: "User : " + req.Username}
+		return UserResponse{}, &util.AlreadyExistsError{Path: "User : " + req.Identifier}
 
 	}
+	// Users and service accounts are both check subjects.
+	exists, _ = s.repo.CheckServiceAccountExistsByIdentifier(ctx, org_id, req.Identifier)
+	if exists {
+		s.logger.Debug("Service account with the identifier already exists.")
+		return UserResponse{}, &util.AlreadyExistsError{Path: "Service account : " + req.Identifier}
+	}
+	if err := s.quotas.CheckQuota(ctx, org_id, limits.QuotaUsers); err != nil {
+		return UserResponse{}, err
+	}
+
+	properties, err := s.properties.ValidateProperties(ctx, org_id, req.UserProperties)
+	if err != nil {
+		return UserResponse{}, err
+	}
 
 	// Generate user id.
 	userId := primitive.NewObjectID()
@@ -173,21 +234,21 @@ func (s service) Create(ctx context.Context, org_id string, req CreateUserReques
 	for _, roleId := range req.Roles {
 		exists, _ := s.repo.CheckRoleExistById(ctx, org_id, roleId.Hex())
 		if !exists {
-			return UserResponse{}, &util.InvalidInputError{Path: "Invalid role id " + roleId.String()}
+			return UserResponse{}, &util.InvalidInputError{Message: "Invalid role id " + roleId.String()}
 		}
 	}
 
 	for _, groupId := range req.Groups {
 		exists, _ := s.repo.CheckGroupExistById(ctx, org_id, groupId.Hex())
 		if !exists {
-			return UserResponse{}, &util.InvalidInputError{Path: "Invalid group id " + groupId.String()}
+			return UserResponse{}, &util.InvalidInputError{Message: "Invalid group id " + groupId.String()}
 		}
 	}
 
 	for _, policyId := range req.Policies {
 		exists, _ := s.repo.CheckPolicyExistById(ctx, org_id, policyId.Hex())
 		if !exists {
-			return UserResponse{}, &util.InvalidInputError{Path: "Invalid policy id " + policyId.String()}
+			return UserResponse{}, &util.InvalidInputError{Message: "Invalid policy id " + policyId.String()}
 		}
 	}
 
@@ -212,11 +273,18 @@ func (s service) Create(ctx context.Context, org_id string, req CreateUserReques
 		policies = req.Policies
 	}
 
-	err := s.repo.Create(ctx, org_id, mongo_entity.User{
+	if err := s.duties.Enforce(ctx, org_id, sod.Change{
+		AddedUserRoles:  sod.Links(userId, roles),
+		AddedUserGroups: sod.Links(userId, groups),
+	}); err != nil {
+		return UserResponse{}, err
+	}
+
+	err = s.repo.Create(ctx, org_id, mongo_entity.User{
 		ID:             userId,
 		Username:       req.Username,
 		Identifier:     req.Identifier,
-		UserProperties: req.UserProperties,
+		UserProperties: properties,
 		Roles:          roles,
 		Groups:         groups,
 		Policies:       policies,
@@ -230,13 +298,17 @@ func (s service) Create(ctx context.Context, org_id string, req CreateUserReques
 	return s.Get(ctx, org_id, userId.Hex())
 }
 
-// Sync user.
+// Sync a user from an identity provider. Roles and groups are referenced by
+// identifier and created when missing.
 func (s service) Sync(ctx context.Context, org_identifier string, req SyncUserRequest) (SyncUserResponse, error) {
 
+	ctx, span := telemetry.Start(ctx, "user.service.Sync")
+	defer span.End()
+
 	// Validate user request.
 	if err := req.Validate(); err != nil {
-		s.logger.Error("Error while validating user create request.")
-		return SyncUserResponse{}, &util.InvalidInputError{Path: "Invalid input for user."}
+		s.logger.Error("Error while validating user sync request.")
+		return SyncUserResponse{}, util.NewValidationError("Invalid input for user.", err)
 	}
 
 	org_id, err := s.repo.GetOrgIdByIdentifier(ctx, org_identifier)
@@ -244,103 +316,104 @@ func (s service) Sync(ctx context.Context, org_identifier string, req SyncUserRe
 		s.logger.Error("Error while syncing user. Invalid org identifier", zap.String("organization_identifier", org_identifier))
 		return SyncUserResponse{}, err
 	}
+	syncer, err := s.newSyncer(ctx, org_id)
+	if err != nil {
+		return SyncUserResponse{}, err
+	}
+	id, _, err := syncer.sync(ctx, req)
+	if err != nil {
+		return SyncUserResponse{}, err
+	}
+	user, err := s.Get(ctx, org_id, id.Hex())
+	if err != nil {
+		return SyncUserResponse{}, err
+	}
+	return SyncUserResponse{
+		ID:             user.ID,
+		Username:       user.Username,
+		Identifier:     user.Identifier,
+		OrganizationId: org_id,
+		UserProperties: user.UserProperties,
+		Roles:          user.Roles,
+		Groups:         user.Groups,
+		Policies:       user.Policies,
+	}, nil
+}
 
-	// Check user already exists.
-	exists, _ := s.repo.CheckUserExistsByIdentifier(ctx, org_id, req.Identifier)
-	roleIds := []primitive.ObjectID{}
-	if req.Roles != nil && len(req.Roles) > 0 {
-		for _, roleIdentifier := range req.Roles {
-			exists, _ := s.roleService.CheckRoleExistsByIdentifier(ctx, org_id, roleIdentifier)
-			if !exists {
-				roleCreateRequest := role.CreateRoleRequest{
-					Identifier:  roleIdentifier,
-					DisplayName: roleIdentifier,
-				}
-				role, _ := s.roleService.Create(ctx, org_id, roleCreateRequest)
-				roleIds = append(roleIds, role.ID)
-			} else {
-				role, _ := s.roleService.GetRoleByIdentifier(ctx, org_id, roleIdentifier)
-				roleIds = append(roleIds, role.ID)
-			}
-		}
+// SyncBatch syncs users from an identity provider, returning the result of
+// each user. Failed users do not stop the batch.
+func (s service) SyncBatch(ctx context.Context, org_identifier string, req SyncBatchRequest) (SyncBatchResponse, error) {
+
+	ctx, span := telemetry.Start(ctx, "user.service.SyncBatch")
+	defer span.End()
+
+	if err := req.Validate(); err != nil {
+		s.logger.Error("Error while validating user sync batch request.")
+		return SyncBatchResponse{}, util.NewValidationError("Invalid input for user sync batch.", err)
 	}
-	if exists {
-		s.logger.Debug("User already exists.")
-		id, _ := s.GetIdByIdentifier(ctx, org_id, req.Identifier)
-		addedRoles := []primitive.ObjectID{}
-		for _, roleId := range roleIds {
-			already_added, _ := s.repo.CheckRoleAlreadyAssignToUserById(ctx, org_id, id, roleId.Hex())
-			if !already_added {
-				addedRoles = append(addedRoles, roleId)
-			}
-		}
-		if len(addedRoles) > 0 {
-			patchUserRequest := PatchUserRequest{
-				AddedRoles: addedRoles,
-			}
-			s.Patch(ctx, org_id, id, patchUserRequest)
-		}
-		user, err := s.Get(ctx, org_id, id)
-		if err != nil {
-			return SyncUserResponse{}, err
-		}
-		return SyncUserResponse{
-			ID:             user.ID,
-			Username:       user.Username,
-			Identifier:     user.Identifier,
-			OrganizationId: org_id,
-			UserProperties: user.UserProperties,
-			Roles:          user.Roles,
-			Groups:         user.Groups,
-			Policies:       user.Policies,
-		}, nil
 
-	} else {
-		// Generate user id.
-		userId := primitive.NewObjectID()
-
-		err = s.repo.Create(ctx, org_id, mongo_entity.User{
-			ID:         userId,
-			Username:   req.Username,
-			Identifier: req.Identifier,
-			Roles:      roleIds,
-			Groups:     []primitive.ObjectID{},
-			Policies:   []primitive.ObjectID{},
-		})
+	org_id, err := s.repo.GetOrgIdByIdentifier(ctx, org_identifier)
+	if err != nil {
+		s.logger.Error("Error while syncing users. Invalid org identifier", zap.String("organization_identifier", org_identifier))
+		return SyncBatchResponse{}, err
+	}
+	syncer, err := s.newSyncer(ctx, org_id)
+	if err != nil {
+		return SyncBatchResponse{}, err
+	}
 
-		if err != nil {
-			s.logger.Error("Error while syncing user.",
-				zap.String("organization_id", org_id))
-			return SyncUserResponse{}, err
+	response := SyncBatchResponse{Results: make([]SyncResult, 0, len(req.Users))}
+	seen := map[string]bool{}
+	for _, user := range req.Users {
+		if user.Mode == "" {
+			user.Mode = req.Mode
+		}
+		result := SyncResult{Identifier: user.Identifier}
+		var err error
+		if seen[user.Identifier] {
+			err = &util.InvalidInputError{Message: "User " + user.Identifier + " is synced more than once in the batch."}
+		} else if err = user.Validate(); err != nil {
+			err = util.NewValidationError("Invalid input for user.", err)
+		} else {
+			var id primitive.ObjectID
+			if id, result.Status, err = syncer.sync(ctx, user); !id.IsZero() {
+				result.ID = id.Hex()
+			}
 		}
-		user, err := s.Get(ctx, org_id, userId.Hex())
+		seen[user.Identifier] = true
 		if err != nil {
-			return SyncUserResponse{}, err
+			s.logger.Debug("Error while syncing user.", zap.String("organization_id", org_id), zap.String("identifier", user.Identifier), zap.Error(err))
+			_, body := util.NewErrorResponse(err)
+			result.Status, result.Error = SyncFailed, &body
 		}
-		return SyncUserResponse{
-			ID:             user.ID,
-			Username:       user.Username,
-			Identifier:     user.Identifier,
-			OrganizationId: org_id,
-			UserProperties: user.UserProperties,
-			Roles:          user.Roles,
-			Groups:         user.Groups,
-			Policies:       user.Policies,
-		}, nil
+		response.count(result.Status)
+		response.Results = append(response.Results, result)
 	}
+	return response, nil
 }
 
 // // Update user.
 func (s service) Update(ctx context.Context, org_id string, id string, req UpdateUserRequest) (UserResponse, error) {
 
+	ctx, span := telemetry.Start(ctx, "user.service.Update")
+	defer span.End()
+
 	_, err := s.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Debug("User not exists.", zap.String("user_id", id))
 		return UserResponse{}, &util.NotFoundError{Path: "User " + id + " not exists."}
 	}
 
+	properties := req.UserProperties
+	if properties != nil {
+		if properties, err = s.properties.ValidateProperties(ctx, org_id, properties); err != nil {
+			return UserResponse{}, err
+		}
+	}
+
 	if err := s.repo.Update(ctx, org_id, id, UpdateUser{
-		UserProperties: req.UserProperties,
+		Username:       req.Username,
+		UserProperties: properties,
 	}); err != nil {
 		s.logger.Error("Error while updating user.",
 			zap.String("organization_id", org_id),
@@ -352,23 +425,39 @@ func (s service) Update(ctx context.Context, org_id string, id string, req Updat
 
 func (s service) Patch(ctx context.Context, org_id string, id string, req PatchUserRequest) (UserResponse, error) {
 
-	_, err := s.Get(ctx, org_id, id)
+	ctx, span := telemetry.Start(ctx, "user.service.Patch")
+	defer span.End()
+
+	user, err := s.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Debug("User not exists.", zap.String("user_id", id))
 		return UserResponse{}, &util.NotFoundError{Path: "User " + id + " not exists."}
 	}
 
+	properties, err := s.patchProperties(ctx, org_id, user.UserProperties, req.UserProperties)
+	if err != nil {
+		return UserResponse{}, err
+	}
+
+	window := assignment.Window{ValidFrom: req.ValidFrom, ValidUntil: req.ValidUntil}
+	if window.Bounded() && len(req.AddedRoles) == 0 && len(req.AddedGroups) == 0 {
+		return UserResponse{}, &util.InvalidInputError{Message: "Assignment window requires added roles or groups."}
+	}
+	if err := window.Validate(time.Now()); err != nil {
+		return UserResponse{}, err
+	}
+
 	// roles
 	for _, roleId := range req.AddedRoles {
 		exists, _ := s.repo.CheckRoleExistById(ctx, org_id, roleId.Hex())
 		if !exists {
-			return UserResponse{}, &util.InvalidInputError{Path: "Invalid role id " + roleId.String()}
+			return UserResponse{}, &util.InvalidInputError{Message: "Invalid role id " + roleId.String()}
 		}
 	}
 	for _, roleId := range req.RemovedRoles {
 		exists, _ := s.repo.CheckRoleExistById(ctx, org_id, roleId.Hex())
 		if !exists {
-			return UserResponse{}, &util.InvalidInputError{Path: "Invalid role id " + roleId.String()}
+			return UserResponse{}, &util.InvalidInputError{Message: "Invalid role id " + roleId.String()}
 		}
 	}
 	added_roles := []primitive.ObjectID{}
@@ -391,13 +480,13 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchU
 	for _, groupId := range req.AddedGroups {
 		exists, _ := s.repo.CheckGroupExistById(ctx, org_id, groupId.Hex())
 		if !exists {
-			return UserResponse{}, &util.InvalidInputError{Path: "Invalid group id " + groupId.String()}
+			return UserResponse{}, &util.InvalidInputError{Message: "Invalid group id " + groupId.String()}
 		}
 	}
 	for _, groupId := range req.RemovedGroups {
 		exists, _ := s.repo.CheckGroupExistById(ctx, org_id, groupId.Hex())
 		if !exists {
-			return UserResponse{}, &util.InvalidInputError{Path: "Invalid group id " + groupId.String()}
+			return UserResponse{}, &util.InvalidInputError{Message: "Invalid group id " + groupId.String()}
 		}
 	}
 	added_groups := []primitive.ObjectID{}
@@ -420,13 +509,13 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchU
 	for _, policyId := range req.AddedPolicies {
 		exists, _ := s.repo.CheckPolicyExistById(ctx, org_id, policyId.Hex())
 		if !exists {
-			return UserResponse{}, &util.InvalidInputError{Path: "Invalid policy id " + policyId.String()}
+			return UserResponse{}, &util.InvalidInputError{Message: "Invalid policy id " + policyId.String()}
 		}
 	}
 	for _, policyId := range req.RemovedPolicies {
 		exists, _ := s.repo.CheckPolicyExistById(ctx, org_id, policyId.Hex())
 		if !exists {
-			return UserResponse{}, &util.InvalidInputError{Path: "Invalid policy id " + policyId.String()}
+			return UserResponse{}, &util.InvalidInputError{Message: "Invalid policy id " + policyId.String()}
 		}
 	}
 	added_policies := []primitive.ObjectID{}
@@ -445,14 +534,27 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchU
 		}
 	}
 
+	userId, _ := primitive.ObjectIDFromHex(id)
+	if err := s.duties.Enforce(ctx, org_id, sod.Change{
+		AddedUserRoles:    sod.Links(userId, added_roles),
+		RemovedUserRoles:  sod.Links(userId, removed_roles),
+		AddedUserGroups:   sod.Links(userId, added_groups),
+		RemovedUserGroups: sod.Links(userId, removed_groups),
+	}); err != nil {
+		return UserResponse{}, err
+	}
+
 	if err := s.repo.Patch(ctx, org_id, id, PatchUser{
-		UserProperties:  req.UserProperties,
+		UserProperties:  properties,
 		AddedRoles:      added_roles,
 		RemovedRoles:    removed_roles,
 		AddedGroups:     added_groups,
 		RemovedGroups:   removed_groups,
 		AddedPolicies:   added_policies,
 		RemovedPolicies: removed_policies,
+		Window:          window,
+		RoleGrants:      req.AddedRoles,
+		GroupGrants:     req.AddedGroups,
 	}); err != nil {
 		s.logger.Error("Error while updating user.",
 			zap.String("organization_id", org_id),
@@ -462,9 +564,42 @@ func (s service) Patch(ctx context.Context, org_id string, id string, req PatchU
 	return s.Get(ctx, org_id, id)
 }
 
+// patchProperties validates the user properties after the patch and returns
+// the properties to set, the patched ones and the defaults of missing ones.
+func (s service) patchProperties(ctx context.Context, org_id string, existing map[string]interface{}, patch map[string]interface{}) (map[string]interface{}, error) {
+
+	if patch == nil {
+		return nil, nil
+	}
+	merged := map[string]interface{}{}
+	for key, value := range existing {
+		merged[key] = value
+	}
+	for key, value := range patch {
+		merged[key] = value
+	}
+	validated, err := s.properties.ValidateProperties(ctx, org_id, merged)
+	if err != nil {
+		return nil, err
+	}
+	properties := map[string]interface{}{}
+	for key := range patch {
+		properties[key] = validated[key]
+	}
+	for key, value := range validated {
+		if _, ok := existing[key]; !ok {
+			properties[key] = value
+		}
+	}
+	return properties, nil
+}
+
 // Delete user.
 func (s service) Delete(ctx context.Context, org_id string, id string) error {
 
+	ctx, span := telemetry.Start(ctx, "user.service.Delete")
+	defer span.End()
+
 	_, err := s.Get(ctx, org_id, id)
 	if err != nil {
 		s.logger.Error("User not exists.", zap.String("user_id", id))
@@ -490,6 +625,9 @@ type Filter struct {
 // // Get all user.
 func (s service) Query(ctx context.Context, org_id string, filter Filter) ([]User, error) {
 
+	ctx, span := telemetry.Start(ctx, "user.service.Query")
+	defer span.End()
+
 	result := []User{}
 	items, err := s.repo.Query(ctx, org_id)
 	if err != nil {
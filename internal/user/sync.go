@@ -0,0 +1,292 @@
+package user
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/shashimalcse/cronuseo/internal/group"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/role"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/userschema"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+)
+
+// Sync modes. Merge adds the synced roles and groups to the user, and replace
+// also removes the roles and groups not synced. Time-bound assignments are
+// never removed by a sync.
+const (
+	SyncModeMerge   = "merge"
+	SyncModeReplace = "replace"
+)
+
+// Sync statuses of a user.
+const (
+	SyncCreated   = "created"
+	SyncUpdated   = "updated"
+	SyncUnchanged = "unchanged"
+	SyncFailed    = "failed"
+)
+
+// MaxSyncBatchSize is the maximum number of users of a sync batch.
+const MaxSyncBatchSize = 5000
+
+type SyncBatchRequest struct {
+	Mode  string            `json:"mode,omitempty"`
+	Users []SyncUserRequest `json:"users"`
+}
+
+// Validate the batch. The users are validated by the sync of each user.
+func (m SyncBatchRequest) Validate() error {
+
+	return validation.ValidateStruct(&m,
+		validation.Field(&m.Mode, validation.In(SyncModeMerge, SyncModeReplace)),
+		validation.Field(&m.Users, validation.Required, validation.Length(1, MaxSyncBatchSize), validation.Skip),
+	)
+}
+
+type SyncBatchResponse struct {
+	Created   int          `json:"created"`
+	Updated   int          `json:"updated"`
+	Unchanged int          `json:"unchanged"`
+	Failed    int          `json:"failed"`
+	Results   []SyncResult `json:"results"`
+}
+
+// SyncResult is the result of a user of a sync batch. Error is set for failed
+// users.
+type SyncResult struct {
+	Identifier string              `json:"identifier"`
+	ID         string              `json:"id,omitempty"`
+	Status     string              `json:"status"`
+	Error      *util.ErrorResponse `json:"error,omitempty"`
+}
+
+func (r *SyncBatchResponse) count(status string) {
+
+	switch status {
+	case SyncCreated:
+		r.Created++
+	case SyncUpdated:
+		r.Updated++
+	case SyncUnchanged:
+		r.Unchanged++
+	default:
+		r.Failed++
+	}
+}
+
+// syncer syncs users of an organization. The users, roles and groups are
+// loaded once, so a batch only writes the users that changed. Roles include
+// the roles inherited from ancestors, local roles shadow inherited ones.
+type syncer struct {
+	service
+	org_id string
+	roles  map[string]primitive.ObjectID
+	groups map[string]primitive.ObjectID
+	users  map[string]*mongo_entity.User
+}
+
+func (s service) newSyncer(ctx context.Context, org_id string) (*syncer, error) {
+
+	org, err := s.repo.GetMembers(ctx, org_id)
+	if err != nil {
+		s.logger.Error("Error while loading organization members.")
+		return nil, err
+	}
+	syncer := &syncer{
+		service: s,
+		org_id:  org_id,
+		roles:   map[string]primitive.ObjectID{},
+		groups:  map[string]primitive.ObjectID{},
+		users:   map[string]*mongo_entity.User{},
+	}
+	inherited, err := s.repo.GetInheritedRoles(ctx, org_id)
+	if err != nil {
+		s.logger.Error("Error while loading inherited roles.")
+		return nil, err
+	}
+	for _, role := range append(org.Roles, inherited...) {
+		if _, ok := syncer.roles[role.Identifier]; !ok {
+			syncer.roles[role.Identifier] = role.ID
+		}
+	}
+	for _, group := range org.Groups {
+		syncer.groups[group.Identifier] = group.ID
+	}
+	for i := range org.Users {
+		syncer.users[org.Users[i].Identifier] = &org.Users[i]
+	}
+	return syncer, nil
+}
+
+// sync creates or updates the user and returns its id and sync status.
+func (s *syncer) sync(ctx context.Context, req SyncUserRequest) (primitive.ObjectID, string, error) {
+
+	ctx, span := telemetry.Start(ctx, "user.service.sync")
+	defer span.End()
+
+	roles, err := s.resolve(ctx, req.Roles, s.roles, s.createRole)
+	if err != nil {
+		return primitive.NilObjectID, "", err
+	}
+	groups, err := s.resolve(ctx, req.Groups, s.groups, s.createGroup)
+	if err != nil {
+		return primitive.NilObjectID, "", err
+	}
+
+	existing := s.users[req.Identifier]
+	if existing == nil {
+		user, err := s.Create(ctx, s.org_id, CreateUserRequest{
+			Username:       req.Username,
+			Identifier:     req.Identifier,
+			UserProperties: req.UserProperties,
+			Roles:          roles,
+			Groups:         groups,
+		})
+		if err != nil {
+			return primitive.NilObjectID, "", err
+		}
+		s.users[req.Identifier] = member(user)
+		return user.ID, SyncCreated, nil
+	}
+
+	id := existing.ID.Hex()
+	status := SyncUnchanged
+	if req.Username != existing.Username {
+		user, err := s.Update(ctx, s.org_id, id, UpdateUserRequest{Username: &req.Username})
+		if err != nil {
+			return existing.ID, "", err
+		}
+		existing = member(user)
+		s.users[req.Identifier] = existing
+		status = SyncUpdated
+	}
+
+	patch := PatchUserRequest{
+		UserProperties: changedProperties(existing.UserProperties, req.UserProperties),
+		AddedRoles:     difference(roles, existing.Roles),
+		AddedGroups:    difference(groups, existing.Groups),
+	}
+	if req.Mode == SyncModeReplace {
+		patch.RemovedRoles = difference(permanent(existing.Roles, existing.RoleGrants), roles)
+		patch.RemovedGroups = difference(permanent(existing.Groups, existing.GroupGrants), groups)
+	}
+	if len(patch.UserProperties) > 0 || len(patch.AddedRoles) > 0 || len(patch.RemovedRoles) > 0 ||
+		len(patch.AddedGroups) > 0 || len(patch.RemovedGroups) > 0 {
+		user, err := s.Patch(ctx, s.org_id, id, patch)
+		if err != nil {
+			return existing.ID, "", err
+		}
+		s.users[req.Identifier] = member(user)
+		status = SyncUpdated
+	}
+	return existing.ID, status, nil
+}
+
+// resolve returns the ids of the identifiers, creating the missing ones.
+func (s *syncer) resolve(ctx context.Context, identifiers []string, ids map[string]primitive.ObjectID,
+	create func(ctx context.Context, identifier string) (primitive.ObjectID, error)) ([]primitive.ObjectID, error) {
+
+	result := []primitive.ObjectID{}
+	seen := map[string]bool{}
+	for _, identifier := range identifiers {
+		if seen[identifier] {
+			continue
+		}
+		seen[identifier] = true
+		id, ok := ids[identifier]
+		if !ok {
+			var err error
+			if id, err = create(ctx, identifier); err != nil {
+				return nil, err
+			}
+			ids[identifier] = id
+		}
+		result = append(result, id)
+	}
+	return result, nil
+}
+
+func (s *syncer) createRole(ctx context.Context, identifier string) (primitive.ObjectID, error) {
+
+	created, err := s.roleService.Create(ctx, s.org_id, role.CreateRoleRequest{Identifier: identifier, DisplayName: identifier})
+	return created.ID, err
+}
+
+func (s *syncer) createGroup(ctx context.Context, identifier string) (primitive.ObjectID, error) {
+
+	created, err := s.groupService.Create(ctx, s.org_id, group.CreateGroupRequest{Identifier: identifier, DisplayName: identifier})
+	return created.ID, err
+}
+
+// member returns the synced state of the user.
+func member(user UserResponse) *mongo_entity.User {
+
+	result := &mongo_entity.User{
+		ID:             user.ID,
+		Username:       user.Username,
+		Identifier:     user.Identifier,
+		UserProperties: user.UserProperties,
+		RoleGrants:     user.RoleGrants,
+		GroupGrants:    user.GroupGrants,
+	}
+	for _, role := range user.Roles {
+		result.Roles = append(result.Roles, role.ID)
+	}
+	for _, group := range user.Groups {
+		result.Groups = append(result.Groups, group.ID)
+	}
+	return result
+}
+
+// changedProperties returns the requested properties that differ from the
+// existing ones.
+func changedProperties(existing map[string]interface{}, requested map[string]interface{}) map[string]interface{} {
+
+	changed := map[string]interface{}{}
+	for key, value := range requested {
+		if current, ok := existing[key]; !ok || !reflect.DeepEqual(userschema.Normalize(current), userschema.Normalize(value)) {
+			changed[key] = value
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return changed
+}
+
+// permanent returns the ids without a grant.
+func permanent(ids []primitive.ObjectID, grants []mongo_entity.Grant) []primitive.ObjectID {
+
+	bounded := map[primitive.ObjectID]bool{}
+	for _, grant := range grants {
+		bounded[grant.ID] = true
+	}
+	result := []primitive.ObjectID{}
+	for _, id := range ids {
+		if !bounded[id] {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// difference returns the ids of a not in b.
+func difference(a []primitive.ObjectID, b []primitive.ObjectID) []primitive.ObjectID {
+
+	in := map[primitive.ObjectID]bool{}
+	for _, id := range b {
+		in[id] = true
+	}
+	result := []primitive.ObjectID{}
+	for _, id := range a {
+		if !in[id] {
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -0,0 +1,362 @@
+package user
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shashimalcse/cronuseo/internal/group"
+	"github.com/shashimalcse/cronuseo/internal/limits"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/role"
+	"github.com/shashimalcse/cronuseo/internal/sod"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const orgIdentifier = "acme"
+
+var orgId = primitive.NewObjectID().Hex()
+
+type mockRepository struct {
+	Repository
+	org       mongo_entity.Organization
+	inherited []mongo_entity.Role
+	patches   int
+}
+
+func (m *mockRepository) user(id string) *mongo_entity.User {
+
+	for i := range m.org.Users {
+		if m.org.Users[i].ID.Hex() == id {
+			return &m.org.Users[i]
+		}
+	}
+	return nil
+}
+
+func (m *mockRepository) GetOrgIdByIdentifier(ctx context.Context, identifier string) (string, error) {
+
+	if identifier != orgIdentifier {
+		return "", &util.NotFoundError{Path: "Org"}
+	}
+	return orgId, nil
+}
+
+func (m *mockRepository) GetMembers(ctx context.Context, org_id string) (*mongo_entity.Organization, error) {
+
+	org := m.org
+	org.Users = append([]mongo_entity.User{}, m.org.Users...)
+	return &org, nil
+}
+
+func (m *mockRepository) GetInheritedRoles(ctx context.Context, org_id string) ([]mongo_entity.Role, error) {
+
+	return m.inherited, nil
+}
+
+func (m *mockRepository) Get(ctx context.Context, org_id string, id string) (*UserResponse, error) {
+
+	user := m.user(id)
+	if user == nil {
+		return nil, &util.NotFoundError{Path: "User"}
+	}
+	response := &UserResponse{ID: user.ID, Username: user.Username, Identifier: user.Identifier, UserProperties: user.UserProperties,
+		RoleGrants: user.RoleGrants, GroupGrants: user.GroupGrants}
+	for _, id := range user.Roles {
+		response.Roles = append(response.Roles, mongo_entity.AssignedRole{ID: id})
+	}
+	for _, id := range user.Groups {
+		response.Groups = append(response.Groups, mongo_entity.AssignedGroup{ID: id})
+	}
+	return response, nil
+}
+
+func (m *mockRepository) Create(ctx context.Context, org_id string, user mongo_entity.User) error {
+
+	m.org.Users = append(m.org.Users, user)
+	return nil
+}
+
+func (m *mockRepository) Update(ctx context.Context, org_id string, id string, update UpdateUser) error {
+
+	m.user(id).Username = *update.Username
+	m.patches++
+	return nil
+}
+
+func (m *mockRepository) Patch(ctx context.Context, org_id string, id string, patch PatchUser) error {
+
+	user := m.user(id)
+	if user.UserProperties == nil {
+		user.UserProperties = map[string]interface{}{}
+	}
+	for key, value := range patch.UserProperties {
+		user.UserProperties[key] = value
+	}
+	user.Roles = append(difference(user.Roles, patch.RemovedRoles), patch.AddedRoles...)
+	user.Groups = append(difference(user.Groups, patch.RemovedGroups), patch.AddedGroups...)
+	m.patches++
+	return nil
+}
+
+func (m *mockRepository) CheckUserExistsByIdentifier(ctx context.Context, org_id string, identifier string) (bool, error) {
+
+	for _, user := range m.org.Users {
+		if user.Identifier == identifier {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockRepository) CheckServiceAccountExistsByIdentifier(ctx context.Context, org_id string, identifier string) (bool, error) {
+	return false, nil
+}
+
+func (m *mockRepository) CheckRoleExistById(ctx context.Context, org_id string, id string) (bool, error) {
+
+	for _, role := range append(m.org.Roles, m.inherited...) {
+		if role.ID.Hex() == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockRepository) CheckGroupExistById(ctx context.Context, org_id string, id string) (bool, error) {
+
+	for _, group := range m.org.Groups {
+		if group.ID.Hex() == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockRepository) CheckRoleAlreadyAssignToUserById(ctx context.Context, org_id string, user_id string, role_id string) (bool, error) {
+
+	for _, id := range m.user(user_id).Roles {
+		if id.Hex() == role_id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockRepository) CheckGroupAlreadyAssignToUserById(ctx context.Context, org_id string, user_id string, group_id string) (bool, error) {
+
+	for _, id := range m.user(user_id).Groups {
+		if id.Hex() == group_id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type mockRoleService struct {
+	role.Service
+	repo *mockRepository
+}
+
+func (m mockRoleService) Create(ctx context.Context, org_id string, req role.CreateRoleRequest) (role.RoleResponse, error) {
+
+	id := primitive.NewObjectID()
+	m.repo.org.Roles = append(m.repo.org.Roles, mongo_entity.Role{ID: id, Identifier: req.Identifier})
+	return role.RoleResponse{ID: id, Identifier: req.Identifier}, nil
+}
+
+type mockGroupService struct {
+	group.Service
+	repo *mockRepository
+}
+
+func (m mockGroupService) Create(ctx context.Context, org_id string, req group.CreateGroupRequest) (group.GroupResponse, error) {
+
+	id := primitive.NewObjectID()
+	m.repo.org.Groups = append(m.repo.org.Groups, mongo_entity.Group{ID: id, Identifier: req.Identifier})
+	return group.GroupResponse{ID: id, Identifier: req.Identifier}, nil
+}
+
+type mockQuotas struct{}
+
+func (mockQuotas) CheckQuota(ctx context.Context, org_id string, quota limits.Quota) error {
+	return nil
+}
+
+func (mockQuotas) RecordCheck(ctx context.Context, org_identifier string) error { return nil }
+
+type mockEnforcer struct{}
+
+func (mockEnforcer) Enforce(ctx context.Context, org_id string, change sod.Change) error { return nil }
+
+type mockValidator struct{}
+
+func (mockValidator) ValidateProperties(ctx context.Context, org_id string, properties map[string]interface{}) (map[string]interface{}, error) {
+
+	if _, ok := properties["invalid"]; ok {
+		return nil, &util.InvalidInputError{Message: "Invalid user properties."}
+	}
+	return properties, nil
+}
+
+func (mockValidator) ValidatePolicy(ctx context.Context, org_id string, policy string) error {
+	return nil
+}
+
+func newTestService(repo *mockRepository) Service {
+	return NewService(repo, zap.NewNop(), mockRoleService{repo: repo}, mockGroupService{repo: repo}, mockQuotas{}, mockEnforcer{}, mockValidator{})
+}
+
+func identifiers(repo *mockRepository, ids []primitive.ObjectID) []string {
+
+	names := map[primitive.ObjectID]string{}
+	for _, role := range append(repo.org.Roles, repo.inherited...) {
+		names[role.ID] = role.Identifier
+	}
+	for _, group := range repo.org.Groups {
+		names[group.ID] = group.Identifier
+	}
+	result := []string{}
+	for _, id := range ids {
+		result = append(result, names[id])
+	}
+	return result
+}
+
+func TestSync(t *testing.T) {
+
+	ctx := context.Background()
+	repo := &mockRepository{}
+	s := newTestService(repo)
+
+	user, err := s.Sync(ctx, orgIdentifier, SyncUserRequest{
+		Username:       "alice",
+		Identifier:     "alice@acme.com",
+		UserProperties: map[string]interface{}{"department": "sales"},
+		Roles:          []string{"viewer", "editor", "viewer"},
+		Groups:         []string{"sales"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, orgId, user.OrganizationId)
+	assert.Len(t, repo.org.Roles, 2)
+	assert.Len(t, repo.org.Groups, 1)
+	assert.Equal(t, []string{"viewer", "editor"}, identifiers(repo, repo.org.Users[0].Roles))
+	assert.Equal(t, []string{"sales"}, identifiers(repo, repo.org.Users[0].Groups))
+
+	// unchanged users are not written
+	_, err = s.Sync(ctx, orgIdentifier, SyncUserRequest{
+		Username:       "alice",
+		Identifier:     "alice@acme.com",
+		UserProperties: map[string]interface{}{"department": "sales"},
+		Roles:          []string{"viewer"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, repo.patches)
+
+	// merge adds roles, groups and properties
+	_, err = s.Sync(ctx, orgIdentifier, SyncUserRequest{
+		Username:       "Alice",
+		Identifier:     "alice@acme.com",
+		UserProperties: map[string]interface{}{"department": "marketing"},
+		Roles:          []string{"admin"},
+		Groups:         []string{"marketing"},
+	})
+	assert.Nil(t, err)
+	alice := repo.org.Users[0]
+	assert.Equal(t, "Alice", alice.Username)
+	assert.Equal(t, "marketing", alice.UserProperties["department"])
+	assert.Equal(t, []string{"viewer", "editor", "admin"}, identifiers(repo, alice.Roles))
+	assert.Equal(t, []string{"sales", "marketing"}, identifiers(repo, alice.Groups))
+
+	// replace removes the roles and groups not synced, except time-bound ones
+	repo.org.Users[0].RoleGrants = []mongo_entity.Grant{{ID: alice.Roles[1]}}
+	_, err = s.Sync(ctx, orgIdentifier, SyncUserRequest{
+		Username:   "Alice",
+		Identifier: "alice@acme.com",
+		Roles:      []string{"admin"},
+		Mode:       SyncModeReplace,
+	})
+	assert.Nil(t, err)
+	alice = repo.org.Users[0]
+	assert.Equal(t, []string{"editor", "admin"}, identifiers(repo, alice.Roles))
+	assert.Empty(t, alice.Groups)
+
+	_, err = s.Sync(ctx, orgIdentifier, SyncUserRequest{Username: "bob", Identifier: "bob@acme.com", Mode: "sync"})
+	assert.IsType(t, &util.InvalidInputError{}, err)
+	_, err = s.Sync(ctx, "globex", SyncUserRequest{Username: "bob", Identifier: "bob@acme.com"})
+	assert.IsType(t, &util.NotFoundError{}, err)
+}
+
+func TestSyncChildOrganization(t *testing.T) {
+
+	ctx := context.Background()
+	parentViewer := mongo_entity.Role{ID: primitive.NewObjectID(), Identifier: "viewer"}
+	parentEditor := mongo_entity.Role{ID: primitive.NewObjectID(), Identifier: "editor"}
+	rootEditor := mongo_entity.Role{ID: primitive.NewObjectID(), Identifier: "editor"}
+	repo := &mockRepository{inherited: []mongo_entity.Role{parentViewer, parentEditor, rootEditor}}
+	s := newTestService(repo)
+
+	// roles of ancestors are assigned instead of creating local roles, the
+	// nearest ancestor's role first
+	_, err := s.Sync(ctx, orgIdentifier, SyncUserRequest{Username: "alice", Identifier: "alice@acme.com", Roles: []string{"viewer", "editor"}})
+	assert.Nil(t, err)
+	assert.Empty(t, repo.org.Roles)
+	assert.Equal(t, []primitive.ObjectID{parentViewer.ID, parentEditor.ID}, repo.org.Users[0].Roles)
+
+	// replace keeps the inherited roles that are synced
+	_, err = s.Sync(ctx, orgIdentifier, SyncUserRequest{Username: "alice", Identifier: "alice@acme.com", Roles: []string{"viewer", "auditor"}, Mode: SyncModeReplace})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"viewer", "auditor"}, identifiers(repo, repo.org.Users[0].Roles))
+	assert.Len(t, repo.org.Roles, 1)
+
+	// local roles shadow inherited ones
+	repo.org.Roles = append(repo.org.Roles, mongo_entity.Role{ID: primitive.NewObjectID(), Identifier: "viewer"})
+	_, err = s.Sync(ctx, orgIdentifier, SyncUserRequest{Username: "bob", Identifier: "bob@acme.com", Roles: []string{"viewer"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []primitive.ObjectID{repo.org.Roles[1].ID}, repo.org.Users[1].Roles)
+}
+
+func TestSyncBatch(t *testing.T) {
+
+	ctx := context.Background()
+	repo := &mockRepository{}
+	s := newTestService(repo)
+
+	_, err := s.Sync(ctx, orgIdentifier, SyncUserRequest{Username: "alice", Identifier: "alice@acme.com", Roles: []string{"viewer"}})
+	assert.Nil(t, err)
+
+	response, err := s.SyncBatch(ctx, orgIdentifier, SyncBatchRequest{
+		Mode: SyncModeReplace,
+		Users: []SyncUserRequest{
+			{Username: "alice", Identifier: "alice@acme.com", Roles: []string{"viewer"}},
+			{Username: "bob", Identifier: "bob@acme.com", Roles: []string{"editor"}, Groups: []string{"sales"}},
+			{Username: "carol", Identifier: "carol@acme.com", Groups: []string{"sales"}},
+			{Username: "bob", Identifier: "bob@acme.com"},
+			{Identifier: "dave@acme.com"},
+			{Username: "erin", Identifier: "erin@acme.com", UserProperties: map[string]interface{}{"invalid": true}},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, response.Created)
+	assert.Equal(t, 0, response.Updated)
+	assert.Equal(t, 1, response.Unchanged)
+	assert.Equal(t, 3, response.Failed)
+	assert.Len(t, response.Results, 6)
+	assert.Equal(t, SyncUnchanged, response.Results[0].Status)
+	assert.Equal(t, repo.org.Users[0].ID.Hex(), response.Results[0].ID)
+	assert.Equal(t, SyncCreated, response.Results[1].Status)
+	assert.NotEmpty(t, response.Results[1].ID)
+	assert.Equal(t, SyncFailed, response.Results[3].Status)
+	assert.Equal(t, util.CodeInvalidInput, response.Results[3].Error.Code)
+	assert.Equal(t, "username", response.Results[4].Error.Details[0].Field)
+	assert.Equal(t, "Invalid user properties.", response.Results[5].Error.Message)
+
+	// groups created in the batch are shared by its users
+	assert.Len(t, repo.org.Groups, 1)
+	assert.Len(t, repo.org.Users, 3)
+
+	_, err = s.SyncBatch(ctx, orgIdentifier, SyncBatchRequest{})
+	assert.IsType(t, &util.InvalidInputError{}, err)
+}
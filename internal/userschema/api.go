@@ -0,0 +1,72 @@
+package userschema
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shashimalcse/cronuseo/internal/util"
+)
+
+func RegisterHandlers(r *echo.Group, service Service) {
+	res := resource{service}
+	router := r.Group("/o/:org_id/user-schema")
+	router.GET("", res.get)
+	router.PUT("", res.update)
+	router.DELETE("", res.delete)
+}
+
+type resource struct {
+	service Service
+}
+
+// @Description Get the user property schema of the organization.
+// @Tags        User
+// @Param org_id path string true "Organization ID"
+// @Produce     json
+// @Success     200 {object}  Schema
+// @failure     404,500
+// @Router      /o/{org_id}/user-schema [get]
+func (r resource) get(c echo.Context) error {
+
+	schema, err := r.service.Get(c.Request().Context(), c.Param("org_id"))
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, schema)
+}
+
+// @Description Replace the user property schema of the organization.
+// @Tags        User
+// @Accept      json
+// @Param org_id path string true "Organization ID"
+// @Param request body Schema true "body"
+// @Produce     json
+// @Success     200 {object}  Schema
+// @failure     400,404,500
+// @Router      /o/{org_id}/user-schema [put]
+func (r resource) update(c echo.Context) error {
+
+	var input Schema
+	if err := c.Bind(&input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs")
+	}
+	schema, err := r.service.Update(c.Request().Context(), c.Param("org_id"), input)
+	if err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusOK, schema)
+}
+
+// @Description Delete the user property schema of the organization.
+// @Tags        User
+// @Param org_id path string true "Organization ID"
+// @Success     204
+// @failure     404,500
+// @Router      /o/{org_id}/user-schema [delete]
+func (r resource) delete(c echo.Context) error {
+
+	if err := r.service.Delete(c.Request().Context(), c.Param("org_id")); err != nil {
+		return util.HandleError(err)
+	}
+	return c.JSON(http.StatusNoContent, "")
+}
@@ -0,0 +1,79 @@
+package userschema
+
+import (
+	"context"
+
+	db "github.com/shashimalcse/cronuseo/internal/db/mongo"
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Repository interface {
+	Get(ctx context.Context, org_id string) (*mongo_entity.PropertySchema, error)
+	Update(ctx context.Context, org_id string, schema *mongo_entity.PropertySchema) error
+}
+
+type repository struct {
+	mongoClient *mongo.Client
+	mongoColl   *mongo.Collection
+}
+
+func NewRepository(mongodb *db.MongoDB) Repository {
+
+	orgCollection := mongodb.MongoClient.Database(mongodb.MongoConfig.DBName).Collection(mongodb.MongoConfig.OrganizationCollectionName)
+
+	return repository{mongoClient: mongodb.MongoClient, mongoColl: orgCollection}
+}
+
+// Get returns the user property schema of the organization, nil when none is
+// set.
+func (r repository) Get(ctx context.Context, org_id string) (*mongo_entity.PropertySchema, error) {
+
+	ctx, span := telemetry.Repository(ctx, "userschema", "Get")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	var org mongo_entity.Organization
+	opts := options.FindOne().SetProjection(bson.M{"user_property_schema": 1})
+	err = r.mongoColl.FindOne(ctx, bson.M{"_id": orgId}, opts).Decode(&org)
+	if err == mongo.ErrNoDocuments {
+		return nil, &util.NotFoundError{Path: "Organization"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return org.UserPropertySchema, nil
+}
+
+// Update replaces the user property schema of the organization, or removes it
+// when the schema is nil.
+func (r repository) Update(ctx context.Context, org_id string, schema *mongo_entity.PropertySchema) error {
+
+	ctx, span := telemetry.Repository(ctx, "userschema", "Update")
+	defer span.End()
+
+	orgId, err := primitive.ObjectIDFromHex(org_id)
+	if err != nil {
+		return &util.NotFoundError{Path: "Organization"}
+	}
+	update := bson.M{"$set": bson.M{"user_property_schema": schema}}
+	if schema == nil {
+		update = bson.M{"$unset": bson.M{"user_property_schema": ""}}
+	}
+	result, err := r.mongoColl.UpdateOne(ctx, bson.M{"_id": orgId}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return &util.NotFoundError{Path: "Organization"}
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package userschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Property types.
+const (
+	TypeObject  = "object"
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+	TypeArray   = "array"
+)
+
+// Codes of the field errors of invalid properties and policies.
+const (
+	CodeRequired        = "validation_required"
+	CodeInvalidType     = "validation_invalid_type"
+	CodeNotInEnum       = "validation_not_in_enum"
+	CodeUnknownProperty = "validation_unknown_property"
+	CodeInvalidOperator = "validation_invalid_operator"
+	CodeInvalidPolicy   = "validation_invalid_policy"
+)
+
+var types = map[string]bool{
+	TypeObject: true, TypeString: true, TypeNumber: true, TypeInteger: true, TypeBoolean: true, TypeArray: true,
+}
+
+// check returns the errors of the definition of the schema at the path.
+func check(schema *mongo_entity.PropertySchema, path string) []util.FieldError {
+
+	if schema == nil {
+		return []util.FieldError{{Field: path, Code: CodeRequired, Message: "schema is required"}}
+	}
+	errs := []util.FieldError{}
+	if !types[schema.Type] {
+		return append(errs, util.FieldError{Field: join(path, "type"), Code: CodeInvalidType, Message: "unsupported type " + schema.Type})
+	}
+	if len(schema.Properties) > 0 && schema.Type != TypeObject {
+		errs = append(errs, util.FieldError{Field: join(path, "properties"), Code: CodeInvalidType, Message: "only object schemas have properties"})
+	}
+	if schema.Items != nil && schema.Type != TypeArray {
+		errs = append(errs, util.FieldError{Field: join(path, "items"), Code: CodeInvalidType, Message: "only array schemas have items"})
+	}
+	for _, name := range names(schema.Properties) {
+		if name == "" || strings.Contains(name, ".") || strings.HasPrefix(name, "$") {
+			errs = append(errs, util.FieldError{Field: join(path, "properties"), Code: CodeUnknownProperty, Message: fmt.Sprintf("invalid property name %q", name)})
+			continue
+		}
+		errs = append(errs, check(schema.Properties[name], join(path, "properties."+name))...)
+	}
+	for _, name := range schema.Required {
+		if schema.Properties[name] == nil {
+			errs = append(errs, util.FieldError{Field: join(path, "required"), Code: CodeUnknownProperty, Message: "required property " + name + " is not defined"})
+		}
+	}
+	if schema.Items != nil {
+		errs = append(errs, check(schema.Items, join(path, "items"))...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	// Enum and default values must be valid values of the schema.
+	value := *schema
+	value.Enum, value.Default = nil, nil
+	for i, enum := range schema.Enum {
+		if _, invalid := validate(&value, enum, join(path, fmt.Sprintf("enum.%d", i))); len(invalid) > 0 {
+			errs = append(errs, invalid...)
+		}
+	}
+	if schema.Default != nil {
+		if _, invalid := validate(schema, schema.Default, join(path, "default")); len(invalid) > 0 {
+			errs = append(errs, invalid...)
+		}
+	}
+	return errs
+}
+
+// validate checks the value against the schema and returns it with the
+// defaults of missing object properties. Null properties are missing.
+func validate(schema *mongo_entity.PropertySchema, value interface{}, path string) (interface{}, []util.FieldError) {
+
+	value = Normalize(value)
+	errs := []util.FieldError{}
+	invalidType := func() (interface{}, []util.FieldError) {
+		return value, append(errs, util.FieldError{Field: path, Code: CodeInvalidType, Message: "must be of type " + schema.Type})
+	}
+	switch schema.Type {
+	case TypeObject:
+		properties, ok := value.(map[string]interface{})
+		if !ok {
+			return invalidType()
+		}
+		result := map[string]interface{}{}
+		for _, name := range keys(properties) {
+			property := properties[name]
+			if property == nil {
+				continue
+			}
+			if schema.Properties[name] == nil {
+				if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+					errs = append(errs, util.FieldError{Field: join(path, name), Code: CodeUnknownProperty, Message: "is not defined by the schema"})
+				}
+				result[name] = property
+				continue
+			}
+			validated, invalid := validate(schema.Properties[name], property, join(path, name))
+			errs = append(errs, invalid...)
+			result[name] = validated
+		}
+		for _, name := range names(schema.Properties) {
+			if _, ok := result[name]; !ok && schema.Properties[name].Default != nil {
+				result[name] = Normalize(schema.Properties[name].Default)
+			}
+		}
+		for _, name := range schema.Required {
+			if _, ok := result[name]; !ok {
+				errs = append(errs, util.FieldError{Field: join(path, name), Code: CodeRequired, Message: "cannot be blank"})
+			}
+		}
+		value = result
+	case TypeArray:
+		items, ok := value.([]interface{})
+		if !ok {
+			return invalidType()
+		}
+		if schema.Items != nil {
+			for i, item := range items {
+				validated, invalid := validate(schema.Items, item, join(path, fmt.Sprint(i)))
+				errs = append(errs, invalid...)
+				items[i] = validated
+			}
+		}
+	case TypeString:
+		if _, ok := value.(string); !ok {
+			return invalidType()
+		}
+	case TypeNumber:
+		if _, ok := value.(float64); !ok {
+			return invalidType()
+		}
+	case TypeInteger:
+		if number, ok := value.(float64); !ok || number != math.Trunc(number) {
+			return invalidType()
+		}
+	case TypeBoolean:
+		if _, ok := value.(bool); !ok {
+			return invalidType()
+		}
+	}
+	if len(schema.Enum) > 0 && len(errs) == 0 {
+		for _, enum := range schema.Enum {
+			if reflect.DeepEqual(Normalize(enum), value) {
+				return value, errs
+			}
+		}
+		errs = append(errs, util.FieldError{Field: path, Code: CodeNotInEnum, Message: "must be one of the enum values"})
+	}
+	return value, errs
+}
+
+// lookup returns the schema of the dotted property path, nil when the schema
+// does not define it.
+func lookup(schema *mongo_entity.PropertySchema, path string) *mongo_entity.PropertySchema {
+
+	for _, name := range strings.Split(path, ".") {
+		if schema == nil || schema.Type != TypeObject {
+			return nil
+		}
+		schema = schema.Properties[name]
+	}
+	return schema
+}
+
+// policy is a policy tunnel policy, a list of paths of conditions on user
+// properties of which one must hold.
+type policy [][]struct {
+	Attribute struct {
+		Name string `json:"name"`
+	} `json:"attribute"`
+	Operator string `json:"operator"`
+}
+
+// operatorTypes are the property types the policy operators apply to.
+var operatorTypes = map[string]string{
+	"equal":                    TypeString,
+	"not_equal":                TypeString,
+	"contains":                 TypeArray,
+	"not_contains":             TypeArray,
+	"contain_at_least_one":     TypeArray,
+	"not_contain_at_least_one": TypeArray,
+}
+
+// checkPolicy returns the errors of the property references of the policy.
+func checkPolicy(schema *mongo_entity.PropertySchema, content string) []util.FieldError {
+
+	var paths policy
+	if err := json.Unmarshal([]byte(content), &paths); err != nil {
+		return []util.FieldError{{Field: "policy", Code: CodeInvalidPolicy, Message: "is not a valid policy"}}
+	}
+	errs := []util.FieldError{}
+	for i, path := range paths {
+		for j, condition := range path {
+			field := fmt.Sprintf("policy.%d.%d", i, j)
+			property := lookup(schema, condition.Attribute.Name)
+			if property == nil {
+				errs = append(errs, util.FieldError{Field: field + ".attribute.name", Code: CodeUnknownProperty,
+					Message: "user property " + condition.Attribute.Name + " is not defined by the schema"})
+				continue
+			}
+			if operatorTypes[condition.Operator] != property.Type {
+				errs = append(errs, util.FieldError{Field: field + ".operator", Code: CodeInvalidOperator,
+					Message: "operator " + condition.Operator + " does not apply to " + property.Type + " property " + condition.Attribute.Name})
+			}
+		}
+	}
+	return errs
+}
+
+// Normalize converts decoded BSON and typed Go values to JSON values, so
+// stored and requested properties compare equal.
+func Normalize(value interface{}) interface{} {
+
+	switch v := value.(type) {
+	case nil, string, bool, float64:
+		return v
+	case primitive.D:
+		result := map[string]interface{}{}
+		for _, e := range v {
+			result[e.Key] = Normalize(e.Value)
+		}
+		return result
+	case primitive.A:
+		return Normalize([]interface{}(v))
+	case primitive.M:
+		return Normalize(map[string]interface{}(v))
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32:
+		return rv.Float()
+	case reflect.Slice, reflect.Array:
+		result := make([]interface{}, rv.Len())
+		for i := range result {
+			result[i] = Normalize(rv.Index(i).Interface())
+		}
+		return result
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return value
+		}
+		result := map[string]interface{}{}
+		iter := rv.MapRange()
+		for iter.Next() {
+			result[iter.Key().String()] = Normalize(iter.Value().Interface())
+		}
+		return result
+	}
+	return value
+}
+
+func names(properties map[string]*mongo_entity.PropertySchema) []string {
+
+	result := make([]string, 0, len(properties))
+	for name := range properties {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func keys(properties map[string]interface{}) []string {
+
+	result := make([]string, 0, len(properties))
+	for name := range properties {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func join(path string, name string) string {
+
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
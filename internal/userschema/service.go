@@ -0,0 +1,139 @@
+package userschema
+
+import (
+	"context"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/telemetry"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"go.uber.org/zap"
+)
+
+// Validator validates user properties and policies against the user property
+// schemas of organizations. Organizations without a schema accept any
+// properties and policies.
+type Validator interface {
+	// ValidateProperties returns the properties with the defaults of missing
+	// properties, or an InvalidInputError with the invalid properties.
+	ValidateProperties(ctx context.Context, org_id string, properties map[string]interface{}) (map[string]interface{}, error)
+	// ValidatePolicy returns an InvalidInputError when the policy refers to
+	// properties the schema does not define or compares them with operators
+	// not applying to their type.
+	ValidatePolicy(ctx context.Context, org_id string, policy string) error
+}
+
+type Service interface {
+	Validator
+	Get(ctx context.Context, org_id string) (Schema, error)
+	Update(ctx context.Context, org_id string, req Schema) (Schema, error)
+	Delete(ctx context.Context, org_id string) error
+}
+
+type Schema struct {
+	mongo_entity.PropertySchema
+}
+
+type service struct {
+	repo   Repository
+	logger *zap.Logger
+}
+
+func NewService(repo Repository, logger *zap.Logger) Service {
+
+	return service{repo: repo, logger: logger}
+}
+
+// Get the user property schema of the organization.
+func (s service) Get(ctx context.Context, org_id string) (Schema, error) {
+
+	ctx, span := telemetry.Start(ctx, "userschema.service.Get")
+	defer span.End()
+
+	schema, err := s.repo.Get(ctx, org_id)
+	if err != nil {
+		return Schema{}, err
+	}
+	if schema == nil {
+		return Schema{}, &util.NotFoundError{Path: "User property schema"}
+	}
+	return Schema{*schema}, nil
+}
+
+// Update the user property schema of the organization. Existing users are
+// validated against it when their properties change.
+func (s service) Update(ctx context.Context, org_id string, req Schema) (Schema, error) {
+
+	ctx, span := telemetry.Start(ctx, "userschema.service.Update")
+	defer span.End()
+
+	if req.Type != TypeObject {
+		return Schema{}, &util.InvalidInputError{Message: "Invalid input for user property schema.",
+			Fields: []util.FieldError{{Field: "type", Code: CodeInvalidType, Message: "must be " + TypeObject}}}
+	}
+	if errs := check(&req.PropertySchema, ""); len(errs) > 0 {
+		s.logger.Debug("Error while validating user property schema.", zap.String("organization_id", org_id))
+		return Schema{}, &util.InvalidInputError{Message: "Invalid input for user property schema.", Fields: errs}
+	}
+	if err := s.repo.Update(ctx, org_id, &req.PropertySchema); err != nil {
+		s.logger.Error("Error while updating user property schema.", zap.String("organization_id", org_id))
+		return Schema{}, err
+	}
+	return req, nil
+}
+
+// Delete the user property schema of the organization.
+func (s service) Delete(ctx context.Context, org_id string) error {
+
+	ctx, span := telemetry.Start(ctx, "userschema.service.Delete")
+	defer span.End()
+
+	if err := s.repo.Update(ctx, org_id, nil); err != nil {
+		s.logger.Error("Error while deleting user property schema.", zap.String("organization_id", org_id))
+		return err
+	}
+	return nil
+}
+
+// ValidateProperties validates user properties against the schema of the
+// organization.
+func (s service) ValidateProperties(ctx context.Context, org_id string, properties map[string]interface{}) (map[string]interface{}, error) {
+
+	ctx, span := telemetry.Start(ctx, "userschema.service.ValidateProperties")
+	defer span.End()
+
+	schema, err := s.repo.Get(ctx, org_id)
+	if err != nil {
+		return nil, err
+	}
+	if schema == nil {
+		return properties, nil
+	}
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+	validated, errs := validate(schema, properties, "user_properties")
+	if len(errs) > 0 {
+		return nil, &util.InvalidInputError{Message: "Invalid user properties.", Fields: errs}
+	}
+	return validated.(map[string]interface{}), nil
+}
+
+// ValidatePolicy validates the property references of a policy against the
+// schema of the organization.
+func (s service) ValidatePolicy(ctx context.Context, org_id string, policy string) error {
+
+	ctx, span := telemetry.Start(ctx, "userschema.service.ValidatePolicy")
+	defer span.End()
+
+	schema, err := s.repo.Get(ctx, org_id)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		return nil
+	}
+	if errs := checkPolicy(schema, policy); len(errs) > 0 {
+		return &util.InvalidInputError{Message: "Invalid policy for the user property schema.", Fields: errs}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package userschema
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shashimalcse/cronuseo/internal/mongo_entity"
+	"github.com/shashimalcse/cronuseo/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+func TestService(t *testing.T) {
+
+	orgId := primitive.NewObjectID().Hex()
+	repo := &mockRepository{}
+	s := NewService(repo, zap.NewNop())
+	ctx := context.Background()
+
+	// without a schema any properties and policies are accepted
+	properties, err := s.ValidateProperties(ctx, orgId, map[string]interface{}{"level": "x"})
+	assert.Nil(t, err)
+	assert.Equal(t, "x", properties["level"])
+	assert.Nil(t, s.ValidatePolicy(ctx, orgId, "not a policy"))
+	_, err = s.Get(ctx, orgId)
+	assert.IsType(t, &util.NotFoundError{}, err)
+
+	closed := false
+	invalid := []mongo_entity.PropertySchema{
+		{Type: TypeString},
+		{Type: TypeObject, Properties: map[string]*mongo_entity.PropertySchema{"level": {Type: "text"}}},
+		{Type: TypeObject, Properties: map[string]*mongo_entity.PropertySchema{"a.b": {Type: TypeString}}},
+		{Type: TypeObject, Required: []string{"level"}},
+		{Type: TypeObject, Properties: map[string]*mongo_entity.PropertySchema{"level": {Type: TypeInteger, Enum: []interface{}{1.0, "two"}}}},
+		{Type: TypeObject, Properties: map[string]*mongo_entity.PropertySchema{"level": {Type: TypeInteger, Default: 1.5}}},
+		{Type: TypeObject, Properties: map[string]*mongo_entity.PropertySchema{"level": {Type: TypeString, Items: &mongo_entity.PropertySchema{Type: TypeString}}}},
+	}
+	for i, schema := range invalid {
+		_, err := s.Update(ctx, orgId, Schema{schema})
+		assert.IsType(t, &util.InvalidInputError{}, err, i)
+	}
+	_, err = s.Update(ctx, orgId, Schema{mongo_entity.PropertySchema{
+		Type:                 TypeObject,
+		AdditionalProperties: &closed,
+		Required:             []string{"department", "level"},
+		Properties: map[string]*mongo_entity.PropertySchema{
+			"department": {Type: TypeString, Enum: []interface{}{"finance", "sales"}},
+			"level":      {Type: TypeInteger, Default: 1.0},
+			"contractor": {Type: TypeBoolean},
+			"groups":     {Type: TypeArray, Items: &mongo_entity.PropertySchema{Type: TypeString}},
+			"address": {Type: TypeObject, Properties: map[string]*mongo_entity.PropertySchema{
+				"country": {Type: TypeString},
+			}},
+		},
+	}})
+	assert.Nil(t, err)
+
+	// defaults are applied and stored values are normalized
+	properties, err = s.ValidateProperties(ctx, orgId, map[string]interface{}{
+		"department": "finance",
+		"groups":     primitive.A{"payments"},
+		"address":    primitive.D{{Key: "country", Value: "LK"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"department": "finance",
+		"level":      1.0,
+		"groups":     []interface{}{"payments"},
+		"address":    map[string]interface{}{"country": "LK"},
+	}, properties)
+	properties, err = s.ValidateProperties(ctx, orgId, map[string]interface{}{"department": "sales", "level": int32(3)})
+	assert.Nil(t, err)
+	assert.Equal(t, 3.0, properties["level"])
+
+	_, err = s.ValidateProperties(ctx, orgId, map[string]interface{}{
+		"department": "hr",
+		"level":      1.5,
+		"contractor": "yes",
+		"groups":     []interface{}{"payments", 1.0},
+		"nickname":   "al",
+	})
+	assert.IsType(t, &util.InvalidInputError{}, err)
+	assert.Equal(t, []util.FieldError{
+		{Field: "user_properties.contractor", Code: CodeInvalidType, Message: "must be of type boolean"},
+		{Field: "user_properties.department", Code: CodeNotInEnum, Message: "must be one of the enum values"},
+		{Field: "user_properties.groups.1", Code: CodeInvalidType, Message: "must be of type string"},
+		{Field: "user_properties.level", Code: CodeInvalidType, Message: "must be of type integer"},
+		{Field: "user_properties.nickname", Code: CodeUnknownProperty, Message: "is not defined by the schema"},
+	}, err.(*util.InvalidInputError).Fields)
+	_, err = s.ValidateProperties(ctx, orgId, nil)
+	assert.Equal(t, "user_properties.department", err.(*util.InvalidInputError).Fields[0].Field)
+
+	// policies may only refer to defined properties with matching operators
+	assert.Nil(t, s.ValidatePolicy(ctx, orgId, `[[{"attribute":{"name":"department","type":"string"},"operator":"equal","value":["finance"]},
+		{"attribute":{"name":"address.country","type":"string"},"operator":"not_equal","value":["US"]}],
+		[{"attribute":{"name":"groups","type":"array"},"operator":"contains","value":["payments"]}]]`))
+	err = s.ValidatePolicy(ctx, orgId, `[[{"attribute":{"name":"team","type":"string"},"operator":"equal","value":["a"]},
+		{"attribute":{"name":"groups","type":"array"},"operator":"equal","value":["a"]}]]`)
+	assert.IsType(t, &util.InvalidInputError{}, err)
+	assert.Equal(t, []util.FieldError{
+		{Field: "policy.0.0.attribute.name", Code: CodeUnknownProperty, Message: "user property team is not defined by the schema"},
+		{Field: "policy.0.1.operator", Code: CodeInvalidOperator, Message: "operator equal does not apply to array property groups"},
+	}, err.(*util.InvalidInputError).Fields)
+	err = s.ValidatePolicy(ctx, orgId, "not a policy")
+	assert.Equal(t, CodeInvalidPolicy, err.(*util.InvalidInputError).Fields[0].Code)
+
+	assert.Nil(t, s.Delete(ctx, orgId))
+	assert.Nil(t, s.ValidatePolicy(ctx, orgId, "not a policy"))
+}
+
+type mockRepository struct {
+	schema *mongo_entity.PropertySchema
+}
+
+func (m *mockRepository) Get(ctx context.Context, org_id string) (*mongo_entity.PropertySchema, error) {
+	return m.schema, nil
+}
+
+func (m *mockRepository) Update(ctx context.Context, org_id string, schema *mongo_entity.PropertySchema) error {
+	m.schema = schema
+	return nil
+}
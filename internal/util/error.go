@@ -1,12 +1,62 @@
 package util
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"time"
 
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
 )
 
+// Code is the stable machine readable code of an error response.
+type Code string
+
+const (
+	CodeInvalidInput     Code = "invalid_input"
+	CodeUnauthenticated  Code = "unauthenticated"
+	CodePermissionDenied Code = "permission_denied"
+	CodeNotFound         Code = "not_found"
+	CodeMethodNotAllowed Code = "method_not_allowed"
+	CodeAlreadyExists    Code = "already_exists"
+	CodeConflict         Code = "conflict"
+	CodeRateLimited      Code = "rate_limited"
+	CodeQuotaExceeded    Code = "quota_exceeded"
+	CodeUnavailable      Code = "unavailable"
+	CodeInternal         Code = "internal"
+)
+
+// ErrorDomain is the domain of the gRPC ErrorInfo details.
+const ErrorDomain = "cronuseo.io"
+
+// internalMessage replaces the message of unexpected errors, which may leak
+// internals.
+const internalMessage = "Internal server error."
+
+// ErrorResponse is the JSON body of every error response of the API.
+type ErrorResponse struct {
+	Code      Code         `json:"code"`
+	Message   string       `json:"message"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// FieldError is a validation error of a request field. Field is the JSON path
+// of the field, e.g. "permissions.0.action", and Code the ozzo-validation
+// error code, e.g. "validation_required".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
 type AlreadyExistsError struct {
 	Path string
 }
@@ -23,25 +73,34 @@ func (e *NotFoundError) Error() string {
 	return fmt.Sprintf("%v not found.", e.Path)
 }
 
+// InvalidInputError is returned for invalid requests, with the field errors
+// of the request validation.
 type InvalidInputError struct {
-	Path string
-}
-
-type SystemError struct {
 	Message string
+	Fields  []FieldError
 }
 
-func (e *SystemError) Error() string {
-	return e.Message
+// NewValidationError returns an InvalidInputError with the field errors of an
+// ozzo-validation error.
+func NewValidationError(message string, err error) *InvalidInputError {
+	return &InvalidInputError{Message: message, Fields: FieldErrors(err)}
 }
 
 func (e *InvalidInputError) Error() string {
-	if e.Path != "" {
-		return "Invalid " + e.Path
+	if e.Message != "" {
+		return e.Message
 	}
 	return "Invalid input."
 }
 
+type SystemError struct {
+	Message string
+}
+
+func (e *SystemError) Error() string {
+	return e.Message
+}
+
 type UnauthorizedError struct {
 	Message string
 }
@@ -50,19 +109,206 @@ func (e *UnauthorizedError) Error() string {
 	return e.Message
 }
 
-func HandleError(err error) *echo.HTTPError {
+// PermissionDeniedError is returned when the caller is not allowed to perform
+// the operation.
+type PermissionDeniedError struct {
+	Message string
+}
+
+func (e *PermissionDeniedError) Error() string {
+	return e.Message
+}
+
+// ConflictError is returned when a change conflicts with the state or the
+// rules of the organization, e.g. separation of duties rules.
+type ConflictError struct {
+	Message string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+// RateLimitError is returned when an organization exceeds its request rate.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return "Rate limit exceeded. Please retry later."
+}
+
+// QuotaExceededError is returned when an organization reaches a quota.
+type QuotaExceededError struct {
+	Path  string
+	Limit int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%v quota of %d exceeded.", e.Path, e.Limit)
+}
+
+// FieldErrors flattens the errors of an ozzo-validation error by field path.
+// Other errors have no field errors.
+func FieldErrors(err error) []FieldError {
+
+	var fields []FieldError
+	var walk func(prefix string, err error)
+	walk = func(prefix string, err error) {
+		var errs validation.Errors
+		var e validation.Error
+		switch {
+		case errors.As(err, &errs):
+			for name, err := range errs {
+				if prefix != "" {
+					name = prefix + "." + name
+				}
+				walk(name, err)
+			}
+		case errors.As(err, &e):
+			fields = append(fields, FieldError{Field: prefix, Code: e.Code(), Message: e.Error()})
+		}
+	}
+	if err != nil {
+		walk("", err)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	return fields
+}
+
+// describe returns the status, code and client message of an error.
+func describe(err error) (int, codes.Code, ErrorResponse) {
+
 	switch e := err.(type) {
 	case *InvalidInputError:
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid inputs. Please check your inputs.")
+		return http.StatusBadRequest, codes.InvalidArgument, ErrorResponse{Code: CodeInvalidInput, Message: e.Error(), Details: e.Fields}
 	case *AlreadyExistsError:
-		return echo.NewHTTPError(http.StatusConflict, e.Error())
+		return http.StatusConflict, codes.AlreadyExists, ErrorResponse{Code: CodeAlreadyExists, Message: e.Error()}
+	case *ConflictError:
+		return http.StatusConflict, codes.FailedPrecondition, ErrorResponse{Code: CodeConflict, Message: e.Error()}
 	case *NotFoundError:
-		return echo.NewHTTPError(http.StatusNotFound, e.Error())
+		return http.StatusNotFound, codes.NotFound, ErrorResponse{Code: CodeNotFound, Message: e.Error()}
 	case *SystemError:
-		return echo.NewHTTPError(http.StatusInternalServerError, e.Error())
+		return http.StatusInternalServerError, codes.Internal, ErrorResponse{Code: CodeInternal, Message: e.Error()}
 	case *UnauthorizedError:
-		return echo.NewHTTPError(http.StatusUnauthorized, e.Error())
+		return http.StatusUnauthorized, codes.Unauthenticated, ErrorResponse{Code: CodeUnauthenticated, Message: e.Error()}
+	case *PermissionDeniedError:
+		return http.StatusForbidden, codes.PermissionDenied, ErrorResponse{Code: CodePermissionDenied, Message: e.Error()}
+	case *RateLimitError:
+		return http.StatusTooManyRequests, codes.ResourceExhausted, ErrorResponse{Code: CodeRateLimited, Message: e.Error()}
+	case *QuotaExceededError:
+		return http.StatusForbidden, codes.ResourceExhausted, ErrorResponse{Code: CodeQuotaExceeded, Message: e.Error()}
+	default:
+		return http.StatusInternalServerError, codes.Internal, ErrorResponse{Code: CodeInternal, Message: internalMessage}
+	}
+}
+
+// HandleError converts an error to an HTTP error with an ErrorResponse
+// message. Unexpected errors are kept as the internal error for logging.
+func HandleError(err error) *echo.HTTPError {
+
+	code, _, response := describe(err)
+	httpErr := echo.NewHTTPError(code, &response)
+	if response.Code == CodeInternal {
+		httpErr.Internal = err
+	}
+	return httpErr
+}
+
+// codeOf returns the error code of an HTTP status.
+func codeOf(httpStatus int) Code {
+
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType:
+		return CodeInvalidInput
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodePermissionDenied
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeAlreadyExists
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	default:
+		return CodeInternal
+	}
+}
+
+// NewErrorResponse returns the body of an error response. HTTP errors with a
+// string message get the code of their status.
+func NewErrorResponse(err error) (int, ErrorResponse) {
+
+	httpErr, ok := err.(*echo.HTTPError)
+	if !ok {
+		httpErr = HandleError(err)
+	}
+	switch message := httpErr.Message.(type) {
+	case *ErrorResponse:
+		return httpErr.Code, *message
+	case ErrorResponse:
+		return httpErr.Code, message
+	case string:
+		return httpErr.Code, ErrorResponse{Code: codeOf(httpErr.Code), Message: message}
 	default:
-		return echo.NewHTTPError(http.StatusInternalServerError, "Server Error!")
+		if httpErr.Code >= http.StatusInternalServerError {
+			return httpErr.Code, ErrorResponse{Code: codeOf(httpErr.Code), Message: internalMessage}
+		}
+		return httpErr.Code, ErrorResponse{Code: codeOf(httpErr.Code), Message: http.StatusText(httpErr.Code)}
+	}
+}
+
+// ErrorHandler is the echo error handler rendering every error as an
+// ErrorResponse with the request id. Server errors are logged.
+func ErrorHandler(logger *zap.Logger) echo.HTTPErrorHandler {
+
+	return func(err error, c echo.Context) {
+
+		if c.Response().Committed {
+			return
+		}
+		code, response := NewErrorResponse(err)
+		response.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+		if code >= http.StatusInternalServerError {
+			logger.Error("Error while handling request.", zap.String("request_id", response.RequestID),
+				zap.String("method", c.Request().Method), zap.String("path", c.Path()), zap.Error(err))
+		}
+		if c.Request().Method == http.MethodHead {
+			err = c.NoContent(code)
+		} else {
+			err = c.JSON(code, response)
+		}
+		if err != nil {
+			logger.Error("Error while writing error response.", zap.String("request_id", response.RequestID), zap.Error(err))
+		}
+	}
+}
+
+// GrpcError converts an error to a gRPC status error with the code matching
+// the HTTP status used by HandleError. The error code is sent as the reason of
+// an ErrorInfo detail, and field errors as a BadRequest detail.
+func GrpcError(err error) error {
+
+	_, grpcCode, response := describe(err)
+	details := []protoiface.MessageV1{&errdetails.ErrorInfo{Reason: string(response.Code), Domain: ErrorDomain}}
+	if len(response.Details) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, field := range response.Details {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       field.Field,
+				Description: field.Message,
+			})
+		}
+		details = append(details, badRequest)
+	}
+	st := status.New(grpcCode, response.Message)
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
 	}
+	return st.Err()
 }
@@ -0,0 +1,138 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type permission struct {
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+}
+
+func (p permission) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.Resource, validation.Required),
+		validation.Field(&p.Action, validation.Required),
+	)
+}
+
+type roleRequest struct {
+	Identifier  string       `json:"identifier"`
+	Permissions []permission `json:"permissions"`
+}
+
+func (r roleRequest) Validate() error {
+	return validation.ValidateStruct(&r,
+		validation.Field(&r.Identifier, validation.Required),
+		validation.Field(&r.Permissions),
+	)
+}
+
+func TestFieldErrors(t *testing.T) {
+
+	err := roleRequest{Permissions: []permission{{Resource: "docs"}}}.Validate()
+	assert.Equal(t, []FieldError{
+		{Field: "identifier", Code: "validation_required", Message: "cannot be blank"},
+		{Field: "permissions.0.action", Code: "validation_required", Message: "cannot be blank"},
+	}, FieldErrors(err))
+	assert.Nil(t, FieldErrors(errors.New("boom")))
+}
+
+func TestErrorHandler(t *testing.T) {
+
+	e := echo.New()
+	e.HTTPErrorHandler = ErrorHandler(zap.NewNop())
+	e.Use(middleware.RequestID())
+	e.POST("/roles", func(c echo.Context) error {
+		return HandleError(NewValidationError("Invalid input for role.", roleRequest{}.Validate()))
+	})
+	e.GET("/boom", func(c echo.Context) error {
+		return HandleError(errors.New("connection refused"))
+	})
+	e.GET("/denied", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusForbidden, "insufficient permissions to invoke this endpoint")
+	})
+
+	serve := func(method string, path string) (int, ErrorResponse) {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(echo.HeaderXRequestID, "req-1")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		var response ErrorResponse
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		return rec.Code, response
+	}
+
+	code, response := serve(http.MethodPost, "/roles")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, ErrorResponse{
+		Code:      CodeInvalidInput,
+		Message:   "Invalid input for role.",
+		Details:   []FieldError{{Field: "identifier", Code: "validation_required", Message: "cannot be blank"}},
+		RequestID: "req-1",
+	}, response)
+
+	code, response = serve(http.MethodGet, "/boom")
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, ErrorResponse{Code: CodeInternal, Message: "Internal server error.", RequestID: "req-1"}, response)
+
+	code, response = serve(http.MethodGet, "/denied")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, CodePermissionDenied, response.Code)
+
+	code, response = serve(http.MethodGet, "/missing")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, CodeNotFound, response.Code)
+}
+
+func TestGrpcError(t *testing.T) {
+
+	interceptor := UnaryServerInterceptor()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDMetadata, "req-2"))
+	call := func(err error) *status.Status {
+		_, err = interceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, err
+		})
+		return status.Convert(err)
+	}
+
+	st := call(GrpcError(NewValidationError("Invalid input for role.", roleRequest{}.Validate())))
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	assert.Equal(t, "Invalid input for role.", st.Message())
+	assert.Len(t, st.Details(), 2)
+	info := st.Details()[0].(*errdetails.ErrorInfo)
+	assert.Equal(t, string(CodeInvalidInput), info.Reason)
+	assert.Equal(t, "req-2", info.Metadata["request_id"])
+	badRequest := st.Details()[1].(*errdetails.BadRequest)
+	assert.Equal(t, "identifier", badRequest.FieldViolations[0].Field)
+
+	// statuses without details get an ErrorInfo with the request id
+	st = call(status.Error(codes.Unavailable, "unavailable"))
+	info = st.Details()[0].(*errdetails.ErrorInfo)
+	assert.Equal(t, string(CodeUnavailable), info.Reason)
+	assert.Equal(t, "req-2", info.Metadata["request_id"])
+
+	st = call(GrpcError(&ConflictError{Message: "Separation of duties violated."}))
+	assert.Equal(t, codes.FailedPrecondition, st.Code())
+	assert.Equal(t, string(CodeConflict), st.Details()[0].(*errdetails.ErrorInfo).Reason)
+
+	st = call(GrpcError(errors.New("connection refused")))
+	assert.Equal(t, codes.Internal, st.Code())
+	assert.Equal(t, "Internal server error.", st.Message())
+}
@@ -0,0 +1,105 @@
+package util
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+)
+
+// RequestIDMetadata is the gRPC metadata key of the request id, matching the
+// X-Request-Id header of the HTTP API.
+const RequestIDMetadata = "x-request-id"
+
+// NewRequestID generates a random request id.
+func NewRequestID() string {
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// UnaryServerInterceptor assigns each call the request id of its metadata, or
+// a generated one, and sends it back in the response header and in the
+// ErrorInfo of error statuses.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		requestID := ""
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(RequestIDMetadata); len(values) > 0 && len(values[0]) <= 128 {
+				requestID = values[0]
+			}
+		}
+		if requestID == "" {
+			requestID = NewRequestID()
+		}
+		grpc.SetHeader(ctx, metadata.Pairs(RequestIDMetadata, requestID))
+
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+		return resp, withRequestID(err, requestID)
+	}
+}
+
+// withRequestID adds the request id to the ErrorInfo detail of a status
+// error, adding an ErrorInfo for statuses without one.
+func withRequestID(err error, requestID string) error {
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	var details []protoiface.MessageV1
+	found := false
+	for _, detail := range st.Details() {
+		message, ok := detail.(protoiface.MessageV1)
+		if !ok {
+			continue
+		}
+		if info, ok := message.(*errdetails.ErrorInfo); ok {
+			if info.Metadata == nil {
+				info.Metadata = map[string]string{}
+			}
+			info.Metadata["request_id"] = requestID
+			found = true
+		}
+		details = append(details, message)
+	}
+	if !found {
+		reason, ok := grpcCodes[st.Code()]
+		if !ok {
+			reason = CodeInternal
+		}
+		details = append(details, &errdetails.ErrorInfo{
+			Reason:   string(reason),
+			Domain:   ErrorDomain,
+			Metadata: map[string]string{"request_id": requestID},
+		})
+	}
+	withDetails, detailsErr := status.New(st.Code(), st.Message()).WithDetails(details...)
+	if detailsErr != nil {
+		return err
+	}
+	return withDetails.Err()
+}
+
+// grpcCodes are the error codes of gRPC status codes.
+var grpcCodes = map[codes.Code]Code{
+	codes.InvalidArgument:   CodeInvalidInput,
+	codes.Unauthenticated:   CodeUnauthenticated,
+	codes.PermissionDenied:  CodePermissionDenied,
+	codes.NotFound:          CodeNotFound,
+	codes.AlreadyExists:     CodeAlreadyExists,
+	codes.ResourceExhausted: CodeRateLimited,
+	codes.Unavailable:       CodeUnavailable,
+	codes.Unimplemented:     CodeMethodNotAllowed,
+}
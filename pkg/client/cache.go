@@ -0,0 +1,88 @@
+package client
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// decisionCache is a fixed size LRU cache of check decisions whose entries
+// expire after a TTL.
+type decisionCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	key     string
+	allowed bool
+	expires time.Time
+}
+
+func newDecisionCache(size int, ttl time.Duration) *decisionCache {
+
+	return &decisionCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+		now:     time.Now,
+	}
+}
+
+func (c *decisionCache) get(key string) (bool, bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return false, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if c.now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return false, false
+	}
+	c.order.MoveToFront(element)
+	return entry.allowed, true
+}
+
+func (c *decisionCache) add(key string, allowed bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := c.now().Add(c.ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.allowed = allowed
+		entry.expires = expires
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, allowed: allowed, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *decisionCache) purge() {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+}
+
+func (c *decisionCache) len() int {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecisionCache(t *testing.T) {
+
+	now := time.Now()
+	cache := newDecisionCache(2, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.add("a", true)
+	cache.add("b", false)
+	allowed, ok := cache.get("a")
+	assert.True(t, ok)
+	assert.True(t, allowed)
+
+	// "b" is the least recently used entry and gets evicted.
+	cache.add("c", true)
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 2, cache.len())
+
+	// Entries expire after the TTL.
+	now = now.Add(2 * time.Minute)
+	_, ok = cache.get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, cache.len())
+
+	cache.purge()
+	assert.Equal(t, 0, cache.len())
+}
@@ -0,0 +1,239 @@
+// Package client is the Go SDK for cronuseo permission checks. It talks to
+// the REST check endpoint or the gRPC check server, retries transient
+// failures and can cache decisions locally.
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// CheckRequest asks whether the user with the identifier may perform the
+// action on the resource.
+type CheckRequest struct {
+	Identifier string
+	Resource   string
+	Action     string
+}
+
+// Client checks permissions of one organization.
+type Client struct {
+	organization string
+	transport    transport
+	cache        *decisionCache
+	maxRetries   int
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	concurrency  int
+}
+
+type options struct {
+	httpClient  *http.Client
+	cacheSize   int
+	cacheTTL    time.Duration
+	maxRetries  int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	concurrency int
+}
+
+// Option configures a Client.
+type Option func(*options)
+
+// WithHTTPClient sets the HTTP client of the REST transport.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithCache enables an LRU cache of up to size decisions, each kept for ttl.
+// Cached decisions do not see permission changes until they expire.
+func WithCache(size int, ttl time.Duration) Option {
+	return func(o *options) {
+		o.cacheSize = size
+		o.cacheTTL = ttl
+	}
+}
+
+// WithRetry sets how often a transient failure is retried and the bounds of
+// the exponential backoff between attempts. Use 0 retries to disable retrying.
+func WithRetry(maxRetries int, minBackoff time.Duration, maxBackoff time.Duration) Option {
+	return func(o *options) {
+		o.maxRetries = maxRetries
+		o.minBackoff = minBackoff
+		o.maxBackoff = maxBackoff
+	}
+}
+
+// WithConcurrency limits the parallel calls of CheckBatch.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+func buildOptions(opts []Option) options {
+
+	o := options{
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		maxRetries:  2,
+		minBackoff:  100 * time.Millisecond,
+		maxBackoff:  2 * time.Second,
+		concurrency: 8,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.concurrency < 1 {
+		o.concurrency = 1
+	}
+	return o
+}
+
+func newClient(organization string, t transport, o options) *Client {
+
+	c := &Client{
+		organization: organization,
+		transport:    t,
+		maxRetries:   o.maxRetries,
+		minBackoff:   o.minBackoff,
+		maxBackoff:   o.maxBackoff,
+		concurrency:  o.concurrency,
+	}
+	if o.cacheSize > 0 && o.cacheTTL > 0 {
+		c.cache = newDecisionCache(o.cacheSize, o.cacheTTL)
+	}
+	return c
+}
+
+// NewHTTP creates a client using the REST check endpoint. baseURL is the
+// server root, for example http://localhost:8080, and organization is the
+// organization identifier.
+func NewHTTP(baseURL string, organization string, apiKey string, opts ...Option) *Client {
+
+	o := buildOptions(opts)
+	return newClient(organization, httpTransport{baseURL: baseURL, apiKey: apiKey, client: o.httpClient}, o)
+}
+
+// NewGRPC creates a client using the gRPC check server over conn.
+func NewGRPC(conn grpc.ClientConnInterface, organization string, apiKey string, opts ...Option) *Client {
+
+	o := buildOptions(opts)
+	return newClient(organization, newGrpcTransport(conn, apiKey), o)
+}
+
+// Check reports whether the user may perform the action on the resource.
+func (c *Client) Check(ctx context.Context, identifier string, resource string, action string) (bool, error) {
+
+	req := CheckRequest{Identifier: identifier, Resource: resource, Action: action}
+	key := cacheKey(req)
+	if c.cache != nil {
+		if allowed, ok := c.cache.get(key); ok {
+			return allowed, nil
+		}
+	}
+
+	var allowed bool
+	var err error
+	for attempt := 0; ; attempt++ {
+		allowed, err = c.transport.check(ctx, c.organization, req)
+		if err == nil || attempt >= c.maxRetries || !retryable(ctx, err) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(c.backoff(attempt)):
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	if c.cache != nil {
+		c.cache.add(key, allowed)
+	}
+	return allowed, nil
+}
+
+// CheckBatch runs the checks in parallel and returns the decisions in request
+// order. It fails with the first error encountered.
+func (c *Client) CheckBatch(ctx context.Context, reqs []CheckRequest) ([]bool, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]bool, len(reqs))
+	var once sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, c.concurrency)
+	for i, req := range reqs {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, req CheckRequest) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			allowed, err := c.Check(ctx, req.Identifier, req.Resource, req.Action)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			results[i] = allowed
+		}(i, req)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+// Purge drops all cached decisions, for example after changing permissions.
+func (c *Client) Purge() {
+
+	if c.cache != nil {
+		c.cache.purge()
+	}
+}
+
+// backoff returns the jittered exponential delay before the next attempt.
+func (c *Client) backoff(attempt int) time.Duration {
+
+	delay := c.minBackoff << uint(attempt)
+	if delay <= 0 || delay > c.maxBackoff {
+		delay = c.maxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func retryable(ctx context.Context, err error) bool {
+
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.retryable()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func cacheKey(req CheckRequest) string {
+	return strings.Join([]string{req.Identifier, req.Resource, req.Action}, "\x00")
+}
@@ -0,0 +1,182 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// checkServer answers checks allowing only the "read" action.
+func checkServer(t *testing.T, failures int32) (*httptest.Server, *int32) {
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		assert.Equal(t, "/api/v1/o/acme/check", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("API_KEY") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message":"Unauthorized"}`))
+			return
+		}
+		if n <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"message":"Server Error!"}`))
+			return
+		}
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]bool{"allowed": req["action"] == "read"})
+	}))
+	return server, &calls
+}
+
+func TestCheck(t *testing.T) {
+
+	server, calls := checkServer(t, 1)
+	defer server.Close()
+
+	c := NewHTTP(server.URL, "acme", "key", WithRetry(2, time.Millisecond, time.Millisecond), WithCache(10, time.Minute))
+	allowed, err := c.Check(context.Background(), "jane", "invoices", "read")
+	assert.Nil(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int32(2), atomic.LoadInt32(calls))
+
+	// Served from the cache.
+	allowed, err = c.Check(context.Background(), "jane", "invoices", "read")
+	assert.Nil(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int32(2), atomic.LoadInt32(calls))
+
+	results, err := c.CheckBatch(context.Background(), []CheckRequest{
+		{Identifier: "jane", Resource: "invoices", Action: "write"},
+		{Identifier: "jane", Resource: "invoices", Action: "read"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []bool{false, true}, results)
+
+	_, err = NewHTTP(server.URL, "acme", "wrong").Check(context.Background(), "jane", "invoices", "read")
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+}
+
+func TestRetryGivesUp(t *testing.T) {
+
+	server, calls := checkServer(t, 10)
+	defer server.Close()
+
+	c := NewHTTP(server.URL, "acme", "key", WithRetry(1, time.Millisecond, time.Millisecond))
+	_, err := c.Check(context.Background(), "jane", "invoices", "read")
+	assert.True(t, errors.Is(err, ErrServer))
+	assert.Equal(t, int32(2), atomic.LoadInt32(calls))
+}
+
+func TestGrpcErrors(t *testing.T) {
+
+	err := fromGrpc(status.Error(codes.NotFound, "User not found."), nil)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.False(t, errors.Is(err, ErrServer))
+	err = fromGrpc(status.Error(codes.Unavailable, "down"), nil)
+	assert.True(t, err.(*Error).retryable())
+	err = fromGrpc(status.Error(codes.PermissionDenied, "Organization is suspended."), nil)
+	assert.True(t, errors.Is(err, ErrPermissionDenied))
+
+	// rate limits keep their retry-after, exceeded quotas are denied
+	err = fromGrpc(status.Error(codes.ResourceExhausted, "Rate limit exceeded."), metadata.Pairs("retry-after", "3"))
+	assert.True(t, errors.Is(err, ErrRateLimited))
+	assert.Equal(t, 3*time.Second, err.(*Error).RetryAfter)
+	st, _ := status.New(codes.ResourceExhausted, "check requests quota of 10 exceeded.").WithDetails(&errdetails.ErrorInfo{Reason: "quota_exceeded"})
+	err = fromGrpc(st.Err(), nil)
+	assert.True(t, errors.Is(err, ErrPermissionDenied))
+	assert.False(t, err.(*Error).retryable())
+}
+
+func TestMiddlewareErrors(t *testing.T) {
+
+	tests := []struct {
+		status     int
+		retryAfter string
+		want       int
+		sentinel   error
+	}{
+		{http.StatusTooManyRequests, "2", http.StatusTooManyRequests, ErrRateLimited},
+		{http.StatusForbidden, "", http.StatusForbidden, ErrPermissionDenied},
+		{http.StatusBadRequest, "", http.StatusForbidden, ErrInvalidInput},
+		{http.StatusNotFound, "", http.StatusForbidden, ErrNotFound},
+		{http.StatusUnauthorized, "", http.StatusServiceUnavailable, ErrUnauthorized},
+		{http.StatusInternalServerError, "", http.StatusServiceUnavailable, ErrServer},
+	}
+	for _, tc := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tc.retryAfter != "" {
+				w.Header().Set("Retry-After", tc.retryAfter)
+			}
+			w.WriteHeader(tc.status)
+		}))
+		c := NewHTTP(server.URL, "acme", "key", WithRetry(0, 0, 0))
+		_, err := c.Check(context.Background(), "jane", "invoices", "read")
+		assert.True(t, errors.Is(err, tc.sentinel), tc.status)
+
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User", "jane")
+		rec := httptest.NewRecorder()
+		c.Middleware("invoices:read", HeaderIdentity("X-User"))(ok).ServeHTTP(rec, req)
+		assert.Equal(t, tc.want, rec.Code, tc.status)
+		assert.Equal(t, tc.retryAfter, rec.Header().Get("Retry-After"), tc.status)
+
+		e := echo.New()
+		e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusNoContent) },
+			c.EchoMiddleware("invoices:read", HeaderIdentity("X-User")))
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, tc.want, rec.Code, tc.status)
+		assert.Equal(t, tc.retryAfter, rec.Header().Get("Retry-After"), tc.status)
+		server.Close()
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+
+	server, _ := checkServer(t, 0)
+	defer server.Close()
+	c := NewHTTP(server.URL, "acme", "key")
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	tests := []struct {
+		permission string
+		user       string
+		want       int
+	}{
+		{"invoices:read", "jane", http.StatusNoContent},
+		{"invoices:write", "jane", http.StatusForbidden},
+		{"invoices:read", "", http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User", tc.user)
+		rec := httptest.NewRecorder()
+		c.Middleware(tc.permission, HeaderIdentity("X-User"))(ok).ServeHTTP(rec, req)
+		assert.Equal(t, tc.want, rec.Code, tc.permission)
+	}
+
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusNoContent) },
+		c.EchoMiddleware("invoices:write", HeaderIdentity("X-User")))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-User", "jane")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Sentinel errors for the statuses returned by cronuseo. Use errors.Is to
+// test an error returned by the client.
+var (
+	ErrInvalidInput     = errors.New("cronuseo: invalid input")
+	ErrUnauthorized     = errors.New("cronuseo: unauthorized")
+	ErrPermissionDenied = errors.New("cronuseo: permission denied")
+	ErrNotFound         = errors.New("cronuseo: not found")
+	ErrAlreadyExists    = errors.New("cronuseo: already exists")
+	ErrRateLimited      = errors.New("cronuseo: rate limited")
+	ErrServer           = errors.New("cronuseo: server error")
+)
+
+// quotaExceeded is the reason of gRPC errors of exceeded quotas, which share
+// the RESOURCE_EXHAUSTED code with rate limits.
+const quotaExceeded = "quota_exceeded"
+
+// Error is an error response from cronuseo.
+type Error struct {
+	// StatusCode is the HTTP status, or the HTTP status matching the gRPC code.
+	StatusCode int
+	Message    string
+	// RetryAfter is the delay sent with rate limited responses, zero when
+	// none was sent.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("cronuseo: %s (%d)", e.Message, e.StatusCode)
+}
+
+// Is matches the sentinel error of the status code.
+func (e *Error) Is(target error) bool {
+
+	switch target {
+	case ErrInvalidInput:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrPermissionDenied:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrAlreadyExists:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// retryable reports whether a failed call can be retried.
+func (e *Error) retryable() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+// parseRetryAfter parses a Retry-After value in seconds.
+func parseRetryAfter(value string) time.Duration {
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// fromGrpc converts a gRPC status error to an *Error, with the retry-after of
+// the response header.
+func fromGrpc(err error, header metadata.MD) error {
+
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	code := http.StatusInternalServerError
+	switch s.Code() {
+	case codes.InvalidArgument:
+		code = http.StatusBadRequest
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.AlreadyExists:
+		code = http.StatusConflict
+	case codes.ResourceExhausted:
+		code = http.StatusTooManyRequests
+		for _, detail := range s.Details() {
+			if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Reason == quotaExceeded {
+				code = http.StatusForbidden
+			}
+		}
+	case codes.Unavailable:
+		code = http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		code = http.StatusGatewayTimeout
+	}
+	apiErr := &Error{StatusCode: code, Message: s.Message()}
+	if values := header.Get("retry-after"); code == http.StatusTooManyRequests && len(values) > 0 {
+		apiErr.RetryAfter = parseRetryAfter(values[0])
+	}
+	return apiErr
+}
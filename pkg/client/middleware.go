@@ -0,0 +1,107 @@
+package client
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// IdentityFunc returns the identifier of the user making a request, or an
+// empty string when the request is not authenticated.
+type IdentityFunc func(r *http.Request) string
+
+// HeaderIdentity reads the user identifier from a request header.
+func HeaderIdentity(header string) IdentityFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(header)
+	}
+}
+
+// splitPermission splits "resource:action" at the first colon, so actions
+// such as "orgs:read" of the system resources are kept whole.
+func splitPermission(permission string) (string, string) {
+
+	resource, action, ok := strings.Cut(permission, ":")
+	if !ok || resource == "" || action == "" {
+		panic("cronuseo client: permission must be resource:action, got " + permission)
+	}
+	return resource, action
+}
+
+// decision is the failure of a checked request.
+type decision struct {
+	code    int
+	message string
+	// retryAfter is the Retry-After header of rate limited requests.
+	retryAfter string
+}
+
+// decide checks the request and returns the HTTP status to fail with, or 0
+// when the request is allowed. Rate limited checks fail with 429, and checks
+// cronuseo refuses, e.g. of unknown users, suspended organizations or
+// exceeded quotas, with 403. Other errors fail with 503.
+func (c *Client) decide(r *http.Request, identify IdentityFunc, resource string, action string) decision {
+
+	identifier := identify(r)
+	if identifier == "" {
+		return decision{code: http.StatusUnauthorized, message: "missing user identity"}
+	}
+	allowed, err := c.Check(r.Context(), identifier, resource, action)
+	var apiErr *Error
+	switch {
+	case err == nil && allowed:
+		return decision{}
+	case err == nil, errors.Is(err, ErrNotFound), errors.Is(err, ErrPermissionDenied), errors.Is(err, ErrInvalidInput):
+		return decision{code: http.StatusForbidden, message: "insufficient permissions"}
+	case errors.As(err, &apiErr) && errors.Is(err, ErrRateLimited):
+		d := decision{code: http.StatusTooManyRequests, message: "too many authorization requests"}
+		if apiErr.RetryAfter > 0 {
+			d.retryAfter = strconv.Itoa(int(math.Ceil(apiErr.RetryAfter.Seconds())))
+		}
+		return d
+	default:
+		return decision{code: http.StatusServiceUnavailable, message: "authorization service unavailable"}
+	}
+}
+
+// Middleware returns net/http middleware allowing only users with the
+// "resource:action" permission. Checks fail closed: when cronuseo cannot be
+// reached the request is rejected with 503, and rate limited checks with 429
+// and the Retry-After of cronuseo.
+func (c *Client) Middleware(permission string, identify IdentityFunc) func(http.Handler) http.Handler {
+
+	resource, action := splitPermission(permission)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if d := c.decide(r, identify, resource, action); d.code != 0 {
+				if d.retryAfter != "" {
+					w.Header().Set("Retry-After", d.retryAfter)
+				}
+				http.Error(w, d.message, d.code)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// EchoMiddleware is Middleware for echo handlers.
+func (c *Client) EchoMiddleware(permission string, identify IdentityFunc) echo.MiddlewareFunc {
+
+	resource, action := splitPermission(permission)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if d := c.decide(ctx.Request(), identify, resource, action); d.code != 0 {
+				if d.retryAfter != "" {
+					ctx.Response().Header().Set("Retry-After", d.retryAfter)
+				}
+				return echo.NewHTTPError(d.code, d.message)
+			}
+			return next(ctx)
+		}
+	}
+}
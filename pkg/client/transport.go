@@ -0,0 +1,99 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/shashimalcse/cronuseo/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// transport performs a single check call.
+type transport interface {
+	check(ctx context.Context, organization string, req CheckRequest) (bool, error)
+}
+
+// httpTransport calls POST /api/v1/o/:org/check.
+type httpTransport struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func (t httpTransport) check(ctx context.Context, organization string, req CheckRequest) (bool, error) {
+
+	body, err := json.Marshal(map[string]string{
+		"identifier": req.Identifier,
+		"resource":   req.Resource,
+		"action":     req.Action,
+	})
+	if err != nil {
+		return false, err
+	}
+	endpoint := strings.TrimSuffix(t.baseURL, "/") + "/api/v1/o/" + url.PathEscape(organization) + "/check"
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("API_KEY", t.apiKey)
+
+	resp, err := t.client.Do(httpReq)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		message := http.StatusText(resp.StatusCode)
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			message = apiErr.Message
+		}
+		return false, &Error{StatusCode: resp.StatusCode, Message: message, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	var result struct {
+		Allowed bool `json:"allowed"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return false, err
+	}
+	return result.Allowed, nil
+}
+
+// grpcTransport calls the Check service of the check server.
+type grpcTransport struct {
+	client proto.CheckClient
+	apiKey string
+}
+
+func (t grpcTransport) check(ctx context.Context, organization string, req CheckRequest) (bool, error) {
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "API_KEY", t.apiKey)
+	var header metadata.MD
+	resp, err := t.client.Check(ctx, &proto.GrpcCheckRequest{
+		Username:     req.Identifier,
+		Resource:     req.Resource,
+		Action:       req.Action,
+		Organization: organization,
+	}, grpc.Header(&header))
+	if err != nil {
+		return false, fromGrpc(err, header)
+	}
+	return resp.Allow, nil
+}
+
+func newGrpcTransport(conn grpc.ClientConnInterface, apiKey string) transport {
+	return grpcTransport{client: proto.NewCheckClient(conn), apiKey: apiKey}
+}